@@ -0,0 +1,61 @@
+package ewf
+
+import (
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/apfs"
+)
+
+// APFSKey is key material for a FileVault-encrypted APFS volume.
+type APFSKey struct {
+	// Password is the login password of a user enabled for FileVault.
+	Password string
+	// RecoveryKey is the volume's personal recovery key, as shown when
+	// FileVault was enabled ("XXXX-XXXX-XXXX-XXXX-XXXX-XXXX").
+	RecoveryKey string
+	// VEK is the 32-byte volume encryption key itself, e.g. recovered from
+	// memory.
+	VEK []byte
+}
+
+// SetAPFSKeys supplies FileVault credentials for the APFS containers of the
+// image. Every APFS filesystem opened afterwards (OpenFileSystem,
+// OpenPartition, OpenFileSystemAt) mounts the volume the keys unlock,
+// preferring the Data volume, and decrypts its metadata and file data on
+// the fly. This covers the software encryption of Macs without a T2 or
+// Apple silicon storage controller; an image of a hardware-encrypted volume
+// holds plaintext and opens without keys.
+//
+// When keys are set but unlock no encrypted volume, opening fails with
+// ErrWrongKey. Without keys an encrypted volume fails with an explicit error
+// asking for credentials. Calling SetAPFSKeys with no keys clears them.
+func (e *EWFImage) SetAPFSKeys(keys ...APFSKey) {
+	var ks []apfs.Key
+	for _, k := range keys {
+		if k.Password != "" {
+			ks = append(ks, apfs.Key{Password: k.Password})
+		}
+		if k.RecoveryKey != "" {
+			ks = append(ks, apfs.Key{Password: k.RecoveryKey})
+		}
+		if len(k.VEK) != 0 {
+			ks = append(ks, apfs.Key{VEK: k.VEK})
+		}
+	}
+	e.mu.Lock()
+	e.apfsKeys = ks
+	e.mu.Unlock()
+}
+
+// applyAPFSKeys hands the image's APFS keys to a freshly built handler.
+func (e *EWFImage) applyAPFSKeys(h filesystem.FileSystem) {
+	a, ok := h.(*apfs.APFS)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	ks := e.apfsKeys
+	e.mu.Unlock()
+	if len(ks) > 0 {
+		a.SetKeys(ks...)
+	}
+}
@@ -0,0 +1,98 @@
+package ewf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// openAPFS wraps an APFS container into an MBR disk image.
+func openAPFS(t *testing.T, c ewffixture.APFSContainer) *EWFImage {
+	t.Helper()
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(c.Build(), 0xAF, 2048), ewffixture.Options{Compress: ewffixture.CompressNone, ShortFinalChunk: true}))
+	parts, err := img.ScanFileSystems()
+	if err != nil || len(parts) != 1 || parts[0].FileSystem != "APFS" {
+		t.Fatalf("ScanFileSystems: %v (%+v)", err, parts)
+	}
+	return img
+}
+
+// readAPFS opens the container's filesystem and reads path.
+func readAPFS(img *EWFImage, path string) ([]byte, error) {
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	return fs.ReadFile(path)
+}
+
+func TestAPFSFileVault(t *testing.T) {
+	secret := bytes.Repeat([]byte("confidential "), 700) // two blocks
+	img := openAPFS(t, ewffixture.APFSContainer{Volumes: []ewffixture.APFSVolume{{
+		Name:        "Macintosh HD - Data",
+		Role:        0x40,
+		Files:       []ewffixture.APFSFile{{Name: "secret.txt", Data: secret}, {Name: "note.txt", Data: []byte("note\n")}},
+		Passwords:   []string{"alice pw", "bob pw"},
+		RecoveryKey: "ABCD-EFGH-IJKL-MNOP-QRST-UVWX",
+	}}})
+
+	if _, err := readAPFS(img, "/secret.txt"); err == nil || errors.Is(err, ErrWrongKey) || !strings.Contains(err.Error(), "FileVault") {
+		t.Errorf("no keys: error = %v, want a request for credentials", err)
+	}
+
+	for name, key := range map[string]APFSKey{
+		"first user":   {Password: "alice pw"},
+		"second user":  {Password: "bob pw"},
+		"recovery key": {RecoveryKey: "ABCD-EFGH-IJKL-MNOP-QRST-UVWX"},
+		"VEK":          {VEK: ewffixture.APFSVEK(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			img.SetAPFSKeys(APFSKey{Password: "wrong"}, key)
+			got, err := readAPFS(img, "/secret.txt")
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if !bytes.Equal(got, secret) {
+				t.Errorf("secret.txt decrypted to %d bytes that differ from the plaintext", len(got))
+			}
+			if got, err := readAPFS(img, "/note.txt"); err != nil || string(got) != "note\n" {
+				t.Errorf("note.txt = %q, %v", got, err)
+			}
+		})
+	}
+
+	for name, key := range map[string]APFSKey{
+		"password":     {Password: "Alice pw"},
+		"recovery key": {RecoveryKey: "ABCD-EFGH-IJKL-MNOP-QRST-UVWY"},
+		"VEK":          {VEK: ewffixture.APFSVEK(1)},
+	} {
+		img.SetAPFSKeys(key)
+		if _, err := readAPFS(img, "/secret.txt"); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("wrong %s: error = %v, want ErrWrongKey", name, err)
+		}
+	}
+}
+
+// With keys the Data volume the keys unlock is mounted; without them the
+// first volume is, as before.
+func TestAPFSFileVaultPrefersDataVolume(t *testing.T) {
+	img := openAPFS(t, ewffixture.APFSContainer{Volumes: []ewffixture.APFSVolume{
+		{Name: "Preboot", Role: 0x10, Files: []ewffixture.APFSFile{{Name: "which.txt", Data: []byte("preboot\n")}}},
+		{Name: "Data", Role: 0x40, Files: []ewffixture.APFSFile{{Name: "which.txt", Data: []byte("data\n")}}, Passwords: []string{"pw"}},
+	}})
+	if got, err := readAPFS(img, "/which.txt"); err != nil || string(got) != "preboot\n" {
+		t.Errorf("no keys: which.txt = %q, %v", got, err)
+	}
+	img.SetAPFSKeys(APFSKey{Password: "pw"})
+	if got, err := readAPFS(img, "/which.txt"); err != nil || string(got) != "data\n" {
+		t.Errorf("with password: which.txt = %q, %v", got, err)
+	}
+	img.SetAPFSKeys()
+	if got, err := readAPFS(img, "/which.txt"); err != nil || string(got) != "preboot\n" {
+		t.Errorf("keys cleared: which.txt = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,123 @@
+package ewf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/laenix/ewfgo/internal/bitlocker"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// BitLockerKey is the key material for UnlockBitLocker. Every field that is
+// set is tried against the protectors of its kind; a volume whose protection
+// is suspended (a clear-key protector) unlocks with an empty BitLockerKey.
+type BitLockerKey struct {
+	// RecoveryPassword is the 48-digit recovery password, with or without
+	// dashes.
+	RecoveryPassword string
+	// Password is the user password.
+	Password string
+	// StartupKey is the content of a startup key (.BEK) file.
+	StartupKey []byte
+	// FVEK is an extracted full-volume encryption key. Its length follows
+	// the volume's method: 16 or 32 bytes for AES-CBC, 32 (key, tweak key)
+	// or 64 (the FVEK entry layout) for AES-CBC with diffuser, 32 or 64 for
+	// AES-XTS.
+	FVEK []byte
+}
+
+// BitLockerProtector is one key protector of a BitLocker volume.
+type BitLockerProtector struct {
+	ID       string // key GUID; a startup key file carries the same GUID
+	Type     string // "Recovery password", "Password", "Startup key", "TPM", ...
+	Modified time.Time
+}
+
+// BitLockerInfo describes a BitLocker volume from its FVE metadata, which is
+// stored in the clear.
+type BitLockerInfo struct {
+	Version       int  // 1 = Windows Vista, 2 = Windows 7 and later
+	ToGo          bool // BitLocker To Go volume header
+	VolumeID      string
+	Method        string // e.g. "AES-XTS 128-bit"
+	Description   string // e.g. "HOST C: 18/10/2026"
+	Created       time.Time
+	EncryptedSize uint64 // bytes encrypted; below the volume size while a conversion is paused
+	Protectors    []BitLockerProtector
+}
+
+// partitionSource returns the sector source and start of a partition: the
+// image itself, or the volume of a virtual partition.
+func (e *EWFImage) partitionSource(part PartitionInfo) (filesystem.Reader, uint64, error) {
+	if e == nil || e.ewf == nil || e.ewf.Filepath() == "" {
+		return nil, 0, fmt.Errorf("no EWF image opened")
+	}
+	if part.volume != nil {
+		return part.volume, 0, nil
+	}
+	return readerAdapter{img: e.ewf}, part.StartSector, nil
+}
+
+// BitLocker reads the FVE metadata of a BitLocker partition: its encryption
+// method and key protectors. No key is needed.
+func (e *EWFImage) BitLocker(part PartitionInfo) (*BitLockerInfo, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return nil, err
+	}
+	m, err := bitlocker.Parse(src, start)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	info := &BitLockerInfo{
+		Version:       m.Version,
+		ToGo:          m.ToGo,
+		VolumeID:      m.VolumeID,
+		Method:        bitlocker.MethodName(m.Method),
+		Description:   m.Description,
+		Created:       m.Created,
+		EncryptedSize: m.EncryptedSize,
+	}
+	for _, p := range m.Protectors {
+		info.Protectors = append(info.Protectors, BitLockerProtector{ID: p.ID, Type: p.TypeName(), Modified: p.Modified})
+	}
+	return info, nil
+}
+
+// UnlockBitLocker decrypts a BitLocker partition with key and returns the
+// plaintext volume as a virtual partition that OpenPartition opens with the
+// NTFS, FAT or exFAT handler. The relocated boot sectors of a Windows 7+
+// volume are read from their encrypted copy, so the returned partition starts
+// with the original boot sector.
+//
+// A key that a protector rejects, or an FVEK that does not decrypt the boot
+// sector, fails with ErrWrongKey; nothing is returned for a volume that did
+// not decrypt. Every read of the returned partition decrypts on the fly.
+func (e *EWFImage) UnlockBitLocker(part PartitionInfo, key BitLockerKey) (PartitionInfo, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return PartitionInfo{}, err
+	}
+	v, err := bitlocker.Open(src, start, part.SizeSectors, bitlocker.Key{
+		RecoveryPassword: key.RecoveryPassword,
+		Password:         key.Password,
+		StartupKey:       key.StartupKey,
+		FVEK:             key.FVEK,
+	})
+	if err != nil {
+		return PartitionInfo{}, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	pi := PartitionInfo{
+		Index:       part.Index,
+		StartSector: part.StartSector,
+		SizeSectors: v.Sectors(),
+		SizeBytes:   v.Sectors() * 512,
+		Type:        "BitLocker",
+		TypeCode:    part.TypeCode,
+		TypeName:    "BitLocker " + bitlocker.MethodName(v.Metadata().Method),
+		FileSystem:  detectVolumeFileSystem(v),
+		Virtual:     true,
+		volume:      v,
+	}
+	return pi, nil
+}
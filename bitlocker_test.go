@@ -0,0 +1,189 @@
+package ewf
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/bitlocker"
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+const (
+	testRecoveryPassword  = "013574-440000-720885-000077-344707-244442-555555-000132"
+	otherRecoveryPassword = "013574-440000-720885-000077-344707-244442-555555-000143"
+)
+
+// fixturePartition returns the single partition of a testdata E01 fixture.
+func fixturePartition(t *testing.T, name string) []byte {
+	t.Helper()
+	src, err := Open(filepath.Join("testdata", "e01", name))
+	if err != nil {
+		t.Fatalf("Open fixture: %v", err)
+	}
+	defer src.Close()
+	parts, err := src.ScanFileSystems()
+	if err != nil || len(parts) == 0 {
+		t.Fatalf("ScanFileSystems(%s): %v", name, err)
+	}
+	data, err := src.ReadSectors(parts[0].StartSector, parts[0].SizeSectors)
+	if err != nil {
+		t.Fatalf("read fixture partition: %v", err)
+	}
+	return data
+}
+
+// openBitLocker wraps an encrypted volume into an MBR disk image and returns
+// the image and its BitLocker partition. Ciphertext does not compress, so
+// the chunks are stored uncompressed as an acquisition tool would.
+func openBitLocker(t *testing.T, vol []byte) (*EWFImage, PartitionInfo) {
+	t.Helper()
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(vol, 0x07, 2048), ewffixture.Options{Compress: ewffixture.CompressNone}))
+	parts, err := img.ScanFileSystems()
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("ScanFileSystems: %v (%d partitions)", err, len(parts))
+	}
+	if parts[0].FileSystem != "BitLocker" {
+		t.Fatalf("encrypted partition detected as %q, want BitLocker", parts[0].FileSystem)
+	}
+	return img, parts[0]
+}
+
+// readUnlocked opens an unlocked partition and returns the content of path.
+func readUnlocked(t *testing.T, img *EWFImage, part PartitionInfo, path string) []byte {
+	t.Helper()
+	fs, err := img.OpenPartition(part)
+	if err != nil {
+		t.Fatalf("OpenPartition(unlocked): %v", err)
+	}
+	defer fs.Close()
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", path, err)
+	}
+	return data
+}
+
+func TestUnlockBitLocker(t *testing.T) {
+	plain := fixturePartition(t, "fat16-encase6-zlib.E01")
+	vol, bek := ewffixture.BitLocker{
+		RecoveryPassword: testRecoveryPassword,
+		Password:         "correct horse",
+		StartupKey:       true,
+		Description:      "EVIDENCE E: 18/10/2026",
+	}.Build(plain)
+	img, part := openBitLocker(t, vol)
+
+	info, err := img.BitLocker(part)
+	if err != nil {
+		t.Fatalf("BitLocker: %v", err)
+	}
+	if info.Version != 2 || info.Method != "AES-XTS 128-bit" || info.Description != "EVIDENCE E: 18/10/2026" {
+		t.Errorf("BitLocker info = %+v", info)
+	}
+	var types []string
+	for _, p := range info.Protectors {
+		types = append(types, p.Type)
+	}
+	if len(types) != 3 || types[0] != "Recovery password" || types[1] != "Password" || types[2] != "Startup key" {
+		t.Errorf("protectors = %v", types)
+	}
+
+	for name, key := range map[string]BitLockerKey{
+		"recovery password": {RecoveryPassword: testRecoveryPassword},
+		"password":          {Password: "correct horse"},
+		"startup key":       {StartupKey: bek},
+		"fvek":              {FVEK: ewffixture.BitLockerFVEK(bitlocker.MethodAES128XTS)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			u, err := img.UnlockBitLocker(part, key)
+			if err != nil {
+				t.Fatalf("UnlockBitLocker: %v", err)
+			}
+			if !u.Virtual || u.FileSystem != "FAT16" || u.SizeSectors != part.SizeSectors {
+				t.Errorf("unlocked partition = %+v", u)
+			}
+			// The relocated boot sector and the data behind the metadata
+			// blocks read back as the original plaintext.
+			got, err := u.volume.ReadSectors(0, u.SizeSectors-0x80*8)
+			if err != nil {
+				t.Fatalf("read decrypted volume: %v", err)
+			}
+			if !bytes.Equal(got, plain[:len(got)]) {
+				t.Fatalf("decrypted volume differs from the plaintext")
+			}
+			if data := readUnlocked(t, img, u, "/FIXTURE.TXT"); string(data) != "fixture\n" {
+				t.Errorf("FIXTURE.TXT = %q", data)
+			}
+		})
+	}
+
+	// Wrong keys fail explicitly.
+	otherBEK := append([]byte(nil), bek...)
+	otherBEK[len(otherBEK)-1] ^= 0xFF
+	for name, key := range map[string]BitLockerKey{
+		"recovery password":           {RecoveryPassword: otherRecoveryPassword},
+		"malformed recovery password": {RecoveryPassword: "123456-123456"},
+		"password":                    {Password: "Correct horse"},
+		"startup key":                 {StartupKey: otherBEK},
+		"fvek":                        {FVEK: bytes.Repeat([]byte{1}, 32)},
+	} {
+		if _, err := img.UnlockBitLocker(part, key); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("%s: UnlockBitLocker error = %v, want ErrWrongKey", name, err)
+		}
+	}
+	// No key material at all, and a key kind without a protector, fail too
+	// but are not a wrong key.
+	if _, err := img.UnlockBitLocker(part, BitLockerKey{}); err == nil || errors.Is(err, ErrWrongKey) {
+		t.Errorf("empty key: error = %v", err)
+	}
+	if _, err := img.OpenPartition(part); err == nil {
+		t.Error("OpenPartition opened the encrypted partition")
+	}
+}
+
+func TestUnlockBitLockerMethods(t *testing.T) {
+	if testing.Short() {
+		t.Skip("encrypts a fixture volume per method")
+	}
+	fat := fixturePartition(t, "fat16-encase6-zlib.E01")
+	ntfs := fixturePartition(t, "ntfs-encase6-zlib.E01")
+	for _, tc := range []struct {
+		name  string
+		plain []byte
+		bl    ewffixture.BitLocker
+		key   BitLockerKey
+		fs    string
+		path  string
+	}{
+		{"cbc-128-diffuser clear key", fat, ewffixture.BitLocker{Method: bitlocker.MethodAES128Diffuser, ClearKey: true}, BitLockerKey{}, "FAT16", "/FIXTURE.TXT"},
+		{"cbc-256-diffuser fvek", fat, ewffixture.BitLocker{Method: bitlocker.MethodAES256Diffuser}, BitLockerKey{FVEK: ewffixture.BitLockerFVEK(bitlocker.MethodAES256Diffuser)}, "FAT16", "/FIXTURE.TXT"},
+		{"cbc-128", fat, ewffixture.BitLocker{Method: bitlocker.MethodAES128CBC, ClearKey: true}, BitLockerKey{}, "FAT16", "/FIXTURE.TXT"},
+		{"cbc-256", ntfs, ewffixture.BitLocker{Method: bitlocker.MethodAES256CBC, ClearKey: true}, BitLockerKey{}, "NTFS", "/fixture.txt"},
+		{"xts-256 paused conversion", ntfs, ewffixture.BitLocker{Method: bitlocker.MethodAES256XTS, ClearKey: true, EncryptedSize: 16 << 20}, BitLockerKey{}, "NTFS", "/fixture.txt"},
+		{"vista cbc-128-diffuser", ntfs, ewffixture.BitLocker{Vista: true, Method: bitlocker.MethodAES128Diffuser, ClearKey: true}, BitLockerKey{}, "NTFS", "/fixture.txt"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vol, _ := tc.bl.Build(tc.plain)
+			img, part := openBitLocker(t, vol)
+			u, err := img.UnlockBitLocker(part, tc.key)
+			if err != nil {
+				t.Fatalf("UnlockBitLocker: %v", err)
+			}
+			if u.FileSystem != tc.fs {
+				t.Errorf("unlocked filesystem = %q, want %q", u.FileSystem, tc.fs)
+			}
+			if data := readUnlocked(t, img, u, tc.path); string(data) != "fixture\n" {
+				t.Errorf("%s = %q", tc.path, data)
+			}
+			if tc.bl.Vista {
+				// Wrong FVEK on Vista: the boot sector is in the clear, so
+				// the check falls to the first $MFT record.
+				if _, err := img.UnlockBitLocker(part, BitLockerKey{FVEK: make([]byte, 32)}); !errors.Is(err, ErrWrongKey) {
+					t.Errorf("wrong FVEK on Vista: error = %v, want ErrWrongKey", err)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	ewf "github.com/laenix/ewfgo"
 )
@@ -24,15 +30,21 @@ func main() {
 		fmt.Println("")
 		fmt.Println("Commands:")
 		fmt.Println("  info     Show disk/partition info (default)")
-		fmt.Println("  parts    List partitions")
+		fmt.Println("  parts    List partitions, unallocated regions and layout anomalies")
 		fmt.Println("  fs       Show filesystem info for each partition")
 		fmt.Println("  ls       List directory (default: root)")
+		fmt.Println("           ls --offset <bytes> [--length <bytes>] [--type <fs>] [path]")
+		fmt.Println("           opens the filesystem at a byte offset instead of a partition")
+		fmt.Println("  usn      Dump the NTFS USN change journal as CSV (default) or JSON lines")
+		fmt.Println("           usn [partition#] [--format csv|json]")
 		fmt.Println("")
 		fmt.Println("Examples:")
 		fmt.Println("  ewftool image.E01 ls")
 		fmt.Println("  ewftool image.E01 ls /")
 		fmt.Println("  ewftool image.E01 ls VIDEO")
 		fmt.Println("  ewftool image.E01 ls VIDEO/00")
+		fmt.Println("  ewftool image.E01 ls --offset 0x100000 /")
+		fmt.Println("  ewftool image.E01 usn 1 --format json")
 		os.Exit(1)
 	}
 
@@ -60,24 +72,41 @@ func main() {
 		showFilesystems(img)
 
 	case "ls":
-		// Optional: partition index and path argument
 		// Usage: ls [partition#] [path]
-		partitionIndex := 0
-		dirPath := ""
-		if len(os.Args) >= 4 {
-			// Check if first arg is a number (partition index)
-			fmt.Sscanf(os.Args[3], "%d", &partitionIndex)
-			if len(os.Args) >= 5 {
-				dirPath = os.Args[4]
+		//        ls --offset <bytes> [--length <bytes>] [--type <fs>] [path]
+		args, at, err := parseOffsetFlags(os.Args[3:])
+		if err != nil {
+			fmt.Println("ls:", err)
+			os.Exit(1)
+		}
+		if at != nil {
+			dirPath := ""
+			if len(args) >= 1 {
+				dirPath = args[0]
 			}
+			listDirectoryAt(img, *at, dirPath)
+			break
+		}
+		partitionIndex, args := parsePartitionIndex(args)
+		dirPath := ""
+		if len(args) >= 1 {
+			dirPath = args[0]
 		}
 		listDirectoryPartition(img, partitionIndex, dirPath)
 		// Try actual file reading too
 		testFileReading(img)
 
+	case "usn":
+		// Usage: usn [partition#] [--format csv|json]
+		//        usn --offset <bytes> [--length <bytes>] [--type <fs>] [--format csv|json]
+		if err := dumpUSN(img, os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, "usn:", err)
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Println("Unknown command:", command)
-		fmt.Println("Available: info, parts, fs, ls")
+		fmt.Println("Available: info, parts, fs, ls, usn")
 		os.Exit(1)
 	}
 }
@@ -148,12 +177,33 @@ func showPartitions(img *ewf.EWFImage) {
 	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
 	fmt.Printf("║ Total Size:  %-45s ║\n", formatSize(disk.TotalSectors))
 	fmt.Printf("║ Block Size: %-45d ║\n", disk.SectorBytes)
+
+	layout, err := img.DiskLayout()
+	if err != nil {
+		fmt.Printf("║ Error: %-48s ║\n", err)
+		fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
+		return
+	}
+	fmt.Printf("║ Scheme:     %-45s ║\n", layout.Scheme)
 	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Partitions: %d                                              ║\n", len(layout.Partitions))
+	for _, p := range layout.Partitions {
+		fmt.Printf("║   %s #%-3d LBA %-12d %-12d sectors %-10s type %s\n",
+			p.Table, p.Slot, p.StartSector, p.SizeSectors, formatSize(p.SizeSectors), p.Type)
+	}
+
+	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Unallocated: %d                                             ║\n", len(layout.Unallocated))
+	for _, r := range layout.Unallocated {
+		fmt.Printf("║   LBA %-12d %-12d sectors %-10s %s\n",
+			r.StartSector, r.SizeSectors, formatSize(r.SizeSectors), r.Kind)
+	}
 
-	// Since full partition table parsing would need more work,
-	// show the basic info we can get
-	fmt.Println("║ Partition detection requires file system parsing          ║")
-	fmt.Println("║ Run: ewftool <file> fs  - to see filesystem info      ║")
+	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Anomalies: %d                                               ║\n", len(layout.Anomalies))
+	for _, a := range layout.Anomalies {
+		fmt.Printf("║   [%s] %s\n", a.Kind, a.Description)
+	}
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 }
 
@@ -195,6 +245,97 @@ func listDirectory(img *ewf.EWFImage, dirPath string) {
 	listDirectoryPartition(img, 0, dirPath)
 }
 
+// offsetVolume is a volume selected with ls --offset instead of a partition.
+type offsetVolume struct {
+	offset, length int64
+	fsType         string
+}
+
+// parseOffsetFlags splits the --offset/--length/--type flags from the
+// positional arguments. Numbers accept a 0x prefix. It returns a nil volume
+// when --offset is absent.
+func parseOffsetFlags(args []string) ([]string, *offsetVolume, error) {
+	var rest []string
+	var vol offsetVolume
+	hasOffset := false
+	for i := 0; i < len(args); i++ {
+		name, value, inline := strings.Cut(args[i], "=")
+		switch name {
+		case "--offset", "--length", "--type":
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+		if !inline {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "--type" {
+			vol.fsType = value
+			continue
+		}
+		n, err := strconv.ParseInt(value, 0, 64)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid %s value %q", name, value)
+		}
+		if name == "--offset" {
+			vol.offset, hasOffset = n, true
+		} else {
+			vol.length = n
+		}
+	}
+	if !hasOffset {
+		if vol.length != 0 || vol.fsType != "" {
+			return nil, nil, fmt.Errorf("--length and --type need --offset")
+		}
+		return rest, nil, nil
+	}
+	return rest, &vol, nil
+}
+
+// parsePartitionIndex takes the optional zero-based partition index (the
+// Index ScanFileSystems reports) off the front of the positional arguments;
+// a first argument that is not a number is left in place and selects the
+// first partition.
+func parsePartitionIndex(args []string) (int, []string) {
+	if len(args) >= 1 {
+		if idx, err := strconv.Atoi(args[0]); err == nil {
+			return idx, args[1:]
+		}
+	}
+	return 0, args
+}
+
+// listDirectoryAt lists a directory of the filesystem at a byte offset.
+func listDirectoryAt(img *ewf.EWFImage, vol offsetVolume, dirPath string) {
+	fmt.Println("╔═══════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                    Root Directory Listing                     ║")
+	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+
+	fs, err := img.OpenFileSystemAt(vol.offset, vol.length, vol.fsType)
+	if err != nil {
+		errStr := err.Error()
+		if len(errStr) > 54 {
+			errStr = errStr[:54]
+		}
+		fmt.Printf("║ Error: %-54s ║\n", errStr)
+		fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer fs.Close()
+
+	fmt.Printf("║ %-61s ║\n", fmt.Sprintf("Volume at byte %d: %s", vol.offset, fs.FSType()))
+	if dirPath != "" {
+		fmt.Printf("║ Directory: %-50s ║\n", dirPath)
+	}
+	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+	printListing(fs, dirPath)
+}
+
 func listDirectoryPartition(img *ewf.EWFImage, partitionIndex int, dirPath string) {
 	fmt.Println("╔═══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║              Root Directory Listing                   ║")
@@ -237,6 +378,11 @@ func listDirectoryPartition(img *ewf.EWFImage, partitionIndex int, dirPath strin
 	}
 	defer fs.Close()
 
+	printListing(fs, dirPath)
+}
+
+// printListing prints up to 20 entries of dirPath and closes the box.
+func printListing(fs *ewf.ImageFS, dirPath string) {
 	entries, err := fs.ListDir(dirPath)
 	if err != nil {
 		errStr := err.Error()
@@ -266,6 +412,155 @@ func listDirectoryPartition(img *ewf.EWFImage, partitionIndex int, dirPath strin
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 }
 
+// usnCSVHeader is the column order of `usn --format csv`.
+var usnCSVHeader = []string{
+	"usn", "time", "name", "path", "entry", "sequence", "parent_entry", "parent_sequence",
+	"reasons", "file_attributes", "source_info", "security_id", "version", "offset", "extents",
+}
+
+// usnJSON is one line of `usn --format json`.
+type usnJSON struct {
+	USN            int64           `json:"usn"`
+	Time           string          `json:"time,omitempty"`
+	Name           string          `json:"name,omitempty"`
+	Path           string          `json:"path,omitempty"`
+	Entry          uint64          `json:"entry"`
+	Sequence       uint16          `json:"sequence"`
+	ParentEntry    uint64          `json:"parent_entry"`
+	ParentSequence uint16          `json:"parent_sequence"`
+	Reasons        string          `json:"reasons"`
+	FileAttributes uint32          `json:"file_attributes"`
+	SourceInfo     uint32          `json:"source_info"`
+	SecurityID     uint32          `json:"security_id"`
+	Version        string          `json:"version"`
+	Offset         int64           `json:"offset"`
+	Extents        []usnExtentJSON `json:"extents,omitempty"`
+}
+
+// usnExtentJSON is one changed range of a V4 record.
+type usnExtentJSON struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// dumpUSN writes the change journal of the partition (or --offset volume)
+// args select to stdout. Records that fail to parse are reported on stderr
+// and skipped.
+func dumpUSN(img *ewf.EWFImage, args []string) error {
+	args, at, err := parseOffsetFlags(args)
+	if err != nil {
+		return err
+	}
+	format := "csv"
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, value, inline := strings.Cut(args[i], "=")
+		if name != "--format" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !inline {
+			if i+1 >= len(args) {
+				return fmt.Errorf("--format needs a value")
+			}
+			i++
+			value = args[i]
+		}
+		format = value
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q (want csv or json)", format)
+	}
+
+	var fs *ewf.ImageFS
+	if at != nil {
+		fs, err = img.OpenFileSystemAt(at.offset, at.length, at.fsType)
+	} else {
+		idx, rest := parsePartitionIndex(rest)
+		if len(rest) > 0 {
+			return fmt.Errorf("unexpected argument %q", rest[0])
+		}
+		fs, err = img.OpenFileSystem(idx)
+	}
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	j, err := fs.OpenUSNJournal()
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var cw *csv.Writer
+	enc := json.NewEncoder(out)
+	if format == "csv" {
+		cw = csv.NewWriter(out)
+		cw.Write(usnCSVHeader)
+	}
+	for {
+		r, err := j.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "usn:", err)
+			continue
+		}
+		row := usnRow(r)
+		if cw != nil {
+			extents := make([]string, len(row.Extents))
+			for i, e := range row.Extents {
+				extents[i] = fmt.Sprintf("%d+%d", e.Offset, e.Length)
+			}
+			cw.Write([]string{
+				strconv.FormatInt(row.USN, 10), row.Time, row.Name, row.Path,
+				strconv.FormatUint(row.Entry, 10), strconv.Itoa(int(row.Sequence)),
+				strconv.FormatUint(row.ParentEntry, 10), strconv.Itoa(int(row.ParentSequence)),
+				row.Reasons, fmt.Sprintf("0x%08X", row.FileAttributes),
+				strconv.FormatUint(uint64(row.SourceInfo), 10), strconv.FormatUint(uint64(row.SecurityID), 10),
+				row.Version, strconv.FormatInt(row.Offset, 10), strings.Join(extents, ";"),
+			})
+		} else if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
+
+// usnRow splits a record's MFT references into entry and sequence numbers and
+// formats its time as RFC 3339 with 100 ns precision.
+func usnRow(r ewf.USNRecord) usnJSON {
+	row := usnJSON{
+		USN:            r.USN,
+		Name:           r.Name,
+		Path:           r.Path,
+		Entry:          r.FileRef & 0x0000FFFFFFFFFFFF,
+		Sequence:       uint16(r.FileRef >> 48),
+		ParentEntry:    r.ParentRef & 0x0000FFFFFFFFFFFF,
+		ParentSequence: uint16(r.ParentRef >> 48),
+		Reasons:        r.Reasons(),
+		FileAttributes: r.FileAttributes,
+		SourceInfo:     r.SourceInfo,
+		SecurityID:     r.SecurityID,
+		Version:        fmt.Sprintf("%d.%d", r.MajorVersion, r.MinorVersion),
+		Offset:         r.Offset,
+	}
+	for _, e := range r.Extents {
+		row.Extents = append(row.Extents, usnExtentJSON{Offset: e.Offset, Length: e.Length})
+	}
+	if !r.Time.IsZero() {
+		row.Time = r.Time.Format("2006-01-02T15:04:05.0000000Z")
+	}
+	return row
+}
+
 func formatBytes(bytes uint64) string {
 	if bytes >= 1024*1024*1024*1024 {
 		return fmt.Sprintf("%.2f TB", float64(bytes)/1024/1024/1024/1024)
@@ -161,3 +161,60 @@ func TestEWFToolVersion(t *testing.T) {
 		t.Errorf("-version: stdout missing version\nstdout:\n%s", res.stdout)
 	}
 }
+
+func TestEWFToolLsOffset(t *testing.T) {
+	// The FAT16 fixture partition starts at LBA 2048 (byte 0x100000).
+	res := runTool(t, fixturePath("fat16-encase6-zlib.E01"), "ls", "--offset", "0x100000", "/")
+	if res.exitCode != 0 {
+		t.Fatalf("ls --offset: exit %d, want 0\nstdout:\n%s\nstderr:\n%s", res.exitCode, res.stdout, res.stderr)
+	}
+	for _, want := range []string{"Volume at byte 1048576: FAT16", "FIXTURE.TXT"} {
+		if !strings.Contains(res.stdout, want) {
+			t.Errorf("ls --offset: stdout missing %q\nstdout:\n%s", want, res.stdout)
+		}
+	}
+
+	res = runTool(t, fixturePath("fat16-encase6-zlib.E01"), "ls", "--offset", "0")
+	if res.exitCode != 1 || !strings.Contains(res.stdout, "Error:") {
+		t.Errorf("ls --offset 0 (MBR, no filesystem): exit %d, want 1 with an error\nstdout:\n%s", res.exitCode, res.stdout)
+	}
+}
+
+func TestEWFToolParts(t *testing.T) {
+	res := runTool(t, fixturePath("fat16-encase6-zlib.E01"), "parts")
+	if res.exitCode != 0 {
+		t.Fatalf("parts: exit %d, want 0\nstdout:\n%s\nstderr:\n%s", res.exitCode, res.stdout, res.stderr)
+	}
+	for _, want := range []string{
+		"Scheme:     MBR",
+		"LBA 2048",
+		"Unallocated: 2",
+		"post-MBR gap",
+		"after last partition",
+		"Anomalies: 0",
+	} {
+		if !strings.Contains(res.stdout, want) {
+			t.Errorf("parts: stdout missing %q\nstdout:\n%s", want, res.stdout)
+		}
+	}
+}
+
+func TestEWFToolUSN(t *testing.T) {
+	// The mkntfs fixture volume was never mounted by Windows, so it has no
+	// $Extend\$UsnJrnl; the command must say so and fail rather than print an
+	// empty journal.
+	res := runTool(t, fixturePath("ntfs-encase6-zlib.E01"), "usn", "--format", "json")
+	if res.exitCode != 1 || !strings.Contains(res.stderr, "not found") || res.stdout != "" {
+		t.Errorf("usn on a volume without a journal: exit %d\nstdout:\n%s\nstderr:\n%s", res.exitCode, res.stdout, res.stderr)
+	}
+
+	res = runTool(t, fixturePath("fat16-encase6-zlib.E01"), "usn")
+	if res.exitCode != 1 || !strings.Contains(res.stderr, "unsupported") {
+		t.Errorf("usn on FAT: exit %d\nstderr:\n%s", res.exitCode, res.stderr)
+	}
+
+	res = runTool(t, fixturePath("ntfs-encase6-zlib.E01"), "usn", "--format", "xml")
+	if res.exitCode != 1 || !strings.Contains(res.stderr, `unknown format "xml"`) {
+		t.Errorf("usn --format xml: exit %d\nstderr:\n%s", res.exitCode, res.stderr)
+	}
+}
@@ -22,10 +22,22 @@ var (
 	// ErrNotFound is returned when a path component or file does not exist.
 	ErrNotFound = filesystem.ErrNotFound
 	// ErrUnsupported is returned when the filesystem or parser path is not
-	// implemented (detection-only filesystems, unimplemented attribute lists).
+	// implemented (detection-only filesystems, unsupported on-disk encodings).
 	ErrUnsupported = filesystem.ErrUnsupported
 	// ErrIsDirectory is returned when a file operation targets a directory.
 	ErrIsDirectory = filesystem.ErrIsDirectory
 	// ErrNotDirectory is returned when a directory operation targets a file.
 	ErrNotDirectory = filesystem.ErrNotDirectory
+	// ErrWrongKey is returned when key material does not unlock an encrypted
+	// volume (UnlockBitLocker, UnlockLUKS, UnlockVeraCrypt, an APFS volume
+	// after SetAPFSKeys).
+	ErrWrongKey = filesystem.ErrWrongKey
+	// ErrReallocated is returned when reading a deleted file (see
+	// ImageFS.WithDeleted) whose clusters now belong to another file;
+	// ImageFS.WithReallocated reads what is left of it.
+	ErrReallocated = filesystem.ErrReallocated
+	// ErrPlaceholder is returned when reading a cloud-files, deduplication
+	// or HSM placeholder whose content is not stored on the volume (see
+	// ImageFS.Reparse).
+	ErrPlaceholder = filesystem.ErrPlaceholder
 )
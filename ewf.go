@@ -2,8 +2,10 @@ package ewf
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/laenix/ewfgo/internal"
+	"github.com/laenix/ewfgo/internal/filesystem/apfs"
 )
 
 // Open opens an EWF image file and parses its metadata.
@@ -57,6 +59,9 @@ func Open(filepath string) (*EWFImage, error) {
 // EWFImage wraps the internal EWFImage and provides exported methods.
 type EWFImage struct {
 	ewf *internal.EWFImage
+
+	mu       sync.Mutex
+	apfsKeys []apfs.Key // FileVault credentials, set by SetAPFSKeys
 }
 
 // Close closes the EWF image file.
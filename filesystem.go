@@ -30,6 +30,7 @@ import (
 
 	"github.com/laenix/ewfgo/internal"
 	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
 
 	// Blank-importing every filesystem subpackage fires each one's init(), which
 	// registers its reader-less factory (the defabrication gate behind
@@ -42,9 +43,14 @@ import (
 	_ "github.com/laenix/ewfgo/internal/filesystem/detect"
 	_ "github.com/laenix/ewfgo/internal/filesystem/exfat"
 	_ "github.com/laenix/ewfgo/internal/filesystem/ext4"
+	_ "github.com/laenix/ewfgo/internal/filesystem/f2fs"
 	_ "github.com/laenix/ewfgo/internal/filesystem/fat"
+	_ "github.com/laenix/ewfgo/internal/filesystem/hfsplus"
 	_ "github.com/laenix/ewfgo/internal/filesystem/ntfs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/refs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/squashfs"
 	_ "github.com/laenix/ewfgo/internal/filesystem/xfs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/zfs"
 )
 
 // FileEntry describes one file or directory inside a partition's filesystem.
@@ -61,6 +67,12 @@ type FileEntry struct {
 	// by handle via ImageFS.OpenInode instead of re-resolving the path. 0 means
 	// the handler exposed no handle (or it is the root).
 	Inode uint64
+	// Deleted marks an entry recovered from unallocated metadata (see
+	// ImageFS.WithDeleted). Reallocated marks a deleted file some of whose
+	// clusters now belong to another file; reading it fails with
+	// ErrReallocated except through ImageFS.WithReallocated.
+	Deleted     bool
+	Reallocated bool
 }
 
 // ImageFS exposes one partition's filesystem through the Evidence method set.
@@ -75,6 +87,13 @@ type ImageFS struct {
 	fs         filesystem.FileSystem
 	sectorSize uint32
 	fsType     filesystem.FileSystemType
+	// src and base locate the partition's sectors: the image itself at the
+	// partition's start sector, or a virtual partition's volume at LBA 0.
+	src  filesystem.Reader
+	base uint64
+	// file is the image file a nested filesystem is read from (see
+	// OpenNestedFileSystem); Close closes it.
+	file io.Closer
 }
 
 // readerAdapter adapts the internal EWF decompressor to filesystem.Reader.
@@ -102,9 +121,12 @@ func (r readerAdapter) ReadSectors(lba uint64, count uint64) ([]byte, error) {
 // through readerAdapter -> internal ReadSectorData for exact decompression).
 // The handler for fsType is looked up in filesystem.NewHandler, populated by
 // the filesystem subpackage init()s (see the blank imports above): fat, ntfs,
-// ext4, xfs, btrfs, apfs and exfat register reader-based constructors, while
-// the detect-only types (HFS+, F2FS, ReFS, ZFS, SquashFS, RAID, BitLocker,
-// LUKS) have no reader-based handler and resolve to an explicit error.
+// ext4, xfs, btrfs, apfs, exfat, hfsplus, refs, f2fs, squashfs and zfs
+// register reader-based constructors, while the detect-only types (RAID,
+// BitLocker, LUKS) have no reader-based handler and resolve to an explicit
+// error. BitLocker, LUKS and VeraCrypt partitions open once UnlockBitLocker,
+// UnlockLUKS or UnlockVeraCrypt has decrypted them; a FileVault-encrypted
+// APFS volume opens once SetAPFSKeys has supplied its credentials.
 //
 // An unsupported filesystem label returns an explicit error; nothing is faked.
 func (e *EWFImage) OpenFileSystem(index int) (*ImageFS, error) {
@@ -134,25 +156,49 @@ func (e *EWFImage) OpenFileSystem(index int) (*ImageFS, error) {
 			return nil, fmt.Errorf("partition index %d not found (image has %d partitions)", index, len(parts))
 		}
 	}
+	return e.OpenPartition(*part)
+}
+
+// OpenPartition opens the filesystem of a partition this image reported —
+// from ScanFileSystems, or from an API that assembles virtual partitions
+// (DynamicVolumes) — with the same handler lookup and guarantees as
+// OpenFileSystem. A virtual partition is read through its volume mapping.
+func (e *EWFImage) OpenPartition(part PartitionInfo) (*ImageFS, error) {
+	return e.openPartition(part, fmt.Sprintf("partition %d", part.Index))
+}
+
+// openPartition opens part's filesystem; name prefixes initialisation errors.
+func (e *EWFImage) openPartition(part PartitionInfo, name string) (*ImageFS, error) {
+	if e == nil || e.ewf == nil || e.ewf.Filepath() == "" {
+		return nil, fmt.Errorf("no EWF image opened")
+	}
 
-	fsType := resolveFSType(*part)
+	fsType := resolveFSType(part)
 	sectorSize := e.SectorSize()
 	if sectorSize == 0 {
 		sectorSize = 512
 	}
-	reader := readerAdapter{img: e.ewf}
 
 	fs := &ImageFS{
 		img:        e,
-		part:       *part,
+		part:       part,
 		sectorSize: sectorSize,
 		fsType:     fsType,
+		src:        readerAdapter{img: e.ewf},
+		base:       part.StartSector,
+	}
+	if part.volume != nil {
+		fs.src, fs.base = part.volume, 0
 	}
 
-	h, err := filesystem.NewHandler(fsType, reader, part.StartSector, part.SizeSectors*uint64(sectorSize))
+	h, err := filesystem.NewHandler(fsType, fs.src, fs.base, part.SizeSectors*uint64(sectorSize))
 	if err != nil {
-		return nil, fmt.Errorf("partition %d: init %s filesystem at sector %d: %w", part.Index, fsType, part.StartSector, err)
+		if part.Virtual {
+			return nil, fmt.Errorf("%s: init %s filesystem on %s volume: %w", name, fsType, part.TypeName, err)
+		}
+		return nil, fmt.Errorf("%s: init %s filesystem at sector %d: %w", name, fsType, part.StartSector, err)
 	}
+	e.applyAPFSKeys(h)
 	fs.fs = h
 
 	// ScanFileSystems never populates PartitionInfo.FilesystemType, so fill it
@@ -161,6 +207,87 @@ func (e *EWFImage) OpenFileSystem(index int) (*ImageFS, error) {
 	return fs, nil
 }
 
+// OpenFileSystemAt opens a filesystem that lives at an arbitrary byte offset
+// of the image — a carved or nested volume, or one found by hex analysis — with
+// the same handler lookup and guarantees as OpenFileSystem. length is the
+// volume size in bytes and must be a whole number of the image's sectors; 0
+// means "to the end of the image". fsType names a registered filesystem in any
+// case ("ntfs" opens NTFS); an empty fsType autodetects the filesystem from
+// the volume's first sectors (the same 129-sector window ScanFileSystems uses).
+//
+// On an image with 512-byte sectors offset need not be sector-aligned. An
+// unaligned volume is read through a byte-offset window and reported as a
+// virtual partition; bytes past the end of the image are never fabricated.
+func (e *EWFImage) OpenFileSystemAt(offset, length int64, fsType string) (*ImageFS, error) {
+	if e == nil || e.ewf == nil || e.ewf.Filepath() == "" {
+		return nil, fmt.Errorf("no EWF image opened")
+	}
+	ss := int64(e.SectorSize())
+	if ss == 0 {
+		ss = 512
+	}
+	imageBytes := int64(e.TotalSectors()) * ss
+	if offset < 0 || offset >= imageBytes {
+		return nil, fmt.Errorf("offset %d outside the %d-byte image", offset, imageBytes)
+	}
+	if length == 0 {
+		length = (imageBytes - offset) / ss * ss
+	}
+	if length <= 0 || length%ss != 0 {
+		return nil, fmt.Errorf("length %d is not a positive whole number of %d-byte sectors", length, ss)
+	}
+	if length > imageBytes-offset {
+		return nil, fmt.Errorf("volume at offset %d of %d bytes exceeds the %d-byte image", offset, length, imageBytes)
+	}
+
+	sectors := uint64(length / ss)
+	part := PartitionInfo{
+		Index:       -1,
+		StartSector: uint64(offset / ss),
+		SizeSectors: sectors,
+		SizeBytes:   uint64(length),
+		Type:        "Offset",
+		TypeName:    fmt.Sprintf("Offset %d", offset),
+		FileSystem:  fsType,
+	}
+	var src filesystem.Reader = readerAdapter{img: e.ewf}
+	base := part.StartSector
+	if offset%ss != 0 {
+		// The window reads 512-byte sectors, so only images with 512-byte
+		// sectors can hold an unaligned volume.
+		if ss != 512 {
+			return nil, fmt.Errorf("offset %d is not aligned to the image's %d-byte sectors", offset, ss)
+		}
+		w, err := volume.NewWindow(src, uint64(offset), sectors)
+		if err != nil {
+			return nil, err
+		}
+		part.Virtual, part.volume = true, w
+		src, base = w, 0
+	}
+	if fsType != "" {
+		t, err := filesystem.HandlerType(fsType)
+		if err != nil {
+			return nil, err
+		}
+		part.FileSystem = string(t)
+	} else {
+		n := uint64(129)
+		if n > sectors {
+			n = sectors
+		}
+		data, err := src.ReadSectors(base, n)
+		if err != nil {
+			return nil, fmt.Errorf("read volume at offset %d: %w", offset, err)
+		}
+		part.FileSystem = DetectFileSystem(data)
+		if part.FileSystem == "Unknown" {
+			return nil, fmt.Errorf("no filesystem detected at offset %d", offset)
+		}
+	}
+	return e.openPartition(part, fmt.Sprintf("volume at byte %d", offset))
+}
+
 // resolveFSType returns the filesystem type to open. ScanFileSystems leaves
 // PartitionInfo.FilesystemType unset and only fills the FileSystem label, so
 // we prefer the typed field (future-proof) and fall back to the label.
@@ -175,7 +302,17 @@ func resolveFSType(part PartitionInfo) filesystem.FileSystemType {
 func (fs *ImageFS) Size() int64 {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	return int64(fs.part.SizeSectors) * int64(fs.sectorSize)
+	return fs.sizeLocked()
+}
+
+// sizeLocked is the partition size in bytes: whole sectors, or the exact
+// byte size of a nested file whose last sector is partial.
+func (fs *ImageFS) sizeLocked() int64 {
+	size := int64(fs.part.SizeSectors) * int64(fs.sectorSize)
+	if b := int64(fs.part.SizeBytes); b > 0 && b < size {
+		return b
+	}
+	return size
 }
 
 // ReadBlock reads raw partition-relative bytes at offset off into p.
@@ -190,7 +327,7 @@ func (fs *ImageFS) ReadBlock(off int64, p []byte) (int, error) {
 	if fs.img == nil {
 		return 0, fmt.Errorf("filesystem closed")
 	}
-	size := int64(fs.part.SizeSectors) * int64(fs.sectorSize)
+	size := fs.sizeLocked()
 	if off < 0 {
 		return 0, fmt.Errorf("negative read offset %d", off)
 	}
@@ -216,11 +353,11 @@ func (fs *ImageFS) ReadBlock(off int64, p []byte) (int, error) {
 	endSector := (off + want + ss - 1) / ss
 	count := endSector - startSector
 
-	raw, err := fs.img.ewf.ReadSectorData(fs.part.StartSector+uint64(startSector), uint64(count))
+	raw, err := fs.src.ReadSectors(fs.base+uint64(startSector), uint64(count))
 	if err != nil {
-		return 0, fmt.Errorf("partition %d: read sectors %d..%d (image LBA %d..%d): %w",
+		return 0, fmt.Errorf("partition %d: read sectors %d..%d (source LBA %d..%d): %w",
 			fs.part.Index, startSector, endSector,
-			fs.part.StartSector+uint64(startSector), fs.part.StartSector+uint64(endSector), err)
+			fs.base+uint64(startSector), fs.base+uint64(endSector), err)
 	}
 	if expected := count * ss; int64(len(raw)) < expected {
 		return 0, fmt.Errorf("partition %d: short decompressed read: got %d bytes, want %d",
@@ -274,12 +411,14 @@ func (fs *ImageFS) ListDir(listingPath string) ([]FileEntry, error) {
 			ino = uint64(e.Cluster)
 		}
 		entries = append(entries, FileEntry{
-			Name:    e.Name,
-			Path:    path.Join(listingPath, e.Name),
-			Size:    int64(e.Size),
-			IsDir:   e.IsDir,
-			ModTime: e.ModTime,
-			Inode:   ino,
+			Name:        e.Name,
+			Path:        path.Join(listingPath, e.Name),
+			Size:        int64(e.Size),
+			IsDir:       e.IsDir,
+			ModTime:     e.ModTime,
+			Inode:       ino,
+			Deleted:     e.Deleted,
+			Reallocated: e.Reallocated,
 		})
 	}
 	return entries, nil
@@ -310,7 +449,8 @@ func (fs *ImageFS) OpenInode(inode uint64, size int64) (io.ReadSeekCloser, error
 	return r, nil
 }
 
-// ReadFile returns the full content of the file at path.
+// ReadFile returns the full content of the file at path. On NTFS, a path
+// ending in ":name" reads the file's alternate data stream name (see Streams).
 func (fs *ImageFS) ReadFile(filePath string) ([]byte, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -336,9 +476,10 @@ func (fs *ImageFS) ReadFile(filePath string) ([]byte, error) {
 // files may be read concurrently, and each handle's ReadAt is safe for
 // concurrent use on that handle.
 //
-// FAT12/16/32, exFAT, NTFS, ext4, XFS, Btrfs and APFS implement streaming
-// today; every other filesystem returns an explicit unsupported error
-// (errors.Is(err, ewf.ErrUnsupported)).
+// FAT12/16/32, exFAT, NTFS, ext2/3/4, XFS, Btrfs, APFS, HFS+, ReFS, F2FS,
+// SquashFS and ZFS implement streaming today; every other filesystem returns an
+// explicit unsupported error (errors.Is(err, ewf.ErrUnsupported)). On NTFS, a
+// path ending in ":name" opens the file's alternate data stream name.
 func (fs *ImageFS) OpenFile(filePath string) (io.ReadSeekCloser, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -397,10 +538,286 @@ func (fs *ImageFS) Close() error {
 		err = fs.fs.Close()
 		fs.fs = nil
 	}
+	if fs.file != nil {
+		if cerr := fs.file.Close(); err == nil {
+			err = cerr
+		}
+		fs.file = nil
+	}
 	fs.img = nil
 	return err
 }
 
+// OpenNestedFileSystem opens a filesystem image stored as a regular file
+// inside this filesystem — a SquashFS root in a firmware partition, a live
+// USB's filesystem image, a disk image copied onto evidence media — with the
+// same handler lookup and guarantees as OpenFileSystem. An empty fsType
+// autodetects the filesystem from the file's first sectors.
+//
+// The nested filesystem reads through this one's streaming OpenFile reader,
+// so this ImageFS must stay open while the nested one is in use. It is
+// reported as a virtual partition (Index -1) whose TypeName names the file.
+// The final partial sector of a file whose size is not a multiple of 512
+// bytes is completed with zeros for the handler, which never reads it as
+// content; Size and ReadBlock stop at the file's last byte.
+func (fs *ImageFS) OpenNestedFileSystem(filePath, fsType string) (*ImageFS, error) {
+	img, rc, vol, err := fs.openFileVolume(filePath)
+	if err != nil {
+		return nil, err
+	}
+	part := PartitionInfo{
+		Index:       -1,
+		SizeSectors: vol.Sectors(),
+		SizeBytes:   uint64(vol.Size()),
+		Type:        "File",
+		TypeName:    "File " + filePath,
+		FileSystem:  fsType,
+		Virtual:     true,
+		volume:      vol,
+	}
+	if fsType == "" {
+		data, err := vol.ReadSectors(0, min(vol.Sectors(), 129))
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("read %q: %w", filePath, err)
+		}
+		part.FileSystem = DetectFileSystem(data)
+		if part.FileSystem == "Unknown" {
+			rc.Close()
+			return nil, fmt.Errorf("no filesystem detected in %q", filePath)
+		}
+	}
+	nested, err := img.openPartition(part, fmt.Sprintf("file %q", filePath))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	nested.file = rc
+	return nested, nil
+}
+
+// openFileVolume opens filePath through OpenFile as a sector volume for a
+// nested filesystem; the caller owns rc and closes it when done with vol.
+func (fs *ImageFS) openFileVolume(filePath string) (*EWFImage, io.ReadSeekCloser, *volume.File, error) {
+	rc, err := fs.OpenFile(filePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fs.mu.Lock()
+	img := fs.img
+	fs.mu.Unlock()
+	if img == nil {
+		rc.Close()
+		return nil, nil, nil, fmt.Errorf("filesystem closed")
+	}
+	size, err := rc.Seek(0, io.SeekEnd)
+	if err != nil {
+		rc.Close()
+		return nil, nil, nil, fmt.Errorf("size of %q: %w", filePath, err)
+	}
+	vol, err := volume.NewFile(rc, size)
+	if err != nil {
+		rc.Close()
+		return nil, nil, nil, fmt.Errorf("%q: %w", filePath, err)
+	}
+	return img, rc, vol, nil
+}
+
+// WithDeleted returns a view of this filesystem whose ListDir, ReadFile and
+// OpenFile also cover deleted files and directories still described by the
+// filesystem's metadata, marked FileEntry.Deleted. On NTFS, deleted MFT
+// records are placed under their parent directory, or under the virtual
+// /$OrphanFiles when that directory is gone or was reused; a deleted file
+// whose clusters $Bitmap shows reallocated is marked Reallocated and reads
+// fail with ErrReallocated (see WithReallocated). Closing the view leaves
+// this ImageFS open. Filesystems without deleted-file recovery return
+// ErrUnsupported.
+func (fs *ImageFS) WithDeleted() (*ImageFS, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	opener, ok := fs.fs.(filesystem.DeletedOpener)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: deleted-file recovery not supported for %s: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	h, err := opener.WithDeleted()
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: deleted-file view: %w", fs.part.Index, err)
+	}
+	return &ImageFS{
+		img:        fs.img,
+		part:       fs.part,
+		fs:         h,
+		sectorSize: fs.sectorSize,
+		fsType:     fs.fsType,
+		src:        fs.src,
+		base:       fs.base,
+	}, nil
+}
+
+// WithReallocated returns a deleted-file view like WithDeleted's in which a
+// deleted file marked Reallocated still reads: the clusters that now belong
+// to another file read as zeros instead of failing the read with
+// ErrReallocated, and Reallocated lists the byte ranges they cover.
+// Filesystems without deleted-file recovery return ErrUnsupported.
+func (fs *ImageFS) WithReallocated() (*ImageFS, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	r, ok := fs.fs.(filesystem.ReallocatedReader)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: deleted-file recovery not supported for %s: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	h, err := r.WithReallocated()
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: reallocated-file view: %w", fs.part.Index, err)
+	}
+	return &ImageFS{
+		img:        fs.img,
+		part:       fs.part,
+		fs:         h,
+		sectorSize: fs.sectorSize,
+		fsType:     fs.fsType,
+		src:        fs.src,
+		base:       fs.base,
+	}, nil
+}
+
+// ByteRange is a span of bytes within a file.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Reallocated returns the byte ranges of the deleted file (or "path:name"
+// stream) at path whose clusters now belong to another file, in file order:
+// the ranges a WithReallocated view reads as zeros. The path must come from
+// a WithDeleted or WithReallocated view; an in-use file has none.
+// Filesystems without deleted-file recovery return ErrUnsupported.
+func (fs *ImageFS) Reallocated(filePath string) ([]ByteRange, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	r, ok := fs.fs.(filesystem.ReallocatedReader)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: deleted-file recovery not supported for %s: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	list, err := r.Reallocated(normalizeInternalPath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: reallocated ranges of %q: %w", fs.part.Index, filePath, err)
+	}
+	out := make([]ByteRange, len(list))
+	for i, br := range list {
+		out[i] = ByteRange{Offset: br.Offset, Length: br.Length}
+	}
+	return out, nil
+}
+
+// Stream describes one named data stream of a file: an NTFS alternate data
+// stream such as Zone.Identifier.
+type Stream struct {
+	Name     string
+	Size     int64
+	Resident bool // stored inside the file's MFT record
+}
+
+// Streams lists the named data streams of the file or directory at path,
+// sorted by name. Each opens with ReadFile or OpenFile as "path:name".
+// Filesystems without named streams return ErrUnsupported.
+func (fs *ImageFS) Streams(filePath string) ([]Stream, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	lister, ok := fs.fs.(filesystem.StreamLister)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no named streams: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	list, err := lister.Streams(normalizeInternalPath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: streams of %q: %w", fs.part.Index, filePath, err)
+	}
+	out := make([]Stream, len(list))
+	for i, s := range list {
+		out[i] = Stream{Name: s.Name, Size: int64(s.Size), Resident: s.Resident}
+	}
+	return out, nil
+}
+
+// Dataset describes one dataset or snapshot of a pooled filesystem (ZFS).
+type Dataset struct {
+	Name       string // "pool/child", or "pool/child@snap" for a snapshot
+	Snapshot   bool
+	GUID       uint64
+	Created    int64  // creation time in Unix seconds
+	Referenced uint64 // bytes referenced
+}
+
+// Datasets lists the datasets and snapshots of a pooled filesystem. The
+// ImageFS OpenFileSystem returns reads the pool's root dataset; the others
+// open with OpenDataset. Filesystems without datasets return ErrUnsupported.
+func (fs *ImageFS) Datasets() ([]Dataset, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	opener, ok := fs.fs.(filesystem.DatasetOpener)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no datasets: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	list, err := opener.Datasets()
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: list datasets: %w", fs.part.Index, err)
+	}
+	out := make([]Dataset, len(list))
+	for i, d := range list {
+		out[i] = Dataset{Name: d.Name, Snapshot: d.Snapshot, GUID: d.GUID, Created: d.Created, Referenced: d.Referenced}
+	}
+	return out, nil
+}
+
+// OpenDataset opens a dataset or snapshot named as Datasets reports it
+// ("tank/home", "tank/home@daily") as its own ImageFS on the same
+// partition. Closing the dataset leaves this ImageFS open.
+func (fs *ImageFS) OpenDataset(name string) (*ImageFS, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	opener, ok := fs.fs.(filesystem.DatasetOpener)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no datasets: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	h, err := opener.OpenDataset(name)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: open dataset %q: %w", fs.part.Index, name, err)
+	}
+	return &ImageFS{
+		img:        fs.img,
+		part:       fs.part,
+		fs:         h,
+		sectorSize: fs.sectorSize,
+		fsType:     fs.fsType,
+		src:        fs.src,
+		base:       fs.base,
+	}, nil
+}
+
 // FSType returns the resolved filesystem type of this ImageFS.
 func (fs *ImageFS) FSType() filesystem.FileSystemType {
 	fs.mu.Lock()
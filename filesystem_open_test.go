@@ -5,7 +5,10 @@ import (
 	"errors"
 	"io"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
 )
 
 // TestImageFSOpenFileStreaming verifies the Evidence bridge's streaming path
@@ -292,3 +295,318 @@ func TestImageFSHashPassthrough(t *testing.T) {
 		t.Error("VerifyImageHash after Close should error")
 	}
 }
+
+// TestOpenFileSystemAt opens the FAT16 fixture volume by byte offset: at its
+// sector-aligned partition start with autodetection, and after relocating it
+// to an unaligned offset on a disk with no partition table, where it must read
+// back through the byte-offset window. Out-of-range and ragged lengths fail.
+func TestOpenFileSystemAt(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+
+	fs, err := img.OpenFileSystemAt(2048*512, 0, "")
+	if err != nil {
+		t.Fatalf("OpenFileSystemAt(aligned, autodetect): %v", err)
+	}
+	if fs.FSType() != "FAT16" {
+		t.Errorf("FSType = %q, want FAT16", fs.FSType())
+	}
+	if got, err := fs.ReadFile("/FIXTURE.TXT"); err != nil || string(got) != "fixture\n" {
+		t.Errorf("aligned ReadFile = %q, %v", got, err)
+	}
+	fs.Close()
+
+	fat, err := img.ReadSectors(2048, 32768)
+	if err != nil {
+		t.Fatalf("read fixture partition: %v", err)
+	}
+	const off = 1<<20 + 200
+	disk := make([]byte, (off+len(fat))/512*512+1024)
+	copy(disk[off:], fat)
+	moved := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	fs, err = moved.OpenFileSystemAt(off, int64(len(fat)), "fat16")
+	if err != nil {
+		t.Fatalf("OpenFileSystemAt(unaligned): %v", err)
+	}
+	if fs.FSType() != "FAT16" {
+		t.Errorf("FSType = %q, want FAT16", fs.FSType())
+	}
+	got, err := fs.ReadFile("/FIXTURE.TXT")
+	fs.Close()
+	if err != nil || string(got) != "fixture\n" {
+		t.Errorf("unaligned ReadFile = %q, %v", got, err)
+	}
+
+	for _, c := range []struct{ off, length int64 }{
+		{off, int64(len(fat)) + 1024}, // past the end of the image
+		{off, 1000},                   // not whole sectors
+		{-1, 0},
+	} {
+		if _, err := moved.OpenFileSystemAt(c.off, c.length, ""); err == nil {
+			t.Errorf("OpenFileSystemAt(%d, %d) succeeded, want an error", c.off, c.length)
+		}
+	}
+	if _, err := moved.OpenFileSystemAt(0, 0, ""); err == nil {
+		t.Errorf("OpenFileSystemAt on zeroed sectors succeeded, want no-filesystem error")
+	}
+	if _, err := moved.OpenFileSystemAt(off, int64(len(fat)), "fat"); err == nil || !strings.Contains(err.Error(), "FAT16, FAT32") {
+		t.Errorf("unknown type: err = %v, want the registered names", err)
+	}
+}
+
+// TestOpenNestedFileSystem opens a SquashFS image stored as a file inside a
+// SquashFS volume, autodetected and by name, and checks that a file that
+// holds no filesystem is refused.
+func TestOpenNestedFileSystem(t *testing.T) {
+	dir := func(children ...*ewffixture.SquashFSNode) *ewffixture.SquashFSNode {
+		return &ewffixture.SquashFSNode{Type: ewffixture.SquashDir, Mode: 0o755, Children: children}
+	}
+	file := func(name string, data []byte) *ewffixture.SquashFSNode {
+		return &ewffixture.SquashFSNode{Name: name, Type: ewffixture.SquashFile, Mode: 0o644, Data: data}
+	}
+	inner := (&ewffixture.SquashFSImage{}).Build(dir(file("inner.txt", []byte("from the nested image\n"))))
+	// Trim to a size that is not a multiple of the sector size.
+	inner = inner[:len(inner)-1000]
+	images := dir(file("root.sqfs", inner), file("notes.txt", bytes.Repeat([]byte("n"), 3000)))
+	images.Name = "images"
+	outer := (&ewffixture.SquashFSImage{}).Build(dir(images))
+	img := openE01(t, ewffixture.WrapDisk(append(outer, make([]byte, 4096)...), ewffixture.Options{}))
+
+	fs, err := img.OpenFileSystemAt(0, int64(len(outer)), "")
+	if err != nil {
+		t.Fatalf("OpenFileSystemAt: %v", err)
+	}
+	defer fs.Close()
+	if fs.FSType() != "SquashFS" {
+		t.Fatalf("FSType = %q, want SquashFS", fs.FSType())
+	}
+	for _, fsType := range []string{"", "SquashFS"} {
+		nested, err := fs.OpenNestedFileSystem("/images/root.sqfs", fsType)
+		if err != nil {
+			t.Fatalf("OpenNestedFileSystem(%q): %v", fsType, err)
+		}
+		got, err := nested.ReadFile("/inner.txt")
+		if err != nil || string(got) != "from the nested image\n" {
+			t.Errorf("nested ReadFile = %q, %v", got, err)
+		}
+		if nested.Size() != int64(len(inner)) {
+			t.Errorf("nested Size = %d, want %d", nested.Size(), len(inner))
+		}
+		tail := make([]byte, 600)
+		if n, err := nested.ReadBlock(int64(len(inner))-100, tail); n != 100 || err != io.EOF ||
+			!bytes.Equal(tail[:100], inner[len(inner)-100:]) {
+			t.Errorf("nested ReadBlock at the tail = %d, %v", n, err)
+		}
+		nested.Close()
+	}
+	if _, err := fs.OpenNestedFileSystem("/images/notes.txt", ""); err == nil {
+		t.Error("OpenNestedFileSystem(notes.txt) succeeded, want an error")
+	}
+	if _, err := fs.OpenNestedFileSystem("/images/missing", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OpenNestedFileSystem(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestOpenZFSDatasets opens a whole-device ZFS pool autodetected in an E01,
+// lists its datasets and reads a child dataset and a snapshot through their
+// own ImageFS.
+func TestOpenZFSDatasets(t *testing.T) {
+	file := func(name, data string) *ewffixture.ZFSNode {
+		return &ewffixture.ZFSNode{Name: name, Mode: 0o100644, Data: []byte(data)}
+	}
+	dir := func(children ...*ewffixture.ZFSNode) *ewffixture.ZFSNode {
+		return &ewffixture.ZFSNode{Mode: 0o040755, Children: children}
+	}
+	pool := (&ewffixture.ZFSPool{Name: "evidence"}).Build(&ewffixture.ZFSDataset{
+		Root:      dir(file("root.txt", "pool root\n")),
+		Snapshots: []*ewffixture.ZFSDataset{{Name: "daily", Root: dir(file("root.txt", "yesterday\n"))}},
+		Children:  []*ewffixture.ZFSDataset{{Name: "home", Root: dir(file("notes.txt", "home dataset\n"))}},
+	})
+	img := openE01(t, ewffixture.WrapDisk(pool, ewffixture.Options{}))
+
+	fs, err := img.OpenFileSystemAt(0, int64(len(pool)), "")
+	if err != nil {
+		t.Fatalf("OpenFileSystemAt: %v", err)
+	}
+	defer fs.Close()
+	if fs.FSType() != "ZFS" {
+		t.Fatalf("FSType = %q, want ZFS", fs.FSType())
+	}
+	if got, err := fs.ReadFile("/root.txt"); err != nil || string(got) != "pool root\n" {
+		t.Errorf("ReadFile(/root.txt) = %q, %v", got, err)
+	}
+	datasets, err := fs.Datasets()
+	if err != nil {
+		t.Fatalf("Datasets: %v", err)
+	}
+	var names []string
+	for _, d := range datasets {
+		names = append(names, d.Name)
+	}
+	if strings.Join(names, ",") != "evidence,evidence@daily,evidence/home" {
+		t.Errorf("Datasets = %v", names)
+	}
+	for name, want := range map[string][2]string{
+		"evidence/home":  {"/notes.txt", "home dataset\n"},
+		"evidence@daily": {"/root.txt", "yesterday\n"},
+	} {
+		ds, err := fs.OpenDataset(name)
+		if err != nil {
+			t.Fatalf("OpenDataset(%s): %v", name, err)
+		}
+		if got, err := ds.ReadFile(want[0]); err != nil || string(got) != want[1] {
+			t.Errorf("%s ReadFile(%s) = %q, %v", name, want[0], got, err)
+		}
+		ds.Close()
+	}
+	if _, err := fs.OpenDataset("evidence/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OpenDataset(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestImageFSStreamsNTFS lists and reads NTFS named streams on a real
+// fixture: mkntfs gives $Secure its $SDS stream and $BadClus its sparse $Bad
+// stream.
+func TestImageFSStreamsNTFS(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "ntfs-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer fs.Close()
+
+	streams, err := fs.Streams("/$BadClus")
+	if err != nil {
+		t.Fatalf("Streams($BadClus): %v", err)
+	}
+	if len(streams) != 1 || streams[0].Name != "$Bad" || streams[0].Resident {
+		t.Errorf("Streams($BadClus) = %+v, want one non-resident $Bad", streams)
+	}
+	if streams, err := fs.Streams("/fixture.txt"); err != nil || len(streams) != 0 {
+		t.Errorf("Streams(fixture.txt) = %+v, %v, want none", streams, err)
+	}
+
+	streams, err = fs.Streams("/$Secure")
+	if err != nil {
+		t.Fatalf("Streams($Secure): %v", err)
+	}
+	var sds *Stream
+	for i := range streams {
+		if streams[i].Name == "$SDS" {
+			sds = &streams[i]
+		}
+	}
+	if sds == nil {
+		t.Fatalf("Streams($Secure) = %+v, want $SDS", streams)
+	}
+	want, err := fs.ReadFile("/$Secure:$SDS")
+	if err != nil {
+		t.Fatalf("ReadFile($Secure:$SDS): %v", err)
+	}
+	if int64(len(want)) != sds.Size {
+		t.Errorf("ReadFile($Secure:$SDS) = %d bytes, Streams reports %d", len(want), sds.Size)
+	}
+	rc, err := fs.OpenFile("/$Secure:$sds:$DATA")
+	if err != nil {
+		t.Fatalf("OpenFile($Secure:$sds:$DATA): %v", err)
+	}
+	defer rc.Close()
+	if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("streamed $SDS: %d bytes, %v", len(got), err)
+	}
+
+	if _, err := fs.ReadFile("/fixture.txt:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadFile of a missing stream = %v, want ErrNotFound", err)
+	}
+	if got, err := fs.ReadFile("/fixture.txt::$DATA"); err != nil || string(got) != "fixture\n" {
+		t.Errorf("ReadFile(fixture.txt::$DATA) = %q, %v", got, err)
+	}
+
+	fat, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fat.Close()
+	ffs, err := fat.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer ffs.Close()
+	if _, err := ffs.Streams("/FIXTURE.TXT"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT Streams = %v, want ErrUnsupported", err)
+	}
+}
+
+// TestImageFSWithDeleted opens the deleted-file view of a real NTFS fixture,
+// which lists the live tree unchanged, and its reallocated-file view, and
+// checks that filesystems without recovery report ErrUnsupported.
+func TestImageFSWithDeleted(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "ntfs-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer fs.Close()
+
+	view, err := fs.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted: %v", err)
+	}
+	entries, err := view.ListDir("/")
+	if err != nil {
+		t.Fatalf("ListDir: %v", err)
+	}
+	found := false
+	for _, e := range entries {
+		if e.Name == "fixture.txt" {
+			found = !e.Deleted && !e.Reallocated
+		}
+	}
+	if !found {
+		t.Errorf("deleted view root = %+v, want live fixture.txt", entries)
+	}
+	if got, err := view.ReadFile("/fixture.txt"); err != nil || string(got) != "fixture\n" {
+		t.Errorf("ReadFile(fixture.txt) = %q, %v", got, err)
+	}
+	view.Close()
+	if _, err := fs.ReadFile("/fixture.txt"); err != nil {
+		t.Errorf("ReadFile after closing the view: %v", err)
+	}
+	view, err = fs.WithReallocated()
+	if err != nil {
+		t.Fatalf("WithReallocated: %v", err)
+	}
+	if lost, err := view.Reallocated("/fixture.txt"); err != nil || len(lost) != 0 {
+		t.Errorf("Reallocated(fixture.txt) = %v, %v, want none for an in-use file", lost, err)
+	}
+	view.Close()
+
+	fat, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fat.Close()
+	ffs, err := fat.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer ffs.Close()
+	if _, err := ffs.WithDeleted(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT WithDeleted = %v, want ErrUnsupported", err)
+	}
+	if _, err := ffs.WithReallocated(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT WithReallocated = %v, want ErrUnsupported", err)
+	}
+}
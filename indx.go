@@ -0,0 +1,90 @@
+package ewf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// IndexEntry is one entry of an NTFS directory's $I30 index. The name,
+// sizes, attribute flags and times are the copy kept in the index, updated
+// less often than the file's MFT record, and for a carved entry the last
+// state of a file that may since have been deleted or renamed. FileRef and
+// ParentRef are MFT references: the record number in the low 48 bits, its
+// sequence number in the high 16.
+type IndexEntry struct {
+	Name          string
+	Namespace     int // 0 POSIX, 1 Win32, 2 DOS (8.3 short), 3 Win32&DOS
+	FileRef       uint64
+	ParentRef     uint64
+	Size          uint64
+	AllocatedSize uint64
+	Flags         uint32 // FILE_ATTRIBUTE_* flags
+	// Times are UTC; zero when the index stores none.
+	Created  time.Time
+	Modified time.Time
+	Changed  time.Time // MFT record change time
+	Accessed time.Time
+	Block    int // INDX block in $INDEX_ALLOCATION; -1 for $INDEX_ROOT
+	Offset   int // byte offset of the entry in its block or root value
+	// Carved marks an entry recovered from index slack or a freed INDX
+	// block; its FileRef is 0 when the entry header was overwritten.
+	Carved bool
+	// Mismatch says how a live entry disagrees with the MFT record it
+	// references (not in use, another sequence number, no such name in
+	// the directory); "" when they agree.
+	Mismatch string
+}
+
+// filetime converts a FILETIME, 100 ns ticks from 1601-01-01, to UTC; zero
+// and negative values are the zero Time.
+func filetime(ft int64) time.Time {
+	if ft <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(ft/10_000_000-11644473600, ft%10_000_000*100).UTC()
+}
+
+// IndexEntries parses the $I30 index of the NTFS directory at path and
+// returns its live entries, each cross-checked against the MFT, followed by
+// entries carved from the slack of its INDX blocks and from blocks no
+// longer in use. A directory without an index returns ErrNotFound;
+// filesystems other than NTFS ErrUnsupported.
+func (fs *ImageFS) IndexEntries(dirPath string) ([]IndexEntry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	lister, ok := fs.fs.(filesystem.IndexLister)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no directory indexes: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	list, err := lister.IndexEntries(normalizeInternalPath(dirPath))
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: index of %q: %w", fs.part.Index, dirPath, err)
+	}
+	out := make([]IndexEntry, len(list))
+	for i, e := range list {
+		out[i] = IndexEntry{
+			Name:          e.Name,
+			Namespace:     int(e.Namespace),
+			FileRef:       e.FileRef,
+			ParentRef:     e.ParentRef,
+			Size:          e.Size,
+			AllocatedSize: e.AllocatedSize,
+			Flags:         e.Flags,
+			Created:       filetime(e.CreateTime),
+			Modified:      filetime(e.ModTime),
+			Changed:       filetime(e.ChangeTime),
+			Accessed:      filetime(e.AccessTime),
+			Block:         e.Block,
+			Offset:        e.Offset,
+			Carved:        e.Carved,
+			Mismatch:      e.Mismatch,
+		}
+	}
+	return out, nil
+}
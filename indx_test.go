@@ -0,0 +1,55 @@
+package ewf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestImageFSIndexEntries: the fixture's root index lives in one INDX
+// block whose entries all agree with the MFT; FAT has no index to parse.
+func TestImageFSIndexEntries(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "ntfs-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer fs.Close()
+	entries, err := fs.IndexEntries("/")
+	if err != nil {
+		t.Fatalf("IndexEntries: %v", err)
+	}
+	found := false
+	for _, e := range entries {
+		if e.Carved || e.Mismatch != "" {
+			t.Errorf("entry %q: carved %v, mismatch %q", e.Name, e.Carved, e.Mismatch)
+		}
+		if e.Name == "fixture.txt" {
+			found = true
+			if e.Block != 0 || e.FileRef&0xFFFFFFFFFFFF != 64 || e.Modified.IsZero() {
+				t.Errorf("fixture.txt = %+v", e)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("fixture.txt not in %+v", entries)
+	}
+
+	fat, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fat.Close()
+	ffs, err := fat.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer ffs.Close()
+	if _, err := ffs.IndexEntries("/"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT IndexEntries = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,268 @@
+package bitlocker
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// stretchRounds is the SHA-256 iteration count of the password key stretch.
+const stretchRounds = 0x100000
+
+// Key is the examiner's key material. Every field that is set is tried; a
+// clear-key protector (protection suspended) unlocks the volume without any.
+type Key struct {
+	// RecoveryPassword is the 48-digit recovery password, with or without
+	// the dashes between its eight groups.
+	RecoveryPassword string
+	// Password is the user password.
+	Password string
+	// StartupKey is the content of a startup key (.BEK) file.
+	StartupKey []byte
+	// FVEK is the full-volume encryption key itself, e.g. recovered from
+	// memory. Its length follows the volume's method: 16 or 32 bytes for
+	// AES-CBC, 32 (key then tweak key) or 64 (the FVEK entry layout, tweak
+	// key at 32) for AES-CBC with diffuser, 32 or 64 for AES-XTS.
+	FVEK []byte
+}
+
+// Unlock recovers the FVEK of the volume from key. A key that a protector of
+// the right kind rejects fails with filesystem.ErrWrongKey; key material for
+// which the volume has no protector fails with a plain error naming the
+// protectors present.
+func (m *Metadata) Unlock(key Key) ([]byte, error) {
+	if key.FVEK != nil {
+		return NormalizeFVEK(m.Method, key.FVEK)
+	}
+
+	var tried []string
+	var errs []error
+	attempt := func(kind uint16, name string, derive func(p Protector) ([]byte, error)) []byte {
+		found := false
+		for _, p := range m.Protectors {
+			if p.Type != kind || p.wrapped == nil {
+				continue
+			}
+			found = true
+			k, err := derive(p)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			vmk, err := unwrap(k, p.wrapped)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s protector %s: %w", name, p.ID, err))
+				continue
+			}
+			return vmk
+		}
+		if !found && kind != ProtectorClearKey {
+			tried = append(tried, name)
+		}
+		return nil
+	}
+
+	var vmk []byte
+	if key.RecoveryPassword != "" {
+		vmk = attempt(ProtectorRecoveryPassword, "recovery password", func(p Protector) ([]byte, error) {
+			return RecoveryPasswordKey(key.RecoveryPassword, p.salt)
+		})
+	}
+	if vmk == nil && key.Password != "" {
+		vmk = attempt(ProtectorPassword, "password", func(p Protector) ([]byte, error) {
+			return PasswordKey(key.Password, p.salt)
+		})
+	}
+	if vmk == nil && key.StartupKey != nil {
+		id, sk, err := ParseStartupKey(key.StartupKey)
+		if err != nil {
+			return nil, err
+		}
+		vmk = attempt(ProtectorStartupKey, "startup key", func(p Protector) ([]byte, error) {
+			if p.ID != id {
+				return nil, fmt.Errorf("startup key %s does not belong to protector %s: %w", id, p.ID, filesystem.ErrWrongKey)
+			}
+			return sk, nil
+		})
+	}
+	if vmk == nil {
+		vmk = attempt(ProtectorClearKey, "clear key", func(p Protector) ([]byte, error) {
+			if p.clear == nil {
+				return nil, fmt.Errorf("clear key protector %s stores no key", p.ID)
+			}
+			return p.clear, nil
+		})
+	}
+	if vmk == nil {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("bitlocker: %w", errors.Join(errs...))
+		}
+		var present []string
+		for _, p := range m.Protectors {
+			present = append(present, p.TypeName())
+		}
+		if len(tried) == 0 {
+			return nil, fmt.Errorf("bitlocker: no key material supplied and no clear key (protectors: %s)", strings.Join(present, ", "))
+		}
+		return nil, fmt.Errorf("bitlocker: the volume has no %s protector (protectors: %s)", strings.Join(tried, " or "), strings.Join(present, ", "))
+	}
+
+	fvek, err := unwrap(vmk, m.fvek)
+	if err != nil {
+		return nil, fmt.Errorf("bitlocker: FVEK: %w", err)
+	}
+	return NormalizeFVEK(m.Method, fvek)
+}
+
+// unwrap decrypts an AES-CCM wrapped key entry with the 256-bit key k and
+// returns the key bytes it holds. A tag mismatch is filesystem.ErrWrongKey.
+func unwrap(k []byte, w *ccmKey) ([]byte, error) {
+	b, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := crypt.OpenCCM(b, w.nonce, w.data[16:], w.data[:16])
+	if errors.Is(err, crypt.ErrAuthentication) {
+		return nil, fmt.Errorf("%w: key does not authenticate", filesystem.ErrWrongKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseEntries(plain)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapped key: %w", err)
+	}
+	if len(entries) == 0 || entries[0].valueType != valueKey || len(entries[0].value) < 4 {
+		return nil, fmt.Errorf("unwrapped data is not a key entry")
+	}
+	return entries[0].value[4:], nil
+}
+
+// NormalizeFVEK checks an FVEK against the method and returns it in the
+// layout NewCipher expects.
+func NormalizeFVEK(method uint16, k []byte) ([]byte, error) {
+	switch method {
+	case MethodAES128CBC:
+		if len(k) >= 16 {
+			return k[:16], nil
+		}
+	case MethodAES256CBC:
+		if len(k) >= 32 {
+			return k[:32], nil
+		}
+	case MethodAES128Diffuser:
+		switch len(k) {
+		case 32:
+			return k, nil
+		case 64:
+			return append(append([]byte(nil), k[:16]...), k[32:48]...), nil
+		}
+	case MethodAES256Diffuser, MethodAES256XTS:
+		if len(k) == 64 {
+			return k, nil
+		}
+	case MethodAES128XTS:
+		if len(k) == 32 {
+			return k, nil
+		}
+	default:
+		return nil, fmt.Errorf("bitlocker: encryption method %s: %w", MethodName(method), filesystem.ErrUnsupported)
+	}
+	return nil, fmt.Errorf("bitlocker: a %d-byte FVEK does not fit %s", len(k), MethodName(method))
+}
+
+// RecoveryPasswordKey derives the AES-256 key a recovery-password protector
+// wraps the VMK with. The password is eight groups of six digits; each group
+// is a multiple of 11 and below 720896, and encodes 16 bits of the 128-bit
+// recovery key as group/11.
+func RecoveryPasswordKey(password string, salt []byte) ([]byte, error) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(password)
+	if len(digits) != 48 {
+		return nil, fmt.Errorf("%w: recovery password must have 48 digits", filesystem.ErrWrongKey)
+	}
+	var key [16]byte
+	for i := 0; i < 8; i++ {
+		var v uint32
+		for _, c := range digits[6*i : 6*i+6] {
+			if c < '0' || c > '9' {
+				return nil, fmt.Errorf("%w: recovery password has a non-digit %q", filesystem.ErrWrongKey, c)
+			}
+			v = v*10 + uint32(c-'0')
+		}
+		if v%11 != 0 || v >= 720896 {
+			return nil, fmt.Errorf("%w: recovery password group %d (%06d) is invalid", filesystem.ErrWrongKey, i+1, v)
+		}
+		binary.LittleEndian.PutUint16(key[2*i:], uint16(v/11))
+	}
+	h := sha256.Sum256(key[:])
+	return stretch(h, salt)
+}
+
+// PasswordKey derives the AES-256 key a password protector wraps the VMK
+// with: the UTF-16LE password hashed twice with SHA-256, then stretched.
+func PasswordKey(password string, salt []byte) ([]byte, error) {
+	u := utf16.Encode([]rune(password))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	h := sha256.Sum256(b)
+	return stretch(sha256.Sum256(h[:]), salt)
+}
+
+// stretch runs the BitLocker key stretch: 0x100000 rounds of SHA-256 over
+// the 88-byte record (last hash, initial hash, salt, round counter).
+func stretch(initial [32]byte, salt []byte) ([]byte, error) {
+	if len(salt) != 16 {
+		return nil, fmt.Errorf("protector has no 16-byte stretch salt")
+	}
+	var rec [88]byte
+	copy(rec[32:64], initial[:])
+	copy(rec[64:80], salt)
+	for i := uint64(0); i < stretchRounds; i++ {
+		binary.LittleEndian.PutUint64(rec[80:], i)
+		h := sha256.Sum256(rec[:])
+		copy(rec[:32], h[:])
+	}
+	return append([]byte(nil), rec[:32]...), nil
+}
+
+// ParseStartupKey reads a startup key (.BEK) file: a 48-byte metadata header
+// followed by an external key entry (key GUID, time, nested key entry). It
+// returns the key GUID, which names the protector the file opens, and the
+// 256-bit key.
+func ParseStartupKey(bek []byte) (string, []byte, error) {
+	if len(bek) < metadataHeaderSize {
+		return "", nil, fmt.Errorf("bitlocker: startup key file of %d bytes is truncated", len(bek))
+	}
+	size := int(binary.LittleEndian.Uint32(bek[0:4]))
+	if size < metadataHeaderSize || size > len(bek) || binary.LittleEndian.Uint32(bek[8:12]) != metadataHeaderSize {
+		return "", nil, fmt.Errorf("bitlocker: not a startup key file")
+	}
+	entries, err := parseEntries(bek[metadataHeaderSize:size])
+	if err != nil {
+		return "", nil, fmt.Errorf("bitlocker: startup key file: %w", err)
+	}
+	for _, e := range entries {
+		if e.typ != entryStartupKey || e.valueType != valueExternalKey || len(e.value) < 24 {
+			continue
+		}
+		nested, err := parseEntries(e.value[24:])
+		if err != nil {
+			return "", nil, fmt.Errorf("bitlocker: startup key file: %w", err)
+		}
+		for _, n := range nested {
+			if n.valueType == valueKey && len(n.value) == 4+32 {
+				return formatGUID(e.value[0:16]), n.value[4:], nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("bitlocker: startup key file holds no external key")
+}
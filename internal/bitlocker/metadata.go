@@ -0,0 +1,433 @@
+// Package bitlocker reads BitLocker Drive Encryption volumes: it parses the
+// FVE metadata, unwraps the volume master key (VMK) and the full-volume
+// encryption key (FVEK) with the examiner's key material, and presents the
+// decrypted volume as a volume.Volume the filesystem handlers open unchanged.
+//
+// Microsoft publishes no specification; the layout follows the public
+// reverse-engineering of the format (libbde, dislocker). All integers are
+// little-endian, GUIDs are in the usual mixed-endian order and times are
+// FILETIMEs.
+//
+// Volume header (sector 0). On Windows 7 and later the boot sector keeps the
+// NTFS BPB with the OEM name replaced by "-FVE-FS-" and the offsets of the
+// three FVE metadata blocks at 176, 184 and 192. BitLocker To Go volumes keep
+// a FAT32 BPB with OEM name "MSWIN4.1" and the same offsets after the
+// identifier GUID 4967d63b-2e29-4ad8-8399-f6a339e3d001 at 160. Windows Vista
+// stores a single metadata block at the cluster held in the NTFS MFT-mirror
+// field (0x38).
+//
+// FVE metadata block. A 64-byte block header, a 48-byte metadata header and
+// a list of entries:
+//
+//	block header    "-FVE-FS-", version (1 Vista, 2 later) at 10, encrypted
+//	                volume size at 16, relocated header sector count at 28,
+//	                the three block offsets at 32, and at 56 the offset of
+//	                the relocated volume header (the original MFT-mirror
+//	                cluster on Vista)
+//	metadata header size at 0, volume GUID at 16, next nonce counter at 32,
+//	                encryption method at 36, creation time at 40
+//	entry           size, entry type, value type, version, value
+//
+// The values used here are a key (method, key bytes), a unicode string, a
+// stretch key (method, 16-byte salt), an AES-CCM encrypted key (FILETIME and
+// counter forming the 12-byte nonce, then the encrypted 16-byte tag and the
+// encrypted key entry), a volume master key (key GUID, modification time,
+// protection type, nested entries) and an external key (key GUID, time,
+// nested entries). The last two nest further entries in the same encoding.
+//
+// A VMK entry exists per key protector; each wraps the same VMK with AES-CCM
+// under a key derived from its protector. The FVEK entry wraps the FVEK under
+// the VMK.
+package bitlocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Signature is the OEM name of a BitLocker volume header and the signature
+// of every FVE metadata block.
+const Signature = "-FVE-FS-"
+
+// toGoGUID identifies a BitLocker To Go volume header.
+var toGoGUID = []byte{0x3B, 0xD6, 0x67, 0x49, 0x29, 0x2E, 0xD8, 0x4A, 0x83, 0x99, 0xF6, 0xA3, 0x39, 0xE3, 0xD0, 0x01}
+
+// Encryption methods (metadata header and key entries).
+const (
+	MethodAES128Diffuser = 0x8000 // AES-CBC 128 with the Elephant diffuser
+	MethodAES256Diffuser = 0x8001 // AES-CBC 256 with the Elephant diffuser
+	MethodAES128CBC      = 0x8002
+	MethodAES256CBC      = 0x8003
+	MethodAES128XTS      = 0x8004
+	MethodAES256XTS      = 0x8005
+)
+
+// Key protector types (the protection field of a VMK entry).
+const (
+	ProtectorClearKey         = 0x0000 // protection suspended: the key is stored in the clear
+	ProtectorTPM              = 0x0100
+	ProtectorStartupKey       = 0x0200 // external key (.BEK file)
+	ProtectorTPMAndPIN        = 0x0500
+	ProtectorRecoveryPassword = 0x0800
+	ProtectorPassword         = 0x2000
+)
+
+// Entry types and value types used from the metadata.
+const (
+	entryProperty    = 0x0000
+	entryVMK         = 0x0002
+	entryFVEK        = 0x0003
+	entryStartupKey  = 0x0006
+	entryDescription = 0x0007
+
+	valueKey           = 0x0001
+	valueUnicode       = 0x0002
+	valueStretchKey    = 0x0003
+	valueAESCCMKey     = 0x0005
+	valueVMK           = 0x0008
+	valueExternalKey   = 0x0009
+	valueOffsetAndSize = 0x000f
+)
+
+const (
+	blockHeaderSize    = 64
+	metadataHeaderSize = 48
+	// maxMetadataSize bounds the metadata read (hostile-input guard); real
+	// metadata is a few KiB inside a 64 KiB block.
+	maxMetadataSize = 1 << 20
+)
+
+// Protector is one key protector (VMK entry) of the volume.
+type Protector struct {
+	ID       string // key GUID
+	Type     uint16 // Protector* constant
+	Modified time.Time
+
+	salt    []byte // stretch-key salt (password, recovery password)
+	clear   []byte // clear key (ProtectorClearKey)
+	wrapped *ccmKey
+}
+
+// TypeName names the protector type.
+func (p Protector) TypeName() string {
+	return ProtectorName(p.Type)
+}
+
+// ProtectorName names a key protector type.
+func ProtectorName(t uint16) string {
+	switch t {
+	case ProtectorClearKey:
+		return "Clear key"
+	case ProtectorTPM:
+		return "TPM"
+	case ProtectorStartupKey:
+		return "Startup key"
+	case ProtectorTPMAndPIN:
+		return "TPM and PIN"
+	case ProtectorRecoveryPassword:
+		return "Recovery password"
+	case ProtectorPassword:
+		return "Password"
+	}
+	return fmt.Sprintf("Unknown (0x%04x)", t)
+}
+
+// MethodName names an encryption method.
+func MethodName(m uint16) string {
+	switch m {
+	case MethodAES128Diffuser:
+		return "AES-CBC 128-bit with diffuser"
+	case MethodAES256Diffuser:
+		return "AES-CBC 256-bit with diffuser"
+	case MethodAES128CBC:
+		return "AES-CBC 128-bit"
+	case MethodAES256CBC:
+		return "AES-CBC 256-bit"
+	case MethodAES128XTS:
+		return "AES-XTS 128-bit"
+	case MethodAES256XTS:
+		return "AES-XTS 256-bit"
+	}
+	return fmt.Sprintf("Unknown (0x%04x)", m)
+}
+
+// Metadata is the parsed FVE metadata of a BitLocker volume.
+type Metadata struct {
+	Version     int    // 1 = Windows Vista, 2 = Windows 7 and later
+	ToGo        bool   // BitLocker To Go (FAT32-style volume header)
+	SectorSize  uint32 // bytes per sector: the encryption unit
+	VolumeID    string // volume GUID
+	Method      uint16 // Method* constant
+	Created     time.Time
+	Description string // e.g. "HOST C: 18/10/2026"
+	Protectors  []Protector
+
+	// EncryptedSize is the number of bytes, from the volume start, that are
+	// encrypted; it is below the volume size while a conversion is paused.
+	EncryptedSize uint64
+	// BlockOffsets are the byte offsets of the FVE metadata blocks (one on
+	// Vista).
+	BlockOffsets []uint64
+	// HeaderOffset and HeaderSectors locate the encrypted copy of the
+	// original first sectors of the volume (Windows 7 and later).
+	HeaderOffset  uint64
+	HeaderSectors uint64
+	// MFTMirror is the original NTFS MFT-mirror cluster a Vista volume header
+	// gave up to point at the metadata.
+	MFTMirror uint64
+
+	fvek *ccmKey
+}
+
+// ccmKey is an AES-CCM wrapped key entry.
+type ccmKey struct {
+	nonce []byte // 12 bytes
+	data  []byte // encrypted tag followed by the encrypted key entry
+}
+
+// entry is one metadata entry.
+type entry struct {
+	typ, valueType uint16
+	value          []byte
+}
+
+// IsVolumeHeader reports whether sector is a BitLocker volume header.
+func IsVolumeHeader(sector []byte) bool {
+	if len(sector) < 512 {
+		return false
+	}
+	if string(sector[3:11]) == Signature {
+		return true
+	}
+	return string(sector[3:11]) == "MSWIN4.1" && bytes.Equal(sector[160:176], toGoGUID)
+}
+
+// Parse reads the volume header and the first valid FVE metadata block of
+// the BitLocker volume at startLBA of r (512-byte sectors).
+func Parse(r filesystem.Reader, startLBA uint64) (*Metadata, error) {
+	hdr, err := r.ReadSectors(startLBA, 1)
+	if err != nil {
+		return nil, fmt.Errorf("bitlocker: read volume header: %w", err)
+	}
+	if !IsVolumeHeader(hdr) {
+		return nil, fmt.Errorf("bitlocker: no BitLocker volume header")
+	}
+	m := &Metadata{
+		SectorSize: uint32(binary.LittleEndian.Uint16(hdr[11:13])),
+		ToGo:       string(hdr[3:11]) != Signature,
+	}
+	if s := m.SectorSize; s < 512 || s > 4096 || s&(s-1) != 0 {
+		return nil, fmt.Errorf("bitlocker: invalid bytes per sector %d", s)
+	}
+
+	var offsets []uint64
+	if lcn := binary.LittleEndian.Uint64(hdr[0x38:0x40]); lcn != 0 && !m.ToGo {
+		// Vista: the MFT-mirror field holds the metadata cluster.
+		spc := uint64(hdr[13])
+		if spc == 0 {
+			return nil, fmt.Errorf("bitlocker: Vista volume header has no cluster size")
+		}
+		offsets = []uint64{lcn * spc * uint64(m.SectorSize)}
+	} else {
+		for i := 0; i < 3; i++ {
+			offsets = append(offsets, binary.LittleEndian.Uint64(hdr[176+8*i:]))
+		}
+	}
+
+	var firstErr error
+	for _, off := range offsets {
+		if off == 0 {
+			continue
+		}
+		err := m.parseBlock(r, startLBA, off)
+		if err == nil {
+			return m, nil
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("metadata block at offset %d: %w", off, err)
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("volume header names no metadata block")
+	}
+	return nil, fmt.Errorf("bitlocker: %w", firstErr)
+}
+
+func (m *Metadata) parseBlock(r filesystem.Reader, startLBA, off uint64) error {
+	head, err := volume.ReadBytes(r, startLBA, off, blockHeaderSize+metadataHeaderSize)
+	if err != nil {
+		return err
+	}
+	if string(head[:8]) != Signature {
+		return fmt.Errorf("bad signature %q", head[:8])
+	}
+	version := int(binary.LittleEndian.Uint16(head[10:12]))
+	if version != 1 && version != 2 {
+		return fmt.Errorf("unsupported metadata version %d", version)
+	}
+	mh := head[blockHeaderSize:]
+	size := uint64(binary.LittleEndian.Uint32(mh[0:4]))
+	if size < metadataHeaderSize || size > maxMetadataSize {
+		return fmt.Errorf("invalid metadata size %d", size)
+	}
+	if hs := binary.LittleEndian.Uint32(mh[8:12]); hs != metadataHeaderSize {
+		return fmt.Errorf("invalid metadata header size %d", hs)
+	}
+	data, err := volume.ReadBytes(r, startLBA, off+blockHeaderSize, size)
+	if err != nil {
+		return err
+	}
+	entries, err := parseEntries(data[metadataHeaderSize:])
+	if err != nil {
+		return err
+	}
+
+	m.Version = version
+	m.VolumeID = formatGUID(mh[16:32])
+	m.Method = binary.LittleEndian.Uint16(mh[36:38])
+	m.Created = filetime(binary.LittleEndian.Uint64(mh[40:48]))
+	m.EncryptedSize = binary.LittleEndian.Uint64(head[16:24])
+	m.BlockOffsets = nil
+	if version == 1 {
+		m.BlockOffsets = []uint64{off}
+		m.MFTMirror = binary.LittleEndian.Uint64(head[56:64])
+	} else {
+		for i := 0; i < 3; i++ {
+			m.BlockOffsets = append(m.BlockOffsets, binary.LittleEndian.Uint64(head[32+8*i:]))
+		}
+		m.HeaderSectors = uint64(binary.LittleEndian.Uint32(head[28:32]))
+		m.HeaderOffset = binary.LittleEndian.Uint64(head[56:64])
+	}
+
+	m.Protectors = nil
+	m.fvek = nil
+	for _, e := range entries {
+		switch {
+		case e.typ == entryVMK && e.valueType == valueVMK:
+			p, err := parseProtector(e.value)
+			if err != nil {
+				return err
+			}
+			m.Protectors = append(m.Protectors, p)
+		case e.typ == entryFVEK && e.valueType == valueAESCCMKey:
+			if m.fvek, err = parseCCMKey(e.value); err != nil {
+				return fmt.Errorf("FVEK entry: %w", err)
+			}
+		case e.typ == entryDescription && e.valueType == valueUnicode:
+			m.Description = utf16String(e.value)
+		case e.typ == entryProperty && e.valueType == valueOffsetAndSize && version == 2 && len(e.value) >= 16 && m.HeaderOffset == 0:
+			// Some writers leave the block header field zero and record the
+			// relocated volume header only as an entry.
+			m.HeaderOffset = binary.LittleEndian.Uint64(e.value[0:8])
+			m.HeaderSectors = binary.LittleEndian.Uint64(e.value[8:16]) / uint64(m.SectorSize)
+		}
+	}
+	if m.fvek == nil {
+		return fmt.Errorf("no FVEK entry")
+	}
+	if version == 2 && (m.HeaderOffset == 0 || m.HeaderSectors == 0) {
+		return fmt.Errorf("no relocated volume header")
+	}
+	return nil
+}
+
+// parseEntries splits a run of metadata entries. A zero-sized entry ends the
+// run.
+func parseEntries(b []byte) ([]entry, error) {
+	var out []entry
+	for len(b) >= 8 {
+		size := int(binary.LittleEndian.Uint16(b[0:2]))
+		if size == 0 {
+			break
+		}
+		if size < 8 || size > len(b) {
+			return nil, fmt.Errorf("metadata entry of %d bytes overruns its %d-byte area", size, len(b))
+		}
+		out = append(out, entry{
+			typ:       binary.LittleEndian.Uint16(b[2:4]),
+			valueType: binary.LittleEndian.Uint16(b[4:6]),
+			value:     b[8:size],
+		})
+		b = b[size:]
+	}
+	return out, nil
+}
+
+func parseProtector(v []byte) (Protector, error) {
+	if len(v) < 28 {
+		return Protector{}, fmt.Errorf("VMK entry of %d bytes is truncated", len(v))
+	}
+	p := Protector{
+		ID:       formatGUID(v[0:16]),
+		Modified: filetime(binary.LittleEndian.Uint64(v[16:24])),
+		Type:     binary.LittleEndian.Uint16(v[26:28]),
+	}
+	nested, err := parseEntries(v[28:])
+	if err != nil {
+		return Protector{}, fmt.Errorf("VMK %s: %w", p.ID, err)
+	}
+	for _, e := range nested {
+		switch e.valueType {
+		case valueStretchKey:
+			if len(e.value) < 20 {
+				return Protector{}, fmt.Errorf("VMK %s: stretch key entry is truncated", p.ID)
+			}
+			p.salt = e.value[4:20]
+		case valueKey:
+			if len(e.value) < 4 {
+				return Protector{}, fmt.Errorf("VMK %s: key entry is truncated", p.ID)
+			}
+			p.clear = e.value[4:]
+		case valueAESCCMKey:
+			if p.wrapped, err = parseCCMKey(e.value); err != nil {
+				return Protector{}, fmt.Errorf("VMK %s: %w", p.ID, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func parseCCMKey(v []byte) (*ccmKey, error) {
+	// nonce (12) + tag (16) + at least a key entry header and method (12)
+	if len(v) < 12+16+12 {
+		return nil, fmt.Errorf("AES-CCM key entry of %d bytes is truncated", len(v))
+	}
+	return &ccmKey{nonce: v[:12], data: v[12:]}, nil
+}
+
+// formatGUID renders an on-disk (mixed-endian) GUID in its canonical
+// lower-case form.
+func formatGUID(g []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+// filetime converts a FILETIME; zero stays the zero time.
+func filetime(ft uint64) time.Time {
+	if ft == 0 || ft > 1<<62 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ft/10_000_000)-11644473600, int64(ft%10_000_000)*100).UTC()
+}
+
+// utf16String decodes a NUL-terminated little-endian UTF-16 string.
+func utf16String(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
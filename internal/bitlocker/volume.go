@@ -0,0 +1,334 @@
+package bitlocker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// metadataRegion is the size of the area each FVE metadata block reserves.
+// It is stored in the clear and presented as is.
+const metadataRegion = 0x10000
+
+// vistaPlainBytes is the leading area of a Vista volume that is never
+// encrypted (the NTFS boot region).
+const vistaPlainBytes = 8192
+
+// Cipher encrypts and decrypts BitLocker sectors. The tweak or IV of a
+// sector is derived from its byte offset from the start of the volume.
+type Cipher struct {
+	method     uint16
+	sectorSize uint64
+	fvek       cipher.Block // AES-CBC key
+	tweak      cipher.Block // diffuser sector-key key
+	xts        *crypt.XTS
+}
+
+// NewCipher builds the sector cipher of method from an FVEK in the layout
+// Unlock returns.
+func NewCipher(method uint16, fvek []byte, sectorSize uint32) (*Cipher, error) {
+	c := &Cipher{method: method, sectorSize: uint64(sectorSize)}
+	var err error
+	switch method {
+	case MethodAES128CBC, MethodAES256CBC:
+		c.fvek, err = aes.NewCipher(fvek)
+	case MethodAES128Diffuser, MethodAES256Diffuser:
+		if c.fvek, err = aes.NewCipher(fvek[:len(fvek)/2]); err == nil {
+			c.tweak, err = aes.NewCipher(fvek[len(fvek)/2:])
+		}
+	case MethodAES128XTS, MethodAES256XTS:
+		c.xts, err = crypt.NewXTS(fvek)
+	default:
+		return nil, fmt.Errorf("bitlocker: encryption method %s: %w", MethodName(method), filesystem.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bitlocker: %s key: %w", MethodName(method), err)
+	}
+	return c, nil
+}
+
+// Decrypt decrypts the sector at byte offset off from src into dst (which
+// may alias src). len(src) is the sector size.
+func (c *Cipher) Decrypt(dst, src []byte, off uint64) {
+	if c.xts != nil {
+		c.xts.Decrypt(dst, src, off/c.sectorSize)
+		return
+	}
+	cipher.NewCBCDecrypter(c.fvek, c.iv(off)).CryptBlocks(dst, src)
+	if c.tweak != nil {
+		words := toWords(dst)
+		diffuserBDecrypt(words)
+		diffuserADecrypt(words)
+		fromWords(dst, words)
+		c.xorSectorKey(dst, off)
+	}
+}
+
+// Encrypt encrypts the sector at byte offset off from src into dst; it is
+// the inverse of Decrypt.
+func (c *Cipher) Encrypt(dst, src []byte, off uint64) {
+	if c.xts != nil {
+		c.xts.Encrypt(dst, src, off/c.sectorSize)
+		return
+	}
+	copy(dst, src)
+	if c.tweak != nil {
+		c.xorSectorKey(dst, off)
+		words := toWords(dst)
+		diffuserAEncrypt(words)
+		diffuserBEncrypt(words)
+		fromWords(dst, words)
+	}
+	cipher.NewCBCEncrypter(c.fvek, c.iv(off)).CryptBlocks(dst, dst)
+}
+
+// iv is the AES-CBC IV of the sector at off: the offset encrypted under the
+// FVEK.
+func (c *Cipher) iv(off uint64) []byte {
+	iv := make([]byte, 16)
+	binary.LittleEndian.PutUint64(iv, off)
+	c.fvek.Encrypt(iv, iv)
+	return iv
+}
+
+// xorSectorKey applies the diffuser's 32-byte sector key: the offset
+// encrypted under the tweak key, twice, the second time with byte 15 set to
+// 0x80.
+func (c *Cipher) xorSectorKey(b []byte, off uint64) {
+	var in [16]byte
+	var key [32]byte
+	binary.LittleEndian.PutUint64(in[:], off)
+	c.tweak.Encrypt(key[:16], in[:])
+	in[15] = 0x80
+	c.tweak.Encrypt(key[16:], in[:])
+	for i := range b {
+		b[i] ^= key[i%32]
+	}
+}
+
+// The Elephant diffuser (Niels Ferguson, "AES-CBC + Elephant diffuser",
+// 2006): two unkeyed, invertible mixing passes over the sector as 32-bit
+// little-endian words.
+var (
+	diffuserARotations = [4]int{9, 0, 13, 0}
+	diffuserBRotations = [4]int{0, 10, 0, 25}
+)
+
+const (
+	diffuserACycles = 5
+	diffuserBCycles = 3
+)
+
+func diffuserADecrypt(d []uint32) {
+	n := len(d)
+	for c := 0; c < diffuserACycles; c++ {
+		for i := 0; i < n; i++ {
+			d[i] += d[(i-2+n)%n] ^ bits.RotateLeft32(d[(i-5+n)%n], diffuserARotations[i%4])
+		}
+	}
+}
+
+func diffuserAEncrypt(d []uint32) {
+	n := len(d)
+	for c := 0; c < diffuserACycles; c++ {
+		for i := n - 1; i >= 0; i-- {
+			d[i] -= d[(i-2+n)%n] ^ bits.RotateLeft32(d[(i-5+n)%n], diffuserARotations[i%4])
+		}
+	}
+}
+
+func diffuserBDecrypt(d []uint32) {
+	n := len(d)
+	for c := 0; c < diffuserBCycles; c++ {
+		for i := 0; i < n; i++ {
+			d[i] += d[(i+2)%n] ^ bits.RotateLeft32(d[(i+5)%n], diffuserBRotations[i%4])
+		}
+	}
+}
+
+func diffuserBEncrypt(d []uint32) {
+	n := len(d)
+	for c := 0; c < diffuserBCycles; c++ {
+		for i := n - 1; i >= 0; i-- {
+			d[i] -= d[(i+2)%n] ^ bits.RotateLeft32(d[(i+5)%n], diffuserBRotations[i%4])
+		}
+	}
+}
+
+func toWords(b []byte) []uint32 {
+	w := make([]uint32, len(b)/4)
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return w
+}
+
+func fromWords(b []byte, w []uint32) {
+	for i, v := range w {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+}
+
+// Volume is the decrypted view of a BitLocker volume: a volume.Volume whose
+// LBA 0 is the first sector of the original (unencrypted) filesystem.
+//
+// Each encryption sector is decrypted with the tweak of the offset it is
+// stored at. On Windows 7 and later the first HeaderSectors sectors are read
+// from their relocated, encrypted copy at HeaderOffset. On Vista the first
+// 8 KiB are stored in the clear and sector 0 gets its NTFS OEM name and
+// MFT-mirror cluster back. The FVE metadata blocks and any area past the
+// encrypted size of a paused conversion are stored in the clear and read
+// unchanged.
+type Volume struct {
+	src      filesystem.Reader
+	startLBA uint64
+	sectors  uint64
+	m        *Metadata
+	c        *Cipher
+}
+
+// NewVolume builds the decrypted view of the sectors-sector BitLocker volume
+// at startLBA of src. A trailing partial encryption sector is not part of
+// the view.
+func NewVolume(src filesystem.Reader, startLBA, sectors uint64, m *Metadata, fvek []byte) (*Volume, error) {
+	c, err := NewCipher(m.Method, fvek, m.SectorSize)
+	if err != nil {
+		return nil, err
+	}
+	per := uint64(m.SectorSize) / 512
+	sectors -= sectors % per
+	if sectors == 0 {
+		return nil, fmt.Errorf("bitlocker: volume is smaller than one sector")
+	}
+	return &Volume{src: src, startLBA: startLBA, sectors: sectors, m: m, c: c}, nil
+}
+
+// Open parses the BitLocker volume at startLBA of src, unlocks it with key
+// and checks that the decrypted volume starts with a boot sector. A
+// directly supplied FVEK that yields no boot sector is filesystem.ErrWrongKey.
+func Open(src filesystem.Reader, startLBA, sectors uint64, key Key) (*Volume, error) {
+	m, err := Parse(src, startLBA)
+	if err != nil {
+		return nil, err
+	}
+	fvek, err := m.Unlock(key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := NewVolume(src, startLBA, sectors, m, fvek)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.verify(); err != nil {
+		if key.FVEK != nil {
+			return nil, fmt.Errorf("bitlocker: %w: %v", filesystem.ErrWrongKey, err)
+		}
+		return nil, fmt.Errorf("bitlocker: %w", err)
+	}
+	return v, nil
+}
+
+// Metadata returns the FVE metadata of the volume.
+func (v *Volume) Metadata() *Metadata { return v.m }
+
+// Sectors returns the volume size in 512-byte sectors.
+func (v *Volume) Sectors() uint64 { return v.sectors }
+
+// verify checks the first decrypted sector that depends on the key: the
+// boot sector, or on Vista (whose boot sector is in the clear) the first
+// $MFT record.
+func (v *Volume) verify() error {
+	boot, err := v.ReadSectors(0, 1)
+	if err != nil {
+		return err
+	}
+	if boot[510] != 0x55 || boot[511] != 0xAA || IsVolumeHeader(boot) {
+		return fmt.Errorf("decrypted volume does not start with a boot sector")
+	}
+	if v.m.Version != 1 {
+		return nil
+	}
+	mft := binary.LittleEndian.Uint64(boot[0x30:0x38]) * uint64(boot[13]) * uint64(binary.LittleEndian.Uint16(boot[11:13]))
+	if mft < vistaPlainBytes || mft/512 >= v.sectors {
+		return fmt.Errorf("decrypted boot sector locates $MFT at invalid offset %d", mft)
+	}
+	rec, err := v.ReadSectors(mft/512, 1)
+	if err != nil {
+		return err
+	}
+	if string(rec[:4]) != "FILE" {
+		return fmt.Errorf("decrypted $MFT record has no FILE signature")
+	}
+	return nil
+}
+
+// ReadSectors implements filesystem.Reader.
+func (v *Volume) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := volume.CheckRange(lba, count, v.sectors); err != nil {
+		return nil, err
+	}
+
+	ss := v.c.sectorSize
+	per := ss / 512
+	first := lba / per * per
+	last := (lba + count + per - 1) / per * per
+	data, err := v.src.ReadSectors(v.startLBA+first, last-first)
+	if err != nil {
+		return nil, fmt.Errorf("bitlocker sector %d: %w", lba, err)
+	}
+	if uint64(len(data)) != (last-first)*512 {
+		return nil, fmt.Errorf("bitlocker sector %d: short read of %d bytes", lba, len(data))
+	}
+	for i := uint64(0); i < uint64(len(data)); i += ss {
+		if err := v.decryptAt(data[i:i+ss], first*512+i); err != nil {
+			return nil, fmt.Errorf("bitlocker sector %d: %w", (first*512+i)/512, err)
+		}
+	}
+	skip := (lba - first) * 512
+	return data[skip : skip+count*512], nil
+}
+
+// decryptAt turns the stored bytes b of the encryption sector at volume
+// offset off into its plaintext.
+func (v *Volume) decryptAt(b []byte, off uint64) error {
+	m := v.m
+	ss := v.c.sectorSize
+	switch {
+	case m.Version == 1 && off < vistaPlainBytes:
+		if off == 0 {
+			copy(b[3:11], "NTFS    ")
+			binary.LittleEndian.PutUint64(b[0x38:0x40], m.MFTMirror)
+		}
+		return nil
+	case m.Version != 1 && off < m.HeaderSectors*ss:
+		src := m.HeaderOffset + off
+		stored, err := volume.ReadBytes(v.src, v.startLBA, src, ss)
+		if err != nil {
+			return fmt.Errorf("relocated header at offset %d: %w", src, err)
+		}
+		v.c.Decrypt(b, stored, src)
+		return nil
+	case v.inMetadata(off):
+		return nil
+	case m.EncryptedSize != 0 && off >= m.EncryptedSize:
+		return nil
+	}
+	v.c.Decrypt(b, b, off)
+	return nil
+}
+
+// inMetadata reports whether off lies in an FVE metadata block.
+func (v *Volume) inMetadata(off uint64) bool {
+	for _, b := range v.m.BlockOffsets {
+		if b != 0 && off >= b && off < b+metadataRegion {
+			return true
+		}
+	}
+	return false
+}
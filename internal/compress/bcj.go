@@ -0,0 +1,234 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The branch/call/jump (BCJ) filters rewrite the relative branch targets
+// that the encoder turned into absolute ones back into relative form. Each
+// decoder below is a port of the matching xz filter, run over a whole block
+// at once with the stream position starting at the filter's start offset.
+
+// checkXZFilter validates a non-last filter of an xz block.
+func checkXZFilter(f xzFilter) error {
+	switch f.id {
+	case xzFilterDelta:
+		if len(f.props) != 1 {
+			return fmt.Errorf("xz: bad delta filter properties: %w", ErrCorrupt)
+		}
+	case xzFilterX86, xzFilterPowerPC, xzFilterIA64, xzFilterARM, xzFilterARMThumb, xzFilterSPARC, xzFilterARM64:
+		if len(f.props) != 0 && len(f.props) != 4 {
+			return fmt.Errorf("xz: bad BCJ filter properties: %w", ErrCorrupt)
+		}
+	case xzFilterLZMA2:
+		return fmt.Errorf("xz: LZMA2 filter is not last in the chain: %w", ErrCorrupt)
+	default:
+		return fmt.Errorf("xz: unsupported filter 0x%X", f.id)
+	}
+	return nil
+}
+
+// applyXZFilter undoes a validated filter in place over a block's data.
+func applyXZFilter(f xzFilter, buf []byte) {
+	if f.id == xzFilterDelta {
+		dist := int(f.props[0]) + 1
+		for i := dist; i < len(buf); i++ {
+			buf[i] += buf[i-dist]
+		}
+		return
+	}
+	var start uint32
+	if len(f.props) == 4 {
+		start = binary.LittleEndian.Uint32(f.props)
+	}
+	switch f.id {
+	case xzFilterX86:
+		bcjX86(buf, start)
+	case xzFilterPowerPC:
+		bcjPowerPC(buf, start)
+	case xzFilterIA64:
+		bcjIA64(buf, start)
+	case xzFilterARM:
+		bcjARM(buf, start)
+	case xzFilterARMThumb:
+		bcjARMThumb(buf, start)
+	case xzFilterSPARC:
+		bcjSPARC(buf, start)
+	case xzFilterARM64:
+		bcjARM64(buf, start)
+	}
+}
+
+func bcjX86(buf []byte, pos uint32) {
+	allowed := [8]bool{true, true, true, false, true, false, false, false}
+	bitNum := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+	msb := func(b byte) bool { return b == 0x00 || b == 0xFF }
+	if len(buf) <= 4 {
+		return
+	}
+	size := len(buf) - 4
+	prevPos := -1
+	prevMask := uint32(0)
+	for i := 0; i < size; i++ {
+		if buf[i]&0xFE != 0xE8 {
+			continue
+		}
+		d := i - prevPos
+		if d > 3 {
+			prevMask = 0
+		} else {
+			prevMask = (prevMask << (d - 1)) & 7
+			if prevMask != 0 {
+				b := buf[i+4-int(bitNum[prevMask])]
+				if !allowed[prevMask] || msb(b) {
+					prevPos = i
+					prevMask = prevMask<<1 | 1
+					continue
+				}
+			}
+		}
+		prevPos = i
+		if !msb(buf[i+4]) {
+			prevMask = prevMask<<1 | 1
+			continue
+		}
+		src := binary.LittleEndian.Uint32(buf[i+1:])
+		var dest uint32
+		for {
+			dest = src - (pos + uint32(i) + 5)
+			if prevMask == 0 {
+				break
+			}
+			j := bitNum[prevMask] * 8
+			if !msb(byte(dest >> (24 - j))) {
+				break
+			}
+			src = dest ^ (1<<(32-j) - 1)
+		}
+		dest &= 0x01FFFFFF
+		dest |= 0 - dest&0x01000000
+		binary.LittleEndian.PutUint32(buf[i+1:], dest)
+		i += 4
+	}
+}
+
+func bcjPowerPC(buf []byte, pos uint32) {
+	for i := 0; i+4 <= len(buf); i += 4 {
+		instr := binary.BigEndian.Uint32(buf[i:])
+		if instr&0xFC000003 == 0x48000001 {
+			instr &= 0x03FFFFFC
+			instr -= pos + uint32(i)
+			instr &= 0x03FFFFFC
+			instr |= 0x48000001
+			binary.BigEndian.PutUint32(buf[i:], instr)
+		}
+	}
+}
+
+func bcjIA64(buf []byte, pos uint32) {
+	branch := [32]uint32{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		4, 4, 6, 6, 0, 0, 7, 7,
+		4, 4, 0, 0, 4, 4, 0, 0,
+	}
+	for i := 0; i+16 <= len(buf); i += 16 {
+		mask := branch[buf[i]&0x1F]
+		for slot, bitPos := uint32(0), uint32(5); slot < 3; slot, bitPos = slot+1, bitPos+41 {
+			if (mask>>slot)&1 == 0 {
+				continue
+			}
+			bytePos := int(bitPos >> 3)
+			bitRes := bitPos & 7
+			var instr uint64
+			for j := 0; j < 6; j++ {
+				instr |= uint64(buf[i+j+bytePos]) << (8 * j)
+			}
+			norm := instr >> bitRes
+			if (norm>>37)&0x0F != 0x05 || (norm>>9)&0x07 != 0 {
+				continue
+			}
+			addr := uint32(norm>>13) & 0x0FFFFF
+			addr |= uint32(norm>>36) & 1 << 20
+			addr <<= 4
+			addr -= pos + uint32(i)
+			addr >>= 4
+			norm &^= uint64(0x8FFFFF) << 13
+			norm |= uint64(addr&0x0FFFFF) << 13
+			norm |= uint64(addr&0x100000) << (36 - 20)
+			instr &= 1<<bitRes - 1
+			instr |= norm << bitRes
+			for j := 0; j < 6; j++ {
+				buf[i+j+bytePos] = byte(instr >> (8 * j))
+			}
+		}
+	}
+}
+
+func bcjARM(buf []byte, pos uint32) {
+	for i := 0; i+4 <= len(buf); i += 4 {
+		if buf[i+3] != 0xEB {
+			continue
+		}
+		addr := uint32(buf[i]) | uint32(buf[i+1])<<8 | uint32(buf[i+2])<<16
+		addr <<= 2
+		addr -= pos + uint32(i) + 8
+		addr >>= 2
+		buf[i], buf[i+1], buf[i+2] = byte(addr), byte(addr>>8), byte(addr>>16)
+	}
+}
+
+func bcjARMThumb(buf []byte, pos uint32) {
+	for i := 0; i+4 <= len(buf); i += 2 {
+		if buf[i+1]&0xF8 != 0xF0 || buf[i+3]&0xF8 != 0xF8 {
+			continue
+		}
+		addr := uint32(buf[i+1]&7)<<19 | uint32(buf[i])<<11 | uint32(buf[i+3]&7)<<8 | uint32(buf[i+2])
+		addr <<= 1
+		addr -= pos + uint32(i) + 4
+		addr >>= 1
+		buf[i+1] = byte(0xF0 | (addr>>19)&7)
+		buf[i] = byte(addr >> 11)
+		buf[i+3] = byte(0xF8 | (addr>>8)&7)
+		buf[i+2] = byte(addr)
+		i += 2
+	}
+}
+
+func bcjSPARC(buf []byte, pos uint32) {
+	for i := 0; i+4 <= len(buf); i += 4 {
+		instr := binary.BigEndian.Uint32(buf[i:])
+		if instr>>22 != 0x100 && instr>>22 != 0x1FF {
+			continue
+		}
+		instr <<= 2
+		instr -= pos + uint32(i)
+		instr >>= 2
+		instr = (0x40000000 - instr&0x400000) | 0x40000000 | instr&0x3FFFFF
+		binary.BigEndian.PutUint32(buf[i:], instr)
+	}
+}
+
+func bcjARM64(buf []byte, pos uint32) {
+	for i := 0; i+4 <= len(buf); i += 4 {
+		pc := pos + uint32(i)
+		instr := binary.LittleEndian.Uint32(buf[i:])
+		switch {
+		case instr>>26 == 0x25: // BL
+			addr := instr - pc>>2
+			binary.LittleEndian.PutUint32(buf[i:], 0x94000000|addr&0x03FFFFFF)
+		case instr&0x9F000000 == 0x90000000: // ADRP
+			addr := (instr>>29)&3 | (instr>>3)&0x1FFFFC
+			if (addr+0x020000)&0x1C0000 != 0 {
+				continue
+			}
+			addr -= pc >> 12
+			instr &= 0x9000001F
+			instr |= (addr & 3) << 29
+			instr |= (addr & 0x03FFFC) << 3
+			instr |= (0 - addr&0x020000) & 0xE00000
+			binary.LittleEndian.PutUint32(buf[i:], instr)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package compress holds pure-Go decoders for the block compressors found
+// inside filesystem images: LZ4 and LZO1X raw blocks, legacy LZMA ("alone")
+// and XZ streams, Zstandard frames, the ZFS-specific LZJB and ZLE blocks,
+// and the Windows formats of NTFS compressed and WOF files: LZNT1, XPRESS
+// Huffman and LZX.
+//
+// Every decoder works on one whole compressed block and takes the largest
+// output it may produce; exceeding that limit is an error rather than a
+// reason to grow the buffer, so a corrupt block cannot balloon memory.
+// Decoders never return partial output together with a nil error.
+package compress
+
+import "errors"
+
+// ErrCorrupt is wrapped by every error caused by malformed input.
+var ErrCorrupt = errors.New("corrupt compressed data")
+
+// ErrOutputLimit is wrapped when a block decodes to more than the caller's
+// limit.
+var ErrOutputLimit = errors.New("decompressed data exceeds limit")
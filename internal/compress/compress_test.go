@@ -0,0 +1,363 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// The vectors in testdata were produced by the reference tools from
+// plain.txt (the first 16 KiB of the README):
+//
+//	xz --format=lzma                        plain.lzma
+//	xz --check=crc32                        plain-crc32.xz
+//	xz --check=sha256 -9e                   plain-sha256.xz
+//	xz --check=crc64 --delta=dist=4         plain-delta.xz
+//	zstd -19 --check / -1 -B4096 / --ultra -22
+//	lz4 -B4 -BI (the single block, frame stripped)
+//
+// and bcj-<arch>.xz with xz --<arch> --lzma2=preset=1 over lcgBytes(4096).
+
+func readVector(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// lcgBytes is the pseudo-random input of the BCJ vectors: dense enough in
+// branch-like byte patterns that every filter rewrites some of them.
+func lcgBytes(n int) []byte {
+	out := make([]byte, n)
+	x := uint32(12345)
+	for i := range out {
+		x = (x*1103515245 + 12345) & 0x7FFFFFFF
+		out[i] = byte(x >> 16)
+	}
+	return out
+}
+
+func TestDecodersMatchReferenceTools(t *testing.T) {
+	plain := readVector(t, "plain.txt")
+	for _, c := range []struct {
+		file string
+		fn   func([]byte, int) ([]byte, error)
+	}{
+		{"plain.lzma", LZMA},
+		{"plain-crc32.xz", XZ},
+		{"plain-sha256.xz", XZ},
+		{"plain-delta.xz", XZ},
+		{"plain-19.zst", Zstd},
+		{"plain-b4k.zst", Zstd},
+		{"plain-22.zst", Zstd},
+		{"plain.lz4block", LZ4Block},
+	} {
+		got, err := c.fn(readVector(t, c.file), len(plain))
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: decoded %d bytes that differ from plain.txt", c.file, len(got))
+		}
+	}
+}
+
+func TestXZBranchFilters(t *testing.T) {
+	want := lcgBytes(4096)
+	for _, arch := range []string{"x86", "powerpc", "ia64", "arm", "armthumb", "arm64", "sparc"} {
+		got, err := XZ(readVector(t, "bcj-"+arch+".xz"), len(want))
+		if err != nil {
+			t.Errorf("%s: %v", arch, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: output differs from the filter input", arch)
+		}
+	}
+}
+
+func TestDecodersRejectDamage(t *testing.T) {
+	plain := readVector(t, "plain.txt")
+	for _, c := range []struct {
+		file string
+		at   int
+		fn   func([]byte, int) ([]byte, error)
+	}{
+		{"plain-crc32.xz", 2000, XZ}, // caught by the block CRC32 at the latest
+		{"plain-19.zst", 3000, Zstd}, // caught by the content checksum at the latest
+		{"plain-sha256.xz", 6, XZ},   // stream flags
+	} {
+		src := readVector(t, c.file)
+		src[c.at] ^= 0x55
+		if got, err := c.fn(src, len(plain)); err == nil {
+			t.Errorf("%s with byte %d flipped decoded %d bytes without error", c.file, c.at, len(got))
+		}
+	}
+	for _, c := range []struct {
+		file string
+		fn   func([]byte, int) ([]byte, error)
+	}{
+		{"plain.lzma", LZMA},
+		{"plain-crc32.xz", XZ},
+		{"plain-19.zst", Zstd},
+		{"plain.lz4block", LZ4Block},
+	} {
+		if _, err := c.fn(readVector(t, c.file), len(plain)-1); !errors.Is(err, ErrOutputLimit) {
+			t.Errorf("%s with a short limit: err = %v, want ErrOutputLimit", c.file, err)
+		}
+		if c.file == "plain.lz4block" {
+			continue // a raw LZ4 block has no end mark; only the caller's size check catches truncation
+		}
+		src := readVector(t, c.file)
+		if _, err := c.fn(src[:len(src)/2], len(plain)); err == nil {
+			t.Errorf("%s truncated: no error", c.file)
+		}
+	}
+}
+
+func TestZstdRejectsDictionaryFrames(t *testing.T) {
+	// Frame header with a one-byte dictionary ID, then an empty raw block.
+	src := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x21, 0x07, 0x00, 0x01, 0x00, 0x00}
+	if _, err := Zstd(src, 16); err == nil || errors.Is(err, ErrCorrupt) {
+		t.Fatalf("dictionary frame: err = %v, want an unsupported-dictionary error", err)
+	}
+}
+
+// lzoVector assembles an LZO1X stream instruction by instruction, tracking
+// the bytes each one must produce.
+type lzoVector struct {
+	src, want []byte
+}
+
+func (v *lzoVector) emit(b ...byte)   { v.src = append(v.src, b...) }
+func (v *lzoVector) lit(b []byte)     { v.src = append(v.src, b...); v.want = append(v.want, b...) }
+func (v *lzoVector) copy(dist, n int) { v.want = copyMatch(v.want, dist, n) }
+
+func TestLZO1X(t *testing.T) {
+	var v lzoVector
+	v.emit(17 + 5) // initial literal run of 5
+	v.lit([]byte("hello"))
+	v.emit(0x80|4<<2|2, 0) // M2: length 5, distance 5, then 2 literals
+	v.copy(5, 5)
+	v.lit([]byte("XY"))
+	v.emit(2<<2, 0) // M1 after literals: length 2, distance 3
+	v.copy(3, 2)
+	v.emit(0, 0, 0x1B) // long literal run: 18 + 255 + 27 = 300
+	v.lit(bytes.Repeat([]byte("0123456789"), 30))
+	v.emit(0x20, 7, 99<<2&0xFF|3, 99>>6) // M3: length 33 + 7, distance 100, then 3 literals
+	v.copy(100, 40)
+	v.lit([]byte("abc"))
+	v.emit(0x20|8, 19<<2, 0) // M3: length 10, distance 20
+	v.copy(20, 10)
+	run := lcgBytes(16400)
+	v.emit(0) // literal run of 18 + 64*255 + 62
+	for n := 16400 - 18; n > 255; n -= 255 {
+		v.emit(0)
+	}
+	v.emit(byte((16400 - 18) % 255))
+	v.lit(run)
+	v.emit(3<<2|1, 0x3F) // M1 after a long run: length 3, distance 0x801 + 3 + 0xFC, then 1 literal
+	v.copy(0x900, 3)
+	v.lit([]byte("Z"))
+	v.emit(0x10|7, 0x123<<2&0xFF, 0x123>>6) // M4: length 9, distance 0x4000 + 0x123
+	v.copy(0x4123, 9)
+	v.emit(0x10, 11, 1<<2, 0) // M4: length 9 + 11, distance 0x4001
+	v.copy(0x4001, 20)
+	v.emit(0x11, 0, 0) // end of stream
+
+	got, err := LZO1X(v.src, len(v.want))
+	if err != nil {
+		t.Fatalf("LZO1X: %v", err)
+	}
+	if !bytes.Equal(got, v.want) {
+		t.Fatalf("LZO1X decoded %d bytes that differ from the %d expected", len(got), len(v.want))
+	}
+
+	// A short initial literal run hands straight over to a match.
+	if got, err := LZO1X([]byte{17 + 2, 'a', 'b', 0x11, 0, 0}, 16); err != nil || string(got) != "ab" {
+		t.Fatalf("short stream = %q, %v; want \"ab\"", got, err)
+	}
+	if _, err := LZO1X(v.src, len(v.want)-1); !errors.Is(err, ErrOutputLimit) {
+		t.Fatalf("short limit: err = %v, want ErrOutputLimit", err)
+	}
+	if _, err := LZO1X(append(v.src[:len(v.src):len(v.src)], 0), len(v.want)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("trailing byte: err = %v, want ErrCorrupt", err)
+	}
+	if _, err := LZO1X(v.src[:len(v.src)-3], len(v.want)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("missing end marker: err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestLZJB(t *testing.T) {
+	// Copy map 0x08: three literals, then a match of 9 at distance 3.
+	src := []byte{0x08, 'a', 'b', 'c', 6 << 2, 3}
+	if got, err := LZJB(src, 12); err != nil || string(got) != "abcabcabcabc" {
+		t.Fatalf("LZJB = %q, %v", got, err)
+	}
+	// A match longer than the block is clipped to the logical size.
+	if got, err := LZJB(src, 7); err != nil || string(got) != "abcabca" {
+		t.Fatalf("LZJB clipped = %q, %v", got, err)
+	}
+	for name, bad := range map[string][]byte{
+		"distance 0":     {0x01, 6 << 2, 0},
+		"before start":   {0x02, 'a', 6 << 2, 2},
+		"truncated":      {0x08, 'a', 'b', 'c', 6 << 2},
+		"short literals": {0x00, 'a'},
+	} {
+		if _, err := LZJB(bad, 12); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestZLE(t *testing.T) {
+	want := append([]byte("xyz"), make([]byte, 10)...)
+	want = append(want, '!')
+	src := []byte{2, 'x', 'y', 'z', 64 + 9, 0, '!'}
+	if got, err := ZLE(src, len(want)); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("ZLE = %q, %v", got, err)
+	}
+	for name, n := range map[string]int{"short block": len(want) + 1, "overrun": len(want) - 2} {
+		if _, err := ZLE(src, n); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+// windowsSample mixes the shapes the Windows encoders meet: text with
+// repeats near and far, x86 CALL opcodes for the LZX E8 translation,
+// incompressible noise and a long zero run for length extensions.
+func windowsSample(n int) []byte {
+	var b []byte
+	for i := 0; len(b) < n; i++ {
+		switch i % 4 {
+		case 0:
+			b = append(b, fmt.Sprintf("HKLM\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\\Run entry %d\r\n", i*7919)...)
+		case 1:
+			b = append(b, 0xE8, byte(i), byte(i>>8), 0, 0, 0x90, 0xE8, 0xF0, 0xFF, 0xFF, 0xFF)
+		case 2:
+			b = append(b, lcgBytes(200+i%300)...)
+		case 3:
+			b = append(b, make([]byte, 40+i*13%700)...)
+		}
+	}
+	return b[:n]
+}
+
+func TestLZNT1(t *testing.T) {
+	// A compressed chunk ("abc" then a back-reference of 9 at distance 3)
+	// that decodes short of 4096 bytes, then a stored chunk.
+	src := []byte{0x05, 0xB0, 0x08, 'a', 'b', 'c', 0x06, 0x20, 0x02, 0x30, 'x', 'y', 'z', 0, 0}
+	want := append([]byte("abcabcabcabc"), make([]byte, 4096-12)...)
+	want = append(want, "xyz"...)
+	if got, err := LZNT1(src, 65536); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("LZNT1 = %d bytes, %v", len(got), err)
+	}
+	if _, err := LZNT1(src, 4096); !errors.Is(err, ErrOutputLimit) {
+		t.Errorf("short limit: err = %v, want ErrOutputLimit", err)
+	}
+
+	plain := windowsSample(65536)
+	if got, err := LZNT1(ewffixture.LZNT1(plain), len(plain)); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("round trip = %d bytes, %v", len(got), err)
+	}
+	for name, bad := range map[string][]byte{
+		"distance past chunk start": {0x03, 0xB0, 0x01, 0x00, 0x10},
+		"chunk overruns buffer":     {0x10, 0xB0, 0x00, 'a'},
+		"truncated back-reference":  {0x02, 0xB0, 0x02, 'a', 0x00},
+	} {
+		if _, err := LZNT1(bad, 65536); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestXpressHuffman(t *testing.T) {
+	for _, n := range []int{1, 100, 4096, 8192, 16384, 65536} {
+		plain := windowsSample(n)
+		src := ewffixture.XpressHuffman(plain)
+		got, err := XpressHuffman(src, n)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: round trip = %d bytes, %v", n, len(got), err)
+		}
+		if n < 4096 {
+			continue
+		}
+		if _, err := XpressHuffman(src[:len(src)/2], n); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%d bytes truncated: err = %v, want ErrCorrupt", n, err)
+		}
+	}
+	// A run long enough for the 16-bit length extension.
+	plain := append([]byte("xyz"), make([]byte, 5000)...)
+	if got, err := XpressHuffman(ewffixture.XpressHuffman(plain), len(plain)); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("long match: %d bytes, %v", len(got), err)
+	}
+	over := make([]byte, 260)
+	over[0], over[1] = 0x11, 0x01 // three 1-bit codewords
+	if _, err := XpressHuffman(over, 10); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("over-subscribed table: err = %v, want ErrCorrupt", err)
+	}
+	if _, err := XpressHuffman(nil, 1<<17); err == nil {
+		t.Error("output over 64 KiB accepted")
+	}
+}
+
+func TestLZX(t *testing.T) {
+	for name, c := range map[string]struct {
+		n     int
+		types []int
+	}{
+		"verbatim":                {32768, nil},
+		"aligned":                 {32768, []int{ewffixture.LZXAligned}},
+		"short chunk":             {5000, []int{ewffixture.LZXVerbatim}},
+		"uncompressed first":      {20001, []int{ewffixture.LZXUncompressed, ewffixture.LZXVerbatim}},
+		"mixed blocks":            {32768, []int{ewffixture.LZXVerbatim, ewffixture.LZXAligned, ewffixture.LZXUncompressed, ewffixture.LZXAligned}},
+		"uncompressed odd length": {999, []int{ewffixture.LZXVerbatim, ewffixture.LZXUncompressed}},
+	} {
+		plain := windowsSample(c.n)
+		src := ewffixture.LZX(plain, c.types...)
+		got, err := LZX(src, c.n)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%s: round trip = %d bytes, %v", name, len(got), err)
+			continue
+		}
+		if _, err := LZX(src[:len(src)/3], c.n); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s truncated: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+	// Like wimlib and libmspack, both sides leave CALLs in the last 10
+	// bytes untranslated: the stored block keeps them as they are, and the
+	// decoder must not rewrite them either.
+	tail := append(windowsSample(1000), 0xE8, 0x10, 0, 0, 0, 0xE8, 0x20, 0, 0, 0)
+	src := ewffixture.LZX(tail, ewffixture.LZXUncompressed)
+	if !bytes.Contains(src, tail[len(tail)-10:]) {
+		t.Error("encoder translated a CALL in the last 10 bytes")
+	}
+	if got, err := LZX(src, len(tail)); err != nil || !bytes.Equal(got, tail) {
+		t.Errorf("CALLs in the tail: round trip = %d bytes, %v", len(got), err)
+	}
+	b := make([]byte, 21)
+	b[5], b[6] = 0xE8, 0x30
+	b[11], b[12] = 0xE8, 0x40
+	lzxUndoE8(b)
+	if b[6] != 0x30-5 {
+		t.Errorf("decoder skipped the CALL before the tail: % x", b)
+	}
+	if b[12] != 0x40 {
+		t.Errorf("decoder rewrote a CALL in the last 10 bytes: % x", b)
+	}
+	if _, err := LZX([]byte{0xFF, 0xFF, 0, 0}, 100); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("block type 7: err = %v, want ErrCorrupt", err)
+	}
+	if _, err := LZX(nil, 65536); err == nil {
+		t.Error("output over the window accepted")
+	}
+}
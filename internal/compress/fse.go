@@ -0,0 +1,459 @@
+package compress
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// fseTable is a finite state entropy decoding table: entry s gives the
+// symbol state s decodes to and how the next state is formed.
+type fseTable struct {
+	log uint8
+	e   []fseEntry
+}
+
+type fseEntry struct {
+	sym  uint8
+	bits uint8
+	base uint16
+}
+
+func (t *fseTable) next(state uint16, br *revBits) uint16 {
+	e := t.e[state]
+	return e.base + uint16(br.read(e.bits))
+}
+
+const (
+	fseLL = iota
+	fseOF
+	fseML
+)
+
+// Per-kind limits: largest symbol and largest accuracy log.
+var (
+	fseMaxSymbol = [3]int{35, 31, 52}
+	fseMaxLog    = [3]uint8{9, 8, 9}
+)
+
+// Predefined distributions (RFC 8878 section 3.1.1.3.2.2).
+var (
+	llDefault = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	mlDefault = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1, -1, -1, -1}
+	ofDefault = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	fseDefaults = [3]*fseTable{
+		mustFSE(llDefault, 6),
+		mustFSE(ofDefault, 5),
+		mustFSE(mlDefault, 6),
+	}
+)
+
+// Literal length and match length codes: baseline value and extra bits.
+var (
+	llBase = [36]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536}
+	llBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16}
+	mlBase = [53]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539}
+	mlBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16}
+)
+
+func mustFSE(norm []int16, log uint8) *fseTable {
+	t, err := buildFSE(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// buildFSE builds the decoding table for a normalized distribution; -1
+// marks a "less than one" probability.
+func buildFSE(norm []int16, log uint8) (*fseTable, error) {
+	size := 1 << log
+	t := &fseTable{log: log, e: make([]fseEntry, size)}
+	next := make([]uint16, len(norm))
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			t.e[high].sym = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(n)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			t.e[pos].sym = uint8(s)
+			for pos = (pos + step) & (size - 1); pos > high; pos = (pos + step) & (size - 1) {
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, fmt.Errorf("zstd: FSE distribution does not fill its table: %w", ErrCorrupt)
+	}
+	for u := range t.e {
+		s := t.e[u].sym
+		ns := next[s]
+		next[s]++
+		if ns == 0 {
+			return nil, fmt.Errorf("zstd: bad FSE distribution: %w", ErrCorrupt)
+		}
+		nb := log - uint8(bits.Len16(ns)-1)
+		t.e[u].bits = nb
+		t.e[u].base = ns<<nb - uint16(size)
+	}
+	return t, nil
+}
+
+// fwdBits reads a forward, little-endian bitstream.
+type fwdBits struct {
+	b   []byte
+	pos int // bit position
+}
+
+func (r *fwdBits) peek(n int) uint32 {
+	var v uint64
+	i := r.pos >> 3
+	for k := 0; k < 5 && i+k < len(r.b); k++ {
+		v |= uint64(r.b[i+k]) << (8 * k)
+	}
+	return uint32(v>>(r.pos&7)) & (1<<n - 1)
+}
+
+// readFSEDescription reads an FSE table description (the normalized counts)
+// and returns the table and the number of bytes it occupied.
+func readFSEDescription(src []byte, maxSym int, maxLog uint8) (*fseTable, int, error) {
+	if len(src) < 1 {
+		return nil, 0, fmt.Errorf("zstd: truncated FSE table: %w", ErrCorrupt)
+	}
+	r := &fwdBits{b: src}
+	log := uint8(r.peek(4)) + 5
+	r.pos += 4
+	if log > maxLog {
+		return nil, 0, fmt.Errorf("zstd: FSE accuracy log %d exceeds %d: %w", log, maxLog, ErrCorrupt)
+	}
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := int(log) + 1
+	var norm []int16
+	prev0 := false
+	for remaining > 1 && len(norm) <= maxSym {
+		if prev0 {
+			n := 0
+			for {
+				rep := int(r.peek(2))
+				r.pos += 2
+				n += rep
+				if rep != 3 {
+					break
+				}
+				if r.pos > len(src)*8 {
+					return nil, 0, fmt.Errorf("zstd: truncated FSE table: %w", ErrCorrupt)
+				}
+			}
+			for ; n > 0; n-- {
+				norm = append(norm, 0)
+			}
+			if len(norm) > maxSym {
+				return nil, 0, fmt.Errorf("zstd: FSE table has too many symbols: %w", ErrCorrupt)
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		v := int(r.peek(nbBits))
+		var count int
+		if v&(threshold-1) < max {
+			count = v & (threshold - 1)
+			r.pos += nbBits - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			r.pos += nbBits
+		}
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		prev0 = count == 0
+		for remaining < threshold && threshold > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+		if r.pos > len(src)*8 {
+			return nil, 0, fmt.Errorf("zstd: truncated FSE table: %w", ErrCorrupt)
+		}
+	}
+	if remaining != 1 || len(norm) > maxSym+1 {
+		return nil, 0, fmt.Errorf("zstd: bad FSE distribution: %w", ErrCorrupt)
+	}
+	t, err := buildFSE(norm, log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, (r.pos + 7) / 8, nil
+}
+
+// readSeqTable sets *table per a sequence symbol compression mode and
+// returns the number of bytes the mode's table data occupied.
+func readSeqTable(src []byte, mode byte, table **fseTable, kind int) (int, error) {
+	switch mode {
+	case 0:
+		*table = fseDefaults[kind]
+		return 0, nil
+	case 1:
+		if len(src) < 1 {
+			return 0, fmt.Errorf("zstd: truncated RLE sequence table: %w", ErrCorrupt)
+		}
+		if int(src[0]) > fseMaxSymbol[kind] {
+			return 0, fmt.Errorf("zstd: RLE symbol %d out of range: %w", src[0], ErrCorrupt)
+		}
+		*table = &fseTable{log: 0, e: []fseEntry{{sym: src[0]}}}
+		return 1, nil
+	case 2:
+		t, n, err := readFSEDescription(src, fseMaxSymbol[kind], fseMaxLog[kind])
+		if err != nil {
+			return 0, err
+		}
+		*table = t
+		return n, nil
+	default:
+		if *table == nil {
+			return 0, fmt.Errorf("zstd: repeat sequence table without a previous one: %w", ErrCorrupt)
+		}
+		return 0, nil
+	}
+}
+
+// huffTable is a Huffman literal decoding table indexed by the next log
+// bits of the stream.
+type huffTable struct {
+	log uint8
+	e   []huffEntry
+}
+
+type huffEntry struct {
+	sym  uint8
+	bits uint8
+}
+
+// readHuffTable reads a Huffman tree description and returns the table and
+// the description's size.
+func readHuffTable(src []byte) (*huffTable, int, error) {
+	if len(src) < 1 {
+		return nil, 0, fmt.Errorf("zstd: missing Huffman tree description: %w", ErrCorrupt)
+	}
+	h := int(src[0])
+	var weights []uint8
+	var n int
+	if h >= 128 {
+		count := h - 127
+		n = 1 + (count+1)/2
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("zstd: truncated Huffman weights: %w", ErrCorrupt)
+		}
+		for i := 0; i < count; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights = append(weights, b>>4)
+			} else {
+				weights = append(weights, b&15)
+			}
+		}
+	} else {
+		n = 1 + h
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("zstd: truncated Huffman weights: %w", ErrCorrupt)
+		}
+		var err error
+		if weights, err = fseWeights(src[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+	t, err := buildHuff(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, n, nil
+}
+
+// fseWeights decodes FSE-compressed Huffman weights: two interleaved states
+// share one table and alternate until the bitstream is exhausted.
+func fseWeights(src []byte) ([]uint8, error) {
+	t, n, err := readFSEDescription(src, 255, 6)
+	if err != nil {
+		return nil, err
+	}
+	br, err := newRevBits(src[n:])
+	if err != nil {
+		return nil, err
+	}
+	s1 := uint16(br.read(t.log))
+	s2 := uint16(br.read(t.log))
+	var w []uint8
+	for len(w) < 255 {
+		w = append(w, t.e[s1].sym)
+		s1 = t.next(s1, br)
+		if br.overflow() {
+			w = append(w, t.e[s2].sym)
+			break
+		}
+		w = append(w, t.e[s2].sym)
+		s2 = t.next(s2, br)
+		if br.overflow() {
+			w = append(w, t.e[s1].sym)
+			break
+		}
+	}
+	if !br.overflow() {
+		return nil, fmt.Errorf("zstd: too many Huffman weights: %w", ErrCorrupt)
+	}
+	return w, nil
+}
+
+// buildHuff completes the weight list with the implied last weight and
+// builds the decoding table.
+func buildHuff(weights []uint8) (*huffTable, error) {
+	if len(weights) == 0 || len(weights) > 255 {
+		return nil, fmt.Errorf("zstd: bad Huffman weight count %d: %w", len(weights), ErrCorrupt)
+	}
+	total := 0
+	for _, w := range weights {
+		if w > 11 {
+			return nil, fmt.Errorf("zstd: Huffman weight %d exceeds 11: %w", w, ErrCorrupt)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("zstd: Huffman weights are all zero: %w", ErrCorrupt)
+	}
+	log := uint8(bits.Len(uint(total)))
+	if log > 11 {
+		return nil, fmt.Errorf("zstd: Huffman table log %d exceeds 11: %w", log, ErrCorrupt)
+	}
+	rest := 1<<log - total
+	if rest&(rest-1) != 0 {
+		return nil, fmt.Errorf("zstd: Huffman weights do not complete a tree: %w", ErrCorrupt)
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+
+	var rank [13]int
+	for _, w := range weights {
+		if w > 0 {
+			rank[w] += 1 << (w - 1)
+		}
+	}
+	start, next := [13]int{}, 0
+	for w := 1; w <= int(log); w++ {
+		start[w] = next
+		next += rank[w]
+	}
+	t := &huffTable{log: log, e: make([]huffEntry, 1<<log)}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		n := 1 << (w - 1)
+		e := huffEntry{sym: uint8(s), bits: log + 1 - w}
+		for i := start[w]; i < start[w]+n; i++ {
+			t.e[i] = e
+		}
+		start[w] += n
+	}
+	return t, nil
+}
+
+// decode fills dst from one Huffman-coded stream, which must be consumed
+// exactly.
+func (t *huffTable) decode(src []byte, dst []byte) error {
+	br, err := newRevBits(src)
+	if err != nil {
+		return err
+	}
+	for i := range dst {
+		e := t.e[br.peek(t.log)]
+		dst[i] = e.sym
+		br.rem -= int(e.bits)
+		if br.rem < 0 {
+			return fmt.Errorf("zstd: Huffman stream overrun: %w", ErrCorrupt)
+		}
+	}
+	if br.rem != 0 {
+		return fmt.Errorf("zstd: %d unused bits in a Huffman stream: %w", br.rem, ErrCorrupt)
+	}
+	return nil
+}
+
+// xxh64 is XXH64 with seed 0, the hash behind the frame content checksum.
+func xxh64(b []byte) uint64 {
+	const (
+		p1 = 0x9E3779B185EBCA87
+		p2 = 0xC2B2AE3D27D4EB4F
+		p3 = 0x165667B19E3779F9
+		p4 = 0x85EBCA77C2B2AE63
+		p5 = 0x27D4EB2F165667C5
+	)
+	round := func(acc, in uint64) uint64 { return bits.RotateLeft64(acc+in*p2, 31) * p1 }
+	le64 := func(b []byte) uint64 {
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+			uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+	}
+	n := uint64(len(b))
+	var h uint64
+	if len(b) >= 32 {
+		seed1, seed2 := uint64(p1), uint64(p2)
+		v1, v2, v3, v4 := seed1+seed2, seed2, uint64(0), 0-seed1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = round(v1, le64(b))
+			v2 = round(v2, le64(b[8:]))
+			v3 = round(v3, le64(b[16:]))
+			v4 = round(v4, le64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range []uint64{v1, v2, v3, v4} {
+			h = (h^round(0, v))*p1 + p4
+		}
+	} else {
+		h = p5
+	}
+	h += n
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, le64(b))
+		h = bits.RotateLeft64(h, 27)*p1 + p4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(uint32(b[0])|uint32(b[1])<<8|uint32(b[2])<<16|uint32(b[3])<<24) * p1
+		h = bits.RotateLeft64(h, 23)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * p5
+		h = bits.RotateLeft64(h, 11) * p1
+	}
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,89 @@
+package compress
+
+import "fmt"
+
+// LZ4Block decodes one raw LZ4 block (the block format, without the frame
+// header that the lz4 tool writes) into at most limit bytes.
+//
+// A block is a run of sequences, each a token (literal length in the high
+// nibble, match length minus 4 in the low), optional length extension bytes,
+// the literals, and a 16-bit little-endian match offset. The last sequence
+// has literals only.
+func LZ4Block(src []byte, limit int) ([]byte, error) {
+	dst := make([]byte, 0, min(limit, 4*len(src)+64))
+	i := 0
+	extend := func(n int) (int, error) {
+		for {
+			if i >= len(src) {
+				return 0, fmt.Errorf("lz4: truncated length: %w", ErrCorrupt)
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if n > limit {
+				return 0, fmt.Errorf("lz4: %w", ErrOutputLimit)
+			}
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	for {
+		if i >= len(src) {
+			return nil, fmt.Errorf("lz4: block ends without a final literal run: %w", ErrCorrupt)
+		}
+		token := src[i]
+		i++
+		lit := int(token >> 4)
+		if lit == 15 {
+			var err error
+			if lit, err = extend(lit); err != nil {
+				return nil, err
+			}
+		}
+		if lit > len(src)-i {
+			return nil, fmt.Errorf("lz4: literal run overruns the block: %w", ErrCorrupt)
+		}
+		if len(dst)+lit > limit {
+			return nil, fmt.Errorf("lz4: %w", ErrOutputLimit)
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			return dst, nil
+		}
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("lz4: truncated match offset: %w", ErrCorrupt)
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if off == 0 || off > len(dst) {
+			return nil, fmt.Errorf("lz4: match offset %d outside the %d decoded bytes: %w", off, len(dst), ErrCorrupt)
+		}
+		ml := int(token & 15)
+		if ml == 15 {
+			var err error
+			if ml, err = extend(ml); err != nil {
+				return nil, err
+			}
+		}
+		ml += 4
+		if len(dst)+ml > limit {
+			return nil, fmt.Errorf("lz4: %w", ErrOutputLimit)
+		}
+		dst = copyMatch(dst, off, ml)
+	}
+}
+
+// copyMatch appends n bytes copied from dist bytes back in dst; the source
+// may overlap the bytes being appended.
+func copyMatch(dst []byte, dist, n int) []byte {
+	start := len(dst) - dist
+	if dist >= n {
+		return append(dst, dst[start:start+n]...)
+	}
+	for k := 0; k < n; k++ {
+		dst = append(dst, dst[start+k])
+	}
+	return dst
+}
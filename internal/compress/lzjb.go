@@ -0,0 +1,44 @@
+package compress
+
+import "fmt"
+
+// LZJB decodes a ZFS lzjb block into exactly n bytes. lzjb blocks carry no
+// length of their own; the block pointer records the logical size.
+//
+// The stream is groups of eight items led by a copy-map byte, least
+// significant bit first: a clear bit is one literal byte, a set bit a
+// two-byte match (length minus 3 in the top 6 bits, distance in the low 10).
+func LZJB(src []byte, n int) ([]byte, error) {
+	dst := make([]byte, 0, n)
+	var copymap byte
+	mask := 0x80
+	i := 0
+	for len(dst) < n {
+		if mask <<= 1; mask == 0x100 {
+			if i >= len(src) {
+				return nil, fmt.Errorf("lzjb: truncated at output %d of %d: %w", len(dst), n, ErrCorrupt)
+			}
+			copymap, mask = src[i], 1
+			i++
+		}
+		if copymap&byte(mask) == 0 {
+			if i >= len(src) {
+				return nil, fmt.Errorf("lzjb: truncated at output %d of %d: %w", len(dst), n, ErrCorrupt)
+			}
+			dst = append(dst, src[i])
+			i++
+			continue
+		}
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("lzjb: truncated match at output %d of %d: %w", len(dst), n, ErrCorrupt)
+		}
+		length := int(src[i]>>2) + 3
+		dist := (int(src[i])<<8 | int(src[i+1])) & 0x3FF
+		i += 2
+		if dist == 0 || dist > len(dst) {
+			return nil, fmt.Errorf("lzjb: match distance %d at output %d: %w", dist, len(dst), ErrCorrupt)
+		}
+		dst = copyMatch(dst, dist, min(length, n-len(dst)))
+	}
+	return dst, nil
+}
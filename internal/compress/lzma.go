@@ -0,0 +1,377 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LZMA decodes a legacy .lzma ("LZMA alone") stream into at most limit
+// bytes: a properties byte (lc/lp/pb), a 32-bit dictionary size, a 64-bit
+// uncompressed size (all ones when unknown, in which case the stream ends
+// with an end marker), then the range-coded data.
+func LZMA(src []byte, limit int) ([]byte, error) {
+	if len(src) < 13 {
+		return nil, fmt.Errorf("lzma: header truncated: %w", ErrCorrupt)
+	}
+	d := &lzmaDecoder{}
+	if err := d.setProps(src[0]); err != nil {
+		return nil, err
+	}
+	d.dictSize = max(binary.LittleEndian.Uint32(src[1:]), 1<<12)
+	size := binary.LittleEndian.Uint64(src[5:])
+	known := size != ^uint64(0)
+	if known && size > uint64(limit) {
+		return nil, fmt.Errorf("lzma: uncompressed size %d: %w", size, ErrOutputLimit)
+	}
+	d.out = make([]byte, 0, min(limit, 4*len(src)+64))
+	d.reset()
+	if err := d.rc.init(src[13:]); err != nil {
+		return nil, err
+	}
+	want := limit
+	if known {
+		want = int(size)
+	}
+	if err := d.decode(want, !known, limit); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
+
+// rangeDecoder is the LZMA binary range decoder over an in-memory buffer.
+type rangeDecoder struct {
+	src  []byte
+	pos  int
+	rng  uint32
+	code uint32
+	err  error
+}
+
+func (rc *rangeDecoder) init(src []byte) error {
+	*rc = rangeDecoder{src: src, rng: 0xFFFFFFFF}
+	if len(src) < 5 || src[0] != 0 {
+		return fmt.Errorf("lzma: bad range coder header: %w", ErrCorrupt)
+	}
+	rc.code = binary.BigEndian.Uint32(src[1:])
+	rc.pos = 5
+	if rc.code == rc.rng {
+		return fmt.Errorf("lzma: bad range coder header: %w", ErrCorrupt)
+	}
+	return nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		if rc.pos >= len(rc.src) {
+			if rc.err == nil {
+				rc.err = fmt.Errorf("lzma: input truncated: %w", ErrCorrupt)
+			}
+			rc.code <<= 8
+			return
+		}
+		rc.code = rc.code<<8 | uint32(rc.src[rc.pos])
+		rc.pos++
+	}
+}
+
+func (rc *rangeDecoder) bit(p *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*p)
+	var b uint32
+	if rc.code < bound {
+		*p += (2048 - *p) >> 5
+		rc.rng = bound
+	} else {
+		*p -= *p >> 5
+		rc.code -= bound
+		rc.rng -= bound
+		b = 1
+	}
+	rc.normalize()
+	return b
+}
+
+func (rc *rangeDecoder) direct(n int) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		if rc.code == rc.rng && rc.err == nil {
+			rc.err = fmt.Errorf("lzma: corrupt direct bits: %w", ErrCorrupt)
+		}
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (rc *rangeDecoder) tree(probs []uint16, bits int) uint32 {
+	m := uint32(1)
+	for i := 0; i < bits; i++ {
+		m = m<<1 + rc.bit(&probs[m])
+	}
+	return m - 1<<bits
+}
+
+func (rc *rangeDecoder) reverseTree(probs []uint16, bits int) uint32 {
+	m, sym := uint32(1), uint32(0)
+	for i := 0; i < bits; i++ {
+		b := rc.bit(&probs[m])
+		m = m<<1 + b
+		sym |= b << i
+	}
+	return sym
+}
+
+const (
+	lzmaStates        = 12
+	lzmaPosBitsMax    = 4
+	lzmaLenToPosState = 4
+	lzmaEndPosModel   = 14
+	lzmaFullDistances = 1 << (lzmaEndPosModel >> 1)
+	lzmaAlignBits     = 4
+	lzmaMatchMinLen   = 2
+)
+
+type lzmaLenDecoder struct {
+	choice, choice2 uint16
+	low, mid        [1 << lzmaPosBitsMax][8]uint16
+	high            [256]uint16
+}
+
+func (l *lzmaLenDecoder) reset() {
+	l.choice, l.choice2 = 1024, 1024
+	for i := range l.low {
+		fill(l.low[i][:])
+		fill(l.mid[i][:])
+	}
+	fill(l.high[:])
+}
+
+func (l *lzmaLenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&l.choice) == 0 {
+		return rc.tree(l.low[posState][:], 3)
+	}
+	if rc.bit(&l.choice2) == 0 {
+		return 8 + rc.tree(l.mid[posState][:], 3)
+	}
+	return 16 + rc.tree(l.high[:], 8)
+}
+
+func fill(p []uint16) {
+	for i := range p {
+		p[i] = 1024
+	}
+}
+
+// lzmaDecoder is the LZMA decoder state. The output buffer doubles as the
+// dictionary: matches may reach back to dictStart, which LZMA2 moves on a
+// dictionary reset.
+type lzmaDecoder struct {
+	lc, lp, pb uint
+	dictSize   uint32
+	rc         rangeDecoder
+
+	out       []byte
+	dictStart int
+
+	literal    []uint16
+	posSlot    [lzmaLenToPosState][64]uint16
+	posDecode  [1 + lzmaFullDistances - lzmaEndPosModel]uint16
+	align      [1 << lzmaAlignBits]uint16
+	isMatch    [lzmaStates << lzmaPosBitsMax]uint16
+	isRep      [lzmaStates]uint16
+	isRepG0    [lzmaStates]uint16
+	isRepG1    [lzmaStates]uint16
+	isRepG2    [lzmaStates]uint16
+	isRep0Long [lzmaStates << lzmaPosBitsMax]uint16
+	lenDec     lzmaLenDecoder
+	repLenDec  lzmaLenDecoder
+
+	state                  uint32
+	rep0, rep1, rep2, rep3 uint32
+}
+
+func (d *lzmaDecoder) setProps(b byte) error {
+	if b >= 9*5*5 {
+		return fmt.Errorf("lzma: bad properties byte 0x%02X: %w", b, ErrCorrupt)
+	}
+	d.lc, d.lp, d.pb = uint(b%9), uint(b/9%5), uint(b/45)
+	return nil
+}
+
+// reset clears the probability model and the match state.
+func (d *lzmaDecoder) reset() {
+	n := 0x300 << (d.lc + d.lp)
+	if cap(d.literal) >= n {
+		d.literal = d.literal[:n]
+	} else {
+		d.literal = make([]uint16, n)
+	}
+	fill(d.literal)
+	for i := range d.posSlot {
+		fill(d.posSlot[i][:])
+	}
+	fill(d.posDecode[:])
+	fill(d.align[:])
+	fill(d.isMatch[:])
+	fill(d.isRep[:])
+	fill(d.isRepG0[:])
+	fill(d.isRepG1[:])
+	fill(d.isRepG2[:])
+	fill(d.isRep0Long[:])
+	d.lenDec.reset()
+	d.repLenDec.reset()
+	d.state = 0
+	d.rep0, d.rep1, d.rep2, d.rep3 = 0, 0, 0, 0
+}
+
+// byteAt returns the byte dist bytes back (1 is the last one written).
+func (d *lzmaDecoder) byteAt(dist uint32) byte { return d.out[len(d.out)-int(dist)] }
+
+func (d *lzmaDecoder) decodeLiteral() {
+	var prev byte
+	if len(d.out) > d.dictStart {
+		prev = d.byteAt(1)
+	}
+	litState := (uint32(len(d.out)-d.dictStart)&(1<<d.lp-1))<<d.lc + uint32(prev)>>(8-d.lc)
+	probs := d.literal[0x300*litState:]
+	sym := uint32(1)
+	if d.state >= 7 {
+		match := uint32(d.byteAt(d.rep0 + 1))
+		for sym < 0x100 {
+			matchBit := (match >> 7) & 1
+			match <<= 1
+			b := d.rc.bit(&probs[(1+matchBit)<<8+sym])
+			sym = sym<<1 | b
+			if matchBit != b {
+				break
+			}
+		}
+	}
+	for sym < 0x100 {
+		sym = sym<<1 | d.rc.bit(&probs[sym])
+	}
+	d.out = append(d.out, byte(sym))
+}
+
+func (d *lzmaDecoder) decodeDistance(length uint32) uint32 {
+	lenState := min(length, lzmaLenToPosState-1)
+	slot := d.rc.tree(d.posSlot[lenState][:], 6)
+	if slot < 4 {
+		return slot
+	}
+	direct := int(slot>>1) - 1
+	dist := (2 | slot&1) << direct
+	if slot < lzmaEndPosModel {
+		return dist + d.rc.reverseTree(d.posDecode[dist-slot:], direct)
+	}
+	dist += d.rc.direct(direct-lzmaAlignBits) << lzmaAlignBits
+	return dist + d.rc.reverseTree(d.align[:], lzmaAlignBits)
+}
+
+// decode produces want more bytes, or — when endMarker is set — decodes up
+// to the end marker, never letting the output grow past limit bytes.
+func (d *lzmaDecoder) decode(want int, endMarker bool, limit int) error {
+	end := len(d.out) + want
+	for endMarker || len(d.out) < end {
+		if d.rc.err != nil {
+			return d.rc.err
+		}
+		posState := uint32(len(d.out)-d.dictStart) & (1<<d.pb - 1)
+		if d.rc.bit(&d.isMatch[d.state<<lzmaPosBitsMax+posState]) == 0 {
+			if len(d.out) >= limit {
+				return fmt.Errorf("lzma: %w", ErrOutputLimit)
+			}
+			d.decodeLiteral()
+			switch {
+			case d.state < 4:
+				d.state = 0
+			case d.state < 10:
+				d.state -= 3
+			default:
+				d.state -= 6
+			}
+			continue
+		}
+		var length uint32
+		if d.rc.bit(&d.isRep[d.state]) != 0 {
+			if len(d.out) == d.dictStart {
+				return fmt.Errorf("lzma: repeated match before any output: %w", ErrCorrupt)
+			}
+			if d.rc.bit(&d.isRepG0[d.state]) == 0 {
+				if d.rc.bit(&d.isRep0Long[d.state<<lzmaPosBitsMax+posState]) == 0 {
+					if len(d.out) >= limit {
+						return fmt.Errorf("lzma: %w", ErrOutputLimit)
+					}
+					if d.state < 7 {
+						d.state = 9
+					} else {
+						d.state = 11
+					}
+					d.out = append(d.out, d.byteAt(d.rep0+1))
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.rc.bit(&d.isRepG1[d.state]) == 0 {
+					dist = d.rep1
+				} else {
+					if d.rc.bit(&d.isRepG2[d.state]) == 0 {
+						dist = d.rep2
+					} else {
+						dist = d.rep3
+						d.rep3 = d.rep2
+					}
+					d.rep2 = d.rep1
+				}
+				d.rep1 = d.rep0
+				d.rep0 = dist
+			}
+			length = d.repLenDec.decode(&d.rc, posState)
+			if d.state < 7 {
+				d.state = 8
+			} else {
+				d.state = 11
+			}
+		} else {
+			d.rep3, d.rep2, d.rep1 = d.rep2, d.rep1, d.rep0
+			length = d.lenDec.decode(&d.rc, posState)
+			if d.state < 7 {
+				d.state = 7
+			} else {
+				d.state = 10
+			}
+			d.rep0 = d.decodeDistance(length)
+			if d.rep0 == 0xFFFFFFFF {
+				if d.rc.err != nil {
+					return d.rc.err
+				}
+				if !endMarker && len(d.out) != end {
+					return fmt.Errorf("lzma: end marker before the declared size: %w", ErrCorrupt)
+				}
+				return nil
+			}
+			if d.rep0 >= d.dictSize && d.dictSize != 0 {
+				return fmt.Errorf("lzma: match distance %d beyond the dictionary: %w", d.rep0, ErrCorrupt)
+			}
+		}
+		if d.rc.err != nil {
+			return d.rc.err
+		}
+		if int(d.rep0) >= len(d.out)-d.dictStart {
+			return fmt.Errorf("lzma: match distance %d outside the decoded data: %w", d.rep0, ErrCorrupt)
+		}
+		n := int(length) + lzmaMatchMinLen
+		if !endMarker && n > end-len(d.out) {
+			return fmt.Errorf("lzma: match runs past the declared size: %w", ErrCorrupt)
+		}
+		if len(d.out)+n > limit {
+			return fmt.Errorf("lzma: %w", ErrOutputLimit)
+		}
+		d.out = copyMatch(d.out, int(d.rep0)+1, n)
+	}
+	return d.rc.err
+}
@@ -0,0 +1,110 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// lznt1ChunkSize is the uncompressed size of every LZNT1 chunk but the last.
+const lznt1ChunkSize = 4096
+
+// LZNT1 decodes an NTFS LZNT1 buffer (MS-XCA 2.5) into at most limit bytes.
+// The buffer carries no total length: decoding stops at a zero chunk header
+// or at the end of src, so the result may be shorter than the compression
+// unit it came from; NTFS defines the rest of the unit as zeros.
+//
+// Each chunk has a 16-bit header: the chunk size minus 3 in the low 12 bits
+// and a compressed flag in bit 15. A stored chunk is its raw bytes. A
+// compressed chunk is groups of eight items led by a flag byte, least
+// significant bit first: a clear bit is a literal byte, a set bit a 16-bit
+// back-reference whose split between displacement and length bits widens with
+// the position inside the chunk. A compressed chunk that decodes to less than
+// 4096 bytes and is followed by another chunk is padded with zeros.
+func LZNT1(src []byte, limit int) ([]byte, error) {
+	dst := make([]byte, 0, min(limit, 16*len(src)+lznt1ChunkSize))
+	for i := 0; i+2 <= len(src); {
+		hdr := binary.LittleEndian.Uint16(src[i:])
+		if hdr == 0 {
+			break
+		}
+		size := int(hdr&0x0FFF) + 1 // chunk data bytes after the header
+		i += 2
+		if i+size > len(src) {
+			return nil, fmt.Errorf("lznt1: chunk of %d bytes at %d overruns the buffer: %w", size, i-2, ErrCorrupt)
+		}
+		// Pad the previous short chunk out to its 4096-byte slot.
+		if r := len(dst) % lznt1ChunkSize; r != 0 {
+			if len(dst)-r+lznt1ChunkSize > limit {
+				return nil, fmt.Errorf("lznt1: %w", ErrOutputLimit)
+			}
+			dst = append(dst, make([]byte, lznt1ChunkSize-r)...)
+		}
+		chunk := src[i : i+size]
+		i += size
+		if hdr&0x8000 == 0 {
+			if size > lznt1ChunkSize {
+				return nil, fmt.Errorf("lznt1: stored chunk of %d bytes: %w", size, ErrCorrupt)
+			}
+			if len(dst)+size > limit {
+				return nil, fmt.Errorf("lznt1: %w", ErrOutputLimit)
+			}
+			dst = append(dst, chunk...)
+			continue
+		}
+		var err error
+		if dst, err = lznt1Chunk(dst, chunk, limit); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// lznt1Chunk appends the decoding of one compressed chunk to dst.
+func lznt1Chunk(dst, chunk []byte, limit int) ([]byte, error) {
+	start := len(dst)
+	for j := 0; j < len(chunk); {
+		flags := chunk[j]
+		j++
+		for bit := 0; bit < 8 && j < len(chunk); bit++ {
+			pos := len(dst) - start
+			if flags&(1<<bit) == 0 {
+				if pos >= lznt1ChunkSize || len(dst) >= limit {
+					return nil, lznt1Overrun(pos, limit, len(dst)+1)
+				}
+				dst = append(dst, chunk[j])
+				j++
+				continue
+			}
+			if j+2 > len(chunk) {
+				return nil, fmt.Errorf("lznt1: truncated back-reference at chunk offset %d: %w", pos, ErrCorrupt)
+			}
+			token := int(binary.LittleEndian.Uint16(chunk[j:]))
+			j += 2
+			if pos == 0 {
+				return nil, fmt.Errorf("lznt1: back-reference at the start of a chunk: %w", ErrCorrupt)
+			}
+			// Displacement bits: 4 for the first 16 bytes of the chunk, then
+			// one more each time the position doubles.
+			dispBits := max(4, bits.Len(uint(pos-1)))
+			lenBits := 16 - dispBits
+			dist := token>>lenBits + 1
+			length := token&(1<<lenBits-1) + 3
+			if dist > pos {
+				return nil, fmt.Errorf("lznt1: displacement %d at chunk offset %d: %w", dist, pos, ErrCorrupt)
+			}
+			if pos+length > lznt1ChunkSize || len(dst)+length > limit {
+				return nil, lznt1Overrun(pos+length, limit, len(dst)+length)
+			}
+			dst = copyMatch(dst, dist, length)
+		}
+	}
+	return dst, nil
+}
+
+func lznt1Overrun(pos, limit, total int) error {
+	if total > limit {
+		return fmt.Errorf("lznt1: %w", ErrOutputLimit)
+	}
+	return fmt.Errorf("lznt1: chunk decodes past %d bytes (offset %d): %w", lznt1ChunkSize, pos, ErrCorrupt)
+}
@@ -0,0 +1,171 @@
+package compress
+
+import "fmt"
+
+// LZO1X decodes one LZO1X-1 block (lzo1x_decompress_safe semantics) into at
+// most limit bytes. The block must end with the end-of-stream marker and be
+// consumed exactly.
+//
+// The stream is a sequence of instructions. Below 16, an instruction at the
+// top level is a literal run; after a literal run of four or more bytes it is
+// a 3-byte match up to 0x0BFF back; after a match it is a 2-byte match up to
+// 0x3FF back. 16-31 are far (M4) matches — distance 0 is the end marker —,
+// 32-63 are M3 and 64-255 are M2 matches. The two low bits of a match's last
+// distance byte are a count of 0-3 literals that follow it.
+func LZO1X(src []byte, limit int) ([]byte, error) {
+	d := lzoDecoder{src: src, limit: limit, dst: make([]byte, 0, min(limit, 4*len(src)+64))}
+	return d.run()
+}
+
+type lzoDecoder struct {
+	src   []byte
+	ip    int
+	dst   []byte
+	limit int
+	err   error
+}
+
+func (d *lzoDecoder) next() int {
+	if d.ip >= len(d.src) {
+		if d.err == nil {
+			d.err = fmt.Errorf("lzo: input overrun: %w", ErrCorrupt)
+		}
+		return 0
+	}
+	b := d.src[d.ip]
+	d.ip++
+	return int(b)
+}
+
+// length reads the zero-byte extension of a run length: each zero adds 255
+// and the first non-zero byte is added to base.
+func (d *lzoDecoder) length(base int) int {
+	n := base
+	for d.err == nil {
+		b := d.next()
+		if b != 0 {
+			return n + b
+		}
+		n += 255
+		if n > d.limit {
+			d.err = fmt.Errorf("lzo: %w", ErrOutputLimit)
+		}
+	}
+	return 0
+}
+
+func (d *lzoDecoder) literals(n int) {
+	if d.err != nil {
+		return
+	}
+	switch {
+	case n > len(d.src)-d.ip:
+		d.err = fmt.Errorf("lzo: literal run overruns the input: %w", ErrCorrupt)
+	case len(d.dst)+n > d.limit:
+		d.err = fmt.Errorf("lzo: %w", ErrOutputLimit)
+	default:
+		d.dst = append(d.dst, d.src[d.ip:d.ip+n]...)
+		d.ip += n
+	}
+}
+
+func (d *lzoDecoder) match(dist, n int) {
+	if d.err != nil {
+		return
+	}
+	switch {
+	case dist <= 0 || dist > len(d.dst):
+		d.err = fmt.Errorf("lzo: match distance %d outside the %d decoded bytes: %w", dist, len(d.dst), ErrCorrupt)
+	case len(d.dst)+n > d.limit:
+		d.err = fmt.Errorf("lzo: %w", ErrOutputLimit)
+	default:
+		d.dst = copyMatch(d.dst, dist, n)
+	}
+}
+
+const (
+	lzoTop        = iota // next instruction starts a literal run or a match
+	lzoAfterRun          // previous instruction was a literal run of 4+ bytes
+	lzoAfterMatch        // t holds an instruction following a match
+)
+
+func (d *lzoDecoder) run() ([]byte, error) {
+	state, t := lzoTop, 0
+	if len(d.src) > 0 && d.src[0] > 17 {
+		d.ip = 1
+		t = int(d.src[0]) - 17
+		d.literals(t)
+		if t < 4 {
+			t, state = d.next(), lzoAfterMatch
+		} else {
+			state = lzoAfterRun
+		}
+	}
+	for d.err == nil {
+		switch state {
+		case lzoTop:
+			t = d.next()
+			if t >= 16 {
+				state = lzoAfterMatch
+				continue
+			}
+			if t == 0 {
+				t = d.length(15)
+			}
+			d.literals(t + 3)
+			state = lzoAfterRun
+			continue
+		case lzoAfterRun:
+			t = d.next()
+			if t >= 16 {
+				state = lzoAfterMatch
+				continue
+			}
+			d.match(1+0x0800+(t>>2)+(d.next()<<2), 3)
+		case lzoAfterMatch:
+			switch {
+			case t >= 64:
+				d.match(1+((t>>2)&7)+(d.next()<<3), (t>>5)+1)
+			case t >= 32:
+				n := t & 31
+				if n == 0 {
+					n = d.length(31)
+				}
+				b0, b1 := d.next(), d.next()
+				d.match(1+(b0>>2)+(b1<<6), n+2)
+			case t >= 16:
+				n := t & 7
+				if n == 0 {
+					n = d.length(7)
+				}
+				b0, b1 := d.next(), d.next()
+				dist := (t&8)<<11 + (b0 >> 2) + (b1 << 6)
+				if d.err != nil {
+					break
+				}
+				if dist == 0 {
+					if d.ip != len(d.src) {
+						return nil, fmt.Errorf("lzo: %d bytes after the end marker: %w", len(d.src)-d.ip, ErrCorrupt)
+					}
+					return d.dst, nil
+				}
+				d.match(dist+0x4000, n+2)
+			default:
+				d.match(1+(t>>2)+(d.next()<<2), 2)
+			}
+		}
+		if d.err != nil {
+			break
+		}
+		// The low bits of the instruction's last distance byte count the
+		// literals that follow the match.
+		t = int(d.src[d.ip-2]) & 3
+		if t == 0 {
+			state = lzoTop
+			continue
+		}
+		d.literals(t)
+		t, state = d.next(), lzoAfterMatch
+	}
+	return nil, d.err
+}
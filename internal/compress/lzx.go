@@ -0,0 +1,308 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LZX parameters for the 32 KiB window of WIM and Windows WOF chunks.
+const (
+	lzxWindowSize      = 32768
+	lzxNumOffsetSlots  = 30
+	lzxNumMainSyms     = 256 + 8*lzxNumOffsetSlots
+	lzxNumLenSyms      = 249
+	lzxNumPrecodeSyms  = 20
+	lzxNumAlignedSyms  = 8
+	lzxDefaultBlock    = 32768
+	lzxE8MagicFileSize = 12000000
+)
+
+// LZX block types.
+const (
+	lzxBlockVerbatim     = 1
+	lzxBlockAligned      = 2
+	lzxBlockUncompressed = 3
+)
+
+// lzxOffsetBase and lzxExtraBits give each offset slot's first formatted
+// offset and number of extra offset bits.
+var lzxOffsetBase, lzxExtraBits = func() (base, extra [lzxNumOffsetSlots]int) {
+	for s := range extra {
+		if s >= 4 {
+			extra[s] = s/2 - 1
+		}
+		if s > 0 {
+			base[s] = base[s-1] + 1<<extra[s-1]
+		}
+	}
+	return
+}()
+
+// lzxBits reads the LZX bitstream: 16-bit little-endian words consumed most
+// significant bit first. Words are loaded only when a read needs them, which
+// fixes where an uncompressed block's byte-aligned data begins. Words past
+// the end of the input read as zeros; consuming any of their bits is
+// corruption, detected once decoding ends.
+type lzxBits struct {
+	src      []byte
+	pos      int
+	buf      uint32
+	left     int
+	real     int // bits loaded from src
+	consumed int
+}
+
+func (b *lzxBits) ensure(n int) {
+	for b.left < n {
+		var w uint32
+		if b.pos+2 <= len(b.src) {
+			w = uint32(binary.LittleEndian.Uint16(b.src[b.pos:]))
+			b.real += 16
+		}
+		b.pos += 2
+		b.buf |= w << (16 - b.left)
+		b.left += 16
+	}
+}
+
+func (b *lzxBits) pop(n int) {
+	b.buf <<= n
+	b.left -= n
+	b.consumed += n
+}
+
+func (b *lzxBits) bits(n int) int {
+	if n == 0 {
+		return 0
+	}
+	b.ensure(n)
+	v := int(b.buf >> (32 - n))
+	b.pop(n)
+	return v
+}
+
+func (b *lzxBits) symbol(c *prefixCode) (int, error) {
+	b.ensure(c.maxLen)
+	sym, l, err := c.decode(b.buf >> (32 - c.maxLen))
+	if err != nil {
+		return 0, err
+	}
+	b.pop(l)
+	return sym, nil
+}
+
+// align discards the bits up to the next 16-bit boundary, or a whole word
+// when the stream is already aligned, before an uncompressed block's bytes.
+func (b *lzxBits) align() {
+	b.ensure(1)
+	b.consumed += b.left
+	b.buf, b.left = 0, 0
+}
+
+// lens reads codeword lengths delta-coded against their previous values
+// through a freshly transmitted 20-symbol precode.
+func (b *lzxBits) lens(lens []uint8) error {
+	var pre [lzxNumPrecodeSyms]uint8
+	for i := range pre {
+		pre[i] = uint8(b.bits(4))
+	}
+	code, err := newPrefixCode(pre[:], 15)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(lens); {
+		sym, err := b.symbol(code)
+		if err != nil {
+			return err
+		}
+		if sym < 17 {
+			lens[i] = (lens[i] + 17 - uint8(sym)) % 17
+			i++
+			continue
+		}
+		var run int
+		var v uint8
+		switch sym {
+		case 17:
+			run = 4 + b.bits(4)
+		case 18:
+			run = 20 + b.bits(5)
+		default:
+			run = 4 + b.bits(1)
+			d, err := b.symbol(code)
+			if err != nil {
+				return err
+			}
+			if d > 17 {
+				return fmt.Errorf("precode run of symbol %d: %w", d, ErrCorrupt)
+			}
+			v = (lens[i] + 17 - uint8(d)) % 17
+		}
+		if run > len(lens)-i {
+			return fmt.Errorf("codeword length run of %d overruns %d lengths: %w", run, len(lens), ErrCorrupt)
+		}
+		for ; run > 0; run-- {
+			lens[i] = v
+			i++
+		}
+	}
+	return nil
+}
+
+// LZX decodes one LZX chunk of a WIM resource or a Windows WOF LZX file into
+// exactly n bytes (at most 32 KiB, the window size). The chunk is compressed
+// on its own: matches cannot reach before its start and the code lengths
+// start from zero.
+//
+// The chunk is a run of blocks, each with a 3-bit type and its output size
+// (a set bit for the default 32768, else 16 bits). Verbatim and aligned
+// offset blocks carry their main and length codes as lengths delta-coded
+// through a precode, aligned offset blocks also an 8-symbol code for the low
+// three bits of long offsets. Uncompressed blocks hold three recent offsets
+// and the raw bytes after aligning the bitstream. The decoded chunk then has
+// its x86 CALL (0xE8) targets translated back from absolute to relative
+// addresses.
+func LZX(src []byte, n int) ([]byte, error) {
+	if n > lzxWindowSize {
+		return nil, fmt.Errorf("lzx: %d-byte output exceeds the %d-byte window", n, lzxWindowSize)
+	}
+	dst := make([]byte, 0, n)
+	b := &lzxBits{src: src}
+	var mainLens [lzxNumMainSyms]uint8
+	var lenLens [lzxNumLenSyms]uint8
+	recent := [3]int{1, 1, 1}
+	for len(dst) < n {
+		typ := b.bits(3)
+		size := lzxDefaultBlock
+		if b.bits(1) == 0 {
+			size = b.bits(16)
+		}
+		if size == 0 || size > n-len(dst) {
+			return nil, fmt.Errorf("lzx: block of %d bytes at output %d of %d: %w", size, len(dst), n, ErrCorrupt)
+		}
+		end := len(dst) + size
+		if typ == lzxBlockUncompressed {
+			b.align()
+			if b.pos+12+size > len(src) {
+				return nil, fmt.Errorf("lzx: truncated uncompressed block at output %d: %w", len(dst), ErrCorrupt)
+			}
+			for i := range recent {
+				recent[i] = int(binary.LittleEndian.Uint32(src[b.pos+4*i:]))
+				if recent[i] == 0 {
+					return nil, fmt.Errorf("lzx: zero recent offset: %w", ErrCorrupt)
+				}
+			}
+			b.pos += 12
+			dst = append(dst, src[b.pos:b.pos+size]...)
+			b.pos += size + size&1
+			continue
+		}
+		if typ != lzxBlockVerbatim && typ != lzxBlockAligned {
+			return nil, fmt.Errorf("lzx: block type %d: %w", typ, ErrCorrupt)
+		}
+		var aligned *prefixCode
+		if typ == lzxBlockAligned {
+			var al [lzxNumAlignedSyms]uint8
+			for i := range al {
+				al[i] = uint8(b.bits(3))
+			}
+			var err error
+			if aligned, err = newPrefixCode(al[:], 7); err != nil {
+				return nil, fmt.Errorf("lzx: aligned offset code: %w", err)
+			}
+		}
+		if err := b.lens(mainLens[:256]); err != nil {
+			return nil, fmt.Errorf("lzx: main code: %w", err)
+		}
+		if err := b.lens(mainLens[256:]); err != nil {
+			return nil, fmt.Errorf("lzx: main code: %w", err)
+		}
+		mainCode, err := newPrefixCode(mainLens[:], 16)
+		if err != nil {
+			return nil, fmt.Errorf("lzx: main code: %w", err)
+		}
+		if err := b.lens(lenLens[:]); err != nil {
+			return nil, fmt.Errorf("lzx: length code: %w", err)
+		}
+		lenCode, err := newPrefixCode(lenLens[:], 16)
+		if err != nil {
+			return nil, fmt.Errorf("lzx: length code: %w", err)
+		}
+
+		for len(dst) < end {
+			sym, err := b.symbol(mainCode)
+			if err != nil {
+				return nil, fmt.Errorf("lzx: at output %d: %w", len(dst), err)
+			}
+			if sym < 256 {
+				dst = append(dst, byte(sym))
+				continue
+			}
+			sym -= 256
+			slot, length := sym>>3, sym&7
+			if length == 7 {
+				l, err := b.symbol(lenCode)
+				if err != nil {
+					return nil, fmt.Errorf("lzx: match length at output %d: %w", len(dst), err)
+				}
+				length += l
+			}
+			length += 2
+			var offset int
+			if slot < 3 {
+				offset = recent[slot]
+				recent[slot] = recent[0]
+				recent[0] = offset
+			} else {
+				eb := lzxExtraBits[slot]
+				var e int
+				if aligned != nil && eb >= 3 {
+					e = b.bits(eb-3) << 3
+					a, err := b.symbol(aligned)
+					if err != nil {
+						return nil, fmt.Errorf("lzx: aligned offset at output %d: %w", len(dst), err)
+					}
+					e += a
+				} else {
+					e = b.bits(eb)
+				}
+				offset = lzxOffsetBase[slot] + e - 2
+				recent[2], recent[1], recent[0] = recent[1], recent[0], offset
+			}
+			if offset > len(dst) || length > end-len(dst) {
+				return nil, fmt.Errorf("lzx: match of %d at distance %d at output %d: %w", length, offset, len(dst), ErrCorrupt)
+			}
+			dst = copyMatch(dst, offset, length)
+		}
+	}
+	if b.consumed > b.real {
+		return nil, fmt.Errorf("lzx: chunk ends %d bits early: %w", b.consumed-b.real, ErrCorrupt)
+	}
+	lzxUndoE8(dst)
+	return dst, nil
+}
+
+// lzxUndoE8 reverses the encoder's x86 call translation: the 32-bit operand
+// after each 0xE8 byte (outside the last 10 bytes, skipping the 4 operand
+// bytes) was rewritten from a relative to an absolute target in a notional
+// 12000000-byte file. The 10-byte tail matches wimlib and libmspack.
+func lzxUndoE8(b []byte) {
+	if len(b) <= 10 {
+		return
+	}
+	for p := 0; p < len(b)-10; {
+		if b[p] != 0xE8 {
+			p++
+			continue
+		}
+		abs := int32(binary.LittleEndian.Uint32(b[p+1:]))
+		if abs >= 0 {
+			if abs < lzxE8MagicFileSize {
+				binary.LittleEndian.PutUint32(b[p+1:], uint32(abs-int32(p)))
+			}
+		} else if abs >= -int32(p) {
+			binary.LittleEndian.PutUint32(b[p+1:], uint32(abs+lzxE8MagicFileSize))
+		}
+		p += 5
+	}
+}
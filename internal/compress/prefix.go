@@ -0,0 +1,60 @@
+package compress
+
+import "fmt"
+
+// prefixCode decodes a canonical prefix code whose codewords are read most
+// significant bit first, the convention of XPRESS Huffman and LZX: codewords
+// are assigned in order of length, then of symbol value. A code that leaves
+// codewords unassigned is accepted; only decoding an unassigned codeword
+// fails. An all-zero set of lengths is an empty code that cannot decode
+// anything.
+type prefixCode struct {
+	maxLen  int
+	count   [17]int  // number of codewords of each length
+	symbols []uint16 // symbols ordered by codeword
+}
+
+// newPrefixCode builds the code of the given codeword lengths (0 = unused).
+func newPrefixCode(lens []uint8, maxLen int) (*prefixCode, error) {
+	c := &prefixCode{maxLen: maxLen}
+	for _, l := range lens {
+		if int(l) > maxLen {
+			return nil, fmt.Errorf("codeword length %d exceeds %d: %w", l, maxLen, ErrCorrupt)
+		}
+		c.count[l]++
+	}
+	c.count[0] = 0
+	left := 1
+	var offs [18]int
+	for l := 1; l <= maxLen; l++ {
+		left = left<<1 - c.count[l]
+		if left < 0 {
+			return nil, fmt.Errorf("over-subscribed prefix code: %w", ErrCorrupt)
+		}
+		offs[l+1] = offs[l] + c.count[l]
+	}
+	c.symbols = make([]uint16, offs[maxLen+1])
+	for s, l := range lens {
+		if l != 0 {
+			c.symbols[offs[l]] = uint16(s)
+			offs[l]++
+		}
+	}
+	return c, nil
+}
+
+// decode returns the symbol whose codeword starts the maxLen bits in peek
+// (first bit most significant) and the codeword's length.
+func (c *prefixCode) decode(peek uint32) (int, int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= c.maxLen; l++ {
+		code |= int(peek>>(c.maxLen-l)) & 1
+		if n := c.count[l]; code-first < n {
+			return int(c.symbols[index+code-first]), l, nil
+		}
+		index += c.count[l]
+		first = (first + c.count[l]) << 1
+		code <<= 1
+	}
+	return 0, 0, fmt.Errorf("invalid prefix codeword: %w", ErrCorrupt)
+}
@@ -0,0 +1,131 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// xpressBlockSize is the output covered by one XPRESS Huffman code table.
+const xpressBlockSize = 65536
+
+// XpressHuffman decodes one LZ77+Huffman ("XPRESS Huffman", MS-XCA 2.2)
+// block into exactly n bytes, the format of Windows WOF XPRESS4K/8K/16K
+// chunks. Outputs over 64 KiB span several code tables and are not
+// supported.
+//
+// The block starts with 512 4-bit codeword lengths (literals 0-255, then
+// matches 256-511: the high nibble of a match symbol is the offset's bit
+// length, the low nibble its length minus 3, 15 meaning the length follows
+// as extra bytes). The Huffman-coded bits follow as 16-bit little-endian
+// words read most significant bit first; the extra length bytes are
+// interleaved byte-aligned at the point the decoder reaches them.
+func XpressHuffman(src []byte, n int) ([]byte, error) {
+	if n > xpressBlockSize {
+		return nil, fmt.Errorf("xpress: %d-byte output spans more than one %d-byte block", n, xpressBlockSize)
+	}
+	dst := make([]byte, 0, n)
+	if n == 0 {
+		return dst, nil
+	}
+	if len(src) < 256 {
+		return nil, fmt.Errorf("xpress: %d-byte block has no code table: %w", len(src), ErrCorrupt)
+	}
+	lens := make([]uint8, 512)
+	for i, b := range src[:256] {
+		lens[2*i], lens[2*i+1] = b&0x0F, b>>4
+	}
+	code, err := newPrefixCode(lens, 15)
+	if err != nil {
+		return nil, fmt.Errorf("xpress: %w", err)
+	}
+
+	// The bit buffer holds 16 + extra unread bits; a word is added as soon
+	// as fewer than 16 remain. Words past the end of src read as zeros so
+	// the last codeword can be decoded, but consuming any of their bits is
+	// corruption.
+	pos, words, phantom, consumed := 256, 0, 0, 0
+	word := func() uint32 {
+		words++
+		if pos+2 > len(src) {
+			pos += 2
+			phantom++
+			return 0
+		}
+		v := uint32(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		return v
+	}
+	next := word()<<16 | word()
+	extra := 16
+	consume := func(k int) {
+		next <<= k
+		extra -= k
+		consumed += k
+		if extra < 0 {
+			next |= word() << -extra
+			extra += 16
+		}
+	}
+	readBytes := func(k int) (uint32, error) {
+		if pos+k > len(src) {
+			return 0, fmt.Errorf("xpress: truncated match length at output %d: %w", len(dst), ErrCorrupt)
+		}
+		var v uint32
+		for i := k - 1; i >= 0; i-- {
+			v = v<<8 | uint32(src[pos+i])
+		}
+		pos += k
+		return v, nil
+	}
+
+	for len(dst) < n {
+		sym, l, err := code.decode(next >> 17)
+		if err != nil {
+			return nil, fmt.Errorf("xpress: at output %d: %w", len(dst), err)
+		}
+		consume(l)
+		if sym < 256 {
+			dst = append(dst, byte(sym))
+			continue
+		}
+		sym -= 256
+		length, obits := sym&15, sym>>4
+		if length == 15 {
+			v, err := readBytes(1)
+			if err != nil {
+				return nil, err
+			}
+			if v == 255 {
+				if v, err = readBytes(2); err == nil && v == 0 {
+					v, err = readBytes(4)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if v < 15 {
+					return nil, fmt.Errorf("xpress: match length %d at output %d: %w", v, len(dst), ErrCorrupt)
+				}
+				v -= 15
+			}
+			if v > uint32(n) {
+				return nil, fmt.Errorf("xpress: match length %d at output %d: %w", v, len(dst), ErrCorrupt)
+			}
+			length = int(v) + 15
+		}
+		length += 3
+		offset := 1 << obits
+		if obits > 0 {
+			offset += int(next >> (32 - obits))
+			consume(obits)
+		}
+		if offset > len(dst) || length > n-len(dst) {
+			return nil, fmt.Errorf("xpress: match of %d at distance %d at output %d of %d: %w",
+				length, offset, len(dst), n, ErrCorrupt)
+		}
+		dst = copyMatch(dst, offset, length)
+	}
+	if consumed > 16*(words-phantom) {
+		return nil, fmt.Errorf("xpress: block ends %d bits early: %w", consumed-16*(words-phantom), ErrCorrupt)
+	}
+	return dst, nil
+}
@@ -0,0 +1,379 @@
+package compress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+var (
+	xzMagic     = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	xzCRC64     = crc64.MakeTable(crc64.ECMA)
+	xzCheckSize = [16]int{0, 4, 4, 4, 8, 8, 8, 16, 16, 16, 32, 32, 32, 64, 64, 64}
+)
+
+const (
+	xzCheckNone   = 0x00
+	xzCheckCRC32  = 0x01
+	xzCheckCRC64  = 0x04
+	xzCheckSHA256 = 0x0A
+
+	xzFilterDelta    = 0x03
+	xzFilterX86      = 0x04
+	xzFilterPowerPC  = 0x05
+	xzFilterIA64     = 0x06
+	xzFilterARM      = 0x07
+	xzFilterARMThumb = 0x08
+	xzFilterSPARC    = 0x09
+	xzFilterARM64    = 0x0A
+	xzFilterLZMA2    = 0x21
+)
+
+// XZ decodes an .xz container into at most limit bytes. Concatenated
+// streams and stream padding are accepted. Every block header, block check
+// (CRC32, CRC64 or SHA-256), index and stream footer is verified; other
+// check types are rejected because they cannot be verified. Blocks may use
+// the BCJ (x86, PowerPC, IA-64, ARM, ARM-Thumb, ARM64, SPARC) and delta
+// filters in front of LZMA2.
+func XZ(src []byte, limit int) ([]byte, error) {
+	out := make([]byte, 0, min(limit, 4*len(src)+64))
+	pos := 0
+	for {
+		n, err := xzStream(src[pos:], &out, limit)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		for len(src)-pos >= 4 && bytes.Equal(src[pos:pos+4], []byte{0, 0, 0, 0}) {
+			pos += 4
+		}
+		if pos == len(src) {
+			return out, nil
+		}
+	}
+}
+
+// xzVarint reads a multibyte integer, returning it and its length.
+func xzVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 9; i++ {
+		v |= uint64(b[i]&0x7F) << (7 * i)
+		if b[i]&0x80 == 0 {
+			if i > 0 && b[i] == 0 {
+				return 0, 0, fmt.Errorf("xz: non-minimal integer: %w", ErrCorrupt)
+			}
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("xz: bad integer: %w", ErrCorrupt)
+}
+
+// xzStream decodes one stream, appending to *out; it returns the stream's
+// length in bytes.
+func xzStream(src []byte, out *[]byte, limit int) (int, error) {
+	if len(src) < 12 || !bytes.Equal(src[:6], xzMagic) {
+		return 0, fmt.Errorf("xz: bad stream header magic: %w", ErrCorrupt)
+	}
+	flags := src[6:8]
+	if crc32.ChecksumIEEE(flags) != binary.LittleEndian.Uint32(src[8:]) {
+		return 0, fmt.Errorf("xz: stream header CRC mismatch: %w", ErrCorrupt)
+	}
+	if flags[0] != 0 || flags[1]&0xF0 != 0 {
+		return 0, fmt.Errorf("xz: bad stream flags %02X%02X: %w", flags[0], flags[1], ErrCorrupt)
+	}
+	check := flags[1]
+	switch check {
+	case xzCheckNone, xzCheckCRC32, xzCheckCRC64, xzCheckSHA256:
+	default:
+		return 0, fmt.Errorf("xz: unsupported check type %d", check)
+	}
+	type record struct{ unpadded, uncompressed uint64 }
+	var records []record
+	pos := 12
+	for {
+		if pos >= len(src) {
+			return 0, fmt.Errorf("xz: stream truncated before the index: %w", ErrCorrupt)
+		}
+		if src[pos] == 0 {
+			break
+		}
+		start := len(*out)
+		hdr, comp, err := xzBlock(src[pos:], out, limit)
+		if err != nil {
+			return 0, err
+		}
+		data := (*out)[start:]
+		pos += hdr + comp
+		for pad := comp; pad%4 != 0; pad++ {
+			if pos >= len(src) || src[pos] != 0 {
+				return 0, fmt.Errorf("xz: bad block padding: %w", ErrCorrupt)
+			}
+			pos++
+		}
+		size := xzCheckSize[check]
+		if len(src)-pos < size {
+			return 0, fmt.Errorf("xz: block check truncated: %w", ErrCorrupt)
+		}
+		sum := src[pos : pos+size]
+		ok := true
+		switch check {
+		case xzCheckCRC32:
+			ok = crc32.ChecksumIEEE(data) == binary.LittleEndian.Uint32(sum)
+		case xzCheckCRC64:
+			ok = crc64.Checksum(data, xzCRC64) == binary.LittleEndian.Uint64(sum)
+		case xzCheckSHA256:
+			h := sha256.Sum256(data)
+			ok = bytes.Equal(h[:], sum)
+		}
+		if !ok {
+			return 0, fmt.Errorf("xz: block check mismatch: %w", ErrCorrupt)
+		}
+		pos += size
+		records = append(records, record{uint64(hdr + comp + size), uint64(len(data))})
+	}
+
+	// Index: indicator, record count, records, padding, CRC32.
+	index := pos
+	pos++
+	count, n, err := xzVarint(src[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	if count != uint64(len(records)) {
+		return 0, fmt.Errorf("xz: index lists %d blocks, stream has %d: %w", count, len(records), ErrCorrupt)
+	}
+	for _, r := range records {
+		unpadded, n, err := xzVarint(src[pos:])
+		if err != nil {
+			return 0, err
+		}
+		pos += n
+		uncompressed, n, err := xzVarint(src[pos:])
+		if err != nil {
+			return 0, err
+		}
+		pos += n
+		if unpadded != r.unpadded || uncompressed != r.uncompressed {
+			return 0, fmt.Errorf("xz: index record does not match its block: %w", ErrCorrupt)
+		}
+	}
+	for ; (pos-index)%4 != 0; pos++ {
+		if pos >= len(src) || src[pos] != 0 {
+			return 0, fmt.Errorf("xz: bad index padding: %w", ErrCorrupt)
+		}
+	}
+	if len(src)-pos < 4+12 {
+		return 0, fmt.Errorf("xz: stream truncated in the index: %w", ErrCorrupt)
+	}
+	if crc32.ChecksumIEEE(src[index:pos]) != binary.LittleEndian.Uint32(src[pos:]) {
+		return 0, fmt.Errorf("xz: index CRC mismatch: %w", ErrCorrupt)
+	}
+	pos += 4
+
+	// Footer: CRC32, backward size, flags, "YZ".
+	foot := src[pos : pos+12]
+	if crc32.ChecksumIEEE(foot[4:10]) != binary.LittleEndian.Uint32(foot) ||
+		!bytes.Equal(foot[8:10], flags) || foot[10] != 'Y' || foot[11] != 'Z' {
+		return 0, fmt.Errorf("xz: bad stream footer: %w", ErrCorrupt)
+	}
+	if backward := (uint64(binary.LittleEndian.Uint32(foot[4:])) + 1) * 4; backward != uint64(pos-index) {
+		return 0, fmt.Errorf("xz: footer backward size %d does not match the index: %w", backward, ErrCorrupt)
+	}
+	return pos + 12, nil
+}
+
+type xzFilter struct {
+	id    uint64
+	props []byte
+}
+
+// xzBlock decodes one block, appending its data to *out; it returns the
+// block header size and the compressed data size (without padding).
+func xzBlock(src []byte, out *[]byte, limit int) (int, int, error) {
+	hdrSize := (int(src[0]) + 1) * 4
+	if len(src) < hdrSize {
+		return 0, 0, fmt.Errorf("xz: block header truncated: %w", ErrCorrupt)
+	}
+	hdr := src[:hdrSize]
+	if crc32.ChecksumIEEE(hdr[:hdrSize-4]) != binary.LittleEndian.Uint32(hdr[hdrSize-4:]) {
+		return 0, 0, fmt.Errorf("xz: block header CRC mismatch: %w", ErrCorrupt)
+	}
+	flags := hdr[1]
+	if flags&0x3C != 0 {
+		return 0, 0, fmt.Errorf("xz: unsupported block flags 0x%02X", flags)
+	}
+	pos := 2
+	body := hdr[:hdrSize-4]
+	compSize, uncompSize := int64(-1), int64(-1)
+	if flags&0x40 != 0 {
+		v, n, err := xzVarint(body[pos:])
+		if err != nil {
+			return 0, 0, err
+		}
+		if v == 0 || v > uint64(len(src)) {
+			return 0, 0, fmt.Errorf("xz: bad compressed size %d: %w", v, ErrCorrupt)
+		}
+		compSize, pos = int64(v), pos+n
+	}
+	if flags&0x80 != 0 {
+		v, n, err := xzVarint(body[pos:])
+		if err != nil {
+			return 0, 0, err
+		}
+		if v > uint64(limit) {
+			return 0, 0, fmt.Errorf("xz: block size %d: %w", v, ErrOutputLimit)
+		}
+		uncompSize, pos = int64(v), pos+n
+	}
+	filters := make([]xzFilter, int(flags&3)+1)
+	for i := range filters {
+		id, n, err := xzVarint(body[pos:])
+		if err != nil {
+			return 0, 0, err
+		}
+		pos += n
+		size, n, err := xzVarint(body[pos:])
+		if err != nil {
+			return 0, 0, err
+		}
+		pos += n
+		if size > uint64(len(body)-pos) {
+			return 0, 0, fmt.Errorf("xz: filter properties overrun the block header: %w", ErrCorrupt)
+		}
+		filters[i] = xzFilter{id, body[pos : pos+int(size)]}
+		pos += int(size)
+	}
+	for _, b := range body[pos:] {
+		if b != 0 {
+			return 0, 0, fmt.Errorf("xz: bad block header padding: %w", ErrCorrupt)
+		}
+	}
+	last := filters[len(filters)-1]
+	if last.id != xzFilterLZMA2 || len(last.props) != 1 {
+		return 0, 0, fmt.Errorf("xz: block does not end in an LZMA2 filter (filter 0x%X)", last.id)
+	}
+	for _, f := range filters[:len(filters)-1] {
+		if err := checkXZFilter(f); err != nil {
+			return 0, 0, err
+		}
+	}
+	data := src[hdrSize:]
+	if compSize >= 0 {
+		if compSize > int64(len(data)) {
+			return 0, 0, fmt.Errorf("xz: compressed size %d overruns the stream: %w", compSize, ErrCorrupt)
+		}
+		data = data[:compSize]
+	}
+	start := len(*out)
+	n, err := lzma2(data, last.props[0], out, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if compSize >= 0 && int64(n) != compSize {
+		return 0, 0, fmt.Errorf("xz: block used %d of %d compressed bytes: %w", n, compSize, ErrCorrupt)
+	}
+	if uncompSize >= 0 && int64(len(*out)-start) != uncompSize {
+		return 0, 0, fmt.Errorf("xz: block decoded to %d bytes, header says %d: %w", len(*out)-start, uncompSize, ErrCorrupt)
+	}
+	for i := len(filters) - 2; i >= 0; i-- {
+		applyXZFilter(filters[i], (*out)[start:])
+	}
+	return hdrSize, n, nil
+}
+
+// lzma2 decodes an LZMA2 chunk sequence into *out, returning the number of
+// input bytes consumed including the end-of-stream byte.
+func lzma2(src []byte, dictProp byte, out *[]byte, limit int) (int, error) {
+	if dictProp > 40 {
+		return 0, fmt.Errorf("lzma2: bad dictionary size property %d: %w", dictProp, ErrCorrupt)
+	}
+	d := &lzmaDecoder{out: *out, dictStart: len(*out)}
+	if dictProp == 40 {
+		d.dictSize = 0xFFFFFFFF
+	} else {
+		d.dictSize = (2 | uint32(dictProp)&1) << (dictProp/2 + 11)
+	}
+	defer func() { *out = d.out }()
+	pos := 0
+	needDictReset, needProps := true, true
+	for {
+		if pos >= len(src) {
+			return 0, fmt.Errorf("lzma2: truncated: %w", ErrCorrupt)
+		}
+		c := src[pos]
+		pos++
+		if c == 0 {
+			return pos, nil
+		}
+		if c == 1 || c >= 0xE0 {
+			d.dictStart = len(d.out)
+			needDictReset = false
+		} else if needDictReset {
+			return 0, fmt.Errorf("lzma2: first chunk does not reset the dictionary: %w", ErrCorrupt)
+		}
+		if c < 0x80 {
+			if c > 2 {
+				return 0, fmt.Errorf("lzma2: bad control byte 0x%02X: %w", c, ErrCorrupt)
+			}
+			if len(src)-pos < 2 {
+				return 0, fmt.Errorf("lzma2: truncated: %w", ErrCorrupt)
+			}
+			n := int(binary.BigEndian.Uint16(src[pos:])) + 1
+			pos += 2
+			if n > len(src)-pos {
+				return 0, fmt.Errorf("lzma2: uncompressed chunk overruns the block: %w", ErrCorrupt)
+			}
+			if len(d.out)+n > limit {
+				return 0, fmt.Errorf("lzma2: %w", ErrOutputLimit)
+			}
+			d.out = append(d.out, src[pos:pos+n]...)
+			pos += n
+			continue
+		}
+		if len(src)-pos < 4 {
+			return 0, fmt.Errorf("lzma2: truncated: %w", ErrCorrupt)
+		}
+		unpacked := int(c&0x1F)<<16 + int(binary.BigEndian.Uint16(src[pos:])) + 1
+		packed := int(binary.BigEndian.Uint16(src[pos+2:])) + 1
+		pos += 4
+		switch mode := (c >> 5) & 3; {
+		case mode >= 2:
+			if pos >= len(src) {
+				return 0, fmt.Errorf("lzma2: truncated: %w", ErrCorrupt)
+			}
+			if err := d.setProps(src[pos]); err != nil {
+				return 0, err
+			}
+			if d.lc+d.lp > 4 {
+				return 0, fmt.Errorf("lzma2: lc+lp exceeds 4: %w", ErrCorrupt)
+			}
+			pos++
+			needProps = false
+			d.reset()
+		case needProps:
+			return 0, fmt.Errorf("lzma2: chunk without properties: %w", ErrCorrupt)
+		case mode == 1:
+			d.reset()
+		}
+		if packed > len(src)-pos {
+			return 0, fmt.Errorf("lzma2: compressed chunk overruns the block: %w", ErrCorrupt)
+		}
+		if len(d.out)+unpacked > limit {
+			return 0, fmt.Errorf("lzma2: %w", ErrOutputLimit)
+		}
+		if err := d.rc.init(src[pos : pos+packed]); err != nil {
+			return 0, err
+		}
+		if err := d.decode(unpacked, false, limit); err != nil {
+			return 0, err
+		}
+		if d.rc.pos != packed || d.rc.code != 0 {
+			return 0, fmt.Errorf("lzma2: chunk did not end on its packed size: %w", ErrCorrupt)
+		}
+		pos += packed
+	}
+}
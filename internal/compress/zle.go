@@ -0,0 +1,32 @@
+package compress
+
+import "fmt"
+
+// ZLE decodes a ZFS zero-length-encoded block into exactly n bytes.
+//
+// Each run starts with a byte b: b < 64 is followed by b+1 literal bytes,
+// b >= 64 stands for b-63 zero bytes.
+func ZLE(src []byte, n int) ([]byte, error) {
+	dst := make([]byte, 0, n)
+	for i := 0; i < len(src) && len(dst) < n; {
+		run := int(src[i]) + 1
+		i++
+		if run <= 64 {
+			if i+run > len(src) || len(dst)+run > n {
+				return nil, fmt.Errorf("zle: literal run of %d overruns the block: %w", run, ErrCorrupt)
+			}
+			dst = append(dst, src[i:i+run]...)
+			i += run
+			continue
+		}
+		run -= 64
+		if len(dst)+run > n {
+			return nil, fmt.Errorf("zle: zero run of %d overruns the block: %w", run, ErrCorrupt)
+		}
+		dst = append(dst, make([]byte, run)...)
+	}
+	if len(dst) != n {
+		return nil, fmt.Errorf("zle: decoded %d of %d bytes: %w", len(dst), n, ErrCorrupt)
+	}
+	return dst, nil
+}
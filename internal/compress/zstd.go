@@ -0,0 +1,463 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Zstd decodes one or more Zstandard frames (RFC 8878) into at most limit
+// bytes. Skippable frames are skipped; frames that need a dictionary are
+// rejected. A frame's content checksum, when present, is verified.
+func Zstd(src []byte, limit int) ([]byte, error) {
+	out := make([]byte, 0, min(limit, 4*len(src)+64))
+	pos := 0
+	for pos < len(src) {
+		if len(src)-pos < 4 {
+			return nil, fmt.Errorf("zstd: truncated frame magic: %w", ErrCorrupt)
+		}
+		magic := binary.LittleEndian.Uint32(src[pos:])
+		if magic&0xFFFFFFF0 == 0x184D2A50 {
+			if len(src)-pos < 8 {
+				return nil, fmt.Errorf("zstd: truncated skippable frame: %w", ErrCorrupt)
+			}
+			n := uint64(binary.LittleEndian.Uint32(src[pos+4:]))
+			if n > uint64(len(src)-pos-8) {
+				return nil, fmt.Errorf("zstd: skippable frame overruns the input: %w", ErrCorrupt)
+			}
+			pos += 8 + int(n)
+			continue
+		}
+		if magic != 0xFD2FB528 {
+			return nil, fmt.Errorf("zstd: bad frame magic 0x%08X: %w", magic, ErrCorrupt)
+		}
+		n, err := zstdFrame(src[pos+4:], &out, limit)
+		if err != nil {
+			return nil, err
+		}
+		pos += 4 + n
+	}
+	return out, nil
+}
+
+const zstdMaxBlock = 128 << 10
+
+// zstdState is what a frame's blocks share: the previous Huffman and FSE
+// tables (for the repeat modes) and the repeat offsets.
+type zstdState struct {
+	huff      *huffTable
+	ll, of, m *fseTable
+	rep       [3]int
+	literals  []byte
+}
+
+func zstdFrame(src []byte, out *[]byte, limit int) (int, error) {
+	if len(src) < 1 {
+		return 0, fmt.Errorf("zstd: truncated frame header: %w", ErrCorrupt)
+	}
+	desc := src[0]
+	if desc&0x08 != 0 {
+		return 0, fmt.Errorf("zstd: reserved frame header bit set: %w", ErrCorrupt)
+	}
+	single := desc&0x20 != 0
+	checksum := desc&0x04 != 0
+	dictSize := [4]int{0, 1, 2, 4}[desc&3]
+	fcsSize := [4]int{0, 2, 4, 8}[desc>>6]
+	if fcsSize == 0 && single {
+		fcsSize = 1
+	}
+	pos := 1
+	if !single {
+		pos++ // window descriptor; the whole output is the window here
+	}
+	if len(src) < pos+dictSize+fcsSize {
+		return 0, fmt.Errorf("zstd: truncated frame header: %w", ErrCorrupt)
+	}
+	var dictID uint32
+	for i := 0; i < dictSize; i++ {
+		dictID |= uint32(src[pos+i]) << (8 * i)
+	}
+	pos += dictSize
+	if dictID != 0 {
+		return 0, fmt.Errorf("zstd: frame needs dictionary %d", dictID)
+	}
+	fcs := int64(-1)
+	if fcsSize > 0 {
+		var v uint64
+		for i := 0; i < fcsSize; i++ {
+			v |= uint64(src[pos+i]) << (8 * i)
+		}
+		if fcsSize == 2 {
+			v += 256
+		}
+		if v > uint64(limit-len(*out)) {
+			return 0, fmt.Errorf("zstd: frame content size %d: %w", v, ErrOutputLimit)
+		}
+		fcs = int64(v)
+		pos += fcsSize
+	}
+	start := len(*out)
+	st := &zstdState{rep: [3]int{1, 4, 8}}
+	for {
+		if len(src)-pos < 3 {
+			return 0, fmt.Errorf("zstd: truncated block header: %w", ErrCorrupt)
+		}
+		h := int(src[pos]) | int(src[pos+1])<<8 | int(src[pos+2])<<16
+		pos += 3
+		last, typ, size := h&1 != 0, (h>>1)&3, h>>3
+		switch typ {
+		case 0, 2:
+			if size > len(src)-pos {
+				return 0, fmt.Errorf("zstd: block overruns the frame: %w", ErrCorrupt)
+			}
+		case 1:
+			if pos >= len(src) {
+				return 0, fmt.Errorf("zstd: truncated RLE block: %w", ErrCorrupt)
+			}
+		default:
+			return 0, fmt.Errorf("zstd: reserved block type: %w", ErrCorrupt)
+		}
+		if size > zstdMaxBlock {
+			return 0, fmt.Errorf("zstd: block size %d exceeds 128 KiB: %w", size, ErrCorrupt)
+		}
+		switch typ {
+		case 0:
+			if len(*out)+size > limit {
+				return 0, fmt.Errorf("zstd: %w", ErrOutputLimit)
+			}
+			*out = append(*out, src[pos:pos+size]...)
+			pos += size
+		case 1:
+			if len(*out)+size > limit {
+				return 0, fmt.Errorf("zstd: %w", ErrOutputLimit)
+			}
+			for i := 0; i < size; i++ {
+				*out = append(*out, src[pos])
+			}
+			pos++
+		case 2:
+			if err := st.block(src[pos:pos+size], out, start, limit); err != nil {
+				return 0, err
+			}
+			pos += size
+		}
+		if last {
+			break
+		}
+	}
+	if fcs >= 0 && int64(len(*out)-start) != fcs {
+		return 0, fmt.Errorf("zstd: frame decoded to %d bytes, header says %d: %w", len(*out)-start, fcs, ErrCorrupt)
+	}
+	if checksum {
+		if len(src)-pos < 4 {
+			return 0, fmt.Errorf("zstd: truncated content checksum: %w", ErrCorrupt)
+		}
+		if uint32(xxh64((*out)[start:])) != binary.LittleEndian.Uint32(src[pos:]) {
+			return 0, fmt.Errorf("zstd: content checksum mismatch: %w", ErrCorrupt)
+		}
+		pos += 4
+	}
+	return pos, nil
+}
+
+// block decodes a compressed block; frameStart bounds match offsets.
+func (st *zstdState) block(src []byte, out *[]byte, frameStart, limit int) error {
+	n, err := st.decodeLiterals(src)
+	if err != nil {
+		return err
+	}
+	src = src[n:]
+	if len(src) < 1 {
+		return fmt.Errorf("zstd: missing sequences section: %w", ErrCorrupt)
+	}
+	nseq := int(src[0])
+	pos := 1
+	switch {
+	case nseq == 255:
+		if len(src) < 3 {
+			return fmt.Errorf("zstd: truncated sequence count: %w", ErrCorrupt)
+		}
+		nseq = int(src[1]) + int(src[2])<<8 + 0x7F00
+		pos = 3
+	case nseq >= 128:
+		if len(src) < 2 {
+			return fmt.Errorf("zstd: truncated sequence count: %w", ErrCorrupt)
+		}
+		nseq = (nseq-128)<<8 + int(src[1])
+		pos = 2
+	}
+	lits := st.literals
+	blockStart := len(*out)
+	emit := func(b []byte) error {
+		if len(*out)+len(b) > limit || len(*out)+len(b)-blockStart > zstdMaxBlock {
+			return fmt.Errorf("zstd: %w", ErrOutputLimit)
+		}
+		*out = append(*out, b...)
+		return nil
+	}
+	if nseq == 0 {
+		if pos != len(src) {
+			return fmt.Errorf("zstd: data after an empty sequences section: %w", ErrCorrupt)
+		}
+		return emit(lits)
+	}
+	if pos >= len(src) {
+		return fmt.Errorf("zstd: truncated sequence modes: %w", ErrCorrupt)
+	}
+	modes := src[pos]
+	pos++
+	if modes&3 != 0 {
+		return fmt.Errorf("zstd: reserved sequence mode bits set: %w", ErrCorrupt)
+	}
+	for _, t := range []struct {
+		mode  byte
+		table **fseTable
+		kind  int
+	}{{modes >> 6, &st.ll, fseLL}, {(modes >> 4) & 3, &st.of, fseOF}, {(modes >> 2) & 3, &st.m, fseML}} {
+		n, err := readSeqTable(src[pos:], t.mode, t.table, t.kind)
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+	br, err := newRevBits(src[pos:])
+	if err != nil {
+		return err
+	}
+	llState := uint16(br.read(st.ll.log))
+	ofState := uint16(br.read(st.of.log))
+	mlState := uint16(br.read(st.m.log))
+	for i := 0; i < nseq; i++ {
+		llCode := st.ll.e[llState].sym
+		ofCode := st.of.e[ofState].sym
+		mlCode := st.m.e[mlState].sym
+		if ofCode > 31 {
+			return fmt.Errorf("zstd: offset code %d: %w", ofCode, ErrCorrupt)
+		}
+		ofValue := 1<<ofCode + int(br.read(uint8(ofCode)))
+		ml := mlBase[mlCode] + int(br.read(mlBits[mlCode]))
+		ll := llBase[llCode] + int(br.read(llBits[llCode]))
+
+		var offset int
+		if ofValue > 3 {
+			offset = ofValue - 3
+			st.rep = [3]int{offset, st.rep[0], st.rep[1]}
+		} else {
+			idx := ofValue - 1
+			if ll == 0 {
+				idx++
+			}
+			if idx == 0 {
+				offset = st.rep[0]
+			} else {
+				if idx == 3 {
+					offset = st.rep[0] - 1
+				} else {
+					offset = st.rep[idx]
+				}
+				if idx > 1 {
+					st.rep[2] = st.rep[1]
+				}
+				st.rep[1] = st.rep[0]
+				st.rep[0] = offset
+			}
+		}
+		if i < nseq-1 {
+			llState = st.ll.next(llState, br)
+			mlState = st.m.next(mlState, br)
+			ofState = st.of.next(ofState, br)
+		}
+		if br.overflow() {
+			return fmt.Errorf("zstd: sequence bitstream overrun: %w", ErrCorrupt)
+		}
+
+		if ll > len(lits) {
+			return fmt.Errorf("zstd: sequence uses %d literals, %d left: %w", ll, len(lits), ErrCorrupt)
+		}
+		if err := emit(lits[:ll]); err != nil {
+			return err
+		}
+		lits = lits[ll:]
+		if offset <= 0 || offset > len(*out)-frameStart {
+			return fmt.Errorf("zstd: match offset %d outside the decoded data: %w", offset, ErrCorrupt)
+		}
+		if len(*out)+ml > limit || len(*out)+ml-blockStart > zstdMaxBlock {
+			return fmt.Errorf("zstd: %w", ErrOutputLimit)
+		}
+		*out = copyMatch(*out, offset, ml)
+	}
+	if br.remaining() != 0 {
+		return fmt.Errorf("zstd: %d unused bits after the last sequence: %w", br.remaining(), ErrCorrupt)
+	}
+	return emit(lits)
+}
+
+// decodeLiterals decodes the literals section into st.literals and returns
+// its size.
+func (st *zstdState) decodeLiterals(src []byte) (int, error) {
+	if len(src) < 1 {
+		return 0, fmt.Errorf("zstd: missing literals section: %w", ErrCorrupt)
+	}
+	typ, sf := src[0]&3, (src[0]>>2)&3
+	if typ < 2 {
+		var size, hdr int
+		switch sf {
+		case 0, 2:
+			size, hdr = int(src[0]>>3), 1
+		case 1:
+			if len(src) < 2 {
+				return 0, fmt.Errorf("zstd: truncated literals header: %w", ErrCorrupt)
+			}
+			size, hdr = int(src[0]>>4)+int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return 0, fmt.Errorf("zstd: truncated literals header: %w", ErrCorrupt)
+			}
+			size, hdr = int(src[0]>>4)+int(src[1])<<4+int(src[2])<<12, 3
+		}
+		if size > zstdMaxBlock {
+			return 0, fmt.Errorf("zstd: literals size %d exceeds 128 KiB: %w", size, ErrCorrupt)
+		}
+		if typ == 0 {
+			if size > len(src)-hdr {
+				return 0, fmt.Errorf("zstd: raw literals overrun the block: %w", ErrCorrupt)
+			}
+			st.literals = append(st.literals[:0], src[hdr:hdr+size]...)
+			return hdr + size, nil
+		}
+		if hdr >= len(src) {
+			return 0, fmt.Errorf("zstd: truncated RLE literals: %w", ErrCorrupt)
+		}
+		st.literals = st.literals[:0]
+		for i := 0; i < size; i++ {
+			st.literals = append(st.literals, src[hdr])
+		}
+		return hdr + 1, nil
+	}
+
+	var regen, comp, hdr int
+	streams := 4
+	switch sf {
+	case 0, 1:
+		if len(src) < 3 {
+			return 0, fmt.Errorf("zstd: truncated literals header: %w", ErrCorrupt)
+		}
+		h := int(src[0]) | int(src[1])<<8 | int(src[2])<<16
+		regen, comp, hdr = (h>>4)&0x3FF, (h>>14)&0x3FF, 3
+		if sf == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(src) < 4 {
+			return 0, fmt.Errorf("zstd: truncated literals header: %w", ErrCorrupt)
+		}
+		h := int(binary.LittleEndian.Uint32(src))
+		regen, comp, hdr = (h>>4)&0x3FFF, (h>>18)&0x3FFF, 4
+	case 3:
+		if len(src) < 5 {
+			return 0, fmt.Errorf("zstd: truncated literals header: %w", ErrCorrupt)
+		}
+		h := int(binary.LittleEndian.Uint32(src)) | int(src[4])<<32
+		regen, comp, hdr = (h>>4)&0x3FFFF, (h>>22)&0x3FFFF, 5
+	}
+	if regen > zstdMaxBlock {
+		return 0, fmt.Errorf("zstd: literals size %d exceeds 128 KiB: %w", regen, ErrCorrupt)
+	}
+	if comp > len(src)-hdr {
+		return 0, fmt.Errorf("zstd: compressed literals overrun the block: %w", ErrCorrupt)
+	}
+	data := src[hdr : hdr+comp]
+	if typ == 2 {
+		t, n, err := readHuffTable(data)
+		if err != nil {
+			return 0, err
+		}
+		st.huff = t
+		data = data[n:]
+	} else if st.huff == nil {
+		return 0, fmt.Errorf("zstd: treeless literals without a previous table: %w", ErrCorrupt)
+	}
+	if cap(st.literals) < regen {
+		st.literals = make([]byte, regen)
+	}
+	st.literals = st.literals[:regen]
+	if streams == 1 {
+		if err := st.huff.decode(data, st.literals); err != nil {
+			return 0, err
+		}
+		return hdr + comp, nil
+	}
+	if len(data) < 6 {
+		return 0, fmt.Errorf("zstd: truncated literals jump table: %w", ErrCorrupt)
+	}
+	s1 := int(binary.LittleEndian.Uint16(data))
+	s2 := int(binary.LittleEndian.Uint16(data[2:]))
+	s3 := int(binary.LittleEndian.Uint16(data[4:]))
+	data = data[6:]
+	if s1+s2+s3 > len(data) {
+		return 0, fmt.Errorf("zstd: literals jump table overruns the block: %w", ErrCorrupt)
+	}
+	seg := (regen + 3) / 4
+	if 3*seg > regen {
+		return 0, fmt.Errorf("zstd: %d literals cannot fill four streams: %w", regen, ErrCorrupt)
+	}
+	bounds := []int{0, s1, s1 + s2, s1 + s2 + s3, len(data)}
+	for i := 0; i < 4; i++ {
+		dst := st.literals[i*seg:]
+		if i < 3 {
+			dst = dst[:seg]
+		}
+		if err := st.huff.decode(data[bounds[i]:bounds[i+1]], dst); err != nil {
+			return 0, err
+		}
+	}
+	return hdr + comp, nil
+}
+
+// revBits reads a backward bitstream: the stream starts at the highest set
+// bit of its last byte and is read towards its first byte, most significant
+// bits first.
+type revBits struct {
+	b   []byte
+	rem int // bits left to read; negative once the reader has overrun
+}
+
+func newRevBits(b []byte) (*revBits, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, fmt.Errorf("zstd: bitstream without an end mark: %w", ErrCorrupt)
+	}
+	return &revBits{b: b, rem: (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1}, nil
+}
+
+// peek returns the next n (at most 56) bits without consuming them; bits
+// past the start of the stream read as zeros.
+func (r *revBits) peek(n uint8) uint64 {
+	if n == 0 {
+		return 0
+	}
+	start := r.rem - int(n)
+	shift := 0
+	if start < 0 {
+		shift, start = -start, 0
+	}
+	avail := int(n) - shift
+	if avail <= 0 {
+		return 0
+	}
+	i := start >> 3
+	var v uint64
+	for k := 0; k < 8 && i+k < len(r.b); k++ {
+		v |= uint64(r.b[i+k]) << (8 * k)
+	}
+	v = (v >> (start & 7)) & (1<<avail - 1)
+	return v << shift
+}
+
+func (r *revBits) read(n uint8) uint64 {
+	v := r.peek(n)
+	r.rem -= int(n)
+	return v
+}
+
+func (r *revBits) overflow() bool { return r.rem < 0 }
+func (r *revBits) remaining() int { return r.rem }
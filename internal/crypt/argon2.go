@@ -0,0 +1,252 @@
+package crypt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Argon2 (RFC 9106), version 0x13, single-threaded. LUKS2 key slots use
+// Argon2i and Argon2id.
+
+// Argon2 variants.
+const (
+	Argon2d  = 0
+	Argon2i  = 1
+	Argon2id = 2
+)
+
+const (
+	argon2Version    = 0x13
+	argon2SyncPoints = 4
+	// maxArgon2Memory bounds the memory cost in KiB (hostile-input guard):
+	// 4 GiB, the largest cost cryptsetup writes.
+	maxArgon2Memory = 4 << 20
+)
+
+type argon2Block [128]uint64
+
+// Argon2Key derives a keyLen-byte key from password and salt. time is the
+// number of passes, memory the cost in KiB and threads the number of lanes.
+func Argon2Key(variant int, password, salt []byte, time, memory, threads uint32, keyLen int) ([]byte, error) {
+	return argon2(variant, password, salt, nil, nil, time, memory, threads, keyLen)
+}
+
+// argon2 is Argon2Key with the optional secret and associated data.
+func argon2(variant int, password, salt, secret, ad []byte, time, memory, threads uint32, keyLen int) ([]byte, error) {
+	if variant < Argon2d || variant > Argon2id {
+		return nil, fmt.Errorf("unknown Argon2 variant %d", variant)
+	}
+	if time < 1 || threads < 1 || threads > 1<<24-1 || keyLen < 4 {
+		return nil, fmt.Errorf("invalid Argon2 parameters (time %d, threads %d, key %d bytes)", time, threads, keyLen)
+	}
+	if memory < 8*threads || memory > maxArgon2Memory {
+		return nil, fmt.Errorf("Argon2 memory cost %d KiB is out of range", memory)
+	}
+
+	h0 := argon2H0(variant, password, salt, secret, ad, time, memory, threads, keyLen)
+	lanes := int(threads)
+	segLen := int(memory) / (argon2SyncPoints * lanes)
+	laneLen := segLen * argon2SyncPoints
+	mem := make([]argon2Block, laneLen*lanes)
+
+	var buf [1024]byte
+	for l := 0; l < lanes; l++ {
+		for j := uint32(0); j < 2; j++ {
+			in := make([]byte, 72)
+			copy(in, h0)
+			binary.LittleEndian.PutUint32(in[64:], j)
+			binary.LittleEndian.PutUint32(in[68:], uint32(l))
+			copy(buf[:], argon2Hash(in, 1024))
+			b := &mem[l*laneLen+int(j)]
+			for k := range b {
+				b[k] = binary.LittleEndian.Uint64(buf[8*k:])
+			}
+		}
+	}
+
+	for pass := 0; pass < int(time); pass++ {
+		for slice := 0; slice < argon2SyncPoints; slice++ {
+			for l := 0; l < lanes; l++ {
+				argon2Segment(mem, variant, pass, slice, l, lanes, segLen, laneLen, int(time))
+			}
+		}
+	}
+
+	var final argon2Block
+	for l := 0; l < lanes; l++ {
+		b := &mem[l*laneLen+laneLen-1]
+		for k := range final {
+			final[k] ^= b[k]
+		}
+	}
+	for k, v := range final {
+		binary.LittleEndian.PutUint64(buf[8*k:], v)
+	}
+	return argon2Hash(buf[:], keyLen), nil
+}
+
+func argon2H0(variant int, password, salt, secret, ad []byte, time, memory, threads uint32, keyLen int) []byte {
+	var p []byte
+	le := func(v uint32) { p = binary.LittleEndian.AppendUint32(p, v) }
+	le(threads)
+	le(uint32(keyLen))
+	le(memory)
+	le(time)
+	le(argon2Version)
+	le(uint32(variant))
+	le(uint32(len(password)))
+	p = append(p, password...)
+	le(uint32(len(salt)))
+	p = append(p, salt...)
+	le(uint32(len(secret)))
+	p = append(p, secret...)
+	le(uint32(len(ad)))
+	p = append(p, ad...)
+	return Blake2b(64, p)
+}
+
+// argon2Hash is the variable-length hash H'.
+func argon2Hash(in []byte, size int) []byte {
+	var t [4]byte
+	binary.LittleEndian.PutUint32(t[:], uint32(size))
+	if size <= 64 {
+		return Blake2b(size, t[:], in)
+	}
+	out := make([]byte, 0, size)
+	v := Blake2b(64, t[:], in)
+	for size-len(out) > 64 {
+		out = append(out, v[:32]...)
+		if size-len(out) <= 64 {
+			break
+		}
+		v = Blake2b(64, v)
+	}
+	return append(out, Blake2b(size-len(out), v)...)
+}
+
+func argon2Segment(mem []argon2Block, variant, pass, slice, lane, lanes, segLen, laneLen, time int) {
+	independent := variant == Argon2i || (variant == Argon2id && pass == 0 && slice < argon2SyncPoints/2)
+	var addr, input, zero argon2Block
+	if independent {
+		input[0] = uint64(pass)
+		input[1] = uint64(lane)
+		input[2] = uint64(slice)
+		input[3] = uint64(len(mem))
+		input[4] = uint64(time)
+		input[5] = uint64(variant)
+	}
+	next := func() {
+		input[6]++
+		argon2G(&addr, &zero, &input, false)
+		argon2G(&addr, &zero, &addr, false)
+	}
+
+	start := 0
+	if pass == 0 && slice == 0 {
+		start = 2
+		if independent {
+			next()
+		}
+	}
+	base := lane * laneLen
+	for i := start; i < segLen; i++ {
+		cur := slice*segLen + i
+		prev := cur - 1
+		if cur == 0 {
+			prev = laneLen - 1
+		}
+		var rnd uint64
+		if independent {
+			if i%128 == 0 {
+				next()
+			}
+			rnd = addr[i%128]
+		} else {
+			rnd = mem[base+prev][0]
+		}
+
+		refLane := int(rnd>>32) % lanes
+		if pass == 0 && slice == 0 {
+			refLane = lane
+		}
+		var area int
+		switch {
+		case pass == 0 && refLane == lane:
+			area = slice*segLen + i - 1
+		case pass == 0:
+			area = slice * segLen
+			if i == 0 {
+				area--
+			}
+		case refLane == lane:
+			area = laneLen - segLen + i - 1
+		default:
+			area = laneLen - segLen
+			if i == 0 {
+				area--
+			}
+		}
+		x := rnd & 0xFFFFFFFF
+		x = x * x >> 32
+		rel := area - 1 - int(uint64(area)*x>>32)
+		startPos := 0
+		if pass != 0 && slice != argon2SyncPoints-1 {
+			startPos = (slice + 1) * segLen
+		}
+		ref := (startPos + rel) % laneLen
+
+		argon2G(&mem[base+cur], &mem[base+prev], &mem[refLane*laneLen+ref], pass != 0)
+	}
+}
+
+// argon2G is the compression function: out = P(x ^ y) ^ x ^ y, XORed into
+// out's previous content when xor is set (passes after the first).
+func argon2G(out, x, y *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	q = r
+	for i := 0; i < 8; i++ {
+		b := q[16*i : 16*i+16]
+		argon2P(&b[0], &b[1], &b[2], &b[3], &b[4], &b[5], &b[6], &b[7],
+			&b[8], &b[9], &b[10], &b[11], &b[12], &b[13], &b[14], &b[15])
+	}
+	for i := 0; i < 8; i++ {
+		c := 2 * i
+		argon2P(&q[c], &q[c+1], &q[c+16], &q[c+17], &q[c+32], &q[c+33], &q[c+48], &q[c+49],
+			&q[c+64], &q[c+65], &q[c+80], &q[c+81], &q[c+96], &q[c+97], &q[c+112], &q[c+113])
+	}
+	if xor {
+		for i := range out {
+			out[i] ^= q[i] ^ r[i]
+		}
+		return
+	}
+	for i := range out {
+		out[i] = q[i] ^ r[i]
+	}
+}
+
+func argon2P(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	argon2GB(v0, v4, v8, v12)
+	argon2GB(v1, v5, v9, v13)
+	argon2GB(v2, v6, v10, v14)
+	argon2GB(v3, v7, v11, v15)
+	argon2GB(v0, v5, v10, v15)
+	argon2GB(v1, v6, v11, v12)
+	argon2GB(v2, v7, v8, v13)
+	argon2GB(v3, v4, v9, v14)
+}
+
+func argon2GB(a, b, c, d *uint64) {
+	*a += *b + 2*(*a&0xFFFFFFFF)*(*b&0xFFFFFFFF)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c += *d + 2*(*c&0xFFFFFFFF)*(*d&0xFFFFFFFF)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a += *b + 2*(*a&0xFFFFFFFF)*(*b&0xFFFFFFFF)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c += *d + 2*(*c&0xFFFFFFFF)*(*d&0xFFFFFFFF)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
@@ -0,0 +1,99 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// ErrAuthentication is returned when a CCM tag does not verify: the key is
+// wrong or the wrapped data is damaged.
+var ErrAuthentication = errors.New("message authentication failed")
+
+// OpenCCM decrypts and authenticates ciphertext sealed with AES-CCM (RFC 3610)
+// without associated data. The nonce is 7 to 13 bytes; tag is the encrypted
+// authentication value, 4 to 16 bytes and even.
+func OpenCCM(b cipher.Block, nonce, ciphertext, tag []byte) ([]byte, error) {
+	if err := checkCCM(b, nonce, tag, len(ciphertext)); err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(ciphertext))
+	ccmCTR(b, nonce, 1, plain, ciphertext)
+	want := ccmMAC(b, nonce, len(tag), plain)
+	var got [16]byte
+	ccmCTR(b, nonce, 0, got[:len(tag)], tag)
+	if subtle.ConstantTimeCompare(got[:len(tag)], want[:len(tag)]) != 1 {
+		return nil, ErrAuthentication
+	}
+	return plain, nil
+}
+
+// SealCCM encrypts and authenticates plaintext with AES-CCM, returning the
+// ciphertext and the encrypted tag of tagSize bytes. It is the inverse of
+// OpenCCM.
+func SealCCM(b cipher.Block, nonce, plaintext []byte, tagSize int) (ciphertext, tag []byte, err error) {
+	tag = make([]byte, tagSize)
+	if err := checkCCM(b, nonce, tag, len(plaintext)); err != nil {
+		return nil, nil, err
+	}
+	mac := ccmMAC(b, nonce, tagSize, plaintext)
+	ccmCTR(b, nonce, 0, tag, mac[:tagSize])
+	ciphertext = make([]byte, len(plaintext))
+	ccmCTR(b, nonce, 1, ciphertext, plaintext)
+	return ciphertext, tag, nil
+}
+
+func checkCCM(b cipher.Block, nonce, tag []byte, n int) error {
+	if b.BlockSize() != 16 {
+		return fmt.Errorf("CCM needs a 16-byte block cipher")
+	}
+	if len(nonce) < 7 || len(nonce) > 13 {
+		return fmt.Errorf("CCM nonce is %d bytes, want 7 to 13", len(nonce))
+	}
+	if len(tag) < 4 || len(tag) > 16 || len(tag)%2 != 0 {
+		return fmt.Errorf("CCM tag is %d bytes, want an even size from 4 to 16", len(tag))
+	}
+	if l := 15 - len(nonce); l < 8 && uint64(n) >= 1<<(8*l) {
+		return fmt.Errorf("CCM message of %d bytes is too long for a %d-byte nonce", n, len(nonce))
+	}
+	return nil
+}
+
+// ccmCTR XORs src with the CCM key stream starting at counter block ctr.
+func ccmCTR(b cipher.Block, nonce []byte, ctr uint64, dst, src []byte) {
+	l := 15 - len(nonce)
+	var a, s [16]byte
+	a[0] = byte(l - 1)
+	copy(a[1:], nonce)
+	for i := 0; i < len(src); i += 16 {
+		for j, v := 0, ctr; j < l; j, v = j+1, v>>8 {
+			a[15-j] = byte(v)
+		}
+		b.Encrypt(s[:], a[:])
+		for j := 0; j < 16 && i+j < len(src); j++ {
+			dst[i+j] = src[i+j] ^ s[j]
+		}
+		ctr++
+	}
+}
+
+// ccmMAC computes the CBC-MAC of plaintext: block B0 carries the flags, the
+// nonce and the message length, and the message follows zero-padded.
+func ccmMAC(b cipher.Block, nonce []byte, tagSize int, plaintext []byte) [16]byte {
+	l := 15 - len(nonce)
+	var x [16]byte
+	x[0] = byte((tagSize-2)/2<<3 | (l - 1))
+	copy(x[1:], nonce)
+	for j, v := 0, uint64(len(plaintext)); j < l; j, v = j+1, v>>8 {
+		x[15-j] = byte(v)
+	}
+	b.Encrypt(x[:], x[:])
+	for i := 0; i < len(plaintext); i += 16 {
+		for j := 0; j < 16 && i+j < len(plaintext); j++ {
+			x[j] ^= plaintext[i+j]
+		}
+		b.Encrypt(x[:], x[:])
+	}
+	return x
+}
@@ -0,0 +1,241 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func seq(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Reference values from an independent XTS implementation (OpenSSL).
+func TestXTS(t *testing.T) {
+	plain := append(seq(256), seq(256)...)
+	for _, tc := range []struct {
+		key         int
+		first, last string
+	}{
+		{64, "dbe0e0e854a3505aa1430b2304c01c5c", "08667691f40a539a3c7b4e2f27215678"},
+		{32, "45b81101c28abf286c092f249af231d2", "9bf20e43121265a7ed9d39ff73dcbe65"},
+	} {
+		x, err := NewXTS(seq(tc.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := make([]byte, len(plain))
+		x.Encrypt(ct, plain, 0x123456789)
+		if got := hex.EncodeToString(ct[:16]); got != tc.first {
+			t.Errorf("XTS-%d first block = %s, want %s", tc.key*4, got, tc.first)
+		}
+		if got := hex.EncodeToString(ct[len(ct)-16:]); got != tc.last {
+			t.Errorf("XTS-%d last block = %s, want %s", tc.key*4, got, tc.last)
+		}
+		x.Decrypt(ct, ct, 0x123456789)
+		if !bytes.Equal(ct, plain) {
+			t.Errorf("XTS-%d round trip mismatch", tc.key*4)
+		}
+	}
+	if _, err := NewXTS(seq(16)); err == nil {
+		t.Error("NewXTS accepted a 16-byte key")
+	}
+}
+
+// Single-block vectors and XTS over the VeraCrypt ciphers, with reference
+// values from an independent implementation (libgcrypt) and, for Camellia,
+// RFC 3713 appendix A.
+func TestBlockCiphers(t *testing.T) {
+	rfc := unhex(t, "0123456789abcdeffedcba9876543210")
+	for _, tc := range []struct {
+		name  string
+		new   func([]byte) (cipher.Block, error)
+		key   []byte
+		plain []byte
+		want  string
+	}{
+		{"Serpent-256", NewSerpent, make([]byte, 32), make([]byte, 16), "49672ba898d98df95019180445491089"},
+		{"Serpent-128", NewSerpent, seq(16), seq(32)[16:], "1fe31aba9824fb1b33b42582291388db"},
+		{"Serpent-192", NewSerpent, seq(24), seq(32)[16:], "4cc221270d91e743ca7b9d9e4e1ebe65"},
+		{"Twofish-256", NewTwofish, make([]byte, 32), make([]byte, 16), "57ff739d4dc92c1bd7fc01700cc8216f"},
+		{"Twofish-256", NewTwofish, seq(32), seq(32)[16:], "dcda2557baabbdaf6fd2637d88f6ec63"},
+		{"Twofish-128", NewTwofish, seq(16), seq(32)[16:], "de05a6de0290d44c57c44314086b4463"},
+		{"Camellia-128", NewCamellia, rfc, rfc, "67673138549669730857065648eabe43"},
+		{"Camellia-192", NewCamellia, append(rfc[:16:16], unhex(t, "0011223344556677")...), rfc, "b4993401b3e996f84ee5cee7d79b09b9"},
+		{"Camellia-256", NewCamellia, append(rfc[:16:16], unhex(t, "00112233445566778899aabbccddeeff")...), rfc, "9acc237dff16d76c20ef7c919e3a7509"},
+	} {
+		b, err := tc.new(tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := make([]byte, 16)
+		b.Encrypt(ct, tc.plain)
+		if got := hex.EncodeToString(ct); got != tc.want {
+			t.Errorf("%s = %s, want %s", tc.name, got, tc.want)
+		}
+		b.Decrypt(ct, ct)
+		if !bytes.Equal(ct, tc.plain) {
+			t.Errorf("%s round trip mismatch", tc.name)
+		}
+	}
+
+	plain := append(seq(256), seq(256)...)
+	for _, tc := range []struct {
+		name        string
+		new         func([]byte) (cipher.Block, error)
+		first, last string
+	}{
+		{"Serpent", NewSerpent, "be9da11ed889271c5a2304bfec656292", "7cfc19cc1651cf206a49d2bd8debe20d"},
+		{"Twofish", NewTwofish, "f9eefa68aa6c90d125f59139da69d49a", "f80685bc7bd93258574634b4a4f422fc"},
+		{"Camellia", NewCamellia, "bf9320c6c64f9622abcac5cfa756b0ca", "71c618bf5569d29325b7ab888228219e"},
+	} {
+		k1, _ := tc.new(seq(32))
+		k2, _ := tc.new(seq(64)[32:])
+		x := NewXTSCipher(k1, k2)
+		ct := make([]byte, len(plain))
+		x.Encrypt(ct, plain, 0x123456789)
+		if got := hex.EncodeToString(ct[:16]) + " " + hex.EncodeToString(ct[len(ct)-16:]); got != tc.first+" "+tc.last {
+			t.Errorf("XTS-%s = %s, want %s %s", tc.name, got, tc.first, tc.last)
+		}
+		x.Decrypt(ct, ct, 0x123456789)
+		if !bytes.Equal(ct, plain) {
+			t.Errorf("XTS-%s round trip mismatch", tc.name)
+		}
+	}
+}
+
+// Reference values from independent implementations (Python hashlib for
+// RIPEMD-160 and BLAKE2s, libgcrypt for Whirlpool), at lengths on both sides
+// of the padding boundary.
+func TestHashes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		new  func() hash.Hash
+		want [4]string // "", "abc", 55 × "a", 200 × "x" (first 20 bytes)
+	}{
+		{"RIPEMD-160", NewRIPEMD160, [4]string{
+			"9c1185a5c5e9fc54612808977ee8f548b2258d31", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc",
+			"0d8a8c9063a48576a7c97e9f95253a6e53ff6765", "38c26b47a8a3ab2e3f3c7cba7f223e4938ff5442"}},
+		{"BLAKE2s-256", NewBLAKE2s256, [4]string{
+			"69217a3079908094e11121d042354a7c1f55b648", "508c5e8c327c14e2e1a72ba34eeb452f37458b20",
+			"8265e9235687e0db03e94d2827d2c44f5bcb2c9a", "1b4e3b53c353aa90e148efe4699c5f60665a2cbc"}},
+		{"Whirlpool", NewWhirlpool, [4]string{
+			"19fa61d75522a4669b44e39c1d2e1726c5302321", "4e2448a4c6f486bb16b6562c73b4020bf3043e3a",
+			"d2ad17cc1c7bdf4ad1f4b5c44f01b16e38c189f3", "782c93e49abefc9d161dd23707ce402ea2317d2c"}},
+	} {
+		for i, in := range []string{"", "abc", strings.Repeat("a", 55), strings.Repeat("x", 200)} {
+			h := tc.new()
+			// Split the input to exercise the buffering.
+			h.Write([]byte(in[:len(in)/3]))
+			h.Write([]byte(in[len(in)/3:]))
+			if got := hex.EncodeToString(h.Sum(nil)[:20]); got != tc.want[i] {
+				t.Errorf("%s(%d bytes) = %s..., want %s...", tc.name, len(in), got, tc.want[i])
+			}
+		}
+	}
+}
+
+// Reference value from an independent CCM implementation (OpenSSL), which
+// appends the tag to the ciphertext.
+func TestCCM(t *testing.T) {
+	b, _ := aes.NewCipher(seq(32))
+	nonce := seq(28)[16:]
+	want := unhex(t, "71b1f300624e666764fa97653052be3e26e5b53ef111231e614c2c633cd77113853403edb99a20d5ad164ec764c8ef3d6637b9b2ed24c94c81483bf5")
+	ct, tag, err := SealCCM(b, nonce, seq(44), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := append(ct, tag...); !bytes.Equal(got, want) {
+		t.Fatalf("SealCCM = %x, want %x", got, want)
+	}
+	plain, err := OpenCCM(b, nonce, want[:44], want[44:])
+	if err != nil || !bytes.Equal(plain, seq(44)) {
+		t.Fatalf("OpenCCM = %x, %v", plain, err)
+	}
+	want[3] ^= 1
+	if _, err := OpenCCM(b, nonce, want[:44], want[44:]); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("OpenCCM of damaged ciphertext: err = %v, want ErrAuthentication", err)
+	}
+}
+
+// RFC 3394 section 4.1, and a 256-bit wrap of a 256-bit key from an
+// independent implementation (Python cryptography).
+func TestKeyWrap(t *testing.T) {
+	for _, tc := range []struct {
+		kek, key []byte
+		want     string
+	}{
+		{seq(16), unhex(t, "00112233445566778899AABBCCDDEEFF"), "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"},
+		{seq(32), seq(0x60)[0x40:], "bd2a276ae8c7464c7e8b396674ac6e0e9558c84c6009b3fa413cf06a67a200823e4d720df2419fa9"},
+	} {
+		b, _ := aes.NewCipher(tc.kek)
+		wrapped, err := KeyWrap(b, tc.key)
+		if err != nil || hex.EncodeToString(wrapped) != tc.want {
+			t.Fatalf("KeyWrap = %x, %v, want %s", wrapped, err, tc.want)
+		}
+		key, err := KeyUnwrap(b, wrapped)
+		if err != nil || !bytes.Equal(key, tc.key) {
+			t.Fatalf("KeyUnwrap = %x, %v", key, err)
+		}
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := KeyUnwrap(b, wrapped); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("KeyUnwrap of damaged key: err = %v, want ErrAuthentication", err)
+		}
+	}
+}
+
+// Reference values from an independent BLAKE2b implementation (Python
+// hashlib).
+func TestBlake2b(t *testing.T) {
+	if got := hex.EncodeToString(Blake2b(64)[:16]); got != "786a02f742015903c6c6fd852552d272" {
+		t.Errorf("BLAKE2b-512(\"\") = %s...", got)
+	}
+	if got := hex.EncodeToString(Blake2b(20, seq(100), seq(200)[100:])); got != "b83a5733ce63f2dd8266ea8ec93333d7935142cf" {
+		t.Errorf("BLAKE2b-160(0..199) = %s", got)
+	}
+}
+
+// Test vectors of RFC 9106 section 5.
+func TestArgon2(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	ad := bytes.Repeat([]byte{4}, 12)
+	for _, tc := range []struct {
+		variant int
+		want    string
+	}{
+		{Argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{Argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{Argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	} {
+		got, err := argon2(tc.variant, password, salt, secret, ad, 3, 32, 4, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("Argon2 variant %d = %x, want %s", tc.variant, got, tc.want)
+		}
+	}
+	if _, err := Argon2Key(Argon2id, password, salt, 1, 4, 1, 32); err == nil {
+		t.Error("Argon2Key accepted a memory cost below 8 KiB per lane")
+	}
+}
@@ -0,0 +1,63 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// AES key wrap (RFC 3394) with the default initial value, as APFS wraps its
+// key encryption and volume encryption keys.
+
+var keyWrapIV = [8]byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// KeyUnwrap unwraps ciphertext (n+1 64-bit blocks, n >= 2) under b. An
+// integrity check failure is ErrAuthentication.
+func KeyUnwrap(b cipher.Block, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 24 || len(ciphertext)%8 != 0 {
+		return nil, fmt.Errorf("wrapped key of %d bytes is not a whole number of at least three 64-bit blocks", len(ciphertext))
+	}
+	n := len(ciphertext)/8 - 1
+	var a [8]byte
+	copy(a[:], ciphertext[:8])
+	r := append([]byte(nil), ciphertext[8:]...)
+	var buf [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a[:])^t)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			b.Decrypt(buf[:], buf[:])
+			copy(a[:], buf[:8])
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a[:], keyWrapIV[:]) != 1 {
+		return nil, ErrAuthentication
+	}
+	return r, nil
+}
+
+// KeyWrap wraps plaintext (at least two 64-bit blocks) under b; it is the
+// inverse of KeyUnwrap.
+func KeyWrap(b cipher.Block, plaintext []byte) ([]byte, error) {
+	if len(plaintext) < 16 || len(plaintext)%8 != 0 {
+		return nil, fmt.Errorf("key of %d bytes is not a whole number of at least two 64-bit blocks", len(plaintext))
+	}
+	n := len(plaintext) / 8
+	a := keyWrapIV
+	r := append([]byte(nil), plaintext...)
+	var buf [16]byte
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf[:8], a[:])
+			copy(buf[8:], r[(i-1)*8:i*8])
+			b.Encrypt(buf[:], buf[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	return append(a[:], r...), nil
+}
@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Serpent for VeraCrypt volumes, in the byte order of the reference
+// implementation and every disk encryption tool that uses it: key,
+// plaintext and ciphertext are sequences of little-endian 32-bit words. The
+// S-boxes are applied in bitslice mode straight from their tables.
+
+const serpentPhi = 0x9e3779b9
+
+var serpentSbox = [8][16]byte{
+	{3, 8, 15, 1, 10, 6, 5, 11, 14, 13, 4, 2, 7, 0, 9, 12},
+	{15, 12, 2, 7, 9, 0, 5, 10, 1, 11, 14, 8, 6, 13, 3, 4},
+	{8, 6, 7, 9, 3, 12, 10, 15, 13, 1, 14, 4, 0, 11, 5, 2},
+	{0, 15, 11, 8, 12, 9, 6, 3, 13, 1, 2, 4, 10, 7, 5, 14},
+	{1, 15, 8, 3, 12, 0, 11, 6, 2, 5, 4, 10, 9, 14, 7, 13},
+	{15, 5, 2, 11, 4, 10, 9, 12, 0, 3, 14, 8, 13, 6, 7, 1},
+	{7, 2, 12, 5, 8, 4, 6, 11, 14, 9, 1, 15, 13, 3, 10, 0},
+	{1, 13, 15, 0, 14, 8, 2, 11, 7, 4, 12, 10, 9, 3, 5, 6},
+}
+
+// serpentLeaves holds, for each S-box (then each inverse S-box), output bit
+// and pair of inputs differing only in bit 0, which of 0, x0, ^x0 and all
+// ones that output bit is (see serpentApply).
+var serpentLeaves [16][4][8]uint8
+
+func init() {
+	for i, s := range serpentSbox {
+		var inv [16]byte
+		for x, y := range s {
+			inv[y] = byte(x)
+		}
+		for k := 0; k < 4; k++ {
+			for j := 0; j < 8; j++ {
+				serpentLeaves[i][k][j] = s[2*j]>>k&1<<1 | s[2*j+1]>>k&1
+				serpentLeaves[8+i][k][j] = inv[2*j]>>k&1<<1 | inv[2*j+1]>>k&1
+			}
+		}
+	}
+}
+
+type serpent struct {
+	k [33][4]uint32
+}
+
+// NewSerpent returns a Serpent block cipher with a 16-, 24- or 32-byte key.
+func NewSerpent(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("Serpent key is %d bytes, want 16, 24 or 32", len(key))
+	}
+	// Short keys are padded to 256 bits with a single one bit.
+	var padded [32]byte
+	copy(padded[:], key)
+	if len(key) < 32 {
+		padded[len(key)] = 1
+	}
+	var w [140]uint32
+	for i := 0; i < 8; i++ {
+		w[i] = binary.LittleEndian.Uint32(padded[4*i:])
+	}
+	for i := 8; i < 140; i++ {
+		w[i] = bits.RotateLeft32(w[i-8]^w[i-5]^w[i-3]^w[i-1]^serpentPhi^uint32(i-8), 11)
+	}
+	s := &serpent{}
+	for i := range s.k {
+		copy(s.k[i][:], w[8+4*i:])
+		serpentApply(&s.k[i], &serpentLeaves[(35-i)%8])
+	}
+	return s, nil
+}
+
+func (s *serpent) BlockSize() int { return 16 }
+
+func (s *serpent) Encrypt(dst, src []byte) {
+	var x [4]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(src[4*i:])
+	}
+	for r := 0; r < 32; r++ {
+		serpentXor(&x, &s.k[r])
+		serpentApply(&x, &serpentLeaves[r%8])
+		if r < 31 {
+			serpentLT(&x)
+		}
+	}
+	serpentXor(&x, &s.k[32])
+	for i := range x {
+		binary.LittleEndian.PutUint32(dst[4*i:], x[i])
+	}
+}
+
+func (s *serpent) Decrypt(dst, src []byte) {
+	var x [4]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(src[4*i:])
+	}
+	serpentXor(&x, &s.k[32])
+	for r := 31; r >= 0; r-- {
+		if r < 31 {
+			serpentLTInv(&x)
+		}
+		serpentApply(&x, &serpentLeaves[8+r%8])
+		serpentXor(&x, &s.k[r])
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(dst[4*i:], x[i])
+	}
+}
+
+func serpentXor(x, k *[4]uint32) {
+	for i := range x {
+		x[i] ^= k[i]
+	}
+}
+
+// serpentApply runs an S-box over the 32 nibbles formed by bit j of x[0]
+// (least significant) through bit j of x[3]. Each output bit is a function
+// of the four input words, evaluated as a multiplexer tree: the eight
+// leaves are 0, x[0], ^x[0] or all ones from the S-box's truth table, and
+// x[1], x[2] and x[3] select between them.
+func serpentApply(x *[4]uint32, leaves *[4][8]uint8) {
+	leaf := [4]uint32{0, x[0], ^x[0], ^uint32(0)}
+	x1, x2, x3 := x[1], x[2], x[3]
+	for k := range x {
+		l := &leaves[k]
+		m0 := leaf[l[0]] ^ (leaf[l[0]]^leaf[l[1]])&x1
+		m1 := leaf[l[2]] ^ (leaf[l[2]]^leaf[l[3]])&x1
+		m2 := leaf[l[4]] ^ (leaf[l[4]]^leaf[l[5]])&x1
+		m3 := leaf[l[6]] ^ (leaf[l[6]]^leaf[l[7]])&x1
+		n0 := m0 ^ (m0^m1)&x2
+		n1 := m2 ^ (m2^m3)&x2
+		x[k] = n0 ^ (n0^n1)&x3
+	}
+}
+
+func serpentLT(x *[4]uint32) {
+	x[0] = bits.RotateLeft32(x[0], 13)
+	x[2] = bits.RotateLeft32(x[2], 3)
+	x[1] ^= x[0] ^ x[2]
+	x[3] ^= x[2] ^ x[0]<<3
+	x[1] = bits.RotateLeft32(x[1], 1)
+	x[3] = bits.RotateLeft32(x[3], 7)
+	x[0] ^= x[1] ^ x[3]
+	x[2] ^= x[3] ^ x[1]<<7
+	x[0] = bits.RotateLeft32(x[0], 5)
+	x[2] = bits.RotateLeft32(x[2], 22)
+}
+
+func serpentLTInv(x *[4]uint32) {
+	x[2] = bits.RotateLeft32(x[2], -22)
+	x[0] = bits.RotateLeft32(x[0], -5)
+	x[2] ^= x[3] ^ x[1]<<7
+	x[0] ^= x[1] ^ x[3]
+	x[3] = bits.RotateLeft32(x[3], -7)
+	x[1] = bits.RotateLeft32(x[1], -1)
+	x[3] ^= x[2] ^ x[0]<<3
+	x[1] ^= x[0] ^ x[2]
+	x[2] = bits.RotateLeft32(x[2], -3)
+	x[0] = bits.RotateLeft32(x[0], -13)
+}
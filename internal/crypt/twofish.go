@@ -0,0 +1,203 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Twofish for VeraCrypt volumes. The key-dependent S-boxes are folded with
+// the MDS matrix into four 256-entry word tables at key setup, as the
+// specification suggests for software implementations.
+
+type twofish struct {
+	s [4][256]uint32
+	k [40]uint32
+}
+
+var twofishQT = [2][4][16]byte{
+	{
+		{8, 1, 7, 13, 6, 15, 3, 2, 0, 11, 5, 9, 14, 12, 10, 4},
+		{14, 12, 11, 8, 1, 2, 3, 5, 15, 4, 10, 6, 7, 0, 9, 13},
+		{11, 10, 5, 14, 6, 13, 9, 0, 12, 8, 15, 3, 2, 4, 7, 1},
+		{13, 7, 15, 4, 1, 2, 6, 14, 9, 11, 3, 0, 8, 5, 12, 10},
+	},
+	{
+		{2, 8, 11, 13, 15, 7, 6, 14, 3, 1, 9, 4, 0, 10, 12, 5},
+		{1, 14, 2, 11, 4, 12, 3, 7, 6, 13, 10, 5, 15, 9, 0, 8},
+		{4, 12, 7, 5, 1, 6, 9, 10, 0, 14, 13, 8, 2, 11, 3, 15},
+		{11, 9, 5, 1, 12, 3, 13, 14, 6, 4, 7, 15, 2, 0, 8, 10},
+	},
+}
+
+// twofishQ holds the fixed permutations q0 and q1.
+var twofishQ [2][256]byte
+
+var twofishMDS = [4][4]byte{
+	{0x01, 0xef, 0x5b, 0x5b},
+	{0x5b, 0xef, 0xef, 0x01},
+	{0xef, 0x5b, 0x01, 0xef},
+	{0xef, 0x01, 0xef, 0x5b},
+}
+
+var twofishRS = [4][8]byte{
+	{0x01, 0xa4, 0x55, 0x87, 0x5a, 0x58, 0xdb, 0x9e},
+	{0xa4, 0x56, 0x82, 0xf3, 0x1e, 0xc6, 0x68, 0xe5},
+	{0x02, 0xa1, 0xfc, 0xc1, 0x47, 0xae, 0x3d, 0x19},
+	{0xa4, 0x55, 0x87, 0x5a, 0x58, 0xdb, 0x9e, 0x03},
+}
+
+func init() {
+	ror4 := func(x byte) byte { return (x>>1 | x<<3) & 15 }
+	for q, t := range twofishQT {
+		for x := 0; x < 256; x++ {
+			a, b := byte(x>>4), byte(x&15)
+			a, b = a^b, (a^ror4(b)^a<<3)&15
+			a, b = t[0][a], t[1][b]
+			a, b = a^b, (a^ror4(b)^a<<3)&15
+			a, b = t[2][a], t[3][b]
+			twofishQ[q][x] = b<<4 | a
+		}
+	}
+}
+
+// gfMul multiplies in GF(2^8) reduced by the low byte of poly.
+func gfMul(a, b byte, poly byte) byte {
+	var p byte
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= poly
+		}
+	}
+	return p
+}
+
+// twofishH is the function h of the specification: the byte-wise q
+// permutations keyed by the words in l, followed by the MDS matrix.
+func twofishH(x uint32, l []uint32) uint32 {
+	var z uint32
+	for j := 0; j < 4; j++ {
+		z ^= twofishMDSColumn(twofishQChain(j, byte(x>>(8*j)), l), j)
+	}
+	return z
+}
+
+// twofishQChain runs byte j of h's input through its chain of q
+// permutations, each followed by the matching byte of a key word.
+func twofishQChain(j int, y byte, l []uint32) byte {
+	// Which of q0/q1 each stage uses for byte j, outermost stage first.
+	order := [4][5]int{
+		{1, 0, 0, 1, 1},
+		{0, 0, 1, 1, 0},
+		{1, 1, 0, 0, 0},
+		{0, 1, 1, 0, 1},
+	}[j]
+	k := len(l)
+	stages := order[:k+1]
+	y = twofishQ[stages[k]][y]
+	for i := k - 1; i >= 0; i-- {
+		y = twofishQ[stages[i]][y^byte(l[i]>>(8*j))]
+	}
+	return y
+}
+
+// twofishMDSColumn multiplies b by column j of the MDS matrix.
+func twofishMDSColumn(b byte, j int) uint32 {
+	var z uint32
+	for i := 0; i < 4; i++ {
+		z |= uint32(gfMul(twofishMDS[i][j], b, 0x69)) << (8 * i)
+	}
+	return z
+}
+
+// NewTwofish returns a Twofish block cipher with a 16-, 24- or 32-byte key.
+func NewTwofish(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("Twofish key is %d bytes, want 16, 24 or 32", len(key))
+	}
+	k := len(key) / 8
+	me := make([]uint32, k)
+	mo := make([]uint32, k)
+	sv := make([]uint32, k)
+	for i := 0; i < k; i++ {
+		me[i] = binary.LittleEndian.Uint32(key[8*i:])
+		mo[i] = binary.LittleEndian.Uint32(key[8*i+4:])
+		var w uint32
+		for r := 0; r < 4; r++ {
+			var b byte
+			for c := 0; c < 8; c++ {
+				b ^= gfMul(twofishRS[r][c], key[8*i+c], 0x4d)
+			}
+			w |= uint32(b) << (8 * r)
+		}
+		sv[k-1-i] = w
+	}
+
+	t := &twofish{}
+	const rho = 0x01010101
+	for i := 0; i < 20; i++ {
+		a := twofishH(uint32(2*i)*rho, me)
+		b := bits.RotateLeft32(twofishH(uint32(2*i+1)*rho, mo), 8)
+		t.k[2*i] = a + b
+		t.k[2*i+1] = bits.RotateLeft32(a+2*b, 9)
+	}
+	for x := 0; x < 256; x++ {
+		for j := 0; j < 4; j++ {
+			t.s[j][x] = twofishMDSColumn(twofishQChain(j, byte(x), sv), j)
+		}
+	}
+	return t, nil
+}
+
+func (t *twofish) BlockSize() int { return 16 }
+
+// g is the keyed function g of the specification. Each input byte passes
+// through its own keyed S-box before the MDS matrix, so g splits into the
+// four precomputed tables.
+func (t *twofish) g(x uint32) uint32 {
+	return t.s[0][byte(x)] ^ t.s[1][byte(x>>8)] ^ t.s[2][byte(x>>16)] ^ t.s[3][byte(x>>24)]
+}
+
+func (t *twofish) Encrypt(dst, src []byte) {
+	var r [4]uint32
+	for i := range r {
+		r[i] = binary.LittleEndian.Uint32(src[4*i:]) ^ t.k[i]
+	}
+	for n := 0; n < 16; n++ {
+		t0 := t.g(r[0])
+		t1 := t.g(bits.RotateLeft32(r[1], 8))
+		f0 := t0 + t1 + t.k[2*n+8]
+		f1 := t0 + 2*t1 + t.k[2*n+9]
+		r[0], r[1], r[2], r[3] = bits.RotateLeft32(r[2]^f0, -1), bits.RotateLeft32(r[3], 1)^f1, r[0], r[1]
+	}
+	for i := range r {
+		binary.LittleEndian.PutUint32(dst[4*i:], r[(i+2)%4]^t.k[i+4])
+	}
+}
+
+func (t *twofish) Decrypt(dst, src []byte) {
+	var r [4]uint32
+	for i := range r {
+		r[(i+2)%4] = binary.LittleEndian.Uint32(src[4*i:]) ^ t.k[i+4]
+	}
+	for n := 15; n >= 0; n-- {
+		r[0], r[1], r[2], r[3] = r[2], r[3], r[0], r[1]
+		t0 := t.g(r[0])
+		t1 := t.g(bits.RotateLeft32(r[1], 8))
+		f0 := t0 + t1 + t.k[2*n+8]
+		f1 := t0 + 2*t1 + t.k[2*n+9]
+		r[2] = bits.RotateLeft32(r[2], 1) ^ f0
+		r[3] = bits.RotateLeft32(r[3]^f1, -1)
+	}
+	for i := range r {
+		binary.LittleEndian.PutUint32(dst[4*i:], r[i]^t.k[i])
+	}
+}
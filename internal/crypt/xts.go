@@ -0,0 +1,88 @@
+// Package crypt holds the block-cipher modes the volume decryption layers
+// share and the standard library does not provide: XTS (IEEE 1619) for
+// BitLocker, LUKS, VeraCrypt and APFS sector encryption, CCM (RFC 3610)
+// for BitLocker key wrapping, AES key wrap (RFC 3394) for APFS keybags,
+// and the Argon2 key derivation (with the BLAKE2b it is built on) of LUKS2
+// key slots. It also carries the non-AES ciphers (Serpent, Twofish,
+// Camellia) and header key derivation hashes (RIPEMD-160, Whirlpool,
+// BLAKE2s) that VeraCrypt volumes use. Everything is built on the
+// standard library; there is no dependency outside it.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// XTS is an XTS cipher (IEEE 1619-2007) over whole data units. The data
+// unit number, little-endian, is the tweak; ciphertext stealing is not
+// implemented because every sector size in use is a multiple of 16 bytes.
+type XTS struct {
+	k1, k2 cipher.Block
+}
+
+// NewXTS builds an XTS-AES cipher from a double-length key: 32 bytes for
+// XTS-AES-128, 64 bytes for XTS-AES-256. The first half encrypts the data,
+// the second half the tweak.
+func NewXTS(key []byte) (*XTS, error) {
+	if len(key) != 32 && len(key) != 64 {
+		return nil, fmt.Errorf("XTS key is %d bytes, want 32 or 64", len(key))
+	}
+	k1, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	k2, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &XTS{k1: k1, k2: k2}, nil
+}
+
+// NewXTSCipher builds XTS over any 128-bit block cipher: k1 encrypts the
+// data, k2 the tweak.
+func NewXTSCipher(k1, k2 cipher.Block) *XTS {
+	return &XTS{k1: k1, k2: k2}
+}
+
+// Decrypt decrypts one data unit src into dst (which may alias src). len(src)
+// must be a non-zero multiple of 16.
+func (x *XTS) Decrypt(dst, src []byte, unit uint64) {
+	x.crypt(dst, src, unit, x.k1.Decrypt)
+}
+
+// Encrypt encrypts one data unit src into dst (which may alias src).
+func (x *XTS) Encrypt(dst, src []byte, unit uint64) {
+	x.crypt(dst, src, unit, x.k1.Encrypt)
+}
+
+func (x *XTS) crypt(dst, src []byte, unit uint64, fn func(dst, src []byte)) {
+	if len(src) == 0 || len(src)%16 != 0 || len(dst) < len(src) {
+		panic("crypt: XTS data unit is not a whole number of blocks")
+	}
+	var tweak, block [16]byte
+	binary.LittleEndian.PutUint64(tweak[:8], unit)
+	x.k2.Encrypt(tweak[:], tweak[:])
+	for i := 0; i < len(src); i += 16 {
+		for j := 0; j < 16; j++ {
+			block[j] = src[i+j] ^ tweak[j]
+		}
+		fn(block[:], block[:])
+		for j := 0; j < 16; j++ {
+			dst[i+j] = block[j] ^ tweak[j]
+		}
+		mulAlpha(&tweak)
+	}
+}
+
+// mulAlpha multiplies the tweak by the primitive element α of GF(2^128),
+// little-endian byte order, reduction polynomial x^128 + x^7 + x^2 + x + 1.
+func mulAlpha(t *[16]byte) {
+	carry := t[15] >> 7
+	for i := 15; i > 0; i-- {
+		t[i] = t[i]<<1 | t[i-1]>>7
+	}
+	t[0] = t[0]<<1 ^ 0x87*carry
+}
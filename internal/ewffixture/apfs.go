@@ -0,0 +1,372 @@
+package ewffixture
+
+import (
+	"crypto/aes"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+)
+
+// APFSFile is a regular file in the root directory of an APFSVolume.
+type APFSFile struct {
+	Name string
+	Data []byte
+}
+
+// APFSVolume is one volume of an APFSContainer.
+type APFSVolume struct {
+	Name  string
+	Role  uint16 // apfs_role, e.g. 0x40 for Data
+	Files []APFSFile
+	// Passwords and RecoveryKey make the volume FileVault-encrypted under
+	// APFSVEK: each gets a KEK record in the volume keybag.
+	Passwords   []string
+	RecoveryKey string
+}
+
+// APFSContainer assembles an APFS container with 4 KiB blocks: a single
+// checkpoint, one object map per volume and a single catalog leaf per
+// volume. Encrypted volumes are laid out as macOS writes software FileVault:
+// catalog and file data encrypted with XTS-AES-128 under the VEK, the VEK
+// wrapped in the container keybag under a KEK, and the KEK wrapped under
+// each password in the volume keybag. Key blob HMACs are left zero.
+type APFSContainer struct {
+	Volumes []APFSVolume
+}
+
+const (
+	apfsBlock      = 4096
+	apfsIterations = 1000
+	apfsCatalogOID = 0x402
+)
+
+// apfsRecoveryKeyUUID is the keybag UUID of the personal recovery key.
+var apfsRecoveryKeyUUID = []byte{0xEB, 0xC6, 0xC0, 0x64, 0x00, 0x00, 0x11, 0xAA, 0xAA, 0x11, 0x00, 0x30, 0x65, 0x43, 0xEC, 0xAC}
+
+// APFSVEK returns the volume encryption key of volume i of a container.
+func APFSVEK(i int) []byte {
+	k := make([]byte, 32)
+	for j := range k {
+		k[j] = byte(0xC3 ^ j*11 ^ i*53)
+	}
+	return k
+}
+
+// apfsUUID returns a UUID that is distinct for each kind and index.
+func apfsUUID(kind byte, i int) []byte {
+	u := make([]byte, 16)
+	for j := range u {
+		u[j] = byte(j*17) ^ kind
+	}
+	u[15] = byte(i)
+	return u
+}
+
+// Build returns the container image.
+func (c APFSContainer) Build() []byte {
+	le := binary.LittleEndian
+	var blocks [][]byte
+	alloc := func(n int) int {
+		b := len(blocks)
+		for i := 0; i < n; i++ {
+			blocks = append(blocks, make([]byte, apfsBlock))
+		}
+		return b
+	}
+	// Block 0 and the one-block checkpoint descriptor area hold the NXSB;
+	// then the container object map and keybag.
+	nx, desc, omap, omapTree, keybag := alloc(1), alloc(1), alloc(1), alloc(1), alloc(1)
+	containerUUID := apfsUUID(0x10, 0)
+
+	var omapPairs [][2]uint64
+	var ckbEntries [][]byte
+	for i, v := range c.Volumes {
+		volUUID := apfsUUID(0x20, i)
+		sb, vomap, vtree, catalog := alloc(1), alloc(1), alloc(1), alloc(1)
+		oid := uint64(0x400 + 4*i)
+		omapPairs = append(omapPairs, [2]uint64{oid, uint64(sb)})
+		crypted := len(v.Passwords) > 0 || v.RecoveryKey != ""
+		// Catalog nodes use their address as the XTS tweak, extents their
+		// crypto_id, which is the address they were first written at.
+		encrypt := func(b int) {
+			if !crypted {
+				return
+			}
+			x, err := crypt.NewXTS(APFSVEK(i))
+			if err != nil {
+				panic(err)
+			}
+			apfsXTS(x, blocks[b], b)
+		}
+
+		// Root directory and one inode, directory record and extent per file.
+		keys := [][]byte{apfsKey(3, 2)}
+		vals := [][]byte{apfsInode(0, 0o40755)}
+		for j, f := range v.Files {
+			ino := uint64(16 + j)
+			n := (len(f.Data) + apfsBlock - 1) / apfsBlock
+			data := alloc(n)
+			for k := 0; k < n; k++ {
+				copy(blocks[data+k], f.Data[min(k*apfsBlock, len(f.Data)):])
+				encrypt(data + k)
+			}
+			name := append([]byte(f.Name), 0)
+			dk := apfsKey(9, 2, le.AppendUint32(nil, uint32(len(name)))...)
+			dv := make([]byte, 18)
+			le.PutUint64(dv, ino)
+			le.PutUint16(dv[16:], 8) // DT_REG
+			ek := apfsKey(8, ino, make([]byte, 8)...)
+			ev := make([]byte, 24) // len_and_flags, phys_block_num, crypto_id
+			le.PutUint64(ev, uint64(n*apfsBlock))
+			le.PutUint64(ev[8:], uint64(data))
+			le.PutUint64(ev[16:], uint64(data))
+			keys = append(keys, append(dk, name...), apfsKey(3, ino), ek)
+			vals = append(vals, dv, apfsInode(uint64(len(f.Data)), 0o100644), ev)
+		}
+		apfsCatalogLeaf(blocks[catalog], keys, vals)
+		encrypt(catalog)
+
+		s := blocks[sb]
+		le.PutUint64(s[0x10:], 1)
+		le.PutUint32(s[0x18:], 0x0d) // OBJECT_TYPE_FS
+		copy(s[0x20:], "APSB")
+		le.PutUint32(s[0x24:], uint32(i))
+		le.PutUint64(s[0x80:], uint64(vomap))
+		le.PutUint64(s[0x88:], apfsCatalogOID)
+		copy(s[0xF0:], volUUID)
+		if crypted {
+			le.PutUint64(s[0x108:], 0x8) // APFS_FS_ONEKEY
+		} else {
+			le.PutUint64(s[0x108:], 0x1) // APFS_FS_UNENCRYPTED
+		}
+		copy(s[0x2C0:], v.Name)
+		le.PutUint16(s[0x3C4:], v.Role)
+		apfsChecksum(s)
+		le.PutUint64(blocks[vomap][0x30:], uint64(vtree))
+		apfsOmapLeaf(blocks[vtree], [][2]uint64{{apfsCatalogOID, uint64(catalog)}})
+
+		if !crypted {
+			continue
+		}
+		// The KEK wraps the VEK (container keybag); each password wraps the
+		// KEK (volume keybag, encrypted under the volume UUID).
+		kek := APFSVEK(i + 100)
+		vekBlob := apfsKeyBlob(volUUID, apfsWrap(kek, APFSVEK(i)), nil, 0)
+		vkb := alloc(1)
+		var records [][]byte
+		kekRecord := func(uuid []byte, pw string, n int) {
+			salt := apfsUUID(0x30, i*16+n)
+			dk, err := pbkdf2.Key(sha256.New, pw, salt, apfsIterations, 32)
+			if err != nil {
+				panic(err)
+			}
+			records = append(records, apfsKeybagEntry(uuid, 3, apfsKeyBlob(uuid, apfsWrap(dk, kek), salt, apfsIterations)))
+		}
+		for n, pw := range v.Passwords {
+			kekRecord(apfsUUID(0x40, n), pw, n)
+		}
+		if v.RecoveryKey != "" {
+			kekRecord(apfsRecoveryKeyUUID, v.RecoveryKey, 15)
+		}
+		apfsKeybag(blocks[vkb], 0x72656373, records) // 'recs'
+		apfsEncrypt(blocks[vkb], volUUID, vkb)
+		prange := le.AppendUint64(le.AppendUint64(nil, uint64(vkb)), 1)
+		ckbEntries = append(ckbEntries, apfsKeybagEntry(volUUID, 2, vekBlob), apfsKeybagEntry(volUUID, 3, prange))
+	}
+
+	for _, b := range []int{nx, desc} {
+		s := blocks[b]
+		le.PutUint64(s[0x10:], 1)
+		le.PutUint32(s[0x18:], 0x80000001) // NX_SUPERBLOCK
+		copy(s[0x20:], "NXSB")
+		le.PutUint32(s[0x24:], apfsBlock)
+		le.PutUint64(s[0x28:], uint64(len(blocks)))
+		copy(s[0x48:], containerUUID)
+		le.PutUint64(s[0x60:], 2) // next_xid
+		le.PutUint32(s[0x68:], 1) // xp_desc_blocks
+		le.PutUint64(s[0x70:], uint64(desc))
+		le.PutUint64(s[0xA0:], uint64(omap))
+		le.PutUint32(s[0xB4:], uint32(len(c.Volumes)))
+		for i, p := range omapPairs {
+			le.PutUint64(s[0xB8+8*i:], p[0])
+		}
+		if ckbEntries != nil {
+			le.PutUint64(s[0x510:], uint64(keybag))
+			le.PutUint64(s[0x518:], 1)
+		}
+		apfsChecksum(s)
+	}
+	le.PutUint64(blocks[omap][0x30:], uint64(omapTree))
+	apfsOmapLeaf(blocks[omapTree], omapPairs)
+	if ckbEntries != nil {
+		apfsKeybag(blocks[keybag], 0x6b657973, ckbEntries) // 'keys'
+		apfsEncrypt(blocks[keybag], containerUUID, keybag)
+	}
+
+	out := make([]byte, 0, len(blocks)*apfsBlock)
+	for _, b := range blocks {
+		out = append(out, b...)
+	}
+	return out
+}
+
+// apfsKey builds a catalog key: obj_id_and_type and the record's tail.
+func apfsKey(typ, id uint64, tail ...byte) []byte {
+	return append(binary.LittleEndian.AppendUint64(nil, typ<<60|id), tail...)
+}
+
+// apfsInode builds a j_inode_val_t without extended fields.
+func apfsInode(size uint64, mode uint16) []byte {
+	v := make([]byte, 92)
+	binary.LittleEndian.PutUint64(v[8:], 1) // private_id
+	binary.LittleEndian.PutUint16(v[80:], mode)
+	binary.LittleEndian.PutUint64(v[84:], size)
+	return v
+}
+
+// apfsCatalogLeaf writes a root leaf node of the catalog (a variable-size
+// key/value B-tree node) into b.
+func apfsCatalogLeaf(b []byte, keys, vals [][]byte) {
+	le := binary.LittleEndian
+	le.PutUint64(b[0x10:], 1)
+	le.PutUint32(b[0x18:], 0x2) // OBJECT_TYPE_BTREE
+	le.PutUint32(b[0x1C:], 0xE) // OBJECT_TYPE_FSTREE
+	le.PutUint16(b[0x20:], 0x3) // BTNODE_ROOT | BTNODE_LEAF
+	le.PutUint32(b[0x24:], uint32(len(keys)))
+	table := 8 * len(keys)
+	le.PutUint16(b[0x2A:], uint16(table))
+	koff, vpos := 0, apfsBlock-40 // values end at the btree_info footer
+	for i := range keys {
+		toc := b[0x38+8*i:]
+		vpos -= len(vals[i])
+		le.PutUint16(toc, uint16(koff))
+		le.PutUint16(toc[2:], uint16(len(keys[i])))
+		le.PutUint16(toc[4:], uint16(apfsBlock-40-vpos))
+		le.PutUint16(toc[6:], uint16(len(vals[i])))
+		copy(b[0x38+table+koff:], keys[i])
+		copy(b[vpos:], vals[i])
+		koff += len(keys[i])
+	}
+	apfsChecksum(b)
+}
+
+// apfsOmapLeaf writes a root leaf node of an object map (fixed-size
+// {oid, xid} -> {flags, size, paddr} entries) into b.
+func apfsOmapLeaf(b []byte, pairs [][2]uint64) {
+	le := binary.LittleEndian
+	le.PutUint64(b[0x10:], 1)
+	le.PutUint32(b[0x18:], 0x40000002) // OBJ_PHYSICAL | OBJECT_TYPE_BTREE
+	le.PutUint32(b[0x1C:], 0xB)        // OBJECT_TYPE_OMAP
+	le.PutUint16(b[0x20:], 0x7)        // BTNODE_ROOT | BTNODE_LEAF | BTNODE_FIXED_KV_SIZE
+	le.PutUint32(b[0x24:], uint32(len(pairs)))
+	table := 4 * len(pairs)
+	le.PutUint16(b[0x2A:], uint16(table))
+	for i, p := range pairs {
+		le.PutUint16(b[0x38+4*i:], uint16(16*i))
+		le.PutUint16(b[0x38+4*i+2:], uint16(16*(i+1)))
+		le.PutUint64(b[0x38+table+16*i:], p[0])
+		le.PutUint64(b[0x38+table+16*i+8:], 1) // xid
+		v := b[apfsBlock-40-16*(i+1):]
+		le.PutUint32(v[4:], apfsBlock)
+		le.PutUint64(v[8:], p[1])
+	}
+	apfsChecksum(b)
+}
+
+// apfsKeybag writes a keybag object of type typ with the given encoded
+// entries into b.
+func apfsKeybag(b []byte, typ uint32, entries [][]byte) {
+	le := binary.LittleEndian
+	le.PutUint64(b[0x10:], 1)
+	le.PutUint32(b[0x18:], typ)
+	le.PutUint16(b[0x20:], 2) // APFS_KEYBAG_VERSION
+	le.PutUint16(b[0x22:], uint16(len(entries)))
+	off := 0x30
+	for _, e := range entries {
+		off += copy(b[off:], e)
+	}
+	le.PutUint32(b[0x24:], uint32(off-0x30))
+	apfsChecksum(b)
+}
+
+// apfsKeybagEntry encodes a keybag_entry_t padded to 16 bytes.
+func apfsKeybagEntry(uuid []byte, tag uint16, data []byte) []byte {
+	e := make([]byte, (24+len(data)+15)&^15)
+	copy(e, uuid)
+	binary.LittleEndian.PutUint16(e[16:], tag)
+	binary.LittleEndian.PutUint16(e[18:], uint16(len(data)))
+	copy(e[24:], data)
+	return e
+}
+
+// apfsKeyBlob encodes the DER blob of a wrapped KEK (with its PBKDF2 salt
+// and iterations) or, without them, of a wrapped VEK.
+func apfsKeyBlob(uuid, wrapped, salt []byte, iterations int) []byte {
+	inner := append(der(0x80, []byte{0}), der(0x81, uuid)...)
+	inner = append(inner, der(0x82, make([]byte, 8))...)
+	inner = append(inner, der(0x83, wrapped)...)
+	if salt != nil {
+		it := binary.BigEndian.AppendUint32(nil, uint32(iterations))
+		inner = append(inner, der(0x84, it)...)
+		inner = append(inner, der(0x85, salt)...)
+	}
+	outer := append(der(0x80, []byte{0}), der(0x81, make([]byte, 32))...)
+	outer = append(outer, der(0x82, make([]byte, 8))...)
+	outer = append(outer, der(0xA3, inner)...)
+	return der(0x30, outer)
+}
+
+// der encodes a DER element with a definite length.
+func der(tag byte, v []byte) []byte {
+	switch {
+	case len(v) < 0x80:
+		return append([]byte{tag, byte(len(v))}, v...)
+	case len(v) < 0x100:
+		return append([]byte{tag, 0x81, byte(len(v))}, v...)
+	}
+	return append([]byte{tag, 0x82, byte(len(v) >> 8), byte(len(v))}, v...)
+}
+
+// apfsWrap wraps key under kek with AES key wrap.
+func apfsWrap(kek, key []byte) []byte {
+	b, err := aes.NewCipher(kek)
+	if err != nil {
+		panic(err)
+	}
+	w, err := crypt.KeyWrap(b, key)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// apfsEncrypt encrypts keybag block b at paddr with XTS keyed by uuid.
+func apfsEncrypt(b, uuid []byte, paddr int) {
+	x, err := crypt.NewXTS(append(append([]byte(nil), uuid...), uuid...))
+	if err != nil {
+		panic(err)
+	}
+	apfsXTS(x, b, paddr)
+}
+
+// apfsXTS encrypts block b with block tweak t in 512-byte units.
+func apfsXTS(x *crypt.XTS, b []byte, t int) {
+	for i := 0; i < apfsBlock; i += 512 {
+		x.Encrypt(b[i:i+512], b[i:i+512], uint64(t*apfsBlock/512+i/512))
+	}
+}
+
+// apfsChecksum stores the Fletcher-64 checksum of object b.
+func apfsChecksum(b []byte) {
+	const m = 0xFFFFFFFF
+	var s1, s2 uint64
+	for i := 8; i < len(b); i += 4 {
+		s1 = (s1 + uint64(binary.LittleEndian.Uint32(b[i:]))) % m
+		s2 = (s2 + s1) % m
+	}
+	c1 := m - (s1+s2)%m
+	c2 := m - (s1+c1)%m
+	binary.LittleEndian.PutUint64(b, c2<<32|c1)
+}
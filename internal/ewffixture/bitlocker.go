@@ -0,0 +1,289 @@
+package ewffixture
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/bitlocker"
+	"github.com/laenix/ewfgo/internal/crypt"
+)
+
+// BitLocker encrypts a filesystem image into a BitLocker volume with the
+// protectors selected by its fields.
+type BitLocker struct {
+	Method           uint16 // bitlocker.Method*; default AES-XTS 128
+	Vista            bool   // Vista layout: NTFS boot region in the clear, one metadata block
+	RecoveryPassword string // adds a recovery-password protector
+	Password         string // adds a password protector
+	StartupKey       bool   // adds a startup-key protector; Build returns its .BEK file
+	ClearKey         bool   // adds a clear-key protector (protection suspended)
+	EncryptedSize    uint64 // bytes encrypted from the start (paused conversion); 0 = all
+	Description      string
+	FVEK             []byte // key data as stored in the FVEK entry; default a fixed pattern
+}
+
+// fveSectors is the number of sectors a Windows 7 volume relocates.
+const fveSectors = 16
+
+// BitLockerFVEK returns the default FVEK entry key data for method.
+func BitLockerFVEK(method uint16) []byte {
+	n := 64
+	switch method {
+	case bitlocker.MethodAES128CBC:
+		n = 16
+	case bitlocker.MethodAES256CBC, bitlocker.MethodAES128XTS:
+		n = 32
+	}
+	k := make([]byte, n)
+	for i := range k {
+		k[i] = byte(0xA5 ^ i*29)
+	}
+	return k
+}
+
+// Build encrypts plain, a sector-aligned filesystem image, and returns the
+// BitLocker volume and, with StartupKey, the .BEK file. The FVE metadata
+// blocks take 64 KiB each from 256 KiB before the end of the volume; on
+// Windows 7 and later the relocated boot sectors follow at 64 KiB before the
+// end. Those areas of plain must be unused (zero).
+func (b BitLocker) Build(plain []byte) (vol, bek []byte) {
+	if b.Method == 0 {
+		b.Method = bitlocker.MethodAES128XTS
+	}
+	if b.FVEK == nil {
+		b.FVEK = BitLockerFVEK(b.Method)
+	}
+	size := uint64(len(plain))
+	blocks := []uint64{size - 0x40000, size - 0x30000, size - 0x20000}
+	hdrOff := size - 0x10000
+	if b.Vista {
+		blocks = blocks[:1]
+	}
+	for _, off := range blocks {
+		for _, c := range plain[off : off+0x10000] {
+			if c != 0 {
+				panic("ewffixture: BitLocker metadata area is in use by the filesystem")
+			}
+		}
+	}
+	if !b.Vista {
+		for _, c := range plain[hdrOff : hdrOff+fveSectors*512] {
+			if c != 0 {
+				panic("ewffixture: BitLocker relocated header area is in use by the filesystem")
+			}
+		}
+	}
+
+	key, err := bitlocker.NormalizeFVEK(b.Method, b.FVEK)
+	if err != nil {
+		panic(err)
+	}
+	c, err := bitlocker.NewCipher(b.Method, key, 512)
+	if err != nil {
+		panic(err)
+	}
+	limit := size
+	if b.EncryptedSize != 0 {
+		limit = b.EncryptedSize
+	}
+	inBlock := func(off uint64) bool {
+		for _, o := range blocks {
+			if off >= o && off < o+0x10000 {
+				return true
+			}
+		}
+		return false
+	}
+	vol = append([]byte(nil), plain...)
+	for off := uint64(0); off < limit; off += 512 {
+		if (b.Vista && off < 8192) || inBlock(off) {
+			continue
+		}
+		c.Encrypt(vol[off:off+512], vol[off:off+512], off)
+	}
+	var mirror uint64
+	if b.Vista {
+		boot := vol[:512]
+		mirror = binary.LittleEndian.Uint64(boot[0x38:])
+		cluster := uint64(boot[13]) * 512
+		copy(boot[3:11], bitlocker.Signature)
+		binary.LittleEndian.PutUint64(boot[0x38:], blocks[0]/cluster)
+	} else {
+		for off := uint64(0); off < fveSectors*512; off += 512 {
+			c.Encrypt(vol[hdrOff+off:hdrOff+off+512], plain[off:off+512], hdrOff+off)
+		}
+		clear(vol[:fveSectors*512])
+		copy(vol, bitLockerVolumeHeader(blocks, size))
+	}
+
+	vmk := make([]byte, 32)
+	for i := range vmk {
+		vmk[i] = byte(0x3C + i*7)
+	}
+	var nonce uint32
+	wrap := func(k, data []byte) []byte {
+		nonce++
+		n := make([]byte, 12)
+		binary.LittleEndian.PutUint64(n, 0x01DB4E5C00000000)
+		binary.LittleEndian.PutUint32(n[8:], nonce)
+		blk, err := aes.NewCipher(k)
+		if err != nil {
+			panic(err)
+		}
+		ct, tag, err := crypt.SealCCM(blk, n, data, 16)
+		if err != nil {
+			panic(err)
+		}
+		return fveEntry(0, 5, append(append(n, tag...), ct...))
+	}
+
+	var entries []byte
+	if b.Description != "" {
+		entries = append(entries, fveEntry(7, 2, fveUTF16(b.Description))...)
+	}
+	salt := func(i byte) []byte {
+		s := make([]byte, 16)
+		for j := range s {
+			s[j] = i*16 + byte(j)
+		}
+		return s
+	}
+	protector := func(id byte, typ uint16, nested ...[]byte) {
+		v := make([]byte, 28)
+		copy(v, fveGUID(id))
+		binary.LittleEndian.PutUint64(v[16:], 0x01DB4E5C00000000)
+		binary.LittleEndian.PutUint16(v[26:], typ)
+		for _, n := range nested {
+			v = append(v, n...)
+		}
+		entries = append(entries, fveEntry(2, 8, v)...)
+	}
+	stretch := func(s []byte) []byte {
+		return fveEntry(0, 3, append([]byte{0x00, 0x10, 0, 0}, s...))
+	}
+	if b.ClearKey {
+		ck := make([]byte, 32)
+		for i := range ck {
+			ck[i] = byte(0x77 ^ i)
+		}
+		protector(1, bitlocker.ProtectorClearKey, fveKey(0x2003, ck), wrap(ck, fveKey(0x2000, vmk)))
+	}
+	if b.RecoveryPassword != "" {
+		s := salt(2)
+		k, err := bitlocker.RecoveryPasswordKey(b.RecoveryPassword, s)
+		if err != nil {
+			panic(err)
+		}
+		protector(2, bitlocker.ProtectorRecoveryPassword, stretch(s), wrap(k, fveKey(0x2000, vmk)))
+	}
+	if b.Password != "" {
+		s := salt(3)
+		k, err := bitlocker.PasswordKey(b.Password, s)
+		if err != nil {
+			panic(err)
+		}
+		protector(3, bitlocker.ProtectorPassword, stretch(s), wrap(k, fveKey(0x2000, vmk)))
+	}
+	if b.StartupKey {
+		sk := make([]byte, 32)
+		for i := range sk {
+			sk[i] = byte(0x11 + i*3)
+		}
+		protector(4, bitlocker.ProtectorStartupKey, wrap(sk, fveKey(0x2000, vmk)))
+		ext := append(append(fveGUID(4), make([]byte, 8)...), fveKey(0x2002, sk)...)
+		bek = fveMetadata(fveEntry(6, 9, ext), b.Method)
+	}
+	fvek := wrap(vmk, fveKey(b.Method, b.FVEK))
+	binary.LittleEndian.PutUint16(fvek[2:], 3) // FVEK entry
+	entries = append(entries, fvek...)
+
+	meta := fveMetadata(entries, b.Method)
+	for _, off := range blocks {
+		h := make([]byte, 64)
+		copy(h, bitlocker.Signature)
+		version := uint16(2)
+		if b.Vista {
+			version = 1
+		}
+		binary.LittleEndian.PutUint16(h[10:], version)
+		binary.LittleEndian.PutUint64(h[16:], limit)
+		if b.Vista {
+			binary.LittleEndian.PutUint64(h[56:], mirror)
+		} else {
+			binary.LittleEndian.PutUint32(h[28:], fveSectors)
+			for i, o := range blocks {
+				binary.LittleEndian.PutUint64(h[32+8*i:], o)
+			}
+			binary.LittleEndian.PutUint64(h[56:], hdrOff)
+		}
+		copy(vol[off:], h)
+		copy(vol[off+64:], meta)
+	}
+	return vol, bek
+}
+
+// bitLockerVolumeHeader builds the Windows 7 volume header: an NTFS-style
+// BPB with OEM name "-FVE-FS-" and the metadata block offsets at 176.
+func bitLockerVolumeHeader(blocks []uint64, size uint64) []byte {
+	h := make([]byte, 512)
+	copy(h, []byte{0xEB, 0x58, 0x90})
+	copy(h[3:], bitlocker.Signature)
+	binary.LittleEndian.PutUint16(h[11:], 512)
+	h[13] = 8
+	h[21] = 0xF8
+	binary.LittleEndian.PutUint32(h[32:], uint32(size/512))
+	copy(h[160:], []byte{0x3B, 0xD6, 0x67, 0x49, 0x29, 0x2E, 0xD8, 0x4A, 0x83, 0x99, 0xF6, 0xA3, 0x39, 0xE3, 0xD0, 0x01})
+	for i, o := range blocks {
+		binary.LittleEndian.PutUint64(h[176+8*i:], o)
+	}
+	h[510], h[511] = 0x55, 0xAA
+	return h
+}
+
+// fveMetadata prefixes entries with a 48-byte metadata header.
+func fveMetadata(entries []byte, method uint16) []byte {
+	m := make([]byte, 48)
+	size := uint32(48 + len(entries))
+	binary.LittleEndian.PutUint32(m[0:], size)
+	binary.LittleEndian.PutUint32(m[4:], 1)
+	binary.LittleEndian.PutUint32(m[8:], 48)
+	binary.LittleEndian.PutUint32(m[12:], size)
+	copy(m[16:], fveGUID(0xF0))
+	binary.LittleEndian.PutUint32(m[32:], 10)
+	binary.LittleEndian.PutUint16(m[36:], method)
+	binary.LittleEndian.PutUint64(m[40:], 0x01DB4E5C00000000)
+	return append(m, entries...)
+}
+
+func fveEntry(typ, valueType uint16, value []byte) []byte {
+	e := make([]byte, 8, 8+len(value))
+	binary.LittleEndian.PutUint16(e[0:], uint16(8+len(value)))
+	binary.LittleEndian.PutUint16(e[2:], typ)
+	binary.LittleEndian.PutUint16(e[4:], valueType)
+	binary.LittleEndian.PutUint16(e[6:], 1)
+	return append(e, value...)
+}
+
+func fveKey(method uint16, key []byte) []byte {
+	v := make([]byte, 4, 4+len(key))
+	binary.LittleEndian.PutUint16(v, method)
+	return fveEntry(0, 1, append(v, key...))
+}
+
+func fveGUID(id byte) []byte {
+	g := make([]byte, 16)
+	for i := range g {
+		g[i] = id + byte(i)
+	}
+	return g
+}
+
+func fveUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u)+2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
@@ -0,0 +1,341 @@
+package ewffixture
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// ExtNode is one file or directory of an ExtImage tree.
+type ExtNode struct {
+	Name string
+	Dir  bool
+	Data []byte // file content
+	// Blocks, when set, makes a sparse file of Size bytes: it holds the
+	// content of individual file blocks, every other block is a hole.
+	Blocks   map[uint64][]byte
+	Size     uint64
+	Children []*ExtNode
+
+	number  uint32
+	iblock  [15]uint32
+	blocks  uint32 // data and indirect blocks
+	dirSize uint64
+}
+
+// ExtImage assembles a single-group ext2 filesystem in memory whose files
+// and directories use the legacy direct and indirect block maps.
+type ExtImage struct {
+	BlockSize int // default 1024
+	// Journal makes it ext3: HAS_JOURNAL with an internal journal inode.
+	Journal bool
+	// Extents sets INCOMPAT_EXTENTS, as tune2fs does when it upgrades a
+	// volume to ext4, without converting the existing files.
+	Extents bool
+	Label   string
+}
+
+const (
+	extInodeSize     = 128
+	extInodesPer     = 128
+	extFirstIno      = 11
+	extJournalBlocks = 1024
+)
+
+type extWriter struct {
+	bs      int
+	ppb     uint64
+	next    uint32
+	data    map[uint32][]byte
+	ind     map[uint32][]uint32
+	dirs    int
+	nodes   []*ExtNode
+	journal *ExtNode
+}
+
+// Build returns the filesystem image of root, a directory; a lost+found
+// directory is added as mke2fs does.
+func (x *ExtImage) Build(root *ExtNode) []byte {
+	bs := x.BlockSize
+	if bs == 0 {
+		bs = 1024
+	}
+	first := uint32(0)
+	if bs == 1024 {
+		first = 1
+	}
+	tableBlocks := uint32(extInodesPer * extInodeSize / bs)
+	w := &extWriter{bs: bs, ppb: uint64(bs / 4), data: map[uint32][]byte{}, ind: map[uint32][]uint32{}}
+	// Boot block / superblock, group descriptors, block and inode bitmaps,
+	// inode table.
+	w.next = first + 4 + tableBlocks
+
+	root = &ExtNode{Dir: true, number: 2, Children: append([]*ExtNode{{Name: "lost+found", Dir: true}}, root.Children...)}
+	number := uint32(extFirstIno)
+	var assign func(n *ExtNode)
+	assign = func(n *ExtNode) {
+		w.nodes = append(w.nodes, n)
+		for _, c := range n.Children {
+			c.number = number
+			number++
+			assign(c)
+		}
+	}
+	assign(root)
+	if number > extInodesPer+1 {
+		panic("ewffixture: too many ext inodes")
+	}
+
+	if x.Journal {
+		w.journal = &ExtNode{number: 8, Size: extJournalBlocks * uint64(bs)}
+		sb := make([]byte, bs)
+		binary.BigEndian.PutUint32(sb[0:], 0xC03B3998) // JBD2 magic
+		binary.BigEndian.PutUint32(sb[4:], 4)          // superblock v2
+		binary.BigEndian.PutUint32(sb[12:], uint32(bs))
+		binary.BigEndian.PutUint32(sb[16:], extJournalBlocks)
+		binary.BigEndian.PutUint32(sb[20:], 1) // s_first
+		binary.BigEndian.PutUint32(sb[24:], 1) // s_sequence
+		w.journal.Data = make([]byte, extJournalBlocks*bs)
+		copy(w.journal.Data, sb)
+		w.mapFile(w.journal, w.journal.Data, nil, false)
+	}
+	for _, n := range w.nodes {
+		if n.Dir {
+			w.dirs++
+			d := w.dirData(n, root)
+			n.dirSize = uint64(len(d))
+			w.mapFile(n, d, nil, false)
+		} else if n.Blocks != nil {
+			w.mapFile(n, nil, n.Blocks, true)
+		} else {
+			w.mapFile(n, n.Data, nil, false)
+		}
+	}
+
+	blocksPerGroup := uint32(8 * bs)
+	total := w.next + 64
+	if total-first > blocksPerGroup {
+		panic("ewffixture: ext image exceeds one block group")
+	}
+	img := make([]byte, int(total)*bs)
+	for b, d := range w.data {
+		copy(img[int(b)*bs:], d)
+	}
+	for b, t := range w.ind {
+		for i, p := range t {
+			binary.LittleEndian.PutUint32(img[int(b)*bs+4*i:], p)
+		}
+	}
+
+	// Inode table.
+	table := img[int(first+4)*bs:]
+	put := func(n *ExtNode, mode uint16, links uint16, size uint64) {
+		in := table[(n.number-1)*extInodeSize:]
+		binary.LittleEndian.PutUint16(in[0x00:], mode)
+		binary.LittleEndian.PutUint32(in[0x04:], uint32(size))
+		for _, off := range []int{0x08, 0x0C, 0x10} {
+			binary.LittleEndian.PutUint32(in[off:], 1700000000)
+		}
+		binary.LittleEndian.PutUint16(in[0x1A:], links)
+		binary.LittleEndian.PutUint32(in[0x1C:], n.blocks*uint32(bs/512))
+		for i, p := range n.iblock {
+			binary.LittleEndian.PutUint32(in[0x28+4*i:], p)
+		}
+		binary.LittleEndian.PutUint32(in[0x6C:], uint32(size>>32))
+	}
+	for _, n := range w.nodes {
+		if n.Dir {
+			links := uint16(2)
+			for _, c := range n.Children {
+				if c.Dir {
+					links++
+				}
+			}
+			put(n, 0x41ED, links, n.dirSize)
+		} else {
+			put(n, 0x81A4, 1, n.size())
+		}
+	}
+	if w.journal != nil {
+		put(w.journal, 0x8180, 1, w.journal.Size)
+	}
+
+	// Bitmaps: every block up to w.next is in use, the padding past the
+	// group's last block and inode is set.
+	bbm := img[int(first+2)*bs : int(first+3)*bs]
+	for i := uint32(0); i < blocksPerGroup; i++ {
+		if i+first < w.next || i+first >= total {
+			bbm[i/8] |= 1 << (i % 8)
+		}
+	}
+	ibm := img[int(first+3)*bs : int(first+4)*bs]
+	for i := uint32(0); i < uint32(8*bs); i++ {
+		if i+1 < number || i >= extInodesPer {
+			ibm[i/8] |= 1 << (i % 8)
+		}
+	}
+	freeBlocks := total - w.next
+	freeInodes := extInodesPer - (number - 1)
+
+	gd := img[int(first+1)*bs:]
+	binary.LittleEndian.PutUint32(gd[0x00:], first+2)
+	binary.LittleEndian.PutUint32(gd[0x04:], first+3)
+	binary.LittleEndian.PutUint32(gd[0x08:], first+4)
+	binary.LittleEndian.PutUint16(gd[0x0C:], uint16(freeBlocks))
+	binary.LittleEndian.PutUint16(gd[0x0E:], uint16(freeInodes))
+	binary.LittleEndian.PutUint16(gd[0x10:], uint16(w.dirs))
+
+	sb := img[1024:2048]
+	binary.LittleEndian.PutUint32(sb[0x00:], extInodesPer)
+	binary.LittleEndian.PutUint32(sb[0x04:], total)
+	binary.LittleEndian.PutUint32(sb[0x0C:], freeBlocks)
+	binary.LittleEndian.PutUint32(sb[0x10:], freeInodes)
+	binary.LittleEndian.PutUint32(sb[0x14:], first)
+	log := uint32(0)
+	for 1024<<log < bs {
+		log++
+	}
+	binary.LittleEndian.PutUint32(sb[0x18:], log)
+	binary.LittleEndian.PutUint32(sb[0x1C:], log)
+	binary.LittleEndian.PutUint32(sb[0x20:], blocksPerGroup)
+	binary.LittleEndian.PutUint32(sb[0x24:], blocksPerGroup)
+	binary.LittleEndian.PutUint32(sb[0x28:], extInodesPer)
+	binary.LittleEndian.PutUint32(sb[0x30:], 1700000000)
+	binary.LittleEndian.PutUint16(sb[0x36:], 0xFFFF)
+	binary.LittleEndian.PutUint16(sb[0x38:], 0xEF53)
+	binary.LittleEndian.PutUint16(sb[0x3A:], 1) // cleanly unmounted
+	binary.LittleEndian.PutUint16(sb[0x3C:], 1)
+	binary.LittleEndian.PutUint32(sb[0x40:], 1700000000)
+	binary.LittleEndian.PutUint32(sb[0x4C:], 1) // dynamic revision
+	binary.LittleEndian.PutUint32(sb[0x54:], extFirstIno)
+	binary.LittleEndian.PutUint16(sb[0x58:], extInodeSize)
+	var compat, incompat uint32 = 0, 0x2 // FILETYPE
+	if x.Journal {
+		compat |= 0x4 // HAS_JOURNAL
+		binary.LittleEndian.PutUint32(sb[0xE0:], 8)
+	}
+	if x.Extents {
+		incompat |= 0x40
+	}
+	binary.LittleEndian.PutUint32(sb[0x5C:], compat)
+	binary.LittleEndian.PutUint32(sb[0x60:], incompat)
+	binary.LittleEndian.PutUint32(sb[0x64:], 0x3) // SPARSE_SUPER, LARGE_FILE
+	for i := 0; i < 16; i++ {
+		sb[0x68+i] = byte(0x51 + i*13)
+	}
+	copy(sb[0x78:0x88], x.Label)
+	return img
+}
+
+func (n *ExtNode) size() uint64 {
+	if n.Blocks != nil {
+		return n.Size
+	}
+	return uint64(len(n.Data))
+}
+
+// dirData returns the directory blocks of n: ".", ".." and its children,
+// each block's last entry stretched to the block end.
+func (w *extWriter) dirData(n, root *ExtNode) []byte {
+	parent := root.number
+	for _, p := range w.nodes {
+		for _, c := range p.Children {
+			if c == n {
+				parent = p.number
+			}
+		}
+	}
+	type ent struct {
+		ino  uint32
+		name string
+		typ  byte
+	}
+	ents := []ent{{n.number, ".", 2}, {parent, "..", 2}}
+	for _, c := range n.Children {
+		typ := byte(1)
+		if c.Dir {
+			typ = 2
+		}
+		ents = append(ents, ent{c.number, c.Name, typ})
+	}
+	var out []byte
+	block := make([]byte, w.bs)
+	off, last := 0, -1
+	flush := func() {
+		binary.LittleEndian.PutUint16(block[last+4:], uint16(w.bs-last))
+		out = append(out, block...)
+		block = make([]byte, w.bs)
+		off, last = 0, -1
+	}
+	for _, e := range ents {
+		rec := (8 + len(e.name) + 3) &^ 3
+		if off+rec > w.bs {
+			flush()
+		}
+		binary.LittleEndian.PutUint32(block[off:], e.ino)
+		binary.LittleEndian.PutUint16(block[off+4:], uint16(rec))
+		block[off+6] = byte(len(e.name))
+		block[off+7] = e.typ
+		copy(block[off+8:], e.name)
+		last = off
+		off += rec
+	}
+	flush()
+	return out
+}
+
+// mapFile allocates n's data blocks and the indirect blocks that map them.
+// sparse content comes from blocks; otherwise data is stored densely.
+func (w *extWriter) mapFile(n *ExtNode, data []byte, blocks map[uint64][]byte, sparse bool) {
+	if sparse {
+		idx := make([]uint64, 0, len(blocks))
+		for i := range blocks {
+			idx = append(idx, i)
+		}
+		sort.Slice(idx, func(a, b int) bool { return idx[a] < idx[b] })
+		for _, i := range idx {
+			w.mapBlock(n, i, blocks[i])
+		}
+		return
+	}
+	for i := 0; i*w.bs < len(data); i++ {
+		w.mapBlock(n, uint64(i), data[i*w.bs:min(len(data), (i+1)*w.bs)])
+	}
+}
+
+func (w *extWriter) alloc(n *ExtNode) uint32 {
+	b := w.next
+	w.next++
+	n.blocks++
+	return b
+}
+
+// mapBlock stores content as file block i of n, allocating indirect blocks
+// on the way down as the kernel does.
+func (w *extWriter) mapBlock(n *ExtNode, i uint64, content []byte) {
+	var ptr *uint32
+	level := 0
+	switch {
+	case i < 12:
+		ptr = &n.iblock[i]
+	case i-12 < w.ppb:
+		ptr, level, i = &n.iblock[12], 1, i-12
+	case i-12-w.ppb < w.ppb*w.ppb:
+		ptr, level, i = &n.iblock[13], 2, i-12-w.ppb
+	default:
+		ptr, level, i = &n.iblock[14], 3, i-12-w.ppb-w.ppb*w.ppb
+	}
+	for ; level > 0; level-- {
+		if *ptr == 0 {
+			*ptr = w.alloc(n)
+			w.ind[*ptr] = make([]uint32, w.ppb)
+		}
+		div := uint64(1)
+		for j := 1; j < level; j++ {
+			div *= w.ppb
+		}
+		ptr = &w.ind[*ptr][i/div]
+		i %= div
+	}
+	*ptr = w.alloc(n)
+	w.data[*ptr] = content
+}
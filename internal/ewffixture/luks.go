@@ -0,0 +1,242 @@
+package ewffixture
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+
+	"github.com/laenix/ewfgo/internal/luks"
+)
+
+// LUKS encrypts a filesystem image into a LUKS1 or LUKS2 volume with one key
+// slot per passphrase.
+type LUKS struct {
+	Version     int    // 1 or 2; default 2
+	Cipher      string // default "aes-xts-plain64"
+	KeyBytes    int    // volume key size; default 64 for XTS, 32 otherwise
+	Hash        string // PBKDF2 and AF hash; default "sha256"
+	KDF         string // LUKS2 key slot KDF: "pbkdf2" (default), "argon2i" or "argon2id"
+	SectorSize  uint32 // LUKS2 encryption sector size; default 512
+	Label       string // LUKS2 label
+	Passphrases []string
+	MasterKey   []byte // default LUKSMasterKey(KeyBytes)
+}
+
+const (
+	luksStripes    = 4000 // as cryptsetup writes
+	luksIterations = 1000
+	luksPayload    = 2 << 20 // payload offset in bytes
+	luks2HdrSize   = 0x4000  // binary header and JSON area
+	luks2AreaStart = 2 * luks2HdrSize
+)
+
+// LUKSMasterKey returns the default n-byte volume key.
+func LUKSMasterKey(n int) []byte {
+	k := make([]byte, n)
+	for i := range k {
+		k[i] = byte(0x5A ^ i*37)
+	}
+	return k
+}
+
+// Build encrypts plain, a filesystem image whose size is a multiple of the
+// sector size, behind a 2 MiB LUKS header area and returns the volume.
+func (l LUKS) Build(plain []byte) []byte {
+	if l.Version == 0 {
+		l.Version = 2
+	}
+	if l.Cipher == "" {
+		l.Cipher = "aes-xts-plain64"
+	}
+	if l.KeyBytes == 0 {
+		l.KeyBytes = 32
+		if l.Cipher[:7] == "aes-xts" {
+			l.KeyBytes = 64
+		}
+	}
+	if l.Hash == "" {
+		l.Hash = "sha256"
+	}
+	if l.KDF == "" {
+		l.KDF = "pbkdf2"
+	}
+	if l.SectorSize == 0 {
+		l.SectorSize = 512
+	}
+	if l.MasterKey == nil {
+		l.MasterKey = LUKSMasterKey(l.KeyBytes)
+	}
+	mk := l.MasterKey
+
+	vol := make([]byte, luksPayload+len(plain))
+	c, err := luks.NewCipher(l.Cipher, mk)
+	if err != nil {
+		panic(err)
+	}
+	ss := int(l.SectorSize)
+	for off := 0; off < len(plain); off += ss {
+		c.Encrypt(vol[luksPayload+off:luksPayload+off+ss], plain[off:off+ss], uint64(off/ss))
+	}
+
+	salt := func(i int) []byte {
+		s := make([]byte, 32)
+		for j := range s {
+			s[j] = byte(i*32 + j)
+		}
+		return s
+	}
+	// Key material of slot i at its area offset, AF-split with a fixed
+	// pattern and encrypted under the key derived from its passphrase.
+	materialSize := l.KeyBytes * luksStripes
+	areaSize := (materialSize + 4095) / 4096 * 4096
+	slotKDF := func(i int) luks.KDF {
+		kdf := luks.KDF{Type: "pbkdf2", Hash: l.Hash, Iterations: luksIterations, Salt: salt(i + 1)}
+		if l.Version == 2 && l.KDF != "pbkdf2" {
+			kdf = luks.KDF{Type: l.KDF, Iterations: 2, Memory: 256, Threads: 2, Salt: salt(i + 1)}
+		}
+		return kdf
+	}
+	writeSlot := func(i, off int) {
+		random := make([]byte, l.KeyBytes*(luksStripes-1))
+		for j := range random {
+			random[j] = byte(j*7 + i*13 + j>>8)
+		}
+		material, err := luks.AFSplit(mk, random, luksStripes, l.Hash)
+		if err != nil {
+			panic(err)
+		}
+		k, err := luks.DeriveKey(slotKDF(i), l.Passphrases[i], l.KeyBytes)
+		if err != nil {
+			panic(err)
+		}
+		kc, err := luks.NewCipher(l.Cipher, k)
+		if err != nil {
+			panic(err)
+		}
+		material = append(material, make([]byte, areaSize-materialSize)...)
+		for s := 0; s < len(material); s += 512 {
+			kc.Encrypt(vol[off+s:off+s+512], material[s:s+512], uint64(s/512))
+		}
+	}
+	digestSalt := salt(0)
+
+	if l.Version == 1 {
+		if len(l.Passphrases) > 8 {
+			panic("ewffixture: LUKS1 has eight key slots")
+		}
+		h := vol[:592]
+		be := binary.BigEndian
+		copy(h, luks.Magic)
+		be.PutUint16(h[6:], 1)
+		spec := l.Cipher[4:]
+		copy(h[8:], "aes")
+		copy(h[40:], spec)
+		copy(h[72:], l.Hash)
+		be.PutUint32(h[104:], luksPayload/512)
+		be.PutUint32(h[108:], uint32(l.KeyBytes))
+		d := pbkdf2Key(l.Hash, mk, digestSalt, 20)
+		copy(h[112:], d)
+		copy(h[132:], digestSalt)
+		be.PutUint32(h[164:], luksIterations)
+		copy(h[168:], "c0ffee00-1111-4222-8333-444455556666")
+		for i := 0; i < 8; i++ {
+			s := h[208+48*i : 208+48*(i+1)]
+			off := 4096 + i*areaSize
+			be.PutUint32(s[40:], uint32(off/512))
+			be.PutUint32(s[44:], luksStripes)
+			if i >= len(l.Passphrases) {
+				be.PutUint32(s[0:], 0x0000DEAD)
+				continue
+			}
+			be.PutUint32(s[0:], 0x00AC71F3)
+			be.PutUint32(s[4:], luksIterations)
+			copy(s[8:], slotKDF(i).Salt)
+			writeSlot(i, off)
+		}
+		return vol
+	}
+
+	type obj = map[string]any
+	keyslots := obj{}
+	var ids []string
+	for i := range l.Passphrases {
+		off := luks2AreaStart + i*areaSize
+		writeSlot(i, off)
+		kdf := slotKDF(i)
+		k := obj{"type": kdf.Type, "salt": base64.StdEncoding.EncodeToString(kdf.Salt)}
+		if kdf.Type == "pbkdf2" {
+			k["hash"] = kdf.Hash
+			k["iterations"] = kdf.Iterations
+		} else {
+			k["time"] = kdf.Iterations
+			k["memory"] = kdf.Memory
+			k["cpus"] = kdf.Threads
+		}
+		id := strconv.Itoa(i)
+		ids = append(ids, id)
+		keyslots[id] = obj{
+			"type":     "luks2",
+			"key_size": l.KeyBytes,
+			"af":       obj{"type": "luks1", "stripes": luksStripes, "hash": l.Hash},
+			"area": obj{"type": "raw", "offset": strconv.Itoa(off), "size": strconv.Itoa(areaSize),
+				"encryption": l.Cipher, "key_size": l.KeyBytes},
+			"kdf": k,
+		}
+	}
+	md := obj{
+		"keyslots": keyslots,
+		"tokens":   obj{},
+		"segments": obj{"0": obj{"type": "crypt", "offset": strconv.Itoa(luksPayload), "size": "dynamic",
+			"iv_tweak": "0", "encryption": l.Cipher, "sector_size": l.SectorSize}},
+		"digests": obj{"0": obj{"type": "pbkdf2", "keyslots": ids, "segments": []string{"0"}, "hash": l.Hash,
+			"iterations": luksIterations, "salt": base64.StdEncoding.EncodeToString(digestSalt),
+			"digest": base64.StdEncoding.EncodeToString(pbkdf2Key(l.Hash, mk, digestSalt, 32))}},
+		"config": obj{"json_size": strconv.Itoa(luks2HdrSize - 4096), "keyslots_size": strconv.Itoa(luksPayload - luks2AreaStart)},
+	}
+	js, err := json.Marshal(md)
+	if err != nil {
+		panic(err)
+	}
+	for i, off := range []int{0, luks2HdrSize} {
+		h := vol[off : off+luks2HdrSize]
+		be := binary.BigEndian
+		if i == 0 {
+			copy(h, luks.Magic)
+		} else {
+			copy(h, "SKUL\xba\xbe")
+		}
+		be.PutUint16(h[6:], 2)
+		be.PutUint64(h[8:], luks2HdrSize)
+		be.PutUint64(h[16:], 1)
+		copy(h[24:72], l.Label)
+		copy(h[72:], "sha256")
+		copy(h[104:168], salt(9+i))
+		copy(h[168:], "c0ffee00-1111-4222-8333-444455556666")
+		be.PutUint64(h[256:], uint64(off))
+		copy(h[4096:], js)
+		sum := sha256.Sum256(h)
+		copy(h[448:], sum[:])
+	}
+	return vol
+}
+
+// pbkdf2Key is the volume key digest of a LUKS header.
+func pbkdf2Key(hash string, mk, salt []byte, n int) []byte {
+	hf := sha256.New
+	switch hash {
+	case "sha1":
+		hf = sha1.New
+	case "sha256":
+	default:
+		panic("ewffixture: LUKS hash must be sha1 or sha256")
+	}
+	d, err := pbkdf2.Key(hf, string(mk), salt, luksIterations, n)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
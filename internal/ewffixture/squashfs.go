@@ -0,0 +1,462 @@
+package ewffixture
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// SquashFS inode types, for SquashFSNode.Type.
+const (
+	SquashDir     = 1
+	SquashFile    = 2
+	SquashSymlink = 3
+	SquashBlkDev  = 4
+	SquashChrDev  = 5
+	SquashFIFO    = 6
+	SquashSocket  = 7
+)
+
+// SquashFSNode is one file, directory or special inode of a SquashFSImage
+// tree.
+type SquashFSNode struct {
+	Name     string
+	Type     uint16 // SquashDir, SquashFile, ...; basic type, extended when needed
+	Mode     uint16 // permission bits
+	UID, GID uint16 // indexes into SquashFSImage.IDs
+	Data     []byte // file content
+	Target   string // symlink target
+	Rdev     uint32 // device number
+	Children []*SquashFSNode
+
+	// Extended forces an extended inode even without xattrs.
+	Extended bool
+	// NoFragment stores a partial last block as a short data block instead
+	// of packing it into a fragment.
+	NoFragment bool
+	// Holes lists block indexes written as sparse (size 0); their Data
+	// must be zero.
+	Holes []int
+	// Xattrs maps full names ("user.comment") to values. Names in
+	// SharedXattrs store their value out of line through a reference.
+	Xattrs       map[string][]byte
+	SharedXattrs []string
+
+	number uint32
+}
+
+// SquashFSImage assembles a SquashFS 4.0 image in memory.
+type SquashFSImage struct {
+	BlockLog   uint16 // default 12 (4 KiB blocks)
+	Compressor uint16 // superblock compressor ID (default 1, gzip)
+	// Compress compresses one data or metadata block. A nil func or a nil
+	// or no-smaller result stores the block uncompressed.
+	Compress func([]byte) []byte
+	// CompressorOptions, when set, is written as a metadata block after the
+	// superblock and flagged in the superblock.
+	CompressorOptions []byte
+	IDs               []uint32 // ID table (default {0})
+	Exportable        bool     // emit the inode-number lookup table
+	ModTime           uint32
+}
+
+type sqMetaWriter struct {
+	img *SquashFSImage
+	out []byte
+	cur []byte
+}
+
+func (w *sqMetaWriter) ref() uint64 { return uint64(len(w.out))<<16 | uint64(len(w.cur)) }
+
+func (w *sqMetaWriter) write(b []byte) {
+	w.cur = append(w.cur, b...)
+	for len(w.cur) >= 8192 {
+		w.out = append(w.out, w.img.metaBlock(w.cur[:8192])...)
+		w.cur = append([]byte(nil), w.cur[8192:]...)
+	}
+}
+
+func (w *sqMetaWriter) bytes() []byte {
+	if len(w.cur) > 0 {
+		w.out = append(w.out, w.img.metaBlock(w.cur)...)
+		w.cur = nil
+	}
+	return w.out
+}
+
+func (img *SquashFSImage) compress(b []byte) ([]byte, bool) {
+	if img.Compress != nil {
+		if c := img.Compress(b); c != nil && len(c) < len(b) {
+			return c, true
+		}
+	}
+	return b, false
+}
+
+func (img *SquashFSImage) metaBlock(b []byte) []byte {
+	data, ok := img.compress(b)
+	hdr := uint16(len(data))
+	if !ok {
+		hdr |= 0x8000
+	}
+	return append(binary.LittleEndian.AppendUint16(nil, hdr), data...)
+}
+
+// Build lays the tree rooted at root (a SquashDir) out as an image: data
+// and fragment blocks, then the inode, directory, fragment, lookup, ID and
+// xattr tables.
+func (img *SquashFSImage) Build(root *SquashFSNode) []byte {
+	le := binary.LittleEndian
+	blockLog := img.BlockLog
+	if blockLog == 0 {
+		blockLog = 12
+	}
+	bs := 1 << blockLog
+	comp := img.Compressor
+	if comp == 0 {
+		comp = 1
+	}
+	ids := img.IDs
+	if len(ids) == 0 {
+		ids = []uint32{0}
+	}
+
+	// Number inodes in pre-order; the root is 1.
+	var nodes []*SquashFSNode
+	var number func(n *SquashFSNode)
+	number = func(n *SquashFSNode) {
+		nodes = append(nodes, n)
+		n.number = uint32(len(nodes))
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+		for _, c := range n.Children {
+			number(c)
+		}
+	}
+	number(root)
+
+	out := make([]byte, 96)
+	var flags uint16
+	if img.CompressorOptions != nil {
+		flags |= 0x0400
+		out = append(out, img.metaBlock(img.CompressorOptions)...)
+	}
+
+	// Data blocks, with tails packed into fragment blocks.
+	type fileLayout struct {
+		start      uint64
+		sizes      []uint32
+		frag, fOff uint32
+	}
+	layout := map[*SquashFSNode]*fileLayout{}
+	var fragBuf []byte
+	var fragEntries []byte
+	flushFrag := func() {
+		if len(fragBuf) == 0 {
+			return
+		}
+		data, ok := img.compress(fragBuf)
+		size := uint32(len(data))
+		if !ok {
+			size |= 1 << 24
+		}
+		fragEntries = le.AppendUint64(fragEntries, uint64(len(out)))
+		fragEntries = le.AppendUint32(fragEntries, size)
+		fragEntries = le.AppendUint32(fragEntries, 0)
+		out = append(out, data...)
+		fragBuf = nil
+	}
+	for _, n := range nodes {
+		if n.Type != SquashFile {
+			continue
+		}
+		l := &fileLayout{start: uint64(len(out)), frag: 0xFFFFFFFF}
+		layout[n] = l
+		full := len(n.Data) / bs
+		tail := n.Data[full*bs:]
+		if n.NoFragment && len(tail) > 0 {
+			full++
+			tail = nil
+		}
+		for i := 0; i < full; i++ {
+			end := (i + 1) * bs
+			if end > len(n.Data) {
+				end = len(n.Data)
+			}
+			blk := n.Data[i*bs : end]
+			hole := false
+			for _, h := range n.Holes {
+				hole = hole || h == i
+			}
+			if hole {
+				l.sizes = append(l.sizes, 0)
+				continue
+			}
+			data, ok := img.compress(blk)
+			size := uint32(len(data))
+			if !ok {
+				size |= 1 << 24
+			}
+			l.sizes = append(l.sizes, size)
+			out = append(out, data...)
+		}
+		if len(tail) > 0 {
+			if len(fragBuf)+len(tail) > bs {
+				flushFrag()
+			}
+			l.frag, l.fOff = uint32(len(fragEntries)/16), uint32(len(fragBuf))
+			fragBuf = append(fragBuf, tail...)
+		}
+	}
+	flushFrag()
+
+	// Xattr key/value metadata and the xattr ID entries.
+	kv := &sqMetaWriter{img: img}
+	var xattrIDs []byte
+	xattrIndex := map[*SquashFSNode]uint32{}
+	prefixes := []string{"user.", "trusted.", "security."}
+	for _, n := range nodes {
+		if len(n.Xattrs) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(n.Xattrs))
+		for k := range n.Xattrs {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		shared := map[string]uint64{}
+		for _, k := range n.SharedXattrs {
+			shared[k] = kv.ref()
+			kv.write(le.AppendUint32(nil, uint32(len(n.Xattrs[k]))))
+			kv.write(n.Xattrs[k])
+		}
+		ref, size := kv.ref(), 0
+		for _, k := range names {
+			typ, name := uint16(0), k
+			for i, p := range prefixes {
+				if len(k) > len(p) && k[:len(p)] == p {
+					typ, name = uint16(i), k[len(p):]
+				}
+			}
+			value := n.Xattrs[k]
+			if r, ok := shared[k]; ok {
+				typ |= 0x100
+				value = le.AppendUint64(nil, r)
+			}
+			e := le.AppendUint16(nil, typ)
+			e = le.AppendUint16(e, uint16(len(name)))
+			e = append(e, name...)
+			e = le.AppendUint32(e, uint32(len(value)))
+			e = append(e, value...)
+			kv.write(e)
+			size += len(e)
+		}
+		xattrIndex[n] = uint32(len(xattrIDs) / 16)
+		xattrIDs = le.AppendUint64(xattrIDs, ref)
+		xattrIDs = le.AppendUint32(xattrIDs, uint32(len(n.Xattrs)))
+		xattrIDs = le.AppendUint32(xattrIDs, uint32(size))
+	}
+
+	// Inodes post-order, so every directory's listing knows its
+	// children's references.
+	inodes := &sqMetaWriter{img: img}
+	dirs := &sqMetaWriter{img: img}
+	refs := map[*SquashFSNode]uint64{}
+	var emit func(n, parent *SquashFSNode)
+	emit = func(n, parent *SquashFSNode) {
+		for _, c := range n.Children {
+			emit(c, n)
+		}
+		xattr, extended := uint32(0xFFFFFFFF), n.Extended
+		if i, ok := xattrIndex[n]; ok {
+			xattr, extended = i, true
+		}
+		typ := n.Type
+		if extended {
+			typ += 7
+		}
+		b := le.AppendUint16(nil, typ)
+		b = le.AppendUint16(b, n.Mode)
+		b = le.AppendUint16(b, n.UID)
+		b = le.AppendUint16(b, n.GID)
+		b = le.AppendUint32(b, img.ModTime)
+		b = le.AppendUint32(b, n.number)
+		switch n.Type {
+		case SquashDir:
+			dirRef := dirs.ref()
+			listing := 0
+			subdirs := 0
+			for i := 0; i < len(n.Children); {
+				first := refs[n.Children[i]]
+				base := n.Children[i].number
+				j := i
+				for j < len(n.Children) && j-i < 256 && refs[n.Children[j]]>>16 == first>>16 {
+					d := int64(n.Children[j].number) - int64(base)
+					if d < -32768 || d > 32767 {
+						break
+					}
+					j++
+				}
+				h := le.AppendUint32(nil, uint32(j-i-1))
+				h = le.AppendUint32(h, uint32(first>>16))
+				h = le.AppendUint32(h, base)
+				for _, c := range n.Children[i:j] {
+					h = le.AppendUint16(h, uint16(refs[c]))
+					h = le.AppendUint16(h, uint16(int16(int64(c.number)-int64(base))))
+					h = le.AppendUint16(h, c.Type)
+					h = le.AppendUint16(h, uint16(len(c.Name)-1))
+					h = append(h, c.Name...)
+					if c.Type == SquashDir {
+						subdirs++
+					}
+				}
+				dirs.write(h)
+				listing += len(h)
+				i = j
+			}
+			parentNum := uint32(len(nodes) + 1)
+			if parent != nil {
+				parentNum = parent.number
+			}
+			if extended {
+				b = le.AppendUint32(b, uint32(2+subdirs))
+				b = le.AppendUint32(b, uint32(listing+3))
+				b = le.AppendUint32(b, uint32(dirRef>>16))
+				b = le.AppendUint32(b, parentNum)
+				b = le.AppendUint16(b, 0)
+				b = le.AppendUint16(b, uint16(dirRef))
+				b = le.AppendUint32(b, xattr)
+			} else {
+				b = le.AppendUint32(b, uint32(dirRef>>16))
+				b = le.AppendUint32(b, uint32(2+subdirs))
+				b = le.AppendUint16(b, uint16(listing+3))
+				b = le.AppendUint16(b, uint16(dirRef))
+				b = le.AppendUint32(b, parentNum)
+			}
+		case SquashFile:
+			l := layout[n]
+			if extended {
+				b = le.AppendUint64(b, l.start)
+				b = le.AppendUint64(b, uint64(len(n.Data)))
+				b = le.AppendUint64(b, 0)
+				b = le.AppendUint32(b, 1)
+				b = le.AppendUint32(b, l.frag)
+				b = le.AppendUint32(b, l.fOff)
+				b = le.AppendUint32(b, xattr)
+			} else {
+				b = le.AppendUint32(b, uint32(l.start))
+				b = le.AppendUint32(b, l.frag)
+				b = le.AppendUint32(b, l.fOff)
+				b = le.AppendUint32(b, uint32(len(n.Data)))
+			}
+			for _, s := range l.sizes {
+				b = le.AppendUint32(b, s)
+			}
+		case SquashSymlink:
+			b = le.AppendUint32(b, 1)
+			b = le.AppendUint32(b, uint32(len(n.Target)))
+			b = append(b, n.Target...)
+			if extended {
+				b = le.AppendUint32(b, xattr)
+			}
+		case SquashBlkDev, SquashChrDev:
+			b = le.AppendUint32(b, 1)
+			b = le.AppendUint32(b, n.Rdev)
+			if extended {
+				b = le.AppendUint32(b, xattr)
+			}
+		default:
+			b = le.AppendUint32(b, 1)
+			if extended {
+				b = le.AppendUint32(b, xattr)
+			}
+		}
+		refs[n] = inodes.ref()
+		inodes.write(b)
+	}
+	emit(root, nil)
+
+	// table writes entries as metadata blocks followed by their pointer
+	// array and returns the array's offset.
+	table := func(entries []byte) uint64 {
+		var ptrs []byte
+		for i := 0; i < len(entries); i += 8192 {
+			end := i + 8192
+			if end > len(entries) {
+				end = len(entries)
+			}
+			ptrs = le.AppendUint64(ptrs, uint64(len(out)))
+			out = append(out, img.metaBlock(entries[i:end])...)
+		}
+		start := uint64(len(out))
+		out = append(out, ptrs...)
+		return start
+	}
+
+	inodeTable := uint64(len(out))
+	out = append(out, inodes.bytes()...)
+	dirTable := uint64(len(out))
+	out = append(out, dirs.bytes()...)
+	fragTable := uint64(0xFFFFFFFFFFFFFFFF)
+	if len(fragEntries) > 0 {
+		fragTable = table(fragEntries)
+	}
+	lookupTable := uint64(0xFFFFFFFFFFFFFFFF)
+	if img.Exportable {
+		flags |= 0x0080
+		var lookup []byte
+		for _, n := range nodes {
+			lookup = le.AppendUint64(lookup, refs[n])
+		}
+		lookupTable = table(lookup)
+	}
+	var idEntries []byte
+	for _, id := range ids {
+		idEntries = le.AppendUint32(idEntries, id)
+	}
+	idTable := table(idEntries)
+	xattrTable := uint64(0xFFFFFFFFFFFFFFFF)
+	if len(xattrIDs) > 0 {
+		kvStart := uint64(len(out))
+		out = append(out, kv.bytes()...)
+		var ptrs []byte
+		for i := 0; i < len(xattrIDs); i += 8192 {
+			end := i + 8192
+			if end > len(xattrIDs) {
+				end = len(xattrIDs)
+			}
+			ptrs = le.AppendUint64(ptrs, uint64(len(out)))
+			out = append(out, img.metaBlock(xattrIDs[i:end])...)
+		}
+		xattrTable = uint64(len(out))
+		out = le.AppendUint64(out, kvStart)
+		out = le.AppendUint32(out, uint32(len(xattrIDs)/16))
+		out = le.AppendUint32(out, 0)
+		out = append(out, ptrs...)
+	} else {
+		flags |= 0x0200
+	}
+
+	sb := out[:96]
+	copy(sb, "hsqs")
+	le.PutUint32(sb[4:], uint32(len(nodes)))
+	le.PutUint32(sb[8:], img.ModTime)
+	le.PutUint32(sb[12:], uint32(bs))
+	le.PutUint32(sb[16:], uint32(len(fragEntries)/16))
+	le.PutUint16(sb[20:], comp)
+	le.PutUint16(sb[22:], blockLog)
+	le.PutUint16(sb[24:], flags)
+	le.PutUint16(sb[26:], uint16(len(ids)))
+	le.PutUint16(sb[28:], 4)
+	le.PutUint16(sb[30:], 0)
+	le.PutUint64(sb[32:], refs[root])
+	le.PutUint64(sb[40:], uint64(len(out)))
+	le.PutUint64(sb[48:], idTable)
+	le.PutUint64(sb[56:], xattrTable)
+	le.PutUint64(sb[64:], inodeTable)
+	le.PutUint64(sb[72:], dirTable)
+	le.PutUint64(sb[80:], fragTable)
+	le.PutUint64(sb[88:], lookupTable)
+	for len(out)%4096 != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
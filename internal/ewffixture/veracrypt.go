@@ -0,0 +1,146 @@
+package ewffixture
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/laenix/ewfgo/internal/veracrypt"
+)
+
+// VeraCrypt encrypts a filesystem image into a VeraCrypt (or TrueCrypt)
+// volume, optionally with a hidden volume in the outer volume's free space.
+type VeraCrypt struct {
+	Password  string
+	PIM       int      // 0 for the default iteration counts; tests use small PIMs
+	Keyfiles  [][]byte // keyfile contents
+	PRF       string   // default "sha512"
+	Algorithm string   // default "AES"
+	TrueCrypt bool
+	Hidden    *VeraCrypt // the hidden volume's key; its image is passed to Build
+}
+
+// VeraCryptMasterKey returns the default master key material of the i-th
+// volume of a container (0 outer, 1 hidden): two 96-byte halves, enough for
+// any cascade.
+func VeraCryptMasterKey(i int) []byte {
+	k := make([]byte, 256)
+	for j := range k {
+		k[j] = byte(0xC3 ^ j*29 ^ i*101)
+	}
+	return k
+}
+
+// Build encrypts plain, a filesystem image whose size is a multiple of 512,
+// into a volume: headers, the data area and backup headers. hidden is the
+// hidden volume's image, placed at the end of the data area; the outer
+// volume's data area grows to hold it, so plain stays intact.
+func (v VeraCrypt) Build(plain, hidden []byte) []byte {
+	data := len(plain) + len(hidden)
+	size := veracrypt.DataAreaOffset + data + veracrypt.DataAreaOffset
+	vol := make([]byte, size)
+	// Unused header areas hold random data, as VeraCrypt writes them.
+	for i := range vol[:veracrypt.DataAreaOffset] {
+		vol[i] = byte(uint32(i)*2654435761>>13) ^ 0x6D
+	}
+	copy(vol[size-veracrypt.DataAreaOffset:], vol[:veracrypt.DataAreaOffset])
+
+	outer := veracryptVolume{key: v, mk: VeraCryptMasterKey(0), start: veracrypt.DataAreaOffset, size: uint64(data), hiddenSize: uint64(len(hidden))}
+	outer.encrypt(vol, plain)
+	outer.header(vol, 0, 0)
+	outer.header(vol, uint64(size-veracrypt.DataAreaOffset), 1)
+	if v.Hidden != nil {
+		h := veracryptVolume{key: *v.Hidden, mk: VeraCryptMasterKey(1), start: uint64(veracrypt.DataAreaOffset + len(plain)), size: uint64(len(hidden))}
+		h.encrypt(vol, hidden)
+		h.header(vol, veracrypt.HeaderAreaSize, 2)
+		h.header(vol, uint64(size-veracrypt.HeaderAreaSize), 3)
+	}
+	return vol
+}
+
+type veracryptVolume struct {
+	key        VeraCrypt
+	mk         []byte
+	start      uint64 // encrypted area start
+	size       uint64 // encrypted area size
+	hiddenSize uint64
+}
+
+func (v veracryptVolume) algorithm() veracrypt.Algorithm {
+	name := v.key.Algorithm
+	if name == "" {
+		name = "AES"
+	}
+	for _, a := range veracrypt.Algorithms {
+		if a.Name == name {
+			return a
+		}
+	}
+	panic("ewffixture: unknown VeraCrypt algorithm " + name)
+}
+
+// encrypt writes plain encrypted with the master key at the area start.
+func (v veracryptVolume) encrypt(vol, plain []byte) {
+	alg := v.algorithm()
+	n := alg.KeyBytes()
+	c, err := veracrypt.NewCipher(alg, append(append([]byte{}, v.mk[:n]...), v.mk[96:96+n]...))
+	if err != nil {
+		panic(err)
+	}
+	for off := 0; off < len(plain); off += 512 {
+		dst := vol[int(v.start)+off : int(v.start)+off+512]
+		c.Encrypt(dst, plain[off:off+512], (v.start+uint64(off))/512)
+	}
+}
+
+// header writes the volume header at off with the seed-th salt.
+func (v veracryptVolume) header(vol []byte, off uint64, seed int) {
+	alg := v.algorithm()
+	n := alg.KeyBytes()
+	h := make([]byte, veracrypt.HeaderSize)
+	for i := 0; i < 64; i++ {
+		h[i] = byte(i*7 + seed*61 + 1)
+	}
+	magic, version, minVersion := "VERA", uint16(5), uint16(0x010b)
+	if v.key.TrueCrypt {
+		magic, minVersion = "TRUE", 0x0700
+	}
+	copy(h[64:], magic)
+	binary.BigEndian.PutUint16(h[68:], version)
+	binary.BigEndian.PutUint16(h[70:], minVersion)
+	binary.BigEndian.PutUint64(h[92:], v.hiddenSize)
+	binary.BigEndian.PutUint64(h[100:], v.size)
+	binary.BigEndian.PutUint64(h[108:], v.start)
+	binary.BigEndian.PutUint64(h[116:], v.size)
+	binary.BigEndian.PutUint32(h[128:], 512)
+	copy(h[256:], v.mk[:n])
+	copy(h[256+n:], v.mk[96:96+n])
+	binary.BigEndian.PutUint32(h[72:], crc32.ChecksumIEEE(h[256:]))
+	binary.BigEndian.PutUint32(h[252:], crc32.ChecksumIEEE(h[64:252]))
+
+	prfName := v.key.PRF
+	if prfName == "" {
+		prfName = "sha512"
+	}
+	key := veracrypt.Key{Password: []byte(v.key.Password), PIM: v.key.PIM, Keyfiles: v.key.Keyfiles, TrueCrypt: v.key.TrueCrypt}
+	password, err := veracrypt.ApplyKeyfiles(key.Password, key.Keyfiles)
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range veracrypt.PRFs {
+		if p.Name != prfName {
+			continue
+		}
+		dk, err := veracrypt.DeriveKey(p, password, h[:64], p.IterationCount(key), n)
+		if err != nil {
+			panic(err)
+		}
+		c, err := veracrypt.NewCipher(alg, dk)
+		if err != nil {
+			panic(err)
+		}
+		c.Encrypt(h[64:], h[64:], 0)
+		copy(vol[off:], h)
+		return
+	}
+	panic("ewffixture: unknown VeraCrypt PRF " + prfName)
+}
@@ -0,0 +1,147 @@
+package ewffixture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/vss"
+)
+
+// VSS adds Volume Shadow Copy snapshots to an NTFS volume image.
+type VSS struct {
+	Snapshots []VSSSnapshot // oldest first
+}
+
+// VSSSnapshot is one shadow copy: the volume as it was when it was taken.
+type VSSSnapshot struct {
+	Image   []byte // same size as the current volume
+	Created time.Time
+	Machine string
+}
+
+// Build returns current, a sector-aligned NTFS volume image, with a VSS
+// volume header at 0x1E00, a catalog and one store per snapshot. A store
+// holds the 16 KiB blocks in which its snapshot differs from the next one
+// (the current volume for the newest), as Windows copies them before
+// overwriting. The catalog and stores take blocks downwards from 16 KiB
+// before the end of the volume, keeping the backup boot sector; that area
+// and the volume header sector must be unused (zero) in every image.
+func (v VSS) Build(current []byte) []byte {
+	size := uint64(len(current))
+	vol := append([]byte(nil), current...)
+	next := size&^(vss.BlockSize-1) - vss.BlockSize
+	alloc := func() uint64 {
+		next -= vss.BlockSize
+		return next
+	}
+
+	catalogOff := alloc()
+	type store struct {
+		header, list uint64
+	}
+	stores := make([]store, len(v.Snapshots))
+	for i, sn := range v.Snapshots {
+		newer := current
+		if i+1 < len(v.Snapshots) {
+			newer = v.Snapshots[i+1].Image
+		}
+		var changed []uint64
+		for off := uint64(0); off < size; off += vss.BlockSize {
+			end := min(off+vss.BlockSize, size)
+			if !bytes.Equal(sn.Image[off:end], newer[off:end]) {
+				changed = append(changed, off)
+			}
+		}
+		id := byte(0x10 * (i + 1))
+		stores[i].header = alloc()
+		hdr := vssBlock(stores[i].header, 0, 0, vss.RecordStoreHeader)
+		info := make([]byte, 64)
+		copy(info[16:], fveGUID(id+1))
+		copy(info[32:], fveGUID(0xE0))
+		binary.LittleEndian.PutUint32(info[48:], 0)    // VSS_CTX_BACKUP
+		binary.LittleEndian.PutUint32(info[56:], 0x1D) // persistent, client-accessible, no auto-release
+		info = append(info, vssString(sn.Machine)...)
+		info = append(info, vssString(sn.Machine)...)
+		binary.LittleEndian.PutUint64(hdr[48:], uint64(len(info)))
+		copy(hdr[0x80:], info)
+		copy(vol[stores[i].header:], hdr)
+
+		// Block list blocks, chained, then one data block per change.
+		perBlock := (vss.BlockSize - 0x80) / 32
+		var lists []uint64
+		for n := 0; n == 0 || n < len(changed); n += perBlock {
+			lists = append(lists, alloc())
+		}
+		stores[i].list = lists[0]
+		for j, off := range lists {
+			nextList := uint64(0)
+			if j+1 < len(lists) {
+				nextList = lists[j+1]
+			}
+			blk := vssBlock(off, uint64(j)*vss.BlockSize, nextList, vss.RecordBlockList)
+			for k := 0; k < perBlock && j*perBlock+k < len(changed); k++ {
+				orig := changed[j*perBlock+k]
+				data := alloc()
+				end := min(orig+vss.BlockSize, size)
+				copy(vol[data:], sn.Image[orig:end])
+				e := blk[0x80+32*k:]
+				binary.LittleEndian.PutUint64(e[0:], orig)
+				binary.LittleEndian.PutUint64(e[16:], data)
+			}
+			copy(vol[off:], blk)
+		}
+	}
+
+	// The catalog lists the newest store first, as Windows appends
+	// snapshots at the front.
+	cat := vssBlock(catalogOff, 0, 0, vss.RecordCatalog)
+	e := cat[0x80:]
+	for i := len(v.Snapshots) - 1; i >= 0; i-- {
+		sn := v.Snapshots[i]
+		storeID := fveGUID(byte(0x10 * (i + 1)))
+		binary.LittleEndian.PutUint64(e[0:], 2)
+		binary.LittleEndian.PutUint64(e[8:], size)
+		copy(e[16:], storeID)
+		binary.LittleEndian.PutUint64(e[32:], uint64(i+1))
+		binary.LittleEndian.PutUint64(e[48:], uint64(sn.Created.UnixNano()/100+116444736000000000))
+		e = e[0x80:]
+		binary.LittleEndian.PutUint64(e[0:], 3)
+		binary.LittleEndian.PutUint64(e[8:], stores[i].list)
+		copy(e[16:], storeID)
+		binary.LittleEndian.PutUint64(e[32:], stores[i].header)
+		e = e[0x80:]
+	}
+	copy(vol[catalogOff:], cat)
+
+	h := vssBlock(vss.HeaderOffset, 0, 0, vss.RecordVolumeHeader)[:512]
+	binary.LittleEndian.PutUint64(h[48:], catalogOff)
+	copy(h[64:], fveGUID(0xC0))
+	copy(vol[vss.HeaderOffset:], h)
+	return vol
+}
+
+// vssBlock returns a VSS block with its 128-byte header filled in.
+func vssBlock(off, relative, next uint64, typ uint32) []byte {
+	b := make([]byte, vss.BlockSize)
+	copy(b, vss.Identifier)
+	binary.LittleEndian.PutUint32(b[16:], 1)
+	binary.LittleEndian.PutUint32(b[20:], typ)
+	binary.LittleEndian.PutUint64(b[24:], relative)
+	binary.LittleEndian.PutUint64(b[32:], off)
+	binary.LittleEndian.PutUint64(b[40:], next)
+	return b
+}
+
+// vssString encodes a store information string: its size in bytes, then
+// the UTF-16 text.
+func vssString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2+2*len(u))
+	binary.LittleEndian.PutUint16(b, uint16(2*len(u)))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2+2*i:], c)
+	}
+	return b
+}
@@ -0,0 +1,602 @@
+package ewffixture
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Encoders for the Windows compression formats of NTFS compressed files and
+// WOF (CompactOS) files: LZNT1, XPRESS Huffman and LZX. They are greedy
+// rather than good, but every stream they write uses the formats' real
+// features (back-references at every distance range, length extensions,
+// repeat offsets, aligned offset blocks, stored chunks), so the fixtures
+// exercise the decoders end to end.
+
+// lzMatcher finds back-references with a hash of the next three bytes.
+type lzMatcher struct {
+	data  []byte
+	heads map[uint32][]int
+}
+
+func newLZMatcher(data []byte) *lzMatcher {
+	return &lzMatcher{data: data, heads: make(map[uint32][]int)}
+}
+
+func (m *lzMatcher) key(pos int) uint32 {
+	d := m.data
+	return uint32(d[pos]) | uint32(d[pos+1])<<8 | uint32(d[pos+2])<<16
+}
+
+// insert records pos as a match candidate.
+func (m *lzMatcher) insert(pos int) {
+	if pos+3 > len(m.data) {
+		return
+	}
+	k := m.key(pos)
+	c := append(m.heads[k], pos)
+	if len(c) > 16 {
+		c = c[1:]
+	}
+	m.heads[k] = c
+}
+
+// matchLen is the length of the match of pos against pos-dist, up to limit.
+func (m *lzMatcher) matchLen(pos, dist, limit int) int {
+	n := 0
+	for n < limit && pos+n < len(m.data) && m.data[pos+n] == m.data[pos+n-dist] {
+		n++
+	}
+	return n
+}
+
+// find returns the longest match at pos no further back than maxDist and no
+// earlier than lo, capped at maxLen.
+func (m *lzMatcher) find(pos, lo, maxDist, maxLen int) (length, dist int) {
+	if pos+3 > len(m.data) {
+		return 0, 0
+	}
+	c := m.heads[m.key(pos)]
+	for i := len(c) - 1; i >= 0; i-- {
+		d := pos - c[i]
+		if d > maxDist || c[i] < lo {
+			break
+		}
+		if l := m.matchLen(pos, d, maxLen); l > length {
+			length, dist = l, d
+		}
+	}
+	return length, dist
+}
+
+// LZNT1 compresses data into an NTFS LZNT1 buffer. Each 4096-byte chunk is
+// compressed, or stored when compression does not shrink it, and the buffer
+// ends with a zero chunk header.
+func LZNT1(data []byte) []byte {
+	var out []byte
+	m := newLZMatcher(data)
+	for start := 0; start < len(data); start += 4096 {
+		end := min(start+4096, len(data))
+		var body []byte
+		flagPos, bit := 0, 8
+		for pos := start; pos < end; {
+			if bit == 8 {
+				flagPos, bit = len(body), 0
+				body = append(body, 0)
+			}
+			off := pos - start
+			dispBits := max(4, bits.Len(uint(off-1)))
+			lenBits := 16 - dispBits
+			length, dist := 0, 0
+			if off > 0 {
+				length, dist = m.find(pos, start, 1<<dispBits, min(1<<lenBits+2, end-pos))
+			}
+			if length >= 3 {
+				body[flagPos] |= 1 << bit
+				body = binary.LittleEndian.AppendUint16(body, uint16((dist-1)<<lenBits|(length-3)))
+			} else {
+				length = 1
+				body = append(body, data[pos])
+			}
+			for i := 0; i < length; i++ {
+				m.insert(pos + i)
+			}
+			pos += length
+			bit++
+		}
+		if len(body) < end-start {
+			out = binary.LittleEndian.AppendUint16(out, uint16(0xB000|(len(body)-1)))
+			out = append(out, body...)
+		} else {
+			out = binary.LittleEndian.AppendUint16(out, uint16(0x3000|(end-start-1)))
+			out = append(out, data[start:end]...)
+		}
+	}
+	return append(out, 0, 0)
+}
+
+// huffmanLengths returns codeword lengths of at most maxLen bits for the
+// symbol frequencies, halving the frequencies until the code fits. At least
+// two symbols get a codeword, so the code is complete.
+func huffmanLengths(freq []int, maxLen int) []uint8 {
+	f := append([]int(nil), freq...)
+	used := 0
+	for _, v := range f {
+		if v > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2; i++ {
+		if f[i] == 0 {
+			f[i] = 1
+			used++
+		}
+	}
+	for {
+		lens := huffmanTree(f)
+		longest := uint8(0)
+		for _, l := range lens {
+			longest = max(longest, l)
+		}
+		if int(longest) <= maxLen {
+			return lens
+		}
+		for i := range f {
+			if f[i] > 0 {
+				f[i] = (f[i] + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTree returns unlimited Huffman codeword lengths for freq.
+func huffmanTree(freq []int) []uint8 {
+	type node struct{ weight, parent int }
+	var nodes []node
+	var leaves []int // node index of each symbol, -1 if unused
+	for _, v := range freq {
+		if v > 0 {
+			leaves = append(leaves, len(nodes))
+			nodes = append(nodes, node{v, -1})
+		} else {
+			leaves = append(leaves, -1)
+		}
+	}
+	live := make([]int, 0, len(nodes))
+	for i := range nodes {
+		live = append(live, i)
+	}
+	for len(live) > 1 {
+		sort.SliceStable(live, func(a, b int) bool { return nodes[live[a]].weight < nodes[live[b]].weight })
+		p := len(nodes)
+		nodes = append(nodes, node{nodes[live[0]].weight + nodes[live[1]].weight, -1})
+		nodes[live[0]].parent, nodes[live[1]].parent = p, p
+		live = append(live[2:], p)
+	}
+	lens := make([]uint8, len(freq))
+	for s, n := range leaves {
+		for ; n >= 0 && nodes[n].parent >= 0; n = nodes[n].parent {
+			lens[s]++
+		}
+	}
+	return lens
+}
+
+// canonicalCodes assigns canonical codewords (by length, then symbol).
+func canonicalCodes(lens []uint8) []uint32 {
+	codes := make([]uint32, len(lens))
+	code := uint32(0)
+	for l := uint8(1); l <= 16; l++ {
+		for s, sl := range lens {
+			if sl == l {
+				codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// msbWriter writes 16-bit little-endian words filled most significant bit
+// first.
+type msbWriter struct {
+	words []uint16
+	acc   uint32
+	n     int
+	total int
+}
+
+func (w *msbWriter) write(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | v>>i&1
+		w.n++
+		w.total++
+		if w.n == 16 {
+			w.words = append(w.words, uint16(w.acc))
+			w.acc, w.n = 0, 0
+		}
+	}
+}
+
+// flush pads the last word with zero bits.
+func (w *msbWriter) flush() {
+	if w.n > 0 {
+		w.write(0, 16-w.n)
+	}
+}
+
+// lzToken is a literal (length 0) or a back-reference.
+type lzToken struct {
+	lit          byte
+	length, dist int
+}
+
+// XpressHuffman compresses data (at most 64 KiB) into one LZ77+Huffman
+// block as Windows WOF XPRESS chunks store it.
+func XpressHuffman(data []byte) []byte {
+	m := newLZMatcher(data)
+	var toks []lzToken
+	for pos := 0; pos < len(data); {
+		length, dist := m.find(pos, 0, 65535, len(data)-pos)
+		if length < 3 {
+			toks = append(toks, lzToken{lit: data[pos]})
+			length = 1
+		} else {
+			toks = append(toks, lzToken{length: length, dist: dist})
+		}
+		for i := 0; i < length; i++ {
+			m.insert(pos + i)
+		}
+		pos += length
+	}
+	symOf := func(t lzToken) int {
+		if t.length == 0 {
+			return int(t.lit)
+		}
+		return 256 | (bits.Len(uint(t.dist))-1)<<4 | min(t.length-3, 15)
+	}
+	freq := make([]int, 512)
+	for _, t := range toks {
+		freq[symOf(t)]++
+	}
+	lens := huffmanLengths(freq, 15)
+	codes := canonicalCodes(lens)
+
+	// The decoder reads a match's extra length bytes at the input position
+	// it has reached after the match symbol: past max(2, ceil(T/16)+1)
+	// words, T being the bits consumed so far. Record them and interleave
+	// once the bitstream is complete.
+	type extraBytes struct {
+		afterWords int
+		b          []byte
+	}
+	var w msbWriter
+	var extras []extraBytes
+	for _, t := range toks {
+		s := symOf(t)
+		w.write(codes[s], int(lens[s]))
+		if t.length == 0 {
+			continue
+		}
+		if l := t.length - 3; l >= 15 {
+			var b []byte
+			if l-15 < 255 {
+				b = []byte{byte(l - 15)}
+			} else {
+				b = binary.LittleEndian.AppendUint16([]byte{255}, uint16(l))
+			}
+			extras = append(extras, extraBytes{max(2, (w.total+15)/16+1), b})
+		}
+		obits := bits.Len(uint(t.dist)) - 1
+		w.write(uint32(t.dist-1<<obits), obits)
+	}
+	w.flush()
+	for len(w.words) < 2 {
+		w.words = append(w.words, 0)
+	}
+	for _, e := range extras {
+		for len(w.words) < e.afterWords {
+			w.words = append(w.words, 0)
+		}
+	}
+
+	out := make([]byte, 256)
+	for i := range out {
+		out[i] = lens[2*i] | lens[2*i+1]<<4
+	}
+	next := 0
+	for _, e := range extras {
+		for ; next < e.afterWords; next++ {
+			out = binary.LittleEndian.AppendUint16(out, w.words[next])
+		}
+		out = append(out, e.b...)
+	}
+	for ; next < len(w.words); next++ {
+		out = binary.LittleEndian.AppendUint16(out, w.words[next])
+	}
+	return out
+}
+
+// LZX block types accepted by LZX.
+const (
+	LZXVerbatim     = 1
+	LZXAligned      = 2
+	LZXUncompressed = 3
+)
+
+// lzxSlots are the LZX offset slot bases and extra bit counts of a 32 KiB
+// window.
+var lzxSlotBase, lzxSlotExtra = func() (base, extra [30]int) {
+	for s := range extra {
+		if s >= 4 {
+			extra[s] = s/2 - 1
+		}
+		if s > 0 {
+			base[s] = base[s-1] + 1<<extra[s-1]
+		}
+	}
+	return
+}()
+
+// lzxWriter mirrors the decoder's lazy word loading so an uncompressed
+// block's alignment padding lands where the decoder expects it.
+type lzxWriter struct {
+	msbWriter
+	out    []byte
+	loaded int // bits the decoder has loaded ahead of what it consumed
+}
+
+func (w *lzxWriter) ensure(n int) {
+	for w.loaded < n {
+		w.loaded += 16
+	}
+}
+
+func (w *lzxWriter) bits(v uint32, n int) {
+	if n == 0 {
+		return
+	}
+	w.ensure(n)
+	w.write(v, n)
+	w.loaded -= n
+}
+
+func (w *lzxWriter) sym(codes []uint32, lens []uint8, maxLen, s int) {
+	w.ensure(maxLen)
+	w.write(codes[s], int(lens[s]))
+	w.loaded -= int(lens[s])
+}
+
+// raw aligns the bitstream like the decoder (discarding its loaded bits, a
+// whole word when none are loaded) and appends bytes.
+func (w *lzxWriter) raw(b []byte) {
+	w.ensure(1)
+	w.write(0, w.loaded)
+	w.loaded = 0
+	w.drain()
+	w.out = append(w.out, b...)
+}
+
+func (w *lzxWriter) drain() {
+	for _, x := range w.words {
+		w.out = binary.LittleEndian.AppendUint16(w.out, x)
+	}
+	w.words = w.words[:0]
+}
+
+// lens writes new codeword lengths delta-coded against old through a
+// precode, with runs of zeros.
+func (w *lzxWriter) lens(old, cur []uint8) {
+	type presym struct{ sym, extra, n int }
+	var ps []presym
+	for i := 0; i < len(cur); {
+		run := 0
+		for i+run < len(cur) && cur[i+run] == 0 && run < 51 {
+			run++
+		}
+		switch {
+		case run >= 20:
+			ps = append(ps, presym{18, run - 20, 5})
+			i += run
+		case run >= 4:
+			ps = append(ps, presym{17, run - 4, 4})
+			i += run
+		default:
+			ps = append(ps, presym{int((old[i] + 17 - cur[i]) % 17), 0, 0})
+			i++
+		}
+	}
+	freq := make([]int, 20)
+	for _, p := range ps {
+		freq[p.sym]++
+	}
+	plens := huffmanLengths(freq, 15)
+	pcodes := canonicalCodes(plens)
+	for _, l := range plens {
+		w.bits(uint32(l), 4)
+	}
+	for _, p := range ps {
+		w.sym(pcodes, plens, 15, p.sym)
+		w.bits(uint32(p.extra), p.n)
+	}
+}
+
+// lzxE8 applies the encoder's x86 call translation (see compress.LZX),
+// leaving the last 10 bytes alone.
+func lzxE8(b []byte) {
+	if len(b) <= 10 {
+		return
+	}
+	for p := 0; p < len(b)-10; {
+		if b[p] != 0xE8 {
+			p++
+			continue
+		}
+		rel := int32(binary.LittleEndian.Uint32(b[p+1:]))
+		if rel >= -int32(p) && rel < 12000000 {
+			abs := rel - 12000000
+			if rel < 12000000-int32(p) {
+				abs = rel + int32(p)
+			}
+			binary.LittleEndian.PutUint32(b[p+1:], uint32(abs))
+		}
+		p += 5
+	}
+}
+
+// LZX compresses one chunk (at most 32 KiB) as the LZX of WIM resources and
+// WOF files. The chunk is split into equal blocks, one per entry of types
+// (LZXVerbatim when empty); matches stay within their block.
+func LZX(data []byte, types ...int) []byte {
+	if len(types) == 0 {
+		types = []int{LZXVerbatim}
+	}
+	in := append([]byte(nil), data...)
+	lzxE8(in)
+	m := newLZMatcher(in)
+	w := &lzxWriter{}
+	var mainLens [496]uint8
+	var lenLens [249]uint8
+	recent := [3]int{1, 1, 1}
+	per := (len(in) + len(types) - 1) / len(types)
+	for bi, typ := range types {
+		start, end := bi*per, min((bi+1)*per, len(in))
+		if start >= end {
+			break
+		}
+		size := end - start
+		w.bits(uint32(typ), 3)
+		if size == 32768 {
+			w.bits(1, 1)
+		} else {
+			w.bits(0, 1)
+			w.bits(uint32(size), 16)
+		}
+		if typ == LZXUncompressed {
+			var hdr []byte
+			for _, r := range recent {
+				hdr = binary.LittleEndian.AppendUint32(hdr, uint32(r))
+			}
+			w.raw(append(hdr, in[start:end]...))
+			if size&1 != 0 {
+				w.out = append(w.out, 0)
+			}
+			for p := start; p < end; p++ {
+				m.insert(p)
+			}
+			continue
+		}
+
+		// Parse the block, preferring repeat offsets.
+		type tok struct{ lit, slot, lenHdr, lenSym, extra int }
+		var toks []tok
+		for pos := start; pos < end; {
+			limit := min(257, end-pos)
+			length, dist, slot := 0, 0, -1
+			for i, r := range recent {
+				if r <= pos {
+					if l := m.matchLen(pos, r, limit); l >= 2 && l > length {
+						length, dist, slot = l, r, i
+					}
+				}
+			}
+			if length < 3 {
+				if l, d := m.find(pos, 0, 32765, limit); l >= 3 && l > length {
+					length, dist, slot = l, d, -1
+				}
+			}
+			if length < 2 {
+				toks = append(toks, tok{lit: int(in[pos]), slot: -1})
+				m.insert(pos)
+				pos++
+				continue
+			}
+			t := tok{lit: -1, lenHdr: min(length-2, 7), lenSym: -1}
+			if t.lenHdr == 7 {
+				t.lenSym = length - 9
+			}
+			if slot >= 0 {
+				t.slot = slot
+				recent[slot] = recent[0]
+				recent[0] = dist
+			} else {
+				f := dist + 2
+				s := 29
+				for lzxSlotBase[s] > f {
+					s--
+				}
+				t.slot, t.extra = s, f-lzxSlotBase[s]
+				recent[2], recent[1], recent[0] = recent[1], recent[0], dist
+			}
+			toks = append(toks, t)
+			for i := 0; i < length; i++ {
+				m.insert(pos + i)
+			}
+			pos += length
+		}
+
+		mainFreq, lenFreq, alFreq := make([]int, 496), make([]int, 249), make([]int, 8)
+		aligned := typ == LZXAligned
+		for _, t := range toks {
+			if t.lit >= 0 {
+				mainFreq[t.lit]++
+				continue
+			}
+			mainFreq[256+t.slot*8+t.lenHdr]++
+			if t.lenSym >= 0 {
+				lenFreq[t.lenSym]++
+			}
+			if aligned && t.slot >= 3 && lzxSlotExtra[t.slot] >= 3 {
+				alFreq[t.extra&7]++
+			}
+		}
+		var alLens []uint8
+		if aligned {
+			alLens = huffmanLengths(alFreq, 7)
+			for _, l := range alLens {
+				w.bits(uint32(l), 3)
+			}
+		}
+		newMain := huffmanLengths(mainFreq, 16)
+		newLen := make([]uint8, 249)
+		used := 0
+		for _, f := range lenFreq {
+			if f > 0 {
+				used++
+			}
+		}
+		if used > 0 {
+			newLen = huffmanLengths(lenFreq, 16)
+		}
+		w.lens(mainLens[:256], newMain[:256])
+		w.lens(mainLens[256:], newMain[256:])
+		w.lens(lenLens[:], newLen)
+		copy(mainLens[:], newMain)
+		copy(lenLens[:], newLen)
+		mainCodes, lenCodes, alCodes := canonicalCodes(newMain), canonicalCodes(newLen), canonicalCodes(alLens)
+		for _, t := range toks {
+			if t.lit >= 0 {
+				w.sym(mainCodes, newMain, 16, t.lit)
+				continue
+			}
+			w.sym(mainCodes, newMain, 16, 256+t.slot*8+t.lenHdr)
+			if t.lenSym >= 0 {
+				w.sym(lenCodes, newLen, 16, t.lenSym)
+			}
+			if t.slot < 3 {
+				continue
+			}
+			eb := lzxSlotExtra[t.slot]
+			if aligned && eb >= 3 {
+				w.bits(uint32(t.extra>>3), eb-3)
+				w.sym(alCodes, alLens, 7, t.extra&7)
+			} else {
+				w.bits(uint32(t.extra), eb)
+			}
+		}
+	}
+	w.flush()
+	w.drain()
+	return w.out
+}
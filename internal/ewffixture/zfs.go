@@ -0,0 +1,809 @@
+package ewffixture
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+)
+
+// ZFSNode is one file, directory or symlink of a ZFSDataset tree.
+type ZFSNode struct {
+	Name     string
+	Mode     uint64 // st_mode with the type bits: 0o100644, 0o040755, 0o120777
+	UID, GID uint64
+	MTime    int64
+	Data     []byte // file content
+	Target   string // symlink target
+	Children []*ZFSNode
+}
+
+// ZFSDataset is one filesystem of a ZFSPool, with its snapshots and child
+// datasets.
+type ZFSDataset struct {
+	// Name is the path component below the parent dataset, or the snapshot
+	// name for an entry of Snapshots. The root dataset is named after the
+	// pool.
+	Name      string
+	Root      *ZFSNode // root directory; nil for an empty one
+	Legacy    bool     // znode_phys_t bonus buffers instead of system attributes
+	Volume    bool     // a zvol object set instead of a filesystem
+	Created   int64
+	Snapshots []*ZFSDataset
+	Children  []*ZFSDataset
+}
+
+// ZFSPool assembles a single-device ZFS pool image in memory. Data starts
+// at the 4 MiB boundary after the front labels; the image ends with the two
+// back labels.
+//
+// The pool is written by one transaction group. ZAP names are not hashed:
+// fat-ZAP entries fill leaves in order, which a reader that walks every leaf
+// cannot tell apart from hashed placement.
+type ZFSPool struct {
+	Name     string // default "tank"
+	GUID     uint64
+	Hostname string
+	TXG      uint64 // default 10
+	// Vdev is the top-level vdev type in the label: "" (disk), "mirror"
+	// (this device and an identical twin) or "raidz".
+	Vdev   string
+	VdevID uint64 // top-level vdev id, used in every DVA
+
+	BlockSize     int   // file record size (default 128 KiB)
+	IndirectShift uint8 // log2 of the indirect block size (default 17)
+	MicroZAPMax   int   // directories with more entries are fat ZAPs (default 2047)
+
+	// Compression is the block pointer compression id written for blocks
+	// Compress makes smaller by at least one sector; Compress returns the
+	// exact on-disk payload, including any ZFS-specific header.
+	Compression uint8
+	Compress    func([]byte) []byte
+	// Embed stores file data whose compressed payload fits 112 bytes in
+	// the block pointer itself.
+	Embed    bool
+	Checksum uint8 // 7 fletcher4 (default) or 8 sha256
+	Copies   int   // DVAs per block, 1 to 3 (default 1)
+
+	// BrokenNewerUberblock also writes an uberblock for TXG+1 whose MOS
+	// block fails its checksum, so a reader must fall back to TXG.
+	BrokenNewerUberblock bool
+}
+
+// DMU object types the fixture writes.
+const (
+	zfsOTObjectDir   = 1
+	zfsOTDnode       = 10
+	zfsOTObjset      = 11
+	zfsOTDSLDir      = 12
+	zfsOTDSLChildMap = 13
+	zfsOTDSLSnapMap  = 14
+	zfsOTDSLDataset  = 16
+	zfsOTZnode       = 17
+	zfsOTPlainFile   = 19
+	zfsOTDirectory   = 20
+	zfsOTMasterNode  = 21
+	zfsOTZvol        = 23
+	zfsOTSA          = 44
+	zfsOTSAMaster    = 45
+	zfsOTSARegistry  = 46
+	zfsOTSALayouts   = 47
+)
+
+type zfsWriter struct {
+	p    *ZFSPool
+	txg  uint64
+	area []byte // allocatable space, from device offset 4 MiB
+}
+
+func zfsAllZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// block writes one logical block (a multiple of 512 bytes) and returns its
+// block pointer. An all-zero block is a hole.
+func (w *zfsWriter) block(data []byte, typ, level uint8, fill uint64) []byte {
+	le := binary.LittleEndian
+	bp := make([]byte, 128)
+	if zfsAllZero(data) {
+		return bp
+	}
+	comp, phys := uint8(2), data // ZIO_COMPRESS_OFF
+	if w.p.Compress != nil {
+		if c := w.p.Compress(data); c != nil && len(c) < len(data) {
+			if w.p.Embed && level == 0 && typ == zfsOTPlainFile && len(c) <= 112 {
+				return w.embedded(data, c, typ)
+			}
+			if padded := (len(c) + 511) &^ 511; padded < len(data) {
+				comp = w.p.Compression
+				phys = append(append([]byte(nil), c...), make([]byte, padded-len(c))...)
+			}
+		}
+	}
+	ck := w.p.Checksum
+	if ck == 0 {
+		ck = 7
+	}
+	for i := 0; i < min(max(w.p.Copies, 1), 3); i++ {
+		off := uint64(len(w.area))
+		w.area = append(w.area, phys...)
+		le.PutUint64(bp[16*i:], w.p.VdevID<<32|uint64(len(phys)>>9))
+		le.PutUint64(bp[16*i+8:], off>>9)
+	}
+	le.PutUint64(bp[48:], uint64(len(data)>>9-1)|uint64(len(phys)>>9-1)<<16|uint64(comp)<<32|
+		uint64(ck)<<40|uint64(typ)<<48|uint64(level)<<56|1<<63)
+	le.PutUint64(bp[80:], w.txg)
+	le.PutUint64(bp[88:], fill)
+	for i, v := range zfsChecksum(ck, phys) {
+		le.PutUint64(bp[96+8*i:], v)
+	}
+	return bp
+}
+
+// embedded packs a compressed payload into every block pointer word but
+// the properties (6) and the birth txg (10).
+func (w *zfsWriter) embedded(data, payload []byte, typ uint8) []byte {
+	le := binary.LittleEndian
+	bp := make([]byte, 128)
+	buf := make([]byte, 112)
+	copy(buf, payload)
+	for word, j := 0, 0; word < 16; word++ {
+		if word == 6 || word == 10 {
+			continue
+		}
+		copy(bp[8*word:8*word+8], buf[8*j:])
+		j++
+	}
+	le.PutUint64(bp[48:], uint64(len(data)-1)|uint64(len(payload)-1)<<25|uint64(w.p.Compression)<<32|
+		1<<39|uint64(typ)<<48|1<<63)
+	le.PutUint64(bp[80:], w.txg)
+	return bp
+}
+
+func zfsChecksum(alg uint8, b []byte) [4]uint64 {
+	var w [4]uint64
+	if alg == 8 {
+		sum := sha256.Sum256(b)
+		for i := range w {
+			w[i] = binary.BigEndian.Uint64(sum[8*i:])
+		}
+		return w
+	}
+	for i := 0; i+4 <= len(b); i += 4 {
+		w[0] += uint64(binary.LittleEndian.Uint32(b[i:]))
+		w[1] += w[0]
+		w[2] += w[1]
+		w[3] += w[2]
+	}
+	return w
+}
+
+// zfsObject is a dnode ready to be packed into its object set.
+type zfsObject struct {
+	typ, bonusType uint8
+	indShift       uint8
+	nlevels        uint8
+	blksz          int
+	maxblkid       uint64
+	bps            [][]byte
+	bonus          []byte
+}
+
+// object writes data as an object of blksz-byte blocks (0: one block of
+// the data rounded up to a sector) under a tree of indirect blocks deep
+// enough to hang from nblkptr block pointers.
+func (w *zfsWriter) object(typ uint8, data []byte, blksz, nblkptr int) *zfsObject {
+	if blksz == 0 {
+		blksz = max(512, (len(data)+511)&^511)
+	}
+	shift := w.p.IndirectShift
+	if shift == 0 {
+		shift = 17
+	}
+	o := &zfsObject{typ: typ, indShift: shift, nlevels: 1, blksz: blksz}
+	var bps [][]byte
+	for off := 0; off < len(data); off += blksz {
+		blk := make([]byte, blksz)
+		copy(blk, data[off:])
+		bps = append(bps, w.block(blk, typ, 0, 1))
+	}
+	if len(bps) > 0 {
+		o.maxblkid = uint64(len(bps) - 1)
+	}
+	per := 1 << (shift - 7)
+	for level := uint8(1); len(bps) > nblkptr; level++ {
+		var up [][]byte
+		for i := 0; i < len(bps); i += per {
+			ind := make([]byte, 1<<shift)
+			var fill uint64
+			for j, bp := range bps[i:min(i+per, len(bps))] {
+				copy(ind[128*j:], bp)
+				if bp[52]&0x80 != 0 { // embedded: word 11 is payload
+					fill++
+				} else {
+					fill += binary.LittleEndian.Uint64(bp[88:])
+				}
+			}
+			up = append(up, w.block(ind, typ, level, fill))
+		}
+		bps = up
+		o.nlevels = level + 1
+	}
+	for len(bps) < nblkptr {
+		bps = append(bps, make([]byte, 128))
+	}
+	o.bps = bps
+	return o
+}
+
+func (o *zfsObject) dnode() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 512)
+	b[0], b[1], b[2], b[3], b[4] = o.typ, o.indShift, o.nlevels, byte(len(o.bps)), o.bonusType
+	b[7] = 1 // DNODE_FLAG_USED_BYTES
+	le.PutUint16(b[8:], uint16(o.blksz>>9))
+	le.PutUint16(b[10:], uint16(len(o.bonus)))
+	le.PutUint64(b[16:], o.maxblkid)
+	for i, bp := range o.bps {
+		copy(b[64+128*i:], bp)
+	}
+	if 64+128*len(o.bps)+len(o.bonus) > 512 {
+		panic("ewffixture: ZFS bonus buffer does not fit its dnode")
+	}
+	copy(b[64+128*len(o.bps):], o.bonus)
+	return b
+}
+
+// zfsObjset collects an object set's dnodes; object 0 is never used.
+type zfsObjset struct {
+	objs []*zfsObject
+}
+
+func (s *zfsObjset) reserve() uint64 {
+	s.objs = append(s.objs, nil)
+	return uint64(len(s.objs) - 1)
+}
+
+func (s *zfsObjset) add(o *zfsObject) uint64 {
+	s.objs = append(s.objs, o)
+	return uint64(len(s.objs) - 1)
+}
+
+// write stores the dnode array under a meta dnode and returns the block
+// pointer of the objset_phys_t.
+func (w *zfsWriter) objset(s *zfsObjset, osType uint64) []byte {
+	arr := make([]byte, 512*len(s.objs))
+	for i, o := range s.objs {
+		if o != nil {
+			copy(arr[512*i:], o.dnode())
+		}
+	}
+	meta := w.object(zfsOTDnode, arr, 16<<10, 3)
+	phys := make([]byte, 1024)
+	copy(phys, meta.dnode())
+	binary.LittleEndian.PutUint64(phys[704:], osType)
+	return w.block(phys, zfsOTObjset, 0, uint64(len(s.objs)))
+}
+
+type zfsZAPEntry struct {
+	name   string
+	intlen int
+	values []uint64
+}
+
+func zfsU64(name string, v uint64) zfsZAPEntry {
+	return zfsZAPEntry{name: name, intlen: 8, values: []uint64{v}}
+}
+
+// zap writes a micro ZAP when the entries allow one and fat is unset, and
+// a fat ZAP of 16 KiB blocks otherwise.
+func (w *zfsWriter) zap(typ uint8, entries []zfsZAPEntry, fat bool) *zfsObject {
+	for _, e := range entries {
+		if e.intlen != 8 || len(e.values) != 1 || len(e.name) >= 50 {
+			fat = true
+		}
+	}
+	if fat || len(entries) > 2047 {
+		return w.object(typ, zfsFatZAP(entries), 16<<10, 1)
+	}
+	size := 512
+	for size < 64*(len(entries)+1) {
+		size *= 2
+	}
+	b := make([]byte, size)
+	le := binary.LittleEndian
+	le.PutUint64(b, 1<<63+3)
+	le.PutUint64(b[8:], 0x5A17)
+	for i, e := range entries {
+		ent := b[64*(i+1):]
+		le.PutUint64(ent, e.values[0])
+		copy(ent[14:64], e.name)
+	}
+	return w.object(typ, b, size, 1)
+}
+
+func zfsZAPChunks(e zfsZAPEntry) int {
+	return 1 + (len(e.name)+1+20)/21 + (len(e.values)*e.intlen+20)/21
+}
+
+func zfsFatZAP(entries []zfsZAPEntry) []byte {
+	le := binary.LittleEndian
+	const shift = 14
+	bs := 1 << shift
+	hashEntries := bs >> 5
+	nchunks := (bs-2*hashEntries)/24 - 2
+	var leaves [][]zfsZAPEntry
+	var cur []zfsZAPEntry
+	used := 0
+	for _, e := range entries {
+		n := zfsZAPChunks(e)
+		if used+n > nchunks || len(cur) == hashEntries {
+			leaves, cur, used = append(leaves, cur), nil, 0
+		}
+		cur, used = append(cur, e), used+n
+	}
+	leaves = append(leaves, cur)
+
+	out := make([]byte, bs*(1+len(leaves)))
+	hdr := out[:bs]
+	le.PutUint64(hdr, 1<<63+1)
+	le.PutUint64(hdr[8:], 0x2F52AB2AB)
+	le.PutUint64(hdr[32:], shift-4) // embedded pointer table of bs/16 entries
+	le.PutUint64(hdr[56:], uint64(len(leaves)+1))
+	le.PutUint64(hdr[64:], uint64(len(leaves)))
+	le.PutUint64(hdr[72:], uint64(len(entries)))
+	le.PutUint64(hdr[80:], 0x5A17)
+	tbl := hdr[bs/2:]
+	for i := 0; i < bs/16; i++ {
+		le.PutUint64(tbl[8*i:], uint64(1+i*len(leaves)/(bs/16)))
+	}
+	prefixLen := bits.Len(uint(len(leaves) - 1))
+	for i, l := range leaves {
+		zfsZAPLeaf(out[bs*(i+1):bs*(i+2)], l, hashEntries, nchunks, i, prefixLen)
+	}
+	return out
+}
+
+func zfsZAPLeaf(b []byte, entries []zfsZAPEntry, hashEntries, nchunks, prefix, prefixLen int) {
+	le := binary.LittleEndian
+	le.PutUint64(b, 1<<63)
+	le.PutUint64(b[16:], uint64(prefix))
+	le.PutUint32(b[24:], 0x2AB1EAF)
+	le.PutUint16(b[30:], uint16(len(entries)))
+	le.PutUint16(b[32:], uint16(prefixLen))
+	ht := b[48 : 48+2*hashEntries]
+	for i := 0; i < hashEntries; i++ {
+		le.PutUint16(ht[2*i:], 0xFFFF)
+	}
+	base := 48 + 2*hashEntries
+	chunk := func(i int) []byte { return b[base+24*i : base+24*i+24] }
+	next := 0
+	array := func(data []byte) int {
+		first := next
+		for off := 0; off < len(data); off += 21 {
+			c := chunk(next)
+			next++
+			c[0] = 251
+			copy(c[1:22], data[off:min(off+21, len(data))])
+			link := uint16(0xFFFF)
+			if off+21 < len(data) {
+				link = uint16(next)
+			}
+			le.PutUint16(c[22:], link)
+		}
+		return first
+	}
+	for i, e := range entries {
+		ec := next
+		next++
+		name := append([]byte(e.name), 0)
+		var vals []byte
+		for _, v := range e.values {
+			for k := e.intlen - 1; k >= 0; k-- {
+				vals = append(vals, byte(v>>(8*k)))
+			}
+		}
+		nameChunk := array(name)
+		valChunk := array(vals)
+		c := chunk(ec)
+		c[0], c[1] = 252, byte(e.intlen)
+		le.PutUint16(c[2:], 0xFFFF)
+		le.PutUint16(c[4:], uint16(nameChunk))
+		le.PutUint16(c[6:], uint16(len(name)))
+		le.PutUint16(c[8:], uint16(valChunk))
+		le.PutUint16(c[10:], uint16(len(e.values)))
+		le.PutUint16(ht[2*i:], uint16(ec))
+	}
+	free := uint16(0xFFFF)
+	if next < nchunks {
+		free = uint16(next)
+	}
+	le.PutUint16(b[28:], uint16(nchunks-next))
+	le.PutUint16(b[34:], free)
+	for i := next; i < nchunks; i++ {
+		c := chunk(i)
+		c[0] = 253
+		link := uint16(0xFFFF)
+		if i+1 < nchunks {
+			link = uint16(i + 1)
+		}
+		le.PutUint16(c[22:], link)
+	}
+}
+
+// System attributes of the ZPL registry: number and fixed length (0 for
+// variable-length attributes).
+var zfsSAAttrs = []struct {
+	name   string
+	num    uint64
+	length uint64
+}{
+	{"ZPL_ATIME", 0, 16}, {"ZPL_MTIME", 1, 16}, {"ZPL_CTIME", 2, 16}, {"ZPL_CRTIME", 3, 16},
+	{"ZPL_GEN", 4, 8}, {"ZPL_MODE", 5, 8}, {"ZPL_SIZE", 6, 8}, {"ZPL_PARENT", 7, 8},
+	{"ZPL_LINKS", 8, 8}, {"ZPL_XATTR", 9, 8}, {"ZPL_RDEV", 10, 8}, {"ZPL_FLAGS", 11, 8},
+	{"ZPL_UID", 12, 8}, {"ZPL_GID", 13, 8}, {"ZPL_PAD", 14, 32}, {"ZPL_ZNODE_ACL", 15, 88},
+	{"ZPL_DACL_COUNT", 16, 8}, {"ZPL_SYMLINK", 17, 0}, {"ZPL_SCANSTAMP", 18, 32},
+	{"ZPL_DACL_ACES", 19, 0}, {"ZPL_DXATTR", 20, 0}, {"ZPL_PROJID", 21, 8},
+}
+
+// Layout 2 holds the attributes of every file and directory; layout 3 adds
+// the ACL and, variable-length, the symlink target.
+var zfsSALayouts = map[string][]uint64{
+	"2": {5, 6, 4, 12, 13, 7, 11, 0, 1, 2, 3, 8},
+	"3": {5, 6, 4, 12, 13, 7, 11, 0, 1, 2, 3, 8, 16, 19, 17},
+}
+
+type zfsInode struct {
+	n             *ZFSNode
+	size, parent  uint64
+	links, mode   uint64
+	symlinkInline bool
+}
+
+func (in *zfsInode) saBonus() []byte {
+	le := binary.LittleEndian
+	n := in.n
+	t := uint64(n.MTime)
+	fixed := [][]byte{}
+	u64 := func(v uint64) []byte { return le.AppendUint64(nil, v) }
+	tm := append(u64(t), u64(0)...)
+	fixed = append(fixed, u64(in.mode), u64(in.size), u64(1), u64(n.UID), u64(n.GID), u64(in.parent),
+		u64(0), tm, tm, tm, tm, u64(in.links))
+	var b []byte
+	if in.mode&0o170000 == 0o120000 {
+		b = make([]byte, 16)
+		le.PutUint16(b[4:], 3|2<<10)
+		le.PutUint16(b[8:], uint16(len(n.Target))) // lengths[0] (ACEs) stays 0
+		fixed = append(fixed, u64(0), nil, []byte(n.Target))
+	} else {
+		b = make([]byte, 8)
+		le.PutUint16(b[4:], 2|1<<10)
+	}
+	le.PutUint32(b, 0x2F505A)
+	for _, a := range fixed {
+		b = append(b, a...)
+		for len(b)%8 != 0 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+func (in *zfsInode) znodeBonus() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 264)
+	for _, off := range []int{0, 16, 32, 48} {
+		le.PutUint64(b[off:], uint64(in.n.MTime))
+	}
+	le.PutUint64(b[64:], 1)
+	le.PutUint64(b[72:], in.mode)
+	le.PutUint64(b[80:], in.size)
+	le.PutUint64(b[88:], in.parent)
+	le.PutUint64(b[96:], in.links)
+	le.PutUint64(b[128:], in.n.UID)
+	le.PutUint64(b[136:], in.n.GID)
+	if in.symlinkInline {
+		b = append(b, in.n.Target...)
+	}
+	return b
+}
+
+// zplNode writes n and its subtree into s and returns n's object number.
+func (w *zfsWriter) zplNode(s *zfsObjset, legacy bool, n *ZFSNode, parent uint64) uint64 {
+	obj := s.reserve()
+	if parent == 0 {
+		parent = obj
+	}
+	in := &zfsInode{n: n, parent: parent, mode: n.Mode, links: 1, size: uint64(len(n.Data))}
+	var o *zfsObject
+	switch n.Mode & 0o170000 {
+	case 0o040000:
+		var ents []zfsZAPEntry
+		in.links = 2
+		for _, c := range n.Children {
+			co := w.zplNode(s, legacy, c, obj)
+			ents = append(ents, zfsU64(c.Name, co|(c.Mode&0o170000)>>12<<60))
+			if c.Mode&0o170000 == 0o040000 {
+				in.links++
+			}
+		}
+		in.size = uint64(len(ents) + 2)
+		limit := w.p.MicroZAPMax
+		if limit == 0 {
+			limit = 2047
+		}
+		o = w.zap(zfsOTDirectory, ents, len(ents) > limit)
+	case 0o120000:
+		in.size = uint64(len(n.Target))
+		if legacy && len(n.Target) > 512-64-128-264 {
+			o = w.object(zfsOTPlainFile, []byte(n.Target), 0, 1)
+		} else {
+			in.symlinkInline = legacy
+			o = w.object(zfsOTPlainFile, nil, 0, 1)
+		}
+	default:
+		bs := w.p.BlockSize
+		if bs == 0 {
+			bs = 128 << 10
+		}
+		if len(n.Data) <= bs {
+			bs = 0
+		}
+		o = w.object(zfsOTPlainFile, n.Data, bs, 1)
+	}
+	if legacy {
+		o.bonusType, o.bonus = zfsOTZnode, in.znodeBonus()
+	} else {
+		o.bonusType, o.bonus = zfsOTSA, in.saBonus()
+	}
+	s.objs[obj] = o
+	return obj
+}
+
+// datasetObjset writes a dataset's object set and returns its block
+// pointer.
+func (w *zfsWriter) datasetObjset(ds *ZFSDataset) []byte {
+	s := &zfsObjset{objs: []*zfsObject{nil}}
+	if ds.Volume {
+		s.add(w.object(zfsOTZvol, []byte("zvol block device contents"), 8192, 1))
+		return w.objset(s, 3)
+	}
+	master := s.reserve()
+	entries := []zfsZAPEntry{zfsU64("normalization", 0), zfsU64("utf8only", 0), zfsU64("casesensitivity", 0)}
+	if ds.Legacy {
+		entries = append(entries, zfsU64("VERSION", 3))
+	} else {
+		var reg, lay []zfsZAPEntry
+		for _, a := range zfsSAAttrs {
+			reg = append(reg, zfsU64(a.name, a.num|a.length<<24))
+		}
+		for _, name := range []string{"2", "3"} {
+			lay = append(lay, zfsZAPEntry{name: name, intlen: 2, values: zfsSALayouts[name]})
+		}
+		regObj := s.add(w.zap(zfsOTSARegistry, reg, false))
+		layObj := s.add(w.zap(zfsOTSALayouts, lay, true))
+		sa := s.add(w.zap(zfsOTSAMaster, []zfsZAPEntry{zfsU64("LAYOUTS", layObj), zfsU64("REGISTRY", regObj)}, false))
+		entries = append(entries, zfsU64("VERSION", 5), zfsU64("SA_ATTRS", sa))
+	}
+	root := ds.Root
+	if root == nil {
+		root = &ZFSNode{Mode: 0o040755}
+	}
+	entries = append(entries, zfsU64("ROOT", w.zplNode(s, ds.Legacy, root, 0)))
+	s.objs[master] = w.zap(zfsOTMasterNode, entries, false)
+	return w.objset(s, 2)
+}
+
+func zfsReferenced(n *ZFSNode) uint64 {
+	if n == nil {
+		return 0
+	}
+	total := uint64(len(n.Data))
+	for _, c := range n.Children {
+		total += zfsReferenced(c)
+	}
+	return total
+}
+
+func (w *zfsWriter) datasetPhys(ds *ZFSDataset, name string, dir, prev, next, snapnames uint64, bp []byte) *zfsObject {
+	le := binary.LittleEndian
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	b := make([]byte, 320)
+	le.PutUint64(b[0:], dir)
+	le.PutUint64(b[8:], prev)
+	le.PutUint64(b[24:], next)
+	le.PutUint64(b[32:], snapnames)
+	le.PutUint64(b[48:], uint64(ds.Created))
+	le.PutUint64(b[56:], w.txg)
+	le.PutUint64(b[72:], zfsReferenced(ds.Root))
+	le.PutUint64(b[112:], h.Sum64()^w.p.GUID)
+	copy(b[128:], bp)
+	o := w.object(zfsOTDSLDataset, nil, 0, 1)
+	o.bonusType, o.bonus = zfsOTDSLDataset, b
+	return o
+}
+
+func (w *zfsWriter) dslDirObject(head, parent, children uint64) *zfsObject {
+	le := binary.LittleEndian
+	b := make([]byte, 256)
+	le.PutUint64(b[8:], head)
+	le.PutUint64(b[16:], parent)
+	le.PutUint64(b[32:], children)
+	o := w.object(zfsOTDSLDir, nil, 0, 1)
+	o.bonusType, o.bonus = zfsOTDSLDir, b
+	return o
+}
+
+// dslDir writes the DSL directory of ds, its head dataset, snapshots and
+// child directories into the MOS and returns the directory's object.
+func (w *zfsWriter) dslDir(mos *zfsObjset, ds *ZFSDataset, name string, parent uint64) uint64 {
+	dirObj, headObj := mos.reserve(), mos.reserve()
+	var kids []zfsZAPEntry
+	if parent == 0 {
+		for _, internal := range []string{"$MOS", "$FREE"} {
+			kids = append(kids, zfsU64(internal, mos.add(w.dslDirObject(0, dirObj, 0))))
+		}
+	}
+	for _, c := range ds.Children {
+		kids = append(kids, zfsU64(c.Name, w.dslDir(mos, c, name+"/"+c.Name, dirObj)))
+	}
+	childMap := mos.add(w.zap(zfsOTDSLChildMap, kids, false))
+	var snaps []zfsZAPEntry
+	var prev uint64
+	for _, sn := range ds.Snapshots {
+		obj := mos.reserve()
+		mos.objs[obj] = w.datasetPhys(sn, name+"@"+sn.Name, dirObj, prev, headObj, 0, w.datasetObjset(sn))
+		snaps = append(snaps, zfsU64(sn.Name, obj))
+		prev = obj
+	}
+	snapMap := mos.add(w.zap(zfsOTDSLSnapMap, snaps, false))
+	mos.objs[headObj] = w.datasetPhys(ds, name, dirObj, prev, 0, snapMap, w.datasetObjset(ds))
+	mos.objs[dirObj] = w.dslDirObject(headObj, parent, childMap)
+	return dirObj
+}
+
+// zfsNV is one nvlist pair: the value is a uint64, string, nested list
+// ([]zfsNV) or list array ([][]zfsNV).
+type zfsNV struct {
+	name  string
+	value any
+}
+
+func zfsXDRString(s string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(s)))
+	b = append(b, s...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func zfsXDRList(pairs []zfsNV) []byte {
+	be := binary.BigEndian
+	b := be.AppendUint32(nil, 0) // version
+	b = be.AppendUint32(b, 1)    // NV_UNIQUE_NAME
+	for _, p := range pairs {
+		var typ, nelem uint32 = 0, 1
+		var val, embedded []byte
+		switch v := p.value.(type) {
+		case uint64:
+			typ, val = 8, be.AppendUint64(nil, v)
+		case string:
+			typ, val = 9, zfsXDRString(v)
+		case []zfsNV:
+			typ, embedded = 19, zfsXDRList(v)
+		case [][]zfsNV:
+			typ, nelem = 20, uint32(len(v))
+			for _, l := range v {
+				embedded = append(embedded, zfsXDRList(l)...)
+			}
+		}
+		name := zfsXDRString(p.name)
+		size := uint32(8 + len(name) + 8 + len(val))
+		b = be.AppendUint32(b, size)
+		b = be.AppendUint32(b, size)
+		b = append(b, name...)
+		b = be.AppendUint32(b, typ)
+		b = be.AppendUint32(b, nelem)
+		b = append(b, val...)
+		b = append(b, embedded...)
+	}
+	return append(b, make([]byte, 8)...)
+}
+
+// zfsEmbedChecksum fills the zio_eck_t at the end of b: a SHA-256 of b
+// taken with the checksum field holding the block's device offset.
+func zfsEmbedChecksum(b []byte, offset uint64) {
+	le := binary.LittleEndian
+	tail := b[len(b)-40:]
+	le.PutUint64(tail, 0x0210da7ab10c7a11)
+	clear(tail[8:])
+	le.PutUint64(tail[8:], offset)
+	sum := sha256.Sum256(b)
+	for i := 0; i < 4; i++ {
+		le.PutUint64(tail[8+8*i:], binary.BigEndian.Uint64(sum[8*i:]))
+	}
+}
+
+func (p *ZFSPool) config(txg, size uint64) []byte {
+	guid := p.GUID | 1
+	tree := []zfsNV{{"id", p.VdevID}, {"guid", guid + 1}, {"ashift", uint64(9)}, {"asize", size - 4<<20 - 512<<10}}
+	disk := func(i uint64) []zfsNV {
+		return []zfsNV{{"type", "disk"}, {"id", i}, {"guid", guid + 2 + i}, {"path", "/dev/da" + string(rune('0'+i))}}
+	}
+	switch p.Vdev {
+	case "":
+		tree = append([]zfsNV{{"type", "disk"}}, tree...)
+	case "raidz":
+		tree = append([]zfsNV{{"type", "raidz"}, {"nparity", uint64(1)}}, tree...)
+		tree = append(tree, zfsNV{"children", [][]zfsNV{disk(0), disk(1), disk(2)}})
+	default:
+		tree = append([]zfsNV{{"type", p.Vdev}}, tree...)
+		tree = append(tree, zfsNV{"children", [][]zfsNV{disk(0), disk(1)}})
+	}
+	cfg := []zfsNV{
+		{"version", uint64(5000)}, {"name", p.name()}, {"state", uint64(0)}, {"txg", txg},
+		{"pool_guid", p.GUID}, {"top_guid", guid + 1}, {"guid", guid + 2}, {"vdev_children", uint64(1)},
+		{"vdev_tree", tree}, {"features_for_read", []zfsNV{}},
+	}
+	if p.Hostname != "" {
+		cfg = append(cfg, zfsNV{"hostname", p.Hostname})
+	}
+	return append([]byte{1, 1, 0, 0}, zfsXDRList(cfg)...)
+}
+
+func (p *ZFSPool) name() string {
+	if p.Name == "" {
+		return "tank"
+	}
+	return p.Name
+}
+
+// Build writes the pool whose root dataset is root and returns the device
+// image.
+func (p *ZFSPool) Build(root *ZFSDataset) []byte {
+	le := binary.LittleEndian
+	w := &zfsWriter{p: p, txg: p.TXG}
+	if w.txg == 0 {
+		w.txg = 10
+	}
+	mos := &zfsObjset{objs: []*zfsObject{nil}}
+	objDir := mos.reserve()
+	rootDir := w.dslDir(mos, root, p.name(), 0)
+	mos.objs[objDir] = w.zap(zfsOTObjectDir, []zfsZAPEntry{zfsU64("root_dataset", rootDir)}, false)
+	rootbp := w.objset(mos, 1)
+
+	const labelSize = 256 << 10
+	size := uint64(4<<20+len(w.area)+2*labelSize+labelSize-1) &^ (labelSize - 1)
+	img := make([]byte, size)
+	copy(img[4<<20:], w.area)
+	cfg := p.config(w.txg, size)
+	for _, off := range []uint64{0, labelSize, size - 2*labelSize, size - labelSize} {
+		label := img[off : off+labelSize]
+		copy(label[16<<10:], cfg)
+		zfsEmbedChecksum(label[16<<10:128<<10], off+16<<10)
+		ub := func(txg uint64, bp []byte) {
+			slot := txg % 128 * 1024
+			b := label[128<<10+slot : 128<<10+slot+1024]
+			le.PutUint64(b, 0x00bab10c)
+			le.PutUint64(b[8:], 5000)
+			le.PutUint64(b[16:], txg)
+			le.PutUint64(b[32:], 1700000000+txg)
+			copy(b[40:], bp)
+			zfsEmbedChecksum(b, off+128<<10+slot)
+		}
+		ub(w.txg, rootbp)
+		if p.BrokenNewerUberblock {
+			bad := append([]byte(nil), rootbp...)
+			bad[96] ^= 0xFF
+			ub(w.txg+1, bad)
+		}
+	}
+	return img
+}
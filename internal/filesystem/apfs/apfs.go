@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/laenix/ewfgo/internal/crypt"
 	"github.com/laenix/ewfgo/internal/filesystem"
 )
 
@@ -123,6 +124,9 @@ type apfsExtent struct {
 	laddr  uint64 // logical byte offset in the file
 	length uint64 // byte length (FILE_EXTENT len_and_flags low 56 bits; verified bytes, not blocks)
 	paddr  uint64 // physical block number
+	// cryptoID is the XTS block tweak of the extent's first block on an
+	// encrypted volume.
+	cryptoID uint64
 }
 
 // APFS implements filesystem.FileSystem over an APFS container partition.
@@ -137,6 +141,10 @@ type APFS struct {
 	catalogRootOid uint64
 	maxXid         uint64
 	volumeName     string
+	vek            *crypt.XTS // catalog and file data cipher; nil = plaintext
+
+	// keys is the FileVault key material supplied with SetKeys.
+	keys []Key
 
 	// omapNodeCache caches object-map B-tree node blocks (immutable during a
 	// read-only mount), avoiding re-reading them for every catalog-node resolve.
@@ -229,6 +237,7 @@ func (apfs *APFS) Open(sectorData []byte) error {
 
 func (apfs *APFS) Close() error {
 	apfs.mounted = false
+	apfs.vek = nil
 	apfs.index = nil
 	apfs.omapNodeCache = nil
 	return nil
@@ -236,7 +245,8 @@ func (apfs *APFS) Close() error {
 
 // ensureMounted performs the full mount chain: find the live container
 // superblock in the checkpoint descriptor area, resolve the container
-// object-map, then the first valid volume (APSB).
+// object-map, then the volume (APSB) to mount: the first valid one, or with
+// FileVault keys the one they unlock (see selectVolume).
 func (apfs *APFS) ensureMounted() error {
 	if apfs.mounted {
 		return nil
@@ -297,12 +307,13 @@ func (apfs *APFS) ensureMounted() error {
 
 	apfs.omapNodeCache = make(map[uint64][]byte)
 
-	// Try each volume oid from the live NXSB; accept the first that resolves to
-	// a valid volume superblock.
+	// Collect each volume oid from the live NXSB that resolves to a valid
+	// volume superblock.
 	maxFs := apfsU32(liveNX, 0xb4)
 	if maxFs > 100 {
 		maxFs = 100
 	}
+	var vols []apfsVolume
 	for i := uint32(0); i < maxFs; i++ {
 		fsOid := apfsU64(liveNX, int(0xb8)+8*int(i))
 		if fsOid == 0 {
@@ -328,17 +339,26 @@ func (apfs *APFS) ensureMounted() error {
 		if err != nil {
 			continue
 		}
-		apfs.omapTreeRoot = apfsU64(volOmapBlk, 0x30)
-		apfs.catalogRootOid = apfsU64(apBlk, 0x88)
-		apfs.maxXid = apfsU64(apBlk, 0x10) // the volume's own checkpoint xid
-		apfs.volumeName = apfsReadCStr(apBlk[0x29e:], 256)
-		if apfs.omapTreeRoot == 0 || apfs.catalogRootOid == 0 {
+		v := apfsVolume{oid: fsOid, sb: apBlk, omapTree: apfsU64(volOmapBlk, 0x30)}
+		if v.omapTree == 0 || apfsU64(apBlk, 0x88) == 0 {
 			return fmt.Errorf("APFS: volume at oid %d has no object-map/catalog", fsOid)
 		}
-		apfs.mounted = true
-		return nil
+		vols = append(vols, v)
 	}
-	return fmt.Errorf("APFS: no valid volume superblock in the container")
+	if len(vols) == 0 {
+		return fmt.Errorf("APFS: no valid volume superblock in the container")
+	}
+	v, vek, err := apfs.selectVolume(liveNX, vols)
+	if err != nil {
+		return err
+	}
+	apfs.omapTreeRoot = v.omapTree
+	apfs.catalogRootOid = apfsU64(v.sb, 0x88)
+	apfs.maxXid = apfsU64(v.sb, 0x10) // the volume's own checkpoint xid
+	apfs.volumeName = v.name()
+	apfs.vek = vek
+	apfs.mounted = true
+	return nil
 }
 
 // resolveOmapOid resolves oid to a physical block through the object-map
@@ -473,7 +493,7 @@ func (apfs *APFS) walkCatalogNode(paddr uint64, depth int, idx *apfsIndex, visit
 	}
 	visited[paddr] = true
 
-	d, err := apfs.apfsReadBlock(paddr)
+	d, err := apfs.readCatalogBlock(paddr)
 	if err != nil {
 		return err
 	}
@@ -629,9 +649,10 @@ func (apfs *APFS) walkCatalogNode(paddr uint64, depth int, idx *apfsIndex, visit
 				continue
 			}
 			idx.extents[id] = append(idx.extents[id], apfsExtent{
-				laddr:  apfsU64(d, keyPos+8),
-				length: length,
-				paddr:  paddr,
+				laddr:    apfsU64(d, keyPos+8),
+				length:   length,
+				paddr:    paddr,
+				cryptoID: apfsU64(val, 16), // 0 when the value has no crypto_id
 			})
 		}
 	}
@@ -966,7 +987,7 @@ func (apfs *APFS) apfsReadStream(streamOID, size uint64) ([]byte, error) {
 			n = size - ext.laddr
 		}
 		remaining := n
-		paddr := ext.paddr
+		var blk uint64
 		off := ext.laddr
 		for remaining > 0 {
 			nblk := remaining / apfs.blocksize
@@ -980,12 +1001,12 @@ func (apfs *APFS) apfsReadStream(streamOID, size uint64) ([]byte, error) {
 			if take > remaining {
 				take = remaining
 			}
-			data, err := apfs.apfsReadBlocks(paddr, nblk)
+			data, err := apfs.readExtentBlocks(ext, blk, nblk)
 			if err != nil {
 				return nil, err
 			}
 			copy(out[off:off+take], data[:take])
-			paddr += nblk
+			blk += nblk
 			off += take
 			remaining -= take
 		}
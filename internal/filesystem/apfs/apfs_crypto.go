@@ -0,0 +1,436 @@
+package apfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// FileVault (software encryption) of APFS volumes.
+//
+// A software-encrypted volume (fs_flags APFS_FS_ONEKEY set, UNENCRYPTED
+// clear) encrypts its catalog nodes and file extents with XTS-AES-128 under
+// a per-volume encryption key (VEK); the container and volume superblocks
+// and the object maps stay in the clear. Every encrypted block is decrypted
+// in 512-byte data units: unit i of a block with tweak t uses t*(bs/512)+i,
+// where t is the block's physical address for catalog nodes and the
+// extent's crypto_id plus the block offset within the extent for file data.
+//
+// The VEK is stored wrapped in the container keybag (nx_keylocker), which
+// also points at each volume's keybag. The volume keybag holds one wrapped
+// key encryption key (KEK) per user and one for the personal recovery key.
+// Both keybags are themselves encrypted with XTS-AES-128 keyed with the
+// container or volume UUID. A password (or the recovery key string) yields
+// the KEK by PBKDF2-SHA256 and AES key unwrap (RFC 3394); the KEK unwraps
+// the VEK. The key wrap's integrity check verifies each step, and the VEK is
+// finally verified by decrypting the catalog root node and checking its
+// Fletcher-64 checksum.
+//
+// On T2 and Apple silicon Macs the encryption is done by the storage
+// controller and an image holds the plaintext; such a volume has the same
+// flags but its catalog reads valid without a key, and it is read as is.
+
+// Key is examiner-supplied key material for a FileVault-encrypted volume.
+type Key struct {
+	// Password is a user's login password or the volume's personal recovery
+	// key ("XXXX-XXXX-XXXX-XXXX-XXXX-XXXX"); either unwraps a KEK from the
+	// volume keybag.
+	Password string
+	// VEK is the volume encryption key itself (32 bytes), e.g. recovered
+	// from memory.
+	VEK []byte
+}
+
+// apfs_superblock_t fields and flags used here.
+const (
+	apfsVolUUIDOff     = 0xf0
+	apfsVolFlagsOff    = 0x108
+	apfsVolRoleOff     = 0x3c4
+	apfsFSUnencrypted  = 0x1
+	apfsFSOneKey       = 0x8
+	apfsVolRoleData    = 0x40
+	apfsNXKeylockerOff = 0x510 // nx_keylocker prange in nx_superblock_t
+)
+
+// Keybag object types and entry tags.
+const (
+	apfsObjContainerKeybag = 0x6b657973 // 'keys'
+	apfsObjVolumeKeybag    = 0x72656373 // 'recs'
+	apfsObjBtree           = 0x2
+	apfsObjBtreeNode       = 0x3
+	apfsKBTagVolumeKey     = 2 // container keybag: wrapped VEK
+	apfsKBTagUnlockRecords = 3 // container: volume keybag prange; volume: wrapped KEK
+	apfsMaxKeybagBlocks    = 16
+	apfsMaxIterations      = 1 << 24 // PBKDF2 rounds of a KEK; macOS uses far fewer
+)
+
+// apfsVolume is a volume superblock found while mounting.
+type apfsVolume struct {
+	oid      uint64
+	sb       []byte // the APSB block
+	omapTree uint64 // volume object-map B-tree root block
+}
+
+func (v apfsVolume) uuid() []byte { return v.sb[apfsVolUUIDOff : apfsVolUUIDOff+16] }
+func (v apfsVolume) name() string { return apfsReadCStr(v.sb[0x29e:], 256) }
+func (v apfsVolume) role() uint16 { return apfsU16(v.sb, apfsVolRoleOff) }
+
+// encrypted reports whether the volume is flagged as FileVault-encrypted.
+func (v apfsVolume) encrypted() bool {
+	f := apfsU64(v.sb, apfsVolFlagsOff)
+	return f&apfsFSUnencrypted == 0 && f&apfsFSOneKey != 0
+}
+
+// apfsKeybagEntry is one keybag_entry_t.
+type apfsKeybagEntry struct {
+	uuid []byte
+	tag  uint16
+	data []byte
+}
+
+// SetKeys supplies key material for FileVault-encrypted volumes. With keys
+// the handler mounts the first volume they unlock, preferring the Data
+// volume; a later mount uses the new keys.
+func (apfs *APFS) SetKeys(keys ...Key) {
+	apfs.keys = keys
+	apfs.Close()
+}
+
+// selectVolume picks the volume to mount from vols and returns the cipher
+// of its catalog and file data (nil when it is read as is). Without keys
+// it is the first volume, as before FileVault support, and an encrypted
+// one is an explicit error asking for credentials.
+func (apfs *APFS) selectVolume(nx []byte, vols []apfsVolume) (apfsVolume, *crypt.XTS, error) {
+	if len(apfs.keys) == 0 {
+		v := vols[0]
+		if v.encrypted() && !apfs.catalogValid(v, nil) {
+			return v, nil, fmt.Errorf("APFS: volume %q is FileVault-encrypted; a password, personal recovery key or volume key is required", v.name())
+		}
+		return v, nil, nil
+	}
+	var unlocked *apfsVolume
+	var unlockedKey *crypt.XTS
+	var lastErr error
+	for i, v := range vols {
+		if !v.encrypted() || apfs.catalogValid(v, nil) {
+			continue
+		}
+		x, err := apfs.unlockVolume(nx, v)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if v.role() == apfsVolRoleData {
+			return v, x, nil
+		}
+		if unlocked == nil {
+			unlocked, unlockedKey = &vols[i], x
+		}
+	}
+	if unlocked != nil {
+		return *unlocked, unlockedKey, nil
+	}
+	if lastErr != nil {
+		return apfsVolume{}, nil, lastErr
+	}
+	return vols[0], nil, nil
+}
+
+// unlockVolume recovers and verifies the VEK of v from the supplied keys.
+func (apfs *APFS) unlockVolume(nx []byte, v apfsVolume) (*crypt.XTS, error) {
+	var veks [][]byte
+	var passwords []string
+	for _, k := range apfs.keys {
+		if len(k.VEK) != 0 {
+			veks = append(veks, k.VEK)
+		}
+		if k.Password != "" {
+			passwords = append(passwords, k.Password)
+		}
+	}
+	if len(passwords) > 0 {
+		fromPw, err := apfs.passwordVEKs(nx, v, passwords)
+		if err != nil && len(veks) == 0 {
+			return nil, err
+		}
+		veks = append(veks, fromPw...)
+	}
+	for _, vek := range veks {
+		x, err := apfsVEKCipher(vek, v.uuid())
+		if err != nil {
+			continue
+		}
+		if apfs.catalogValid(v, x) {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("APFS: volume %q: %w", v.name(), filesystem.ErrWrongKey)
+}
+
+// passwordVEKs unwraps the VEK of v with every password that opens one of
+// the KEKs in its volume keybag.
+func (apfs *APFS) passwordVEKs(nx []byte, v apfsVolume, passwords []string) ([][]byte, error) {
+	uuid := v.uuid()
+	keylocker, n := apfsU64(nx, apfsNXKeylockerOff), apfsU64(nx, apfsNXKeylockerOff+8)
+	if keylocker == 0 || n == 0 {
+		return nil, fmt.Errorf("APFS: volume %q is encrypted but the container has no keybag", v.name())
+	}
+	ckb, err := apfs.readKeybag(keylocker, n, nx[0x48:0x58], apfsObjContainerKeybag)
+	if err != nil {
+		return nil, fmt.Errorf("APFS: container keybag: %w", err)
+	}
+	var vekBlob, recs []byte
+	for _, e := range ckb {
+		if !bytes.Equal(e.uuid, uuid) {
+			continue
+		}
+		switch e.tag {
+		case apfsKBTagVolumeKey:
+			vekBlob = e.data
+		case apfsKBTagUnlockRecords:
+			recs = e.data
+		}
+	}
+	if vekBlob == nil || len(recs) < 16 {
+		return nil, fmt.Errorf("APFS: container keybag has no key for volume %q", v.name())
+	}
+	vkb, err := apfs.readKeybag(apfsU64(recs, 0), apfsU64(recs, 8), uuid, apfsObjVolumeKeybag)
+	if err != nil {
+		return nil, fmt.Errorf("APFS: volume %q keybag: %w", v.name(), err)
+	}
+	vek, err := apfsParseKeyBlob(vekBlob)
+	if err != nil {
+		return nil, fmt.Errorf("APFS: volume %q VEK: %w", v.name(), err)
+	}
+	var out [][]byte
+	for _, e := range vkb {
+		if e.tag != apfsKBTagUnlockRecords {
+			continue
+		}
+		kek, err := apfsParseKeyBlob(e.data)
+		if err != nil || kek.iterations == 0 || kek.iterations > apfsMaxIterations {
+			continue
+		}
+		for _, pw := range passwords {
+			dk, err := pbkdf2.Key(sha256.New, pw, kek.salt, int(kek.iterations), 32)
+			if err != nil {
+				continue
+			}
+			k, err := apfsUnwrap(dk, kek.wrapped)
+			if err != nil {
+				continue
+			}
+			if key, err := apfsUnwrap(k, vek.wrapped); err == nil {
+				out = append(out, key)
+			}
+		}
+	}
+	return out, nil
+}
+
+// apfsUnwrap unwraps an RFC 3394 wrapped key under kek.
+func apfsUnwrap(kek, wrapped []byte) ([]byte, error) {
+	b, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.KeyUnwrap(b, wrapped)
+}
+
+// apfsVEKCipher builds the XTS-AES-128 cipher of a VEK. A 16-byte VEK (a
+// volume converted from CoreStorage) is extended with SHA-256 of the key and
+// the volume UUID.
+func apfsVEKCipher(vek, uuid []byte) (*crypt.XTS, error) {
+	switch len(vek) {
+	case 32:
+		return crypt.NewXTS(vek)
+	case 16:
+		h := sha256.Sum256(append(append([]byte(nil), vek...), uuid...))
+		return crypt.NewXTS(append(append([]byte(nil), vek...), h[:16]...))
+	}
+	return nil, fmt.Errorf("APFS: volume key of %d bytes", len(vek))
+}
+
+// readKeybag reads and decrypts the keybag of n blocks at paddr, encrypted
+// under uuid, and returns its entries.
+func (apfs *APFS) readKeybag(paddr, n uint64, uuid []byte, objType uint32) ([]apfsKeybagEntry, error) {
+	if n > apfsMaxKeybagBlocks {
+		return nil, fmt.Errorf("keybag of %d blocks", n)
+	}
+	d, err := apfs.apfsReadBlocks(paddr, n)
+	if err != nil {
+		return nil, err
+	}
+	x, err := crypt.NewXTS(append(append([]byte(nil), uuid...), uuid...))
+	if err != nil {
+		return nil, err
+	}
+	apfs.decryptBlocks(x, d, paddr)
+	if apfsU32(d, 0x18) != objType {
+		return nil, fmt.Errorf("object type %#x is not a keybag; the keybag does not decrypt", apfsU32(d, 0x18))
+	}
+	// kb_locker_t: version u16 (2), nkeys u16, nbytes u32, padding; then
+	// 16-byte aligned keybag_entry_t {uuid, tag u16, keylen u16, pad, data}.
+	if v := apfsU16(d, 0x20); v != 2 {
+		return nil, fmt.Errorf("keybag version %d: %w", v, filesystem.ErrUnsupported)
+	}
+	nkeys := int(apfsU16(d, 0x22))
+	var out []apfsKeybagEntry
+	off := 0x30
+	for i := 0; i < nkeys; i++ {
+		if off+24 > len(d) {
+			return nil, fmt.Errorf("keybag entry %d is truncated", i)
+		}
+		keylen := int(apfsU16(d, off+18))
+		if off+24+keylen > len(d) {
+			return nil, fmt.Errorf("keybag entry %d is truncated", i)
+		}
+		out = append(out, apfsKeybagEntry{uuid: d[off : off+16], tag: apfsU16(d, off+16), data: d[off+24 : off+24+keylen]})
+		off += (24 + keylen + 15) &^ 15
+	}
+	return out, nil
+}
+
+// apfsKeyBlob is a DER key blob of a keybag entry: a wrapped KEK (with its
+// PBKDF2 parameters) or a wrapped VEK (without).
+type apfsKeyBlob struct {
+	wrapped    []byte
+	iterations uint64
+	salt       []byte
+}
+
+// apfsParseKeyBlob parses SEQUENCE { [0] version, [1] HMAC, [2] salt,
+// [3] { [0] version, [1] UUID, [2] flags, [3] wrapped key, [4] PBKDF2
+// iterations, [5] PBKDF2 salt } }.
+func apfsParseKeyBlob(b []byte) (apfsKeyBlob, error) {
+	var kb apfsKeyBlob
+	tag, seq, _, err := apfsDER(b)
+	if err != nil || tag != 0x30 {
+		return kb, errors.New("key blob is not a DER sequence")
+	}
+	var inner []byte
+	for len(seq) > 0 {
+		var v []byte
+		if tag, v, seq, err = apfsDER(seq); err != nil {
+			return kb, err
+		}
+		if tag == 0xa3 {
+			inner = v
+		}
+	}
+	for len(inner) > 0 {
+		var v []byte
+		if tag, v, inner, err = apfsDER(inner); err != nil {
+			return kb, err
+		}
+		switch tag {
+		case 0x83:
+			kb.wrapped = v
+		case 0x84:
+			if len(v) > 8 {
+				return kb, errors.New("key blob iteration count overflows")
+			}
+			for _, c := range v {
+				kb.iterations = kb.iterations<<8 | uint64(c)
+			}
+		case 0x85:
+			kb.salt = v
+		}
+	}
+	if kb.wrapped == nil {
+		return kb, errors.New("key blob has no wrapped key")
+	}
+	return kb, nil
+}
+
+// apfsDER splits one DER element (tag, definite length) off b.
+func apfsDER(b []byte) (tag byte, val, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated DER element")
+	}
+	tag, n, p := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 3 || len(b) < 2+l {
+			return 0, nil, nil, errors.New("bad DER length")
+		}
+		n = 0
+		for _, c := range b[2 : 2+l] {
+			n = n<<8 | int(c)
+		}
+		p += l
+	}
+	if n > len(b)-p {
+		return 0, nil, nil, errors.New("truncated DER element")
+	}
+	return tag, b[p : p+n], b[p+n:], nil
+}
+
+// catalogValid reports whether the catalog root node of v, decrypted with x
+// when x is not nil, is a B-tree node with a correct checksum.
+func (apfs *APFS) catalogValid(v apfsVolume, x *crypt.XTS) bool {
+	paddr, err := apfs.resolveOmapOidAt(v.omapTree, apfsU64(v.sb, 0x88), apfsU64(v.sb, 0x10))
+	if err != nil {
+		return false
+	}
+	d, err := apfs.apfsReadBlock(paddr)
+	if err != nil {
+		return false
+	}
+	if x != nil {
+		apfs.decryptBlocks(x, d, paddr)
+	}
+	t := apfsU32(d, 0x18) & 0xffff
+	return (t == apfsObjBtree || t == apfsObjBtreeNode) && apfsU64(d, 0) == apfsChecksum(d)
+}
+
+// apfsChecksum is the Fletcher-64 checksum of an object, over its 32-bit
+// words after the checksum field.
+func apfsChecksum(d []byte) uint64 {
+	const m = 0xffffffff
+	var s1, s2 uint64
+	for i := 8; i+4 <= len(d); i += 4 {
+		s1 = (s1 + uint64(apfsU32(d, i))) % m
+		s2 = (s2 + s1) % m
+	}
+	c1 := m - (s1+s2)%m
+	c2 := m - (s1+c1)%m
+	return c2<<32 | c1
+}
+
+// decryptBlocks decrypts whole blocks in place, the first with block tweak
+// tweak.
+func (apfs *APFS) decryptBlocks(x *crypt.XTS, d []byte, tweak uint64) {
+	per := apfs.blocksize / 512
+	for i := uint64(0); i+512 <= uint64(len(d)); i += 512 {
+		x.Decrypt(d[i:i+512], d[i:i+512], tweak*per+i/512)
+	}
+}
+
+// readCatalogBlock reads the catalog node at paddr, decrypted on an
+// encrypted volume.
+func (apfs *APFS) readCatalogBlock(paddr uint64) ([]byte, error) {
+	d, err := apfs.apfsReadBlock(paddr)
+	if err != nil || apfs.vek == nil {
+		return d, err
+	}
+	apfs.decryptBlocks(apfs.vek, d, paddr)
+	return d, nil
+}
+
+// readExtentBlocks reads count blocks of ext starting blk blocks into it,
+// decrypted on an encrypted volume.
+func (apfs *APFS) readExtentBlocks(ext apfsExtent, blk, count uint64) ([]byte, error) {
+	d, err := apfs.apfsReadBlocks(ext.paddr+blk, count)
+	if err != nil || apfs.vek == nil {
+		return d, err
+	}
+	apfs.decryptBlocks(apfs.vek, d, ext.cryptoID+blk)
+	return d, nil
+}
@@ -34,6 +34,13 @@ import (
 const (
 	apfsDecmpfsMagic = 0x636d7066 // 'fpmc'
 	apfsCmpfChunk    = 0x10000    // 64 KiB per compressed chunk
+	// apfsDecmpfsMaxBytes bounds the uncompressed size a decmpfs header may
+	// declare; the whole file is decompressed into memory.
+	apfsDecmpfsMaxBytes = uint64(1) << 32
+	// apfsDecmpfsMaxRatio bounds the uncompressed size against the
+	// compressed payload: zlib's best ratio is about 1032:1 and LZVN's far
+	// lower, so a larger claim is a corrupt header, not a real file.
+	apfsDecmpfsMaxRatio = 2048
 )
 
 // apfsDecmpfsSize returns the uncompressed size declared by an inode's
@@ -84,37 +91,104 @@ func (apfs *APFS) apfsReadDecmpfs(ino uint64) ([]byte, error) {
 	if !found {
 		return nil, nil
 	}
-	if len(payload) < 16 {
-		return nil, fmt.Errorf("APFS: inode %d com.apple.decmpfs xattr too short (%d bytes)", ino, len(payload))
+	out, err := DecodeDecmpfs(payload, func() ([]byte, error) { return apfs.resourceFork(ino) })
+	if err != nil {
+		return nil, fmt.Errorf("APFS: inode %d: %w", ino, err)
+	}
+	return out, nil
+}
+
+// resourceFork reads the com.apple.ResourceFork xattr of ino, or nil when the
+// inode has none.
+func (apfs *APFS) resourceFork(ino uint64) ([]byte, error) {
+	for _, xa := range apfs.index.xattrs[ino] {
+		if xa.name != "com.apple.ResourceFork" {
+			continue
+		}
+		if xa.dataOID != 0 {
+			return apfs.apfsReadStream(xa.dataOID, xa.dataSize)
+		}
+		if len(xa.value) >= 4 {
+			return xa.value[4:], nil
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// DecmpfsHeader parses the 16-byte com.apple.decmpfs header at the start of
+// payload and returns the compression type and uncompressed size. ok is false
+// when payload is too short or the magic is wrong.
+func DecmpfsHeader(payload []byte) (typ uint32, size uint64, ok bool) {
+	if len(payload) < 16 || binary.LittleEndian.Uint32(payload[0:4]) != apfsDecmpfsMagic {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint32(payload[4:8]), binary.LittleEndian.Uint64(payload[8:16]), true
+}
+
+// DecodeDecmpfs decompresses a transparently-compressed file from its
+// com.apple.decmpfs payload (header plus any inline data). rsrc returns the
+// file's resource fork; it is only called for the resource-fork types and a
+// nil result is an error. The decoder is shared with the HFS+ handler, which
+// stores the same payload in its attributes B-tree and keeps the resource
+// fork as a real fork rather than an xattr.
+func DecodeDecmpfs(payload []byte, rsrc func() ([]byte, error)) ([]byte, error) {
+	typ, size, ok := DecmpfsHeader(payload)
+	if !ok {
+		return nil, fmt.Errorf("decmpfs: invalid header (%d bytes)", len(payload))
 	}
-	if binary.LittleEndian.Uint32(payload[0:4]) != apfsDecmpfsMagic {
-		return nil, fmt.Errorf("APFS: inode %d has an invalid com.apple.decmpfs magic", ino)
+	if size > apfsDecmpfsMaxBytes {
+		return nil, fmt.Errorf("decmpfs: uncompressed size %d exceeds the %d-byte limit", size, apfsDecmpfsMaxBytes)
 	}
-	typ := binary.LittleEndian.Uint32(payload[4:8])
-	size := binary.LittleEndian.Uint64(payload[8:16])
 	var out []byte
 	var err error
 	switch {
 	case typ == 3 || typ == 7 || typ == 9 || typ == 11 || typ == 13:
-		out, err = apfs.decmpfsInline(typ, size, payload[16:])
+		if err := checkDecmpfsRatio(size, len(payload)-16); err != nil {
+			return nil, err
+		}
+		out, err = decmpfsInline(typ, size, payload[16:])
 	case typ == 4 || typ == 8 || typ == 10 || typ == 12 || typ == 14:
-		out, err = apfs.decmpfsRsrc(ino, typ, size)
+		var fork []byte
+		if fork, err = rsrc(); err != nil {
+			return nil, err
+		}
+		if fork == nil {
+			return nil, fmt.Errorf("decmpfs: type %d has no resource fork", typ)
+		}
+		if err := checkDecmpfsRatio(size, len(fork)); err != nil {
+			return nil, err
+		}
+		if typ == 4 {
+			out, err = decmpfsRsrcZlib(fork, size)
+		} else {
+			out, err = decmpfsRsrcChunks(typ, fork, size)
+		}
 	default:
-		return nil, fmt.Errorf("APFS: inode %d uses unsupported decmpfs type %d", ino, typ)
+		return nil, fmt.Errorf("decmpfs: unsupported type %d", typ)
 	}
 	if err != nil {
 		return nil, err
 	}
 	if uint64(len(out)) != size {
-		return nil, fmt.Errorf("APFS: inode %d decmpfs produced %d bytes, want %d", ino, len(out), size)
+		return nil, fmt.Errorf("decmpfs: produced %d bytes, want %d", len(out), size)
 	}
 	return out, nil
 }
 
+// checkDecmpfsRatio rejects an uncompressed size no n-byte compressed
+// payload can produce, before anything of that size is allocated.
+func checkDecmpfsRatio(size uint64, n int) error {
+	if size > uint64(n)*apfsDecmpfsMaxRatio {
+		return fmt.Errorf("decmpfs: uncompressed size %d is implausible for a %d-byte payload", size, n)
+	}
+	return nil
+}
+
 // decmpfsInline decompresses an inline (odd-type) payload to size bytes.
-func (apfs *APFS) decmpfsInline(typ uint32, size uint64, cdata []byte) ([]byte, error) {
+func decmpfsInline(typ uint32, size uint64, cdata []byte) ([]byte, error) {
 	if len(cdata) == 0 {
-		return nil, fmt.Errorf("APFS: inline decmpfs payload empty")
+		return nil, fmt.Errorf("decmpfs: inline payload empty")
 	}
 	switch typ {
 	case 3: // Zlib
@@ -124,7 +198,7 @@ func (apfs *APFS) decmpfsInline(typ uint32, size uint64, cdata []byte) ([]byte,
 		case 0xFF:
 			return cdata[1:], nil
 		}
-		return nil, fmt.Errorf("APFS: inline decmpfs type 3 chunk has bad zlib marker 0x%02x", cdata[0])
+		return nil, fmt.Errorf("decmpfs: inline type 3 chunk has bad zlib marker 0x%02x", cdata[0])
 	case 7: // LZVN
 		if cdata[0] == 0x06 {
 			return cdata[1:], nil
@@ -133,73 +207,45 @@ func (apfs *APFS) decmpfsInline(typ uint32, size uint64, cdata []byte) ([]byte,
 	case 9: // uncompressed
 		return cdata[1:], nil
 	case 11: // LZFSE
-		return nil, fmt.Errorf("APFS: LZFSE decompression not implemented (decmpfs type 11)")
+		return nil, fmt.Errorf("decmpfs: LZFSE decompression not implemented (type 11)")
 	case 13: // LZBitmap
 		if cdata[0] == 0xFF {
 			return cdata[1:], nil
 		}
-		return nil, fmt.Errorf("APFS: LZBitmap decompression not implemented (decmpfs type 13)")
-	}
-	return nil, fmt.Errorf("APFS: unsupported inline decmpfs type %d", typ)
-}
-
-// decmpfsRsrc decompresses a resource-fork (even-type) payload. The resource
-// fork is read through the com.apple.ResourceFork xattr's external stream.
-func (apfs *APFS) decmpfsRsrc(ino uint64, typ uint32, size uint64) ([]byte, error) {
-	var rsrc []byte
-	for _, xa := range apfs.index.xattrs[ino] {
-		if xa.name != "com.apple.ResourceFork" {
-			continue
-		}
-		if xa.dataOID != 0 {
-			p, err := apfs.apfsReadStream(xa.dataOID, xa.dataSize)
-			if err != nil {
-				return nil, err
-			}
-			rsrc = p
-		} else if len(xa.value) >= 4 {
-			rsrc = xa.value[4:]
-		}
-		break
-	}
-	if rsrc == nil {
-		return nil, fmt.Errorf("APFS: inode %d decmpfs type %d has no com.apple.ResourceFork", ino, typ)
-	}
-	if typ == 4 {
-		return apfs.decmpfsRsrcZlib(rsrc, size)
+		return nil, fmt.Errorf("decmpfs: LZBitmap decompression not implemented (type 13)")
 	}
-	return decmpfsRsrcChunks(typ, rsrc, size)
+	return nil, fmt.Errorf("decmpfs: unsupported inline type %d", typ)
 }
 
 // decmpfsRsrcZlib handles the zlib resource fork: a big-endian RsrcForkHeader,
 // then a CmpfRsrc table of {off, size} entries pointing at each chunk.
-func (apfs *APFS) decmpfsRsrcZlib(rsrc []byte, size uint64) ([]byte, error) {
+func decmpfsRsrcZlib(rsrc []byte, size uint64) ([]byte, error) {
 	if len(rsrc) < 16 {
-		return nil, fmt.Errorf("APFS: zlib resource fork shorter than RsrcForkHeader")
+		return nil, fmt.Errorf("decmpfs: zlib resource fork shorter than RsrcForkHeader")
 	}
 	dataOffset := binary.BigEndian.Uint32(rsrc[0:4]) // be_uint32_t
 	if dataOffset > uint32(len(rsrc)) {
-		return nil, fmt.Errorf("APFS: zlib resource fork has invalid data offset %d", dataOffset)
+		return nil, fmt.Errorf("decmpfs: zlib resource fork has invalid data offset %d", dataOffset)
 	}
 	base := int(dataOffset) + 4 // skip the 4-byte data length after the offset
 	if base+4 > len(rsrc) {
-		return nil, fmt.Errorf("APFS: zlib resource fork CmpfRsrc out of range")
+		return nil, fmt.Errorf("decmpfs: zlib resource fork CmpfRsrc out of range")
 	}
 	entries := binary.LittleEndian.Uint32(rsrc[base:])
 	if entries > 64 { // a file decompressing to >4 GiB is not plausible here
-		return nil, fmt.Errorf("APFS: zlib resource fork has implausible %d chunks", entries)
+		return nil, fmt.Errorf("decmpfs: zlib resource fork has implausible %d chunks", entries)
 	}
 	out := make([]byte, 0, size)
 	for k := uint32(0); k < entries; k++ {
 		pos := base + 4 + 8*int(k)
 		if pos+8 > len(rsrc) {
-			return nil, fmt.Errorf("APFS: zlib resource fork chunk table truncated")
+			return nil, fmt.Errorf("decmpfs: zlib resource fork chunk table truncated")
 		}
 		off := binary.LittleEndian.Uint32(rsrc[pos:])
 		chunkLen := binary.LittleEndian.Uint32(rsrc[pos+4:])
 		s := base + int(off)
 		if s < 0 || s+int(chunkLen) > len(rsrc) {
-			return nil, fmt.Errorf("APFS: zlib resource fork chunk %d out of range", k)
+			return nil, fmt.Errorf("decmpfs: zlib resource fork chunk %d out of range", k)
 		}
 		src := rsrc[s : s+int(chunkLen)]
 		want := uint64(apfsCmpfChunk)
@@ -214,13 +260,13 @@ func (apfs *APFS) decmpfsRsrcZlib(rsrc []byte, size uint64) ([]byte, error) {
 		case src[0]&0x0f == 0x0f:
 			chunk = src[1:]
 		default:
-			err = fmt.Errorf("APFS: zlib resource fork chunk %d has bad marker 0x%02x", k, src[0])
+			err = fmt.Errorf("decmpfs: zlib resource fork chunk %d has bad marker 0x%02x", k, src[0])
 		}
 		if err != nil {
 			return nil, err
 		}
 		if uint64(len(chunk)) != want {
-			return nil, fmt.Errorf("APFS: zlib chunk %d produced %d bytes, want %d", k, len(chunk), want)
+			return nil, fmt.Errorf("decmpfs: zlib chunk %d produced %d bytes, want %d", k, len(chunk), want)
 		}
 		out = append(out, chunk...)
 	}
@@ -236,14 +282,14 @@ func decmpfsRsrcChunks(typ uint32, rsrc []byte, size uint64) ([]byte, error) {
 		return []byte{}, nil
 	}
 	if len(rsrc) < 4*(numChunks+1) {
-		return nil, fmt.Errorf("APFS: resource fork offset table too short (%d bytes, need %d)", len(rsrc), 4*(numChunks+1))
+		return nil, fmt.Errorf("decmpfs: resource fork offset table too short (%d bytes, need %d)", len(rsrc), 4*(numChunks+1))
 	}
 	out := make([]byte, 0, size)
 	for k := 0; k < numChunks; k++ {
 		start := binary.LittleEndian.Uint32(rsrc[4*k:])
 		end := binary.LittleEndian.Uint32(rsrc[4*(k+1):])
 		if int(end) > len(rsrc) || start >= end {
-			return nil, fmt.Errorf("APFS: resource fork chunk %d has bad range %d..%d", k, start, end)
+			return nil, fmt.Errorf("decmpfs: resource fork chunk %d has bad range %d..%d", k, start, end)
 		}
 		src := rsrc[start:end]
 		want := uint64(apfsCmpfChunk)
@@ -262,19 +308,19 @@ func decmpfsRsrcChunks(typ uint32, rsrc []byte, size uint64) ([]byte, error) {
 		case 10: // uncompressed
 			chunk = src[1:]
 		case 12: // LZFSE
-			return nil, fmt.Errorf("APFS: LZFSE decompression not implemented (decmpfs type 12)")
+			return nil, fmt.Errorf("decmpfs: LZFSE decompression not implemented (type 12)")
 		case 14: // LZBitmap
 			if src[0] == 0xFF {
 				chunk = src[1:]
 			} else {
-				return nil, fmt.Errorf("APFS: LZBitmap decompression not implemented (decmpfs type 14)")
+				return nil, fmt.Errorf("decmpfs: LZBitmap decompression not implemented (type 14)")
 			}
 		}
 		if err != nil {
 			return nil, err
 		}
 		if uint64(len(chunk)) != want {
-			return nil, fmt.Errorf("APFS: resource fork chunk %d produced %d bytes, want %d", k, len(chunk), want)
+			return nil, fmt.Errorf("decmpfs: resource fork chunk %d produced %d bytes, want %d", k, len(chunk), want)
 		}
 		out = append(out, chunk...)
 	}
@@ -286,16 +332,16 @@ func decmpfsRsrcChunks(typ uint32, rsrc []byte, size uint64) ([]byte, error) {
 func inflateZlib(src []byte, want uint64) ([]byte, error) {
 	zr, err := zlib.NewReader(bytes.NewReader(src))
 	if err != nil {
-		return nil, fmt.Errorf("APFS: invalid zlib stream: %w", err)
+		return nil, fmt.Errorf("decmpfs: invalid zlib stream: %w", err)
 	}
 	defer zr.Close()
 	out := make([]byte, int(want))
 	if _, err := io.ReadFull(zr, out); err != nil {
-		return nil, fmt.Errorf("APFS: zlib stream shorter than %d bytes: %w", want, err)
+		return nil, fmt.Errorf("decmpfs: zlib stream shorter than %d bytes: %w", want, err)
 	}
 	var extra [1]byte
 	if n, _ := zr.Read(extra[:]); n > 0 {
-		return nil, fmt.Errorf("APFS: zlib stream inflates beyond %d bytes", want)
+		return nil, fmt.Errorf("decmpfs: zlib stream inflates beyond %d bytes", want)
 	}
 	return out, nil
 }
@@ -30,7 +30,7 @@ import (
 // declared size read as zeros, matching APFS sparse-file semantics.
 //
 // Concurrency: the reader's state (size, block size, extent list) is immutable
-// after open, and every data read goes through apfsReadBlock / apfsReadBlocks,
+// after open, and every data read goes through readExtentBlocks,
 // which only read via the handler's readFunc (concurrency-safe). ReadAt is
 // therefore safe for concurrent use on the same handle without internal locking;
 // Read/Seek share a cursor and are not concurrent-safe.
@@ -174,7 +174,6 @@ func (r *apfsFileReader) readAt(p []byte, off int64) (int, error) {
 			continue
 		}
 		within := uint64(o) - ext.laddr
-		block := ext.paddr + within/r.blockSize
 		blockOff := within % r.blockSize
 		take := int64(r.blockSize) - int64(blockOff)
 		if uint64(take) > ext.length-within {
@@ -183,7 +182,7 @@ func (r *apfsFileReader) readAt(p []byte, off int64) (int, error) {
 		if take > int64(want)-int64(n) {
 			take = int64(want) - int64(n)
 		}
-		data, err := r.h.apfsReadBlock(block)
+		data, err := r.h.readExtentBlocks(ext, within/r.blockSize, 1)
 		if err != nil {
 			return n, err
 		}
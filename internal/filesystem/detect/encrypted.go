@@ -7,7 +7,10 @@ import (
 )
 
 // BitLocker/LUKS encrypted volume detection
-// Note: Full decryption is not possible without keys, but we can identify the volume type
+// Note: this handler only identifies the volume type. BitLocker and LUKS
+// volumes are decrypted with the examiner's key by ewf.EWFImage.UnlockBitLocker
+// and ewf.EWFImage.UnlockLUKS, which expose the plaintext volume as a virtual
+// partition.
 
 type BitLocker struct {
 	encrypted        bool
@@ -56,8 +59,8 @@ func (bl *BitLocker) Open(sectorData []byte) error {
 		return fmt.Errorf("BitLocker: sector data too small")
 	}
 
-	// Check for BitLocker signature
-	if len(sectorData) >= 8 && string(sectorData[:8]) == BitLockerMagic {
+	// Check for BitLocker signature (the OEM name of the volume header)
+	if string(sectorData[3:11]) == BitLockerMagic {
 		bl.version = "BitLocker"
 		bl.encrypted = true
 		bl.metadataSize = 0x100000 // 1MB typical
@@ -65,7 +68,7 @@ func (bl *BitLocker) Open(sectorData []byte) error {
 	}
 
 	// Check for LUKS signature
-	if len(sectorData) >= 6 && string(sectorData[:6]) == "LUKS" {
+	if len(sectorData) >= 6 && string(sectorData[:6]) == LUKSMagic {
 		bl.version = "LUKS"
 		bl.encrypted = true
 
@@ -1,5 +1,8 @@
-// Package ext4 provides an ext4 filesystem handler adapted from the parent
-// filesystem package. It qualifies every parent identifier with filesystem.
+// Package ext4 provides an ext2/ext3/ext4 filesystem handler adapted from
+// the parent filesystem package. It qualifies every parent identifier with
+// filesystem. Files are read through the ext4 extent tree or, for ext2/ext3
+// and non-extent files on upgraded volumes, the legacy direct and indirect
+// block maps.
 package ext4
 
 import (
@@ -17,6 +20,12 @@ const (
 	// ext4ExtentsFlag is the EXTENTS_FL i_flags bit: the inode uses the extent
 	// tree (not legacy direct/indirect block pointers).
 	ext4ExtentsFlag = 0x80000
+	// ext4InlineDataFlag is the INLINE_DATA_FL i_flags bit: the data lives in
+	// the inode itself, so i_block holds neither extents nor block pointers.
+	ext4InlineDataFlag = 0x10000000
+	// ext4DirectBlocks is the number of direct block pointers in i_block; the
+	// single-, double- and triple-indirect pointers follow them.
+	ext4DirectBlocks = 12
 	// ext4ExtentMagic is the extent tree header magic (0xF30A).
 	ext4ExtentMagic = 0xF30A
 	// ext4MaxExtentDepth bounds extent-tree recursion (a real tree is <=5).
@@ -61,6 +70,8 @@ type Ext4Handler struct {
 	descSize uint16
 	// superblockData is a copy of the superblock (for s_volume_name).
 	superblockData []byte
+	// fsType is ext2, ext3 or ext4 by the superblock feature flags.
+	fsType filesystem.FileSystemType
 }
 
 // NewExt4Handler creates a new ext4 handler
@@ -155,6 +166,7 @@ func (h *Ext4Handler) parseSuperblock(data []byte) error {
 
 	// Keep a copy of the superblock for the volume label (s_volume_name).
 	h.superblockData = append([]byte(nil), data[:1024]...)
+	h.fsType = filesystem.ExtFileSystemType(data)
 
 	if h.blocksPerGroup == 0 || h.inodesPerGroup == 0 || h.blockSize == 0 {
 		return fmt.Errorf("ext4: invalid superblock geometry (blockSize=%d blocksPerGroup=%d inodesPerGroup=%d)",
@@ -180,11 +192,10 @@ func (h *Ext4Handler) readInode(inodeNum uint32) ([]byte, error) {
 	groupNum := (inodeNum - 1) / h.inodesPerGroup
 	inodeIndex := (inodeNum - 1) % h.inodesPerGroup
 
-	// Group descriptor table is right after the superblock/block group 0.
-	var gdtBlock uint64 = uint64(h.firstDataBlock) + 1
-	if h.blockSize == 1024 {
-		gdtBlock = uint64(h.firstDataBlock) + 2
-	}
+	// The group descriptor table is the block after the superblock's:
+	// s_first_data_block is 1 for 1 KiB blocks (block 0 is the boot block)
+	// and 0 otherwise (the superblock sits inside block 0).
+	gdtBlock := h.firstDataBlock + 1
 
 	descSize := uint64(h.descSize)
 	if descSize == 0 {
@@ -375,6 +386,50 @@ func (h *Ext4Handler) resolveExtent(nodeData []byte, fileBlock uint64, depth uin
 	return h.resolveExtent(leaf, fileBlock, depth-1)
 }
 
+// resolveBlockMap resolves logical file block fileBlock through the legacy
+// block map in iBlock (the inode's 60-byte i_block): 12 direct pointers, then
+// the single-, double- and triple-indirect pointers, each indirect block an
+// array of blockSize/4 little-endian block numbers. A zero pointer at any
+// level is a sparse hole.
+func (h *Ext4Handler) resolveBlockMap(iBlock []byte, fileBlock uint64) (uint64, error) {
+	if len(iBlock) < 60 {
+		return 0, fmt.Errorf("ext4: block map too small")
+	}
+	perBlock := uint64(h.blockSize) / 4
+	slot, level, idx := fileBlock, 0, fileBlock
+	if fileBlock >= ext4DirectBlocks {
+		idx = fileBlock - ext4DirectBlocks
+		span := perBlock
+		for slot, level = ext4DirectBlocks, 1; idx >= span; slot, level = slot+1, level+1 {
+			if level == 3 {
+				return 0, fmt.Errorf("ext4: file block %d is beyond the triple-indirect map", fileBlock)
+			}
+			idx -= span
+			span *= perBlock
+		}
+	}
+	block := uint64(binary.LittleEndian.Uint32(iBlock[slot*4:]))
+	for ; level > 0; level-- {
+		if block == 0 {
+			break
+		}
+		data, err := h.readBlockBytes(block)
+		if err != nil {
+			return 0, err
+		}
+		div := uint64(1)
+		for i := 1; i < level; i++ {
+			div *= perBlock
+		}
+		block = uint64(binary.LittleEndian.Uint32(data[idx/div*4:]))
+		idx %= div
+	}
+	if block == 0 {
+		return 0, fmt.Errorf("ext4: file block %d is not mapped: %w", fileBlock, errExt4Hole)
+	}
+	return block, nil
+}
+
 // inodeSizeOf returns the 64-bit file size (i_size_lo | i_size_high<<32).
 func (h *Ext4Handler) inodeSizeOf(inodeData []byte) uint64 {
 	lo := uint64(binary.LittleEndian.Uint32(inodeData[0x04:]))
@@ -454,6 +509,67 @@ func (h *Ext4Handler) readExtentData(inodeNum uint32, inodeData []byte, size uin
 	return out[:size], nil
 }
 
+// readBlockMapData reads size bytes of a file described by inodeData through
+// its legacy block map. Holes (zero pointers) read as zero bytes, as the
+// kernel returns them; a size the map cannot address is rejected before any
+// allocation.
+func (h *Ext4Handler) readBlockMapData(inodeNum uint32, inodeData []byte, size uint64) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	if len(inodeData) < 0x28+60 {
+		return nil, fmt.Errorf("ext4: inode %d too small for block map", inodeNum)
+	}
+	blockSize := uint64(h.blockSize)
+	fileBlocks := size / blockSize
+	if size%blockSize != 0 {
+		fileBlocks++
+	}
+	if fileBlocks > ext4MaxFileBlocks {
+		return nil, fmt.Errorf("ext4: inode %d size %d exceeds maximum readable %d blocks", inodeNum, size, ext4MaxFileBlocks)
+	}
+	iBlock := inodeData[0x28 : 0x28+60]
+
+	initialCap := size
+	if initialCap > 1<<20 {
+		initialCap = 1 << 20
+	}
+	out := make([]byte, 0, initialCap)
+	for n := uint64(0); n < fileBlocks; n++ {
+		phys, err := h.resolveBlockMap(iBlock, n)
+		if errors.Is(err, errExt4Hole) {
+			out = append(out, make([]byte, blockSize)...)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ext4: inode %d file block %d: %w", inodeNum, n, err)
+		}
+		blk, err := h.readBlockBytes(phys)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, blk...)
+	}
+	return out[:size], nil
+}
+
+// readInodeData reads size bytes of the file described by inodeData through
+// its extent tree or its block map, whichever the inode uses. Inline-data
+// inodes are rejected explicitly.
+func (h *Ext4Handler) readInodeData(inodeNum uint32, inodeData []byte, size uint64) ([]byte, error) {
+	if len(inodeData) < 0x24 {
+		return nil, fmt.Errorf("ext4: inode %d too small", inodeNum)
+	}
+	flags := binary.LittleEndian.Uint32(inodeData[0x20:])
+	switch {
+	case flags&ext4InlineDataFlag != 0:
+		return nil, fmt.Errorf("ext4: inode %d stores inline data: %w", inodeNum, filesystem.ErrUnsupported)
+	case flags&ext4ExtentsFlag != 0:
+		return h.readExtentData(inodeNum, inodeData, size)
+	}
+	return h.readBlockMapData(inodeNum, inodeData, size)
+}
+
 // resolvePathToInode walks a path from the root inode (2) through directory
 // entries and returns the target inode number. The root itself has no entry.
 func (h *Ext4Handler) resolvePathToInode(path string) (uint32, error) {
@@ -523,13 +639,8 @@ func (h *Ext4Handler) readDirectory(inodeNum uint32, parentPath string) ([]files
 	if mode&0x4000 == 0 {
 		return nil, fmt.Errorf("ext4: inode %d is not a directory: %w", inodeNum, filesystem.ErrNotDirectory)
 	}
-	flags := binary.LittleEndian.Uint32(inodeData[0x20:])
-	if flags&ext4ExtentsFlag == 0 {
-		return nil, fmt.Errorf("ext4: directory inode %d uses unsupported (non-extent) block mapping", inodeNum)
-	}
-
 	size := h.inodeSizeOf(inodeData)
-	dirData, err := h.readExtentData(inodeNum, inodeData, size)
+	dirData, err := h.readInodeData(inodeNum, inodeData, size)
 	if err != nil {
 		return nil, err
 	}
@@ -585,10 +696,15 @@ func (h *Ext4Handler) parseDirectory(data []byte, parentPath string) ([]filesyst
 		isDir := fileType == 2
 
 		// The dirent carries no size — only the inode does. Read the child inode
-		// for a real size (never a fabricated 0), like the other handlers.
+		// for a real size (never a fabricated 0), like the other handlers. An
+		// ext2 volume without the FILETYPE feature leaves file_type 0, so the
+		// inode mode says whether the child is a directory.
 		childSize := uint64(0)
 		if childData, err := h.readInode(inode); err == nil && len(childData) >= 0x6E {
 			childSize = h.inodeSizeOf(childData)
+			if fileType == 0 {
+				isDir = binary.LittleEndian.Uint16(childData[0x00:])&0xF000 == 0x4000
+			}
 		}
 
 		entries = append(entries, filesystem.DirectoryEntry{
@@ -605,9 +721,13 @@ func (h *Ext4Handler) parseDirectory(data []byte, parentPath string) ([]filesyst
 	return entries, nil
 }
 
-// Type returns the filesystem type
+// Type returns the filesystem type: ext2, ext3 or ext4 by the superblock
+// feature flags (ext4 for a handler without a superblock).
 func (h *Ext4Handler) Type() filesystem.FileSystemType {
-	return filesystem.FS_EXT4
+	if h.fsType == "" {
+		return filesystem.FS_EXT4
+	}
+	return h.fsType
 }
 
 // Open initializes the filesystem (required by interface)
@@ -621,7 +741,7 @@ func (h *Ext4Handler) Close() error {
 }
 
 // GetFile reads a file's contents by resolving its inode and walking its extent
-// tree. The root path (a directory) and missing paths return explicit errors.
+// tree or block map. The root path (a directory) and missing paths return explicit errors.
 func (h *Ext4Handler) GetFile(path string) ([]byte, error) {
 	inodeNum, err := h.resolvePathToInode(path)
 	if err != nil {
@@ -646,10 +766,10 @@ func (h *Ext4Handler) GetFile(path string) ([]byte, error) {
 		if size <= 60 && uint64(len(inodeData)) >= 0x28+size {
 			return inodeData[0x28 : 0x28+size], nil
 		}
-		return h.readExtentData(inodeNum, inodeData, size)
+		return h.readInodeData(inodeNum, inodeData, size)
 	}
 	size := h.inodeSizeOf(inodeData)
-	return h.readExtentData(inodeNum, inodeData, size)
+	return h.readInodeData(inodeNum, inodeData, size)
 }
 
 // GetFileByPath gets file info by path, reading the real inode metadata.
@@ -776,8 +896,11 @@ func init() {
 	// fabricated listing). The real listing path is NewExt4Handler (reader-based,
 	// used by open_files.go/evidence.go), so DetectAndOpen can never hand out a
 	// canned listing.
-	filesystem.RegisterFileSystem(filesystem.FS_EXT4, func() filesystem.FileSystem { return &Ext4Handler{} })
-	filesystem.RegisterHandler(filesystem.FS_EXT4, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
-		return NewExt4Handler(r, startLBA)
-	})
+	// ext2 and ext3 share the handler; only their Type differs.
+	for _, t := range []filesystem.FileSystemType{filesystem.FS_EXT2, filesystem.FS_EXT3, filesystem.FS_EXT4} {
+		filesystem.RegisterFileSystem(t, func() filesystem.FileSystem { return &Ext4Handler{fsType: t} })
+		filesystem.RegisterHandler(t, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+			return NewExt4Handler(r, startLBA)
+		})
+	}
 }
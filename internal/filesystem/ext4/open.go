@@ -15,15 +15,15 @@ import (
 // streaming path for GB-scale files (sqlite databases etc.) that ReadFile
 // cannot hold in memory.
 //
-// Only extent-mapped regular files are streamable. A directory resolves to
-// ErrIsDirectory, a missing path to ErrNotFound, and a non-regular inode
-// (legacy block mapping, symlink, device) to an explicit error — nothing is
-// fabricated.
+// Regular files stream through their extent tree or legacy block map. A
+// directory resolves to ErrIsDirectory, a missing path to ErrNotFound, and a
+// non-regular or inline-data inode (symlink, device) to an explicit error —
+// nothing is fabricated.
 //
-// Concurrency: the reader's state (size, block size, extent root, tree depth)
-// is immutable after open, and every data read goes through resolveExtent /
-// readBlockBytes, which only read via the handler's reader.ReadSectors
-// (concurrency-safe). ReadAt is therefore safe for concurrent use on the same
+// Concurrency: the reader's state (size, block size, extent root or block
+// map, tree depth) is immutable after open, and every data read goes through
+// resolveExtent / resolveBlockMap / readBlockBytes, which only read via the
+// handler's reader.ReadSectors (concurrency-safe). ReadAt is therefore safe for concurrent use on the same
 // handle without internal locking; Read/Seek share a cursor and are not
 // concurrent-safe.
 func (h *Ext4Handler) OpenFile(path string) (io.ReadSeekCloser, error) {
@@ -57,8 +57,8 @@ func (h *Ext4Handler) openInode(inodeNum uint32) (io.ReadSeekCloser, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ext4: inode %d: %w", inodeNum, err)
 	}
-	if len(inodeData) < 0x28+12 {
-		return nil, fmt.Errorf("ext4: inode %d too small for extent root", inodeNum)
+	if len(inodeData) < 0x28+60 {
+		return nil, fmt.Errorf("ext4: inode %d too small for i_block", inodeNum)
 	}
 
 	// i_mode file-type bits (0xF000): 0x4000 = directory, 0x8000 = regular.
@@ -66,7 +66,7 @@ func (h *Ext4Handler) openInode(inodeNum uint32) (io.ReadSeekCloser, error) {
 	case 0x4000:
 		return nil, fmt.Errorf("ext4: inode %d is a directory: %w", inodeNum, filesystem.ErrIsDirectory)
 	case 0x8000:
-		// regular file: stream via its extent tree
+		// regular file: stream via its extent tree or block map
 	default:
 		return nil, fmt.Errorf("ext4: inode %d is not a regular file (mode 0x%04X): %w",
 			inodeNum, mode, filesystem.ErrUnsupported)
@@ -78,8 +78,17 @@ func (h *Ext4Handler) openInode(inodeNum uint32) (io.ReadSeekCloser, error) {
 	}
 
 	flags := binary.LittleEndian.Uint32(inodeData[0x20:])
+	if flags&ext4InlineDataFlag != 0 {
+		return nil, fmt.Errorf("ext4: inode %d stores inline data: %w", inodeNum, filesystem.ErrUnsupported)
+	}
 	if flags&ext4ExtentsFlag == 0 {
-		return nil, fmt.Errorf("ext4: inode %d uses unsupported (non-extent) block mapping", inodeNum)
+		return &ext4FileReader{
+			h:         h,
+			size:      int64(size),
+			blockSize: uint64(h.blockSize),
+			root:      inodeData[0x28 : 0x28+60],
+			blockMap:  true,
+		}, nil
 	}
 	root := inodeData[0x28:]
 	if magic := binary.LittleEndian.Uint16(root[0:2]); magic != ext4ExtentMagic {
@@ -98,19 +107,21 @@ func (h *Ext4Handler) openInode(inodeNum uint32) (io.ReadSeekCloser, error) {
 	}, nil
 }
 
-// ext4FileReader is a lazy, seekable reader over an ext4 file's extent tree.
+// ext4FileReader is a lazy, seekable reader over an ext4 file's extent tree
+// or an ext2/ext3-style block map.
 type ext4FileReader struct {
 	h         *Ext4Handler
 	size      int64
 	blockSize uint64
-	root      []byte // extent tree root (12-byte header + entries) from the inode
+	root      []byte // extent tree root (12-byte header + entries) or i_block from the inode
 	depth     uint16
+	blockMap  bool // root is a legacy direct/indirect block map
 	pos       int64
 }
 
 // readAt copies into p the bytes of the file starting at off, resolving each
-// logical file block through the extent tree and reading only the blocks that
-// intersect the range. It returns io.EOF when off is at or past the end of the
+// logical file block through the extent tree or block map and reading only
+// the blocks that intersect the range. It returns io.EOF when off is at or past the end of the
 // file, and n < len(p) with io.EOF for a range that runs past the end (the
 // readable prefix is real data; nothing past the end is fabricated).
 func (r *ext4FileReader) readAt(p []byte, off int64) (int, error) {
@@ -144,7 +155,13 @@ func (r *ext4FileReader) readAt(p []byte, off int64) (int, error) {
 			take = int64(want) - int64(n)
 		}
 
-		phys, err := r.h.resolveExtent(r.root, blockIdx, r.depth)
+		var phys uint64
+		var err error
+		if r.blockMap {
+			phys, err = r.h.resolveBlockMap(r.root, blockIdx)
+		} else {
+			phys, err = r.h.resolveExtent(r.root, blockIdx, r.depth)
+		}
 		if err != nil {
 			if errors.Is(err, errExt4Hole) {
 				// Sparse hole: ext4 defines an unallocated block within the
@@ -217,7 +234,8 @@ func (r *ext4FileReader) Seek(offset int64, whence int) (int64, error) {
 }
 
 // Close releases the reader. The shared handler/image stay open (owned by the
-// caller); this reader holds no resources beyond the extent root slice.
+// caller); this reader holds no resources beyond the extent root or block map
+// slice.
 func (r *ext4FileReader) Close() error { return nil }
 
 var _ io.ReadSeekCloser = (*ext4FileReader)(nil)
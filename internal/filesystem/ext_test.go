@@ -0,0 +1,191 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	_ "github.com/laenix/ewfgo/internal/filesystem/ext4"
+)
+
+// memExtReader is a fake Reader over an in-memory ext image.
+type memExtReader struct {
+	data []byte
+}
+
+func (r *memExtReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start := lba * 512
+	end := start + count*512
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("ext: read past end of image")
+	}
+	return r.data[start:end], nil
+}
+
+// buildExtTree returns a tree whose files reach every level of the block map
+// for blockSize: direct blocks only, a dense file through the double-indirect
+// blocks, and a sparse file with data in the single-, double- and
+// triple-indirect ranges (returned as its block index -> content).
+func buildExtTree(blockSize int) (*ewffixture.ExtNode, []byte, map[uint64][]byte, uint64) {
+	per := uint64(blockSize / 4)
+	dense := make([]byte, (12+int(per)+30)*blockSize+77)
+	for i := range dense {
+		dense[i] = byte(i*7 + i>>10)
+	}
+	triple := 12 + per + per*per
+	sparse := map[uint64][]byte{
+		3:              bytes.Repeat([]byte("d"), blockSize),
+		12 + per/2:     []byte("single"),
+		12 + per + 5:   []byte("double"),
+		triple + per*2: []byte("triple"),
+	}
+	size := (triple+per*2+1)*uint64(blockSize) + 10
+	root := &ewffixture.ExtNode{Children: []*ewffixture.ExtNode{
+		{Name: "fixture.txt", Data: []byte("fixture\n")},
+		{Name: "dense.bin", Data: dense},
+		{Name: "sparse.bin", Blocks: sparse, Size: size},
+		{Name: "dir", Dir: true, Children: []*ewffixture.ExtNode{
+			{Name: "nested.txt", Data: []byte("nested\n")},
+		}},
+	}}
+	for i := 0; i < 40; i++ {
+		root.Children[3].Children = append(root.Children[3].Children,
+			&ewffixture.ExtNode{Name: fmt.Sprintf("entry-with-a-long-name-%02d", i)})
+	}
+	return root, dense, sparse, size
+}
+
+// TestExtBlockMaps reads ext2, ext3 and upgraded ext4 volumes whose files
+// use direct, single-, double- and triple-indirect block maps, through
+// GetFile and the streaming reader.
+func TestExtBlockMaps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		img  ewffixture.ExtImage
+		want filesystem.FileSystemType
+	}{
+		{"ext2", ewffixture.ExtImage{Label: "OLDDISK"}, filesystem.FS_EXT2},
+		{"ext3", ewffixture.ExtImage{Journal: true}, filesystem.FS_EXT3},
+		{"upgraded ext4", ewffixture.ExtImage{BlockSize: 4096, Journal: true, Extents: true}, filesystem.FS_EXT4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bs := tc.img.BlockSize
+			if bs == 0 {
+				bs = 1024
+			}
+			root, dense, sparse, size := buildExtTree(bs)
+			data := tc.img.Build(root)
+			if got := filesystem.DetectFileSystem(data[:129*512]); got != tc.want {
+				t.Fatalf("DetectFileSystem = %q, want %q", got, tc.want)
+			}
+			fs, err := filesystem.NewHandler(tc.want, &memExtReader{data}, 0, uint64(len(data)/512))
+			if err != nil {
+				t.Fatalf("NewHandler: %v", err)
+			}
+			if fs.Type() != tc.want {
+				t.Errorf("Type() = %q, want %q", fs.Type(), tc.want)
+			}
+			if got := fs.GetVolumeLabel(); got != tc.img.Label {
+				t.Errorf("label = %q, want %q", got, tc.img.Label)
+			}
+
+			entries, err := fs.ListDirectory("/")
+			if err != nil {
+				t.Fatalf("ListDirectory(/): %v", err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, fmt.Sprintf("%s:%v:%d", e.Path, e.IsDir, e.Size))
+			}
+			sort.Strings(names)
+			want := []string{"/dense.bin:false:" + fmt.Sprint(len(dense)), fmt.Sprintf("/dir:true:%d", bs*2),
+				"/fixture.txt:false:8", fmt.Sprintf("/lost+found:true:%d", bs), fmt.Sprintf("/sparse.bin:false:%d", size)}
+			if bs == 4096 {
+				want[1] = fmt.Sprintf("/dir:true:%d", bs)
+			}
+			if !equalStrings(names, want) {
+				t.Errorf("root = %v, want %v", names, want)
+			}
+			if dir, err := fs.ListDirectory("/dir"); err != nil || len(dir) != 41 {
+				t.Errorf("ListDirectory(/dir) = %d entries, %v", len(dir), err)
+			}
+
+			for path, want := range map[string][]byte{
+				"/fixture.txt":    []byte("fixture\n"),
+				"/dir/nested.txt": []byte("nested\n"),
+				"/dense.bin":      dense,
+			} {
+				got, err := fs.GetFile(path)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("GetFile(%s) = %d bytes, %v; want %d bytes", path, len(got), err, len(want))
+				}
+			}
+
+			opener := fs.(filesystem.FileOpener)
+			rc, err := opener.OpenFile("/dense.bin")
+			if err != nil {
+				t.Fatalf("OpenFile(dense.bin): %v", err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil || !bytes.Equal(got, dense) {
+				t.Errorf("streamed dense.bin = %d bytes, %v", len(got), err)
+			}
+
+			rc, err = opener.OpenFile("/sparse.bin")
+			if err != nil {
+				t.Fatalf("OpenFile(sparse.bin): %v", err)
+			}
+			defer rc.Close()
+			ra := rc.(io.ReaderAt)
+			for block, content := range sparse {
+				buf := make([]byte, bs+2)
+				off := int64(block)*int64(bs) - 1
+				if _, err := ra.ReadAt(buf, off); err != nil {
+					t.Fatalf("ReadAt block %d: %v", block, err)
+				}
+				want := append(append([]byte{0}, content...), make([]byte, bs+1-len(content))...)
+				if !bytes.Equal(buf, want) {
+					t.Errorf("sparse block %d = %q...", block, buf[:8])
+				}
+			}
+			if end, _ := rc.Seek(0, io.SeekEnd); uint64(end) != size {
+				t.Errorf("sparse.bin size = %d, want %d", end, size)
+			}
+			tail := make([]byte, 20)
+			if n, err := ra.ReadAt(tail, int64(size)-10); n != 10 || err != io.EOF || !bytes.Equal(tail[:n], make([]byte, 10)) {
+				t.Errorf("tail ReadAt = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+// A sparse ext2 file small enough for GetFile reads its holes as zeros.
+func TestExtBlockMapHoles(t *testing.T) {
+	content := map[uint64][]byte{1: []byte("one"), 20: []byte("twenty"), 300: []byte("three hundred")}
+	root := &ewffixture.ExtNode{Children: []*ewffixture.ExtNode{{Name: "holes", Blocks: content, Size: 301*1024 + 5}}}
+	data := (&ewffixture.ExtImage{}).Build(root)
+	fs, err := filesystem.NewHandler(filesystem.FS_EXT2, &memExtReader{data}, 0, uint64(len(data)/512))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	got, err := fs.GetFile("/holes")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	want := make([]byte, 301*1024+5)
+	for b, c := range content {
+		copy(want[b*1024:], c)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("GetFile(/holes) differs")
+	}
+	if _, err := fs.GetFile("/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("GetFile(/missing) error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,440 @@
+package f2fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// Dentry layout (struct f2fs_dentry_block and the inline variant): a slot
+// bitmap, reserved bytes, an array of {hash u32, ino u32, name_len u16,
+// file_type u8} and an array of 8-byte name slots. A name spans
+// ceil(name_len/8) consecutive slots.
+const (
+	dentrySize       = 11
+	dentrySlotLen    = 8
+	dentriesPerBlock = 214
+	dentryBitmapSize = 27
+	dentryReserved   = 3
+	maxDirHashDepth  = 63
+	maxDirBlocks     = 1 << 26
+	fileTypeDir      = 2
+)
+
+// dentry is one directory entry.
+type dentry struct {
+	name  string
+	hash  uint32
+	ino   uint32
+	ftype byte
+}
+
+// parseDentries walks n dentry slots. Bits are numbered little-endian
+// (test_bit_le). A set slot with a zero or overrunning name length is
+// skipped, as the kernel does.
+func parseDentries(bitmap, dentries, names []byte, n int) []dentry {
+	var out []dentry
+	for i := 0; i < n; {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			i++
+			continue
+		}
+		d := dentries[i*dentrySize:]
+		nameLen := int(binary.LittleEndian.Uint16(d[8:]))
+		slots := (nameLen + dentrySlotLen - 1) / dentrySlotLen
+		if nameLen == 0 || i+slots > n {
+			i++
+			continue
+		}
+		out = append(out, dentry{
+			name:  string(names[i*dentrySlotLen : i*dentrySlotLen+nameLen]),
+			hash:  binary.LittleEndian.Uint32(d[0:]),
+			ino:   binary.LittleEndian.Uint32(d[4:]),
+			ftype: d[10],
+		})
+		i += slots
+	}
+	return out
+}
+
+// parseDentryBlock parses a 4 KiB dentry block.
+func parseDentryBlock(b []byte) []dentry {
+	const dentries = dentryBitmapSize + dentryReserved
+	const names = dentries + dentriesPerBlock*dentrySize
+	return parseDentries(b[:dentryBitmapSize], b[dentries:names], b[names:], dentriesPerBlock)
+}
+
+// parseInlineDentries parses an inode's inline dentry area, whose slot count
+// is derived from its size (NR_INLINE_DENTRY).
+func parseInlineDentries(area []byte) []dentry {
+	n := len(area) * 8 / ((dentrySize+dentrySlotLen)*8 + 1)
+	bitmapSize := (n + 7) / 8
+	reserved := len(area) - ((dentrySize+dentrySlotLen)*n + bitmapSize)
+	dentries := bitmapSize + reserved
+	names := dentries + n*dentrySize
+	return parseDentries(area[:bitmapSize], area[dentries:names], area[names:], n)
+}
+
+// dentryHash is f2fs_dentry_hash for a name without casefolding: TEA over
+// 16-byte chunks of the name. "." and ".." hash to 0.
+func dentryHash(name string) uint32 {
+	if name == "." || name == ".." {
+		return 0
+	}
+	buf := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	p := []byte(name)
+	for {
+		var in [8]uint32
+		str2hashbuf(p, in[:4])
+		teaTransform(&buf, in[:4])
+		if len(p) <= 16 {
+			break
+		}
+		p = p[16:]
+	}
+	return buf[0]
+}
+
+// str2hashbuf packs up to len(out)*4 bytes of msg into out, padding with a
+// value derived from the remaining length (fs/f2fs/hash.c).
+func str2hashbuf(msg []byte, out []uint32) {
+	pad := uint32(len(msg)) | uint32(len(msg))<<8
+	pad |= pad << 16
+	val := pad
+	if len(msg) > len(out)*4 {
+		msg = msg[:len(out)*4]
+	}
+	j := 0
+	for i, c := range msg {
+		if i%4 == 0 {
+			val = pad
+		}
+		val = uint32(c) + val<<8
+		if i%4 == 3 {
+			out[j] = val
+			j++
+			val = pad
+		}
+	}
+	if j < len(out) {
+		out[j] = val
+		j++
+	}
+	for ; j < len(out); j++ {
+		out[j] = pad
+	}
+}
+
+func teaTransform(buf *[4]uint32, in []uint32) {
+	const delta = 0x9E3779B9
+	var sum uint32
+	b0, b1 := buf[0], buf[1]
+	a, b, c, d := in[0], in[1], in[2], in[3]
+	for n := 0; n < 16; n++ {
+		sum += delta
+		b0 += ((b1 << 4) + a) ^ (b1 + sum) ^ ((b1 >> 5) + b)
+		b1 += ((b0 << 4) + c) ^ (b0 + sum) ^ ((b0 >> 5) + d)
+	}
+	buf[0] += b0
+	buf[1] += b1
+}
+
+// dirBuckets is the number of hash buckets at a directory level.
+func dirBuckets(level uint32, dirLevel byte) uint64 {
+	if level+uint32(dirLevel) < maxDirHashDepth/2 {
+		return 1 << (level + uint32(dirLevel))
+	}
+	return 1 << (maxDirHashDepth/2 - 1)
+}
+
+// bucketBlocks is the number of dentry blocks per bucket at a level.
+func bucketBlocks(level uint32) uint64 {
+	if level < maxDirHashDepth/2 {
+		return 2
+	}
+	return 4
+}
+
+// dirBlockIndex is the first directory block of bucket idx at level.
+func dirBlockIndex(level uint32, dirLevel byte, idx uint64) uint64 {
+	var bidx uint64
+	for i := uint32(0); i < level; i++ {
+		bidx += dirBuckets(i, dirLevel) * bucketBlocks(i)
+	}
+	return bidx + idx*bucketBlocks(level)
+}
+
+// dirBlocks is the number of blocks a directory's size covers.
+func (f *F2FS) dirBlocks(dir *inode) (uint64, error) {
+	n := (dir.size + f2fsBlockSize - 1) / f2fsBlockSize
+	if n > maxDirBlocks {
+		return 0, fmt.Errorf("F2FS: directory inode %d size %d is implausible", dir.ino, dir.size)
+	}
+	return n, nil
+}
+
+// dirBlock reads directory block idx; ok is false for a hole.
+func (f *F2FS) dirBlock(dir *inode, idx uint64) (b []byte, ok bool, err error) {
+	addr, err := f.blockAddr(dir, idx)
+	if err != nil || addr == f2fsNullAddr || addr == f2fsNewAddr {
+		return nil, false, err
+	}
+	b, err = f.readMainBlock(addr)
+	return b, err == nil, err
+}
+
+// readDir returns every entry of a directory except "." and "..".
+func (f *F2FS) readDir(dir *inode) ([]dentry, error) {
+	if !dir.isDir() {
+		return nil, fmt.Errorf("F2FS: inode %d is not a directory: %w", dir.ino, filesystem.ErrNotDirectory)
+	}
+	if dir.flags&flagEncrypted != 0 {
+		return nil, fmt.Errorf("F2FS: directory inode %d has encrypted names: %w", dir.ino, filesystem.ErrUnsupported)
+	}
+	var all []dentry
+	if dir.inline&inlineDentry != 0 {
+		all = parseInlineDentries(dir.inlineArea())
+	} else {
+		n, err := f.dirBlocks(dir)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			b, ok, err := f.dirBlock(dir, i)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				all = append(all, parseDentryBlock(b)...)
+			}
+		}
+	}
+	out := all[:0]
+	for _, d := range all {
+		if d.name != "." && d.name != ".." {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// lookup finds name in dir. Block directories are searched the way the
+// kernel does — one bucket per hash level — and then, if that misses, in
+// full, so an entry outside its bucket (casefolded names, whose hash this
+// reader does not reproduce, or a corrupt table) is still found.
+func (f *F2FS) lookup(dir *inode, name string) (dentry, error) {
+	if !dir.isDir() {
+		return dentry{}, fmt.Errorf("F2FS: inode %d is not a directory: %w", dir.ino, filesystem.ErrNotDirectory)
+	}
+	if dir.flags&flagEncrypted != 0 {
+		return dentry{}, fmt.Errorf("F2FS: directory inode %d has encrypted names: %w", dir.ino, filesystem.ErrUnsupported)
+	}
+	if dir.inline&inlineDentry == 0 && dir.flags&flagCasefold == 0 {
+		n, err := f.dirBlocks(dir)
+		if err != nil {
+			return dentry{}, err
+		}
+		h := dentryHash(name)
+		for level := uint32(0); level < dir.depth && level < maxDirHashDepth; level++ {
+			start := dirBlockIndex(level, dir.dirLevel, uint64(h)%dirBuckets(level, dir.dirLevel))
+			for i := start; i < start+bucketBlocks(level) && i < n; i++ {
+				b, ok, err := f.dirBlock(dir, i)
+				if err != nil {
+					return dentry{}, err
+				}
+				if !ok {
+					continue
+				}
+				for _, d := range parseDentryBlock(b) {
+					if d.hash == h && d.name == name {
+						return d, nil
+					}
+				}
+			}
+		}
+	}
+	entries, err := f.readDir(dir)
+	if err != nil {
+		return dentry{}, err
+	}
+	for _, d := range entries {
+		if d.name == name {
+			return d, nil
+		}
+	}
+	return dentry{}, fmt.Errorf("F2FS: %q: %w", name, filesystem.ErrNotFound)
+}
+
+// resolve walks path from the root directory. Names compare exactly.
+func (f *F2FS) resolve(path string) (*inode, error) {
+	cur, err := f.readInode(f.rootIno)
+	if err != nil {
+		return nil, err
+	}
+	for _, comp := range strings.Split(path, "/") {
+		if comp == "" || comp == "." {
+			continue
+		}
+		d, err := f.lookup(cur, comp)
+		if err != nil {
+			return nil, err
+		}
+		if cur, err = f.readInode(d.ino); err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+func cleanPath(path string) string {
+	if path == "" || path == "/" {
+		return ""
+	}
+	return "/" + strings.Trim(path, "/")
+}
+
+// ListDirectory lists a directory path. "" and "/" both denote the root.
+// DirectoryEntry.Inode is the entry's inode number.
+func (f *F2FS) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
+	if f.readFunc == nil || f.natJournal == nil {
+		return nil, fmt.Errorf("F2FS: directory parsing requires a reader")
+	}
+	dir, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := f.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := cleanPath(path)
+	out := make([]filesystem.DirectoryEntry, 0, len(entries))
+	for _, d := range entries {
+		e := filesystem.DirectoryEntry{
+			Name:  d.name,
+			Path:  filesystem.JoinPath(dirPath, d.name),
+			IsDir: d.ftype == fileTypeDir,
+			Inode: uint64(d.ino),
+		}
+		// The dentry carries no size or times; read them from the inode.
+		if in, err := f.readInode(d.ino); err == nil {
+			e.Size, e.IsDir = in.size, in.isDir()
+			e.ModTime, e.AccessTime, e.CreateTime = in.mtime, in.atime, in.crtime
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// GetFile reads a file's contents by path. A symlink's contents are its
+// target.
+func (f *F2FS) GetFile(path string) ([]byte, error) {
+	if f.readFunc == nil || f.natJournal == nil {
+		return nil, fmt.Errorf("F2FS: file reading requires a reader")
+	}
+	in, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if in.isDir() {
+		return nil, fmt.Errorf("F2FS: %q: %w", path, filesystem.ErrIsDirectory)
+	}
+	r, err := f.openData(in)
+	if err != nil {
+		return nil, err
+	}
+	// The whole file is read into memory: bound it, and outside inline data
+	// require the blocks it allocated to cover it. Sparse files stream
+	// through OpenFile.
+	switch {
+	case in.size > f2fsMaxFileBytes:
+		return nil, fmt.Errorf("F2FS: inode %d size %d exceeds the %d-byte read limit", in.ino, in.size, f2fsMaxFileBytes)
+	case r.inline == nil && (in.size+f2fsBlockSize-1)/f2fsBlockSize > in.blocks:
+		return nil, fmt.Errorf("F2FS: inode %d size %d exceeds its %d allocated blocks", in.ino, in.size, in.blocks)
+	}
+	buf := make([]byte, r.size)
+	if _, err := r.ReadAt(buf, 0); err != nil && r.size > 0 {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// GetFileByPath returns metadata for a path.
+func (f *F2FS) GetFileByPath(path string) (*filesystem.FileInfo, error) {
+	if f.readFunc == nil || f.natJournal == nil {
+		return nil, fmt.Errorf("F2FS: file lookup requires a reader")
+	}
+	in, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(in, cleanPath(path)), nil
+}
+
+func infoOf(in *inode, path string) *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:       path[strings.LastIndex(path, "/")+1:],
+		Path:       path,
+		Size:       in.size,
+		Mode:       filesystem.FileMode(in.mode & modeTypeMask),
+		IsDir:      in.isDir(),
+		ModTime:    in.mtime,
+		AccessTime: in.atime,
+		CreateTime: in.crtime,
+		IsReadOnly: in.mode&0o222 == 0,
+	}
+}
+
+// SearchFiles walks the directory tree under rootPath and returns every
+// FileInfo for which predicate returns true. Depth and result count are
+// bounded.
+func (f *F2FS) SearchFiles(rootPath string, predicate func(filesystem.FileInfo) bool) ([]filesystem.FileInfo, error) {
+	if f.readFunc == nil || f.natJournal == nil {
+		return nil, fmt.Errorf("F2FS: search requires a reader")
+	}
+	start, err := f.resolve(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !start.isDir() {
+		return nil, fmt.Errorf("F2FS: %q is not a directory: %w", rootPath, filesystem.ErrNotDirectory)
+	}
+	results := make([]filesystem.FileInfo, 0)
+	visited := make(map[uint32]bool)
+	var walk func(dir *inode, dirPath string, depth int) error
+	walk = func(dir *inode, dirPath string, depth int) error {
+		if depth > f2fsMaxSearchDepth || visited[dir.ino] {
+			return nil
+		}
+		visited[dir.ino] = true
+		entries, err := f.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, d := range entries {
+			if len(results) >= f2fsMaxSearchCount {
+				return fmt.Errorf("F2FS: search exceeded %d results", f2fsMaxSearchCount)
+			}
+			in, err := f.readInode(d.ino)
+			if err != nil {
+				return err
+			}
+			fi := infoOf(in, filesystem.JoinPath(dirPath, d.name))
+			if predicate(*fi) {
+				results = append(results, *fi)
+			}
+			if in.isDir() {
+				if err := walk(in, fi.Path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(start, cleanPath(rootPath), 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,628 @@
+package f2fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+func init() {
+	filesystem.RegisterFileSystem(filesystem.FS_F2FS, func() filesystem.FileSystem { return &F2FS{} })
+	filesystem.RegisterHandler(filesystem.FS_F2FS, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+		return NewF2FSHandler(r, startLBA)
+	})
+}
+
+// F2FS (Flash-Friendly File System) implementation.
+// Reference: include/linux/f2fs_fs.h and Documentation/filesystems/f2fs.rst.
+//
+// The volume is a sequence of 4 KiB blocks split into areas: superblock
+// (two copies, at byte 1024 of blocks 0 and 1), checkpoint (two packs one
+// segment apart), SIT, NAT, SSA and main. Files and directories are inodes
+// stored in node blocks, which are addressed by node id (nid) and located
+// through the NAT; node and data blocks live in the main area.
+//
+//   - The valid checkpoint pack with the higher checkpoint_ver is current. A
+//     pack is valid when its first and last blocks carry the same version
+//     and both CRCs match. The pack carries the NAT/SIT version bitmaps that
+//     pick which of the two copies of each NAT/SIT block is live, and the
+//     current-segment summaries whose journals hold NAT and SIT entries not
+//     yet written back to their areas.
+//   - nid -> NAT entry {version u8, ino u32, block address u32}; the NAT
+//     journal wins over the NAT area. A node block's SIT valid bit must be set
+//     and its footer must name the nid it was read for.
+//   - An inode maps its first data blocks directly (i_addr) and the rest via
+//     two direct, two indirect and one double-indirect node (i_nid). Small
+//     files and directories may be stored inline in the inode.
+//   - A directory is a multi-level hash table of dentry blocks; level n has
+//     2^(n+dir_level) buckets of two blocks each (four from level 31 on).
+//
+// Encrypted and compressed files are recognised and rejected with
+// ErrUnsupported: their blocks cannot be read as plain data without keys or
+// a cluster decompressor.
+
+const (
+	f2fsMagic          = 0xF2F52010
+	f2fsSuperOffset    = 1024
+	f2fsBlockSize      = 4096
+	f2fsLogBlocksSeg   = 9
+	f2fsBlocksPerSeg   = 1 << f2fsLogBlocksSeg
+	f2fsSectorsPerBlk  = f2fsBlockSize / 512
+	f2fsNullAddr       = 0x00000000
+	f2fsNewAddr        = 0xFFFFFFFF
+	f2fsCompressAddr   = 0xFFFFFFFE
+	f2fsNodeFooter     = f2fsBlockSize - 24
+	f2fsAddrsPerInode  = 923
+	f2fsAddrsPerBlock  = 1018
+	f2fsNidsPerBlock   = 1018
+	f2fsInodeAddrs     = 360 // offset of i_addr in the inode
+	f2fsInodeNids      = 4052
+	f2fsInlineXattrDef = 50 // DEFAULT_INLINE_XATTR_ADDRS
+	f2fsNATEntrySize   = 9
+	f2fsNATPerBlock    = f2fsBlockSize / f2fsNATEntrySize
+	f2fsSITEntrySize   = 74
+	f2fsSITPerBlock    = f2fsBlockSize / f2fsSITEntrySize
+	f2fsSumEntries     = 512 * 7 // summary entries before the journal
+	f2fsSumJournalSize = f2fsBlockSize - 5 - f2fsSumEntries
+
+	// Superblock features.
+	f2fsFeatureFlexibleXattr = 0x0040
+	f2fsFeatureInodeCrtime   = 0x0100
+	f2fsFeatureSBChecksum    = 0x0800
+
+	// Checkpoint flags.
+	cpCompactSum       = 0x00000004
+	cpLargeNATBitmap   = 0x00000400
+	cpMinChecksumOff   = 192 // offsetof(sit_nat_version_bitmap)
+	cpNATBitmapSizeOff = 160
+
+	// i_inline flags.
+	inlineXattr  = 0x01
+	inlineData   = 0x02
+	inlineDentry = 0x04
+	inlineExtra  = 0x20
+
+	// i_flags.
+	flagCompressed = 0x00000004
+	flagEncrypted  = 0x00000800
+	flagCasefold   = 0x40000000
+
+	modeTypeMask = 0xF000
+	modeDir      = 0x4000
+	modeRegular  = 0x8000
+	modeSymlink  = 0xA000
+
+	f2fsMaxSearchDepth = 64
+	f2fsMaxSearchCount = 100000
+	// f2fsMaxFileBytes bounds a whole-file read (GetFile); larger files
+	// stream through OpenFile.
+	f2fsMaxFileBytes = uint64(1) << 32
+)
+
+// crcTable is the CRC-32 (IEEE) table behind f2fs_crc32, which the kernel
+// seeds with F2FS_SUPER_MAGIC and does not invert.
+var crcTable = crc32.MakeTable(crc32.IEEE)
+
+func f2fsCRC(b []byte) uint32 {
+	return ^crc32.Update(^uint32(f2fsMagic), crcTable, b)
+}
+
+// natEntry is a NAT entry: the inode a node belongs to and its block.
+type natEntry struct {
+	ino  uint32
+	addr uint32
+}
+
+// inode is a parsed f2fs_inode.
+type inode struct {
+	ino      uint32
+	block    []byte // the node block holding the inode
+	mode     uint16
+	inline   byte
+	flags    uint32
+	size     uint64
+	blocks   uint64 // i_blocks: allocated blocks, the inode's own included
+	atime    int64
+	ctime    int64
+	mtime    int64
+	crtime   int64
+	depth    uint32 // i_current_depth: directory hash levels in use
+	dirLevel byte
+	extra    int // i_extra_isize in 4-byte address slots
+	xattr    int // inline xattr size in 4-byte address slots
+}
+
+func (in *inode) isDir() bool { return in.mode&modeTypeMask == modeDir }
+
+// addrs is the number of data block addresses the inode holds directly.
+func (in *inode) addrs() int { return f2fsAddrsPerInode - in.extra - in.xattr }
+
+// addr returns the i-th direct data block address.
+func (in *inode) addr(i int) uint32 {
+	return binary.LittleEndian.Uint32(in.block[f2fsInodeAddrs+4*(in.extra+i):])
+}
+
+// nid returns i_nid[i]: 0/1 direct, 2/3 indirect, 4 double indirect.
+func (in *inode) nid(i int) uint32 {
+	return binary.LittleEndian.Uint32(in.block[f2fsInodeNids+4*i:])
+}
+
+// inlineArea returns the inline data / dentry area: i_addr past the extra
+// attributes and one reserved slot, up to the inline xattrs.
+func (in *inode) inlineArea() []byte {
+	start := f2fsInodeAddrs + 4*(in.extra+1)
+	return in.block[start : start+4*(in.addrs()-1)]
+}
+
+// F2FS implements filesystem.FileSystem over an F2FS volume.
+type F2FS struct {
+	startLBA uint64
+	readFunc func(startLBA uint64, count uint64) ([]byte, error)
+
+	// Superblock.
+	major, minor uint16
+	blockCount   uint64
+	segCountSIT  uint32
+	cpAddr       uint32
+	sitAddr      uint32
+	natAddr      uint32
+	mainAddr     uint32
+	rootIno      uint32
+	features     uint32
+	cpPayload    uint32
+	volumeName   string
+
+	// Current checkpoint.
+	cpVersion  uint64
+	natBitmap  []byte
+	sitBitmap  []byte
+	natJournal map[uint32]natEntry
+	sitJournal map[uint32][]byte // segment -> valid map
+}
+
+// NewF2FSHandler opens the F2FS volume at startLBA: it validates a
+// superblock (falling back to the backup copy) and loads the current
+// checkpoint.
+func NewF2FSHandler(reader filesystem.Reader, startLBA uint64) (*F2FS, error) {
+	f := &F2FS{startLBA: startLBA, readFunc: reader.ReadSectors}
+	head, err := reader.ReadSectors(startLBA, 2*f2fsSectorsPerBlk)
+	if err != nil {
+		return nil, fmt.Errorf("F2FS: failed to read superblock: %w", err)
+	}
+	if err := f.Open(head); err != nil {
+		if len(head) < 2*f2fsBlockSize {
+			return nil, err
+		}
+		if errBackup := f.Open(head[f2fsBlockSize:]); errBackup != nil {
+			return nil, err
+		}
+	}
+	if err := f.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *F2FS) Type() filesystem.FileSystemType { return filesystem.FS_F2FS }
+
+// Open validates the superblock at byte 1024 of sectorData and caches the
+// layout.
+func (f *F2FS) Open(sectorData []byte) error {
+	if len(sectorData) < f2fsBlockSize {
+		return fmt.Errorf("F2FS: superblock data too small (%d bytes)", len(sectorData))
+	}
+	sb := sectorData[f2fsSuperOffset:f2fsBlockSize]
+	if binary.LittleEndian.Uint32(sb[0:]) != f2fsMagic {
+		return fmt.Errorf("F2FS: invalid superblock magic")
+	}
+	logSector := binary.LittleEndian.Uint32(sb[8:])
+	logSectorsPerBlock := binary.LittleEndian.Uint32(sb[12:])
+	if binary.LittleEndian.Uint32(sb[16:]) != 12 || logSector+logSectorsPerBlock != 12 ||
+		binary.LittleEndian.Uint32(sb[20:]) != f2fsLogBlocksSeg {
+		return fmt.Errorf("F2FS: unsupported geometry (only 4 KiB blocks and 512-block segments exist)")
+	}
+	features := binary.LittleEndian.Uint32(sb[2180:])
+	if features&f2fsFeatureSBChecksum != 0 {
+		off := binary.LittleEndian.Uint32(sb[32:])
+		if off < 4 || off > uint32(len(sb))-4 {
+			return fmt.Errorf("F2FS: superblock checksum offset %d out of range", off)
+		}
+		if got, want := f2fsCRC(sb[:off]), binary.LittleEndian.Uint32(sb[off:]); got != want {
+			return fmt.Errorf("F2FS: superblock checksum mismatch (0x%08X != 0x%08X)", got, want)
+		}
+	}
+	f.major = binary.LittleEndian.Uint16(sb[4:])
+	f.minor = binary.LittleEndian.Uint16(sb[6:])
+	f.blockCount = binary.LittleEndian.Uint64(sb[36:])
+	f.segCountSIT = binary.LittleEndian.Uint32(sb[56:])
+	f.cpAddr = binary.LittleEndian.Uint32(sb[76:])
+	f.sitAddr = binary.LittleEndian.Uint32(sb[80:])
+	f.natAddr = binary.LittleEndian.Uint32(sb[84:])
+	f.mainAddr = binary.LittleEndian.Uint32(sb[92:])
+	f.rootIno = binary.LittleEndian.Uint32(sb[96:])
+	f.cpPayload = binary.LittleEndian.Uint32(sb[1664:])
+	f.features = features
+	if !(uint64(f.cpAddr) < uint64(f.sitAddr) && f.sitAddr < f.natAddr && f.natAddr < f.mainAddr &&
+		uint64(f.mainAddr) < f.blockCount) {
+		return fmt.Errorf("F2FS: superblock area addresses out of order")
+	}
+	name := make([]uint16, 0, 512)
+	for i := 0; i < 512; i++ {
+		c := binary.LittleEndian.Uint16(sb[124+2*i:])
+		if c == 0 {
+			break
+		}
+		name = append(name, c)
+	}
+	f.volumeName = string(utf16.Decode(name))
+	return nil
+}
+
+func (f *F2FS) Close() error { return nil }
+
+// GetVolumeLabel returns the superblock volume name.
+func (f *F2FS) GetVolumeLabel() string { return f.volumeName }
+
+// GetVersion returns the on-disk format version as "major.minor".
+func (f *F2FS) GetVersion() string { return fmt.Sprintf("%d.%d", f.major, f.minor) }
+
+// CheckpointVersion returns the version of the checkpoint pack in use.
+func (f *F2FS) CheckpointVersion() uint64 { return f.cpVersion }
+
+// readBlock reads one 4 KiB block by volume block address.
+func (f *F2FS) readBlock(addr uint32) ([]byte, error) {
+	if f.readFunc == nil {
+		return nil, fmt.Errorf("F2FS: handler has no reader")
+	}
+	if uint64(addr) >= f.blockCount {
+		return nil, fmt.Errorf("F2FS: block %d beyond the volume (%d blocks)", addr, f.blockCount)
+	}
+	b, err := f.readFunc(f.startLBA+uint64(addr)*f2fsSectorsPerBlk, f2fsSectorsPerBlk)
+	if err != nil {
+		return nil, fmt.Errorf("F2FS: block %d: %w", addr, err)
+	}
+	if len(b) < f2fsBlockSize {
+		return nil, fmt.Errorf("F2FS: short read of block %d", addr)
+	}
+	return b[:f2fsBlockSize], nil
+}
+
+// readMainBlock reads a node or data block, which must lie in the main area.
+func (f *F2FS) readMainBlock(addr uint32) ([]byte, error) {
+	if addr < f.mainAddr {
+		return nil, fmt.Errorf("F2FS: block %d is outside the main area", addr)
+	}
+	return f.readBlock(addr)
+}
+
+// checkpointBlock reads a checkpoint block and verifies its CRC, returning
+// the block and its checkpoint_ver.
+func (f *F2FS) checkpointBlock(addr uint32) ([]byte, uint64, error) {
+	b, err := f.readBlock(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	off := binary.LittleEndian.Uint32(b[164:])
+	if off < cpMinChecksumOff || off > f2fsBlockSize-4 {
+		return nil, 0, fmt.Errorf("F2FS: checkpoint block %d checksum offset %d out of range", addr, off)
+	}
+	if got, want := f2fsCRC(b[:off]), binary.LittleEndian.Uint32(b[off:]); got != want {
+		return nil, 0, fmt.Errorf("F2FS: checkpoint block %d checksum mismatch", addr)
+	}
+	return b, binary.LittleEndian.Uint64(b[0:]), nil
+}
+
+// checkpointPack validates the pack starting at start: the first and last
+// blocks must both check out and agree on the version.
+func (f *F2FS) checkpointPack(start uint32) ([]byte, uint64, error) {
+	cp, ver, err := f.checkpointBlock(start)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := binary.LittleEndian.Uint32(cp[136:])
+	if total < 2 || total > f2fsBlocksPerSeg {
+		return nil, 0, fmt.Errorf("F2FS: checkpoint pack at %d has %d blocks", start, total)
+	}
+	_, tailVer, err := f.checkpointBlock(start + total - 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tailVer != ver {
+		return nil, 0, fmt.Errorf("F2FS: checkpoint pack at %d is torn (version %d / %d)", start, ver, tailVer)
+	}
+	return cp, ver, nil
+}
+
+// loadCheckpoint selects the current checkpoint pack and loads its NAT/SIT
+// version bitmaps and the NAT/SIT journals of its summary blocks.
+func (f *F2FS) loadCheckpoint() error {
+	var cp []byte
+	var start uint32
+	var errs []error
+	for pack := uint32(0); pack < 2; pack++ {
+		s := f.cpAddr + pack*f2fsBlocksPerSeg
+		b, ver, err := f.checkpointPack(s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if cp == nil || ver > f.cpVersion {
+			cp, start, f.cpVersion = b, s, ver
+		}
+	}
+	if cp == nil {
+		return fmt.Errorf("F2FS: no valid checkpoint pack: %v", errs)
+	}
+	flags := binary.LittleEndian.Uint32(cp[132:])
+	sitSize := binary.LittleEndian.Uint32(cp[156:])
+	natSize := binary.LittleEndian.Uint32(cp[cpNATBitmapSizeOff:])
+
+	// The bitmaps' placement follows __bitmap_ptr in fs/f2fs/f2fs.h.
+	switch {
+	case flags&cpLargeNATBitmap != 0:
+		base := uint32(cpMinChecksumOff + 4)
+		if uint64(base)+uint64(natSize)+uint64(sitSize) > f2fsBlockSize {
+			return fmt.Errorf("F2FS: checkpoint bitmaps overrun the block")
+		}
+		f.natBitmap = cp[base : base+natSize]
+		f.sitBitmap = cp[base+natSize : base+natSize+sitSize]
+	case f.cpPayload > 0:
+		if uint64(cpMinChecksumOff)+uint64(natSize) > f2fsBlockSize ||
+			uint64(sitSize) > uint64(f.cpPayload)*f2fsBlockSize {
+			return fmt.Errorf("F2FS: checkpoint bitmaps overrun the pack")
+		}
+		f.natBitmap = cp[cpMinChecksumOff : cpMinChecksumOff+natSize]
+		for i := uint32(0); i < f.cpPayload; i++ {
+			b, err := f.readBlock(start + 1 + i)
+			if err != nil {
+				return err
+			}
+			f.sitBitmap = append(f.sitBitmap, b...)
+		}
+		f.sitBitmap = f.sitBitmap[:sitSize]
+	default:
+		if uint64(cpMinChecksumOff)+uint64(sitSize)+uint64(natSize) > f2fsBlockSize {
+			return fmt.Errorf("F2FS: checkpoint bitmaps overrun the block")
+		}
+		f.sitBitmap = cp[cpMinChecksumOff : cpMinChecksumOff+sitSize]
+		f.natBitmap = cp[cpMinChecksumOff+sitSize : cpMinChecksumOff+sitSize+natSize]
+	}
+
+	// The hot-data summary journals NAT entries and the cold-data summary SIT
+	// entries. Compacted summaries pack both journals at the head of one block.
+	sum := start + binary.LittleEndian.Uint32(cp[140:])
+	var natJ, sitJ []byte
+	if flags&cpCompactSum != 0 {
+		b, err := f.readBlock(sum)
+		if err != nil {
+			return err
+		}
+		natJ, sitJ = b[:f2fsSumJournalSize], b[f2fsSumJournalSize:2*f2fsSumJournalSize]
+	} else {
+		hot, err := f.readBlock(sum)
+		if err != nil {
+			return err
+		}
+		cold, err := f.readBlock(sum + 2)
+		if err != nil {
+			return err
+		}
+		natJ = hot[f2fsSumEntries : f2fsSumEntries+f2fsSumJournalSize]
+		sitJ = cold[f2fsSumEntries : f2fsSumEntries+f2fsSumJournalSize]
+	}
+	f.natJournal = make(map[uint32]natEntry)
+	n := int(binary.LittleEndian.Uint16(natJ[0:]))
+	if 2+n*13 > len(natJ) {
+		return fmt.Errorf("F2FS: NAT journal claims %d entries", n)
+	}
+	for i := 0; i < n; i++ {
+		e := natJ[2+13*i:]
+		f.natJournal[binary.LittleEndian.Uint32(e[0:])] = natEntry{
+			ino:  binary.LittleEndian.Uint32(e[5:]),
+			addr: binary.LittleEndian.Uint32(e[9:]),
+		}
+	}
+	f.sitJournal = make(map[uint32][]byte)
+	n = int(binary.LittleEndian.Uint16(sitJ[0:]))
+	if 2+n*(4+f2fsSITEntrySize) > len(sitJ) {
+		return fmt.Errorf("F2FS: SIT journal claims %d entries", n)
+	}
+	for i := 0; i < n; i++ {
+		e := sitJ[2+(4+f2fsSITEntrySize)*i:]
+		f.sitJournal[binary.LittleEndian.Uint32(e[0:])] = e[4+2 : 4+2+64]
+	}
+	return nil
+}
+
+// testBit is f2fs_test_bit: bit nr of a bitmap numbered MSB first.
+func testBit(bitmap []byte, nr uint32) bool {
+	if int(nr>>3) >= len(bitmap) {
+		return false
+	}
+	return bitmap[nr>>3]&(0x80>>(nr&7)) != 0
+}
+
+// nat looks up a node id, preferring the checkpoint's NAT journal.
+func (f *F2FS) nat(nid uint32) (natEntry, error) {
+	if e, ok := f.natJournal[nid]; ok {
+		return e, nil
+	}
+	blockOff := nid / f2fsNATPerBlock
+	if blockOff>>3 >= uint32(len(f.natBitmap)) {
+		return natEntry{}, fmt.Errorf("F2FS: nid %d beyond the NAT", nid)
+	}
+	seg := blockOff >> f2fsLogBlocksSeg
+	addr := f.natAddr + seg<<f2fsLogBlocksSeg<<1 + blockOff&(f2fsBlocksPerSeg-1)
+	if testBit(f.natBitmap, blockOff) {
+		addr += f2fsBlocksPerSeg
+	}
+	b, err := f.readBlock(addr)
+	if err != nil {
+		return natEntry{}, err
+	}
+	e := b[(nid%f2fsNATPerBlock)*f2fsNATEntrySize:]
+	return natEntry{ino: binary.LittleEndian.Uint32(e[1:]), addr: binary.LittleEndian.Uint32(e[5:])}, nil
+}
+
+// blockValid reports whether the SIT marks a main-area block as valid,
+// preferring the checkpoint's SIT journal.
+func (f *F2FS) blockValid(addr uint32) (bool, error) {
+	if addr < f.mainAddr {
+		return false, nil
+	}
+	segno := (addr - f.mainAddr) >> f2fsLogBlocksSeg
+	off := (addr - f.mainAddr) & (f2fsBlocksPerSeg - 1)
+	if m, ok := f.sitJournal[segno]; ok {
+		return testBit(m, off), nil
+	}
+	blockOff := segno / f2fsSITPerBlock
+	sitBlock := f.sitAddr + blockOff
+	if testBit(f.sitBitmap, blockOff) {
+		sitBlock += (f.segCountSIT / 2) << f2fsLogBlocksSeg
+	}
+	b, err := f.readBlock(sitBlock)
+	if err != nil {
+		return false, err
+	}
+	e := b[(segno%f2fsSITPerBlock)*f2fsSITEntrySize:]
+	return testBit(e[2:2+64], off), nil
+}
+
+// readNode resolves nid through the NAT, checks the block against the SIT
+// and its footer, and returns the node block.
+func (f *F2FS) readNode(nid uint32) ([]byte, error) {
+	e, err := f.nat(nid)
+	if err != nil {
+		return nil, err
+	}
+	if e.addr == f2fsNullAddr || e.addr == f2fsNewAddr {
+		return nil, fmt.Errorf("F2FS: nid %d has no node block", nid)
+	}
+	valid, err := f.blockValid(e.addr)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("F2FS: nid %d block %d is not valid in the SIT", nid, e.addr)
+	}
+	b, err := f.readMainBlock(e.addr)
+	if err != nil {
+		return nil, err
+	}
+	if got := binary.LittleEndian.Uint32(b[f2fsNodeFooter:]); got != nid {
+		return nil, fmt.Errorf("F2FS: node block %d footer names nid %d, want %d", e.addr, got, nid)
+	}
+	return b, nil
+}
+
+// readInode reads and parses the inode ino.
+func (f *F2FS) readInode(ino uint32) (*inode, error) {
+	b, err := f.readNode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(b[f2fsNodeFooter+4:]) != ino {
+		return nil, fmt.Errorf("F2FS: node %d is not an inode", ino)
+	}
+	in := &inode{
+		ino:      ino,
+		block:    b,
+		mode:     binary.LittleEndian.Uint16(b[0:]),
+		inline:   b[3],
+		size:     binary.LittleEndian.Uint64(b[16:]),
+		blocks:   binary.LittleEndian.Uint64(b[24:]),
+		atime:    int64(binary.LittleEndian.Uint64(b[32:])),
+		ctime:    int64(binary.LittleEndian.Uint64(b[40:])),
+		mtime:    int64(binary.LittleEndian.Uint64(b[48:])),
+		depth:    binary.LittleEndian.Uint32(b[72:]),
+		flags:    binary.LittleEndian.Uint32(b[80:]),
+		dirLevel: b[347],
+	}
+	if in.inline&inlineExtra != 0 {
+		isize := int(binary.LittleEndian.Uint16(b[f2fsInodeAddrs:]))
+		if isize%4 != 0 || isize > 4*(f2fsAddrsPerInode/2) {
+			return nil, fmt.Errorf("F2FS: inode %d extra size %d invalid", ino, isize)
+		}
+		in.extra = isize / 4
+		if f.features&f2fsFeatureInodeCrtime != 0 && isize >= 20 { // i_crtime follows the projid and inode checksum
+			in.crtime = int64(binary.LittleEndian.Uint64(b[f2fsInodeAddrs+12:]))
+		}
+	}
+	switch {
+	case f.features&f2fsFeatureFlexibleXattr != 0:
+		if in.extra > 0 {
+			in.xattr = int(binary.LittleEndian.Uint16(b[f2fsInodeAddrs+2:]))
+		}
+	case in.inline&(inlineXattr|inlineDentry) != 0:
+		in.xattr = f2fsInlineXattrDef
+	}
+	if in.addrs() < 2 {
+		return nil, fmt.Errorf("F2FS: inode %d extra/xattr sizes leave no address slots", ino)
+	}
+	return in, nil
+}
+
+// blockAddr maps file block idx of in to its volume block address; 0 is a
+// hole (or a block reserved but never written).
+func (f *F2FS) blockAddr(in *inode, idx uint64) (uint32, error) {
+	n := uint64(in.addrs())
+	if idx < n {
+		return in.addr(int(idx)), nil
+	}
+	idx -= n
+	span := uint64(f2fsAddrsPerBlock)
+	for i, level := 0, 0; i < 5; i++ {
+		switch i {
+		case 2:
+			level, span = 1, f2fsNidsPerBlock*f2fsAddrsPerBlock
+		case 4:
+			level, span = 2, f2fsNidsPerBlock*f2fsNidsPerBlock*f2fsAddrsPerBlock
+		}
+		if idx < span {
+			return f.nodeAddr(in.nid(i), level, idx)
+		}
+		idx -= span
+	}
+	return 0, fmt.Errorf("F2FS: inode %d block index beyond the maximum file size", in.ino)
+}
+
+// nodeAddr descends level indirect nodes from nid to the data address of
+// block idx. A zero nid anywhere on the path is a hole.
+func (f *F2FS) nodeAddr(nid uint32, level int, idx uint64) (uint32, error) {
+	for ; ; level-- {
+		if nid == 0 {
+			return 0, nil
+		}
+		b, err := f.readNode(nid)
+		if err != nil {
+			return 0, err
+		}
+		if level == 0 {
+			return binary.LittleEndian.Uint32(b[4*idx:]), nil
+		}
+		span := uint64(f2fsAddrsPerBlock)
+		if level == 2 {
+			span *= f2fsNidsPerBlock
+		}
+		nid = binary.LittleEndian.Uint32(b[4*(idx/span):])
+		idx %= span
+	}
+}
+
+// readFileBlock reads file block idx of in; holes read as zeros.
+func (f *F2FS) readFileBlock(in *inode, idx uint64) ([]byte, error) {
+	addr, err := f.blockAddr(in, idx)
+	if err != nil {
+		return nil, err
+	}
+	switch addr {
+	case f2fsNullAddr, f2fsNewAddr:
+		return make([]byte, f2fsBlockSize), nil
+	case f2fsCompressAddr:
+		return nil, fmt.Errorf("F2FS: inode %d block %d is compressed: %w", in.ino, idx, filesystem.ErrUnsupported)
+	}
+	return f.readMainBlock(addr)
+}
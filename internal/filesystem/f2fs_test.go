@@ -0,0 +1,411 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// memF2FSReader is a fake Reader over an in-memory F2FS volume.
+type memF2FSReader struct {
+	data []byte
+}
+
+func (r *memF2FSReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start := lba * 512
+	end := start + count*512
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("f2fs: read past end of image")
+	}
+	return r.data[start:end], nil
+}
+
+// Synthetic F2FS layout: checkpoint packs at blocks 512 and 1024, SIT at
+// 1536, NAT at 2560, SSA at 3584 and the main area from 4096. Main segment 0
+// holds most nodes, segment 1 the remaining nodes and the data blocks.
+const (
+	f2fsTestCP     = 512
+	f2fsTestSIT    = 1536
+	f2fsTestNAT    = 2560
+	f2fsTestMain   = 4096
+	f2fsTestBlocks = 4672
+	f2fsTestMagic  = 0xF2F52010
+)
+
+type fakeF2FS struct {
+	img  []byte
+	nat  map[uint32]uint32 // nid -> block, written to the live NAT copy
+	sit  map[uint32]bool   // valid main blocks
+	next [2]uint32         // next free block in main segment 0 / 1
+}
+
+func (f *fakeF2FS) block(addr uint32) []byte {
+	return f.img[int(addr)*4096 : int(addr+1)*4096]
+}
+
+func (f *fakeF2FS) alloc(seg int) uint32 {
+	a := f2fsTestMain + uint32(seg)*512 + f.next[seg]
+	f.next[seg]++
+	return a
+}
+
+func f2fsTestCRC(b []byte) uint32 {
+	return ^crc32.Update(^uint32(f2fsTestMagic), crc32.IEEETable, b)
+}
+
+// node writes a node block for nid (owned by ino) and records it in the NAT
+// and the SIT.
+func (f *fakeF2FS) node(seg int, nid, ino uint32, fill func(b []byte)) {
+	addr := f.alloc(seg)
+	b := f.block(addr)
+	fill(b)
+	binary.LittleEndian.PutUint32(b[4072:], nid)
+	binary.LittleEndian.PutUint32(b[4076:], ino)
+	f.nat[nid] = addr
+	f.sit[addr] = true
+}
+
+type f2fsTestInode struct {
+	mode          uint16
+	inline        byte
+	flags         uint32
+	size          uint64
+	blocks        uint64
+	mtime         int64
+	depth         uint32
+	extra, xattr  int
+	addrs         map[int]uint32
+	nids          [5]uint32
+	inlineContent []byte
+}
+
+func (f *fakeF2FS) inode(seg int, ino uint32, in f2fsTestInode) {
+	f.node(seg, ino, ino, func(b []byte) {
+		binary.LittleEndian.PutUint16(b[0:], in.mode)
+		b[3] = in.inline
+		binary.LittleEndian.PutUint64(b[16:], in.size)
+		binary.LittleEndian.PutUint64(b[24:], in.blocks)
+		binary.LittleEndian.PutUint64(b[48:], uint64(in.mtime))
+		binary.LittleEndian.PutUint32(b[72:], in.depth)
+		binary.LittleEndian.PutUint32(b[80:], in.flags)
+		if in.extra > 0 {
+			binary.LittleEndian.PutUint16(b[360:], uint16(4*in.extra))
+			binary.LittleEndian.PutUint16(b[362:], uint16(in.xattr))
+			binary.LittleEndian.PutUint64(b[372:], 1234) // i_crtime
+		}
+		for i, a := range in.addrs {
+			binary.LittleEndian.PutUint32(b[360+4*(in.extra+i):], a)
+		}
+		for i, n := range in.nids {
+			binary.LittleEndian.PutUint32(b[4052+4*i:], n)
+		}
+		copy(b[360+4*(in.extra+1):], in.inlineContent)
+	})
+}
+
+// dentries fills a dentry array of n slots.
+func f2fsDentries(bitmap, dentries, names []byte, ents []f2fsTestDentry) {
+	slot := 0
+	for _, e := range ents {
+		bitmap[slot/8] |= 1 << (slot % 8)
+		d := dentries[slot*11:]
+		binary.LittleEndian.PutUint32(d[4:], e.ino)
+		binary.LittleEndian.PutUint16(d[8:], uint16(len(e.name)))
+		d[10] = e.ftype
+		copy(names[slot*8:], e.name)
+		slot += (len(e.name) + 7) / 8
+	}
+}
+
+type f2fsTestDentry struct {
+	name  string
+	ino   uint32
+	ftype byte
+}
+
+func f2fsDentryBlock(b []byte, ents ...f2fsTestDentry) {
+	f2fsDentries(b[:27], b[30:30+214*11], b[30+214*11:], ents)
+}
+
+func f2fsInlineDentries(area []byte, ents ...f2fsTestDentry) {
+	n := len(area) * 8 / (19*8 + 1)
+	bm := (n + 7) / 8
+	dentries := bm + len(area) - (19*n + bm)
+	f2fsDentries(area[:bm], area[dentries:dentries+11*n], area[dentries+11*n:], ents)
+}
+
+// buildFakeF2FS builds a volume whose root holds:
+//
+//	small.txt   inline data, extra attributes and flexible inline xattrs
+//	big.bin     sparse, with blocks mapped via i_addr, a direct node, an
+//	            indirect node and the double-indirect node
+//	Dir/        inline dentries -> note.txt (NAT entry only in the journal)
+//	link        symlink to small.txt
+//	a-rather-long-file-name.txt   multi-slot name in a level-1 dentry block
+//	secret      encrypted file
+//	ghost       dentry whose node block the SIT marks free
+//
+// Checkpoint pack 2 (version 7) is current; the stale pack 1 selects a NAT
+// copy that maps the root inode outside the main area.
+func buildFakeF2FS() (*memF2FSReader, map[uint64][]byte) {
+	f := &fakeF2FS{img: make([]byte, f2fsTestBlocks*4096), nat: map[uint32]uint32{}, sit: map[uint32]bool{}}
+
+	sb := f.img[1024:4096]
+	binary.LittleEndian.PutUint32(sb[0:], f2fsTestMagic)
+	binary.LittleEndian.PutUint16(sb[4:], 1)
+	binary.LittleEndian.PutUint16(sb[6:], 16)
+	binary.LittleEndian.PutUint32(sb[8:], 9)
+	binary.LittleEndian.PutUint32(sb[12:], 3)
+	binary.LittleEndian.PutUint32(sb[16:], 12)
+	binary.LittleEndian.PutUint32(sb[20:], 9)
+	binary.LittleEndian.PutUint64(sb[36:], f2fsTestBlocks)
+	binary.LittleEndian.PutUint32(sb[56:], 2) // SIT segments
+	binary.LittleEndian.PutUint32(sb[60:], 2) // NAT segments
+	binary.LittleEndian.PutUint32(sb[76:], f2fsTestCP)
+	binary.LittleEndian.PutUint32(sb[80:], f2fsTestSIT)
+	binary.LittleEndian.PutUint32(sb[84:], f2fsTestNAT)
+	binary.LittleEndian.PutUint32(sb[88:], 3584)
+	binary.LittleEndian.PutUint32(sb[92:], f2fsTestMain)
+	binary.LittleEndian.PutUint32(sb[96:], 3)
+	for i, c := range "android" {
+		binary.LittleEndian.PutUint16(sb[124+2*i:], uint16(c))
+	}
+	binary.LittleEndian.PutUint32(sb[2180:], 0x0040|0x0100|0x0800) // flexible xattr, crtime, sb checksum
+	binary.LittleEndian.PutUint32(sb[32:], 3068)
+	binary.LittleEndian.PutUint32(sb[3068:], f2fsTestCRC(sb[:3068]))
+	copy(f.img[4096+1024:8192], sb) // backup superblock
+
+	want := map[uint64][]byte{}
+	data := func(content []byte) uint32 {
+		a := f.alloc(1)
+		copy(f.block(a), content)
+		return a
+	}
+
+	// Regular files.
+	small := []byte("inline hello\n")
+	f.inode(0, 6, f2fsTestInode{mode: 0x81A4, inline: 0x02 | 0x20, size: uint64(len(small)),
+		mtime: 1700000000, extra: 6, xattr: 50, inlineContent: small})
+	note := []byte("note in a block\n")
+	noteData := data(note)
+	f.inode(1, 5, f2fsTestInode{mode: 0x81A4, size: uint64(len(note)), blocks: 2, addrs: map[int]uint32{0: noteData}})
+	long := bytes.Repeat([]byte("L"), 5000)
+	longA, longB := data(long[:4096]), data(long[4096:])
+	f.inode(0, 9, f2fsTestInode{mode: 0x81A4, size: uint64(len(long)), blocks: 3, addrs: map[int]uint32{0: longA, 1: longB}})
+	f.inode(0, 10, f2fsTestInode{mode: 0x81A4, flags: 0x800, size: 10, addrs: map[int]uint32{0: longA}})
+	f.inode(0, 8, f2fsTestInode{mode: 0xA1FF, inline: 0x02, size: 9, inlineContent: []byte("small.txt")})
+
+	// big.bin: file blocks at i_addr[0], direct node 1 slot 5, indirect node
+	// 1 -> direct 1 slot 3, and double indirect -> indirect 1 -> direct 2 slot 7.
+	const direct1 = 923
+	const indirect1 = direct1 + 2*1018
+	const dindirect = indirect1 + 2*1018*1018
+	mapped := map[uint64]uint32{}
+	for _, blk := range []uint64{0, direct1 + 5, indirect1 + 1018 + 3, dindirect + 1018*1018 + 2*1018 + 7} {
+		content := bytes.Repeat([]byte{byte(blk%251 + 1)}, 4096)
+		mapped[blk] = data(content)
+		want[blk] = content
+	}
+	f.node(1, 20, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*5:], mapped[direct1+5]) })
+	f.node(1, 22, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*3:], mapped[indirect1+1018+3]) })
+	f.node(1, 21, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*1:], 22) })
+	f.node(1, 25, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*7:], mapped[dindirect+1018*1018+2*1018+7]) })
+	f.node(1, 24, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*2:], 25) })
+	f.node(1, 23, 7, func(b []byte) { binary.LittleEndian.PutUint32(b[4*1:], 24) })
+	bigBlocks := uint64(dindirect + 1018*1018 + 2*1018 + 8)
+	f.inode(0, 7, f2fsTestInode{mode: 0x81A4, size: bigBlocks*4096 + 100, addrs: map[int]uint32{0: mapped[0]},
+		nids: [5]uint32{20, 0, 21, 0, 23}})
+
+	// Ghost: a valid-looking inode block the SIT does not mark valid.
+	f.inode(1, 99, f2fsTestInode{mode: 0x81A4, size: 1})
+	delete(f.sit, f.nat[99])
+
+	// Dir/ with inline dentries.
+	f.inode(0, 4, f2fsTestInode{mode: 0x41ED, inline: 0x04, depth: 1})
+	dirBlk := f.block(f.nat[4])
+	f2fsInlineDentries(dirBlk[360+4:360+4*923], // no extra attrs: no inline xattrs
+		f2fsTestDentry{".", 4, 2}, f2fsTestDentry{"..", 3, 2}, f2fsTestDentry{"note.txt", 5, 1})
+
+	// Root: two hash levels (blocks 0-1 and 2-5); block 1 is a hole.
+	rootBlocks := map[int]uint32{}
+	for i, ents := range [][]f2fsTestDentry{
+		{{".", 3, 2}, {"..", 3, 2}, {"small.txt", 6, 1}, {"Dir", 4, 2}},
+		nil,
+		{{"big.bin", 7, 1}},
+		{{"a-rather-long-file-name.txt", 9, 1}, {"link", 8, 7}},
+		{{"secret", 10, 1}},
+		{{"ghost", 99, 1}},
+	} {
+		if ents == nil {
+			continue
+		}
+		a := f.alloc(1)
+		f2fsDentryBlock(f.block(a), ents...)
+		rootBlocks[i] = a
+	}
+	f.inode(0, 3, f2fsTestInode{mode: 0x41ED, size: 6 * 4096, depth: 2, addrs: rootBlocks})
+
+	// NAT: the live copy (second) for the current pack, a bogus root in the
+	// stale copy, and nid 5 only in the journal (its NAT slot is stale).
+	live, stale := f.block(f2fsTestNAT+512), f.block(f2fsTestNAT)
+	for nid, addr := range f.nat {
+		if nid == 5 {
+			addr = f.nat[99]
+		}
+		binary.LittleEndian.PutUint32(live[int(nid)*9+1:], nid)
+		binary.LittleEndian.PutUint32(live[int(nid)*9+5:], addr)
+	}
+	binary.LittleEndian.PutUint32(stale[3*9+5:], 100) // outside the main area
+
+	// SIT: segment 0 in the SIT area, segment 1 only in the journal.
+	sitMap := func(seg int) []byte {
+		m := make([]byte, 64)
+		for a := range f.sit {
+			if off := int(a) - f2fsTestMain - seg*512; off >= 0 && off < 512 {
+				m[off/8] |= 0x80 >> (off % 8)
+			}
+		}
+		return m
+	}
+	copy(f.block(f2fsTestSIT)[2:], sitMap(0))
+
+	// Checkpoint packs: {cp, compact summary, cp}.
+	pack := func(start uint32, ver uint64, natBit bool) {
+		cp := make([]byte, 4096)
+		binary.LittleEndian.PutUint64(cp[0:], ver)
+		binary.LittleEndian.PutUint32(cp[132:], 0x4|0x1) // compact summary, umount
+		binary.LittleEndian.PutUint32(cp[136:], 3)
+		binary.LittleEndian.PutUint32(cp[140:], 1)
+		binary.LittleEndian.PutUint32(cp[156:], 64)
+		binary.LittleEndian.PutUint32(cp[160:], 64)
+		binary.LittleEndian.PutUint32(cp[164:], 4092)
+		if natBit {
+			cp[192+64] = 0x80
+		}
+		binary.LittleEndian.PutUint32(cp[4092:], f2fsTestCRC(cp[:4092]))
+		copy(f.block(start), cp)
+		copy(f.block(start+2), cp)
+		sum := f.block(start + 1)
+		binary.LittleEndian.PutUint16(sum[0:], 1)
+		binary.LittleEndian.PutUint32(sum[2:], 5)
+		binary.LittleEndian.PutUint32(sum[7:], 5)
+		binary.LittleEndian.PutUint32(sum[11:], f.nat[5])
+		binary.LittleEndian.PutUint16(sum[507:], 1)
+		binary.LittleEndian.PutUint32(sum[509:], 1)
+		copy(sum[509+4+2:], sitMap(1))
+	}
+	pack(f2fsTestCP, 5, false)
+	pack(f2fsTestCP+512, 7, true)
+	return &memF2FSReader{data: f.img}, want
+}
+
+func TestF2FSReader(t *testing.T) {
+	r, bigBlocks := buildFakeF2FS()
+	if got := filesystem.DetectFileSystem(r.data[:0x10048]); got != filesystem.FS_F2FS {
+		t.Fatalf("DetectFileSystem = %s, want F2FS", got)
+	}
+	fs, err := filesystem.NewHandler(filesystem.FS_F2FS, r, 0, uint64(len(r.data)))
+	if err != nil {
+		t.Fatalf("NewHandler(F2FS): %v", err)
+	}
+	if fs.GetVolumeLabel() != "android" {
+		t.Errorf("label = %q, want android", fs.GetVolumeLabel())
+	}
+
+	entries, err := fs.ListDirectory("/")
+	if err != nil {
+		t.Fatalf("ListDirectory(/): %v", err)
+	}
+	names := map[string]filesystem.DirectoryEntry{}
+	for _, e := range entries {
+		names[e.Name] = e
+	}
+	if len(entries) != 7 || !names["Dir"].IsDir || names["small.txt"].Size != 13 ||
+		names["small.txt"].ModTime != 1700000000 || names["small.txt"].CreateTime != 1234 {
+		t.Fatalf("root listing = %+v", entries)
+	}
+
+	for path, want := range map[string][]byte{
+		"/small.txt":                   []byte("inline hello\n"),
+		"/Dir/note.txt":                []byte("note in a block\n"),
+		"/a-rather-long-file-name.txt": bytes.Repeat([]byte("L"), 5000),
+		"/link":                        []byte("small.txt"),
+	} {
+		if got, err := fs.GetFile(path); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("GetFile(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+
+	opener := fs.(filesystem.FileOpener)
+	f, err := opener.OpenFile("/big.bin")
+	if err != nil {
+		t.Fatalf("OpenFile(/big.bin): %v", err)
+	}
+	ra := f.(io.ReaderAt)
+	buf := make([]byte, 4096)
+	for blk, want := range bigBlocks {
+		if _, err := ra.ReadAt(buf, int64(blk)*4096); err != nil || !bytes.Equal(buf, want) {
+			t.Errorf("big.bin block %d: err %v, data mismatch %v", blk, err, !bytes.Equal(buf, want))
+		}
+	}
+	if _, err := ra.ReadAt(buf, 4096); err != nil || !bytes.Equal(buf, make([]byte, 4096)) {
+		t.Errorf("big.bin hole block 1: err %v or non-zero data", err)
+	}
+
+	if _, err := fs.(filesystem.InodeOpener).OpenInode(names["small.txt"].Inode, 0); err != nil {
+		t.Errorf("OpenInode(small.txt): %v", err)
+	}
+	for path, want := range map[string]error{
+		"/Dir":     filesystem.ErrIsDirectory,
+		"/link":    filesystem.ErrUnsupported,
+		"/secret":  filesystem.ErrUnsupported,
+		"/missing": filesystem.ErrNotFound,
+	} {
+		if _, err := opener.OpenFile(path); !errors.Is(err, want) {
+			t.Errorf("OpenFile(%q) = %v, want %v", path, err, want)
+		}
+	}
+	if _, err := fs.GetFile("/ghost"); err == nil {
+		t.Error("GetFile(/ghost) read a node block the SIT marks free")
+	}
+	if _, err := fs.GetFile("/big.bin"); err == nil {
+		t.Error("GetFile(/big.bin) read a file beyond the whole-file limit")
+	}
+
+	found, err := fs.SearchFiles("/Dir", func(fi filesystem.FileInfo) bool { return !fi.IsDir })
+	if err != nil || len(found) != 1 || found[0].Path != "/Dir/note.txt" {
+		t.Errorf("SearchFiles(/Dir) = %+v, %v", found, err)
+	}
+}
+
+// TestF2FSGetFileSizeBounds pins that GetFile refuses, before allocating, an
+// inode whose size its allocated blocks cannot cover.
+func TestF2FSGetFileSizeBounds(t *testing.T) {
+	r, _ := buildFakeF2FS()
+	var in []byte
+	for off := 0; off < len(r.data); off += 4096 {
+		b := r.data[off : off+4096]
+		if binary.LittleEndian.Uint32(b[4072:]) == 9 && binary.LittleEndian.Uint32(b[4076:]) == 9 {
+			in = b
+		}
+	}
+	if in == nil {
+		t.Fatal("inode 9 not found in the fixture")
+	}
+	for _, size := range []uint64{1 << 47, 4 * 4096} {
+		binary.LittleEndian.PutUint64(in[16:], size)
+		fs, err := filesystem.NewHandler(filesystem.FS_F2FS, r, 0, uint64(len(r.data)))
+		if err != nil {
+			t.Fatalf("NewHandler(F2FS): %v", err)
+		}
+		if got, err := fs.GetFile("/a-rather-long-file-name.txt"); err == nil {
+			t.Errorf("size %d: GetFile = %d bytes, want an error", size, len(got))
+		}
+	}
+}
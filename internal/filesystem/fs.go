@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 )
 
 // Sentinel errors returned (possibly wrapped) by filesystem handlers and the
@@ -19,12 +20,21 @@ var (
 	// ErrNotFound is returned when a path component or file does not exist.
 	ErrNotFound = errors.New("not found")
 	// ErrUnsupported is returned when the filesystem or parser path is not
-	// implemented (detection-only filesystems, unimplemented attribute lists).
+	// implemented (detection-only filesystems, unsupported on-disk encodings).
 	ErrUnsupported = errors.New("unsupported")
 	// ErrIsDirectory is returned when a file operation targets a directory.
 	ErrIsDirectory = errors.New("is a directory")
 	// ErrNotDirectory is returned when a directory operation targets a file.
 	ErrNotDirectory = errors.New("not a directory")
+	// ErrWrongKey is returned when key material does not unlock an encrypted
+	// volume.
+	ErrWrongKey = errors.New("wrong key")
+	// ErrReallocated is returned when reading a deleted file whose data
+	// clusters have since been allocated to another file.
+	ErrReallocated = errors.New("deleted file's clusters reallocated")
+	// ErrPlaceholder is returned when reading a placeholder whose content
+	// lives outside the volume (a cloud-files, deduplication or HSM stub).
+	ErrPlaceholder = errors.New("placeholder content not stored on the volume")
 )
 
 // FileOpener is implemented by filesystem handlers that can open a file for
@@ -51,6 +61,272 @@ type InodeOpener interface {
 	OpenInode(inode uint64, size int64) (io.ReadSeekCloser, error)
 }
 
+// DatasetOpener is implemented by pooled filesystems (ZFS) whose handler
+// reads one dataset of several. Datasets lists them all, snapshots
+// included; OpenDataset opens one by name as its own FileSystem.
+type DatasetOpener interface {
+	Datasets() ([]Dataset, error)
+	OpenDataset(name string) (FileSystem, error)
+}
+
+// Dataset describes one dataset of a pooled filesystem.
+type Dataset struct {
+	Name       string // "pool/child", or "pool/child@snap" for a snapshot
+	Snapshot   bool
+	GUID       uint64
+	Created    int64  // creation time in Unix seconds
+	Referenced uint64 // bytes referenced
+}
+
+// DeletedOpener is implemented by filesystems that can recover deleted files
+// from their metadata (NTFS). WithDeleted returns a view of the same volume
+// whose listings and path lookups also cover deleted entries, marked with
+// DirectoryEntry.Deleted; the handler it is called on is unchanged.
+type DeletedOpener interface {
+	WithDeleted() (FileSystem, error)
+}
+
+// ReallocatedReader is implemented by filesystems whose deleted files can
+// lose clusters to files written later (NTFS). Reallocated lists the byte
+// ranges of a deleted file's stream whose clusters now belong to another
+// file; WithReallocated returns a deleted-file view (see DeletedOpener) that
+// reads such a file with those ranges as zeros instead of failing with
+// ErrReallocated.
+type ReallocatedReader interface {
+	Reallocated(path string) ([]ByteRange, error)
+	WithReallocated() (FileSystem, error)
+}
+
+// ByteRange is a span of bytes within a file.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// StreamLister is implemented by filesystems whose files carry named data
+// streams besides their main content (NTFS alternate data streams). Streams
+// lists a file's named streams; GetFile and FileOpener.OpenFile read one
+// given as "path:name".
+type StreamLister interface {
+	Streams(path string) ([]Stream, error)
+}
+
+// Stream describes one named data stream of a file.
+type Stream struct {
+	Name     string
+	Size     uint64
+	Resident bool // stored inside the file's metadata record
+}
+
+// IndexLister is implemented by filesystems whose directories are on-disk
+// indexes that keep remnants of removed entries (NTFS $I30). IndexEntries
+// parses the index of the directory at path and returns its live entries,
+// cross-checked against the file records, followed by entries carved from
+// the index's slack space.
+type IndexLister interface {
+	IndexEntries(path string) ([]IndexEntry, error)
+}
+
+// IndexEntry is one entry of a directory index. Its name, sizes, flags and
+// timestamps are the copy stored in the index, as of the file's last
+// update there; FileRef and ParentRef are MFT references (record number in
+// the low 48 bits, sequence number in the high 16).
+type IndexEntry struct {
+	Name          string
+	Namespace     uint8 // 0 POSIX, 1 Win32, 2 DOS (8.3 short), 3 Win32&DOS
+	FileRef       uint64
+	ParentRef     uint64
+	Size          uint64
+	AllocatedSize uint64
+	Flags         uint32 // FILE_ATTRIBUTE_* flags
+	// Timestamps are FILETIMEs: 100 ns ticks since 1601-01-01 UTC.
+	CreateTime int64
+	ModTime    int64
+	ChangeTime int64 // file record change time
+	AccessTime int64
+	Block      int // index block in $INDEX_ALLOCATION; -1 for $INDEX_ROOT
+	Offset     int // byte offset of the entry in its block or root value
+	// Carved marks an entry recovered from slack space or an unallocated
+	// index block rather than the live index. Its FileRef is 0 when the
+	// entry header did not survive.
+	Carved bool
+	// Mismatch says how a live entry disagrees with the file record it
+	// references, "" when they agree.
+	Mismatch string
+}
+
+// SecurityReader is implemented by filesystems that store Windows security
+// descriptors (NTFS). Security returns the descriptor governing the file or
+// directory at path.
+type SecurityReader interface {
+	Security(path string) (*SecurityDescriptor, error)
+}
+
+// SecurityDescriptor is a parsed self-relative SECURITY_DESCRIPTOR. SIDs
+// are in their string form, "S-1-5-32-544"; Owner and Group are "" when
+// the descriptor has none. DACL is nil when the descriptor carries none:
+// with SE_DACL_PRESENT set in Control that is a NULL DACL, which grants
+// everyone full access.
+type SecurityDescriptor struct {
+	// SecurityID is the descriptor's id in the shared $Secure store; 0
+	// when it is stored with the file itself.
+	SecurityID uint32
+	Control    uint16 // SE_* control flags
+	Owner      string
+	Group      string
+	DACL       *ACL
+	SACL       *ACL
+	Raw        []byte // the self-relative descriptor as stored
+}
+
+// ACL is an access control list.
+type ACL struct {
+	Revision uint8
+	ACEs     []ACE
+}
+
+// ACE is one access control entry. Object ACEs also carry ObjectFlags and
+// the GUIDs those flags say are present, as "bf967aba-0de6-11d0-a285-
+// 00aa003049e2"; SID is "" for ACE types whose layout is unknown.
+type ACE struct {
+	Type                uint8
+	Flags               uint8
+	Mask                uint32
+	SID                 string
+	ObjectFlags         uint32
+	ObjectType          string
+	InheritedObjectType string
+}
+
+// ReparseReader is implemented by filesystems whose files can carry
+// reparse points (NTFS). Reparse decodes the reparse point of the file or
+// directory at path; a file without one is ErrNotFound.
+type ReparseReader interface {
+	Reparse(path string) (*ReparsePoint, error)
+}
+
+// ReparsePoint is a decoded reparse point. SubstituteName and PrintName
+// are the stored names of a symbolic link or junction, in Windows form;
+// Target is the link's destination as a path on the volume of drive letter
+// Drive for an absolute target, on this volume for a relative one, and ""
+// when it names a volume by GUID, a network share or nothing at all.
+type ReparsePoint struct {
+	Tag  uint32
+	GUID string // owner of a third-party tag, "" for Microsoft tags
+	// SubstituteName, PrintName and Relative are set for symbolic links,
+	// junctions and WSL symlinks only.
+	SubstituteName string
+	PrintName      string
+	Relative       bool
+	Drive          string // drive letter of an absolute target, "C"
+	Target         string
+	Data           []byte // the tag-specific data as stored
+}
+
+// LinkFollower is implemented by filesystems whose links are only followed
+// on request (NTFS symbolic links and junctions). FollowLinks returns a view
+// of the same volume whose path lookups follow links to their targets on
+// the volume, which was mounted as drive letter drive ("" when unknown);
+// the handler it is called on is unchanged.
+type LinkFollower interface {
+	FollowLinks(drive string) (FileSystem, error)
+}
+
+// USNJournalOpener is implemented by filesystems that keep an update sequence
+// number change journal (NTFS $Extend\$UsnJrnl:$J). OpenUSNJournal opens it
+// for a forward scan of its records.
+type USNJournalOpener interface {
+	OpenUSNJournal() (USNJournal, error)
+}
+
+// USNJournal streams change-journal records in stored order. Next returns
+// io.EOF after the last record. A record that cannot be parsed or read is
+// returned as an error naming its offset; calling Next again resumes the scan
+// past it.
+type USNJournal interface {
+	Next() (USNRecord, error)
+	Close() error
+}
+
+// USNRecord is one USN_RECORD_V2, V3 or V4 entry. File references are NTFS
+// MFT references (record number | sequence << 48); V3 and V4 store 128-bit
+// references whose low 64 bits these are.
+type USNRecord struct {
+	Offset         int64 // byte offset in the journal stream
+	MajorVersion   uint16
+	MinorVersion   uint16
+	USN            int64
+	FileRef        uint64
+	ParentRef      uint64
+	Timestamp      int64 // FILETIME (100 ns ticks since 1601); 0 for V4
+	Reason         uint32
+	SourceInfo     uint32
+	SecurityID     uint32
+	FileAttributes uint32
+	Name           string      // V2/V3 only
+	Path           string      // resolved full path; "" when unresolvable
+	Extents        []USNExtent // V4 range-tracking records only
+}
+
+// USNExtent is one changed byte range of a V4 range-tracking record.
+type USNExtent struct {
+	Offset int64
+	Length int64
+}
+
+// LogFileOpener is implemented by filesystems with a metadata transaction
+// log (NTFS $LogFile). OpenLogFile parses the log's restart area and record
+// pages and returns its client records in LSN order.
+type LogFileOpener interface {
+	OpenLogFile() (LogFile, error)
+}
+
+// LogFile streams transaction-log events in LSN order. Next returns io.EOF
+// after the last event. A record whose operation data cannot be decoded is
+// returned as an error naming its LSN; calling Next again moves on.
+type LogFile interface {
+	Restart() LogFileRestart
+	Next() (LogFileEvent, error)
+	Close() error
+}
+
+// LogFileRestart is the restart area the log was parsed from, the newer of
+// its two copies.
+type LogFileRestart struct {
+	MajorVersion int
+	MinorVersion int
+	CurrentLSN   uint64
+	Clean        bool // the volume was cleanly dismounted
+	PageSize     int
+	FileSize     int64
+}
+
+// LogFileEvent is one NTFS log client record: a redo/undo operation pair.
+// FileRef, ParentRef and Name are decoded from the operation where it
+// describes a file — an MFT record image, a $FILE_NAME attribute or an index
+// entry — and FileRef otherwise names the MFT record a record-level
+// operation targets (sequence 0: the log does not carry it).
+type LogFileEvent struct {
+	LSN           uint64
+	PreviousLSN   uint64 // previous record of the same transaction
+	UndoNextLSN   uint64
+	Offset        int64 // byte offset of the record in the log
+	TransactionID uint32
+	RedoOp        uint16
+	UndoOp        uint16
+	TargetVCN     uint64
+	// ClusterBlockOffset is the 512-byte block within the target cluster.
+	ClusterBlockOffset uint16
+	RecordOffset       uint16 // offset of the change in the MFT record or index buffer
+	AttributeOffset    uint16 // offset of the change in the attribute
+	RedoData           []byte
+	UndoData           []byte
+	FileRef            uint64
+	ParentRef          uint64
+	Name               string
+	Path               string // resolved full path; "" when unresolvable
+}
+
 // Reader is an interface for reading sector data from a disk image. It is the
 // seam every reader-based handler reads through; the ewf package's internal
 // decompressor satisfies it structurally.
@@ -130,6 +406,12 @@ type DirectoryEntry struct {
 	Cluster uint32
 	// For XFS: inode number
 	Inode uint64
+	// Deleted marks an entry recovered from unallocated metadata (see
+	// DeletedOpener); Reallocated that some of its data clusters now belong
+	// to another file, so its content reads in full only through
+	// ReallocatedReader.WithReallocated.
+	Deleted     bool
+	Reallocated bool
 }
 
 // FileSystem is the interface that must be implemented by filesystem handlers
@@ -144,6 +426,10 @@ type FileSystem interface {
 	GetVolumeLabel() string
 }
 
+// bitLockerToGoGUID is the on-disk identifier of a BitLocker To Go volume
+// header.
+const bitLockerToGoGUID = "\x3B\xD6\x67\x49\x29\x2E\xD8\x4A\x83\x99\xF6\xA3\x39\xE3\xD0\x01"
+
 // DetectFileSystem detects the filesystem type from boot sector data.
 //
 // This is the single source of truth for filesystem detection: the public
@@ -158,6 +444,14 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 		return FS_UNKNOWN
 	}
 
+	// Check BitLocker (OEM name "-FVE-FS-" at offset 3; a BitLocker To Go
+	// volume keeps the FAT32 OEM name "MSWIN4.1" and is identified by the GUID
+	// 4967d63b-2e29-4ad8-8399-f6a339e3d001 at offset 160)
+	if string(sectorData[3:11]) == "-FVE-FS-" ||
+		(string(sectorData[3:11]) == "MSWIN4.1" && string(sectorData[160:176]) == bitLockerToGoGUID) {
+		return FS_BITLOCKER
+	}
+
 	// Check NTFS (signature at offset 3)
 	if len(sectorData) >= 8 && string(sectorData[3:7]) == "NTFS" {
 		return FS_NTFS
@@ -193,6 +487,12 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 		}
 	}
 
+	// Check F2FS (include/linux/f2fs_fs.h: the superblock sits at byte 1024
+	// and starts with the little-endian magic 0xF2F52010)
+	if len(sectorData) >= 1028 && binary.LittleEndian.Uint32(sectorData[1024:1028]) == 0xF2F52010 {
+		return FS_F2FS
+	}
+
 	// Check APFS (Apple File System Reference: the container superblock starts
 	// at partition offset 0 and its 4-byte NXSB magic sits at offset 0x20,
 	// after the 32-byte obj_phys header). In-window for the 4096-byte GPT
@@ -203,10 +503,11 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 
 	// Check ext2/3/4 (s_magic 0xEF53 little-endian at offset 0x38 within the
 	// superblock; the superblock always starts at byte 1024 from the partition
-	// start — block 0 holds the 1024-byte boot block)
+	// start — block 0 holds the 1024-byte boot block). The feature flags tell
+	// the three apart.
 	if len(sectorData) >= 1024+0x3A &&
 		binary.LittleEndian.Uint16(sectorData[1024+0x38:1024+0x3A]) == 0xEF53 {
-		return FS_EXT4
+		return ExtFileSystemType(sectorData[1024:])
 	}
 
 	// Check XFS ("XFSB", big-endian superblock at offset 0) with field
@@ -237,16 +538,13 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 		return FS_UNKNOWN // XFSB magic found but superblock fields invalid
 	}
 
-	// Check SquashFS ("hsqs" at offset 96)
-	if len(sectorData) >= 100 && string(sectorData[96:100]) == "hsqs" {
+	// Check SquashFS ("hsqs" at offset 0, major version 4 at offset 28;
+	// older big-endian "sqsh" images are not recognised)
+	if len(sectorData) >= 32 && string(sectorData[0:4]) == "hsqs" &&
+		binary.LittleEndian.Uint16(sectorData[28:30]) == 4 {
 		return FS_SQUASHFS
 	}
 
-	// Check F2FS ("F2FS" at offset 0)
-	if len(sectorData) >= 4 && string(sectorData[:4]) == "F2FS" {
-		return FS_F2FS
-	}
-
 	// Check Btrfs (superblock magic "_BHRfS_M" at offset 0x10040)
 	if len(sectorData) >= 0x10048 && string(sectorData[0x10040:0x10048]) == "_BHRfS_M" {
 		return FS_BTRFS
@@ -257,13 +555,13 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 		return FS_REFS
 	}
 
-	// Check LUKS (magic "LUKS" at offset 0)
-	if len(sectorData) >= 8 && string(sectorData[0:6]) == "LUKS" {
+	// Check LUKS (magic "LUKS\xba\xbe" at offset 0)
+	if len(sectorData) >= 8 && string(sectorData[0:6]) == "LUKS\xba\xbe" {
 		return FS_LUKS
 	}
 
-	// Check ZFS (magic "ZFS " at offset 0x84)
-	if len(sectorData) >= 136 && string(sectorData[0x84:0x88]) == "ZFS " {
+	// Check ZFS (vdev label config nvlist at offset 16 KiB)
+	if IsZFSLabel(sectorData) {
 		return FS_ZFS
 	}
 
@@ -275,6 +573,47 @@ func DetectFileSystem(sectorData []byte) FileSystemType {
 	return FS_UNKNOWN
 }
 
+// ExtFileSystemType classifies an ext2/3/4 superblock by its feature flags,
+// as blkid does: any incompat or ro_compat feature beyond what ext3 knows
+// (extents, 64bit, flex_bg, huge_file, metadata_csum, ...) makes it ext4, a
+// journal without them ext3, and neither ext2. A superblock too short for
+// the feature words is ext4, the handler's native type.
+func ExtFileSystemType(sb []byte) FileSystemType {
+	if len(sb) < 0x68 {
+		return FS_EXT4
+	}
+	compat := binary.LittleEndian.Uint32(sb[0x5C:])
+	incompat := binary.LittleEndian.Uint32(sb[0x60:])
+	roCompat := binary.LittleEndian.Uint32(sb[0x64:])
+	const (
+		compatHasJournal = 0x4
+		// FILETYPE, RECOVER, META_BG
+		ext3Incompat = 0x2 | 0x4 | 0x10
+		// SPARSE_SUPER, LARGE_FILE, BTREE_DIR
+		ext3ROCompat = 0x1 | 0x2 | 0x4
+	)
+	switch {
+	case incompat&^ext3Incompat != 0 || roCompat&^ext3ROCompat != 0:
+		return FS_EXT4
+	case compat&compatHasJournal != 0:
+		return FS_EXT3
+	}
+	return FS_EXT2
+}
+
+// IsZFSLabel reports whether data, read from the start of a device, holds
+// the config of ZFS vdev label 0: at offset 16 KiB an XDR-encoded nvlist
+// (encoding 1, version 0, unique-name flag) whose first pair is "version".
+func IsZFSLabel(data []byte) bool {
+	const nv = 16 << 10
+	if len(data) < nv+32 {
+		return false
+	}
+	b := data[nv:]
+	return b[0] == 1 && binary.BigEndian.Uint32(b[4:]) == 0 && binary.BigEndian.Uint32(b[8:]) == 1 &&
+		binary.BigEndian.Uint32(b[20:]) == 7 && string(b[24:31]) == "version"
+}
+
 // DetectFileSystemFromGPT detects filesystem from GPT partition type GUID
 func DetectFileSystemFromGPT(partitionTypeGUID string) FileSystemType {
 	switch partitionTypeGUID {
@@ -338,6 +677,21 @@ func NewHandler(fsType FileSystemType, r Reader, startLBA, partitionSize uint64)
 	return factory(r, startLBA, partitionSize)
 }
 
+// HandlerType returns the registered reader-based filesystem type whose name
+// matches name case-insensitively ("ntfs" is NTFS). An unknown name is an
+// error listing the valid ones.
+func HandlerType(name string) (FileSystemType, error) {
+	names := make([]string, 0, len(handlerRegistry))
+	for t := range handlerRegistry {
+		if strings.EqualFold(string(t), name) {
+			return t, nil
+		}
+		names = append(names, string(t))
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unsupported filesystem type %q (one of %s)", name, strings.Join(names, ", "))
+}
+
 // RegisteredFileSystems returns the sorted list of filesystem types that have
 // a registered handler (reader-less or reader-based). The defabrication-gate
 // test iterates it to enforce that every registered handler errors honestly
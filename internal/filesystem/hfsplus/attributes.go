@@ -0,0 +1,109 @@
+package hfsplus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Attributes B-tree (extended attributes).
+//
+// Keys are { keyLength u16, pad u16, fileID u32, startBlock u32,
+// attrNameLen u16, attrName[] u16 }, ordered by fileID, then name (binary
+// code-unit order, even on case-insensitive volumes), then startBlock.
+// Records begin with a u32 recordType:
+//
+//	0x10 inline data  { type, reserved[2] u32, attrSize u32, attrData[] }
+//	0x20 fork data    { type, reserved u32, ForkData }
+//	0x30 extents      { type, reserved u32, extents[8] } (continues a fork)
+
+const (
+	attrInlineData = 0x10
+	attrForkData   = 0x20
+	attrExtents    = 0x30
+	// maxAttrBytes bounds a fork-stored attribute read.
+	maxAttrBytes = 64 << 20
+)
+
+// compareAttrKey orders attribute keys against (fileID, name, startBlock).
+func compareAttrKey(key []byte, fileID uint32, name []uint16, start uint32) int {
+	if len(key) < 14 {
+		return -1
+	}
+	if c := cmpU32(binary.BigEndian.Uint32(key[4:]), fileID); c != 0 {
+		return c
+	}
+	n := int(binary.BigEndian.Uint16(key[12:]))
+	if 14+2*n > len(key) {
+		return -1
+	}
+	if c := compareBinary(decodeUnits(key[14:14+2*n]), name); c != 0 {
+		return c
+	}
+	return cmpU32(binary.BigEndian.Uint32(key[8:]), start)
+}
+
+// attribute returns the value of extended attribute name on cnid, or nil
+// when the file has no such attribute.
+func (h *HFSPlus) attribute(cnid uint32, name string) ([]byte, error) {
+	units := utf16.Encode([]rune(name))
+	var value []byte
+	var fork []byte
+	var more [][]byte
+	err := h.attributes.walk(func(key []byte) int {
+		return compareAttrKey(key, cnid, units, 0)
+	}, func(key, data []byte) (bool, error) {
+		if compareAttrKey(key, cnid, units, binary.BigEndian.Uint32(key[8:])) != 0 || len(data) < 4 {
+			return false, nil
+		}
+		switch binary.BigEndian.Uint32(data) {
+		case attrInlineData:
+			if len(data) < 16 {
+				return false, fmt.Errorf("HFS+: CNID %d attribute %q record too short", cnid, name)
+			}
+			size := int(binary.BigEndian.Uint32(data[12:]))
+			if 16+size > len(data) {
+				return false, fmt.Errorf("HFS+: CNID %d attribute %q overruns its record", cnid, name)
+			}
+			value = data[16 : 16+size]
+			return false, nil
+		case attrForkData:
+			if len(data) < 88 {
+				return false, fmt.Errorf("HFS+: CNID %d attribute %q fork record too short", cnid, name)
+			}
+			fork = data[8:88]
+		case attrExtents:
+			if fork == nil || len(data) < 72 {
+				return false, fmt.Errorf("HFS+: CNID %d attribute %q has a stray extents record", cnid, name)
+			}
+			more = append(more, data[8:72])
+		default:
+			return false, fmt.Errorf("HFS+: CNID %d attribute %q has unknown record type 0x%X", cnid, name, binary.BigEndian.Uint32(data))
+		}
+		return true, nil
+	})
+	if err != nil || value != nil || fork == nil {
+		return value, err
+	}
+
+	// Fork-stored attribute: its extents are the ForkData's plus any
+	// continuation records.
+	size := binary.BigEndian.Uint64(fork)
+	total := uint64(binary.BigEndian.Uint32(fork[12:]))
+	if size > maxAttrBytes || size > total*uint64(h.blockSize) {
+		return nil, fmt.Errorf("HFS+: CNID %d attribute %q has implausible size %d", cnid, name, size)
+	}
+	exts, have := appendExtents(nil, fork[16:80])
+	for _, rec := range more {
+		exts, have = appendExtents(exts, rec)
+	}
+	if have < total {
+		return nil, fmt.Errorf("HFS+: CNID %d attribute %q maps %d of its %d blocks", cnid, name, have, total)
+	}
+	r := &forkReader{h: h, size: int64(size), blockSize: uint64(h.blockSize), exts: exts}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, 0); err != nil && size > 0 {
+		return nil, err
+	}
+	return buf, nil
+}
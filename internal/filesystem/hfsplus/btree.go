@@ -0,0 +1,198 @@
+package hfsplus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HFS+ B-trees (TN1150 "B-Trees").
+//
+// The catalog, extents-overflow and attributes files are all B-trees of
+// fixed-size nodes stored in a special file's fork. Every node starts with a
+// 14-byte BTNodeDescriptor
+//
+//	{ fLink u32, bLink u32, kind i8, height u8, numRecords u16, reserved u16 }
+//
+// and ends with a table of u16 record offsets growing backwards from the end
+// of the node (offset of record i at nodeSize-2*(i+1)); one extra entry past
+// the last record holds the free-space offset, which bounds the last record.
+// Node 0 is the header node; its first record is the BTHeaderRec. Keys begin
+// with a u16 keyLength (kBTBigKeysMask is set on every HFS+ tree) and index
+// records carry their keyLength-sized key followed by a u32 child node number.
+
+// Node kinds (BTNodeDescriptor.kind).
+const (
+	btLeafNode   = -1
+	btIndexNode  = 0
+	btHeaderNode = 1
+)
+
+// btree is an open HFS+ B-tree over one of the special files.
+type btree struct {
+	name        string
+	fork        *forkReader
+	nodeSize    uint32
+	root        uint32
+	depth       uint16
+	totalNodes  uint32
+	compareType byte // BTHeaderRec.keyCompareType (catalog: 0xCF fold, 0xBC binary)
+}
+
+// btNode is one parsed node: its descriptor and the raw bytes of each record.
+type btNode struct {
+	fLink   uint32
+	kind    int8
+	records [][]byte
+}
+
+// openBTree reads the header node of the B-tree stored in fork.
+func openBTree(name string, fork *forkReader) (*btree, error) {
+	hdr := make([]byte, 512)
+	if _, err := fork.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("HFS+: read %s header node: %w", name, err)
+	}
+	if int8(hdr[8]) != btHeaderNode {
+		return nil, fmt.Errorf("HFS+: %s node 0 is not a header node (kind %d)", name, int8(hdr[8]))
+	}
+	rec := hdr[14:]
+	t := &btree{
+		name:        name,
+		fork:        fork,
+		depth:       binary.BigEndian.Uint16(rec[0:]),
+		root:        binary.BigEndian.Uint32(rec[2:]),
+		nodeSize:    uint32(binary.BigEndian.Uint16(rec[18:])),
+		totalNodes:  binary.BigEndian.Uint32(rec[22:]),
+		compareType: rec[37],
+	}
+	if t.nodeSize < 512 || t.nodeSize > 32768 || t.nodeSize&(t.nodeSize-1) != 0 {
+		return nil, fmt.Errorf("HFS+: %s has invalid node size %d", name, t.nodeSize)
+	}
+	if uint64(t.totalNodes)*uint64(t.nodeSize) > uint64(fork.size) {
+		return nil, fmt.Errorf("HFS+: %s declares %d nodes, more than its %d-byte fork holds", name, t.totalNodes, fork.size)
+	}
+	if t.depth > btMaxDepth {
+		return nil, fmt.Errorf("HFS+: %s has implausible depth %d", name, t.depth)
+	}
+	return t, nil
+}
+
+// btMaxDepth bounds tree descent so a crafted tree cannot loop.
+const btMaxDepth = 16
+
+// node reads and parses node n.
+func (t *btree) node(n uint32) (*btNode, error) {
+	if n >= t.totalNodes {
+		return nil, fmt.Errorf("HFS+: %s node %d out of range (%d nodes)", t.name, n, t.totalNodes)
+	}
+	buf := make([]byte, t.nodeSize)
+	if _, err := t.fork.ReadAt(buf, int64(n)*int64(t.nodeSize)); err != nil {
+		return nil, fmt.Errorf("HFS+: read %s node %d: %w", t.name, n, err)
+	}
+	count := int(binary.BigEndian.Uint16(buf[10:]))
+	if 14+2*(count+1) > len(buf) {
+		return nil, fmt.Errorf("HFS+: %s node %d has implausible %d records", t.name, n, count)
+	}
+	nd := &btNode{fLink: binary.BigEndian.Uint32(buf[0:]), kind: int8(buf[8]), records: make([][]byte, count)}
+	offset := func(i int) int { return int(binary.BigEndian.Uint16(buf[len(buf)-2*(i+1):])) }
+	for i := 0; i < count; i++ {
+		start, end := offset(i), offset(i+1)
+		if start < 14 || end < start || end > len(buf)-2*(count+1) {
+			return nil, fmt.Errorf("HFS+: %s node %d record %d has bad bounds %d..%d", t.name, n, i, start, end)
+		}
+		nd.records[i] = buf[start:end]
+	}
+	return nd, nil
+}
+
+// splitRecord splits a record into its key (including the keyLength field)
+// and its data, which starts on the next 2-byte boundary.
+func splitRecord(rec []byte) (key, data []byte, err error) {
+	if len(rec) < 2 {
+		return nil, nil, fmt.Errorf("HFS+: B-tree record too short")
+	}
+	end := 2 + int(binary.BigEndian.Uint16(rec))
+	if end > len(rec) {
+		return nil, nil, fmt.Errorf("HFS+: B-tree key length %d overruns its record", end-2)
+	}
+	key = rec[:end]
+	if end%2 == 1 {
+		end++
+	}
+	if end > len(rec) {
+		end = len(rec)
+	}
+	return key, rec[end:], nil
+}
+
+// walk visits every leaf record whose key compares >= 0 under cmp, in key
+// order, starting at the first such record. cmp reports the sign of
+// (key - target). fn returns false to stop. A descent always takes the last
+// index record whose key is <= the target, so walk finds the first matching
+// leaf record even when the target itself is absent.
+func (t *btree) walk(cmp func(key []byte) int, fn func(key, data []byte) (bool, error)) error {
+	if t.root == 0 {
+		return nil // empty tree
+	}
+	n := t.root
+	var nd *btNode
+	for level := 0; ; level++ {
+		if level > btMaxDepth {
+			return fmt.Errorf("HFS+: %s descent exceeds depth %d", t.name, btMaxDepth)
+		}
+		var err error
+		if nd, err = t.node(n); err != nil {
+			return err
+		}
+		if nd.kind == btLeafNode {
+			break
+		}
+		if nd.kind != btIndexNode || len(nd.records) == 0 {
+			return fmt.Errorf("HFS+: %s node %d is not an index node (kind %d)", t.name, n, nd.kind)
+		}
+		child := -1
+		for i, rec := range nd.records {
+			key, data, err := splitRecord(rec)
+			if err != nil {
+				return err
+			}
+			if i > 0 && cmp(key) > 0 {
+				break
+			}
+			if len(data) < 4 {
+				return fmt.Errorf("HFS+: %s index record in node %d has no child pointer", t.name, n)
+			}
+			child = int(binary.BigEndian.Uint32(data))
+		}
+		n = uint32(child)
+	}
+
+	// Scan forward along the leaf chain. The chain is bounded by totalNodes.
+	for hops := uint32(0); ; hops++ {
+		if hops > t.totalNodes {
+			return fmt.Errorf("HFS+: %s leaf chain loops", t.name)
+		}
+		for _, rec := range nd.records {
+			key, data, err := splitRecord(rec)
+			if err != nil {
+				return err
+			}
+			if cmp(key) < 0 {
+				continue
+			}
+			more, err := fn(key, data)
+			if err != nil || !more {
+				return err
+			}
+		}
+		if nd.fLink == 0 {
+			return nil
+		}
+		var err error
+		if nd, err = t.node(nd.fLink); err != nil {
+			return err
+		}
+		if nd.kind != btLeafNode {
+			return fmt.Errorf("HFS+: %s leaf chain reaches a non-leaf node", t.name)
+		}
+	}
+}
@@ -0,0 +1,979 @@
+package hfsplus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/apfs"
+)
+
+func init() {
+	filesystem.RegisterFileSystem(filesystem.FS_HFS, func() filesystem.FileSystem { return &HFSPlus{} })
+	filesystem.RegisterHandler(filesystem.FS_HFS, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+		return NewHFSPlusHandler(r, startLBA)
+	})
+}
+
+// HFS+ / HFSX (Mac OS Extended) implementation.
+//
+// Reference: Apple TN1150 "HFS Plus Volume Format". Every multi-byte field is
+// big-endian.
+//
+//   - The volume header sits at byte 1024 of the volume: signature 'H+'
+//     (version 4) or 'HX' (HFSX, version 5), blockSize at 40, totalBlocks at
+//     44, and the ForkData of the special files (extents overflow at 192,
+//     catalog at 272, attributes at 352).
+//   - ForkData is { logicalSize u64, clumpSize u32, totalBlocks u32,
+//     extents[8] {startBlock u32, blockCount u32} }. A fork with more than
+//     eight extents continues in the extents-overflow B-tree, keyed
+//     {forkType, fileID, startBlock} where startBlock is the fork-relative
+//     allocation block the record's first extent maps.
+//   - Catalog keys are {parentID u32, nodeName HFSUniStr255}. Leaf records are
+//     folder (1), file (2), folder thread (3) and file thread (4); a thread is
+//     keyed {CNID, ""} and names the item's parent and name, which is how a
+//     CNID resolves back to its catalog record.
+//   - HFS+ orders names with Apple's case-insensitive FastUnicodeCompare; HFSX
+//     volumes whose catalog keyCompareType is kHFSBinaryCompare (0xBC) order
+//     them by raw UTF-16 code unit and are case-sensitive.
+//   - File hard links are catalog files of type 'hlnk' / creator 'hfs+' whose
+//     BSD special field is the link reference; the data lives in the file
+//     "iNode<ref>" inside the "\0\0\0\0HFS+ Private Data" root folder.
+//     Directory hard links ('fdrp' / 'MACS') point at "dir_<ref>" inside
+//     ".HFS+ Private Directory Data\r".
+//   - Symlinks have BSD mode S_IFLNK and store the target in the data fork.
+//   - Transparently compressed files carry the UF_COMPRESSED owner flag and a
+//     com.apple.decmpfs attribute in the attributes B-tree; its payload (and
+//     the resource fork, for the resource-fork algorithms) is the same format
+//     APFS stores, so the APFS decoder is reused.
+//
+// The journal is not replayed: reads reflect the B-trees as last written to
+// their home locations.
+
+// Volume header signatures.
+const (
+	hfsPlusSignature = 0x482B // 'H+'
+	hfsxSignature    = 0x4858 // 'HX'
+)
+
+// Catalog record types.
+const (
+	recFolder       = 1
+	recFile         = 2
+	recFolderThread = 3
+	recFileThread   = 4
+)
+
+// Reserved catalog node IDs.
+const (
+	cnidRootParent = 1
+	cnidRoot       = 2
+	cnidExtents    = 3
+	cnidCatalog    = 4
+	cnidAttributes = 8
+)
+
+const (
+	// kHFSBinaryCompare: the HFSX catalog orders names by code unit.
+	keyCompareBinary = 0xBC
+	// hfsEpochDelta converts HFS+ dates (seconds since 1904-01-01 UTC) to Unix.
+	hfsEpochDelta = 2082844800
+	// ufCompressed is the BSD UF_COMPRESSED owner flag (decmpfs file).
+	ufCompressed = 0x20
+	// finderInvisible is the Finder kIsInvisible flag.
+	finderInvisible = 0x4000
+	// forkData and forkRsrc are the extents-overflow fork types.
+	forkData = 0x00
+	forkRsrc = 0xFF
+	// resourceForkSuffix names a file's resource fork, as on macOS.
+	resourceForkSuffix = "/..namedfork/rsrc"
+	// maxSymlinkHops bounds symlink resolution during a path walk.
+	maxSymlinkHops = 40
+	// maxSearchDepth and maxSearchCount bound SearchFiles.
+	maxSearchDepth = 32
+	maxSearchCount = 100000
+)
+
+// Names of the hard-link metadata folders in the root directory.
+var (
+	privateDataName    = "\x00\x00\x00\x00HFS+ Private Data"
+	privateDirDataName = ".HFS+ Private Directory Data\r"
+)
+
+// Finder type/creator codes identifying hard links.
+const (
+	typeHardLink       = 0x686C6E6B // 'hlnk'
+	creatorHardLink    = 0x6866732B // 'hfs+'
+	typeDirHardLink    = 0x66647270 // 'fdrp'
+	creatorDirHardLink = 0x4D414353 // 'MACS'
+)
+
+// HFSPlus implements filesystem.FileSystem over an HFS+ or HFSX volume.
+type HFSPlus struct {
+	startLBA    uint64
+	readFunc    func(startLBA uint64, count uint64) ([]byte, error)
+	blockSize   uint32
+	totalBlocks uint32
+	hfsx        bool
+	volumeName  string
+
+	// Raw ForkData of the special files (set by Open).
+	extentsForkData, catalogForkData, attributesForkData []byte
+
+	// B-trees (set by mount; attributes is nil when the volume has none).
+	extents, catalog, attributes *btree
+	caseSensitive                bool
+
+	// CNIDs of the hard-link metadata folders (0 when absent).
+	privateData, privateDirData uint32
+}
+
+// extent is one run of allocation blocks.
+type extent struct {
+	start, count uint32
+}
+
+// catalogRecord is a parsed catalog folder or file record.
+type catalogRecord struct {
+	kind       int16
+	cnid       uint32
+	parent     uint32
+	name       string
+	mode       uint16 // BSD fileMode (0 when the item has no BSD info)
+	ownerFlags uint8
+	special    uint32 // BSD special: hard-link reference for link files
+	fileType   uint32
+	creator    uint32
+	finder     uint16 // Finder flags
+	create     int64
+	modify     int64
+	access     int64
+	dataFork   []byte // raw ForkData (files only)
+	rsrcFork   []byte
+}
+
+func (r *catalogRecord) isDir() bool { return r.kind == recFolder }
+
+func (r *catalogRecord) isSymlink() bool { return r.kind == recFile && r.mode&0xF000 == 0xA000 }
+
+func (r *catalogRecord) isCompressed() bool {
+	return r.kind == recFile && r.ownerFlags&ufCompressed != 0
+}
+
+// NewHFSPlusHandler opens the HFS+/HFSX volume at startLBA and mounts its
+// catalog.
+func NewHFSPlusHandler(reader filesystem.Reader, startLBA uint64) (*HFSPlus, error) {
+	h := &HFSPlus{startLBA: startLBA, readFunc: reader.ReadSectors}
+	sectors, err := reader.ReadSectors(startLBA, 4)
+	if err != nil {
+		return nil, fmt.Errorf("HFS+: failed to read volume header: %w", err)
+	}
+	if err := h.Open(sectors); err != nil {
+		return nil, err
+	}
+	if err := h.mount(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *HFSPlus) Type() filesystem.FileSystemType { return filesystem.FS_HFS }
+
+// Open validates the volume header (at byte 1024 of sectorData) and caches the
+// geometry and special-file forks. It reads nothing else.
+func (h *HFSPlus) Open(sectorData []byte) error {
+	if len(sectorData) < 1536 {
+		return fmt.Errorf("HFS+: sector data too small (%d bytes)", len(sectorData))
+	}
+	vh := sectorData[1024:1536]
+	sig, version := binary.BigEndian.Uint16(vh[0:]), binary.BigEndian.Uint16(vh[2:])
+	switch {
+	case sig == hfsPlusSignature && version == 4:
+	case sig == hfsxSignature && version == 5:
+		h.hfsx = true
+	default:
+		return fmt.Errorf("HFS+: invalid volume header signature 0x%04X version %d", sig, version)
+	}
+	bs := binary.BigEndian.Uint32(vh[40:])
+	if bs < 512 || bs > 1<<20 || bs&(bs-1) != 0 {
+		return fmt.Errorf("HFS+: invalid block size %d", bs)
+	}
+	h.blockSize = bs
+	h.totalBlocks = binary.BigEndian.Uint32(vh[44:])
+	if h.totalBlocks == 0 {
+		return fmt.Errorf("HFS+: volume header declares zero blocks")
+	}
+	h.extentsForkData = append([]byte(nil), vh[192:272]...)
+	h.catalogForkData = append([]byte(nil), vh[272:352]...)
+	h.attributesForkData = append([]byte(nil), vh[352:432]...)
+	return nil
+}
+
+func (h *HFSPlus) Close() error { return nil }
+
+// GetVolumeLabel returns the name of the root folder (the volume name).
+func (h *HFSPlus) GetVolumeLabel() string { return h.volumeName }
+
+// IsHFSX reports whether the volume is HFSX (signature 'HX').
+func (h *HFSPlus) IsHFSX() bool { return h.hfsx }
+
+// CaseSensitive reports whether catalog names compare case-sensitively (an
+// HFSX volume with binary key comparison).
+func (h *HFSPlus) CaseSensitive() bool { return h.caseSensitive }
+
+// mount opens the extents-overflow, catalog and attributes B-trees and looks
+// up the volume name and the hard-link metadata folders.
+func (h *HFSPlus) mount() error {
+	// The extents file maps itself entirely from the volume header.
+	ext, err := h.newForkReader(cnidExtents, forkData, h.extentsForkData, false)
+	if err != nil {
+		return err
+	}
+	if h.extents, err = openBTree("extents overflow file", ext); err != nil {
+		return err
+	}
+	cat, err := h.newForkReader(cnidCatalog, forkData, h.catalogForkData, true)
+	if err != nil {
+		return err
+	}
+	if h.catalog, err = openBTree("catalog file", cat); err != nil {
+		return err
+	}
+	h.caseSensitive = h.hfsx && h.catalog.compareType == keyCompareBinary
+	if binary.BigEndian.Uint64(h.attributesForkData) != 0 {
+		attr, err := h.newForkReader(cnidAttributes, forkData, h.attributesForkData, true)
+		if err != nil {
+			return err
+		}
+		if h.attributes, err = openBTree("attributes file", attr); err != nil {
+			return err
+		}
+	}
+
+	_, name, err := h.thread(cnidRoot)
+	if err != nil {
+		return fmt.Errorf("HFS+: root folder: %w", err)
+	}
+	h.volumeName = name
+	if rec, err := h.lookup(cnidRoot, privateDataName); err == nil && rec.isDir() {
+		h.privateData = rec.cnid
+	} else if err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+		return err
+	}
+	if rec, err := h.lookup(cnidRoot, privateDirDataName); err == nil && rec.isDir() {
+		h.privateDirData = rec.cnid
+	} else if err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// readBlocks reads count allocation blocks starting at block.
+func (h *HFSPlus) readBlocks(block, count uint64) ([]byte, error) {
+	if h.readFunc == nil {
+		return nil, fmt.Errorf("HFS+: handler has no reader")
+	}
+	if block+count > uint64(h.totalBlocks) {
+		return nil, fmt.Errorf("HFS+: blocks %d+%d beyond the volume (%d blocks)", block, count, h.totalBlocks)
+	}
+	spb := uint64(h.blockSize / 512)
+	return h.readFunc(h.startLBA+block*spb, count*spb)
+}
+
+// forkExtents returns the extents of a fork: the eight in its ForkData, then
+// (when overflow is set and they do not cover totalBlocks) the records of the
+// extents-overflow file.
+func (h *HFSPlus) forkExtents(fileID uint32, forkType byte, fd []byte, overflow bool) ([]extent, uint64, error) {
+	if len(fd) < 80 {
+		return nil, 0, fmt.Errorf("HFS+: fork data too short")
+	}
+	size := binary.BigEndian.Uint64(fd[0:])
+	total := binary.BigEndian.Uint32(fd[12:])
+	exts, have := appendExtents(nil, fd[16:80])
+	if size > uint64(total)*uint64(h.blockSize) {
+		return nil, 0, fmt.Errorf("HFS+: CNID %d fork size %d exceeds its %d blocks", fileID, size, total)
+	}
+	if have < uint64(total) && overflow {
+		var err error
+		if exts, have, err = h.overflowExtents(fileID, forkType, exts, have, uint64(total)); err != nil {
+			return nil, 0, err
+		}
+	}
+	if have < uint64(total) {
+		return nil, 0, fmt.Errorf("HFS+: CNID %d fork maps %d of its %d blocks", fileID, have, total)
+	}
+	return exts, size, nil
+}
+
+// appendExtents appends the non-empty descriptors of an 8-extent record and
+// returns the running block count.
+func appendExtents(exts []extent, rec []byte) ([]extent, uint64) {
+	var have uint64
+	for _, e := range exts {
+		have += uint64(e.count)
+	}
+	for i := 0; i+8 <= len(rec) && i < 64; i += 8 {
+		e := extent{binary.BigEndian.Uint32(rec[i:]), binary.BigEndian.Uint32(rec[i+4:])}
+		if e.count == 0 {
+			break
+		}
+		exts = append(exts, e)
+		have += uint64(e.count)
+	}
+	return exts, have
+}
+
+// overflowExtents continues a fork's extent list from the extents-overflow
+// B-tree, one 8-extent record per lookup, until total blocks are mapped.
+func (h *HFSPlus) overflowExtents(fileID uint32, forkType byte, exts []extent, have, total uint64) ([]extent, uint64, error) {
+	for have < total {
+		want := uint32(have)
+		var rec []byte
+		err := h.extents.walk(func(key []byte) int {
+			return compareExtentKey(key, fileID, forkType, want)
+		}, func(key, data []byte) (bool, error) {
+			if compareExtentKey(key, fileID, forkType, want) == 0 {
+				rec = data
+			}
+			return false, nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if rec == nil {
+			return nil, 0, fmt.Errorf("HFS+: CNID %d fork has no overflow extents at block %d", fileID, want)
+		}
+		before := have
+		if exts, have = appendExtents(exts, rec); have == before {
+			return nil, 0, fmt.Errorf("HFS+: CNID %d overflow extent record at block %d is empty", fileID, want)
+		}
+	}
+	return exts, have, nil
+}
+
+// compareExtentKey orders extents-overflow keys by fileID, forkType, then
+// startBlock: {keyLength u16, forkType u8, pad u8, fileID u32, startBlock u32}.
+func compareExtentKey(key []byte, fileID uint32, forkType byte, start uint32) int {
+	if len(key) < 12 {
+		return -1
+	}
+	if c := cmpU32(binary.BigEndian.Uint32(key[4:]), fileID); c != 0 {
+		return c
+	}
+	if key[2] != forkType {
+		if key[2] < forkType {
+			return -1
+		}
+		return 1
+	}
+	return cmpU32(binary.BigEndian.Uint32(key[8:]), start)
+}
+
+func cmpU32(a, b uint32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// --- catalog ---
+
+// catalogKeyName decodes the nodeName of a catalog key as UTF-16 code units.
+func catalogKeyName(key []byte) ([]uint16, error) {
+	if len(key) < 8 {
+		return nil, fmt.Errorf("HFS+: catalog key too short")
+	}
+	n := int(binary.BigEndian.Uint16(key[6:]))
+	if 8+2*n > len(key) {
+		return nil, fmt.Errorf("HFS+: catalog key name overruns its key")
+	}
+	return decodeUnits(key[8 : 8+2*n]), nil
+}
+
+func decodeUnits(b []byte) []uint16 {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return u
+}
+
+// compareCatalogKey orders catalog keys by parentID, then name under the
+// volume's comparison (binary on case-sensitive HFSX, case-folding otherwise).
+func (h *HFSPlus) compareCatalogKey(key []byte, parent uint32, name []uint16) int {
+	if len(key) < 8 {
+		return -1
+	}
+	if c := cmpU32(binary.BigEndian.Uint32(key[2:]), parent); c != 0 {
+		return c
+	}
+	kn, err := catalogKeyName(key)
+	if err != nil {
+		return -1
+	}
+	if h.caseSensitive {
+		return compareBinary(kn, name)
+	}
+	return compareFolded(kn, name)
+}
+
+// compareBinary is Apple's UnicodeBinaryCompare: code unit by code unit, a
+// prefix ordering before the longer name.
+func compareBinary(a, b []uint16) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareFolded is Apple's FastUnicodeCompare: code units are folded through
+// Apple's table (see foldUnit), units that fold to 0 are skipped and NUL sorts
+// after every other character (which places the "\0\0\0\0HFS+ Private Data"
+// folder last).
+func compareFolded(a, b []uint16) int {
+	i, j := 0, 0
+	for {
+		var ca, cb uint16
+		for ca == 0 && i < len(a) {
+			ca = foldUnit(a[i])
+			i++
+		}
+		for cb == 0 && j < len(b) {
+			cb = foldUnit(b[j])
+			j++
+		}
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+		if ca == 0 {
+			return 0
+		}
+	}
+}
+
+// decomposed converts a path component to the decomposed form HFS+ stores
+// names in (TN1150, "Unicode Subtleties"): canonical decomposition, except
+// that U+2000-U+2FFF, U+F900-U+FAFF and U+2F800-U+2FAFF are kept as they are.
+func decomposed(name string) string {
+	var b strings.Builder
+	start := 0
+	for i, r := range name {
+		if (r >= 0x2000 && r <= 0x2FFF) || (r >= 0xF900 && r <= 0xFAFF) || (r >= 0x2F800 && r <= 0x2FAFF) {
+			b.WriteString(norm.NFD.String(name[start:i]))
+			b.WriteRune(r)
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	b.WriteString(norm.NFD.String(name[start:]))
+	return b.String()
+}
+
+// lookup returns the catalog record named name inside folder parent. The name
+// is decomposed first, so a precomposed path finds its on-disk name. Hard
+// links are not resolved here.
+func (h *HFSPlus) lookup(parent uint32, name string) (*catalogRecord, error) {
+	units := utf16.Encode([]rune(decomposed(name)))
+	var found *catalogRecord
+	err := h.catalog.walk(func(key []byte) int {
+		return h.compareCatalogKey(key, parent, units)
+	}, func(key, data []byte) (bool, error) {
+		if h.compareCatalogKey(key, parent, units) != 0 {
+			return false, nil
+		}
+		rec, err := parseCatalogRecord(key, data)
+		if err != nil {
+			return false, err
+		}
+		found = rec
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil || (found.kind != recFolder && found.kind != recFile) {
+		return nil, fmt.Errorf("HFS+: %q not found in folder %d: %w", name, parent, filesystem.ErrNotFound)
+	}
+	return found, nil
+}
+
+// thread resolves a CNID to its parent folder and name via its thread record.
+func (h *HFSPlus) thread(cnid uint32) (uint32, string, error) {
+	var parent uint32
+	var name string
+	found := false
+	err := h.catalog.walk(func(key []byte) int {
+		return h.compareCatalogKey(key, cnid, nil)
+	}, func(key, data []byte) (bool, error) {
+		if h.compareCatalogKey(key, cnid, nil) != 0 || len(data) < 10 {
+			return false, nil
+		}
+		typ := int16(binary.BigEndian.Uint16(data))
+		if typ != recFolderThread && typ != recFileThread {
+			return false, nil
+		}
+		n := int(binary.BigEndian.Uint16(data[8:]))
+		if 10+2*n > len(data) {
+			return false, fmt.Errorf("HFS+: thread record of CNID %d overruns its record", cnid)
+		}
+		parent = binary.BigEndian.Uint32(data[4:])
+		name = string(utf16.Decode(decodeUnits(data[10 : 10+2*n])))
+		found = true
+		return false, nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if !found {
+		return 0, "", fmt.Errorf("HFS+: CNID %d has no thread record: %w", cnid, filesystem.ErrNotFound)
+	}
+	return parent, name, nil
+}
+
+// record resolves a CNID to its catalog record through its thread.
+func (h *HFSPlus) record(cnid uint32) (*catalogRecord, error) {
+	parent, name, err := h.thread(cnid)
+	if err != nil {
+		return nil, err
+	}
+	return h.lookup(parent, name)
+}
+
+// children lists the folder and file records of folder cnid in catalog order.
+func (h *HFSPlus) children(cnid uint32) ([]*catalogRecord, error) {
+	var out []*catalogRecord
+	err := h.catalog.walk(func(key []byte) int {
+		return h.compareCatalogKey(key, cnid, nil)
+	}, func(key, data []byte) (bool, error) {
+		if binary.BigEndian.Uint32(key[2:]) != cnid {
+			return false, nil
+		}
+		if len(data) < 2 {
+			return false, fmt.Errorf("HFS+: empty catalog record in folder %d", cnid)
+		}
+		switch int16(binary.BigEndian.Uint16(data)) {
+		case recFolder, recFile:
+			rec, err := parseCatalogRecord(key, data)
+			if err != nil {
+				return false, err
+			}
+			out = append(out, rec)
+		}
+		return true, nil
+	})
+	return out, err
+}
+
+// parseCatalogRecord parses a folder (88-byte) or file (248-byte) record.
+func parseCatalogRecord(key, data []byte) (*catalogRecord, error) {
+	name, err := catalogKeyName(key)
+	if err != nil {
+		return nil, err
+	}
+	rec := &catalogRecord{
+		kind:   int16(binary.BigEndian.Uint16(data)),
+		parent: binary.BigEndian.Uint32(key[2:]),
+		name:   string(utf16.Decode(name)),
+	}
+	need := 88
+	if rec.kind == recFile {
+		need = 248
+	} else if rec.kind != recFolder {
+		return rec, nil
+	}
+	if len(data) < need {
+		return nil, fmt.Errorf("HFS+: catalog record %q is %d bytes, want %d", rec.name, len(data), need)
+	}
+	rec.cnid = binary.BigEndian.Uint32(data[8:])
+	rec.create = hfsTime(binary.BigEndian.Uint32(data[12:]))
+	rec.modify = hfsTime(binary.BigEndian.Uint32(data[16:]))
+	rec.access = hfsTime(binary.BigEndian.Uint32(data[24:]))
+	rec.ownerFlags = data[41]
+	rec.mode = binary.BigEndian.Uint16(data[42:])
+	rec.special = binary.BigEndian.Uint32(data[44:])
+	rec.finder = binary.BigEndian.Uint16(data[56:])
+	if rec.kind == recFile {
+		rec.fileType = binary.BigEndian.Uint32(data[48:])
+		rec.creator = binary.BigEndian.Uint32(data[52:])
+		rec.dataFork = data[88:168]
+		rec.rsrcFork = data[168:248]
+	}
+	return rec, nil
+}
+
+// hfsTime converts an HFS+ date to Unix seconds (0 stays 0: date not set).
+func hfsTime(v uint32) int64 {
+	if v == 0 {
+		return 0
+	}
+	return int64(v) - hfsEpochDelta
+}
+
+// resolveLink follows a file or directory hard link to the record holding its
+// content in the private metadata folders. The link's own name and parent are
+// kept. Anything else is returned unchanged.
+func (h *HFSPlus) resolveLink(rec *catalogRecord) (*catalogRecord, error) {
+	if rec.kind != recFile {
+		return rec, nil
+	}
+	var dir uint32
+	var name string
+	switch {
+	case rec.fileType == typeHardLink && rec.creator == creatorHardLink:
+		dir, name = h.privateData, "iNode"+strconv.FormatUint(uint64(rec.special), 10)
+	case rec.fileType == typeDirHardLink && rec.creator == creatorDirHardLink:
+		dir, name = h.privateDirData, "dir_"+strconv.FormatUint(uint64(rec.special), 10)
+	default:
+		return rec, nil
+	}
+	if dir == 0 {
+		return nil, fmt.Errorf("HFS+: hard link %q has no private metadata folder", rec.name)
+	}
+	target, err := h.lookup(dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("HFS+: hard link %q: %w", rec.name, err)
+	}
+	resolved := *target
+	resolved.name, resolved.parent = rec.name, rec.parent
+	return &resolved, nil
+}
+
+// splitPath splits a path into its non-empty components.
+func splitPath(path string) []string {
+	var out []string
+	for _, c := range strings.Split(path, "/") {
+		if c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// resolvePath walks path from the root folder, resolving hard links at every
+// component and symlinks at every intermediate component (and the final one
+// when followFinal is set). An empty path resolves to the root folder.
+func (h *HFSPlus) resolvePath(path string, followFinal bool) (*catalogRecord, error) {
+	root, err := h.lookupRoot()
+	if err != nil {
+		return nil, err
+	}
+	hops := 0
+	return h.resolveFrom(root, splitPath(path), followFinal, &hops)
+}
+
+func (h *HFSPlus) lookupRoot() (*catalogRecord, error) {
+	rec, err := h.record(cnidRoot)
+	if err != nil {
+		return nil, fmt.Errorf("HFS+: root folder: %w", err)
+	}
+	return rec, nil
+}
+
+func (h *HFSPlus) resolveFrom(cur *catalogRecord, comps []string, followFinal bool, hops *int) (*catalogRecord, error) {
+	for i, comp := range comps {
+		if !cur.isDir() {
+			return nil, fmt.Errorf("HFS+: %q is not a directory: %w", cur.name, filesystem.ErrNotDirectory)
+		}
+		var next *catalogRecord
+		var err error
+		switch comp {
+		case ".":
+			continue
+		case "..":
+			if cur.cnid == cnidRoot {
+				continue
+			}
+			next, err = h.record(cur.parent)
+		default:
+			if next, err = h.lookup(cur.cnid, comp); err == nil {
+				next, err = h.resolveLink(next)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(comps)-1
+		if next.isSymlink() && (followFinal || !last) {
+			if *hops++; *hops > maxSymlinkHops {
+				return nil, fmt.Errorf("HFS+: too many symlinks resolving %q", comp)
+			}
+			target, err := h.readSymlink(next)
+			if err != nil {
+				return nil, err
+			}
+			base := cur
+			if strings.HasPrefix(target, "/") {
+				if base, err = h.lookupRoot(); err != nil {
+					return nil, err
+				}
+			}
+			return h.resolveFrom(base, append(splitPath(target), comps[i+1:]...), followFinal, hops)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// splitForkPath strips the macOS "/..namedfork/rsrc" suffix, reporting
+// whether the resource fork was named.
+func splitForkPath(path string) (string, bool) {
+	if strings.HasSuffix(path, resourceForkSuffix) {
+		return strings.TrimSuffix(path, resourceForkSuffix), true
+	}
+	return path, false
+}
+
+// readSymlink returns a symlink's target, stored in its data fork.
+func (h *HFSPlus) readSymlink(rec *catalogRecord) (string, error) {
+	data, err := h.readFork(rec, forkData)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readFork reads a whole data or resource fork.
+func (h *HFSPlus) readFork(rec *catalogRecord, forkType byte) ([]byte, error) {
+	fd := rec.dataFork
+	if forkType == forkRsrc {
+		fd = rec.rsrcFork
+	}
+	r, err := h.newForkReader(rec.cnid, forkType, fd, true)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, r.size)
+	if _, err := r.ReadAt(buf, 0); err != nil && len(buf) > 0 {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decmpfs returns the com.apple.decmpfs attribute payload of cnid, or nil
+// when the file has none.
+func (h *HFSPlus) decmpfs(cnid uint32) ([]byte, error) {
+	if h.attributes == nil {
+		return nil, nil
+	}
+	return h.attribute(cnid, "com.apple.decmpfs")
+}
+
+// fileSize is the logical size of a file as the user sees it: the decmpfs
+// uncompressed size for a compressed file, the data-fork size otherwise.
+func (h *HFSPlus) fileSize(rec *catalogRecord) uint64 {
+	if rec.kind != recFile {
+		return 0
+	}
+	if rec.isCompressed() {
+		if payload, err := h.decmpfs(rec.cnid); err == nil {
+			if _, size, ok := apfs.DecmpfsHeader(payload); ok {
+				return size
+			}
+		}
+	}
+	return binary.BigEndian.Uint64(rec.dataFork)
+}
+
+// readContent returns a file's content: decompressed for a decmpfs file, the
+// data fork otherwise.
+func (h *HFSPlus) readContent(rec *catalogRecord) ([]byte, error) {
+	if rec.isCompressed() {
+		payload, err := h.decmpfs(rec.cnid)
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			out, err := apfs.DecodeDecmpfs(payload, func() ([]byte, error) { return h.readFork(rec, forkRsrc) })
+			if err != nil {
+				return nil, fmt.Errorf("HFS+: CNID %d: %w", rec.cnid, err)
+			}
+			return out, nil
+		}
+	}
+	return h.readFork(rec, forkData)
+}
+
+// entryOf builds the DirectoryEntry of a (link-resolved) record.
+func (h *HFSPlus) entryOf(rec *catalogRecord, dirPath string, cnid uint32) filesystem.DirectoryEntry {
+	return filesystem.DirectoryEntry{
+		Name:       rec.name,
+		Path:       filesystem.JoinPath(dirPath, rec.name),
+		Size:       h.fileSize(rec),
+		IsDir:      rec.isDir(),
+		ModTime:    rec.modify,
+		AccessTime: rec.access,
+		CreateTime: rec.create,
+		Inode:      uint64(cnid),
+	}
+}
+
+// listFolder lists folder rec. Entry Inode is the catalog node ID of the
+// entry itself (for a hard link, the link's CNID: OpenInode resolves it).
+func (h *HFSPlus) listFolder(rec *catalogRecord, dirPath string) ([]filesystem.DirectoryEntry, error) {
+	kids, err := h.children(rec.cnid)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]filesystem.DirectoryEntry, 0, len(kids))
+	for _, k := range kids {
+		resolved, err := h.resolveLink(k)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, h.entryOf(resolved, dirPath, k.cnid))
+	}
+	return entries, nil
+}
+
+// ListDirectory lists a directory path. "" and "/" both denote the root.
+func (h *HFSPlus) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: directory parsing requires a reader")
+	}
+	rec, err := h.resolvePath(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.isDir() {
+		return nil, fmt.Errorf("HFS+: %q is not a directory: %w", path, filesystem.ErrNotDirectory)
+	}
+	dirPath := ""
+	if path != "" && path != "/" {
+		dirPath = "/" + strings.Trim(path, "/")
+	}
+	return h.listFolder(rec, dirPath)
+}
+
+// GetFile reads a file's contents by path. Compressed files are decompressed;
+// a symlink returns its target string; "<path>/..namedfork/rsrc" returns the
+// resource fork.
+func (h *HFSPlus) GetFile(path string) ([]byte, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: file reading requires a reader")
+	}
+	path, rsrc := splitForkPath(path)
+	rec, err := h.resolvePath(path, rsrc)
+	if err != nil {
+		return nil, err
+	}
+	if rec.isDir() {
+		return nil, fmt.Errorf("HFS+: %q: %w", path, filesystem.ErrIsDirectory)
+	}
+	if rsrc {
+		return h.readFork(rec, forkRsrc)
+	}
+	if rec.isSymlink() {
+		target, err := h.readSymlink(rec)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(target), nil
+	}
+	return h.readContent(rec)
+}
+
+// GetFileByPath returns metadata for a path.
+func (h *HFSPlus) GetFileByPath(path string) (*filesystem.FileInfo, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: file lookup requires a reader")
+	}
+	rec, err := h.resolvePath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return h.infoOf(rec, "/"+strings.Trim(path, "/")), nil
+}
+
+func (h *HFSPlus) infoOf(rec *catalogRecord, path string) *filesystem.FileInfo {
+	mode := filesystem.FileMode(rec.mode & 0xF000)
+	if mode == 0 {
+		mode = filesystem.ModeRegular
+		if rec.isDir() {
+			mode = filesystem.ModeDir
+		}
+	}
+	return &filesystem.FileInfo{
+		Name:       rec.name,
+		Path:       path,
+		Size:       h.fileSize(rec),
+		Mode:       mode,
+		IsDir:      rec.isDir(),
+		ModTime:    rec.modify,
+		AccessTime: rec.access,
+		CreateTime: rec.create,
+		IsHidden:   rec.finder&finderInvisible != 0 || strings.HasPrefix(rec.name, "."),
+	}
+}
+
+// SearchFiles walks the directory tree under rootPath and returns every
+// FileInfo for which predicate returns true. Directory hard links are walked
+// once.
+func (h *HFSPlus) SearchFiles(rootPath string, predicate func(filesystem.FileInfo) bool) ([]filesystem.FileInfo, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: search requires a reader")
+	}
+	start, err := h.resolvePath(rootPath, true)
+	if err != nil {
+		return nil, err
+	}
+	if !start.isDir() {
+		return nil, fmt.Errorf("HFS+: %q is not a directory: %w", rootPath, filesystem.ErrNotDirectory)
+	}
+	base := ""
+	if rootPath != "" && rootPath != "/" {
+		base = "/" + strings.Trim(rootPath, "/")
+	}
+	results := make([]filesystem.FileInfo, 0)
+	visited := make(map[uint32]bool)
+	var walk func(dir *catalogRecord, dirPath string, depth int) error
+	walk = func(dir *catalogRecord, dirPath string, depth int) error {
+		if depth > maxSearchDepth || visited[dir.cnid] {
+			return nil
+		}
+		visited[dir.cnid] = true
+		kids, err := h.children(dir.cnid)
+		if err != nil {
+			return err
+		}
+		for _, k := range kids {
+			rec, err := h.resolveLink(k)
+			if err != nil {
+				return err
+			}
+			if len(results) >= maxSearchCount {
+				return fmt.Errorf("HFS+: search exceeded %d results", maxSearchCount)
+			}
+			fi := h.infoOf(rec, filesystem.JoinPath(dirPath, rec.name))
+			if predicate(*fi) {
+				results = append(results, *fi)
+			}
+			if rec.isDir() {
+				if err := walk(rec, fi.Path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(start, base, 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,199 @@
+package hfsplus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// OpenFile opens the file at path for streaming reads. It returns a lazy,
+// seekable io.ReadSeekCloser whose reads touch only the allocation blocks
+// intersecting the accessed byte range. "<path>/..namedfork/rsrc" opens the
+// file's resource fork instead of its data fork.
+//
+// The fork's full extent list (the eight extents in the catalog record plus
+// any extents-overflow records) is resolved at open; only data blocks are read
+// lazily. Hard links open their target's data. A directory resolves to
+// ErrIsDirectory, a missing path to ErrNotFound, and a symlink to
+// ErrUnsupported. A decmpfs-compressed file is ErrUnsupported too: its data
+// fork is empty, so streaming it would fabricate zeros; the decompressed
+// content is available via GetFile.
+//
+// Concurrency: the reader's state is immutable after open and every read goes
+// through the handler's readFunc, so ReadAt is safe for concurrent use;
+// Read/Seek share a cursor and are not.
+func (h *HFSPlus) OpenFile(path string) (io.ReadSeekCloser, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: handler has no reader")
+	}
+	path, rsrc := splitForkPath(path)
+	rec, err := h.resolvePath(path, rsrc)
+	if err != nil {
+		return nil, err
+	}
+	return h.openRecord(rec, rsrc)
+}
+
+// OpenInode opens a file by its catalog node ID (DirectoryEntry.Inode),
+// resolving it through its thread record. The size param is ignored.
+func (h *HFSPlus) OpenInode(inode uint64, _ int64) (io.ReadSeekCloser, error) {
+	if h.readFunc == nil || h.catalog == nil {
+		return nil, fmt.Errorf("HFS+: handler has no reader")
+	}
+	if inode == 0 || inode > 0xFFFFFFFF {
+		return nil, fmt.Errorf("HFS+: invalid CNID %d: %w", inode, filesystem.ErrNotFound)
+	}
+	rec, err := h.record(uint32(inode))
+	if err != nil {
+		return nil, err
+	}
+	if rec, err = h.resolveLink(rec); err != nil {
+		return nil, err
+	}
+	return h.openRecord(rec, false)
+}
+
+func (h *HFSPlus) openRecord(rec *catalogRecord, rsrc bool) (io.ReadSeekCloser, error) {
+	switch {
+	case rec.isDir():
+		return nil, fmt.Errorf("HFS+: CNID %d is a directory: %w", rec.cnid, filesystem.ErrIsDirectory)
+	case rsrc:
+		return h.newForkReader(rec.cnid, forkRsrc, rec.rsrcFork, true)
+	case rec.isSymlink():
+		return nil, fmt.Errorf("HFS+: CNID %d is a symlink: %w", rec.cnid, filesystem.ErrUnsupported)
+	case rec.isCompressed():
+		return nil, fmt.Errorf("HFS+: CNID %d is transparently compressed (decmpfs), streaming unsupported: %w", rec.cnid, filesystem.ErrUnsupported)
+	}
+	return h.newForkReader(rec.cnid, forkData, rec.dataFork, true)
+}
+
+// newForkReader resolves a fork's extents (consulting the extents-overflow
+// file when overflow is set) and returns a lazy reader over it.
+func (h *HFSPlus) newForkReader(fileID uint32, forkType byte, fd []byte, overflow bool) (*forkReader, error) {
+	if len(fd) >= 16 && binary.BigEndian.Uint32(fd[12:]) > h.totalBlocks {
+		return nil, fmt.Errorf("HFS+: CNID %d fork declares more blocks than the volume has", fileID)
+	}
+	exts, size, err := h.forkExtents(fileID, forkType, fd, overflow)
+	if err != nil {
+		return nil, err
+	}
+	if size >= uint64(1)<<63 {
+		return nil, fmt.Errorf("HFS+: CNID %d fork size %d overflows int64: %w", fileID, size, filesystem.ErrUnsupported)
+	}
+	return &forkReader{h: h, size: int64(size), blockSize: uint64(h.blockSize), exts: exts}, nil
+}
+
+// forkReader is a lazy, seekable reader over an HFS+ fork. HFS+ has no sparse
+// files: every byte within the logical size is mapped by an extent.
+type forkReader struct {
+	h         *HFSPlus
+	size      int64
+	blockSize uint64
+	exts      []extent
+	pos       int64
+}
+
+// locate maps fork-relative block fb to its volume block and the number of
+// contiguous blocks that follow it in the same extent.
+func (r *forkReader) locate(fb uint64) (uint64, uint64, bool) {
+	var base uint64
+	for _, e := range r.exts {
+		if fb < base+uint64(e.count) {
+			return uint64(e.start) + fb - base, base + uint64(e.count) - fb, true
+		}
+		base += uint64(e.count)
+	}
+	return 0, 0, false
+}
+
+// readAt copies into p the fork bytes starting at off, returning io.EOF for a
+// read at or past the end and n < len(p) with io.EOF for one that crosses it.
+func (r *forkReader) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("HFS+: negative read offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	atEOF := false
+	if want > r.size-off {
+		want = r.size - off
+		atEOF = true
+	}
+	n := 0
+	for int64(n) < want {
+		o := uint64(off) + uint64(n)
+		fb, within := o/r.blockSize, o%r.blockSize
+		block, run, ok := r.locate(fb)
+		if !ok {
+			return n, fmt.Errorf("HFS+: fork offset %d is not mapped by any extent", o)
+		}
+		// Read up to the rest of the request within this extent, capped so a
+		// single request stays bounded.
+		need := (within + uint64(want-int64(n)) + r.blockSize - 1) / r.blockSize
+		if need > run {
+			need = run
+		}
+		if need > 256 {
+			need = 256
+		}
+		data, err := r.h.readBlocks(block, need)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:want], data[within:])
+	}
+	if atEOF {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *forkReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.readAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt; it is safe for concurrent use.
+func (r *forkReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.readAt(p, off)
+}
+
+// Seek implements io.Seeker. It shares the cursor with Read.
+func (r *forkReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative seek position %d", abs)
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+// Close releases the reader; it holds nothing beyond the extent list.
+func (r *forkReader) Close() error { return nil }
+
+var _ io.ReadSeekCloser = (*forkReader)(nil)
+var _ io.ReaderAt = (*forkReader)(nil)
+var _ filesystem.FileOpener = (*HFSPlus)(nil)
+var _ filesystem.InodeOpener = (*HFSPlus)(nil)
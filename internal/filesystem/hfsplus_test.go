@@ -0,0 +1,474 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/hfsplus"
+)
+
+const (
+	fakeHFSBlock    = 4096
+	fakeHFSBlocks   = 64
+	fakeHFSFragSize = 9*fakeHFSBlock - 100
+)
+
+// memHFSReader is a fake Reader over an in-memory HFS+ volume.
+type memHFSReader struct {
+	data []byte
+}
+
+func (r *memHFSReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start := lba * 512
+	end := start + count*512
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("hfs: read past end of image")
+	}
+	return r.data[start:end], nil
+}
+
+// fakeHFSCatalogEntry is one catalog item of the fake volume.
+type fakeHFSCatalogEntry struct {
+	cnid, parent uint32
+	name         string
+	folder       bool
+	mode         uint16
+	ownerFlags   byte
+	special      uint32
+	fileType     uint32
+	creator      uint32
+	data, rsrc   [80]byte
+}
+
+// hfsForkData encodes a ForkData of size bytes over exts ({start, count}).
+func hfsForkData(size uint64, exts ...[2]uint32) [80]byte {
+	var fd [80]byte
+	binary.BigEndian.PutUint64(fd[0:], size)
+	var total uint32
+	for i, e := range exts {
+		if i < 8 {
+			binary.BigEndian.PutUint32(fd[16+8*i:], e[0])
+			binary.BigEndian.PutUint32(fd[20+8*i:], e[1])
+		}
+		total += e[1]
+	}
+	binary.BigEndian.PutUint32(fd[12:], total)
+	return fd
+}
+
+func hfsUnits(s string) []uint16 { return utf16.Encode([]rune(s)) }
+
+func hfsCatalogKey(parent uint32, name string) []byte {
+	u := hfsUnits(name)
+	k := make([]byte, 8+2*len(u))
+	binary.BigEndian.PutUint16(k[0:], uint16(6+2*len(u)))
+	binary.BigEndian.PutUint32(k[2:], parent)
+	binary.BigEndian.PutUint16(k[6:], uint16(len(u)))
+	for i, c := range u {
+		binary.BigEndian.PutUint16(k[8+2*i:], c)
+	}
+	return k
+}
+
+// hfsNode lays out one B-tree node: descriptor, records, offset table.
+func hfsNode(kind int8, height byte, fLink uint32, records [][]byte) []byte {
+	n := make([]byte, fakeHFSBlock)
+	binary.BigEndian.PutUint32(n[0:], fLink)
+	n[8] = byte(kind)
+	n[9] = height
+	binary.BigEndian.PutUint16(n[10:], uint16(len(records)))
+	off := 14
+	for i, r := range records {
+		binary.BigEndian.PutUint16(n[len(n)-2*(i+1):], uint16(off))
+		off += copy(n[off:], r)
+	}
+	binary.BigEndian.PutUint16(n[len(n)-2*(len(records)+1):], uint16(off))
+	return n
+}
+
+// hfsHeaderNode builds node 0 of a B-tree.
+func hfsHeaderNode(depth uint16, root, firstLeaf, lastLeaf, total uint32, compare byte) []byte {
+	rec := make([]byte, 106)
+	binary.BigEndian.PutUint16(rec[0:], depth)
+	binary.BigEndian.PutUint32(rec[2:], root)
+	binary.BigEndian.PutUint32(rec[10:], firstLeaf)
+	binary.BigEndian.PutUint32(rec[14:], lastLeaf)
+	binary.BigEndian.PutUint16(rec[18:], fakeHFSBlock)
+	binary.BigEndian.PutUint32(rec[22:], total)
+	rec[37] = compare
+	binary.BigEndian.PutUint32(rec[38:], 6) // big keys, variable index keys
+	return hfsNode(1, 0, 0, [][]byte{rec})
+}
+
+// hfsFakeOrder mirrors Apple's catalog name order for the names used here:
+// code units on case-sensitive HFSX; on HFS+ ASCII and Georgian capitals
+// folded as in Apple's table (Georgian to U+10D0, not unicode.ToLower's
+// U+2D00), with NUL sorting last.
+func hfsFakeOrder(name string, binaryCompare bool) []uint16 {
+	u := hfsUnits(name)
+	if binaryCompare {
+		return u
+	}
+	for i, c := range u {
+		switch {
+		case c == 0:
+			u[i] = 0xFFFF
+		case c >= 'A' && c <= 'Z':
+			u[i] = c + 32
+		case c >= 0x10A0 && c <= 0x10C5:
+			u[i] = c + 0x30
+		}
+	}
+	return u
+}
+
+// buildFakeHFSImage builds an HFS+ (or case-sensitive HFSX) volume:
+//
+//	/hello.txt        data fork "hello, world\n" and a resource fork
+//	/frag.bin         nine single-block extents, the ninth in the extents-overflow file
+//	/comp.txt         decmpfs type 3 (zlib, inline in the attributes B-tree)
+//	/dir/link         file hard link to iNode100 in the private data folder
+//	/dir/sym          symlink to ../hello.txt
+//	/café.txt         stored decomposed (e + U+0301), as a Mac writes it
+//	/Ⴀ.txt            Georgian capital, which Apple's table folds to U+10D0
+//
+// The catalog is a two-level tree (one index node over two leaves) so lookups
+// exercise the index descent and the leaf chain.
+func buildFakeHFSImage(hfsx bool) (*memHFSReader, []byte, []byte) {
+	img := make([]byte, fakeHFSBlocks*fakeHFSBlock)
+	blk := func(n int) []byte { return img[n*fakeHFSBlock : (n+1)*fakeHFSBlock] }
+
+	frag := make([]byte, fakeHFSFragSize)
+	for i := range frag {
+		frag[i] = byte(i*7 + i/fakeHFSBlock)
+	}
+	var fragExts [][2]uint32
+	for i := 0; i < 9; i++ {
+		b := uint32(20 + 2*i)
+		copy(blk(int(b)), frag[i*fakeHFSBlock:])
+		fragExts = append(fragExts, [2]uint32{b, 1})
+	}
+	compressed := bytes.Repeat([]byte("compressed content\n"), 50)
+
+	copy(blk(10), "hello, world\n")
+	copy(blk(11), "resource fork\n")
+	copy(blk(12), "linked\n")
+	copy(blk(13), "../hello.txt")
+
+	const (
+		cnidHello, cnidFrag, cnidDir, cnidLink, cnidPriv, cnidINode, cnidSym, cnidComp, cnidCafe, cnidAn = 16, 17, 18, 19, 20, 21, 22, 23, 24, 25
+	)
+	entries := []fakeHFSCatalogEntry{
+		{cnid: 2, parent: 1, name: "Fixture HD", folder: true, mode: 0x41ED},
+		{cnid: cnidHello, parent: 2, name: "hello.txt", mode: 0x81A4,
+			data: hfsForkData(13, [2]uint32{10, 1}), rsrc: hfsForkData(14, [2]uint32{11, 1})},
+		{cnid: cnidFrag, parent: 2, name: "frag.bin", mode: 0x81A4, data: hfsForkData(fakeHFSFragSize, fragExts...)},
+		{cnid: cnidComp, parent: 2, name: "comp.txt", mode: 0x81A4, ownerFlags: 0x20},
+		{cnid: cnidDir, parent: 2, name: "dir", folder: true, mode: 0x41ED},
+		{cnid: cnidLink, parent: cnidDir, name: "link", fileType: 0x686C6E6B, creator: 0x6866732B, special: 100},
+		{cnid: cnidSym, parent: cnidDir, name: "sym", mode: 0xA1ED, data: hfsForkData(12, [2]uint32{13, 1})},
+		{cnid: cnidPriv, parent: 2, name: "\x00\x00\x00\x00HFS+ Private Data", folder: true},
+		{cnid: cnidINode, parent: cnidPriv, name: "iNode100", mode: 0x81A4, data: hfsForkData(7, [2]uint32{12, 1})},
+		{cnid: cnidCafe, parent: 2, name: "cafe\u0301.txt", mode: 0x81A4, data: hfsForkData(13, [2]uint32{10, 1})},
+		{cnid: cnidAn, parent: 2, name: "\u10A0.txt", mode: 0x81A4, data: hfsForkData(7, [2]uint32{12, 1})},
+	}
+
+	type rec struct {
+		parent uint32
+		order  []uint16
+		bytes  []byte
+	}
+	var recs []rec
+	for _, e := range entries {
+		body := make([]byte, 88)
+		kind := uint16(1)
+		if !e.folder {
+			body = make([]byte, 248)
+			kind = 2
+		}
+		binary.BigEndian.PutUint16(body[0:], kind)
+		binary.BigEndian.PutUint32(body[8:], e.cnid)
+		binary.BigEndian.PutUint32(body[16:], 3600+2082844800) // contentModDate
+		body[41] = e.ownerFlags
+		binary.BigEndian.PutUint16(body[42:], e.mode)
+		binary.BigEndian.PutUint32(body[44:], e.special)
+		if !e.folder {
+			binary.BigEndian.PutUint32(body[48:], e.fileType)
+			binary.BigEndian.PutUint32(body[52:], e.creator)
+			copy(body[88:], e.data[:])
+			copy(body[168:], e.rsrc[:])
+		}
+		recs = append(recs, rec{e.parent, hfsFakeOrder(e.name, hfsx), append(hfsCatalogKey(e.parent, e.name), body...)})
+
+		u := hfsUnits(e.name)
+		thread := make([]byte, 10+2*len(u))
+		binary.BigEndian.PutUint16(thread[0:], kind+2)
+		binary.BigEndian.PutUint32(thread[4:], e.parent)
+		binary.BigEndian.PutUint16(thread[8:], uint16(len(u)))
+		for i, c := range u {
+			binary.BigEndian.PutUint16(thread[10+2*i:], c)
+		}
+		recs = append(recs, rec{e.cnid, nil, append(hfsCatalogKey(e.cnid, ""), thread...)})
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].parent != recs[j].parent {
+			return recs[i].parent < recs[j].parent
+		}
+		a, b := recs[i].order, recs[j].order
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	var leaf1, leaf2 [][]byte
+	for i, r := range recs {
+		if i < len(recs)/2 {
+			leaf1 = append(leaf1, r.bytes)
+		} else {
+			leaf2 = append(leaf2, r.bytes)
+		}
+	}
+	indexRec := func(leafRec []byte, child uint32) []byte {
+		key := leafRec[:2+binary.BigEndian.Uint16(leafRec)]
+		return binary.BigEndian.AppendUint32(append([]byte(nil), key...), child)
+	}
+	compare := byte(0xCF)
+	if hfsx {
+		compare = 0xBC
+	}
+	// Catalog: blocks 3-6 = header, index (node 1), leaves (nodes 2 and 3).
+	copy(blk(3), hfsHeaderNode(2, 1, 2, 3, 4, compare))
+	copy(blk(4), hfsNode(0, 2, 0, [][]byte{indexRec(leaf1[0], 2), indexRec(leaf2[0], 3)}))
+	copy(blk(5), hfsNode(-1, 1, 3, leaf1))
+	copy(blk(6), hfsNode(-1, 1, 0, leaf2))
+
+	// Extents overflow: blocks 1-2; the ninth frag.bin extent.
+	ovKey := make([]byte, 12)
+	binary.BigEndian.PutUint16(ovKey[0:], 10)
+	binary.BigEndian.PutUint32(ovKey[4:], cnidFrag)
+	binary.BigEndian.PutUint32(ovKey[8:], 8)
+	ovExt := make([]byte, 64)
+	binary.BigEndian.PutUint32(ovExt[0:], fragExts[8][0])
+	binary.BigEndian.PutUint32(ovExt[4:], 1)
+	copy(blk(1), hfsHeaderNode(1, 1, 1, 1, 2, 0))
+	copy(blk(2), hfsNode(-1, 1, 0, [][]byte{append(ovKey, ovExt...)}))
+
+	// Attributes: blocks 7-8; com.apple.decmpfs of comp.txt, inline zlib.
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(compressed)
+	zw.Close()
+	payload := make([]byte, 16)
+	binary.LittleEndian.PutUint32(payload[0:], 0x636d7066) // 'fpmc'
+	binary.LittleEndian.PutUint32(payload[4:], 3)
+	binary.LittleEndian.PutUint64(payload[8:], uint64(len(compressed)))
+	payload = append(payload, z.Bytes()...)
+	name := hfsUnits("com.apple.decmpfs")
+	attrKey := make([]byte, 14+2*len(name))
+	binary.BigEndian.PutUint16(attrKey[0:], uint16(len(attrKey)-2))
+	binary.BigEndian.PutUint32(attrKey[4:], cnidComp)
+	binary.BigEndian.PutUint16(attrKey[12:], uint16(len(name)))
+	for i, c := range name {
+		binary.BigEndian.PutUint16(attrKey[14+2*i:], c)
+	}
+	attrRec := make([]byte, 16)
+	binary.BigEndian.PutUint32(attrRec[0:], 0x10)
+	binary.BigEndian.PutUint32(attrRec[12:], uint32(len(payload)))
+	attrRec = append(attrRec, payload...)
+	copy(blk(7), hfsHeaderNode(1, 1, 1, 1, 2, 0))
+	copy(blk(8), hfsNode(-1, 1, 0, [][]byte{append(attrKey, attrRec...)}))
+
+	vh := img[1024:1536]
+	if hfsx {
+		copy(vh, []byte{'H', 'X', 0, 5})
+	} else {
+		copy(vh, []byte{'H', '+', 0, 4})
+	}
+	binary.BigEndian.PutUint32(vh[40:], fakeHFSBlock)
+	binary.BigEndian.PutUint32(vh[44:], fakeHFSBlocks)
+	ext, cat, attr := hfsForkData(2*fakeHFSBlock, [2]uint32{1, 2}), hfsForkData(4*fakeHFSBlock, [2]uint32{3, 4}), hfsForkData(2*fakeHFSBlock, [2]uint32{7, 2})
+	copy(vh[192:], ext[:])
+	copy(vh[272:], cat[:])
+	copy(vh[352:], attr[:])
+	return &memHFSReader{data: img}, frag, compressed
+}
+
+func openFakeHFS(t *testing.T, hfsx bool) (*hfsplus.HFSPlus, []byte, []byte) {
+	t.Helper()
+	r, frag, compressed := buildFakeHFSImage(hfsx)
+	fs, err := filesystem.NewHandler(filesystem.FS_HFS, r, 0, uint64(len(r.data)))
+	if err != nil {
+		t.Fatalf("NewHandler(HFS+): %v", err)
+	}
+	return fs.(*hfsplus.HFSPlus), frag, compressed
+}
+
+// TestHFSPlusCatalog lists and reads every kind of catalog item through the
+// registered reader-based handler.
+func TestHFSPlusCatalog(t *testing.T) {
+	h, frag, compressed := openFakeHFS(t, false)
+	if got := h.GetVolumeLabel(); got != "Fixture HD" {
+		t.Errorf("GetVolumeLabel() = %q, want %q", got, "Fixture HD")
+	}
+	entries, err := h.ListDirectory("/")
+	if err != nil {
+		t.Fatalf("ListDirectory(/): %v", err)
+	}
+	var names []string
+	sizes := map[string]uint64{}
+	for _, e := range entries {
+		names = append(names, e.Name)
+		sizes[e.Name] = e.Size
+	}
+	want := "cafe\u0301.txt,comp.txt,dir,frag.bin,hello.txt,\u10A0.txt,\x00\x00\x00\x00HFS+ Private Data"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("root listing = %q, want %q", got, want)
+	}
+	if sizes["comp.txt"] != uint64(len(compressed)) || sizes["frag.bin"] != fakeHFSFragSize {
+		t.Errorf("sizes = %v, want comp.txt %d and frag.bin %d", sizes, len(compressed), fakeHFSFragSize)
+	}
+
+	for path, want := range map[string][]byte{
+		"/hello.txt":                  []byte("hello, world\n"),
+		"/HELLO.TXT":                  []byte("hello, world\n"),
+		"/hello.txt/..namedfork/rsrc": []byte("resource fork\n"),
+		"/frag.bin":                   frag,
+		"/comp.txt":                   compressed,
+		"/dir/link":                   []byte("linked\n"),
+		"/dir/sym":                    []byte("../hello.txt"),
+		"/dir/../dir/link":            []byte("linked\n"),
+		"/caf\u00e9.txt":              []byte("hello, world\n"),
+		"/CAFE\u0301.TXT":             []byte("hello, world\n"),
+		"/\u10D0.txt":                 []byte("linked\n"),
+		"/hel\u200Dlo.txt":            []byte("hello, world\n"),
+	} {
+		got, err := h.GetFile(path)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("GetFile(%q) = %q, %v; want %q", path, hfsQuote(got), err, hfsQuote(want))
+		}
+	}
+
+	dir, err := h.ListDirectory("/dir")
+	if err != nil || len(dir) != 2 || dir[0].Name != "link" || dir[0].Size != 7 || dir[1].Name != "sym" {
+		t.Fatalf("ListDirectory(/dir) = %+v, %v; want link (7 bytes) and sym", dir, err)
+	}
+	fi, err := h.GetFileByPath("/dir/sym")
+	if err != nil || fi.Mode != filesystem.ModeSymlink || fi.ModTime != 3600 {
+		t.Errorf("GetFileByPath(/dir/sym) = %+v, %v; want a symlink modified at 3600", fi, err)
+	}
+	if _, err := h.GetFile("/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("GetFile(/missing) = %v, want ErrNotFound", err)
+	}
+	if _, err := h.ListDirectory("/hello.txt"); !errors.Is(err, filesystem.ErrNotDirectory) {
+		t.Errorf("ListDirectory(/hello.txt) = %v, want ErrNotDirectory", err)
+	}
+	found, err := h.SearchFiles("/", func(fi filesystem.FileInfo) bool { return strings.HasSuffix(fi.Name, "link") })
+	if err != nil || len(found) != 1 || found[0].Path != "/dir/link" {
+		t.Errorf("SearchFiles(*link) = %+v, %v; want /dir/link", found, err)
+	}
+}
+
+// TestHFSXCaseSensitive pins that a binary-compare HFSX catalog is looked up
+// case-sensitively.
+func TestHFSXCaseSensitive(t *testing.T) {
+	h, _, _ := openFakeHFS(t, true)
+	if !h.IsHFSX() || !h.CaseSensitive() {
+		t.Fatalf("IsHFSX/CaseSensitive = %v/%v, want true/true", h.IsHFSX(), h.CaseSensitive())
+	}
+	if got, err := h.GetFile("/hello.txt"); err != nil || string(got) != "hello, world\n" {
+		t.Errorf("GetFile(/hello.txt) = %q, %v", got, err)
+	}
+	if _, err := h.GetFile("/HELLO.TXT"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("GetFile(/HELLO.TXT) on HFSX = %v, want ErrNotFound", err)
+	}
+	if got, err := h.GetFile("/dir/link"); err != nil || string(got) != "linked\n" {
+		t.Errorf("GetFile(/dir/link) = %q, %v", got, err)
+	}
+}
+
+// TestHFSPlusOpenFile streams a fragmented fork across the extents-overflow
+// boundary and opens a hard link by its CNID.
+func TestHFSPlusOpenFile(t *testing.T) {
+	h, frag, _ := openFakeHFS(t, false)
+	f, err := h.OpenFile("/frag.bin")
+	if err != nil {
+		t.Fatalf("OpenFile(/frag.bin): %v", err)
+	}
+	defer f.Close()
+	ra := f.(io.ReaderAt)
+	buf := make([]byte, 3*fakeHFSBlock)
+	off := int64(7*fakeHFSBlock - 10) // blocks 7, 8 (overflow) and the end
+	n, err := ra.ReadAt(buf, off)
+	if n != fakeHFSFragSize-int(off) || err != io.EOF || !bytes.Equal(buf[:n], frag[off:]) {
+		t.Errorf("ReadAt(%d) = %d, %v; want %d bytes of frag.bin and io.EOF", off, n, err, fakeHFSFragSize-int(off))
+	}
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, frag) {
+		t.Errorf("ReadAll(frag.bin) = %d bytes, %v; want %d", len(all), err, len(frag))
+	}
+
+	entries, _ := h.ListDirectory("/dir")
+	link, err := h.OpenInode(entries[0].Inode, 0)
+	if err != nil {
+		t.Fatalf("OpenInode(link CNID %d): %v", entries[0].Inode, err)
+	}
+	if got, _ := io.ReadAll(link); string(got) != "linked\n" {
+		t.Errorf("OpenInode(link) read %q, want %q", got, "linked\n")
+	}
+	rsrc, err := h.OpenFile("/hello.txt/..namedfork/rsrc")
+	if err != nil {
+		t.Fatalf("OpenFile(resource fork): %v", err)
+	}
+	if got, _ := io.ReadAll(rsrc); string(got) != "resource fork\n" {
+		t.Errorf("resource fork = %q", got)
+	}
+
+	for path, want := range map[string]error{
+		"/dir":      filesystem.ErrIsDirectory,
+		"/dir/sym":  filesystem.ErrUnsupported,
+		"/comp.txt": filesystem.ErrUnsupported,
+		"/nope":     filesystem.ErrNotFound,
+	} {
+		if _, err := h.OpenFile(path); !errors.Is(err, want) {
+			t.Errorf("OpenFile(%q) = %v, want %v", path, err, want)
+		}
+	}
+}
+
+// TestHFSPlusDecmpfsAbsurdSize pins that a decmpfs header declaring a size
+// no payload can produce is an error before anything is allocated.
+func TestHFSPlusDecmpfsAbsurdSize(t *testing.T) {
+	for _, size := range []uint64{230897446027264, 1 << 63, 1 << 20} {
+		r, _, _ := buildFakeHFSImage(false)
+		at := bytes.Index(r.data, []byte("fpmc"))
+		if at < 0 {
+			t.Fatal("decmpfs header not found in the fixture")
+		}
+		binary.LittleEndian.PutUint64(r.data[at+8:], size)
+		fs, err := filesystem.NewHandler(filesystem.FS_HFS, r, 0, uint64(len(r.data)))
+		if err != nil {
+			t.Fatalf("NewHandler(HFS+): %v", err)
+		}
+		if got, err := fs.(*hfsplus.HFSPlus).GetFile("/comp.txt"); err == nil {
+			t.Errorf("size %d: GetFile(/comp.txt) = %d bytes, want an error", size, len(got))
+		}
+	}
+}
+
+func hfsQuote(b []byte) string {
+	if len(b) > 32 {
+		return fmt.Sprintf("%q... (%d bytes)", b[:32], len(b))
+	}
+	return string(b)
+}
@@ -0,0 +1,292 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// mftBaseRefMask keeps the 48-bit record number of an MFT file reference,
+// dropping the 16-bit sequence number.
+const mftBaseRefMask = 0x0000FFFFFFFFFFFF
+
+// ntfsRecAttr is a parsed attribute together with the fixed-up MFT record it
+// was parsed from: the base record, or an extension record reached through the
+// base record's $ATTRIBUTE_LIST. Attribute offsets are relative to rec.
+type ntfsRecAttr struct {
+	ntfsAttr
+	rec []byte
+}
+
+// ntfsAttrListEntry is one entry of an $ATTRIBUTE_LIST value: the attribute
+// (type, id) and the MFT record that holds it.
+type ntfsAttrListEntry struct {
+	typ      uint32
+	startVCN uint64
+	record   uint64
+	id       uint16
+}
+
+// ntfsStream is a $DATA (or other attribute) stream assembled from its
+// attribute fragments. A
+// non-resident stream split across extension records carries the merged run
+// list of every fragment, in VCN order.
+type ntfsStream struct {
+	nonResident bool
+	resident    []byte    // inline value of a resident stream
+	runs        []ntfsRun // merged data runs of a non-resident stream
+	size        uint64    // real size in bytes
+	flags       uint16    // attribute flags of the first fragment
+	compUnit    uint8     // log2 compression unit of a compressed stream
+}
+
+// attrName decodes the UTF-16LE name of attribute a in rec ("" when unnamed).
+func attrName(rec []byte, a ntfsAttr) string {
+	if a.nameLen == 0 {
+		return ""
+	}
+	start := a.offset + a.nameOffset
+	units := make([]uint16, a.nameLen)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(rec[start+i*2 : start+i*2+2])
+	}
+	return string(utf16.Decode(units))
+}
+
+// withRecord pairs each attribute parsed from rec with the record bytes.
+func withRecord(rec []byte, attrs []ntfsAttr) []ntfsRecAttr {
+	out := make([]ntfsRecAttr, len(attrs))
+	for i := range attrs {
+		out[i] = ntfsRecAttr{ntfsAttr: attrs[i], rec: rec}
+	}
+	return out
+}
+
+// fileAttrs reads base MFT record num and returns all of the file's
+// attributes: the record's own, followed by those its $ATTRIBUTE_LIST places in
+// extension records.
+func (h *NTFSHandler) fileAttrs(num uint64) ([]ntfsRecAttr, error) {
+	rec, err := h.readRecord(num)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := h.parseAttrs(rec)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := h.extensionAttrs(num, rec, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return append(withRecord(rec, attrs), ext...), nil
+}
+
+// extensionAttrs follows the $ATTRIBUTE_LIST of base record num (rec, already
+// parsed into attrs) and returns the attributes it places in other MFT
+// records. It returns nil for a record without an attribute list. Every listed
+// attribute must be found in an extension record whose base reference points
+// back at num, in use unless the base record is deleted; a stale or missing
+// one is an error, because dropping it would silently truncate the file. The
+// attributes that could be followed are returned alongside that error so the
+// index can still name the file.
+func (h *NTFSHandler) extensionAttrs(num uint64, rec []byte, attrs []ntfsAttr) ([]ntfsRecAttr, error) {
+	var list *ntfsAttr
+	for i := range attrs {
+		if attrs[i].typ == attrAttributeList {
+			list = &attrs[i]
+			break
+		}
+	}
+	if list == nil {
+		return nil, nil
+	}
+	raw, err := h.attrListValue(rec, *list)
+	if err != nil {
+		return nil, fmt.Errorf("attribute list of record %d: %w", num, err)
+	}
+	entries, err := parseAttrList(raw)
+	if err != nil {
+		return nil, fmt.Errorf("attribute list of record %d: %w", num, err)
+	}
+
+	// A deleted file's extension records were freed along with it.
+	baseInUse := binary.LittleEndian.Uint16(rec[0x16:0x18])&mftRecordInUse != 0
+	type extRecord struct {
+		rec   []byte
+		attrs []ntfsAttr
+		err   error
+	}
+	extRecs := make(map[uint64]*extRecord)
+	seen := make(map[[2]uint64]bool)
+	var out []ntfsRecAttr
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, e := range entries {
+		if e.record == num {
+			continue // the base record's own attributes are parsed directly
+		}
+		key := [2]uint64{e.record, uint64(e.id)}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		x, ok := extRecs[e.record]
+		if !ok {
+			x = &extRecord{}
+			extRecs[e.record] = x
+			x.rec, x.err = h.readRecord(e.record)
+			switch {
+			case x.err != nil:
+			case binary.LittleEndian.Uint16(x.rec[0x16:0x18])&mftRecordInUse == 0 && baseInUse:
+				x.err = fmt.Errorf("not in use")
+			case binary.LittleEndian.Uint64(x.rec[0x20:0x28])&mftBaseRefMask != num:
+				x.err = fmt.Errorf("belongs to record %d", binary.LittleEndian.Uint64(x.rec[0x20:0x28])&mftBaseRefMask)
+			default:
+				x.attrs, x.err = h.parseAttrs(x.rec)
+			}
+			if x.err != nil {
+				fail(fmt.Errorf("extension record %d of record %d: %w", e.record, num, x.err))
+			}
+		}
+		if x.err != nil {
+			continue
+		}
+		found := false
+		for i := range x.attrs {
+			if x.attrs[i].typ == e.typ && x.attrs[i].id == e.id {
+				out = append(out, ntfsRecAttr{ntfsAttr: x.attrs[i], rec: x.rec})
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail(fmt.Errorf("attribute 0x%X (id %d) of record %d missing from extension record %d",
+				e.typ, e.id, num, e.record))
+		}
+	}
+	return out, firstErr
+}
+
+// attrListValue returns the value of an $ATTRIBUTE_LIST attribute, which is
+// resident in the base record or, for very long lists, non-resident.
+func (h *NTFSHandler) attrListValue(rec []byte, a ntfsAttr) ([]byte, error) {
+	if !a.nonResident {
+		return rec[a.valueOffset : a.valueOffset+int(a.valueLen)], nil
+	}
+	if a.realSize > ntfsMaxAttrListBytes {
+		return nil, fmt.Errorf("size %d exceeds limit %d", a.realSize, ntfsMaxAttrListBytes)
+	}
+	if a.realSize == 0 {
+		return []byte{}, nil
+	}
+	runs, err := h.parseRuns(rec[a.runDataOff:a.runDataEnd])
+	if err != nil {
+		return nil, err
+	}
+	return h.readNonResident(runs, a.realSize)
+}
+
+// parseAttrList parses an $ATTRIBUTE_LIST value into its entries. Each entry
+// is type (u32), entry length (u16), name length and offset (u8 each), start
+// VCN (u64), MFT reference (u64) and attribute id (u16).
+func parseAttrList(raw []byte) ([]ntfsAttrListEntry, error) {
+	var entries []ntfsAttrListEntry
+	for off := 0; off < len(raw); {
+		if off+0x1A > len(raw) {
+			return nil, fmt.Errorf("entry at %d truncated", off)
+		}
+		length := int(binary.LittleEndian.Uint16(raw[off+4 : off+6]))
+		if length < 0x1A || off+length > len(raw) {
+			return nil, fmt.Errorf("invalid entry length %d at %d", length, off)
+		}
+		entries = append(entries, ntfsAttrListEntry{
+			typ:      binary.LittleEndian.Uint32(raw[off : off+4]),
+			startVCN: binary.LittleEndian.Uint64(raw[off+8 : off+16]),
+			record:   binary.LittleEndian.Uint64(raw[off+16:off+24]) & mftBaseRefMask,
+			id:       binary.LittleEndian.Uint16(raw[off+24 : off+26]),
+		})
+		off += length
+	}
+	return entries, nil
+}
+
+// dataStream assembles the $DATA stream called name ("" for the unnamed
+// stream) from a file's attributes. It returns nil when the file has no such
+// stream.
+func (h *NTFSHandler) dataStream(attrs []ntfsRecAttr, name string) (*ntfsStream, error) {
+	return h.attrStream(attrs, attrData, name)
+}
+
+// attrStream assembles the stream of the attribute of type typ called name
+// from a file's attributes, nil when there is none. The fragments of a
+// non-resident stream are ordered by starting VCN and must tile the stream
+// without gaps or overlaps; the real size comes from the first fragment, the
+// only one that records it.
+func (h *NTFSHandler) attrStream(attrs []ntfsRecAttr, typ uint32, name string) (*ntfsStream, error) {
+	label := attrTypeName(typ)
+	var frags []ntfsRecAttr
+	for _, a := range attrs {
+		if a.typ == typ && attrName(a.rec, a.ntfsAttr) == name {
+			frags = append(frags, a)
+		}
+	}
+	if len(frags) == 0 {
+		return nil, nil
+	}
+	if !frags[0].nonResident {
+		if len(frags) > 1 {
+			return nil, fmt.Errorf("resident %s repeated in %d attributes", label, len(frags))
+		}
+		a := frags[0]
+		return &ntfsStream{resident: a.rec[a.valueOffset : a.valueOffset+int(a.valueLen)], size: uint64(a.valueLen), flags: a.flags}, nil
+	}
+	sort.SliceStable(frags, func(i, j int) bool { return frags[i].startVCN < frags[j].startVCN })
+	if frags[0].startVCN != 0 {
+		return nil, fmt.Errorf("%s first fragment starts at VCN %d", label, frags[0].startVCN)
+	}
+	s := &ntfsStream{nonResident: true, size: frags[0].realSize, flags: frags[0].flags, compUnit: frags[0].compUnit}
+	if s.size == 0 {
+		// Zero-length stream: the run list is legitimately empty.
+		return s, nil
+	}
+	var next uint64
+	for _, a := range frags {
+		if !a.nonResident {
+			return nil, fmt.Errorf("%s mixes resident and non-resident fragments", label)
+		}
+		if a.startVCN != next {
+			return nil, fmt.Errorf("%s fragment starts at VCN %d, want %d", label, a.startVCN, next)
+		}
+		runs, err := h.parseRuns(a.rec[a.runDataOff:a.runDataEnd])
+		if err != nil {
+			return nil, fmt.Errorf("data runs at VCN %d: %w", a.startVCN, err)
+		}
+		for _, r := range runs {
+			r.vcnStart += a.startVCN
+			s.runs = append(s.runs, r)
+			next = r.vcnStart + r.length
+		}
+		if next > ntfsMaxClusterBytes {
+			return nil, fmt.Errorf("data runs exceed %d clusters", ntfsMaxClusterBytes)
+		}
+	}
+	return s, nil
+}
+
+// attrTypeName names the attribute types read as streams, for errors.
+func attrTypeName(typ uint32) string {
+	switch typ {
+	case attrData:
+		return "$DATA"
+	case attrIndexAllocation:
+		return "$INDEX_ALLOCATION"
+	case attrBitmap:
+		return "$BITMAP"
+	}
+	return fmt.Sprintf("attribute %#x", typ)
+}
@@ -0,0 +1,401 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/compress"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// Windows Overlay Filter (WOF) files, as written by "compact /exe" and
+// CompactOS: the unnamed $DATA is sparse and sized to the uncompressed file,
+// a WOF reparse point names the algorithm, and the compressed chunks live in
+// the "WofCompressedData" named stream.
+const (
+	ioReparseTagWOF = 0x80000017
+	wofProviderFile = 2 // FILE_PROVIDER: the data is in the file itself
+	wofStreamName   = "WofCompressedData"
+
+	// ntfsMaxReparseBytes bounds a reparse point value (16 KiB by definition).
+	ntfsMaxReparseBytes = 16 * 1024
+	// ntfsMaxUnitBytes bounds one decoded compression unit (16 clusters of
+	// up to 64 KiB).
+	ntfsMaxUnitBytes = 1 << 20
+)
+
+// wofAlgorithms maps a FILE_PROVIDER_EXTERNAL_INFO_V1 algorithm to its chunk
+// size and chunk decoder.
+var wofAlgorithms = map[uint32]struct {
+	name   string
+	chunk  int64
+	decode func([]byte, int) ([]byte, error)
+}{
+	0: {"XPRESS4K", 4096, compress.XpressHuffman},
+	1: {"LZX", 32768, compress.LZX},
+	2: {"XPRESS8K", 8192, compress.XpressHuffman},
+	3: {"XPRESS16K", 16384, compress.XpressHuffman},
+}
+
+// fileReader is a file content reader: every reader OpenFile returns also
+// implements io.ReaderAt.
+type fileReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// decodedReader returns a reader over the decompressed content of the $DATA
+// stream data called name when it is LZNT1-compressed or, for the unnamed
+// stream, when a WOF reparse point holds the file's content; it returns nil
+// when data holds the content as stored. The unnamed stream of a symbolic
+// link reads as its target, and that of a placeholder whose content is not
+// on the volume is ErrPlaceholder. Encrypted streams and compression methods
+// other than LZNT1 are ErrUnsupported.
+func (h *NTFSHandler) decodedReader(attrs []ntfsRecAttr, data *ntfsStream, name string) (fileReader, error) {
+	if name == "" {
+		rp, err := h.reparseValue(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if len(rp) >= 8 {
+			switch tag := binary.LittleEndian.Uint32(rp); {
+			case tag == ioReparseTagWOF:
+				return h.openWOF(attrs, data, rp)
+			case isLinkTag(tag):
+				r, err := parseReparse(rp, "/")
+				if err != nil {
+					return nil, fmt.Errorf("$REPARSE_POINT: %w", err)
+				}
+				return &byteFileReader{Reader: bytes.NewReader([]byte(linkText(r)))}, nil
+			case placeholderKind(tag) != "" && !storedLocally(data):
+				return nil, fmt.Errorf("%s placeholder (reparse tag %#08x): %w", placeholderKind(tag), tag, filesystem.ErrPlaceholder)
+			}
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+	if data.flags&attrFlagEncrypted != 0 {
+		return nil, fmt.Errorf("EFS-encrypted $DATA: %w", filesystem.ErrUnsupported)
+	}
+	switch data.flags & attrFlagCompressionMask {
+	case 0:
+		return nil, nil
+	case attrFlagLZNT1:
+		if !data.nonResident || data.size == 0 {
+			return nil, nil
+		}
+		return h.openLZNT1(data)
+	default:
+		return nil, fmt.Errorf("$DATA compression method %d: %w", data.flags&attrFlagCompressionMask, filesystem.ErrUnsupported)
+	}
+}
+
+// reparseValue returns the value of a file's $REPARSE_POINT attribute, or nil
+// when it has none.
+func (h *NTFSHandler) reparseValue(attrs []ntfsRecAttr) ([]byte, error) {
+	for _, a := range attrs {
+		if a.typ != attrReparsePoint {
+			continue
+		}
+		if !a.nonResident {
+			return a.rec[a.valueOffset : a.valueOffset+int(a.valueLen)], nil
+		}
+		if a.realSize > ntfsMaxReparseBytes {
+			return nil, fmt.Errorf("$REPARSE_POINT of %d bytes (limit %d)", a.realSize, ntfsMaxReparseBytes)
+		}
+		runs, err := h.parseRuns(a.rec[a.runDataOff:a.runDataEnd])
+		if err != nil {
+			return nil, fmt.Errorf("$REPARSE_POINT data runs: %w", err)
+		}
+		return h.readNonResident(runs, a.realSize)
+	}
+	return nil, nil
+}
+
+// streamReader returns a reader over a $DATA stream's stored bytes.
+func (h *NTFSHandler) streamReader(s *ntfsStream) fileReader {
+	switch {
+	case s == nil:
+		// No stream: the content is empty.
+		return &byteFileReader{Reader: bytes.NewReader(nil)}
+	case !s.nonResident:
+		// Resident: data lives inline in the MFT record.
+		return &byteFileReader{Reader: bytes.NewReader(s.resident)}
+	case s.size == 0:
+		// Zero-length non-resident stream: the run list is legitimately empty.
+		return &byteFileReader{Reader: bytes.NewReader(nil)}
+	}
+	return &ntfsFileReader{h: h, runs: s.runs, size: int64(s.size)}
+}
+
+// openLZNT1 opens an LZNT1-compressed stream. The stream is cut into
+// compression units of 2^compUnit clusters. A unit whose clusters are all
+// allocated is stored raw and one with none allocated is zeros; otherwise its
+// allocated clusters, which must precede its sparse ones, hold the unit
+// compressed, and whatever the LZNT1 data does not cover is zeros.
+func (h *NTFSHandler) openLZNT1(s *ntfsStream) (fileReader, error) {
+	if s.compUnit == 0 || s.compUnit > 16 {
+		return nil, fmt.Errorf("compression unit of 2^%d clusters: %w", s.compUnit, filesystem.ErrUnsupported)
+	}
+	clusters := uint64(1) << s.compUnit
+	unit := clusters * h.clusterSize
+	if unit > ntfsMaxUnitBytes {
+		return nil, fmt.Errorf("compression unit of %d bytes (limit %d): %w", unit, ntfsMaxUnitBytes, filesystem.ErrUnsupported)
+	}
+	decode := func(idx int64) ([]byte, error) {
+		first := uint64(idx) * clusters
+		var stored []byte
+		allocated, sparse := uint64(0), false
+		for vcn := first; vcn < first+clusters; vcn++ {
+			run, ok := runAt(s.runs, vcn)
+			if !ok || run.lcnStart < 0 {
+				sparse = true
+				continue
+			}
+			if sparse {
+				return nil, fmt.Errorf("compression unit %d: VCN %d allocated after a sparse cluster", idx, vcn)
+			}
+			cl, err := h.readClustersAtLCN(run.lcnStart+int64(vcn-run.vcnStart), 1)
+			if err != nil {
+				return nil, err
+			}
+			stored = append(stored, cl...)
+			allocated++
+		}
+		switch allocated {
+		case clusters:
+			return stored, nil
+		case 0:
+			return make([]byte, unit), nil
+		}
+		out, err := compress.LZNT1(stored, int(unit))
+		if err != nil {
+			return nil, fmt.Errorf("compression unit %d: %w", idx, err)
+		}
+		return append(out, make([]byte, int(unit)-len(out))...), nil
+	}
+	return &unitReader{size: int64(s.size), unit: int64(unit), decode: decode, cached: -1}, nil
+}
+
+// openWOF opens a WOF-compressed file from its reparse point value rp. The
+// WofCompressedData stream starts with a table of the end offsets of all
+// chunks but the last, 4 bytes each (8 for files over 4 GiB) and relative to
+// the end of the table. A chunk stored at its full size is raw.
+func (h *NTFSHandler) openWOF(attrs []ntfsRecAttr, data *ntfsStream, rp []byte) (fileReader, error) {
+	if len(rp) < 8+16 || int(binary.LittleEndian.Uint16(rp[4:])) < 16 {
+		return nil, fmt.Errorf("WOF reparse point of %d bytes is truncated", len(rp))
+	}
+	v := rp[8:]
+	if ver := binary.LittleEndian.Uint32(v); ver != 1 {
+		return nil, fmt.Errorf("WOF version %d: %w", ver, filesystem.ErrUnsupported)
+	}
+	if p := binary.LittleEndian.Uint32(v[4:]); p != wofProviderFile {
+		return nil, fmt.Errorf("WOF provider %d (data outside the file): %w", p, filesystem.ErrUnsupported)
+	}
+	if ver := binary.LittleEndian.Uint32(v[8:]); ver != 1 {
+		return nil, fmt.Errorf("WOF file provider version %d: %w", ver, filesystem.ErrUnsupported)
+	}
+	alg, ok := wofAlgorithms[binary.LittleEndian.Uint32(v[12:])]
+	if !ok {
+		return nil, fmt.Errorf("WOF algorithm %d: %w", binary.LittleEndian.Uint32(v[12:]), filesystem.ErrUnsupported)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("WOF file has no unnamed $DATA to give its size")
+	}
+	cs, err := h.dataStream(attrs, wofStreamName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", wofStreamName, err)
+	}
+	if cs == nil {
+		return nil, fmt.Errorf("WOF file has no %s stream", wofStreamName)
+	}
+	size := int64(data.size)
+	if size == 0 {
+		return &byteFileReader{Reader: bytes.NewReader(nil)}, nil
+	}
+	chunks := (size + alg.chunk - 1) / alg.chunk
+	entry := int64(4)
+	if size > 0xFFFFFFFF {
+		entry = 8
+	}
+	table := (chunks - 1) * entry
+	stored := h.streamReader(cs)
+	body := int64(cs.size) - table
+	if body < 0 {
+		return nil, fmt.Errorf("%s of %d bytes is shorter than its %d-byte chunk table", wofStreamName, cs.size, table)
+	}
+	// end returns the end of chunk i relative to the end of the table.
+	end := func(i int64) (int64, error) {
+		if i == chunks-1 {
+			return body, nil
+		}
+		var b [8]byte
+		if err := readFullAt(stored, b[:entry], i*entry); err != nil {
+			return 0, fmt.Errorf("%s chunk table: %w", wofStreamName, err)
+		}
+		if entry == 4 {
+			return int64(binary.LittleEndian.Uint32(b[:])), nil
+		}
+		return int64(binary.LittleEndian.Uint64(b[:])), nil
+	}
+	decode := func(idx int64) ([]byte, error) {
+		n := min(alg.chunk, size-idx*alg.chunk)
+		var start int64
+		if idx > 0 {
+			var err error
+			if start, err = end(idx - 1); err != nil {
+				return nil, err
+			}
+		}
+		stop, err := end(idx)
+		if err != nil {
+			return nil, err
+		}
+		if start > stop || stop > body || stop-start > n {
+			return nil, fmt.Errorf("WOF chunk %d spans %d-%d of %d stored bytes for %d bytes", idx, start, stop, body, n)
+		}
+		buf := make([]byte, stop-start)
+		if err := readFullAt(stored, buf, table+start); err != nil {
+			return nil, fmt.Errorf("WOF chunk %d: %w", idx, err)
+		}
+		if int64(len(buf)) == n {
+			return buf, nil
+		}
+		out, err := alg.decode(buf, int(n))
+		if err != nil {
+			return nil, fmt.Errorf("WOF %s chunk %d: %w", alg.name, idx, err)
+		}
+		return out, nil
+	}
+	return &unitReader{size: size, unit: alg.chunk, decode: decode, cached: -1}, nil
+}
+
+// readFullAt fills p from r at off; a short read is an error.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("read %d bytes at %d: %w", len(p), off, err)
+}
+
+// unitReader is a lazy, seekable reader over a stream stored as separately
+// decoded units of a fixed size: LZNT1 compression units or WOF chunks. It
+// holds one decoded unit at a time, so memory is O(unit), not O(file). ReadAt
+// is safe for concurrent use: the cached unit is swapped under a mutex.
+// Read/Seek share a cursor and are not.
+type unitReader struct {
+	size   int64
+	unit   int64
+	decode func(idx int64) ([]byte, error)
+	pos    int64
+
+	mu     sync.Mutex
+	cached int64 // index of the unit held in data, -1 for none
+	data   []byte
+}
+
+// block returns the decoded unit idx.
+func (r *unitReader) block(idx int64) ([]byte, error) {
+	r.mu.Lock()
+	if r.cached == idx {
+		b := r.data
+		r.mu.Unlock()
+		return b, nil
+	}
+	r.mu.Unlock()
+	b, err := r.decode(idx)
+	if err != nil {
+		return nil, err
+	}
+	if want := min(r.unit, r.size-idx*r.unit); int64(len(b)) < want {
+		return nil, fmt.Errorf("unit %d decodes to %d bytes, want %d", idx, len(b), want)
+	}
+	r.mu.Lock()
+	r.cached, r.data = idx, b
+	r.mu.Unlock()
+	return b, nil
+}
+
+func (r *unitReader) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative read offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	atEOF := false
+	if want > r.size-off {
+		want = r.size - off
+		atEOF = true
+	}
+	n := 0
+	for int64(n) < want {
+		o := off + int64(n)
+		b, err := r.block(o / r.unit)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:want], b[o%r.unit:])
+	}
+	if atEOF {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *unitReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.readAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt; it is safe for concurrent use.
+func (r *unitReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.readAt(p, off)
+}
+
+// Seek implements io.Seeker. It shares the cursor with Read.
+func (r *unitReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative seek position %d", abs)
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+// Close releases the cached unit.
+func (r *unitReader) Close() error {
+	r.mu.Lock()
+	r.cached, r.data = -1, nil
+	r.mu.Unlock()
+	return nil
+}
+
+var _ io.ReadSeekCloser = (*unitReader)(nil)
@@ -0,0 +1,326 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/compress"
+	"github.com/laenix/ewfgo/internal/ewffixture"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsNamedNonResidentAttr appends a non-resident attribute with a name,
+// attribute flags and compression unit.
+func ntfsNamedNonResidentAttr(buf []byte, typ uint32, id uint16, name string, flags uint16, compUnit uint8, realSize uint64, runs []byte) []byte {
+	start := len(buf)
+	runOff := (0x40 + 2*len(name) + 7) &^ 7
+	total := (runOff + len(runs) + 7) &^ 7
+	a := make([]byte, total)
+	nle32(a, 0, typ)
+	nle32(a, 4, uint32(total))
+	a[8] = 1 // non-resident
+	a[9] = byte(len(name))
+	nle16(a, 10, 0x40)
+	nle16(a, 12, flags)
+	nle16(a, 14, id)
+	nle16(a, 0x20, uint16(runOff))
+	a[0x22] = compUnit
+	nle64(a, 0x28, realSize)
+	nle64(a, 0x30, realSize)
+	nle64(a, 0x38, realSize)
+	for i, c := range []byte(name) {
+		nle16(a, 0x40+2*i, uint16(c))
+	}
+	copy(a[runOff:], runs)
+	return append(buf[:start], a...)
+}
+
+// ntfsRunList encodes data runs of {length, lcn} clusters; lcn -1 is sparse.
+func ntfsRunList(runs ...[2]int64) []byte {
+	var out []byte
+	prev := int64(0)
+	for _, r := range runs {
+		length := leBytes(r[0], false)
+		if r[1] < 0 {
+			out = append(out, byte(len(length)))
+			out = append(out, length...)
+			continue
+		}
+		delta := leBytes(r[1]-prev, true)
+		prev = r[1]
+		out = append(out, byte(len(delta)<<4|len(length)))
+		out = append(out, length...)
+		out = append(out, delta...)
+	}
+	return append(out, 0)
+}
+
+// leBytes returns the shortest little-endian encoding of v.
+func leBytes(v int64, signed bool) []byte {
+	var b []byte
+	for {
+		b = append(b, byte(v))
+		v >>= 8
+		last := b[len(b)-1]
+		if (v == 0 && (!signed || last < 0x80)) || (signed && v == -1 && last >= 0x80) {
+			return b
+		}
+	}
+}
+
+// ntfsNoise returns n incompressible bytes.
+func ntfsNoise(n int, seed uint32) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		seed ^= seed << 13
+		seed ^= seed >> 17
+		seed ^= seed << 5
+		b[i] = byte(seed)
+	}
+	return b
+}
+
+// ntfsText returns n bytes of compressible text.
+func ntfsText(n int) []byte {
+	var b []byte
+	for i := 0; len(b) < n; i++ {
+		b = append(b, fmt.Sprintf("C:\\Windows\\System32\\drivers\\etc entry %d\r\n", i%97)...)
+	}
+	return b[:n]
+}
+
+// buildNTFSCompressedImage extends the base image with packed.bin as record
+// 20. attrs appends its attributes after $FILE_NAME, given the first free LCN,
+// and returns the clusters to append to the image from there.
+func buildNTFSCompressedImage(attrs func(body []byte, lcn int64) ([]byte, []byte)) []byte {
+	img := buildNTFSImage()
+	var clusters []byte
+	rec := ntfsBuildRecordRaw(20, false, func(body []byte) []byte {
+		body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+		body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, "packed.bin", false))
+		body, clusters = attrs(body, ntfsTestTotalCls)
+		return body
+	})
+	copy(img[ntfsTestMFTLCN*4096+20*ntfsDefaultRecordSize:], rec)
+	return append(img, clusters...)
+}
+
+// ntfsLZNT1Content lays out four 64 KiB compression units and a partial
+// fifth: compressed text, a hole, incompressible noise stored raw, text that
+// compresses into a single chunk and a compressed partial unit.
+func ntfsLZNT1Content() []byte {
+	var c []byte
+	c = append(c, ntfsText(65536)...)
+	c = append(c, make([]byte, 65536)...)
+	c = append(c, ntfsNoise(65536, 7)...)
+	c = append(c, ntfsText(3000)...)
+	c = append(c, make([]byte, 65536-3000)...)
+	return append(c, ntfsText(5000)...)
+}
+
+// lznt1Attrs stores content as an LZNT1-compressed $DATA with 16-cluster
+// compression units, choosing per unit what NTFS would: raw, sparse or
+// compressed followed by sparse clusters.
+func lznt1Attrs(content []byte, flags uint16) func([]byte, int64) ([]byte, []byte) {
+	return func(body []byte, lcn int64) ([]byte, []byte) {
+		var runs [][2]int64
+		var clusters []byte
+		for off := 0; off < len(content); off += 65536 {
+			unit := content[off:min(off+65536, len(content))]
+			if bytes.Count(unit, []byte{0}) == len(unit) {
+				runs = append(runs, [2]int64{16, -1})
+				continue
+			}
+			packed := ewffixture.LZNT1(unit)
+			used := (len(packed) + 4095) / 4096
+			if used >= 16 {
+				packed, used = unit, 16
+			}
+			runs = append(runs, [2]int64{int64(used), lcn + int64(len(clusters)/4096)})
+			if used < 16 {
+				runs = append(runs, [2]int64{int64(16 - used), -1})
+			}
+			clusters = append(clusters, packed...)
+			clusters = append(clusters, make([]byte, used*4096-len(packed))...)
+		}
+		body = ntfsNamedNonResidentAttr(body, attrData, 2, "", flags, 4, uint64(len(content)), ntfsRunList(runs...))
+		return body, clusters
+	}
+}
+
+// wofAttrs stores content as a WOF file of the given provider and
+// algorithm: a sparse unnamed $DATA, the WOF reparse point and the
+// WofCompressedData stream. Incompressible chunks are stored raw.
+func wofAttrs(content []byte, provider, alg uint32) func([]byte, int64) ([]byte, []byte) {
+	return func(body []byte, lcn int64) ([]byte, []byte) {
+		chunk := 4096
+		if a, ok := wofAlgorithms[alg]; ok {
+			chunk = int(a.chunk)
+		}
+		var table, data []byte
+		for off := 0; off < len(content); off += chunk {
+			c := content[off:min(off+chunk, len(content))]
+			var packed []byte
+			if alg == 1 {
+				packed = ewffixture.LZX(c, ewffixture.LZXVerbatim, ewffixture.LZXAligned)
+			} else {
+				packed = ewffixture.XpressHuffman(c)
+			}
+			if len(packed) >= len(c) {
+				packed = c
+			}
+			data = append(data, packed...)
+			if off+chunk < len(content) {
+				table = binary.LittleEndian.AppendUint32(table, uint32(len(data)))
+			}
+		}
+		stream := append(table, data...)
+		clusters := make([]byte, (len(stream)+4095)&^4095)
+		copy(clusters, stream)
+
+		rp := make([]byte, 24)
+		nle32(rp, 0, ioReparseTagWOF)
+		nle16(rp, 4, 16)
+		nle32(rp, 8, 1)
+		nle32(rp, 12, provider)
+		nle32(rp, 16, 1)
+		nle32(rp, 20, alg)
+		size := uint64(len(content))
+		body = ntfsNamedNonResidentAttr(body, attrData, 2, "", 0x8000, 0, size, ntfsRunList([2]int64{int64(size+4095) / 4096, -1}))
+		body = ntfsNamedNonResidentAttr(body, attrData, 3, wofStreamName, 0, 0, uint64(len(stream)), ntfsRunList([2]int64{int64(len(clusters) / 4096), lcn}))
+		body = ntfsResidentAttr(body, attrReparsePoint, 4, rp)
+		return body, clusters
+	}
+}
+
+// wofContent is three and a bit chunks of the algorithm, the second
+// incompressible so that it is stored raw.
+func wofContent(alg uint32) []byte {
+	chunk := int(wofAlgorithms[alg].chunk)
+	c := ntfsText(chunk)
+	c = append(c, ntfsNoise(chunk, alg+1)...)
+	return append(c, ntfsText(chunk+1234)...)
+}
+
+// checkNTFSStream reads name through GetFile and OpenFile, including a ReadAt
+// straddling the unit boundary at offset boundary and one running past the
+// end, against want.
+func checkNTFSStream(t *testing.T, h *NTFSHandler, name string, want []byte, boundary int) {
+	t.Helper()
+	got, err := h.GetFile(name)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("GetFile: %d bytes differ from the %d written", len(got), len(want))
+	}
+	f, err := h.OpenFile(name)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if got, err = io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("OpenFile ReadAll: %d bytes, %v", len(got), err)
+	}
+	ra := f.(io.ReaderAt)
+	buf := make([]byte, 100)
+	if n, err := ra.ReadAt(buf, int64(boundary-50)); err != nil || !bytes.Equal(buf[:n], want[boundary-50:boundary+50]) {
+		t.Errorf("ReadAt across %d: %d bytes, %v", boundary, n, err)
+	}
+	if n, err := ra.ReadAt(buf, int64(len(want)-40)); err != io.EOF || !bytes.Equal(buf[:n], want[len(want)-40:]) {
+		t.Errorf("ReadAt past the end: %d bytes, %v (want 40, io.EOF)", n, err)
+	}
+}
+
+// TestNTFSGetFileLZNT1: a compressed file's raw, sparse, compressed and
+// partial compression units read back decompressed through GetFile and the
+// streaming reader.
+func TestNTFSGetFileLZNT1(t *testing.T) {
+	content := ntfsLZNT1Content()
+	img := buildNTFSCompressedImage(lznt1Attrs(content, attrFlagLZNT1))
+	if stored := len(img) - ntfsTestTotalCls*4096; stored >= len(content)/2 {
+		t.Fatalf("fixture stores %d bytes for %d: units not compressed", stored, len(content))
+	}
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	checkNTFSStream(t, h, "/packed.bin", content, 2*65536)
+}
+
+// TestNTFSGetFileWOF: WOF XPRESS4K/8K/16K and LZX files, with a raw chunk
+// among the compressed ones, read back decompressed.
+func TestNTFSGetFileWOF(t *testing.T) {
+	for alg, a := range wofAlgorithms {
+		t.Run(a.name, func(t *testing.T) {
+			content := wofContent(alg)
+			img := buildNTFSCompressedImage(wofAttrs(content, wofProviderFile, alg))
+			h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+			if err != nil {
+				t.Fatalf("NewNTFSHandler: %v", err)
+			}
+			checkNTFSStream(t, h, "/packed.bin", content, int(a.chunk))
+		})
+	}
+}
+
+// TestNTFSCompressedErrors: a corrupt compression unit or WOF chunk is
+// ErrCorrupt for reads that touch it while the other units still read, and
+// encrypted streams and WOF files backed by a WIM are ErrUnsupported.
+func TestNTFSCompressedErrors(t *testing.T) {
+	content := ntfsLZNT1Content()
+	img := buildNTFSCompressedImage(lznt1Attrs(content, attrFlagLZNT1))
+	// A compressed chunk opening with a back-reference.
+	copy(img[ntfsTestTotalCls*4096:], []byte{0x02, 0xB0, 0x01, 0x00, 0x00})
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	if _, err := h.GetFile("/packed.bin"); !errors.Is(err, compress.ErrCorrupt) {
+		t.Errorf("GetFile with corrupt LZNT1 unit: %v, want ErrCorrupt", err)
+	}
+	f, err := h.OpenFile("/packed.bin")
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	buf := make([]byte, 100)
+	if _, err := f.(io.ReaderAt).ReadAt(buf, 10); !errors.Is(err, compress.ErrCorrupt) {
+		t.Errorf("ReadAt in corrupt unit: %v, want ErrCorrupt", err)
+	}
+	if _, err := f.(io.ReaderAt).ReadAt(buf, 2*65536); err != nil || !bytes.Equal(buf, content[2*65536:2*65536+100]) {
+		t.Errorf("ReadAt in intact unit: %v", err)
+	}
+
+	content = wofContent(0)
+	img = buildNTFSCompressedImage(wofAttrs(content, wofProviderFile, 0))
+	// Over-subscribe the first chunk's code: every symbol one bit long.
+	copy(img[ntfsTestTotalCls*4096+8:], bytes.Repeat([]byte{0x11}, 256))
+	h, err = NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	if _, err := h.GetFile("/packed.bin"); !errors.Is(err, compress.ErrCorrupt) {
+		t.Errorf("GetFile with corrupt XPRESS chunk: %v, want ErrCorrupt", err)
+	}
+
+	for name, attrs := range map[string]func([]byte, int64) ([]byte, []byte){
+		"encrypted":     lznt1Attrs(content, attrFlagEncrypted),
+		"WIM provider":  wofAttrs(content, 1, 0),
+		"WOF algorithm": wofAttrs(content, wofProviderFile, 9),
+	} {
+		h, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSCompressedImage(attrs)}, 0)
+		if err != nil {
+			t.Fatalf("NewNTFSHandler: %v", err)
+		}
+		if _, err := h.GetFile("/packed.bin"); !errors.Is(err, filesystem.ErrUnsupported) {
+			t.Errorf("%s: GetFile: %v, want ErrUnsupported", name, err)
+		}
+		if _, err := h.OpenFile("/packed.bin"); !errors.Is(err, filesystem.ErrUnsupported) {
+			t.Errorf("%s: OpenFile: %v, want ErrUnsupported", name, err)
+		}
+	}
+}
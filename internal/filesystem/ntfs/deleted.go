@@ -0,0 +1,296 @@
+package ntfs
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+const (
+	// ntfsBitmapRecord is the fixed MFT record number of $Bitmap.
+	ntfsBitmapRecord = 6
+	// ntfsOrphanRecord is the handle of the virtual $OrphanFiles directory,
+	// past any 48-bit MFT record number.
+	ntfsOrphanRecord = 1 << 48
+	// ntfsOrphanDir names the virtual root directory holding deleted files
+	// whose parent directory is gone or was reused.
+	ntfsOrphanDir = "$OrphanFiles"
+	// ntfsBitmapReadBytes bounds one read of the cluster bitmap.
+	ntfsBitmapReadBytes = 64 * 1024
+)
+
+// WithDeleted returns a view of the volume that also indexes MFT records
+// not in use: deleted files and directories, listed with Deleted set under
+// the directory their $FILE_NAME names. A deleted entry whose parent record
+// is missing, not a directory, or reused for another file (its sequence
+// number moved on), or whose deleted parents loop back to it without
+// reaching the root, is listed under the virtual /$OrphanFiles directory
+// instead. Reading a deleted file checks $Bitmap first: if any of its
+// clusters now belongs to another file the read fails with ErrReallocated
+// rather than returning someone else's data (see WithReallocated).
+func (h *NTFSHandler) WithDeleted() (filesystem.FileSystem, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	c := h.view()
+	c.includeDeleted = true
+	return c, nil
+}
+
+// WithReallocated returns a deleted-file view like WithDeleted's in which a
+// deleted file some of whose clusters now belong to another file still
+// reads: those clusters read as zeros, and Reallocated lists the byte ranges
+// they cover. A compression unit of an LZNT1 file that lost some of its
+// clusters no longer decodes and fails the read.
+func (h *NTFSHandler) WithReallocated() (filesystem.FileSystem, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	c := h.view()
+	c.includeDeleted, c.readReallocated = true, true
+	return c, nil
+}
+
+// Reallocated returns the byte ranges of the stream at path (a file, or
+// "file:name") whose clusters $Bitmap shows allocated to another file, in
+// file order. An in-use file has none.
+func (h *NTFSHandler) Reallocated(path string) ([]filesystem.ByteRange, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	rec, name, err := h.resolveStream(path)
+	if err != nil {
+		return nil, err
+	}
+	if e := h.fileIndex[rec]; e == nil || !e.deleted {
+		return nil, nil
+	}
+	attrs, err := h.fileAttrs(rec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if name != "" {
+		if name, err = streamName(attrs, name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	s, err := h.dataStream(attrs, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: $DATA %q: %w", path, name, err)
+	}
+	if s == nil || !s.nonResident {
+		return nil, nil
+	}
+	_, lost, err := h.blankReallocated(s.runs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var out []filesystem.ByteRange
+	for _, r := range lost {
+		start := r.vcnStart * h.clusterSize
+		if start >= s.size {
+			break
+		}
+		end := min((r.vcnStart+r.length)*h.clusterSize, s.size)
+		out = append(out, filesystem.ByteRange{Offset: int64(start), Length: int64(end - start)})
+	}
+	return out, nil
+}
+
+// placeDeleted adds the deleted records recs, already in fileIndex, to their
+// parents' children, re-parenting those whose parent is not theirs any more
+// to $OrphanFiles. A loop of deleted directories, each naming the next as
+// its parent, is broken at the first of them in recs, which moves to
+// $OrphanFiles and takes the rest of the loop with it.
+func (h *NTFSHandler) placeDeleted(recs []uint64) {
+	for _, rec := range recs {
+		e := h.fileIndex[rec]
+		for i := range e.names {
+			if !h.ownParent(&e.names[i]) {
+				e.names[i].parent = ntfsOrphanRecord
+			}
+		}
+	}
+	for _, rec := range recs {
+		if h.deletedLoop(rec) {
+			e := h.fileIndex[rec]
+			for i := range e.names {
+				e.names[i].parent = ntfsOrphanRecord
+			}
+		}
+	}
+	for _, rec := range recs {
+		e := h.fileIndex[rec]
+		seenParent := make(map[uint64]bool)
+		for i := range e.names {
+			fn := &e.names[i]
+			if seenParent[fn.parent] {
+				continue
+			}
+			seenParent[fn.parent] = true
+			h.children[fn.parent] = append(h.children[fn.parent], rec)
+		}
+	}
+	if len(h.children[ntfsOrphanRecord]) > 0 {
+		h.fileIndex[ntfsOrphanRecord] = &ntfsIndexEntry{
+			recNum: ntfsOrphanRecord,
+			isDir:  true,
+			names:  []ntfsFileName{{parent: ntfsRootRecord, name: ntfsOrphanDir, namespace: 1}},
+		}
+		h.children[ntfsRootRecord] = append(h.children[ntfsRootRecord], ntfsOrphanRecord)
+	}
+}
+
+// deletedLoop reports whether the chain of deleted parent directories above
+// the deleted record rec leads back to rec. A directory has one parent, so
+// the chain follows each record's first name.
+func (h *NTFSHandler) deletedLoop(rec uint64) bool {
+	seen := make(map[uint64]bool)
+	for cur := rec; !seen[cur]; {
+		seen[cur] = true
+		e := h.fileIndex[cur]
+		if e == nil || !e.deleted || len(e.names) == 0 {
+			return false
+		}
+		if cur = e.names[0].parent; cur == rec {
+			return true
+		}
+	}
+	return false
+}
+
+// ownParent reports whether the directory a deleted record's $FILE_NAME
+// references is still the one it was created in. NTFS bumps a record's
+// sequence number when it is freed, so a deleted parent is one ahead of the
+// reference its deleted children kept. A zero sequence is not checked.
+func (h *NTFSHandler) ownParent(fn *ntfsFileName) bool {
+	p, ok := h.fileIndex[fn.parent]
+	if !ok || !p.isDir {
+		return false
+	}
+	return fn.parentSeq == 0 || p.seq == fn.parentSeq || (p.deleted && p.seq == fn.parentSeq+1)
+}
+
+// reallocated reports whether any cluster of a deleted entry's data streams
+// is now allocated in $Bitmap. The result is cached on the entry.
+func (h *NTFSHandler) reallocated(e *ntfsIndexEntry) (bool, error) {
+	if e.realloc != 0 {
+		return e.realloc == 2, nil
+	}
+	attrs, err := h.fileAttrs(e.recNum)
+	if err != nil {
+		return false, err
+	}
+	realloc := false
+	for _, a := range attrs {
+		if a.typ != attrData || !a.nonResident || (a.startVCN == 0 && a.realSize == 0) {
+			continue
+		}
+		runs, err := h.parseRuns(a.rec[a.runDataOff:a.runDataEnd])
+		if err != nil {
+			return false, fmt.Errorf("data runs: %w", err)
+		}
+		for _, r := range runs {
+			if r.lcnStart < 0 {
+				continue
+			}
+			spans, err := h.allocatedSpans(r.lcnStart, r.length, 1)
+			if err != nil {
+				return false, err
+			}
+			if realloc = len(spans) > 0; realloc {
+				break
+			}
+		}
+		if realloc {
+			break
+		}
+	}
+	e.realloc = 1
+	if realloc {
+		e.realloc = 2
+	}
+	return realloc, nil
+}
+
+// blankReallocated splits runs around the clusters $Bitmap shows allocated
+// and turns those into sparse runs, which read as zeros. lost holds the
+// blanked runs.
+func (h *NTFSHandler) blankReallocated(runs []ntfsRun) (kept, lost []ntfsRun, err error) {
+	for _, r := range runs {
+		if r.lcnStart < 0 {
+			kept = append(kept, r)
+			continue
+		}
+		spans, err := h.allocatedSpans(r.lcnStart, r.length, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		pos := uint64(0)
+		for _, sp := range spans {
+			if off := sp.vcnStart; off > pos {
+				kept = append(kept, ntfsRun{vcnStart: r.vcnStart + pos, lcnStart: r.lcnStart + int64(pos), length: off - pos})
+			}
+			blank := ntfsRun{vcnStart: r.vcnStart + sp.vcnStart, lcnStart: -1, length: sp.length}
+			kept, lost = append(kept, blank), append(lost, blank)
+			pos = sp.vcnStart + sp.length
+		}
+		if pos < r.length {
+			kept = append(kept, ntfsRun{vcnStart: r.vcnStart + pos, lcnStart: r.lcnStart + int64(pos), length: r.length - pos})
+		}
+	}
+	return kept, lost, nil
+}
+
+// allocatedSpans returns the stretches of the count clusters from lcn that
+// $Bitmap marks allocated, one bit per cluster, least significant bit
+// first. Each span's vcnStart is its offset from lcn. A limit above 0 stops
+// after that many spans.
+func (h *NTFSHandler) allocatedSpans(lcn int64, count uint64, limit int) ([]ntfsRun, error) {
+	if h.bitmap == nil {
+		attrs, err := h.fileAttrs(ntfsBitmapRecord)
+		if err != nil {
+			return nil, fmt.Errorf("$Bitmap: %w", err)
+		}
+		s, err := h.dataStream(attrs, "")
+		if err != nil {
+			return nil, fmt.Errorf("$Bitmap: %w", err)
+		}
+		if s == nil {
+			return nil, fmt.Errorf("$Bitmap has no $DATA")
+		}
+		h.bitmap = h.streamReader(s)
+	}
+	var spans []ntfsRun
+	end := uint64(lcn) + count
+	for c := uint64(lcn); c < end; {
+		off := c / 8
+		buf := make([]byte, min((end+7)/8-off, ntfsBitmapReadBytes))
+		if err := readFullAt(h.bitmap, buf, int64(off)); err != nil {
+			return nil, fmt.Errorf("$Bitmap at cluster %d: %w", c, err)
+		}
+		for ; c < end && c/8 < off+uint64(len(buf)); c++ {
+			if buf[c/8-off]>>(c%8)&1 == 0 {
+				continue
+			}
+			rel := c - uint64(lcn)
+			if n := len(spans); n > 0 && spans[n-1].vcnStart+spans[n-1].length == rel {
+				spans[n-1].length++
+				continue
+			}
+			if limit > 0 && len(spans) == limit {
+				return spans, nil
+			}
+			spans = append(spans, ntfsRun{vcnStart: rel, lcnStart: int64(c), length: 1})
+		}
+	}
+	return spans, nil
+}
+
+var (
+	_ filesystem.DeletedOpener     = (*NTFSHandler)(nil)
+	_ filesystem.ReallocatedReader = (*NTFSHandler)(nil)
+)
@@ -0,0 +1,265 @@
+package ntfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsDeletedRecord marks a built MFT record not in use, with the sequence
+// number NTFS moved it to when it was freed.
+func ntfsDeletedRecord(rec []byte, seq uint16) []byte {
+	nle16(rec, 0x16, binary.LittleEndian.Uint16(rec[0x16:])&^mftRecordInUse)
+	nle16(rec, 0x10, seq)
+	return rec
+}
+
+// buildNTFSDeletedImage extends the base image with $Bitmap (record 6,
+// clusters 0-11 allocated) and four deleted records: gone.bin (20) whose
+// cluster 10 now holds big.bin, the directory olddir (21, sequence 3), its
+// file inner.bin (22) in the free cluster 12 whose parent reference carries
+// olddir's pre-deletion sequence 2, and orphan.txt (23) whose parent
+// reference names subdir with a stale sequence. It returns the image and
+// inner.bin's content.
+func buildNTFSDeletedImage() ([]byte, []byte) {
+	img := append(buildNTFSImage(), make([]byte, 4096)...)
+	mftOff := ntfsTestMFTLCN * 4096
+	inner := ntfsNoise(4096, 99)
+	copy(img[12*4096:], inner)
+	put := func(num int, rec []byte) { copy(img[mftOff+num*ntfsDefaultRecordSize:], rec) }
+	put(6, ntfsBuildRecord(6, "$Bitmap", 5, false, []byte{0xFF, 0x0F, 0, 0, 0, 0, 0, 0}, nil, 0, ""))
+	put(20, ntfsDeletedRecord(ntfsBuildRecord(20, "gone.bin", 5, false, nil, []byte{0x11, 0x01, 0x0A, 0x00}, 4096, ""), 2))
+	put(21, ntfsDeletedRecord(ntfsBuildRecord(21, "olddir", 5, true, nil, nil, 0, ""), 3))
+	put(22, ntfsDeletedRecord(ntfsBuildRecord(22, "inner.bin", 21|2<<48, false, nil, []byte{0x11, 0x01, 0x0C, 0x00}, 4096, ""), 2))
+	put(23, ntfsDeletedRecord(ntfsBuildRecord(23, "orphan.txt", 18|7<<48, false, []byte("orphaned"), nil, 0, ""), 2))
+	return img, inner
+}
+
+// TestNTFSDeletedFiles: deleted records stay hidden by default; a
+// WithDeleted view lists them under their parent or $OrphanFiles, marks
+// them, and reads those whose clusters are still free while refusing the
+// one whose cluster was reallocated.
+func TestNTFSDeletedFiles(t *testing.T) {
+	img, inner := buildNTFSDeletedImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	fs, err := h.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted: %v", err)
+	}
+	d := fs.(*NTFSHandler)
+
+	list := func(h *NTFSHandler, path string) map[string]filesystem.DirectoryEntry {
+		t.Helper()
+		entries, err := h.ListDirectory(path)
+		if err != nil {
+			t.Fatalf("ListDirectory(%s): %v", path, err)
+		}
+		out := map[string]filesystem.DirectoryEntry{}
+		for _, e := range entries {
+			out[e.Name] = e
+		}
+		return out
+	}
+	live := list(h, "/")
+	for _, name := range []string{"gone.bin", "olddir", ntfsOrphanDir} {
+		if _, ok := live[name]; ok {
+			t.Errorf("default listing has deleted %s", name)
+		}
+	}
+	if _, err := h.GetFile("/gone.bin"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("default GetFile(gone.bin) = %v, want ErrNotFound", err)
+	}
+
+	root := list(d, "/")
+	if e := root["gone.bin"]; !e.Deleted || !e.Reallocated {
+		t.Errorf("gone.bin = %+v, want deleted and reallocated", e)
+	}
+	if e := root["olddir"]; !e.Deleted || !e.IsDir {
+		t.Errorf("olddir = %+v, want a deleted directory", e)
+	}
+	if e := root[ntfsOrphanDir]; e.Deleted || !e.IsDir {
+		t.Errorf("%s = %+v, want a directory", ntfsOrphanDir, e)
+	}
+	if e := root["hello.txt"]; e.Deleted {
+		t.Errorf("hello.txt = %+v, want live", e)
+	}
+	if e := list(d, "/olddir")["inner.bin"]; !e.Deleted || e.Reallocated || e.Size != 4096 {
+		t.Errorf("olddir/inner.bin = %+v, want deleted, not reallocated", e)
+	}
+	if e, ok := list(d, "/"+ntfsOrphanDir)["orphan.txt"]; !ok || !e.Deleted {
+		t.Errorf("orphan.txt under %s = %+v, %v", ntfsOrphanDir, e, ok)
+	}
+	if _, ok := list(d, "/subdir")["orphan.txt"]; ok {
+		t.Error("orphan.txt listed under the reused parent subdir")
+	}
+
+	if got, err := d.GetFile("/olddir/inner.bin"); err != nil || !bytes.Equal(got, inner) {
+		t.Errorf("GetFile(olddir/inner.bin) = %d bytes, %v", len(got), err)
+	}
+	f, err := d.OpenFile("/olddir/inner.bin")
+	if err != nil {
+		t.Fatalf("OpenFile(olddir/inner.bin): %v", err)
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, inner) {
+		t.Errorf("streamed inner.bin = %d bytes, %v", len(got), err)
+	}
+	f.Close()
+	if got, err := d.GetFile("/" + ntfsOrphanDir + "/orphan.txt"); err != nil || string(got) != "orphaned" {
+		t.Errorf("GetFile(orphan.txt) = %q, %v", got, err)
+	}
+	if _, err := d.GetFile("/gone.bin"); !errors.Is(err, filesystem.ErrReallocated) {
+		t.Errorf("GetFile(gone.bin) = %v, want ErrReallocated", err)
+	}
+	if _, err := d.OpenInode(20, 0); !errors.Is(err, filesystem.ErrReallocated) {
+		t.Errorf("OpenInode(gone.bin) = %v, want ErrReallocated", err)
+	}
+	if got, err := d.GetFile("/hello.txt"); err != nil || string(got) != "hello world" {
+		t.Errorf("GetFile(hello.txt) = %q, %v", got, err)
+	}
+	if _, ok := list(h, "/")["gone.bin"]; ok {
+		t.Error("WithDeleted changed the handler it was called on")
+	}
+}
+
+// TestNTFSReallocatedPartly: a deleted file whose first cluster now belongs
+// to big.bin fails to read in a WithDeleted view, while a WithReallocated
+// view reads its free second cluster, the reallocated one as zeros, and
+// Reallocated reports the blanked range.
+func TestNTFSReallocatedPartly(t *testing.T) {
+	img, inner := buildNTFSDeletedImage()
+	mftOff := ntfsTestMFTLCN * 4096
+	copy(img[mftOff+20*ntfsDefaultRecordSize:], ntfsDeletedRecord(ntfsBuildRecord(20, "gone.bin", 5, false, nil, []byte{0x11, 0x02, 0x0B, 0x00}, 8192, ""), 2))
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	fs, err := h.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted: %v", err)
+	}
+	if _, err := fs.GetFile("/gone.bin"); !errors.Is(err, filesystem.ErrReallocated) {
+		t.Errorf("WithDeleted GetFile(gone.bin) = %v, want ErrReallocated", err)
+	}
+
+	fs, err = h.WithReallocated()
+	if err != nil {
+		t.Fatalf("WithReallocated: %v", err)
+	}
+	r := fs.(*NTFSHandler)
+	want := append(make([]byte, 4096), inner...)
+	if got, err := r.GetFile("/gone.bin"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("GetFile(gone.bin) = %d bytes, %v", len(got), err)
+	}
+	f, err := r.OpenInode(20, 0)
+	if err != nil {
+		t.Fatalf("OpenInode(gone.bin): %v", err)
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("streamed gone.bin = %d bytes, %v", len(got), err)
+	}
+	f.Close()
+	lost, err := r.Reallocated("/gone.bin")
+	if err != nil || len(lost) != 1 || lost[0] != (filesystem.ByteRange{Offset: 0, Length: 4096}) {
+		t.Errorf("Reallocated(gone.bin) = %v, %v", lost, err)
+	}
+	if lost, err := r.Reallocated("/olddir/inner.bin"); err != nil || len(lost) != 0 {
+		t.Errorf("Reallocated(inner.bin) = %v, %v", lost, err)
+	}
+	if lost, err := r.Reallocated("/big.bin"); err != nil || len(lost) != 0 {
+		t.Errorf("Reallocated(big.bin) = %v, %v, want none for an in-use file", lost, err)
+	}
+}
+
+// TestNTFSDeletedParentLoop: two deleted directories that name each other
+// as parent never reach the root; the first moves to $OrphanFiles with the
+// other and its file beneath it.
+func TestNTFSDeletedParentLoop(t *testing.T) {
+	img := buildNTFSImage()
+	mftOff := ntfsTestMFTLCN * 4096
+	put := func(num int, rec []byte) { copy(img[mftOff+num*ntfsDefaultRecordSize:], rec) }
+	put(6, ntfsBuildRecord(6, "$Bitmap", 5, false, []byte{0xFF, 0x0F, 0, 0, 0, 0, 0, 0}, nil, 0, ""))
+	put(20, ntfsDeletedRecord(ntfsBuildRecord(20, "loopa", 21|2<<48, true, nil, nil, 0, ""), 3))
+	put(21, ntfsDeletedRecord(ntfsBuildRecord(21, "loopb", 20|2<<48, true, nil, nil, 0, ""), 3))
+	put(22, ntfsDeletedRecord(ntfsBuildRecord(22, "looped.txt", 21|2<<48, false, []byte("in a loop"), nil, 0, ""), 2))
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	fs, err := h.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted: %v", err)
+	}
+	d := fs.(*NTFSHandler)
+
+	entries, err := d.ListDirectory("/" + ntfsOrphanDir)
+	if err != nil {
+		t.Fatalf("ListDirectory(%s): %v", ntfsOrphanDir, err)
+	}
+	if len(entries) != 1 || entries[0].Name != "loopa" || !entries[0].IsDir || !entries[0].Deleted {
+		t.Fatalf("%s = %+v, want the deleted directory loopa", ntfsOrphanDir, entries)
+	}
+	path := "/" + ntfsOrphanDir + "/loopa/loopb/looped.txt"
+	if got, err := d.GetFile(path); err != nil || string(got) != "in a loop" {
+		t.Errorf("GetFile(%s) = %q, %v", path, got, err)
+	}
+}
+
+// TestNTFSDeletedViewConcurrent uses a handler and views of it from
+// several goroutines at once; run under -race it checks that a view shares
+// no lazily built state with the handler it came from.
+func TestNTFSDeletedViewConcurrent(t *testing.T) {
+	img, inner := buildNTFSDeletedImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	fs, err := h.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted: %v", err)
+	}
+	d := fs.(*NTFSHandler)
+	if _, err := d.ListDirectory("/"); err != nil {
+		t.Fatalf("ListDirectory: %v", err)
+	}
+	fs, err = d.WithDeleted()
+	if err != nil {
+		t.Fatalf("WithDeleted of a view: %v", err)
+	}
+	d2 := fs.(*NTFSHandler)
+	fs, err = d.FollowLinks("C")
+	if err != nil {
+		t.Fatalf("FollowLinks of a view: %v", err)
+	}
+	d3 := fs.(*NTFSHandler)
+
+	done := make(chan error, 4)
+	for _, v := range []*NTFSHandler{d, d2, d3} {
+		go func() {
+			if _, err := v.GetFile("/gone.bin"); !errors.Is(err, filesystem.ErrReallocated) {
+				done <- err
+				return
+			}
+			got, err := v.GetFile("/olddir/inner.bin")
+			if err == nil && !bytes.Equal(got, inner) {
+				err = errors.New("inner.bin differs")
+			}
+			done <- err
+		}()
+	}
+	go func() {
+		_, err := h.ListDirectory("/")
+		done <- err
+	}()
+	for range 4 {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
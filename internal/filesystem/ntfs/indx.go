@@ -0,0 +1,345 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// i30Name is the name of a directory's file-name index attributes.
+const i30Name = "$I30"
+
+// Index node layout. Entry offsets in a node header are relative to the
+// header, which sits at 0x10 in an $INDEX_ROOT value and at 0x18 in an INDX
+// block.
+const (
+	indexRootNodeHeader  = 0x10
+	indexBlockNodeHeader = 0x18
+	indexEntryHeaderLen  = 0x10
+	indexEntryLast       = 0x0002
+	// fileNameKeyLen is the fixed part of a $FILE_NAME key, before its
+	// UTF-16 name.
+	fileNameKeyLen = 0x42
+	// ntfsMaxIndexBitmapBytes bounds the $I30 $BITMAP read: 2^27 index
+	// blocks, far beyond any directory.
+	ntfsMaxIndexBitmapBytes = 16 << 20
+	// ntfsMaxFileTime bounds the timestamps of a carved key (year ~2514).
+	ntfsMaxFileTime = 1 << 58
+)
+
+// IndexEntries parses the $I30 index of the directory at path: the entries
+// of $INDEX_ROOT and of every INDX block of $INDEX_ALLOCATION that $BITMAP
+// marks in use, then the $FILE_NAME keys left in the slack after each
+// node's used size and in blocks no longer in use. Live entries come first,
+// in index order, each checked against the MFT record it references; carved
+// entries follow, flagged Carved. A carved key must name this directory as
+// its parent and look like a $FILE_NAME throughout, and copies of a live or
+// earlier carved entry are dropped. A live block that fails its fixups or
+// holds a malformed entry is an error.
+func (h *NTFSHandler) IndexEntries(path string) ([]filesystem.IndexEntry, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	dir, err := h.resolveDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := h.fileIndex[dir]; !ok || !e.isDir {
+		return nil, fmt.Errorf("path is not a directory: %s: %w", path, filesystem.ErrNotDirectory)
+	}
+	attrs, err := h.fileAttrs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := indexRoot(attrs, i30Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if typ := binary.LittleEndian.Uint32(root[0:4]); typ != attrFileName {
+		return nil, fmt.Errorf("$I30 indexes attribute %#x, want $FILE_NAME", typ)
+	}
+	blockSize := int(binary.LittleEndian.Uint32(root[0x08:0x0C]))
+
+	x := &i30Scan{h: h, dir: dir, seen: map[string]bool{}}
+	if err := x.node(root, indexRootNodeHeader, -1, true); err != nil {
+		return nil, fmt.Errorf("$INDEX_ROOT: %w", err)
+	}
+	err = h.indexBlocks(attrs, i30Name, blockSize, func(blk []byte, i int, inUse bool) error {
+		// A block not in use is carved whole: its entries were all
+		// removed, or it never held any and is skipped.
+		if err := h.indexBlock(blk); err != nil {
+			return err
+		}
+		return x.node(blk, indexBlockNodeHeader, i, inUse)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(x.live, x.carved...), nil
+}
+
+// i30Scan collects the entries of one directory's $I30 index. seen holds
+// the raw bytes of every entry collected, to drop carved copies.
+type i30Scan struct {
+	h      *NTFSHandler
+	dir    uint64
+	live   []filesystem.IndexEntry
+	carved []filesystem.IndexEntry
+	seen   map[string]bool
+}
+
+// node walks the index node whose header is at hdr in buf, collecting its
+// entries up to the used size as live when live is set, and carving the
+// rest of the allocated node.
+func (x *i30Scan) node(buf []byte, hdr, block int, live bool) error {
+	start, used, end, err := indexNode(buf, hdr)
+	if err != nil {
+		return err
+	}
+	if !live {
+		x.carve(buf, start, start, end, block)
+		return nil
+	}
+	err = nodeEntries(buf, start, used, func(off int, entry, key []byte) error {
+		e, ok := fileNameKey(key)
+		if !ok {
+			return fmt.Errorf("entry at %d: malformed $FILE_NAME key", off)
+		}
+		e.FileRef = binary.LittleEndian.Uint64(entry)
+		e.Block, e.Offset = block, off
+		e.Mismatch = x.h.indexMismatch(e, x.dir)
+		x.seen[string(entry[:indexEntryHeaderLen+len(key)])] = true
+		x.live = append(x.live, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	x.carve(buf, start, used, end, block)
+	return nil
+}
+
+// carve scans buf[from:to] at 8-byte steps for $FILE_NAME keys naming this
+// directory as parent. An entry's header precedes its key; its file
+// reference is kept when the header lies inside the node's entries, which
+// start at lo.
+func (x *i30Scan) carve(buf []byte, lo, from, to, block int) {
+	for off := (from + 7) &^ 7; off+fileNameKeyLen <= to; {
+		e, ok := fileNameKey(buf[off:to])
+		if !ok || e.ParentRef&mftBaseRefMask != x.dir || !plausibleKey(e) {
+			off += 8
+			continue
+		}
+		end := off + fileNameKeyLen + 2*int(buf[off+0x40])
+		head := off
+		if off-indexEntryHeaderLen >= lo {
+			head = off - indexEntryHeaderLen
+			e.FileRef = binary.LittleEndian.Uint64(buf[head:])
+		}
+		if id := string(buf[head:end]); !x.seen[id] {
+			x.seen[id] = true
+			e.Block, e.Offset, e.Carved = block, head, true
+			x.carved = append(x.carved, e)
+		}
+		off = (end + 7) &^ 7
+	}
+}
+
+// indexRoot returns the value of the resident $INDEX_ROOT called name.
+func indexRoot(attrs []ntfsRecAttr, name string) ([]byte, error) {
+	for _, a := range attrs {
+		if a.typ != attrIndexRoot || a.nonResident || attrName(a.rec, a.ntfsAttr) != name {
+			continue
+		}
+		root := a.rec[a.valueOffset : a.valueOffset+int(a.valueLen)]
+		if len(root) < indexRootNodeHeader+0x10 {
+			return nil, fmt.Errorf("$INDEX_ROOT:%s too short (%d bytes)", name, len(root))
+		}
+		return root, nil
+	}
+	return nil, fmt.Errorf("no $INDEX_ROOT:%s: %w", name, filesystem.ErrNotFound)
+}
+
+// indexBlocks calls fn with each INDX block of the index called name, as
+// stored in its $INDEX_ALLOCATION (none when the index fits its root) up to
+// the end of its $BITMAP, and whether the bitmap marks it in use. A block that cannot be read,
+// or for which fn fails, is an error only when in use.
+func (h *NTFSHandler) indexBlocks(attrs []ntfsRecAttr, name string, blockSize int, fn func(blk []byte, i int, inUse bool) error) error {
+	alloc, err := h.attrStream(attrs, attrIndexAllocation, name)
+	if err != nil || alloc == nil {
+		return err
+	}
+	if blockSize < 512 || blockSize > 1<<16 || blockSize&(blockSize-1) != 0 {
+		return fmt.Errorf("index block size %d", blockSize)
+	}
+	bm, err := h.attrStream(attrs, attrBitmap, name)
+	if err != nil {
+		return err
+	}
+	if bm == nil {
+		return fmt.Errorf("$INDEX_ALLOCATION:%s without $BITMAP", name)
+	}
+	if bm.size > ntfsMaxIndexBitmapBytes {
+		return fmt.Errorf("$BITMAP:%s is %d bytes, exceeds %d", name, bm.size, ntfsMaxIndexBitmapBytes)
+	}
+	bitmap := make([]byte, bm.size)
+	br := h.streamReader(bm)
+	err = readFullAt(br, bitmap, 0)
+	br.Close()
+	if err != nil {
+		return fmt.Errorf("$BITMAP:%s: %w", name, err)
+	}
+	if alloc.nonResident {
+		var mapped uint64
+		if n := len(alloc.runs); n > 0 {
+			mapped = (alloc.runs[n-1].vcnStart + alloc.runs[n-1].length) * h.clusterSize
+		}
+		if alloc.size > mapped {
+			return fmt.Errorf("$INDEX_ALLOCATION:%s size %d exceeds the %d bytes its runs map", name, alloc.size, mapped)
+		}
+	}
+	// Blocks past the bitmap cannot be in use; stopping there also bounds
+	// the walk when the allocation size is corrupt.
+	blocks := min(alloc.size/uint64(blockSize), uint64(len(bitmap))*8)
+	r := h.streamReader(alloc)
+	defer r.Close()
+	blk := make([]byte, blockSize)
+	for i := 0; uint64(i) < blocks; i++ {
+		inUse := bitmap[i/8]&(1<<(i%8)) != 0
+		err := readFullAt(r, blk, int64(i)*int64(blockSize))
+		if err == nil {
+			err = fn(blk, i, inUse)
+		}
+		if err != nil && inUse {
+			return fmt.Errorf("index block %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// indexBlock checks an INDX block's signature and applies its fixups.
+func (h *NTFSHandler) indexBlock(blk []byte) error {
+	if m := string(blk[:4]); m != "INDX" {
+		return fmt.Errorf("signature %q", m)
+	}
+	return h.fixupRecord(blk)
+}
+
+// indexNode reads the node header at hdr in buf: where its entries start,
+// the end of the used entries and the end of the allocated node.
+func indexNode(buf []byte, hdr int) (start, used, end int, err error) {
+	le := binary.LittleEndian
+	start = hdr + int(le.Uint32(buf[hdr:]))
+	used = hdr + int(le.Uint32(buf[hdr+4:]))
+	end = hdr + int(le.Uint32(buf[hdr+8:]))
+	if end > len(buf) {
+		end = len(buf)
+	}
+	if start < hdr+0x10 || start > used || used > end {
+		return 0, 0, 0, fmt.Errorf("node header: entries %d, used %d, allocated %d", start, used, end)
+	}
+	return start, used, end, nil
+}
+
+// nodeEntries calls fn with the offset, bytes and key of each entry in
+// buf[start:used] before the terminating entry.
+func nodeEntries(buf []byte, start, used int, fn func(off int, entry, key []byte) error) error {
+	le := binary.LittleEndian
+	for off := start; ; {
+		if off+indexEntryHeaderLen > used {
+			return fmt.Errorf("entries at %d run past the used size %d", off, used)
+		}
+		eLen := int(le.Uint16(buf[off+0x08:]))
+		keyLen := int(le.Uint16(buf[off+0x0A:]))
+		flags := le.Uint16(buf[off+0x0C:])
+		if eLen < indexEntryHeaderLen || eLen%8 != 0 || off+eLen > used {
+			return fmt.Errorf("entry at %d: length %d", off, eLen)
+		}
+		if flags&indexEntryLast != 0 {
+			return nil
+		}
+		if indexEntryHeaderLen+keyLen > eLen {
+			return fmt.Errorf("entry at %d: key length %d", off, keyLen)
+		}
+		entry := buf[off : off+eLen]
+		if err := fn(off, entry, entry[indexEntryHeaderLen:indexEntryHeaderLen+keyLen]); err != nil {
+			return err
+		}
+		off += eLen
+	}
+}
+
+// fileNameKey decodes a $FILE_NAME key at the start of key; ok is false
+// when key is too short for the name it declares or the namespace is
+// unknown.
+func fileNameKey(key []byte) (filesystem.IndexEntry, bool) {
+	le := binary.LittleEndian
+	if len(key) < fileNameKeyLen {
+		return filesystem.IndexEntry{}, false
+	}
+	n := int(key[0x40])
+	if n == 0 || fileNameKeyLen+2*n > len(key) || key[0x41] > 3 {
+		return filesystem.IndexEntry{}, false
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = le.Uint16(key[fileNameKeyLen+2*i:])
+	}
+	return filesystem.IndexEntry{
+		Name:          string(utf16.Decode(units)),
+		Namespace:     key[0x41],
+		ParentRef:     le.Uint64(key[0x00:]),
+		CreateTime:    int64(le.Uint64(key[0x08:])),
+		ModTime:       int64(le.Uint64(key[0x10:])),
+		ChangeTime:    int64(le.Uint64(key[0x18:])),
+		AccessTime:    int64(le.Uint64(key[0x20:])),
+		AllocatedSize: le.Uint64(key[0x28:]),
+		Size:          le.Uint64(key[0x30:]),
+		Flags:         le.Uint32(key[0x38:]),
+	}, true
+}
+
+// plausibleKey rejects carved keys whose timestamps are out of range or
+// whose name holds characters no file name can: NUL, '/', or an unpaired
+// surrogate.
+func plausibleKey(e filesystem.IndexEntry) bool {
+	for _, t := range []int64{e.CreateTime, e.ModTime, e.ChangeTime, e.AccessTime} {
+		if t < 0 || t >= ntfsMaxFileTime {
+			return false
+		}
+	}
+	for _, r := range e.Name {
+		if r == 0 || r == '/' || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// indexMismatch cross-checks a live $I30 entry of directory dir against the
+// MFT: the record it references must be in use, with the same sequence
+// number and a $FILE_NAME of the same name under dir.
+func (h *NTFSHandler) indexMismatch(e filesystem.IndexEntry, dir uint64) string {
+	num, seq := e.FileRef&mftBaseRefMask, uint16(e.FileRef>>48)
+	f, ok := h.fileIndex[num]
+	if !ok || f.deleted {
+		return fmt.Sprintf("MFT record %d not in use", num)
+	}
+	if seq != 0 && seq != f.seq {
+		return fmt.Sprintf("MFT record %d has sequence %d, index %d", num, f.seq, seq)
+	}
+	for _, fn := range f.names {
+		if fn.parent == dir && fn.name == e.Name {
+			return ""
+		}
+	}
+	return fmt.Sprintf("MFT record %d has no name %q in this directory", num, e.Name)
+}
+
+var _ filesystem.IndexLister = (*NTFSHandler)(nil)
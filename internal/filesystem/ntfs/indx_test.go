@@ -0,0 +1,200 @@
+package ntfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsIndexEnd is the terminating entry of an index node.
+var ntfsIndexEnd = []byte{8: 0x10, 12: indexEntryLast, 15: 0}
+
+// ntfsIndexRootValue builds an $I30 $INDEX_ROOT value with 4 KiB index
+// blocks holding entries.
+func ntfsIndexRootValue(entries ...[]byte) []byte {
+	v := make([]byte, 0x20)
+	nle32(v, 0x00, attrFileName)
+	nle32(v, 0x04, 1) // collation: file name
+	nle32(v, 0x08, 4096)
+	v[0x0C] = 1
+	for _, e := range append(entries, ntfsIndexEnd) {
+		v = append(v, e...)
+	}
+	nle32(v, 0x10, 0x10)
+	nle32(v, 0x14, uint32(len(v)-0x10))
+	nle32(v, 0x18, uint32(len(v)-0x10))
+	return v
+}
+
+// ntfsIndxBlock builds a 4 KiB INDX block at vcn holding entries, with slack
+// written just past the used size.
+func ntfsIndxBlock(vcn uint64, entries [][]byte, slack []byte) []byte {
+	b := make([]byte, 4096)
+	copy(b, "INDX")
+	nle64(b, 0x10, vcn)
+	off := 0x40
+	for _, e := range append(entries, ntfsIndexEnd) {
+		off += copy(b[off:], e)
+	}
+	copy(b[off:], slack)
+	nle32(b, 0x18, 0x40-0x18)
+	nle32(b, 0x1C, uint32(off-0x18))
+	nle32(b, 0x20, 4096-0x18)
+	lfsFixup(b, 0x28)
+	return b
+}
+
+// ntfsTimedFileName is a $FILE_NAME key with its four timestamps set to ft.
+func ntfsTimedFileName(parent uint64, name string, ft int64) []byte {
+	fn := ntfsFileNameValue(parent, name, false)
+	for off := 0x08; off <= 0x20; off += 8 {
+		nle64(fn, off, uint64(ft))
+	}
+	nle64(fn, 0x30, 1234)
+	return fn
+}
+
+// ntfsIndexTestTime is the FILETIME of the carved entries, 2023-11-14.
+const ntfsIndexTestTime = 133444737000000000
+
+// buildNTFSIndexImage extends the base image with $I30 indexes. The root's
+// $INDEX_ROOT lists hello.txt, big.bin with a stale sequence number, subdir
+// and ghost.txt, whose record 22 is not in use. subdir keeps its entries in
+// three INDX blocks in clusters 12-14: block 0, in use, lists nested.txt and
+// holds in its slack gone.txt, a copy of the nested.txt entry and a key whose
+// timestamps are out of range; block 1, freed, still lists old.log; block 2
+// was never written.
+func buildNTFSIndexImage() []byte {
+	img := append(buildNTFSImage(), make([]byte, 3*4096)...)
+	mftOff := ntfsTestMFTLCN * 4096
+	put := func(num int, rec []byte) { copy(img[mftOff+num*ntfsDefaultRecordSize:], rec) }
+	dir := func(num uint64, name string, attrs func([]byte) []byte) []byte {
+		return ntfsBuildRecordRaw(num, true, func(body []byte) []byte {
+			body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+			body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, name, true))
+			return attrs(body)
+		})
+	}
+	put(5, dir(5, ".", func(body []byte) []byte {
+		return ntfsNamedResidentAttr(body, attrIndexRoot, 2, i30Name, ntfsIndexRootValue(
+			ntfsIndexEntryValue(16|1<<48, ntfsFileNameValue(5|1<<48, "hello.txt", false)),
+			ntfsIndexEntryValue(17|3<<48, ntfsFileNameValue(5|1<<48, "big.bin", false)),
+			ntfsIndexEntryValue(18|1<<48, ntfsFileNameValue(5|1<<48, "subdir", true)),
+			ntfsIndexEntryValue(22|1<<48, ntfsFileNameValue(5|1<<48, "ghost.txt", false)),
+		))
+	}))
+	put(18, dir(18, "subdir", func(body []byte) []byte {
+		body = ntfsNamedResidentAttr(body, attrIndexRoot, 2, i30Name, ntfsIndexRootValue())
+		body = ntfsNamedNonResidentAttr(body, attrIndexAllocation, 3, i30Name, 0, 0, 3*4096, ntfsRunList([2]int64{3, 12}))
+		return ntfsNamedResidentAttr(body, attrBitmap, 4, i30Name, []byte{0x01, 0, 0, 0, 0, 0, 0, 0})
+	}))
+
+	nested := ntfsIndexEntryValue(19|1<<48, ntfsFileNameValue(18|1<<48, "nested.txt", false))
+	var slack []byte
+	slack = append(slack, ntfsIndexEntryValue(23|2<<48, ntfsTimedFileName(18|1<<48, "gone.txt", ntfsIndexTestTime))...)
+	slack = append(slack, nested...)
+	slack = append(slack, ntfsIndexEntryValue(24|1<<48, ntfsTimedFileName(18|1<<48, "junk", -1))...)
+	copy(img[12*4096:], ntfsIndxBlock(0, [][]byte{nested}, slack))
+	copy(img[13*4096:], ntfsIndxBlock(1, [][]byte{ntfsIndexEntryValue(25|4<<48, ntfsTimedFileName(18|1<<48, "old.log", ntfsIndexTestTime))}, nil))
+	return img
+}
+
+// TestNTFSIndexEntries: live entries are listed from $INDEX_ROOT and the
+// in-use INDX block and cross-checked against the MFT; slack and the freed
+// block yield carved entries, without duplicates of live ones or keys that
+// fail the plausibility checks.
+func TestNTFSIndexEntries(t *testing.T) {
+	h, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSIndexImage()}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	type want struct {
+		name     string
+		ref      uint64
+		block    int
+		carved   bool
+		mismatch string
+	}
+	for path, wants := range map[string][]want{
+		"/": {
+			{"hello.txt", 16 | 1<<48, -1, false, ""},
+			{"big.bin", 17 | 3<<48, -1, false, "MFT record 17 has sequence 1, index 3"},
+			{"subdir", 18 | 1<<48, -1, false, ""},
+			{"ghost.txt", 22 | 1<<48, -1, false, "MFT record 22 not in use"},
+		},
+		"/subdir": {
+			{"nested.txt", 19 | 1<<48, 0, false, ""},
+			{"gone.txt", 23 | 2<<48, 0, true, ""},
+			{"old.log", 25 | 4<<48, 1, true, ""},
+		},
+	} {
+		got, err := h.IndexEntries(path)
+		if err != nil {
+			t.Fatalf("IndexEntries(%s): %v", path, err)
+		}
+		if len(got) != len(wants) {
+			t.Fatalf("IndexEntries(%s) = %+v, want %d entries", path, got, len(wants))
+		}
+		for i, w := range wants {
+			e := got[i]
+			if e.Name != w.name || e.FileRef != w.ref || e.Block != w.block || e.Carved != w.carved || e.Mismatch != w.mismatch {
+				t.Errorf("%s entry %d = %q ref %#x block %d carved %v %q, want %+v", path, i, e.Name, e.FileRef, e.Block, e.Carved, e.Mismatch, w)
+			}
+		}
+	}
+
+	sub, _ := h.IndexEntries("/subdir")
+	if g := sub[1]; g.ParentRef != 18|1<<48 || g.Size != 1234 || g.CreateTime != ntfsIndexTestTime || g.AccessTime != ntfsIndexTestTime || g.Offset%8 != 0 {
+		t.Errorf("gone.txt = %+v", g)
+	}
+
+	for path, want := range map[string]error{
+		"/hello.txt": filesystem.ErrNotDirectory,
+		"/missing":   filesystem.ErrNotFound,
+	} {
+		if _, err := h.IndexEntries(path); !errors.Is(err, want) {
+			t.Errorf("IndexEntries(%s) = %v, want %v", path, err, want)
+		}
+	}
+	base, err := newTestNTFSHandler()
+	if err != nil {
+		t.Fatalf("newTestNTFSHandler: %v", err)
+	}
+	if _, err := base.IndexEntries("/"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("IndexEntries without $INDEX_ROOT = %v, want ErrNotFound", err)
+	}
+
+	// Block 2 marked in use but never written is damage, not slack.
+	img := buildNTFSIndexImage()
+	bm := ntfsTestMFTLCN*4096 + 18*ntfsDefaultRecordSize
+	h, err = NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	rec, _ := h.readRecord(18)
+	attrs, _ := h.parseAttrs(rec)
+	for _, a := range attrs {
+		if a.typ == attrBitmap {
+			img[bm+a.valueOffset] = 0x05
+		}
+	}
+	if _, err := h.IndexEntries("/subdir"); err == nil {
+		t.Error("IndexEntries with an unwritten in-use block succeeded")
+	}
+
+	// A corrupt $INDEX_ALLOCATION size beyond the clusters its runs map is
+	// an error, not a walk over 2^48 blocks.
+	img = buildNTFSIndexImage()
+	for _, a := range attrs {
+		if a.typ == attrIndexAllocation {
+			nle64(img[bm:], a.offset+0x38, 1<<60)
+		}
+	}
+	if h, err = NewNTFSHandler(&memNTFSReader{data: img}, 0); err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	if _, err := h.IndexEntries("/subdir"); err == nil {
+		t.Error("IndexEntries with an $INDEX_ALLOCATION size past its runs succeeded")
+	}
+}
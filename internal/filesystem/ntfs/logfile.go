@@ -0,0 +1,446 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// $LogFile is the NTFS transaction log, written by the Log File Service
+// (LFS). It opens with two restart pages ("RSTR") whose restart area locates
+// the log, followed by log record pages ("RCRD") that form one circular byte
+// stream: each page's data area continues the previous page's, and a record
+// longer than the space left in a page carries on in the next. A record's
+// LSN encodes its byte offset in the file, which tells live record headers
+// apart from stale bytes and from the tail-copy pages at the front.
+const (
+	ntfsLogFileRecord = 2
+
+	// ntfsMaxLogFileBytes bounds the log parsed; Windows sizes it at a few
+	// tens of MiB and chkdsk /L caps it far below this.
+	ntfsMaxLogFileBytes = 1 << 30
+	// lfsMaxRecordBytes bounds one log record's client data.
+	lfsMaxRecordBytes = 1 << 20
+
+	lfsRecordClient  = 1      // LfsClientRecord; 2 is LfsClientRestart (a checkpoint)
+	lfsRestartClean  = 0x0002 // RESTART_AREA_CLEAN
+	lfsMinHeaderSize = 0x30
+	// ntfsLogHeaderBytes is the NTFS client's fixed header before its LCN
+	// array.
+	ntfsLogHeaderBytes = 0x20
+)
+
+// NTFS log operation codes that decode to a file.
+const (
+	logInitializeFileRecordSegment = 0x02
+	logCreateAttribute             = 0x05
+	logAddIndexEntryRoot           = 0x0C
+	logAddIndexEntryAllocation     = 0x0E
+)
+
+// logMFTOps are the operations whose target is an MFT record rather than an
+// index buffer or non-resident attribute data.
+var logMFTOps = map[uint16]bool{
+	0x02: true, // InitializeFileRecordSegment
+	0x03: true, // DeallocateFileRecordSegment
+	0x04: true, // WriteEndOfFileRecordSegment
+	0x05: true, // CreateAttribute
+	0x06: true, // DeleteAttribute
+	0x07: true, // UpdateResidentValue
+	0x09: true, // UpdateMappingPairs
+	0x0B: true, // SetNewAttributeSizes
+	0x0C: true, // AddIndexEntryRoot
+	0x0D: true, // DeleteIndexEntryRoot
+	0x11: true, // SetIndexEntryVcnRoot
+	0x13: true, // UpdateFileNameRoot
+	0x21: true, // UpdateRecordDataRoot
+	0x25: true, // ZeroEndOfFileRecord
+}
+
+// lfsRestart is the parsed restart area.
+type lfsRestart struct {
+	filesystem.LogFileRestart
+	seqBits   uint
+	logStart  int64 // first record page, past the two restart pages
+	headerLen int   // log record header length
+	dataOff   int   // offset of the data area in a record page
+}
+
+// lfsRecord is one client log record: its header and client data.
+type lfsRecord struct {
+	lsn  uint64
+	off  int64
+	data []byte
+}
+
+// OpenLogFile parses $LogFile: the newer valid restart area, then every
+// record page with its update-sequence fixups, reassembling records that span
+// pages. The client records are returned in LSN order, oldest first,
+// including those a wrapped log still holds from before the current restart.
+// Each event names the file it touches where the operation's data says (an
+// MFT record image, a $FILE_NAME attribute or an index entry) and is resolved
+// to a path like a USN record.
+func (h *NTFSHandler) OpenLogFile() (filesystem.LogFile, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	attrs, err := h.fileAttrs(ntfsLogFileRecord)
+	if err != nil {
+		return nil, fmt.Errorf("$LogFile: %w", err)
+	}
+	s, err := h.dataStream(attrs, "")
+	if err != nil {
+		return nil, fmt.Errorf("$LogFile: %w", err)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("$LogFile has no $DATA: %w", filesystem.ErrNotFound)
+	}
+	if s.size > ntfsMaxLogFileBytes {
+		return nil, fmt.Errorf("$LogFile too large: %d bytes (limit %d)", s.size, ntfsMaxLogFileBytes)
+	}
+	r := h.streamReader(s)
+	defer r.Close()
+	rs, err := h.logRestart(r, int64(s.size))
+	if err != nil {
+		return nil, fmt.Errorf("$LogFile: %w", err)
+	}
+	recs, err := h.logRecords(r, rs)
+	if err != nil {
+		return nil, fmt.Errorf("$LogFile: %w", err)
+	}
+	return &logFile{h: h, restart: rs, recs: recs}, nil
+}
+
+// logRestart reads both restart pages and returns the restart area with the
+// higher current LSN. Page 0 gives the system page size, which places the
+// second copy.
+func (h *NTFSHandler) logRestart(r fileReader, size int64) (*lfsRestart, error) {
+	var best *lfsRestart
+	var firstErr error
+	pageSize := int64(4096)
+	for i := 0; i < 2; i++ {
+		off := int64(i) * pageSize
+		rs, sys, err := h.parseRestartPage(r, off, size)
+		if i == 0 && sys != 0 {
+			pageSize = sys
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("restart page at %d: %w", off, err)
+			}
+			continue
+		}
+		if best == nil || rs.CurrentLSN > best.CurrentLSN {
+			best = rs
+		}
+	}
+	if best == nil {
+		return nil, firstErr
+	}
+	return best, nil
+}
+
+// parseRestartPage parses the restart page at off. It also returns the
+// system page size the page declares, 0 when unreadable.
+func (h *NTFSHandler) parseRestartPage(r fileReader, off, size int64) (*lfsRestart, int64, error) {
+	le := binary.LittleEndian
+	hdr := make([]byte, 0x20)
+	if err := readFullAt(r, hdr, off); err != nil {
+		return nil, 0, err
+	}
+	if m := string(hdr[:4]); m != "RSTR" {
+		return nil, 0, fmt.Errorf("signature %q", m)
+	}
+	sysPage, logPage := int64(le.Uint32(hdr[0x10:])), int64(le.Uint32(hdr[0x14:]))
+	if !lfsPageSize(sysPage) || !lfsPageSize(logPage) {
+		return nil, 0, fmt.Errorf("page sizes %d/%d", sysPage, logPage)
+	}
+	page := make([]byte, sysPage)
+	if err := readFullAt(r, page, off); err != nil {
+		return nil, sysPage, err
+	}
+	if err := h.fixupRecord(page); err != nil {
+		return nil, sysPage, err
+	}
+	ra := int(le.Uint16(page[0x18:]))
+	if ra < 0x1E || ra+0x30 > len(page) {
+		return nil, sysPage, fmt.Errorf("restart area offset %d", ra)
+	}
+	a := page[ra:]
+	rs := &lfsRestart{
+		LogFileRestart: filesystem.LogFileRestart{
+			MajorVersion: int(int16(le.Uint16(page[0x1C:]))),
+			MinorVersion: int(int16(le.Uint16(page[0x1A:]))),
+			CurrentLSN:   le.Uint64(a),
+			Clean:        le.Uint16(a[0x0E:])&lfsRestartClean != 0,
+			PageSize:     int(logPage),
+			FileSize:     int64(le.Uint64(a[0x18:])),
+		},
+		seqBits:   uint(le.Uint32(a[0x10:])),
+		logStart:  2 * sysPage,
+		headerLen: int(le.Uint16(a[0x24:])),
+		dataOff:   int(le.Uint16(a[0x26:])),
+	}
+	switch {
+	case rs.seqBits < 3 || rs.seqBits > 60:
+		return nil, sysPage, fmt.Errorf("sequence number bits %d", rs.seqBits)
+	case rs.headerLen < lfsMinHeaderSize || rs.dataOff < 0x28 || rs.dataOff+rs.headerLen > rs.PageSize:
+		return nil, sysPage, fmt.Errorf("record header %d bytes at page offset %d", rs.headerLen, rs.dataOff)
+	case rs.FileSize <= 0 || rs.FileSize > size:
+		return nil, sysPage, fmt.Errorf("log size %d in a %d-byte $LogFile", rs.FileSize, size)
+	}
+	return rs, sysPage, nil
+}
+
+// lfsPageSize reports whether n is a plausible LFS page size.
+func lfsPageSize(n int64) bool {
+	return n >= 512 && n <= 64*1024 && n&(n-1) == 0
+}
+
+// lsnOffset is the byte offset in $LogFile an LSN encodes: its low
+// 64-seqBits bits count 8-byte units.
+func (rs *lfsRestart) lsnOffset(lsn uint64) int64 {
+	return int64(lsn << rs.seqBits >> rs.seqBits << 3)
+}
+
+// logRecords walks the record pages after the restart pages in file order,
+// reassembling records that continue on the next page. A record header
+// counts only where its LSN says it is, so every 8-byte position of a page's
+// data area is tried: that finds the records after a continuation whose start
+// is lost and skips the tail-copy pages, whose records belong elsewhere. A
+// continuation page must be of the record's own pass over the log (its last
+// LSN within the record's reach), and a record straddling the wrap from the
+// last page to the first is not reassembled. The result is sorted by LSN.
+func (h *NTFSHandler) logRecords(r fileReader, rs *lfsRestart) ([]lfsRecord, error) {
+	le := binary.LittleEndian
+	ps := int64(rs.PageSize)
+	var recs []lfsRecord
+	seen := map[uint64]bool{}
+	page := make([]byte, ps)
+	var pending *lfsRecord
+	need := 0
+	for off := rs.logStart; off+ps <= rs.FileSize; off += ps {
+		if err := readFullAt(r, page, off); err != nil {
+			return nil, fmt.Errorf("page at %d: %w", off, err)
+		}
+		if string(page[:4]) != "RCRD" || h.fixupRecord(page) != nil {
+			pending = nil
+			continue
+		}
+		pos := rs.dataOff
+		if pending != nil {
+			last := le.Uint64(page[0x08:])
+			if last < pending.lsn || last-pending.lsn > uint64(len(pending.data)+need)/8+uint64(ps) {
+				pending = nil // a page of another pass over the log
+			} else {
+				take := min(need, int(ps)-pos)
+				pending.data = append(pending.data, page[pos:pos+take]...)
+				need -= take
+				pos += (take + 7) &^ 7
+				if need > 0 {
+					continue
+				}
+				if !seen[pending.lsn] {
+					seen[pending.lsn] = true
+					recs = append(recs, *pending)
+				}
+				pending = nil
+			}
+		}
+		for ; pos+rs.headerLen <= int(ps); pos += 8 {
+			hdr := page[pos:]
+			lsn := le.Uint64(hdr)
+			clen := int(le.Uint32(hdr[0x18:]))
+			typ := le.Uint32(hdr[0x20:])
+			if lsn == 0 || rs.lsnOffset(lsn) != off+int64(pos) || clen > lfsMaxRecordBytes || typ < 1 || typ > 2 {
+				continue
+			}
+			total := rs.headerLen + clen
+			rec := lfsRecord{lsn: lsn, off: off + int64(pos)}
+			if pos+total > int(ps) {
+				rec.data = append(make([]byte, 0, total), page[pos:]...)
+				pending, need = &rec, total-(int(ps)-pos)
+				break
+			}
+			rec.data = append([]byte(nil), page[pos:pos+total]...)
+			if !seen[lsn] {
+				seen[lsn] = true
+				recs = append(recs, rec)
+			}
+			pos += (total+7)&^7 - 8
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].lsn < recs[j].lsn })
+	return recs, nil
+}
+
+// logFile iterates the parsed records.
+type logFile struct {
+	h       *NTFSHandler
+	restart *lfsRestart
+	recs    []lfsRecord
+	next    int
+}
+
+// Restart returns the restart area the log was parsed from.
+func (l *logFile) Restart() filesystem.LogFileRestart {
+	return l.restart.LogFileRestart
+}
+
+// Next decodes the next client record; checkpoint (client restart) records
+// are skipped.
+func (l *logFile) Next() (filesystem.LogFileEvent, error) {
+	for l.next < len(l.recs) {
+		rec := l.recs[l.next]
+		l.next++
+		if binary.LittleEndian.Uint32(rec.data[0x20:]) != lfsRecordClient {
+			continue
+		}
+		ev, err := l.h.logEvent(rec, l.restart.headerLen)
+		if err != nil {
+			return filesystem.LogFileEvent{}, fmt.Errorf("log record LSN %d: %w", rec.lsn, err)
+		}
+		return ev, nil
+	}
+	return filesystem.LogFileEvent{}, io.EOF
+}
+
+// Close releases the parsed records.
+func (l *logFile) Close() error {
+	l.recs = nil
+	return nil
+}
+
+// logEvent decodes a client record's NTFS operation header and data.
+func (h *NTFSHandler) logEvent(rec lfsRecord, headerLen int) (filesystem.LogFileEvent, error) {
+	le := binary.LittleEndian
+	b := rec.data
+	ev := filesystem.LogFileEvent{
+		LSN:           rec.lsn,
+		PreviousLSN:   le.Uint64(b[0x08:]),
+		UndoNextLSN:   le.Uint64(b[0x10:]),
+		Offset:        rec.off,
+		TransactionID: le.Uint32(b[0x24:]),
+	}
+	cd := b[headerLen:]
+	if len(cd) < ntfsLogHeaderBytes {
+		return ev, fmt.Errorf("client data of %d bytes", len(cd))
+	}
+	ev.RedoOp, ev.UndoOp = le.Uint16(cd[0x00:]), le.Uint16(cd[0x02:])
+	ev.RecordOffset, ev.AttributeOffset = le.Uint16(cd[0x10:]), le.Uint16(cd[0x12:])
+	ev.ClusterBlockOffset = le.Uint16(cd[0x14:])
+	ev.TargetVCN = le.Uint64(cd[0x18:])
+	var err error
+	if ev.RedoData, err = logData(cd, le.Uint16(cd[0x04:]), le.Uint16(cd[0x06:])); err != nil {
+		return ev, fmt.Errorf("redo data: %w", err)
+	}
+	if ev.UndoData, err = logData(cd, le.Uint16(cd[0x08:]), le.Uint16(cd[0x0A:])); err != nil {
+		return ev, fmt.Errorf("undo data: %w", err)
+	}
+
+	hasFile := logMFTOps[ev.RedoOp] || logMFTOps[ev.UndoOp]
+	if hasFile {
+		pos := ev.TargetVCN*h.clusterSize + uint64(ev.ClusterBlockOffset)*512
+		ev.FileRef = pos / uint64(h.recordSize)
+	}
+	for _, d := range []struct {
+		op   uint16
+		data []byte
+	}{{ev.RedoOp, ev.RedoData}, {ev.UndoOp, ev.UndoData}} {
+		if ref, fn := h.logFileName(d.op, d.data, ev.FileRef); fn != nil {
+			if ref != 0 {
+				ev.FileRef = ref
+			}
+			ev.ParentRef = fn.parent | uint64(fn.parentSeq)<<48
+			ev.Name = fn.name
+			hasFile = true
+			break
+		}
+	}
+	if hasFile {
+		ev.Path = h.namedPath(ev.FileRef, ev.ParentRef, ev.Name)
+	}
+	return ev, nil
+}
+
+// logData returns the length bytes at off of a record's client data.
+func logData(cd []byte, off, length uint16) ([]byte, error) {
+	if length == 0 {
+		return nil, nil
+	}
+	if int(off)+int(length) > len(cd) {
+		return nil, fmt.Errorf("%d bytes at %d overrun %d bytes of client data", length, off, len(cd))
+	}
+	return cd[off : int(off)+int(length)], nil
+}
+
+// logFileName decodes the $FILE_NAME an operation's data carries: in an MFT
+// record image, a created attribute or an index entry. It also returns the
+// file's reference where the data gives it: an index entry's, or for a
+// record image the target record num with the image's sequence number. It
+// returns nil when the operation carries no name.
+func (h *NTFSHandler) logFileName(op uint16, data []byte, num uint64) (uint64, *ntfsFileName) {
+	le := binary.LittleEndian
+	switch op {
+	case logInitializeFileRecordSegment:
+		if len(data) < 0x30 || len(data) > h.recordSize {
+			return 0, nil
+		}
+		rec := make([]byte, h.recordSize)
+		copy(rec, data)
+		attrs, err := h.parseAttrs(rec)
+		if err != nil {
+			return 0, nil
+		}
+		var names []ntfsFileName
+		for _, a := range attrs {
+			if a.typ == attrFileName && !a.nonResident {
+				if fn, err := h.parseFileName(rec, a); err == nil {
+					names = append(names, *fn)
+				}
+			}
+		}
+		if len(names) == 0 {
+			return 0, nil
+		}
+		return num | uint64(le.Uint16(data[0x10:]))<<48, preferredAnyName(names)
+	case logCreateAttribute:
+		if len(data) < 0x18 || le.Uint32(data) != attrFileName || data[8] != 0 {
+			return 0, nil
+		}
+		a := ntfsAttr{valueOffset: int(le.Uint16(data[0x14:])), valueLen: le.Uint32(data[0x10:])}
+		if a.valueOffset+int(a.valueLen) > len(data) {
+			return 0, nil
+		}
+		fn, err := h.parseFileName(data, a)
+		if err != nil {
+			return 0, nil
+		}
+		return 0, fn
+	case logAddIndexEntryRoot, logAddIndexEntryAllocation:
+		// INDEX_ENTRY: file reference, entry and key lengths, then a
+		// $FILE_NAME key in a directory's $I30 index. Keys of other indexes
+		// ($SII, $O) fail the length check.
+		if len(data) < 0x10+0x42 {
+			return 0, nil
+		}
+		keyLen := int(le.Uint16(data[0x0A:]))
+		if 0x10+keyLen > len(data) || keyLen < 0x42 || keyLen != 0x42+2*int(data[0x10+0x40]) || data[0x10+0x41] > 3 {
+			return 0, nil
+		}
+		fn, err := h.parseFileName(data, ntfsAttr{valueOffset: 0x10, valueLen: uint32(keyLen)})
+		if err != nil {
+			return 0, nil
+		}
+		return le.Uint64(data), fn
+	}
+	return 0, nil
+}
+
+var _ filesystem.LogFileOpener = (*NTFSHandler)(nil)
@@ -0,0 +1,237 @@
+package ntfs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// lfsTestSeqBits gives the test log's LSNs 20 bits of 8-byte file offset.
+const lfsTestSeqBits = 44
+
+// lfsFixup writes a page's update-sequence array at usaOff, stashing each
+// 512-byte sector's tail.
+func lfsFixup(page []byte, usaOff int) {
+	count := len(page)/512 + 1
+	nle16(page, 0x04, uint16(usaOff))
+	nle16(page, 0x06, uint16(count))
+	nle16(page, usaOff, 7)
+	for i := 1; i < count; i++ {
+		copy(page[usaOff+2*i:], page[i*512-2:i*512])
+		nle16(page, i*512-2, 7)
+	}
+}
+
+// lfsWriter lays log records out across the data areas of 4 KiB record pages
+// the way LFS does: 8-byte aligned, a header never split, a record's tail
+// continuing after the next page's header.
+type lfsWriter struct {
+	pages [][]byte
+	page  int
+	pos   int
+	seq   uint64
+}
+
+// add appends a client record with NTFS client data cd and returns its LSN.
+func (w *lfsWriter) add(cd []byte, prev uint64, tx uint32) uint64 {
+	if w.pos+0x30 > 4096 {
+		w.page, w.pos = w.page+1, 0x40
+	}
+	lsn := w.seq<<(64-lfsTestSeqBits) | uint64(w.page*4096+w.pos)>>3
+	rec := make([]byte, 0x30, 0x30+len(cd))
+	nle64(rec, 0x00, lsn)
+	nle64(rec, 0x08, prev)
+	nle32(rec, 0x18, uint32(len(cd)))
+	nle32(rec, 0x20, lfsRecordClient)
+	nle32(rec, 0x24, tx)
+	rec = append(rec, cd...)
+	for {
+		nle64(w.pages[w.page], 0x08, lsn)
+		n := copy(w.pages[w.page][w.pos:], rec)
+		rec, w.pos = rec[n:], w.pos+n
+		if len(rec) == 0 {
+			break
+		}
+		w.page, w.pos = w.page+1, 0x40
+	}
+	w.pos = (w.pos + 7) &^ 7
+	return lsn
+}
+
+// ntfsLogOp builds NTFS client data for a redo/undo pair targeting MFT record
+// rec (1 KiB records in 4 KiB clusters), with one LCN to follow.
+func ntfsLogOp(redoOp, undoOp uint16, rec uint64, redo, undo []byte) []byte {
+	redoOff := 0x28
+	undoOff := redoOff + (len(redo)+7)&^7
+	cd := make([]byte, undoOff+len(undo))
+	nle16(cd, 0x00, redoOp)
+	nle16(cd, 0x02, undoOp)
+	nle16(cd, 0x04, uint16(redoOff))
+	nle16(cd, 0x06, uint16(len(redo)))
+	nle16(cd, 0x08, uint16(undoOff))
+	nle16(cd, 0x0A, uint16(len(undo)))
+	nle16(cd, 0x0E, 1)
+	nle16(cd, 0x14, uint16(rec*1024%4096/512))
+	nle64(cd, 0x18, rec*1024/4096)
+	copy(cd[redoOff:], redo)
+	copy(cd[undoOff:], undo)
+	return cd
+}
+
+// ntfsIndexEntryValue builds an $I30 INDEX_ENTRY for file ref named by the
+// $FILE_NAME key fn.
+func ntfsIndexEntryValue(ref uint64, fn []byte) []byte {
+	e := make([]byte, (0x10+len(fn)+7)&^7)
+	nle64(e, 0, ref)
+	nle16(e, 0x08, uint16(len(e)))
+	nle16(e, 0x0A, uint16(len(fn)))
+	copy(e[0x10:], fn)
+	return e
+}
+
+// buildNTFSLogFileImage extends the base image with an 8-page $LogFile in
+// clusters 12-19: restart pages 0 (current) and 1 (older), a tail copy of
+// page 4 at page 2, and records in pages 4-6. The current pass writes the
+// creation of new.txt (record 21) and its root index entry, the deletion of
+// subdir/nested.txt, a 5000-byte update spanning pages 4 and 5, the removal
+// of old.doc's index entry from subdir and a record whose redo data overruns
+// it; page 6 still holds a rename to renamed.txt from the previous pass.
+func buildNTFSLogFileImage() ([]byte, []uint64) {
+	img := append(buildNTFSImage(), make([]byte, 8*4096)...)
+	mftOff := ntfsTestMFTLCN * 4096
+	copy(img[mftOff+2*ntfsDefaultRecordSize:], ntfsBuildRecord(2, "$LogFile", 5, false, nil, ntfsRunList([2]int64{8, 12}), 8*4096, ""))
+
+	w := &lfsWriter{pages: make([][]byte, 8), page: 4, pos: 0x40, seq: 2}
+	for i := range w.pages {
+		w.pages[i] = make([]byte, 4096)
+	}
+	newRec := ntfsBuildRecord(21, "new.txt", 5, false, []byte("new"), nil, 0, "")
+	nle16(newRec, 0x10, 2)
+	nested := ntfsBuildRecord(19, "nested.txt", 18, false, []byte("nested content"), nil, 0, "")
+	var lsns []uint64
+	lsns = append(lsns, w.add(ntfsLogOp(0x02, 0x00, 21, newRec[:0x200], nil), 0, 1))
+	lsns = append(lsns, w.add(ntfsLogOp(0x0C, 0x0D, 5, ntfsIndexEntryValue(21|2<<48, ntfsFileNameValue(5|1<<48, "new.txt", false)), nil), lsns[0], 1))
+	lsns = append(lsns, w.add(ntfsLogOp(0x03, 0x02, 19, nil, nested[:0x200]), 0, 2))
+	lsns = append(lsns, w.add(ntfsLogOp(0x08, 0x08, 17, make([]byte, 5000), nil), 0, 3))
+	lsns = append(lsns, w.add(ntfsLogOp(0x0F, 0x0E, 0, nil, ntfsIndexEntryValue(22|3<<48, ntfsFileNameValue(18|1<<48, "old.doc", false))), 0, 4))
+	bad := ntfsLogOp(0x07, 0x07, 16, []byte("abcd"), nil)
+	nle16(bad, 0x06, 0x400)
+	lsns = append(lsns, w.add(bad, 0, 5))
+	if w.page != 5 {
+		panic("log records did not end on page 5")
+	}
+	w.page, w.pos, w.seq = 6, 0x40, 1
+	attr := ntfsResidentAttr(nil, attrFileName, 4, ntfsFileNameValue(5|1<<48, "renamed.txt", false))
+	old := w.add(ntfsLogOp(0x05, 0x06, 16, attr, nil), 0, 9)
+	lsns = append([]uint64{old}, lsns...)
+
+	for _, i := range []int{4, 5, 6} {
+		copy(w.pages[i], "RCRD")
+		lfsFixup(w.pages[i], 0x28)
+	}
+	copy(w.pages[2], w.pages[4])
+	for i, lsn := range []uint64{lsns[len(lsns)-1], lsns[1]} {
+		p := w.pages[i]
+		copy(p, "RSTR")
+		nle32(p, 0x10, 4096)
+		nle32(p, 0x14, 4096)
+		nle16(p, 0x18, 0x30)
+		nle16(p, 0x1A, 1)
+		nle16(p, 0x1C, 1)
+		a := p[0x30:]
+		nle64(a, 0x00, lsn)
+		if i == 0 {
+			nle16(a, 0x0E, lfsRestartClean)
+		}
+		nle32(a, 0x10, lfsTestSeqBits)
+		nle64(a, 0x18, 8*4096)
+		nle16(a, 0x24, 0x30)
+		nle16(a, 0x26, 0x40)
+		lfsFixup(p, 0x1E)
+	}
+	for i, p := range w.pages {
+		copy(img[(12+i)*4096:], p)
+	}
+	return img, lsns
+}
+
+// TestNTFSLogFile: the log parses from the newer restart area, skips the
+// tail copy, reassembles the record spanning two pages, orders the previous
+// pass's record first, decodes file references and names from record
+// images, index entries and $FILE_NAME attributes, and reports the record
+// with overrunning redo data without stopping.
+func TestNTFSLogFile(t *testing.T) {
+	img, lsns := buildNTFSLogFileImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	l, err := h.OpenLogFile()
+	if err != nil {
+		t.Fatalf("OpenLogFile: %v", err)
+	}
+	defer l.Close()
+	if rs := l.Restart(); rs.CurrentLSN != lsns[len(lsns)-1] || !rs.Clean || rs.MajorVersion != 1 || rs.MinorVersion != 1 || rs.PageSize != 4096 {
+		t.Errorf("Restart = %+v", rs)
+	}
+
+	var evs []filesystem.LogFileEvent
+	var errs []error
+	for {
+		ev, err := l.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "redo data") {
+		t.Errorf("errors = %v, want the overrunning record's", errs)
+	}
+
+	want := []struct {
+		redo, undo uint16
+		file       uint64
+		name, path string
+	}{
+		{0x05, 0x06, 16, "renamed.txt", "/renamed.txt"},
+		{0x02, 0x00, 21 | 2<<48, "new.txt", "/new.txt"},
+		{0x0C, 0x0D, 21 | 2<<48, "new.txt", "/new.txt"},
+		{0x03, 0x02, 19 | 1<<48, "nested.txt", "/subdir/nested.txt"},
+		{0x08, 0x08, 0, "", ""},
+		{0x0F, 0x0E, 22 | 3<<48, "old.doc", "/subdir/old.doc"},
+	}
+	if len(evs) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(evs), evs, len(want))
+	}
+	for i, w := range want {
+		ev := evs[i]
+		if ev.LSN != lsns[i] || ev.RedoOp != w.redo || ev.UndoOp != w.undo || ev.FileRef != w.file || ev.Name != w.name || ev.Path != w.path {
+			t.Errorf("event %d = LSN %d %#x/%#x file %#x %q %q, want LSN %d %#x/%#x file %#x %q %q", i,
+				ev.LSN, ev.RedoOp, ev.UndoOp, ev.FileRef, ev.Name, ev.Path, lsns[i], w.redo, w.undo, w.file, w.name, w.path)
+		}
+	}
+	if ev := evs[1]; ev.TransactionID != 1 || ev.ParentRef != 5 || len(ev.RedoData) != 0x200 {
+		t.Errorf("new.txt event = tx %d parent %#x, %d redo bytes", ev.TransactionID, ev.ParentRef, len(ev.RedoData))
+	}
+	if ev := evs[2]; ev.PreviousLSN != lsns[1] || ev.ParentRef != 5|1<<48 || ev.TargetVCN != 1 || ev.ClusterBlockOffset != 2 {
+		t.Errorf("root index event = prev %d parent %#x VCN %d block %d", ev.PreviousLSN, ev.ParentRef, ev.TargetVCN, ev.ClusterBlockOffset)
+	}
+	if ev := evs[4]; len(ev.RedoData) != 5000 || ev.Offset/4096 != 4 {
+		t.Errorf("spanning record at %d with %d redo bytes", ev.Offset, len(ev.RedoData))
+	}
+
+	base, err := newTestNTFSHandler()
+	if err != nil {
+		t.Fatalf("newTestNTFSHandler: %v", err)
+	}
+	if _, err := base.OpenLogFile(); err == nil || errors.Is(err, filesystem.ErrUnsupported) {
+		t.Errorf("OpenLogFile without $LogFile = %v, want an error", err)
+	}
+}
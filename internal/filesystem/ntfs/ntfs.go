@@ -3,6 +3,7 @@ package ntfs
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"unicode/utf16"
@@ -11,17 +12,30 @@ import (
 )
 
 // NTFS attribute type constants. Only the attributes this parser walks are
-// defined; an $ATTRIBUTE_LIST (0x20) on a record whose data lives in external
-// records is detected via attrAttributeList (see GetFile).
+// defined; an $ATTRIBUTE_LIST (0x20) is followed into the extension records it
+// names (see fileAttrs).
 const (
 	attrStandardInformation = 0x10
 	attrAttributeList       = 0x20
 	attrFileName            = 0x30
+	attrSecurityDescriptor  = 0x50
 	attrVolumeName          = 0x60
 	attrData                = 0x80
+	attrIndexRoot           = 0x90
+	attrIndexAllocation     = 0xA0
+	attrBitmap              = 0xB0
+	attrReparsePoint        = 0xC0
 	attrEnd                 = 0xFFFFFFFF
 )
 
+// Attribute header flags (offset 0x0C). The low byte is the compression
+// method; 1 is LZNT1, the only one NTFS implements.
+const (
+	attrFlagCompressionMask = 0x00FF
+	attrFlagLZNT1           = 0x0001
+	attrFlagEncrypted       = 0x4000
+)
+
 // MFT record header flags.
 const (
 	mftRecordInUse = 0x0001
@@ -50,6 +64,9 @@ const (
 	ntfsMaxSearchCount = 100000
 	// ntfsMaxPathDepth bounds parent-chain walking (cycle guard).
 	ntfsMaxPathDepth = 64
+	// ntfsMaxAttrListBytes bounds a non-resident $ATTRIBUTE_LIST read. Even a
+	// heavily fragmented multi-GiB file needs only a few hundred KiB of list.
+	ntfsMaxAttrListBytes = 16 << 20
 )
 
 // ntfsRun is a single NTFS data-run: a contiguous VCN -> LCN mapping.
@@ -67,6 +84,8 @@ type ntfsAttr struct {
 	nonResident bool
 	nameLen     int
 	nameOffset  int
+	id          uint16
+	flags       uint16
 	// Resident value location (absolute offsets within the record).
 	valueOffset int
 	valueLen    uint32
@@ -74,11 +93,18 @@ type ntfsAttr struct {
 	runDataOff int
 	runDataEnd int
 	realSize   uint64
+	// startVCN is the first VCN a non-resident fragment maps; non-zero only for
+	// the later fragments of a stream split across extension records.
+	startVCN uint64
+	// compUnit is log2 of a compressed attribute's compression unit in
+	// clusters.
+	compUnit uint8
 }
 
 // ntfsFileName is a parsed $FILE_NAME attribute value.
 type ntfsFileName struct {
 	parent    uint64
+	parentSeq uint16 // sequence number of the parent reference
 	name      string
 	namespace uint8 // 0 POSIX, 1 Win32, 2 DOS (8.3 short), 3 Win32&DOS
 	flags     uint32
@@ -91,6 +117,7 @@ type ntfsStandardInfo struct {
 	modTime    int64
 	accessTime int64
 	flags      uint32
+	securityID uint32 // $Secure id; 0 in NTFS 1.x's shorter value
 }
 
 // ntfsIndexEntry aggregates the interesting fields of one in-use MFT record.
@@ -101,6 +128,13 @@ type ntfsIndexEntry struct {
 	si       *ntfsStandardInfo
 	dataSize uint64
 	hasData  bool
+	seq      uint16 // record sequence number
+	deleted  bool   // record not in use (indexed by WithDeleted)
+	// reparseTag is the tag of the record's reparse point, 0 for none.
+	reparseTag uint32
+	// realloc caches whether a deleted entry's clusters are allocated in
+	// $Bitmap (0 unchecked, 1 free, 2 reallocated).
+	realloc uint8
 }
 
 // NTFSHandler handles NTFS filesystem operations.
@@ -128,6 +162,22 @@ type NTFSHandler struct {
 	// ensureIndex so a directory listing and a path-component lookup are
 	// O(directory size) instead of a full-MFT scan per call.
 	children map[uint64][]uint64
+	// includeDeleted also indexes records not in use (see WithDeleted);
+	// readReallocated reads deleted files whose clusters were partly
+	// reallocated (see WithReallocated).
+	includeDeleted  bool
+	readReallocated bool
+	// followLinks makes path lookups follow links (see FollowLinks); drive
+	// is the upper-case drive letter the volume was mounted as, "" when
+	// unknown.
+	followLinks bool
+	drive       string
+	// bitmap reads the $Bitmap cluster allocation bitmap, opened on first use.
+	bitmap fileReader
+	// secure maps security ids to their descriptors in $Secure:$SDS, read
+	// from $Secure:$SII on first use.
+	secure map[uint32]ntfsSDSEntry
+	sds    *ntfsStream
 }
 
 // NewNTFSHandler creates a new NTFS handler. reader is the absolute-LBA sector
@@ -149,6 +199,17 @@ func NewNTFSHandler(reader filesystem.Reader, startLBA uint64) (*NTFSHandler, er
 	return h, nil
 }
 
+// view returns a copy of h for a view of the same volume. The copy starts
+// with none of h's lazily built state — the index, the cluster bitmap reader
+// and the security descriptors — and builds its own, so a view and the
+// handler it came from may be used from different goroutines.
+func (h *NTFSHandler) view() *NTFSHandler {
+	c := *h
+	c.indexLoaded, c.fileIndex, c.children = false, nil, nil
+	c.bitmap, c.secure, c.sds = nil, nil, nil
+	return &c
+}
+
 // readBootSector reads and validates the NTFS boot sector.
 func (h *NTFSHandler) readBootSector() error {
 	bootData, err := h.reader.ReadSectors(h.startLBA, 1)
@@ -329,6 +390,8 @@ func (h *NTFSHandler) parseAttrs(rec []byte) ([]ntfsAttr, error) {
 			nonResident: nonRes,
 			nameLen:     nameLen,
 			nameOffset:  nameOff,
+			id:          binary.LittleEndian.Uint16(rec[off+14 : off+16]),
+			flags:       binary.LittleEndian.Uint16(rec[off+12 : off+14]),
 		}
 		if !nonRes {
 			if off+24 > len(rec) {
@@ -351,6 +414,8 @@ func (h *NTFSHandler) parseAttrs(rec []byte) ([]ntfsAttr, error) {
 			}
 			a.runDataOff = off + runOff
 			a.runDataEnd = off + length
+			a.startVCN = binary.LittleEndian.Uint64(rec[off+0x10 : off+0x18])
+			a.compUnit = rec[off+0x22]
 			a.realSize = binary.LittleEndian.Uint64(rec[off+0x38 : off+0x40])
 		}
 		attrs = append(attrs, a)
@@ -375,6 +440,7 @@ func (h *NTFSHandler) parseFileName(rec []byte, a ntfsAttr) (*ntfsFileName, erro
 	}
 	return &ntfsFileName{
 		parent:    binary.LittleEndian.Uint64(val[0:8]) & 0x0000FFFFFFFFFFFF,
+		parentSeq: binary.LittleEndian.Uint16(val[6:8]),
 		name:      string(utf16.Decode(units)),
 		namespace: val[0x41],
 		flags:     binary.LittleEndian.Uint32(val[0x38:0x3C]),
@@ -388,12 +454,16 @@ func (h *NTFSHandler) parseStandardInfo(rec []byte, a ntfsAttr) *ntfsStandardInf
 	if len(val) < 0x24 {
 		return nil
 	}
-	return &ntfsStandardInfo{
+	si := &ntfsStandardInfo{
 		createTime: int64(binary.LittleEndian.Uint64(val[0x00:0x08])),
 		modTime:    int64(binary.LittleEndian.Uint64(val[0x08:0x10])),
 		accessTime: int64(binary.LittleEndian.Uint64(val[0x18:0x20])),
 		flags:      binary.LittleEndian.Uint32(val[0x20:0x24]),
 	}
+	if len(val) >= 0x38 {
+		si.securityID = binary.LittleEndian.Uint32(val[0x34:0x38])
+	}
+	return si
 }
 
 // filetimeToUnix converts a Windows FILETIME (100 ns ticks since 1601-01-01)
@@ -613,7 +683,8 @@ func (h *NTFSHandler) readNonResident(runs []ntfsRun, realSize uint64) ([]byte,
 }
 
 // ensureIndex builds the in-memory index of in-use MFT records (names, parent
-// pointers, timestamps, sizes). It is built lazily and cached.
+// pointers, timestamps, sizes), and of deleted ones when includeDeleted is
+// set. It is built lazily and cached.
 func (h *NTFSHandler) ensureIndex() error {
 	if h.indexLoaded {
 		return nil
@@ -622,6 +693,7 @@ func (h *NTFSHandler) ensureIndex() error {
 	h.children = make(map[uint64][]uint64)
 
 	const chunk uint64 = 256 // records per bulk read
+	var deleted []uint64
 	for start := uint64(0); start < h.recordCount; start += chunk {
 		count := chunk
 		if start+count > h.recordCount {
@@ -643,34 +715,60 @@ func (h *NTFSHandler) ensureIndex() error {
 				continue
 			}
 			flags := binary.LittleEndian.Uint16(rc[0x16:0x18])
-			if flags&mftRecordInUse == 0 {
+			inUse := flags&mftRecordInUse != 0
+			if !inUse && !h.includeDeleted {
 				continue
 			}
-			attrs, err := h.parseAttrs(rc)
+			if binary.LittleEndian.Uint64(rc[0x20:0x28])&mftBaseRefMask != 0 {
+				continue // extension record: indexed through its base record
+			}
+			parsed, err := h.parseAttrs(rc)
 			if err != nil {
 				continue
 			}
-			entry := &ntfsIndexEntry{recNum: recNum, isDir: flags&mftRecordDir != 0}
+			attrs := withRecord(rc, parsed)
+			// Names, timestamps and the data size may live in extension
+			// records. A partly broken attribute list still leaves the
+			// metadata that could be followed listable; reading the file
+			// reports the error.
+			ext, _ := h.extensionAttrs(recNum, rc, parsed)
+			attrs = append(attrs, ext...)
+			entry := &ntfsIndexEntry{
+				recNum:  recNum,
+				isDir:   flags&mftRecordDir != 0,
+				seq:     binary.LittleEndian.Uint16(rc[0x10:0x12]),
+				deleted: !inUse,
+			}
 			for j := range attrs {
 				a := &attrs[j]
 				switch {
 				case a.typ == attrFileName && a.nameLen == 0:
-					if fn, err := h.parseFileName(rc, *a); err == nil {
+					if fn, err := h.parseFileName(a.rec, a.ntfsAttr); err == nil {
 						entry.names = append(entry.names, *fn)
 					}
 				case a.typ == attrStandardInformation && a.nameLen == 0 && entry.si == nil:
-					entry.si = h.parseStandardInfo(rc, *a)
+					entry.si = h.parseStandardInfo(a.rec, a.ntfsAttr)
 				case a.typ == attrData && a.nameLen == 0:
 					entry.hasData = true
-					if a.nonResident {
-						entry.dataSize = a.realSize
-					} else {
+					if !a.nonResident {
 						entry.dataSize = uint64(a.valueLen)
+					} else if a.startVCN == 0 {
+						// Only the first fragment of a split stream records its size.
+						entry.dataSize = a.realSize
+					}
+				case a.typ == attrReparsePoint:
+					if rp, err := h.reparseValue([]ntfsRecAttr{*a}); err == nil && len(rp) >= 4 {
+						entry.reparseTag = binary.LittleEndian.Uint32(rp)
 					}
 				}
 			}
 			if len(entry.names) > 0 {
 				h.fileIndex[recNum] = entry
+				if entry.deleted {
+					// Placed once every parent candidate is indexed.
+					deleted = append(deleted, recNum)
+					continue
+				}
 				seenParent := make(map[uint64]bool)
 				for _, fn := range entry.names {
 					if seenParent[fn.parent] {
@@ -683,8 +781,10 @@ func (h *NTFSHandler) ensureIndex() error {
 		}
 	}
 
+	h.placeDeleted(deleted)
+
 	// The root directory (record 5) must be present and be a directory.
-	if root, ok := h.fileIndex[ntfsRootRecord]; !ok || !root.isDir {
+	if root, ok := h.fileIndex[ntfsRootRecord]; !ok || root.deleted || !root.isDir {
 		return fmt.Errorf("NTFS root directory record %d not found", ntfsRootRecord)
 	}
 	h.indexLoaded = true
@@ -693,16 +793,23 @@ func (h *NTFSHandler) ensureIndex() error {
 
 // findChild returns the MFT record whose $FILE_NAME (parent, name) matches. It
 // scans only the parent's children (see NTFSHandler.children), never the whole
-// file index.
+// file index. A live file wins over a deleted one of the same name.
 func (h *NTFSHandler) findChild(parent uint64, name string) (uint64, bool) {
+	found, ok := uint64(0), false
 	for _, rec := range h.children[parent] {
-		for _, fn := range h.fileIndex[rec].names {
+		e := h.fileIndex[rec]
+		for _, fn := range e.names {
 			if fn.parent == parent && fn.name == name {
-				return rec, true
+				if !e.deleted {
+					return rec, true
+				}
+				if !ok {
+					found, ok = rec, true
+				}
 			}
 		}
 	}
-	return 0, false
+	return found, ok
 }
 
 // displayRank orders $FILE_NAME namespaces for display. DOS (8.3 short) is the
@@ -755,8 +862,25 @@ func preferredAnyName(names []ntfsFileName) *ntfsFileName {
 
 // resolvePath resolves a filesystem path to an MFT record number by walking
 // $FILE_NAME parent pointers (a full-MFT walk). The root path ("", "/")
-// resolves to the root directory record.
+// resolves to the root directory record. In a FollowLinks view, links are
+// followed at every component but the last.
 func (h *NTFSHandler) resolvePath(path string) (uint64, error) {
+	return h.resolveLinks(path, false, make(map[uint64]struct{}))
+}
+
+// resolveDir resolves path like resolvePath, but in a FollowLinks view a
+// link at the final component is followed too, so listing or searching
+// through a junction reaches its target.
+func (h *NTFSHandler) resolveDir(path string) (uint64, error) {
+	return h.resolveLinks(path, true, make(map[uint64]struct{}))
+}
+
+// resolveLinks resolves path as described by resolvePath and resolveDir.
+// seen holds every link record followed so far across the whole lookup,
+// bounding the hops and breaking loops. A link's target is a volume path
+// (see Reparse), so the lookup restarts from the root with the target
+// followed by the components after the link.
+func (h *NTFSHandler) resolveLinks(path string, followFinal bool, seen map[uint64]struct{}) (uint64, error) {
 	clean := strings.Trim(path, "/")
 	if clean == "" {
 		return ntfsRootRecord, nil
@@ -771,7 +895,26 @@ func (h *NTFSHandler) resolvePath(path string) (uint64, error) {
 		if !ok {
 			return 0, fmt.Errorf("path component not found: %q: %w", part, filesystem.ErrNotFound)
 		}
-		if i < len(parts)-1 {
+		last := i == len(parts)-1
+		if e := h.fileIndex[next]; h.followLinks && isLinkTag(e.reparseTag) && (followFinal || !last) {
+			if len(seen) >= ntfsMaxLinkHops {
+				return 0, fmt.Errorf("too many links resolving %q", part)
+			}
+			if _, loop := seen[next]; loop {
+				return 0, fmt.Errorf("link loop at %q", part)
+			}
+			seen[next] = struct{}{}
+			rp, err := h.reparsePoint(next)
+			if err != nil {
+				return 0, fmt.Errorf("link %q: %w", part, err)
+			}
+			if rp.Target == "" || rp.Drive != "" && rp.Drive != h.drive {
+				return 0, fmt.Errorf("link %q to %q is not on the volume: %w", part, linkText(rp), filesystem.ErrNotFound)
+			}
+			rest := append([]string{rp.Target}, parts[i+1:]...)
+			return h.resolveLinks(strings.Join(rest, "/"), followFinal, seen)
+		}
+		if !last {
 			if e, ok2 := h.fileIndex[next]; !ok2 || !e.isDir {
 				return 0, fmt.Errorf("path component %q is not a directory: %w", part, filesystem.ErrNotDirectory)
 			}
@@ -814,7 +957,10 @@ func (h *NTFSHandler) relativePath(rec, ancestor uint64) (string, bool) {
 func fileInfoFromEntry(entry *ntfsIndexEntry, path string) filesystem.FileInfo {
 	fn := preferredAnyName(entry.names)
 	mode := filesystem.ModeRegular
-	if entry.isDir {
+	switch {
+	case isLinkTag(entry.reparseTag):
+		mode = filesystem.ModeSymlink
+	case entry.isDir:
 		mode = filesystem.ModeDir
 	}
 	var modT, accT, creT int64
@@ -866,7 +1012,8 @@ func (h *NTFSHandler) Close() error {
 
 // ListDirectory lists the entries of the directory at path. The listing is
 // reconstructed from the real $FILE_NAME attributes across the MFT (every
-// in-use record whose parent pointer references the directory's MFT record).
+// in-use record whose parent pointer references the directory's MFT record,
+// and deleted ones in a WithDeleted view).
 func (h *NTFSHandler) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
 	if h.reader == nil {
 		return nil, fmt.Errorf("NTFS handler has no reader")
@@ -874,7 +1021,7 @@ func (h *NTFSHandler) ListDirectory(path string) ([]filesystem.DirectoryEntry, e
 	if err := h.ensureIndex(); err != nil {
 		return nil, err
 	}
-	dirRec, err := h.resolvePath(path)
+	dirRec, err := h.resolveDir(path)
 	if err != nil {
 		return nil, err
 	}
@@ -897,21 +1044,31 @@ func (h *NTFSHandler) ListDirectory(path string) ([]filesystem.DirectoryEntry, e
 		if e.si != nil {
 			modTime = filetimeToUnix(e.si.modTime)
 		}
-		out = append(out, filesystem.DirectoryEntry{
+		de := filesystem.DirectoryEntry{
 			Name:    fn.name,
 			Path:    filesystem.JoinPath(path, fn.name),
 			Size:    e.dataSize,
 			IsDir:   e.isDir,
 			ModTime: modTime,
 			Inode:   rec,
-		})
+			Deleted: e.deleted,
+		}
+		if e.deleted {
+			// An unreadable run list is reported when the file is read.
+			de.Reallocated, _ = h.reallocated(e)
+		}
+		out = append(out, de)
 	}
 	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out, nil
 }
 
 // GetFile reads a file's content by resolving its path and reading its unnamed
-// $DATA attribute (resident inline, or non-resident via data runs).
+// $DATA attribute (resident inline, or non-resident via data runs). A stream
+// split across extension records by an $ATTRIBUTE_LIST is read through its
+// merged run list. LZNT1-compressed streams and WOF-compressed (CompactOS)
+// files are decompressed. A path ending in ":name" reads the file's named
+// stream (alternate data stream) name instead.
 func (h *NTFSHandler) GetFile(path string) ([]byte, error) {
 	if h.reader == nil {
 		return nil, fmt.Errorf("NTFS handler has no reader")
@@ -919,50 +1076,81 @@ func (h *NTFSHandler) GetFile(path string) ([]byte, error) {
 	if err := h.ensureIndex(); err != nil {
 		return nil, err
 	}
-	rec, err := h.resolvePath(path)
+	rec, name, err := h.resolveStream(path)
 	if err != nil {
 		return nil, err
 	}
-	entry := h.fileIndex[rec]
-	if entry.isDir {
-		return nil, fmt.Errorf("path is a directory: %s: %w", path, filesystem.ErrIsDirectory)
-	}
-
-	recBytes, err := h.readRecord(rec)
+	attrs, stream, name, err := h.recordStream(rec, name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	attrs, err := h.parseAttrs(recBytes)
+	dec, err := h.decodedReader(attrs, stream, name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("$DATA of %s: %w", path, err)
 	}
-	var hasAttrList, hasUnnamedData bool
-	for i := range attrs {
-		a := &attrs[i]
-		if a.typ == attrAttributeList {
-			hasAttrList = true
+	if dec != nil {
+		defer dec.Close()
+		size, err := dec.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
 		}
-		if a.typ != attrData || a.nameLen != 0 {
-			continue
+		if size > ntfsMaxFileBytes {
+			return nil, fmt.Errorf("file too large: %d bytes (limit %d)", size, ntfsMaxFileBytes)
 		}
-		hasUnnamedData = true
-		if !a.nonResident {
-			return recBytes[a.valueOffset : a.valueOffset+int(a.valueLen)], nil
+		out := make([]byte, size)
+		if _, err := dec.ReadAt(out, 0); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", path, err)
 		}
-		runs, err := h.parseRuns(recBytes[a.runDataOff:a.runDataEnd])
-		if err != nil {
-			return nil, fmt.Errorf("data runs for %s: %w", path, err)
+		return out, nil
+	}
+	if stream == nil {
+		// No unnamed $DATA stream: the file is empty (non-nil, zero-length).
+		return []byte{}, nil
+	}
+	if !stream.nonResident {
+		return stream.resident, nil
+	}
+	return h.readNonResident(stream.runs, stream.size)
+}
+
+// recordStream returns the attributes of MFT record rec and its $DATA stream
+// called name ("" for the unnamed stream, which only files read and which may
+// be absent), along with the stream's stored name. A deleted record whose
+// clusters were reallocated is ErrReallocated, unless the view reads them
+// blanked (see WithReallocated).
+func (h *NTFSHandler) recordStream(rec uint64, name string) ([]ntfsRecAttr, *ntfsStream, string, error) {
+	if name == "" && h.fileIndex[rec].isDir {
+		return nil, nil, "", fmt.Errorf("path is a directory: %w", filesystem.ErrIsDirectory)
+	}
+	realloc := false
+	if e := h.fileIndex[rec]; e != nil && e.deleted {
+		var err error
+		if realloc, err = h.reallocated(e); err != nil {
+			return nil, nil, "", fmt.Errorf("deleted record %d: %w", rec, err)
+		}
+		if realloc && !h.readReallocated {
+			return nil, nil, "", fmt.Errorf("deleted record %d: %w", rec, filesystem.ErrReallocated)
 		}
-		return h.readNonResident(runs, a.realSize)
 	}
-	// A record that carries an $ATTRIBUTE_LIST but no local unnamed $DATA holds
-	// its data in external MFT records, which this parser does not follow.
-	// Reporting an empty file here would be fabricated data.
-	if hasAttrList && !hasUnnamedData {
-		return nil, fmt.Errorf("attribute list not supported for %s: %w", path, filesystem.ErrUnsupported)
+	attrs, err := h.fileAttrs(rec)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if name != "" {
+		if name, err = streamName(attrs, name); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	stream, err := h.dataStream(attrs, name)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("$DATA %q: %w", name, err)
+	}
+	if realloc && stream != nil && stream.nonResident {
+		if stream.runs, _, err = h.blankReallocated(stream.runs); err != nil {
+			return nil, nil, "", fmt.Errorf("deleted record %d: %w", rec, err)
+		}
 	}
-	// No unnamed $DATA stream: the file is empty (non-nil, zero-length).
-	return []byte{}, nil
+	return attrs, stream, name, nil
 }
 
 // GetFileByPath returns metadata for the file at path.
@@ -994,7 +1182,7 @@ func (h *NTFSHandler) SearchFiles(rootPath string, predicate func(filesystem.Fil
 	if err := h.ensureIndex(); err != nil {
 		return nil, err
 	}
-	rootRec, err := h.resolvePath(rootPath)
+	rootRec, err := h.resolveDir(rootPath)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,9 @@
 package ntfs
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -398,46 +400,154 @@ func TestNTFSGetVolumeLabel(t *testing.T) {
 	}
 }
 
-// TestNTFSGetFileAttributeList: a file whose unnamed $DATA lives in an external
-// MFT record (signalled by an $ATTRIBUTE_LIST attribute and no local $DATA) must
-// produce an explicit error from GetFile, never an empty buffer.
-func TestNTFSGetFileAttributeList(t *testing.T) {
-	img := buildNTFSImage()
+// ntfsAttrListEntryValue builds one $ATTRIBUTE_LIST entry for attribute
+// (typ, id) held in MFT record rec.
+func ntfsAttrListEntryValue(typ uint32, startVCN, rec uint64, id uint16) []byte {
+	e := make([]byte, 0x20)
+	nle32(e, 0x00, typ)
+	nle16(e, 0x04, 0x20) // entry length
+	e[0x07] = 0x1A       // name offset
+	nle64(e, 0x08, startVCN)
+	nle64(e, 0x10, rec|1<<48) // MFT reference with sequence number 1
+	nle16(e, 0x18, id)
+	return e
+}
+
+// ntfsExtensionRecord builds extension MFT record recNum belonging to base.
+func ntfsExtensionRecord(recNum, base uint64, buildBody func([]byte) []byte) []byte {
+	rec := ntfsBuildRecordRaw(recNum, false, buildBody)
+	nle64(rec, 0x20, base|1<<48) // base file reference
+	return rec
+}
+
+// ntfsAttrListModTime is the $STANDARD_INFORMATION modification time of the
+// attribute-list fixture file, as Unix seconds.
+const ntfsAttrListModTime = 1700000000
+
+// buildNTFSAttrListImage extends the base image with frag.bin (record 20),
+// whose base record holds only $STANDARD_INFORMATION and an $ATTRIBUTE_LIST.
+// Its $FILE_NAME and the first $DATA fragment (VCN 0 -> LCN 13) live in
+// extension record 21 and the second fragment (VCN 1 -> LCN 12) in extension
+// record 22. The list names the fragments out of VCN order. It returns the
+// image and frag.bin's content.
+func buildNTFSAttrListImage() ([]byte, []byte) {
+	img := append(buildNTFSImage(), make([]byte, 2*4096)...)
 	mftOff := ntfsTestMFTLCN * 4096
-	rec := ntfsBuildRecordRaw(20, false, func(body []byte) []byte {
+	const size = 4096 + 100
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*13 + i/4096)
+	}
+	copy(img[13*4096:], content[:4096])
+	copy(img[12*4096:], content[4096:])
+
+	var list []byte
+	list = append(list, ntfsAttrListEntryValue(attrStandardInformation, 0, 20, 0)...)
+	list = append(list, ntfsAttrListEntryValue(attrFileName, 0, 21, 0)...)
+	list = append(list, ntfsAttrListEntryValue(attrData, 1, 22, 0)...)
+	list = append(list, ntfsAttrListEntryValue(attrData, 0, 21, 1)...)
+	base := ntfsBuildRecordRaw(20, false, func(body []byte) []byte {
 		siVal := make([]byte, 0x48)
+		nle64(siVal, 0x08, uint64(ntfsAttrListModTime+11644473600)*10_000_000)
 		nle32(siVal, 0x20, 0x20)
 		body = ntfsResidentAttr(body, attrStandardInformation, 0, siVal)
-		body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, "ext.txt", false))
-		// $ATTRIBUTE_LIST pointing at an external record; no unnamed $DATA here.
-		al := make([]byte, 0x18)
-		nle32(al, 0x00, attrData)
-		body = ntfsResidentAttr(body, attrAttributeList, 2, al)
-		return body
+		return ntfsResidentAttr(body, attrAttributeList, 1, list)
 	})
-	copy(img[mftOff+20*ntfsDefaultRecordSize:], rec)
+	copy(img[mftOff+20*ntfsDefaultRecordSize:], base)
+	copy(img[mftOff+21*ntfsDefaultRecordSize:], ntfsExtensionRecord(21, 20, func(body []byte) []byte {
+		body = ntfsResidentAttr(body, attrFileName, 0, ntfsFileNameValue(5, "frag.bin", false))
+		return ntfsNonResidentAttr(body, attrData, 1, 0, 0, size, []byte{0x11, 0x01, 0x0D, 0x00})
+	}))
+	copy(img[mftOff+22*ntfsDefaultRecordSize:], ntfsExtensionRecord(22, 20, func(body []byte) []byte {
+		return ntfsNonResidentAttr(body, attrData, 0, 1, 1, 0, []byte{0x11, 0x01, 0x0C, 0x00})
+	}))
+	return img, content
+}
 
+// TestNTFSGetFileAttributeList: a file whose name and $DATA live in extension
+// records reached through its $ATTRIBUTE_LIST is indexed under its real name
+// and timestamps, and reads back through the fragments' merged run list.
+func TestNTFSGetFileAttributeList(t *testing.T) {
+	img, content := buildNTFSAttrListImage()
 	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
 	if err != nil {
 		t.Fatalf("NewNTFSHandler: %v", err)
 	}
-	data, err := h.GetFile("ext.txt")
-	if err == nil {
-		t.Fatalf("GetFile on an attribute-list record must error (got %d bytes)", len(data))
-	}
-	// The record still lists real metadata; the read path alone is honest.
 	entries, err := h.ListDirectory("/")
 	if err != nil {
 		t.Fatalf("ListDirectory: %v", err)
 	}
-	found := false
-	for _, e := range entries {
-		if e.Name == "ext.txt" {
-			found = true
+	var frag *filesystem.DirectoryEntry
+	for i := range entries {
+		if entries[i].Name == "frag.bin" {
+			frag = &entries[i]
 		}
 	}
-	if !found {
-		t.Errorf("ext.txt should still be listed (its metadata is local)")
+	if frag == nil {
+		t.Fatalf("frag.bin not listed (got %d entries)", len(entries))
+	}
+	if frag.Size != uint64(len(content)) || frag.ModTime != ntfsAttrListModTime || frag.Inode != 20 {
+		t.Errorf("frag.bin entry = %+v, want size %d mtime %d inode 20", *frag, len(content), ntfsAttrListModTime)
+	}
+	data, err := h.GetFile("/frag.bin")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("GetFile(frag.bin) = %d bytes, content differs", len(data))
+	}
+	fi, err := h.GetFileByPath("/frag.bin")
+	if err != nil {
+		t.Fatalf("GetFileByPath: %v", err)
+	}
+	if fi.Size != uint64(len(content)) || fi.ModTime != ntfsAttrListModTime {
+		t.Errorf("GetFileByPath = size %d mtime %d", fi.Size, fi.ModTime)
+	}
+}
+
+// TestNTFSAttributeListErrors: an attribute list that cannot be followed must
+// produce an explicit error from GetFile, never a truncated or empty buffer,
+// while the base record's own metadata stays listable.
+func TestNTFSAttributeListErrors(t *testing.T) {
+	mftOff := ntfsTestMFTLCN * 4096
+	for name, corrupt := range map[string]func(img []byte){
+		"malformed entry": func(img []byte) {
+			copy(img[mftOff+20*ntfsDefaultRecordSize:], ntfsBuildRecordRaw(20, false, func(body []byte) []byte {
+				body = ntfsResidentAttr(body, attrFileName, 0, ntfsFileNameValue(5, "frag.bin", false))
+				al := make([]byte, 0x18)
+				nle32(al, 0x00, attrData)
+				return ntfsResidentAttr(body, attrAttributeList, 1, al)
+			}))
+		},
+		"foreign extension record": func(img []byte) {
+			rec := img[mftOff+22*ntfsDefaultRecordSize:]
+			nle64(rec, 0x20, 19|1<<48)
+		},
+		"missing fragment": func(img []byte) {
+			copy(img[mftOff+22*ntfsDefaultRecordSize:], ntfsExtensionRecord(22, 20, func(body []byte) []byte {
+				return ntfsResidentAttr(body, attrStandardInformation, 7, make([]byte, 0x48))
+			}))
+		},
+		"VCN gap": func(img []byte) {
+			copy(img[mftOff+22*ntfsDefaultRecordSize:], ntfsExtensionRecord(22, 20, func(body []byte) []byte {
+				return ntfsNonResidentAttr(body, attrData, 0, 2, 2, 0, []byte{0x11, 0x01, 0x0C, 0x00})
+			}))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			img, _ := buildNTFSAttrListImage()
+			corrupt(img)
+			h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+			if err != nil {
+				t.Fatalf("NewNTFSHandler: %v", err)
+			}
+			if data, err := h.GetFile("/frag.bin"); err == nil || errors.Is(err, filesystem.ErrNotFound) {
+				t.Fatalf("GetFile = %d bytes, %v; want a read error on a listed file", len(data), err)
+			}
+			if _, err := h.OpenFile("/frag.bin"); err == nil {
+				t.Fatal("OpenFile must error")
+			}
+		})
 	}
 }
 
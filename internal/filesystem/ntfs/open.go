@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/laenix/ewfgo/internal/filesystem"
 )
@@ -16,10 +17,14 @@ import (
 //
 // Resident files (data inline in the MFT record) are served from the record
 // bytes already read. Non-resident files are served through a lazy reader over
-// the data-run list. The file's MFT record and $DATA attribute are read once at
-// open; only the cluster data is fetched on demand through the same
-// reader.ReadSectors exact-decompression path as GetFile, so the red line
-// holds: real on-disk data or an explicit error, never fabricated bytes.
+// the data-run list, merged across extension records when an $ATTRIBUTE_LIST
+// splits the stream. LZNT1-compressed and WOF-compressed files are decoded one
+// compression unit or chunk at a time. A path ending in ":name" opens the
+// named stream (alternate data stream) name, with the same guarantees. The
+// file's MFT record and $DATA attribute are read once at open; only the
+// cluster data is fetched on demand through the same reader.ReadSectors
+// exact-decompression path as GetFile, so the red line holds: real on-disk
+// data or an explicit error, never fabricated bytes.
 func (h *NTFSHandler) OpenFile(path string) (io.ReadSeekCloser, error) {
 	if h.reader == nil {
 		return nil, fmt.Errorf("NTFS handler has no reader")
@@ -27,11 +32,11 @@ func (h *NTFSHandler) OpenFile(path string) (io.ReadSeekCloser, error) {
 	if err := h.ensureIndex(); err != nil {
 		return nil, err
 	}
-	rec, err := h.resolvePath(path)
+	rec, name, err := h.resolveStream(path)
 	if err != nil {
 		return nil, err
 	}
-	return h.openRecord(rec)
+	return h.openRecord(rec, name)
 }
 
 // OpenInode opens a file by its MFT record number, skipping the path walk (see
@@ -47,58 +52,22 @@ func (h *NTFSHandler) OpenInode(inode uint64, _ int64) (io.ReadSeekCloser, error
 	if _, ok := h.fileIndex[inode]; !ok {
 		return nil, fmt.Errorf("NTFS: record %d not found: %w", inode, filesystem.ErrNotFound)
 	}
-	return h.openRecord(inode)
+	return h.openRecord(inode, "")
 }
 
-func (h *NTFSHandler) openRecord(rec uint64) (io.ReadSeekCloser, error) {
-	entry := h.fileIndex[rec]
-	if entry.isDir {
-		return nil, fmt.Errorf("path is a directory: %w", filesystem.ErrIsDirectory)
-	}
-
-	recBytes, err := h.readRecord(rec)
+func (h *NTFSHandler) openRecord(rec uint64, name string) (io.ReadSeekCloser, error) {
+	attrs, stream, name, err := h.recordStream(rec, name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("record %d: %w", rec, err)
 	}
-	attrs, err := h.parseAttrs(recBytes)
+	dec, err := h.decodedReader(attrs, stream, name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("$DATA of record %d: %w", rec, err)
 	}
-	var hasAttrList, hasUnnamedData bool
-	for i := range attrs {
-		a := &attrs[i]
-		if a.typ == attrAttributeList {
-			hasAttrList = true
-		}
-		if a.typ != attrData || a.nameLen != 0 {
-			continue
-		}
-		hasUnnamedData = true
-		if !a.nonResident {
-			// Resident: data lives inline in the MFT record.
-			data := recBytes[a.valueOffset : a.valueOffset+int(a.valueLen)]
-			return &byteFileReader{Reader: bytes.NewReader(data)}, nil
-		}
-		if a.realSize == 0 {
-			// Zero-length non-resident stream: the run list is legitimately
-			// empty and there is no data to follow. A realSize > 0 stream with
-			// no runs is corrupt (its data would be lost), so that case still
-			// falls through to parseRuns and errors.
-			return &byteFileReader{Reader: bytes.NewReader(nil)}, nil
-		}
-		runs, err := h.parseRuns(recBytes[a.runDataOff:a.runDataEnd])
-		if err != nil {
-			return nil, fmt.Errorf("data runs for record %d: %w", rec, err)
-		}
-		return &ntfsFileReader{h: h, runs: runs, size: int64(a.realSize)}, nil
+	if dec != nil {
+		return dec, nil
 	}
-	// Same classification as GetFile: an $ATTRIBUTE_LIST with no local unnamed
-	// $DATA holds its data in external records this parser does not follow.
-	if hasAttrList && !hasUnnamedData {
-		return nil, fmt.Errorf("attribute list not supported for record %d: %w", rec, filesystem.ErrUnsupported)
-	}
-	// No unnamed $DATA stream: the file is empty.
-	return &byteFileReader{Reader: bytes.NewReader(nil)}, nil
+	return h.streamReader(stream), nil
 }
 
 // byteFileReader adapts a fixed byte slice to io.ReadSeekCloser.
@@ -121,12 +90,13 @@ type ntfsFileReader struct {
 	pos  int64
 }
 
-// runAt returns the data run that maps vcn, and whether it exists.
+// runAt returns the data run that maps vcn, and whether it exists. runs are
+// in VCN order, so a heavily fragmented file's thousands of runs are searched
+// in O(log n).
 func runAt(runs []ntfsRun, vcn uint64) (ntfsRun, bool) {
-	for _, r := range runs {
-		if vcn >= r.vcnStart && vcn < r.vcnStart+r.length {
-			return r, true
-		}
+	i := sort.Search(len(runs), func(i int) bool { return runs[i].vcnStart+runs[i].length > vcn })
+	if i < len(runs) && vcn >= runs[i].vcnStart {
+		return runs[i], true
 	}
 	return ntfsRun{}, false
 }
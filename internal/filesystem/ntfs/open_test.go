@@ -190,3 +190,26 @@ func TestNTFSOpenFileSentinelErrors(t *testing.T) {
 		t.Errorf("ListDirectory on a file err = %v, want ErrNotDirectory", err)
 	}
 }
+
+// TestNTFSOpenInodeAttributeList streams a file whose $DATA fragments live in
+// two extension records, across the fragment boundary.
+func TestNTFSOpenInodeAttributeList(t *testing.T) {
+	img, content := buildNTFSAttrListImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	rc, err := h.OpenInode(20, 0)
+	if err != nil {
+		t.Fatalf("OpenInode(20): %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("streamed = %d bytes, %v; want %d bytes", len(got), err, len(content))
+	}
+	buf := make([]byte, 10)
+	if _, err := rc.(io.ReaderAt).ReadAt(buf, 4091); err != nil || !bytes.Equal(buf, content[4091:4101]) {
+		t.Errorf("ReadAt across fragments = %x, %v", buf, err)
+	}
+}
@@ -0,0 +1,324 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// Reparse tags interpreted beyond their tag value.
+const (
+	ioReparseTagMountPoint = 0xA0000003 // junction or volume mount point
+	ioReparseTagSymlink    = 0xA000000C
+	ioReparseTagLXSymlink  = 0xA000001D // WSL symbolic link
+	ioReparseTagDedup      = 0x80000013
+
+	// ioReparseTagCloud is IO_REPARSE_TAG_CLOUD; bits 12-15 number its
+	// variants, IO_REPARSE_TAG_CLOUD_1 to IO_REPARSE_TAG_CLOUD_F.
+	ioReparseTagCloud     = 0x9000001A
+	ioReparseTagCloudMask = 0xFFFF0FFF
+
+	// symlinkFlagRelative marks a symbolic link whose substitute name is
+	// relative to the directory holding the link.
+	symlinkFlagRelative = 1
+
+	// ntfsMaxLinkHops bounds the links one path lookup follows, guarding
+	// against link cycles.
+	ntfsMaxLinkHops = 40
+)
+
+// reparseTag describes a reparse tag Windows defines: its name without the
+// IO_REPARSE_TAG_ prefix and, for a placeholder, the kind of placeholder.
+// The $DATA stream of a placeholder is sized to the file but left sparse
+// until its content, which lives outside the volume, is brought back.
+type reparseTag struct {
+	name        string
+	placeholder string
+}
+
+// reparseTags holds the reparse tags Windows defines. The cloud-files tags
+// are placeholders too, matched by ioReparseTagCloudMask.
+var reparseTags = map[uint32]reparseTag{
+	0x00000000:             {name: "RESERVED_ZERO"},
+	0x00000001:             {name: "RESERVED_ONE"},
+	0x00000002:             {name: "RESERVED_TWO"},
+	0x80000005:             {name: "DRIVE_EXTENDER"},
+	0x80000006:             {name: "HSM2", placeholder: "HSM2"},
+	0x80000007:             {name: "SIS"},
+	0x80000008:             {name: "WIM"},
+	0x80000009:             {name: "CSV"},
+	0x8000000A:             {name: "DFS"},
+	0x8000000B:             {name: "FILTER_MANAGER"},
+	0x80000012:             {name: "DFSR"},
+	ioReparseTagDedup:      {name: "DEDUP", placeholder: "deduplication"},
+	0x80000014:             {name: "NFS"},
+	0x80000015:             {name: "FILE_PLACEHOLDER", placeholder: "file placeholder"},
+	0x80000016:             {name: "DFM"},
+	ioReparseTagWOF:        {name: "WOF"},
+	0x80000018:             {name: "WCI", placeholder: "Windows container"},
+	0x8000001B:             {name: "APPEXECLINK"},
+	0x8000001E:             {name: "STORAGE_SYNC"},
+	0x80000020:             {name: "UNHANDLED"},
+	0x80000021:             {name: "ONEDRIVE", placeholder: "OneDrive"},
+	0x80000023:             {name: "AF_UNIX"},
+	0x80000024:             {name: "LX_FIFO"},
+	0x80000025:             {name: "LX_CHR"},
+	0x80000026:             {name: "LX_BLK"},
+	0x9000001C:             {name: "PROJFS", placeholder: "projected file system"},
+	0x9000101A:             {name: "CLOUD_1"},
+	0x9000201A:             {name: "CLOUD_2"},
+	0x9000301A:             {name: "CLOUD_3"},
+	0x9000401A:             {name: "CLOUD_4"},
+	0x9000501A:             {name: "CLOUD_5"},
+	0x9000601A:             {name: "CLOUD_6"},
+	0x9000701A:             {name: "CLOUD_7"},
+	0x9000801A:             {name: "CLOUD_8"},
+	0x9000901A:             {name: "CLOUD_9"},
+	0x9000A01A:             {name: "CLOUD_A"},
+	0x9000B01A:             {name: "CLOUD_B"},
+	0x9000C01A:             {name: "CLOUD_C"},
+	0x9000D01A:             {name: "CLOUD_D"},
+	0x9000E01A:             {name: "CLOUD_E"},
+	0x9000F01A:             {name: "CLOUD_F"},
+	ioReparseTagCloud:      {name: "CLOUD"},
+	0x90001018:             {name: "WCI_1"},
+	0x90000027:             {name: "STORAGE_SYNC_FOLDER"},
+	ioReparseTagMountPoint: {name: "MOUNT_POINT"},
+	ioReparseTagSymlink:    {name: "SYMLINK"},
+	0xA0000010:             {name: "IIS_CACHE"},
+	0xA0000019:             {name: "GLOBAL_REPARSE"},
+	0xA000001C:             {name: "PROJFS_TOMBSTONE"},
+	ioReparseTagLXSymlink:  {name: "LX_SYMLINK"},
+	0xA000001F:             {name: "WCI_TOMBSTONE"},
+	0xA0000027:             {name: "WCI_LINK"},
+	0xA0001027:             {name: "WCI_LINK_1"},
+	0xA0000028:             {name: "DATALESS_CIM"},
+	0xC0000004:             {name: "HSM", placeholder: "HSM"},
+	0xC0000014:             {name: "APPXSTRM"},
+}
+
+// TagName names reparse tag tag, IO_REPARSE_TAG_SYMLINK as "SYMLINK"; an
+// unknown tag is given in hex.
+func TagName(tag uint32) string {
+	if t, ok := reparseTags[tag]; ok {
+		return t.name
+	}
+	return fmt.Sprintf("0x%08X", tag)
+}
+
+// placeholderKind names the kind of placeholder tag is, "" when files with
+// the tag keep their content on the volume.
+func placeholderKind(tag uint32) string {
+	if tag&ioReparseTagCloudMask == ioReparseTagCloud {
+		return "cloud files"
+	}
+	return reparseTags[tag].placeholder
+}
+
+// isLinkTag reports whether tag makes its file a link to another path.
+func isLinkTag(tag uint32) bool {
+	return tag == ioReparseTagMountPoint || tag == ioReparseTagSymlink || tag == ioReparseTagLXSymlink
+}
+
+// Reparse decodes the $REPARSE_POINT of the file or directory at path. A
+// relative link's Target is resolved against the directory holding it; an
+// absolute one keeps its drive letter in Drive. A file without a reparse
+// point is ErrNotFound.
+func (h *NTFSHandler) Reparse(path string) (*filesystem.ReparsePoint, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	rec, err := h.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return h.reparsePoint(rec)
+}
+
+// reparsePoint decodes the reparse point of MFT record rec.
+func (h *NTFSHandler) reparsePoint(rec uint64) (*filesystem.ReparsePoint, error) {
+	attrs, err := h.fileAttrs(rec)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := h.reparseValue(attrs)
+	if err != nil {
+		return nil, err
+	}
+	if rp == nil {
+		return nil, fmt.Errorf("record %d has no $REPARSE_POINT: %w", rec, filesystem.ErrNotFound)
+	}
+	dir := "/"
+	if e := h.fileIndex[rec]; e != nil && len(e.names) > 0 {
+		parent := preferredAnyName(e.names).parent
+		if rel, ok := h.relativePath(parent, ntfsRootRecord); ok {
+			dir = "/" + rel
+		}
+	}
+	r, err := parseReparse(rp, dir)
+	if err != nil {
+		return nil, fmt.Errorf("$REPARSE_POINT of record %d: %w", rec, err)
+	}
+	return r, nil
+}
+
+// parseReparse decodes a $REPARSE_POINT value: the REPARSE_DATA_BUFFER of
+// a Microsoft tag, or the REPARSE_GUID_DATA_BUFFER of a third-party one.
+// dir is the path of the directory holding the file, against which a
+// relative link target resolves.
+func parseReparse(rp []byte, dir string) (*filesystem.ReparsePoint, error) {
+	if len(rp) < 8 {
+		return nil, fmt.Errorf("reparse point of %d bytes", len(rp))
+	}
+	r := &filesystem.ReparsePoint{Tag: binary.LittleEndian.Uint32(rp)}
+	dataLen := int(binary.LittleEndian.Uint16(rp[4:]))
+	data := rp[8:]
+	if r.Tag&0x80000000 == 0 {
+		if len(data) < 16 {
+			return nil, fmt.Errorf("tag %#08x: reparse GUID truncated", r.Tag)
+		}
+		r.GUID = formatGUID(data)
+		data = data[16:]
+	}
+	if dataLen > len(data) {
+		return nil, fmt.Errorf("tag %#08x: %d bytes of reparse data, %d stored", r.Tag, dataLen, len(data))
+	}
+	r.Data = data[:dataLen]
+
+	switch r.Tag {
+	case ioReparseTagMountPoint, ioReparseTagSymlink:
+		head := 8
+		if r.Tag == ioReparseTagSymlink {
+			head = 12
+		}
+		if dataLen < head {
+			return nil, fmt.Errorf("tag %#08x: reparse data of %d bytes", r.Tag, dataLen)
+		}
+		names := r.Data[head:]
+		var err error
+		if r.SubstituteName, err = reparseName(names, r.Data[0:], "substitute"); err != nil {
+			return nil, err
+		}
+		if r.PrintName, err = reparseName(names, r.Data[4:], "print"); err != nil {
+			return nil, err
+		}
+		if r.Tag == ioReparseTagSymlink {
+			r.Relative = binary.LittleEndian.Uint32(r.Data[8:])&symlinkFlagRelative != 0
+		}
+		if r.Relative {
+			r.Target = relativeTarget(dir, strings.ReplaceAll(r.SubstituteName, `\`, "/"))
+		} else {
+			r.Drive, r.Target = volumePath(r.SubstituteName)
+		}
+	case ioReparseTagLXSymlink:
+		if dataLen < 4 {
+			return nil, fmt.Errorf("tag %#08x: reparse data of %d bytes", r.Tag, dataLen)
+		}
+		r.SubstituteName = string(r.Data[4:])
+		r.PrintName = r.SubstituteName
+		// An absolute WSL target is a Linux path, not one on this volume.
+		if r.Relative = !strings.HasPrefix(r.SubstituteName, "/"); r.Relative {
+			r.Target = relativeTarget(dir, r.SubstituteName)
+		}
+	}
+	return r, nil
+}
+
+// reparseName decodes the UTF-16LE name whose offset and length are at
+// the start of hdr, from the path buffer names.
+func reparseName(names, hdr []byte, what string) (string, error) {
+	off := int(binary.LittleEndian.Uint16(hdr))
+	n := int(binary.LittleEndian.Uint16(hdr[2:]))
+	if n%2 != 0 || off+n > len(names) {
+		return "", fmt.Errorf("%s name at %d+%d outside the %d-byte path buffer", what, off, n, len(names))
+	}
+	units := make([]uint16, n/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(names[off+2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// volumePath splits an absolute link target, "\??\C:\Users" or
+// "C:\Users", into its upper-case drive letter and the path on that
+// drive's volume, "C" and "/Users". A target naming a volume by GUID or a
+// network share has neither.
+func volumePath(name string) (drive, p string) {
+	for _, prefix := range []string{`\??\`, `\\?\`} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if len(name) < 2 || name[1] != ':' || !(name[0]|0x20 >= 'a' && name[0]|0x20 <= 'z') {
+		return "", ""
+	}
+	return string(name[0] &^ 0x20), path.Clean("/" + strings.ReplaceAll(name[2:], `\`, "/"))
+}
+
+// relativeTarget resolves a relative link target against dir. A target
+// starting with a separator is relative to the root of the volume.
+func relativeTarget(dir, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return path.Clean(target)
+	}
+	return path.Join(dir, target)
+}
+
+// linkText is what reading a link returns: its target as it would be
+// displayed, the ext4 convention for symbolic links.
+func linkText(r *filesystem.ReparsePoint) string {
+	if r.PrintName != "" {
+		return r.PrintName
+	}
+	return r.SubstituteName
+}
+
+// storedLocally reports whether the $DATA stream data holds all of a
+// placeholder's content: present, and either resident, empty, compressed
+// (whose sparse runs are part of the encoding) or without sparse runs.
+func storedLocally(data *ntfsStream) bool {
+	if data == nil {
+		return false
+	}
+	if !data.nonResident || data.size == 0 || data.flags&attrFlagCompressionMask != 0 {
+		return true
+	}
+	for _, r := range data.runs {
+		if r.lcnStart < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FollowLinks returns a view of the volume whose path lookups follow
+// symbolic links, junctions and WSL symlinks to their targets on the
+// volume. Links are followed at every path component but the last; listing
+// or searching a directory, or reading its index, follows a final link as
+// well. drive is the letter the volume was mounted as, "C" or "C:": an
+// absolute target on another drive letter, or on any when drive is "", is
+// not on the volume. A lookup gives up after ntfsMaxLinkHops links or on a
+// loop, and a link whose target is not on the volume is ErrNotFound.
+func (h *NTFSHandler) FollowLinks(drive string) (filesystem.FileSystem, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	drive = strings.TrimSuffix(drive, ":")
+	if drive != "" && (len(drive) != 1 || !(drive[0]|0x20 >= 'a' && drive[0]|0x20 <= 'z')) {
+		return nil, fmt.Errorf("drive %q is not a drive letter", drive)
+	}
+	c := h.view()
+	c.followLinks = true
+	c.drive = strings.ToUpper(drive)
+	return c, nil
+}
+
+var (
+	_ filesystem.ReparseReader = (*NTFSHandler)(nil)
+	_ filesystem.LinkFollower  = (*NTFSHandler)(nil)
+)
@@ -0,0 +1,248 @@
+package ntfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsReparseValue builds a $REPARSE_POINT value for a Microsoft tag.
+func ntfsReparseValue(tag uint32, data []byte) []byte {
+	v := make([]byte, 8)
+	nle32(v, 0, tag)
+	nle16(v, 4, uint16(len(data)))
+	return append(v, data...)
+}
+
+// ntfsLinkReparse builds the $REPARSE_POINT value of a junction or, with
+// flags, a symbolic link.
+func ntfsLinkReparse(tag uint32, subst, print string, flags uint32) []byte {
+	enc := func(s string) []byte {
+		units := utf16.Encode([]rune(s))
+		b := make([]byte, 2*len(units))
+		for i, u := range units {
+			nle16(b, 2*i, u)
+		}
+		return b
+	}
+	sb, pb := enc(subst), enc(print)
+	head := 8
+	if tag == ioReparseTagSymlink {
+		head = 12
+	}
+	data := make([]byte, head)
+	nle16(data, 2, uint16(len(sb)))
+	nle16(data, 4, uint16(len(sb)))
+	nle16(data, 6, uint16(len(pb)))
+	if tag == ioReparseTagSymlink {
+		nle32(data, 8, flags)
+	}
+	data = append(append(data, sb...), pb...)
+	return ntfsReparseValue(tag, data)
+}
+
+// buildNTFSReparseImage extends the base image with link.txt (record 20),
+// a symbolic link to subdir/nested.txt; jct (21), a junction to subdir;
+// cloud.docx (22), a placeholder with the given tag whose 8 KiB $DATA is
+// sparse; and loop (23), a relative symbolic link to itself.
+func buildNTFSReparseImage(placeholderTag uint32) []byte {
+	img := buildNTFSImage()
+	mftOff := ntfsTestMFTLCN * 4096
+	put := func(num uint64, name string, isDir bool, attrs func([]byte) []byte) {
+		copy(img[mftOff+int(num)*ntfsDefaultRecordSize:], ntfsBuildRecordRaw(num, isDir, func(body []byte) []byte {
+			body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+			body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, name, isDir))
+			return attrs(body)
+		}))
+	}
+	put(20, "link.txt", false, func(body []byte) []byte {
+		return ntfsResidentAttr(body, attrReparsePoint, 2, ntfsLinkReparse(ioReparseTagSymlink, `\??\C:\subdir\nested.txt`, `C:\subdir\nested.txt`, 0))
+	})
+	put(21, "jct", true, func(body []byte) []byte {
+		return ntfsResidentAttr(body, attrReparsePoint, 2, ntfsLinkReparse(ioReparseTagMountPoint, `\??\C:\subdir`, `C:\subdir`, 0))
+	})
+	put(22, "cloud.docx", false, func(body []byte) []byte {
+		body = ntfsNonResidentAttr(body, attrData, 2, 0, 1, 8192, []byte{0x01, 0x02, 0x00})
+		return ntfsResidentAttr(body, attrReparsePoint, 3, ntfsReparseValue(placeholderTag, make([]byte, 16)))
+	})
+	put(23, "loop", false, func(body []byte) []byte {
+		return ntfsResidentAttr(body, attrReparsePoint, 2, ntfsLinkReparse(ioReparseTagSymlink, "loop", "loop", symlinkFlagRelative))
+	})
+	return img
+}
+
+// TestNTFSReparse: links decode to their names and volume targets, read as
+// their target text and report ModeSymlink; placeholders refuse to read.
+func TestNTFSReparse(t *testing.T) {
+	h, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSReparseImage(0x9000601A)}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	for path, want := range map[string]filesystem.ReparsePoint{
+		"/link.txt": {Tag: ioReparseTagSymlink, SubstituteName: `\??\C:\subdir\nested.txt`, PrintName: `C:\subdir\nested.txt`, Drive: "C", Target: "/subdir/nested.txt"},
+		"/jct":      {Tag: ioReparseTagMountPoint, SubstituteName: `\??\C:\subdir`, PrintName: `C:\subdir`, Drive: "C", Target: "/subdir"},
+		"/loop":     {Tag: ioReparseTagSymlink, SubstituteName: "loop", PrintName: "loop", Relative: true, Target: "/loop"},
+	} {
+		rp, err := h.Reparse(path)
+		if err != nil {
+			t.Fatalf("Reparse(%s): %v", path, err)
+		}
+		if rp.Tag != want.Tag || rp.SubstituteName != want.SubstituteName || rp.PrintName != want.PrintName || rp.Relative != want.Relative || rp.Drive != want.Drive || rp.Target != want.Target {
+			t.Errorf("Reparse(%s) = %+v, want %+v", path, rp, want)
+		}
+		fi, err := h.GetFileByPath(path)
+		if err != nil || fi.Mode != filesystem.ModeSymlink {
+			t.Errorf("GetFileByPath(%s) = %+v, %v, want ModeSymlink", path, fi, err)
+		}
+	}
+	if rp, err := h.Reparse("/cloud.docx"); err != nil || rp.Tag != 0x9000601A || len(rp.Data) != 16 {
+		t.Errorf("Reparse(/cloud.docx) = %+v, %v", rp, err)
+	}
+	if _, err := h.Reparse("/hello.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Reparse(/hello.txt) = %v, want ErrNotFound", err)
+	}
+
+	if got, err := h.GetFile("/link.txt"); err != nil || string(got) != `C:\subdir\nested.txt` {
+		t.Errorf("GetFile(/link.txt) = %q, %v", got, err)
+	}
+	if _, err := h.GetFile("/cloud.docx"); !errors.Is(err, filesystem.ErrPlaceholder) {
+		t.Errorf("GetFile(/cloud.docx) = %v, want ErrPlaceholder", err)
+	}
+	if _, err := h.OpenFile("/cloud.docx"); !errors.Is(err, filesystem.ErrPlaceholder) {
+		t.Errorf("OpenFile(/cloud.docx) = %v, want ErrPlaceholder", err)
+	}
+	if _, err := h.GetFile("/jct/nested.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("GetFile(/jct/nested.txt) without FollowLinks = %v, want ErrNotFound", err)
+	}
+
+	dedup, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSReparseImage(ioReparseTagDedup)}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	if _, err := dedup.GetFile("/cloud.docx"); !errors.Is(err, filesystem.ErrPlaceholder) || !strings.Contains(err.Error(), "deduplication") {
+		t.Errorf("GetFile of a dedup stub = %v, want a deduplication ErrPlaceholder", err)
+	}
+	// IO_REPARSE_TAG_FILE_PLACEHOLDER, the Windows 8.1 OneDrive stub.
+	stub, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSReparseImage(0x80000015)}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	if _, err := stub.GetFile("/cloud.docx"); !errors.Is(err, filesystem.ErrPlaceholder) {
+		t.Errorf("GetFile of a FILE_PLACEHOLDER stub = %v, want ErrPlaceholder", err)
+	}
+}
+
+// TestTagName: every placeholder tag is named, so the names callers see
+// and the tags reads refuse come from the same table.
+func TestTagName(t *testing.T) {
+	for tag, want := range map[uint32]string{
+		ioReparseTagSymlink: "SYMLINK",
+		0x80000015:          "FILE_PLACEHOLDER",
+		0x9000601A:          "CLOUD_6",
+		0x0000BEEF:          "0x0000BEEF",
+	} {
+		if got := TagName(tag); got != want {
+			t.Errorf("TagName(%#x) = %q, want %q", tag, got, want)
+		}
+	}
+	for tag, rt := range reparseTags {
+		if rt.name == "" {
+			t.Errorf("tag %#x has no name", tag)
+		}
+	}
+}
+
+// TestNTFSFollowLinks: a FollowLinks view resolves junctions and symbolic
+// links inside paths, and for listings at the final component too, and
+// stops on a loop. Links to another drive letter are not followed.
+func TestNTFSFollowLinks(t *testing.T) {
+	h, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSReparseImage(ioReparseTagCloud)}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	view, err := h.FollowLinks("c:")
+	if err != nil {
+		t.Fatalf("FollowLinks: %v", err)
+	}
+	f := view.(*NTFSHandler)
+	if got, err := f.GetFile("/jct/nested.txt"); err != nil || string(got) != "nested content" {
+		t.Errorf("GetFile(/jct/nested.txt) = %q, %v", got, err)
+	}
+	entries, err := f.ListDirectory("/jct")
+	if err != nil || len(entries) != 1 || entries[0].Name != "nested.txt" || entries[0].Path != "/jct/nested.txt" {
+		t.Errorf("ListDirectory(/jct) = %+v, %v", entries, err)
+	}
+	if got, err := f.GetFile("/link.txt"); err != nil || string(got) != `C:\subdir\nested.txt` {
+		t.Errorf("GetFile(/link.txt) = %q, %v; the final link is not followed", got, err)
+	}
+	if _, err := f.GetFile("/loop/x"); err == nil || !strings.Contains(err.Error(), "link loop") {
+		t.Errorf("GetFile(/loop/x) = %v, want a link loop", err)
+	}
+	if entries, err := h.ListDirectory("/jct"); err != nil || len(entries) != 0 {
+		t.Errorf("ListDirectory(/jct) on the original handler = %+v, %v", entries, err)
+	}
+
+	for _, drive := range []string{"D", ""} {
+		view, err := h.FollowLinks(drive)
+		if err != nil {
+			t.Fatalf("FollowLinks(%q): %v", drive, err)
+		}
+		if _, err := view.GetFile("/jct/nested.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+			t.Errorf("FollowLinks(%q) GetFile(/jct/nested.txt) = %v, want ErrNotFound", drive, err)
+		}
+		if _, err := view.GetFile("/loop/x"); err == nil || !strings.Contains(err.Error(), "link loop") {
+			t.Errorf("FollowLinks(%q) GetFile(/loop/x) = %v, want a link loop", drive, err)
+		}
+	}
+	if _, err := h.FollowLinks("CD"); err == nil {
+		t.Error("FollowLinks(CD) succeeded")
+	}
+}
+
+// TestParseReparse covers link targets that leave the directory, the
+// volume or Windows, third-party tags and truncated values.
+func TestParseReparse(t *testing.T) {
+	guid := ntfsReparseValue(0x0000BEEF, nil)
+	guid = append(guid, 0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x34, 0x12, 0, 1, 2, 3, 4, 5, 6, 7)
+	lx := func(target string) []byte {
+		return ntfsReparseValue(ioReparseTagLXSymlink, append([]byte{2, 0, 0, 0}, target...))
+	}
+	for _, c := range []struct {
+		name   string
+		rp     []byte
+		drive  string
+		target string
+		guid   string
+	}{
+		{"relative", ntfsLinkReparse(ioReparseTagSymlink, `..\hello.txt`, `..\hello.txt`, symlinkFlagRelative), "", "/hello.txt", ""},
+		{"root-relative", ntfsLinkReparse(ioReparseTagSymlink, `\hello.txt`, `\hello.txt`, symlinkFlagRelative), "", "/hello.txt", ""},
+		{"other drive", ntfsLinkReparse(ioReparseTagMountPoint, `\??\d:\data`, `d:\data`, 0), "D", "/data", ""},
+		{"volume GUID", ntfsLinkReparse(ioReparseTagMountPoint, `\??\Volume{0b1c2d3e-0000-0000-0000-100000000000}\`, "", 0), "", "", ""},
+		{"UNC", ntfsLinkReparse(ioReparseTagSymlink, `\??\UNC\server\share`, `\\server\share`, 0), "", "", ""},
+		{"WSL relative", lx("../hello.txt"), "", "/hello.txt", ""},
+		{"WSL absolute", lx("/etc/passwd"), "", "", ""},
+		{"third-party", guid, "", "", "12345678-1234-1234-0001-020304050607"},
+	} {
+		rp, err := parseReparse(c.rp, "/subdir")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if rp.Drive != c.drive || rp.Target != c.target || rp.GUID != c.guid {
+			t.Errorf("%s: drive %q target %q GUID %q, want %q %q %q", c.name, rp.Drive, rp.Target, rp.GUID, c.drive, c.target, c.guid)
+		}
+	}
+	symlink := ntfsLinkReparse(ioReparseTagSymlink, `C:\x`, `C:\x`, 0)
+	for name, rp := range map[string][]byte{
+		"header":      symlink[:6],
+		"data length": symlink[:len(symlink)-2],
+		"name bounds": ntfsReparseValue(ioReparseTagSymlink, []byte{0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+	} {
+		if _, err := parseReparse(rp, "/"); err == nil {
+			t.Errorf("%s: parseReparse succeeded", name)
+		}
+	}
+}
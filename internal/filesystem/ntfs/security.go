@@ -0,0 +1,362 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsSecureRecord is the fixed MFT record of $Secure, which holds the
+// volume's shared security descriptors in its $SDS stream, indexed by id in
+// $SII.
+const ntfsSecureRecord = 9
+
+const (
+	sdsStreamName = "$SDS"
+	siiIndexName  = "$SII"
+	// sdsHeaderLen is the $SDS entry header: hash, id, offset and length.
+	sdsHeaderLen = 0x14
+	// ntfsMaxSecurityBytes bounds one security descriptor; its offsets are
+	// 32-bit but Windows caps ACLs at 64 KiB.
+	ntfsMaxSecurityBytes = 1 << 18
+)
+
+// SECURITY_DESCRIPTOR control flags this parser consults.
+const (
+	seDACLPresent  = 0x0004
+	seSACLPresent  = 0x0010
+	seSelfRelative = 0x8000
+)
+
+// ntfsSDSEntry locates one descriptor in $Secure:$SDS.
+type ntfsSDSEntry struct {
+	offset int64
+	length uint32 // including the sdsHeaderLen header
+}
+
+// Security returns the security descriptor of the file or directory at
+// path: its own $SECURITY_DESCRIPTOR attribute on volumes that keep one
+// per file (NTFS 1.x), otherwise the descriptor its $STANDARD_INFORMATION
+// security id names in $Secure. A file with neither is ErrNotFound.
+func (h *NTFSHandler) Security(path string) (*filesystem.SecurityDescriptor, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	rec, err := h.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := h.fileAttrs(rec)
+	if err != nil {
+		return nil, err
+	}
+	s, err := h.attrStream(attrs, attrSecurityDescriptor, "")
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		if s.size > ntfsMaxSecurityBytes {
+			return nil, fmt.Errorf("$SECURITY_DESCRIPTOR is %d bytes, exceeds %d", s.size, ntfsMaxSecurityBytes)
+		}
+		raw := make([]byte, s.size)
+		r := h.streamReader(s)
+		err := readFullAt(r, raw, 0)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("$SECURITY_DESCRIPTOR: %w", err)
+		}
+		sd, err := parseSecurityDescriptor(raw)
+		if err != nil {
+			return nil, fmt.Errorf("$SECURITY_DESCRIPTOR: %w", err)
+		}
+		return sd, nil
+	}
+	var id uint32
+	for _, a := range attrs {
+		if a.typ == attrStandardInformation && a.nameLen == 0 && !a.nonResident {
+			if si := h.parseStandardInfo(a.rec, a.ntfsAttr); si != nil {
+				id = si.securityID
+			}
+			break
+		}
+	}
+	if id == 0 {
+		return nil, fmt.Errorf("%s: no security descriptor: %w", path, filesystem.ErrNotFound)
+	}
+	return h.secureDescriptor(id)
+}
+
+// secureDescriptor reads and parses descriptor id from $Secure:$SDS.
+func (h *NTFSHandler) secureDescriptor(id uint32) (*filesystem.SecurityDescriptor, error) {
+	if err := h.loadSecure(); err != nil {
+		return nil, fmt.Errorf("$Secure: %w", err)
+	}
+	loc, ok := h.secure[id]
+	if !ok {
+		return nil, fmt.Errorf("security id %d not in $Secure:%s: %w", id, siiIndexName, filesystem.ErrNotFound)
+	}
+	raw := make([]byte, loc.length)
+	r := h.streamReader(h.sds)
+	err := readFullAt(r, raw, loc.offset)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("$Secure:%s: %w", sdsStreamName, err)
+	}
+	if got := binary.LittleEndian.Uint32(raw[4:]); got != id {
+		return nil, fmt.Errorf("$Secure:%s entry at %d has security id %d, want %d", sdsStreamName, loc.offset, got, id)
+	}
+	sd, err := parseSecurityDescriptor(raw[sdsHeaderLen:])
+	if err != nil {
+		return nil, fmt.Errorf("security id %d: %w", id, err)
+	}
+	sd.SecurityID = id
+	return sd, nil
+}
+
+// loadSecure reads the $SII index of $Secure, mapping every security id to
+// its $SDS entry. It is read once and cached.
+func (h *NTFSHandler) loadSecure() error {
+	if h.secure != nil {
+		return nil
+	}
+	attrs, err := h.fileAttrs(ntfsSecureRecord)
+	if err != nil {
+		return err
+	}
+	sds, err := h.dataStream(attrs, sdsStreamName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sdsStreamName, err)
+	}
+	if sds == nil {
+		return fmt.Errorf("no %s stream: %w", sdsStreamName, filesystem.ErrNotFound)
+	}
+	root, err := indexRoot(attrs, siiIndexName)
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	m := make(map[uint32]ntfsSDSEntry)
+	add := func(off int, entry, key []byte) error {
+		dOff, dLen := int(le.Uint16(entry[0:])), int(le.Uint16(entry[2:]))
+		if len(key) != 4 || dLen < sdsHeaderLen || dOff+dLen > len(entry) {
+			return fmt.Errorf("%s entry at %d: key %d bytes, data %d bytes at %d", siiIndexName, off, len(key), dLen, dOff)
+		}
+		d := entry[dOff : dOff+dLen]
+		id := le.Uint32(d[4:])
+		e := ntfsSDSEntry{offset: int64(le.Uint64(d[8:])), length: le.Uint32(d[0x10:])}
+		if id != le.Uint32(key) || e.length < sdsHeaderLen || e.length > sdsHeaderLen+ntfsMaxSecurityBytes || e.offset < 0 {
+			return fmt.Errorf("%s entry at %d: security id %d, %d bytes at %d", siiIndexName, off, id, e.length, e.offset)
+		}
+		m[id] = e
+		return nil
+	}
+	start, used, _, err := indexNode(root, indexRootNodeHeader)
+	if err == nil {
+		err = nodeEntries(root, start, used, add)
+	}
+	if err != nil {
+		return fmt.Errorf("$INDEX_ROOT:%s: %w", siiIndexName, err)
+	}
+	blockSize := int(le.Uint32(root[0x08:0x0C]))
+	err = h.indexBlocks(attrs, siiIndexName, blockSize, func(blk []byte, _ int, inUse bool) error {
+		if !inUse {
+			return nil
+		}
+		if err := h.indexBlock(blk); err != nil {
+			return err
+		}
+		start, used, _, err := indexNode(blk, indexBlockNodeHeader)
+		if err != nil {
+			return err
+		}
+		return nodeEntries(blk, start, used, add)
+	})
+	if err != nil {
+		return err
+	}
+	h.secure, h.sds = m, sds
+	return nil
+}
+
+// parseSecurityDescriptor parses a self-relative SECURITY_DESCRIPTOR.
+func parseSecurityDescriptor(raw []byte) (*filesystem.SecurityDescriptor, error) {
+	le := binary.LittleEndian
+	if len(raw) < 0x14 {
+		return nil, fmt.Errorf("security descriptor too short (%d bytes)", len(raw))
+	}
+	if raw[0] != 1 {
+		return nil, fmt.Errorf("security descriptor revision %d", raw[0])
+	}
+	sd := &filesystem.SecurityDescriptor{Control: le.Uint16(raw[2:]), Raw: raw}
+	if sd.Control&seSelfRelative == 0 {
+		return nil, fmt.Errorf("security descriptor is not self-relative")
+	}
+	part := func(field int, what string) ([]byte, error) {
+		off := int(le.Uint32(raw[field:]))
+		if off == 0 {
+			return nil, nil
+		}
+		if off < 0x14 || off >= len(raw) {
+			return nil, fmt.Errorf("%s offset %d outside %d-byte descriptor", what, off, len(raw))
+		}
+		return raw[off:], nil
+	}
+	for _, f := range []struct {
+		field int
+		what  string
+		sid   *string
+	}{{0x04, "owner", &sd.Owner}, {0x08, "group", &sd.Group}} {
+		b, err := part(f.field, f.what)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		if *f.sid, err = parseSID(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.what, err)
+		}
+	}
+	for _, f := range []struct {
+		field   int
+		what    string
+		present uint16
+		acl     **filesystem.ACL
+	}{{0x0C, "SACL", seSACLPresent, &sd.SACL}, {0x10, "DACL", seDACLPresent, &sd.DACL}} {
+		if sd.Control&f.present == 0 {
+			continue
+		}
+		b, err := part(f.field, f.what)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue // a NULL ACL
+		}
+		if *f.acl, err = parseACL(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.what, err)
+		}
+	}
+	return sd, nil
+}
+
+// parseACL parses the ACL at the start of b.
+func parseACL(b []byte) (*filesystem.ACL, error) {
+	le := binary.LittleEndian
+	if len(b) < 8 {
+		return nil, fmt.Errorf("ACL header truncated")
+	}
+	size, count := int(le.Uint16(b[2:])), int(le.Uint16(b[4:]))
+	if size < 8 || size > len(b) {
+		return nil, fmt.Errorf("ACL size %d outside %d bytes", size, len(b))
+	}
+	acl := &filesystem.ACL{Revision: b[0]}
+	off := 8
+	for i := 0; i < count; i++ {
+		if off+4 > size {
+			return nil, fmt.Errorf("ACE %d at %d overruns the %d-byte ACL", i, off, size)
+		}
+		aceSize := int(le.Uint16(b[off+2:]))
+		if aceSize < 4 || aceSize%4 != 0 || off+aceSize > size {
+			return nil, fmt.Errorf("ACE %d at %d: size %d", i, off, aceSize)
+		}
+		ace, err := parseACE(b[off : off+aceSize])
+		if err != nil {
+			return nil, fmt.Errorf("ACE %d: %w", i, err)
+		}
+		acl.ACEs = append(acl.ACEs, ace)
+		off += aceSize
+	}
+	return acl, nil
+}
+
+// parseACE parses one ACE. Types with the common access mask and SID
+// layout and the object types are decoded; others keep only their header.
+func parseACE(b []byte) (filesystem.ACE, error) {
+	le := binary.LittleEndian
+	ace := filesystem.ACE{Type: b[0], Flags: b[1]}
+	body := b[4:]
+	switch ace.Type {
+	case 0x00, 0x01, 0x02, 0x03, 0x09, 0x0A, 0x0D, 0x0E, 0x11, 0x12, 0x13:
+		// ACCESS_ALLOWED, ACCESS_DENIED, SYSTEM_AUDIT, SYSTEM_ALARM, their
+		// callback forms, SYSTEM_MANDATORY_LABEL, SYSTEM_RESOURCE_ATTRIBUTE
+		// and SYSTEM_SCOPED_POLICY_ID: mask, SID, optional trailing data.
+		if len(body) < 4 {
+			return ace, fmt.Errorf("type %#x truncated", ace.Type)
+		}
+		ace.Mask = le.Uint32(body)
+		body = body[4:]
+	case 0x05, 0x06, 0x07, 0x08, 0x0B, 0x0C, 0x0F, 0x10:
+		// The object forms: mask, flags, the GUIDs the flags announce, SID.
+		if len(body) < 8 {
+			return ace, fmt.Errorf("type %#x truncated", ace.Type)
+		}
+		ace.Mask, ace.ObjectFlags = le.Uint32(body), le.Uint32(body[4:])
+		body = body[8:]
+		for _, g := range []struct {
+			flag uint32
+			dst  *string
+		}{{0x1, &ace.ObjectType}, {0x2, &ace.InheritedObjectType}} {
+			if ace.ObjectFlags&g.flag == 0 {
+				continue
+			}
+			if len(body) < 16 {
+				return ace, fmt.Errorf("type %#x object GUID truncated", ace.Type)
+			}
+			*g.dst = formatGUID(body)
+			body = body[16:]
+		}
+	default:
+		return ace, nil
+	}
+	sid, err := parseSID(body)
+	if err != nil {
+		return ace, err
+	}
+	ace.SID = sid
+	return ace, nil
+}
+
+// parseSID decodes the SID at the start of b to its string form.
+func parseSID(b []byte) (string, error) {
+	if len(b) < 8 {
+		return "", fmt.Errorf("SID truncated")
+	}
+	if b[0] != 1 {
+		return "", fmt.Errorf("SID revision %d", b[0])
+	}
+	n := int(b[1])
+	if n > 15 || len(b) < 8+4*n {
+		return "", fmt.Errorf("SID with %d sub-authorities truncated", n)
+	}
+	var auth uint64
+	for _, c := range b[2:8] {
+		auth = auth<<8 | uint64(c)
+	}
+	var sb strings.Builder
+	sb.WriteString("S-1-")
+	if auth >= 1<<32 {
+		fmt.Fprintf(&sb, "0x%012X", auth)
+	} else {
+		sb.WriteString(strconv.FormatUint(auth, 10))
+	}
+	for i := 0; i < n; i++ {
+		sb.WriteByte('-')
+		sb.WriteString(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[8+4*i:])), 10))
+	}
+	return sb.String(), nil
+}
+
+// formatGUID formats the little-endian GUID at the start of b.
+func formatGUID(b []byte) string {
+	le := binary.LittleEndian
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", le.Uint32(b), le.Uint16(b[4:]), le.Uint16(b[6:]), b[8:10], b[10:16])
+}
+
+var _ filesystem.SecurityReader = (*NTFSHandler)(nil)
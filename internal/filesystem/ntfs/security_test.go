@@ -0,0 +1,225 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsSID encodes a SID given as "S-1-5-32-544".
+func ntfsSID(s string) []byte {
+	parts := strings.Split(s, "-")[2:]
+	auth, _ := strconv.ParseUint(parts[0], 10, 48)
+	b := []byte{1, byte(len(parts) - 1), 0, 0, byte(auth >> 24), byte(auth >> 16), byte(auth >> 8), byte(auth)}
+	for _, p := range parts[1:] {
+		v, _ := strconv.ParseUint(p, 10, 32)
+		b = binary.LittleEndian.AppendUint32(b, uint32(v))
+	}
+	return b
+}
+
+// ntfsACE encodes an ACE of the common mask-and-SID layout.
+func ntfsACE(typ, flags uint8, mask uint32, sid string) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, mask)
+	return ntfsACEBody(typ, flags, append(body, ntfsSID(sid)...))
+}
+
+// ntfsACEBody wraps an ACE body in its header.
+func ntfsACEBody(typ, flags uint8, body []byte) []byte {
+	b := []byte{typ, flags, 0, 0}
+	b = append(b, body...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	nle16(b, 2, uint16(len(b)))
+	return b
+}
+
+// ntfsACL encodes an ACL holding aces.
+func ntfsACL(aces ...[]byte) []byte {
+	b := make([]byte, 8)
+	b[0] = 2
+	for _, a := range aces {
+		b = append(b, a...)
+	}
+	nle16(b, 2, uint16(len(b)))
+	nle16(b, 4, uint16(len(aces)))
+	return b
+}
+
+// ntfsSecurityDescriptor encodes a self-relative descriptor; a nil ACL with
+// its present flag set in control is stored as a NULL ACL.
+func ntfsSecurityDescriptor(control uint16, owner, group string, sacl, dacl []byte) []byte {
+	b := make([]byte, 0x14)
+	b[0] = 1
+	nle16(b, 2, control|seSelfRelative)
+	for _, p := range []struct {
+		field int
+		data  []byte
+	}{{0x04, ntfsSID(owner)}, {0x08, ntfsSID(group)}, {0x0C, sacl}, {0x10, dacl}} {
+		if p.data != nil {
+			nle32(b, p.field, uint32(len(b)))
+			b = append(b, p.data...)
+		}
+	}
+	return b
+}
+
+// ntfsSIIEntry builds a $SII index entry mapping id to the $SDS entry of
+// length bytes at off.
+func ntfsSIIEntry(id uint32, off uint64, length uint32) []byte {
+	e := make([]byte, 0x28)
+	nle16(e, 0x00, 0x14)
+	nle16(e, 0x02, sdsHeaderLen)
+	nle16(e, 0x08, uint16(len(e)))
+	nle16(e, 0x0A, 4)
+	nle32(e, 0x10, id)
+	nle32(e, 0x18, id)
+	nle64(e, 0x1C, off)
+	nle32(e, 0x24, length)
+	return e
+}
+
+// ntfsSetSecurityID sets the security id in the $STANDARD_INFORMATION
+// that ntfsBuildRecord writes first.
+func ntfsSetSecurityID(rec []byte, id uint32) []byte {
+	nle32(rec, 56+0x18+0x34, id)
+	return rec
+}
+
+// buildNTFSSecurityImage extends the base image with $Secure (record 9):
+// $SDS in cluster 12 holds descriptors 0x100 and 0x101, indexed by $SII's
+// root and by an INDX block in cluster 13. hello.txt uses 0x100, big.bin
+// 0x101, nested.txt the unindexed 0x200; subdir carries its own
+// $SECURITY_DESCRIPTOR with a NULL DACL. It returns the image and
+// descriptor 0x100.
+func buildNTFSSecurityImage() ([]byte, []byte) {
+	img := append(buildNTFSImage(), make([]byte, 2*4096)...)
+	mftOff := ntfsTestMFTLCN * 4096
+	put := func(num int, rec []byte) { copy(img[mftOff+num*ntfsDefaultRecordSize:], rec) }
+
+	guid := []byte{0xBA, 0x7A, 0x96, 0xBF, 0xE6, 0x0D, 0xD0, 0x11, 0xA2, 0x85, 0x00, 0xAA, 0x00, 0x30, 0x49, 0xE2}
+	objBody := binary.LittleEndian.AppendUint32(nil, 0x100)
+	objBody = binary.LittleEndian.AppendUint32(objBody, 0x1)
+	objBody = append(append(objBody, guid...), ntfsSID("S-1-5-11")...)
+	sd100 := ntfsSecurityDescriptor(seDACLPresent|seSACLPresent, "S-1-5-21-1-2-3-500", "S-1-5-32-544",
+		ntfsACL(ntfsACE(0x02, 0x80, 0x1, "S-1-1-0")),
+		ntfsACL(
+			ntfsACE(0x01, 0x00, 0x10000, "S-1-1-0"),
+			ntfsACE(0x00, 0x13, 0x1F01FF, "S-1-5-18"),
+			ntfsACEBody(0x05, 0x00, objBody),
+			ntfsACEBody(0x04, 0x00, make([]byte, 12)),
+		))
+	sd101 := ntfsSecurityDescriptor(seDACLPresent, "S-1-5-18", "S-1-5-18", nil, ntfsACL(ntfsACE(0x00, 0x00, 0x1200A9, "S-1-5-32-545")))
+	sds := img[12*4096 : 13*4096]
+	var offs []uint64
+	var off uint64
+	for i, sd := range [][]byte{sd100, sd101} {
+		id := uint32(0x100 + i)
+		nle32(sds, int(off)+4, id)
+		nle64(sds, int(off)+8, off)
+		nle32(sds, int(off)+0x10, uint32(sdsHeaderLen+len(sd)))
+		copy(sds[off+sdsHeaderLen:], sd)
+		offs = append(offs, off)
+		off = (off + sdsHeaderLen + uint64(len(sd)) + 15) &^ 15
+	}
+	copy(img[13*4096:], ntfsIndxBlock(0, [][]byte{ntfsSIIEntry(0x101, offs[1], uint32(sdsHeaderLen+len(sd101)))}, nil))
+
+	siiRoot := ntfsIndexRootValue(ntfsSIIEntry(0x100, offs[0], uint32(sdsHeaderLen+len(sd100))))
+	nle32(siiRoot, 0, 0) // a view index: no indexed attribute
+	put(9, ntfsBuildRecordRaw(9, false, func(body []byte) []byte {
+		body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+		body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, "$Secure", false))
+		body = ntfsNamedNonResidentAttr(body, attrData, 2, sdsStreamName, 0, 0, 4096, ntfsRunList([2]int64{1, 12}))
+		body = ntfsNamedResidentAttr(body, attrIndexRoot, 3, siiIndexName, siiRoot)
+		body = ntfsNamedNonResidentAttr(body, attrIndexAllocation, 4, siiIndexName, 0, 0, 4096, ntfsRunList([2]int64{1, 13}))
+		return ntfsNamedResidentAttr(body, attrBitmap, 5, siiIndexName, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	}))
+	put(16, ntfsSetSecurityID(ntfsBuildRecord(16, "hello.txt", 5, false, []byte("hello world"), nil, 0, ""), 0x100))
+	put(17, ntfsSetSecurityID(ntfsBuildRecord(17, "big.bin", 5, false, nil, []byte{0x11, 0x02, 0x0A, 0x00}, 4096, ""), 0x101))
+	put(19, ntfsSetSecurityID(ntfsBuildRecord(19, "nested.txt", 18, false, []byte("nested content"), nil, 0, ""), 0x200))
+	put(18, ntfsBuildRecordRaw(18, true, func(body []byte) []byte {
+		body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+		body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(5, "subdir", true))
+		return ntfsResidentAttr(body, attrSecurityDescriptor, 2, ntfsSecurityDescriptor(seDACLPresent, "S-1-5-32-544", "S-1-5-32-544", nil, nil))
+	}))
+	return img, sd100
+}
+
+// TestNTFSSecurity: descriptors resolve through $SII's root and INDX
+// blocks into $SDS and from a per-file $SECURITY_DESCRIPTOR, with owner,
+// group, SACL and DACL decoded down to object ACEs and NULL DACLs; a
+// missing descriptor is ErrNotFound.
+func TestNTFSSecurity(t *testing.T) {
+	img, sd100 := buildNTFSSecurityImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+
+	sd, err := h.Security("/hello.txt")
+	if err != nil {
+		t.Fatalf("Security(hello.txt): %v", err)
+	}
+	if sd.SecurityID != 0x100 || sd.Owner != "S-1-5-21-1-2-3-500" || sd.Group != "S-1-5-32-544" || string(sd.Raw) != string(sd100) {
+		t.Errorf("hello.txt descriptor = id %#x owner %s group %s", sd.SecurityID, sd.Owner, sd.Group)
+	}
+	if sd.SACL == nil || len(sd.SACL.ACEs) != 1 || sd.SACL.ACEs[0] != (filesystem.ACE{Type: 0x02, Flags: 0x80, Mask: 0x1, SID: "S-1-1-0"}) {
+		t.Errorf("hello.txt SACL = %+v", sd.SACL)
+	}
+	want := []filesystem.ACE{
+		{Type: 0x01, Mask: 0x10000, SID: "S-1-1-0"},
+		{Type: 0x00, Flags: 0x13, Mask: 0x1F01FF, SID: "S-1-5-18"},
+		{Type: 0x05, Mask: 0x100, SID: "S-1-5-11", ObjectFlags: 0x1, ObjectType: "bf967aba-0de6-11d0-a285-00aa003049e2"},
+		{Type: 0x04},
+	}
+	if sd.DACL == nil || sd.DACL.Revision != 2 || len(sd.DACL.ACEs) != len(want) {
+		t.Fatalf("hello.txt DACL = %+v", sd.DACL)
+	}
+	for i, w := range want {
+		if sd.DACL.ACEs[i] != w {
+			t.Errorf("DACL ACE %d = %+v, want %+v", i, sd.DACL.ACEs[i], w)
+		}
+	}
+
+	if sd, err := h.Security("/big.bin"); err != nil || sd.SecurityID != 0x101 || sd.Owner != "S-1-5-18" || sd.SACL != nil || len(sd.DACL.ACEs) != 1 {
+		t.Errorf("Security(big.bin) = %+v, %v", sd, err)
+	}
+	if sd, err := h.Security("/subdir"); err != nil || sd.SecurityID != 0 || sd.Owner != "S-1-5-32-544" || sd.DACL != nil || sd.Control&seDACLPresent == 0 {
+		t.Errorf("Security(subdir) = %+v, %v", sd, err)
+	}
+	for _, p := range []string{"/subdir/nested.txt", "/"} {
+		if _, err := h.Security(p); !errors.Is(err, filesystem.ErrNotFound) {
+			t.Errorf("Security(%s) = %v, want ErrNotFound", p, err)
+		}
+	}
+}
+
+func TestParseSecurityDescriptorMalformed(t *testing.T) {
+	good := ntfsSecurityDescriptor(seDACLPresent, "S-1-5-18", "S-1-5-18", nil, ntfsACL(ntfsACE(0x00, 0, 1, "S-1-1-0")))
+	for name, mutate := range map[string]func([]byte) []byte{
+		"short":             func(b []byte) []byte { return b[:0x10] },
+		"revision":          func(b []byte) []byte { b[0] = 2; return b },
+		"absolute":          func(b []byte) []byte { nle16(b, 2, seDACLPresent); return b },
+		"owner offset":      func(b []byte) []byte { nle32(b, 4, uint32(len(b))); return b },
+		"truncated DACL":    func(b []byte) []byte { return b[:len(b)-4] },
+		"SID sub-authority": func(b []byte) []byte { b[0x15] = 15; return b },
+	} {
+		b := append([]byte(nil), good...)
+		if _, err := parseSecurityDescriptor(mutate(b)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+	sid, err := parseSID(ntfsSID("S-1-5-21-1-2-3-1001"))
+	if err != nil || sid != "S-1-5-21-1-2-3-1001" {
+		t.Errorf("parseSID = %q, %v", sid, err)
+	}
+	big := []byte{1, 0, 0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC}
+	if sid, _ := parseSID(big); sid != "S-1-0x123456789ABC" {
+		t.Errorf("parseSID(48-bit authority) = %q", sid)
+	}
+}
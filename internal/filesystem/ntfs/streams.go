@@ -0,0 +1,107 @@
+package ntfs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// splitStream splits a path whose last component names an alternate data
+// stream, "dir/file:name" or "dir/file:name:$DATA", into the file's path and
+// the stream name. "file::$DATA" names the unnamed stream. ok is false when
+// the last component has no stream suffix.
+func splitStream(p string) (file, stream string, ok bool, err error) {
+	base := p[strings.LastIndex(p, "/")+1:]
+	i := strings.IndexByte(base, ':')
+	if i < 0 {
+		return p, "", false, nil
+	}
+	file = p[:len(p)-len(base)+i]
+	stream = base[i+1:]
+	if j := strings.IndexByte(stream, ':'); j >= 0 {
+		if typ := stream[j+1:]; !strings.EqualFold(typ, "$DATA") {
+			return "", "", false, fmt.Errorf("stream type %q: %w", typ, filesystem.ErrUnsupported)
+		}
+		stream = stream[:j]
+	}
+	return file, stream, true, nil
+}
+
+// resolveStream resolves a path that may end in a stream suffix (see
+// splitStream) to an MFT record and stream name ("" for the unnamed stream).
+// A name that exists with its colon, possible in the POSIX namespace, wins.
+func (h *NTFSHandler) resolveStream(p string) (uint64, string, error) {
+	rec, err := h.resolvePath(p)
+	if err == nil || !errors.Is(err, filesystem.ErrNotFound) {
+		return rec, "", err
+	}
+	file, stream, ok, serr := splitStream(p)
+	if serr != nil {
+		return 0, "", serr
+	}
+	if !ok {
+		return 0, "", err
+	}
+	if rec, err = h.resolvePath(file); err != nil {
+		return 0, "", err
+	}
+	return rec, stream, nil
+}
+
+// streamName returns the stored name of a file's named $DATA stream matching
+// name; stream names compare case-insensitively, like file names.
+func streamName(attrs []ntfsRecAttr, name string) (string, error) {
+	for _, a := range attrs {
+		if a.typ != attrData || a.nameLen == 0 {
+			continue
+		}
+		if n := attrName(a.rec, a.ntfsAttr); strings.EqualFold(n, name) {
+			return n, nil
+		}
+	}
+	return "", fmt.Errorf("stream %q: %w", name, filesystem.ErrNotFound)
+}
+
+// Streams lists the named $DATA streams (alternate data streams) of the file
+// or directory at path, sorted by name, with each stream's size and whether
+// it is resident in the MFT record. The unnamed stream is not listed.
+func (h *NTFSHandler) Streams(path string) ([]filesystem.Stream, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	rec, err := h.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := h.fileAttrs(rec)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	out := []filesystem.Stream{}
+	for _, a := range attrs {
+		if a.typ != attrData || a.nameLen == 0 {
+			continue
+		}
+		name := attrName(a.rec, a.ntfsAttr)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		s, err := h.dataStream(attrs, name)
+		if err != nil {
+			return nil, fmt.Errorf("stream %q: %w", name, err)
+		}
+		out = append(out, filesystem.Stream{Name: name, Size: s.size, Resident: !s.nonResident})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+var _ filesystem.StreamLister = (*NTFSHandler)(nil)
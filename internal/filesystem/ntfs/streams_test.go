@@ -0,0 +1,116 @@
+package ntfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ntfsNamedResidentAttr appends a resident attribute with a name.
+func ntfsNamedResidentAttr(buf []byte, typ uint32, id uint16, name string, value []byte) []byte {
+	valOff := (0x18 + 2*len(name) + 7) &^ 7
+	total := (valOff + len(value) + 7) &^ 7
+	a := make([]byte, total)
+	nle32(a, 0, typ)
+	nle32(a, 4, uint32(total))
+	a[9] = byte(len(name))
+	nle16(a, 10, 0x18)
+	nle16(a, 14, id)
+	nle32(a, 16, uint32(len(value)))
+	nle16(a, 20, uint16(valOff))
+	for i, c := range []byte(name) {
+		nle16(a, 0x18+2*i, uint16(c))
+	}
+	copy(a[valOff:], value)
+	return append(buf, a...)
+}
+
+// ntfsZoneIdentifier is the mark-of-the-web of the streams fixture.
+const ntfsZoneIdentifier = "[ZoneTransfer]\r\nZoneId=3\r\n"
+
+// buildNTFSStreamsImage extends the base image with packed.bin (record 20):
+// resident main content, a resident Zone.Identifier stream and a
+// non-resident "payload" stream in clusters 12-13. It returns the image and
+// the payload.
+func buildNTFSStreamsImage() ([]byte, []byte) {
+	payload := ntfsNoise(5000, 43)
+	img := buildNTFSCompressedImage(func(body []byte, lcn int64) ([]byte, []byte) {
+		body = ntfsResidentAttr(body, attrData, 2, []byte("main content"))
+		body = ntfsNamedResidentAttr(body, attrData, 3, "Zone.Identifier", []byte(ntfsZoneIdentifier))
+		body = ntfsNamedNonResidentAttr(body, attrData, 4, "payload", 0, 0, uint64(len(payload)), ntfsRunList([2]int64{2, lcn}))
+		return body, append(bytes.Clone(payload), make([]byte, 2*4096-len(payload))...)
+	})
+	return img, payload
+}
+
+// TestNTFSStreams: a file's alternate data streams list with their sizes and
+// residency and read as "path:name", case-insensitively and with an optional
+// ":$DATA" type, through GetFile and the streaming reader.
+func TestNTFSStreams(t *testing.T) {
+	img, payload := buildNTFSStreamsImage()
+	h, err := NewNTFSHandler(&memNTFSReader{data: img}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	streams, err := h.Streams("/packed.bin")
+	if err != nil {
+		t.Fatalf("Streams: %v", err)
+	}
+	want := []filesystem.Stream{
+		{Name: "Zone.Identifier", Size: uint64(len(ntfsZoneIdentifier)), Resident: true},
+		{Name: "payload", Size: uint64(len(payload))},
+	}
+	if len(streams) != len(want) || streams[0] != want[0] || streams[1] != want[1] {
+		t.Errorf("Streams = %+v, want %+v", streams, want)
+	}
+
+	for path, want := range map[string]string{
+		"/packed.bin":                       "main content",
+		"/packed.bin::$DATA":                "main content",
+		"/packed.bin:Zone.Identifier":       ntfsZoneIdentifier,
+		"/packed.bin:zone.identifier:$data": ntfsZoneIdentifier,
+		"/packed.bin:payload":               string(payload),
+		"/packed.bin:PAYLOAD:$DATA":         string(payload),
+	} {
+		got, err := h.GetFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("GetFile(%s) = %d bytes, %v", path, len(got), err)
+		}
+		f, err := h.OpenFile(path)
+		if err != nil {
+			t.Fatalf("OpenFile(%s): %v", path, err)
+		}
+		if got, err := io.ReadAll(f); err != nil || string(got) != want {
+			t.Errorf("OpenFile(%s) = %d bytes, %v", path, len(got), err)
+		}
+		f.Close()
+	}
+
+	f, err := h.OpenFile("/packed.bin:payload")
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	buf := make([]byte, 100)
+	if n, err := f.(io.ReaderAt).ReadAt(buf, 4050); err != nil || !bytes.Equal(buf[:n], payload[4050:4150]) {
+		t.Errorf("ReadAt across clusters: %d bytes, %v", n, err)
+	}
+
+	if _, err := h.GetFile("/packed.bin:missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("missing stream: %v, want ErrNotFound", err)
+	}
+	if _, err := h.OpenFile("/packed.bin:payload:$INDEX_ALLOCATION"); !errors.Is(err, filesystem.ErrUnsupported) {
+		t.Errorf("non-$DATA stream type: %v, want ErrUnsupported", err)
+	}
+	if _, err := h.GetFile("/missing.bin:payload"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("stream of a missing file: %v, want ErrNotFound", err)
+	}
+	if streams, err := h.Streams("/hello.txt"); err != nil || len(streams) != 0 {
+		t.Errorf("Streams(hello.txt) = %+v, %v, want none", streams, err)
+	}
+	if _, err := h.GetFile("/subdir:payload"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("missing stream of a directory: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,245 @@
+package ntfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// The update sequence number (USN) change journal: $Extend\$UsnJrnl keeps its
+// records in the sparse "$J" stream. Windows frees old records by
+// deallocating the front of the stream, so a long-lived journal is mostly
+// hole with the live records at its end.
+const (
+	usnJournalPath = "/$Extend/$UsnJrnl"
+	usnStreamName  = "$J"
+
+	// usnReadBytes is the journal window read at a time.
+	usnReadBytes = 64 * 1024
+	// usnMaxRecordBytes bounds one record; a V2/V3 record with a 255-unit
+	// name is under 600 bytes.
+	usnMaxRecordBytes = 64 * 1024
+
+	usnV2HeaderBytes = 0x3C
+	usnV3HeaderBytes = 0x4C
+	usnV4HeaderBytes = 0x40
+	usnExtentBytes   = 16
+)
+
+// OpenUSNJournal opens $Extend\$UsnJrnl:$J for a forward scan of its
+// USN_RECORD_V2, V3 and V4 entries. Sparse runs of the stream are skipped
+// without reading them. Each record's Path is its name under the current
+// path of its parent directory, or for V4 records (which carry no name) the
+// current path of the file itself; a reference whose MFT record was reused
+// since (its sequence number moved on) is not resolved.
+func (h *NTFSHandler) OpenUSNJournal() (filesystem.USNJournal, error) {
+	if h.reader == nil {
+		return nil, fmt.Errorf("NTFS handler has no reader")
+	}
+	if err := h.ensureIndex(); err != nil {
+		return nil, err
+	}
+	rec, err := h.resolvePath(usnJournalPath)
+	if err != nil {
+		return nil, fmt.Errorf("USN journal %s: %w", usnJournalPath, err)
+	}
+	_, s, _, err := h.recordStream(rec, usnStreamName)
+	if err != nil {
+		return nil, fmt.Errorf("USN journal %s:%s: %w", usnJournalPath, usnStreamName, err)
+	}
+	j := &usnJournal{h: h, r: h.streamReader(s)}
+	if s != nil {
+		if s.flags&(attrFlagCompressionMask|attrFlagEncrypted) != 0 {
+			return nil, fmt.Errorf("USN journal stored with attribute flags %#x: %w", s.flags, filesystem.ErrUnsupported)
+		}
+		j.size = int64(s.size)
+		if s.nonResident {
+			j.runs = s.runs
+		}
+	}
+	return j, nil
+}
+
+// usnJournal scans the $J stream. off is the next record offset, always
+// 8-byte aligned; buf caches the stream window at bufOff.
+type usnJournal struct {
+	h      *NTFSHandler
+	r      fileReader
+	runs   []ntfsRun // nil for a resident stream
+	size   int64
+	off    int64
+	buf    []byte
+	bufOff int64
+}
+
+// Next returns the next record. Zero padding between records is skipped
+// 8 bytes at a time and sparse runs in one step. After a malformed record
+// the scan resumes 8 bytes on; after a read error, at the next cluster.
+func (j *usnJournal) Next() (filesystem.USNRecord, error) {
+	for j.off < j.size {
+		if end := j.holeEnd(j.off); end > j.off {
+			j.off = end
+			continue
+		}
+		off := j.off
+		hdr, err := j.bytes(off, 8)
+		if err != nil {
+			cs := int64(j.h.clusterSize)
+			j.off = (off/cs + 1) * cs
+			return filesystem.USNRecord{}, fmt.Errorf("USN journal at offset %d: %w", off, err)
+		}
+		length := binary.LittleEndian.Uint32(hdr)
+		if length == 0 {
+			j.off += 8
+			continue
+		}
+		rec, err := j.record(off, length)
+		if err != nil {
+			j.off += 8
+			return filesystem.USNRecord{}, fmt.Errorf("USN record at offset %d: %w", off, err)
+		}
+		j.off += int64(length)
+		return rec, nil
+	}
+	return filesystem.USNRecord{}, io.EOF
+}
+
+// Close releases the stream reader.
+func (j *usnJournal) Close() error {
+	j.buf = nil
+	return j.r.Close()
+}
+
+// holeEnd returns the end of the sparse run containing off, or off when off
+// is in allocated (or unmapped) clusters.
+func (j *usnJournal) holeEnd(off int64) int64 {
+	if j.runs == nil {
+		return off
+	}
+	cs := int64(j.h.clusterSize)
+	run, ok := runAt(j.runs, uint64(off/cs))
+	if !ok || run.lcnStart >= 0 {
+		return off
+	}
+	return int64(run.vcnStart+run.length) * cs
+}
+
+// bytes returns n bytes of the stream at off, refilling the window when they
+// are not all in it.
+func (j *usnJournal) bytes(off int64, n int) ([]byte, error) {
+	if off+int64(n) > j.size {
+		return nil, fmt.Errorf("%d bytes at the end of the %d-byte journal: %w", n, j.size, io.ErrUnexpectedEOF)
+	}
+	if off >= j.bufOff && off+int64(n) <= j.bufOff+int64(len(j.buf)) {
+		return j.buf[off-j.bufOff:][:n], nil
+	}
+	size := min(max(int64(n), usnReadBytes), j.size-off)
+	buf := make([]byte, size)
+	if err := readFullAt(j.r, buf, off); err != nil {
+		j.buf = nil
+		return nil, err
+	}
+	j.buf, j.bufOff = buf, off
+	return buf[:n], nil
+}
+
+// record parses the length-byte record at off.
+func (j *usnJournal) record(off int64, length uint32) (filesystem.USNRecord, error) {
+	if length%8 != 0 || length < 8 || length > usnMaxRecordBytes {
+		return filesystem.USNRecord{}, fmt.Errorf("invalid record length %d", length)
+	}
+	b, err := j.bytes(off, int(length))
+	if err != nil {
+		return filesystem.USNRecord{}, err
+	}
+	le := binary.LittleEndian
+	r := filesystem.USNRecord{
+		Offset:       off,
+		MajorVersion: le.Uint16(b[4:]),
+		MinorVersion: le.Uint16(b[6:]),
+	}
+	switch r.MajorVersion {
+	case 2, 3:
+		// V3 widens both file references to 128 bits; the fields after them
+		// are the same, 16 bytes further on.
+		base, hdrLen := 0x18, usnV2HeaderBytes
+		r.FileRef, r.ParentRef = le.Uint64(b[8:]), le.Uint64(b[16:])
+		if r.MajorVersion == 3 {
+			base, hdrLen = 0x28, usnV3HeaderBytes
+			r.ParentRef = le.Uint64(b[24:])
+		}
+		if len(b) < hdrLen {
+			return filesystem.USNRecord{}, fmt.Errorf("V%d record of %d bytes", r.MajorVersion, len(b))
+		}
+		r.USN = int64(le.Uint64(b[base:]))
+		r.Timestamp = int64(le.Uint64(b[base+8:]))
+		r.Reason = le.Uint32(b[base+16:])
+		r.SourceInfo = le.Uint32(b[base+20:])
+		r.SecurityID = le.Uint32(b[base+24:])
+		r.FileAttributes = le.Uint32(b[base+28:])
+		nameLen, nameOff := int(le.Uint16(b[base+32:])), int(le.Uint16(b[base+34:]))
+		if nameLen%2 != 0 || (nameLen > 0 && nameOff < hdrLen) || nameOff+nameLen > len(b) {
+			return filesystem.USNRecord{}, fmt.Errorf("name of %d bytes at %d overruns the %d-byte record", nameLen, nameOff, len(b))
+		}
+		units := make([]uint16, nameLen/2)
+		for i := range units {
+			units[i] = le.Uint16(b[nameOff+2*i:])
+		}
+		r.Name = string(utf16.Decode(units))
+	case 4:
+		if len(b) < usnV4HeaderBytes {
+			return filesystem.USNRecord{}, fmt.Errorf("V4 record of %d bytes", len(b))
+		}
+		r.FileRef, r.ParentRef = le.Uint64(b[8:]), le.Uint64(b[24:])
+		r.USN = int64(le.Uint64(b[0x28:]))
+		r.Reason = le.Uint32(b[0x30:])
+		r.SourceInfo = le.Uint32(b[0x34:])
+		count, extLen := int(le.Uint16(b[0x3C:])), int(le.Uint16(b[0x3E:]))
+		if count > 0 && (extLen < usnExtentBytes || usnV4HeaderBytes+count*extLen > len(b)) {
+			return filesystem.USNRecord{}, fmt.Errorf("%d extents of %d bytes overrun the %d-byte record", count, extLen, len(b))
+		}
+		for i := 0; i < count; i++ {
+			e := b[usnV4HeaderBytes+i*extLen:]
+			r.Extents = append(r.Extents, filesystem.USNExtent{Offset: int64(le.Uint64(e)), Length: int64(le.Uint64(e[8:]))})
+		}
+	default:
+		return filesystem.USNRecord{}, fmt.Errorf("record version %d.%d: %w", r.MajorVersion, r.MinorVersion, filesystem.ErrUnsupported)
+	}
+	r.Path = j.h.namedPath(r.FileRef, r.ParentRef, r.Name)
+	return r, nil
+}
+
+// namedPath resolves a file a journal or log record names through the MFT
+// index: name under the parent directory's current path, or the file's own
+// path when there is no name. It is "" when neither resolves.
+func (h *NTFSHandler) namedPath(file, parent uint64, name string) string {
+	if name == "" {
+		p, _ := h.refPath(file)
+		return p
+	}
+	if p, ok := h.refPath(parent); ok {
+		return filesystem.JoinPath(p, name)
+	}
+	return ""
+}
+
+// refPath returns the current path of the MFT record an MFT reference names,
+// provided the record still has the reference's sequence number. A zero
+// sequence is not checked.
+func (h *NTFSHandler) refPath(ref uint64) (string, bool) {
+	num, seq := ref&0x0000FFFFFFFFFFFF, uint16(ref>>48)
+	e, ok := h.fileIndex[num]
+	if !ok || (seq != 0 && e.seq != seq) {
+		return "", false
+	}
+	rel, ok := h.relativePath(num, ntfsRootRecord)
+	if !ok {
+		return "", false
+	}
+	return "/" + rel, true
+}
+
+var _ filesystem.USNJournalOpener = (*NTFSHandler)(nil)
@@ -0,0 +1,168 @@
+package ntfs
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// usnRecordV2 builds a USN_RECORD_V2, or with major 3 a USN_RECORD_V3, padded
+// to 8 bytes.
+func usnRecordV2(major uint16, file, parent uint64, usn int64, reason uint32, name string) []byte {
+	base, hdr := 0x18, 0x3C
+	if major == 3 {
+		base, hdr = 0x28, 0x4C
+	}
+	units := utf16.Encode([]rune(name))
+	b := make([]byte, (hdr+2*len(units)+7)&^7)
+	nle32(b, 0, uint32(len(b)))
+	nle16(b, 4, major)
+	nle64(b, 8, file)
+	if major == 3 {
+		nle64(b, 24, parent)
+	} else {
+		nle64(b, 16, parent)
+	}
+	nle64(b, base, uint64(usn))
+	nle64(b, base+8, 133000000000000000)
+	nle32(b, base+16, reason)
+	nle32(b, base+28, 0x20)
+	nle16(b, base+32, uint16(2*len(units)))
+	nle16(b, base+34, uint16(hdr))
+	for i, u := range units {
+		nle16(b, hdr+2*i, u)
+	}
+	return b
+}
+
+// usnRecordV4 builds a USN_RECORD_V4 range-tracking record.
+func usnRecordV4(file, parent uint64, usn int64, extents ...filesystem.USNExtent) []byte {
+	b := make([]byte, 0x40+16*len(extents))
+	nle32(b, 0, uint32(len(b)))
+	nle16(b, 4, 4)
+	nle64(b, 8, file)
+	nle64(b, 24, parent)
+	nle64(b, 0x28, uint64(usn))
+	nle32(b, 0x30, 0x80000001)
+	nle16(b, 0x3C, uint16(len(extents)))
+	nle16(b, 0x3E, 16)
+	for i, e := range extents {
+		nle64(b, 0x40+16*i, uint64(e.Offset))
+		nle64(b, 0x48+16*i, uint64(e.Length))
+	}
+	return b
+}
+
+// usnTestHole is the sparse run at the front of the test journal: 128 GiB of
+// clusters no scan can afford to read.
+const usnTestHole = 1 << 25
+
+// buildNTFSUSNImage extends the base image with $Extend (record 11) and
+// $UsnJrnl (record 20), whose $J stream is the hole, cluster 12, a one-cluster
+// hole and cluster 13. Cluster 12 holds records for hello.txt (V2),
+// subdir/nested.txt (V3), after 8 bytes of padding a deleted gone.tmp in
+// subdir, a record with a bad length, and stale.txt whose parent reference
+// has an old sequence number; cluster 13 a V4 record for big.bin.
+func buildNTFSUSNImage() []byte {
+	img := append(buildNTFSImage(), make([]byte, 2*4096)...)
+	mftOff := ntfsTestMFTLCN * 4096
+	copy(img[mftOff+11*ntfsDefaultRecordSize:], ntfsBuildRecord(11, "$Extend", 5, true, nil, nil, 0, ""))
+	size := uint64(usnTestHole+3) * 4096
+	copy(img[mftOff+20*ntfsDefaultRecordSize:], ntfsBuildRecordRaw(20, false, func(body []byte) []byte {
+		body = ntfsResidentAttr(body, attrStandardInformation, 0, make([]byte, 0x48))
+		body = ntfsResidentAttr(body, attrFileName, 1, ntfsFileNameValue(11|1<<48, "$UsnJrnl", false))
+		body = ntfsNamedResidentAttr(body, attrData, 2, "$Max", make([]byte, 32))
+		return ntfsNamedNonResidentAttr(body, attrData, 3, "$J", 0, 0, size,
+			ntfsRunList([2]int64{usnTestHole, -1}, [2]int64{1, 12}, [2]int64{1, -1}, [2]int64{1, 13}))
+	}))
+
+	o1 := int64(usnTestHole) * 4096
+	var c []byte
+	c = append(c, usnRecordV2(2, 16|1<<48, 5|1<<48, o1, 0x80000100, "hello.txt")...)
+	c = append(c, usnRecordV2(3, 19|1<<48, 18|1<<48, o1+int64(len(c)), 0x2, "nested.txt")...)
+	c = append(c, make([]byte, 8)...)
+	c = append(c, usnRecordV2(2, 21|5<<48, 18|1<<48, o1+int64(len(c)), 0x200, "gone.tmp")...)
+	bad := make([]byte, 16)
+	nle32(bad, 0, 12)
+	c = append(c, bad...)
+	c = append(c, usnRecordV2(2, 22|2<<48, 18|9<<48, o1+int64(len(c)), 0x1000, "stale.txt")...)
+	copy(img[12*4096:], c)
+	copy(img[13*4096:], usnRecordV4(17|1<<48, 5|1<<48, o1+2*4096,
+		filesystem.USNExtent{Offset: 0, Length: 4096}, filesystem.USNExtent{Offset: 8192, Length: 512}))
+	return img
+}
+
+// TestNTFSUSNJournal: the journal scan skips the 128 GiB hole, reads V2, V3
+// and V4 records with their paths resolved, reports the malformed record and
+// carries on past it.
+func TestNTFSUSNJournal(t *testing.T) {
+	h, err := NewNTFSHandler(&memNTFSReader{data: buildNTFSUSNImage()}, 0)
+	if err != nil {
+		t.Fatalf("NewNTFSHandler: %v", err)
+	}
+	j, err := h.OpenUSNJournal()
+	if err != nil {
+		t.Fatalf("OpenUSNJournal: %v", err)
+	}
+	defer j.Close()
+
+	var recs []filesystem.USNRecord
+	var errs []error
+	for {
+		r, err := j.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		recs = append(recs, r)
+	}
+	if len(errs) != 1 {
+		t.Errorf("scan errors = %v, want the bad-length record only", errs)
+	}
+
+	o1 := int64(usnTestHole) * 4096
+	want := []struct {
+		major  uint16
+		offset int64
+		name   string
+		path   string
+	}{
+		{2, o1, "hello.txt", "/hello.txt"},
+		{3, o1 + 80, "nested.txt", "/subdir/nested.txt"},
+		{2, o1 + 184, "gone.tmp", "/subdir/gone.tmp"},
+		{2, o1 + 280, "stale.txt", ""},
+		{4, o1 + 8192, "", "/big.bin"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("got %d records %+v, want %d", len(recs), recs, len(want))
+	}
+	for i, w := range want {
+		r := recs[i]
+		if r.MajorVersion != w.major || r.Offset != w.offset || r.USN != w.offset || r.Name != w.name || r.Path != w.path {
+			t.Errorf("record %d = %+v, want V%d at %d %q %q", i, r, w.major, w.offset, w.name, w.path)
+		}
+	}
+	if r := recs[0]; r.Reason != 0x80000100 || r.FileRef != 16|1<<48 || r.FileAttributes != 0x20 || r.Timestamp != 133000000000000000 {
+		t.Errorf("hello.txt record = %+v", r)
+	}
+	if r := recs[1]; r.ParentRef != 18|1<<48 || r.Reason != 0x2 {
+		t.Errorf("V3 record = %+v", r)
+	}
+	if r := recs[4]; len(r.Extents) != 2 || r.Extents[1] != (filesystem.USNExtent{Offset: 8192, Length: 512}) || r.Timestamp != 0 {
+		t.Errorf("V4 record = %+v", r)
+	}
+
+	base, err := newTestNTFSHandler()
+	if err != nil {
+		t.Fatalf("newTestNTFSHandler: %v", err)
+	}
+	if _, err := base.OpenUSNJournal(); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("OpenUSNJournal without $UsnJrnl = %v, want ErrNotFound", err)
+	}
+}
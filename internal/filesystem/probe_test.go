@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"encoding/binary"
 	"math/rand"
 	"testing"
 )
@@ -84,16 +85,37 @@ func TestProbeAPFS(t *testing.T) {
 // every block size, block 0 holds the 1024-byte boot block then the
 // superblock); its s_magic 0xEF53 (little-endian) is at 1024+0x38. The old
 // probe also consulted 2048 and 4096, which are not superblock locations and
-// can false-positive on stray 0xEF53 bytes.
+// can false-positive on stray 0xEF53 bytes. The feature flags tell ext2, ext3
+// and ext4 apart.
 func TestProbeExt4(t *testing.T) {
 	// Positive: s_magic at 1024+0x38.
 	buf := make([]byte, 4096)
 	buf[1024+0x38] = 0x53
 	buf[1024+0x39] = 0xEF
+	buf[1024+0x60] = 0x40 // INCOMPAT_EXTENTS
 	if got := DetectFileSystem(buf); got != FS_EXT4 {
 		t.Fatalf("ext4 s_magic at 1024+0x38: DetectFileSystem = %q, want %q", got, FS_EXT4)
 	}
 
+	for _, tc := range []struct {
+		compat, incompat, roCompat uint32
+		want                       FileSystemType
+	}{
+		{0, 0x2, 0x3, FS_EXT2},         // FILETYPE, SPARSE_SUPER, LARGE_FILE
+		{0x4, 0x2, 0x3, FS_EXT3},       // + HAS_JOURNAL
+		{0x4, 0x6, 0x7, FS_EXT3},       // + RECOVER, BTREE_DIR
+		{0x4, 0x2, 0x3 | 0x8, FS_EXT4}, // HUGE_FILE
+		{0, 0x2 | 0x200, 0x3, FS_EXT4}, // FLEX_BG without a journal
+		{0x4, 0x2 | 0x40, 0x3, FS_EXT4},
+	} {
+		binary.LittleEndian.PutUint32(buf[1024+0x5C:], tc.compat)
+		binary.LittleEndian.PutUint32(buf[1024+0x60:], tc.incompat)
+		binary.LittleEndian.PutUint32(buf[1024+0x64:], tc.roCompat)
+		if got := DetectFileSystem(buf); got != tc.want {
+			t.Errorf("features %#x/%#x/%#x: DetectFileSystem = %q, want %q", tc.compat, tc.incompat, tc.roCompat, got, tc.want)
+		}
+	}
+
 	// Negative: s_magic at 2048+0x38 / 4096+0x38 (old false-positive offsets).
 	for _, sbOff := range []int{2048, 4096} {
 		b := make([]byte, 4096+sbOff) // >= sbOff+0x3A
@@ -0,0 +1,866 @@
+package refs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+func init() {
+	filesystem.RegisterFileSystem(filesystem.FS_REFS, func() filesystem.FileSystem { return &ReFS{} })
+	filesystem.RegisterHandler(filesystem.FS_REFS, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+		return NewReFSHandler(r, startLBA)
+	})
+}
+
+// ReFS (Resilient File System) implementation, format versions 1.x and 3.x.
+//
+// Microsoft does not document ReFS; the layout below follows the libfsrefs
+// format description. No ReFS evidence image is part of the fixture set, so
+// the reader is exercised against synthetic volumes built from this layout.
+// Every structure is validated as it is read (signatures, self references,
+// checksums) and anything that does not match is an explicit error.
+//
+//   - Volume boot record (sector 0): "ReFS\0\0\0\0" at 3, "FSRS" at 16,
+//     sector count at 24, bytes per sector at 32, sectors per cluster at 36,
+//     major/minor version at 40/41, serial number at 56 and (v3) the container
+//     size in bytes at 64.
+//   - Metadata blocks are 16 KiB. On v1 they are addressed in 16 KiB units; on
+//     v3 they are addressed by cluster, and a 16 KiB block on a 4 KiB-cluster
+//     volume is made of the four clusters its block reference lists. Headers
+//     are 0x30 bytes on v1 ({block number, sequence, object id}) and 0x50 bytes
+//     on v3 ({signature, ..., allocator clock at 16, four block numbers at 32,
+//     table id at 64}).
+//   - The superblock is metadata block 30. It names two checkpoints; the valid
+//     one with the higher sequence (v3: allocator clock) is current.
+//   - The checkpoint lists block references to the Minstore trees; reference
+//     0 is the object table and (v3) reference 7 the container table. A block
+//     reference is {block number, flags u16, checksum type u8, checksum
+//     offset u8, checksum length u16, ..., checksum} — 24 bytes on v1 and 48
+//     on v3, where it carries four block numbers. Checksum type 1 is CRC32-C
+//     and type 2 CRC64 (ECMA) over the whole metadata block.
+//   - A Minstore node is a u32 prefix size (the root node's table header
+//     lives in the prefix) followed by the node header {data start, data end,
+//     free, level u8, flags u8 (0x01 branch, 0x02 root), ..., record-offset
+//     array start at 16, record count at 20}. Each record is {size u32,
+//     key offset u16, key size u16, flags u16 (0x04 deleted), value offset
+//     u16, value size u16}, offsets relative to the record. A branch record's
+//     value is the block reference of a child node.
+//   - The object table maps a 16-byte object id (the id is the low u64) to the
+//     block reference of the object's tree. The root directory is object
+//     0x600. A directory table holds name records keyed {0x0030 u16, kind u16
+//     (1 file, 2 directory), UTF-16 name}. A directory's value is {u64, object
+//     id u64, created, modified, changed, accessed FILETIMEs, attributes u32};
+//     a file's value is an embedded Minstore node (the file table) whose
+//     record 0x10 holds {created, modified, changed, accessed, attributes u32
+//     at 0x20, file id at 0x28, size at 0x40} and whose record 0x80 (the data
+//     stream) holds an embedded node of extents keyed by starting file cluster
+//     (u64) with value {cluster count u64, start cluster u64}.
+//   - On v3 the clusters of file extents are virtual: the container table maps
+//     container n (key u64) to its physical start cluster (value u64 at 0),
+//     each container spanning container-size bytes.
+
+const (
+	refsSignature      = "ReFS\x00\x00\x00\x00"
+	refsMetadataBlock  = 16 * 1024
+	refsSuperblockNum  = 30
+	refsRootDirectory  = 0x600
+	refsMaxTreeDepth   = 16
+	refsHeaderV1       = 0x30
+	refsHeaderV3       = 0x50
+	refsRefSizeV1      = 24
+	refsRefSizeV3      = 48
+	refsCheckpointsV1  = 0x18 // number of tree references, relative to the body
+	refsCheckpointsV3  = 0x40
+	refsObjectTableRef = 0
+	refsContainerRef   = 7
+
+	refsNodeBranch    = 0x01
+	refsRecordDeleted = 0x04
+
+	refsKeyName        = 0x0030
+	refsNameFile       = 1
+	refsNameDirectory  = 2
+	refsKeyFileInfo    = 0x10
+	refsKeyDataStream  = 0x80
+	refsAttrHidden     = 0x02
+	refsAttrSystem     = 0x04
+	refsAttrReadOnly   = 0x01
+	refsMaxSearchDepth = 32
+	refsMaxSearchCount = 100000
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+	crc64Table  = crc64.MakeTable(crc64.ECMA)
+)
+
+// blockRef is a parsed block reference: the block numbers making up one
+// metadata block and the checksum that covers it.
+type blockRef struct {
+	blocks   []uint64
+	csumType byte
+	csum     []byte
+}
+
+// refsEntry is a parsed directory name record.
+type refsEntry struct {
+	name     string
+	isDir    bool
+	objectID uint64 // directories: the object id of the directory table
+	fileID   uint64 // files: the file id from the file table
+	size     uint64
+	attrs    uint32
+	created  int64
+	modified int64
+	accessed int64
+	file     []byte // files: the embedded file table
+}
+
+// ReFS implements filesystem.FileSystem over a ReFS volume.
+type ReFS struct {
+	startLBA      uint64
+	readFunc      func(startLBA uint64, count uint64) ([]byte, error)
+	bytesPerSec   uint32
+	clusterSize   uint32
+	major, minor  byte
+	serial        uint64
+	totalSectors  uint64
+	containerSize uint64
+
+	// Mount state.
+	objects    map[uint64]blockRef // object id -> tree root (object table)
+	containers map[uint64]uint64   // v3: container -> physical start cluster
+}
+
+// NewReFSHandler opens the ReFS volume at startLBA, selects the current
+// checkpoint and loads the object table.
+func NewReFSHandler(reader filesystem.Reader, startLBA uint64) (*ReFS, error) {
+	r := &ReFS{startLBA: startLBA, readFunc: reader.ReadSectors}
+	vbr, err := reader.ReadSectors(startLBA, 1)
+	if err != nil {
+		return nil, fmt.Errorf("ReFS: failed to read boot sector: %w", err)
+	}
+	if err := r.Open(vbr); err != nil {
+		return nil, err
+	}
+	if err := r.mount(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ReFS) Type() filesystem.FileSystemType { return filesystem.FS_REFS }
+
+// Open validates the volume boot record and caches the geometry.
+func (r *ReFS) Open(sectorData []byte) error {
+	if len(sectorData) < 512 {
+		return fmt.Errorf("ReFS: boot sector too small (%d bytes)", len(sectorData))
+	}
+	if string(sectorData[3:11]) != refsSignature || string(sectorData[16:20]) != "FSRS" {
+		return fmt.Errorf("ReFS: invalid boot sector signature")
+	}
+	bps := binary.LittleEndian.Uint32(sectorData[32:])
+	spc := binary.LittleEndian.Uint32(sectorData[36:])
+	if bps < 512 || bps > 4096 || bps&(bps-1) != 0 || spc == 0 || spc&(spc-1) != 0 || uint64(bps)*uint64(spc) > 64*1024 {
+		return fmt.Errorf("ReFS: invalid geometry (%d bytes/sector, %d sectors/cluster)", bps, spc)
+	}
+	r.bytesPerSec = bps
+	r.clusterSize = bps * spc
+	r.major, r.minor = sectorData[40], sectorData[41]
+	switch r.major {
+	case 1:
+	case 3:
+		if r.clusterSize != 4096 && r.clusterSize != 65536 {
+			return fmt.Errorf("ReFS: v3 volume with unsupported cluster size %d", r.clusterSize)
+		}
+		r.containerSize = binary.LittleEndian.Uint64(sectorData[64:])
+	default:
+		return fmt.Errorf("ReFS: unsupported format version %d.%d: %w", r.major, r.minor, filesystem.ErrUnsupported)
+	}
+	r.totalSectors = binary.LittleEndian.Uint64(sectorData[24:])
+	r.serial = binary.LittleEndian.Uint64(sectorData[56:])
+	return nil
+}
+
+func (r *ReFS) Close() error { return nil }
+
+// GetVolumeLabel returns "" — ReFS keeps the label in a metadata table this
+// reader does not parse.
+func (r *ReFS) GetVolumeLabel() string { return "" }
+
+// Version returns the format version as "major.minor".
+func (r *ReFS) Version() string { return fmt.Sprintf("%d.%d", r.major, r.minor) }
+
+// SerialNumber returns the volume serial number from the boot record.
+func (r *ReFS) SerialNumber() uint64 { return r.serial }
+
+func (r *ReFS) v3() bool { return r.major >= 3 }
+
+func (r *ReFS) headerSize() int {
+	if r.v3() {
+		return refsHeaderV3
+	}
+	return refsHeaderV1
+}
+
+// unitBytes is the size of one metadata block-number unit.
+func (r *ReFS) unitBytes() uint64 {
+	if r.v3() {
+		return uint64(r.clusterSize)
+	}
+	return refsMetadataBlock
+}
+
+// blockSize is the size of one metadata block.
+func (r *ReFS) blockSize() int {
+	if r.v3() && r.clusterSize > refsMetadataBlock {
+		return int(r.clusterSize)
+	}
+	return refsMetadataBlock
+}
+
+// readBytes reads length bytes at a volume byte offset (sector aligned).
+func (r *ReFS) readBytes(off, length uint64) ([]byte, error) {
+	if r.readFunc == nil {
+		return nil, fmt.Errorf("ReFS: handler has no reader")
+	}
+	bps := uint64(r.bytesPerSec)
+	if off%512 != 0 || length%512 != 0 {
+		return nil, fmt.Errorf("ReFS: unaligned read at %d", off)
+	}
+	if r.totalSectors != 0 && (off+length)/bps > r.totalSectors {
+		return nil, fmt.Errorf("ReFS: read at byte %d beyond the volume", off)
+	}
+	return r.readFunc(r.startLBA+off/512, length/512)
+}
+
+// readBlock reads the metadata block made of the units in blocks (one unit
+// when the block is contiguous or a unit is a whole block).
+func (r *ReFS) readBlock(blocks []uint64) ([]byte, error) {
+	unit, size := r.unitBytes(), uint64(r.blockSize())
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("ReFS: empty block reference")
+	}
+	if unit >= size || len(blocks) == 1 {
+		return r.readBytes(blocks[0]*unit, size)
+	}
+	buf := make([]byte, 0, size)
+	for i := uint64(0); i < size/unit; i++ {
+		part, err := r.readBytes(blocks[i]*unit, unit)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, part...)
+	}
+	return buf, nil
+}
+
+// readMetadata reads and validates the metadata block of ref: its header
+// must name the block itself and its checksum (when the reference carries
+// one) must match.
+func (r *ReFS) readMetadata(ref blockRef, sig string) ([]byte, error) {
+	buf, err := r.readBlock(ref.blocks)
+	if err != nil {
+		return nil, err
+	}
+	if r.v3() {
+		if sig != "" && string(buf[0:4]) != sig {
+			return nil, fmt.Errorf("ReFS: block %d has signature %q, want %q", ref.blocks[0], buf[0:4], sig)
+		}
+		if got := binary.LittleEndian.Uint64(buf[32:]); got != ref.blocks[0] {
+			return nil, fmt.Errorf("ReFS: block %d names itself %d", ref.blocks[0], got)
+		}
+	} else if got := binary.LittleEndian.Uint64(buf[0:]); got != ref.blocks[0] {
+		return nil, fmt.Errorf("ReFS: block %d names itself %d", ref.blocks[0], got)
+	}
+	switch ref.csumType {
+	case 0:
+	case 1:
+		if len(ref.csum) < 4 || crc32.Checksum(buf, crc32cTable) != binary.LittleEndian.Uint32(ref.csum) {
+			return nil, fmt.Errorf("ReFS: block %d fails its CRC32-C checksum", ref.blocks[0])
+		}
+	case 2:
+		if len(ref.csum) < 8 || crc64.Checksum(buf, crc64Table) != binary.LittleEndian.Uint64(ref.csum) {
+			return nil, fmt.Errorf("ReFS: block %d fails its CRC64 checksum", ref.blocks[0])
+		}
+	default:
+		return nil, fmt.Errorf("ReFS: block %d has unknown checksum type %d", ref.blocks[0], ref.csumType)
+	}
+	return buf, nil
+}
+
+// parseRef parses a block reference.
+func (r *ReFS) parseRef(b []byte) (blockRef, error) {
+	n, size := 1, refsRefSizeV1
+	if r.v3() {
+		n, size = 4, refsRefSizeV3
+	}
+	if len(b) < size {
+		return blockRef{}, fmt.Errorf("ReFS: block reference truncated (%d bytes)", len(b))
+	}
+	ref := blockRef{}
+	for i := 0; i < n; i++ {
+		ref.blocks = append(ref.blocks, binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	at := 8 * n
+	ref.csumType = b[at+2]
+	off, length := int(b[at+3]), int(binary.LittleEndian.Uint16(b[at+4:]))
+	if ref.csumType != 0 {
+		if off+length > size {
+			return blockRef{}, fmt.Errorf("ReFS: block reference checksum out of range")
+		}
+		ref.csum = b[off : off+length]
+	}
+	return ref, nil
+}
+
+// mount selects the current checkpoint and loads the object and container
+// tables.
+func (r *ReFS) mount() error {
+	sb, err := r.readMetadata(blockRef{blocks: r.contiguous(refsSuperblockNum)}, "SUPB")
+	if err != nil {
+		return fmt.Errorf("ReFS: superblock: %w", err)
+	}
+	body := sb[r.headerSize():]
+	cpOff := int(binary.LittleEndian.Uint32(body[32:]))
+	cpCount := int(binary.LittleEndian.Uint32(body[36:]))
+	if cpCount == 0 || cpCount > 8 || cpOff+8*cpCount > len(sb) {
+		return fmt.Errorf("ReFS: superblock has implausible checkpoint list (%d at %d)", cpCount, cpOff)
+	}
+
+	var cp []byte
+	var best uint64
+	var lastErr error
+	for i := 0; i < cpCount; i++ {
+		num := binary.LittleEndian.Uint64(sb[cpOff+8*i:])
+		buf, err := r.readMetadata(blockRef{blocks: r.contiguous(num)}, "CHKP")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		seq := binary.LittleEndian.Uint64(buf[8:])
+		if r.v3() {
+			seq = binary.LittleEndian.Uint64(buf[16:])
+		}
+		if cp == nil || seq > best {
+			cp, best = buf, seq
+		}
+	}
+	if cp == nil {
+		return fmt.Errorf("ReFS: no valid checkpoint: %w", lastErr)
+	}
+
+	refs, err := r.checkpointRefs(cp)
+	if err != nil {
+		return err
+	}
+	if len(refs) <= refsObjectTableRef {
+		return fmt.Errorf("ReFS: checkpoint has no object table reference")
+	}
+	r.objects = make(map[uint64]blockRef)
+	err = r.walkTree(refs[refsObjectTableRef], 0, func(key, value []byte) error {
+		if len(key) < 16 {
+			return fmt.Errorf("ReFS: object table key too short")
+		}
+		ref, err := r.parseRef(value)
+		if err != nil {
+			return err
+		}
+		r.objects[binary.LittleEndian.Uint64(key[8:])] = ref
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ReFS: object table: %w", err)
+	}
+	if _, ok := r.objects[refsRootDirectory]; !ok {
+		return fmt.Errorf("ReFS: object table has no root directory")
+	}
+
+	if r.v3() && r.containerSize != 0 {
+		if len(refs) <= refsContainerRef {
+			return fmt.Errorf("ReFS: checkpoint has no container table reference")
+		}
+		r.containers = make(map[uint64]uint64)
+		err = r.walkTree(refs[refsContainerRef], 0, func(key, value []byte) error {
+			if len(key) < 8 || len(value) < 8 {
+				return fmt.Errorf("ReFS: container table record too short")
+			}
+			r.containers[binary.LittleEndian.Uint64(key)] = binary.LittleEndian.Uint64(value)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("ReFS: container table: %w", err)
+		}
+	}
+	return nil
+}
+
+// contiguous returns the unit numbers of the metadata block starting at n.
+func (r *ReFS) contiguous(n uint64) []uint64 {
+	per := uint64(r.blockSize()) / r.unitBytes()
+	out := make([]uint64, per)
+	for i := range out {
+		out[i] = n + uint64(i)
+	}
+	return out
+}
+
+// checkpointRefs parses the tree references listed by a checkpoint.
+func (r *ReFS) checkpointRefs(cp []byte) ([]blockRef, error) {
+	body := r.headerSize()
+	at := body + refsCheckpointsV1
+	if r.v3() {
+		at = body + refsCheckpointsV3
+	}
+	count := int(binary.LittleEndian.Uint32(cp[at:]))
+	if count == 0 || at+4+4*count > len(cp) {
+		return nil, fmt.Errorf("ReFS: checkpoint has implausible %d tree references", count)
+	}
+	refs := make([]blockRef, count)
+	for i := range refs {
+		off := int(binary.LittleEndian.Uint32(cp[at+4+4*i:]))
+		if off >= len(cp) {
+			return nil, fmt.Errorf("ReFS: checkpoint tree reference %d out of range", i)
+		}
+		ref, err := r.parseRef(cp[off:])
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// --- Minstore nodes ---
+
+type refsRecord struct {
+	key, value []byte
+}
+
+// parseNode parses the Minstore node at b (a block body or an embedded
+// value) and returns whether it is a branch and its live records.
+func parseNode(b []byte) (bool, []refsRecord, error) {
+	if len(b) < 4 {
+		return false, nil, fmt.Errorf("ReFS: node too short")
+	}
+	prefix := int(binary.LittleEndian.Uint32(b))
+	if prefix < 4 || prefix+32 > len(b) {
+		return false, nil, fmt.Errorf("ReFS: node prefix size %d out of range", prefix)
+	}
+	hdr := b[prefix:]
+	branch := hdr[13]&refsNodeBranch != 0
+	arr := int(binary.LittleEndian.Uint32(hdr[16:]))
+	count := int(binary.LittleEndian.Uint32(hdr[20:]))
+	if arr < 0 || count < 0 || arr+4*count > len(hdr) {
+		return false, nil, fmt.Errorf("ReFS: node record array out of range (%d records at %d)", count, arr)
+	}
+	var recs []refsRecord
+	for i := 0; i < count; i++ {
+		off := int(binary.LittleEndian.Uint32(hdr[arr+4*i:]) & 0xFFFF)
+		if off+14 > len(hdr) {
+			return false, nil, fmt.Errorf("ReFS: node record %d out of range", i)
+		}
+		rec := hdr[off:]
+		size := int(binary.LittleEndian.Uint32(rec))
+		if size < 14 || size > len(rec) {
+			return false, nil, fmt.Errorf("ReFS: node record %d has bad size %d", i, size)
+		}
+		rec = rec[:size]
+		if binary.LittleEndian.Uint16(rec[8:])&refsRecordDeleted != 0 {
+			continue
+		}
+		ko, ks := int(binary.LittleEndian.Uint16(rec[4:])), int(binary.LittleEndian.Uint16(rec[6:]))
+		vo, vs := int(binary.LittleEndian.Uint16(rec[10:])), int(binary.LittleEndian.Uint16(rec[12:]))
+		if ko+ks > size || vo+vs > size {
+			return false, nil, fmt.Errorf("ReFS: node record %d key/value out of range", i)
+		}
+		recs = append(recs, refsRecord{key: rec[ko : ko+ks], value: rec[vo : vo+vs]})
+	}
+	return branch, recs, nil
+}
+
+// walkTree visits every leaf record of the tree rooted at ref, in node order.
+func (r *ReFS) walkTree(ref blockRef, depth int, fn func(key, value []byte) error) error {
+	if depth > refsMaxTreeDepth {
+		return fmt.Errorf("ReFS: tree deeper than %d levels", refsMaxTreeDepth)
+	}
+	buf, err := r.readMetadata(ref, "MSB+")
+	if err != nil {
+		return err
+	}
+	return r.walkNode(buf[r.headerSize():], depth, fn)
+}
+
+// walkNode visits the leaf records under a parsed node; branch records are
+// child references.
+func (r *ReFS) walkNode(b []byte, depth int, fn func(key, value []byte) error) error {
+	branch, recs, err := parseNode(b)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if !branch {
+			if err := fn(rec.key, rec.value); err != nil {
+				return err
+			}
+			continue
+		}
+		child, err := r.parseRef(rec.value)
+		if err != nil {
+			return err
+		}
+		if err := r.walkTree(child, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- directories and files ---
+
+// filetimeToUnix converts a Windows FILETIME (100 ns ticks since 1601-01-01)
+// to Unix seconds. Zero and negative values are left as 0.
+func filetimeToUnix(ft int64) int64 {
+	if ft <= 0 {
+		return 0
+	}
+	return ft/10_000_000 - 11644473600
+}
+
+func u64(b []byte, off int) uint64 {
+	if off+8 > len(b) {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b[off:])
+}
+
+// readDirectory parses the name records of directory object oid.
+func (r *ReFS) readDirectory(oid uint64) ([]refsEntry, error) {
+	ref, ok := r.objects[oid]
+	if !ok {
+		return nil, fmt.Errorf("ReFS: directory object 0x%X not in the object table: %w", oid, filesystem.ErrNotFound)
+	}
+	var out []refsEntry
+	err := r.walkTree(ref, 0, func(key, value []byte) error {
+		if len(key) < 4 || binary.LittleEndian.Uint16(key) != refsKeyName {
+			return nil // not a name record
+		}
+		u := make([]uint16, (len(key)-4)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(key[4+2*i:])
+		}
+		e := refsEntry{name: string(utf16.Decode(u))}
+		switch binary.LittleEndian.Uint16(key[2:]) {
+		case refsNameDirectory:
+			if len(value) < 52 {
+				return fmt.Errorf("ReFS: directory record %q too short", e.name)
+			}
+			e.isDir = true
+			e.objectID = u64(value, 8)
+			e.created = filetimeToUnix(int64(u64(value, 16)))
+			e.modified = filetimeToUnix(int64(u64(value, 24)))
+			e.accessed = filetimeToUnix(int64(u64(value, 40)))
+			e.attrs = binary.LittleEndian.Uint32(value[48:])
+		case refsNameFile:
+			if err := r.parseFileTable(&e, value); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		out = append(out, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// parseFileTable reads the metadata record (0x10) of an embedded file table.
+func (r *ReFS) parseFileTable(e *refsEntry, table []byte) error {
+	e.file = table
+	found := false
+	err := r.walkNode(table, 0, func(key, value []byte) error {
+		if len(key) < 4 || binary.LittleEndian.Uint32(key) != refsKeyFileInfo {
+			return nil
+		}
+		if len(value) < 0x48 {
+			return fmt.Errorf("ReFS: file record %q metadata too short", e.name)
+		}
+		e.created = filetimeToUnix(int64(u64(value, 0)))
+		e.modified = filetimeToUnix(int64(u64(value, 8)))
+		e.accessed = filetimeToUnix(int64(u64(value, 24)))
+		e.attrs = binary.LittleEndian.Uint32(value[0x20:])
+		e.fileID = u64(value, 0x28)
+		e.size = u64(value, 0x40)
+		found = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("ReFS: file record %q has no metadata record", e.name)
+	}
+	return nil
+}
+
+// refsExtent maps a run of file clusters to volume clusters.
+type refsExtent struct {
+	vcn, lcn, count uint64
+}
+
+// fileExtents collects the data-stream extents of a file table, translating
+// v3 virtual clusters through the container table.
+func (r *ReFS) fileExtents(e *refsEntry) ([]refsExtent, error) {
+	var exts []refsExtent
+	err := r.walkNode(e.file, 0, func(key, value []byte) error {
+		if len(key) < 4 || binary.LittleEndian.Uint32(key) != refsKeyDataStream {
+			return nil
+		}
+		return r.walkNode(value, 0, func(k, v []byte) error {
+			if len(k) < 8 || len(v) < 16 {
+				return fmt.Errorf("ReFS: %q extent record too short", e.name)
+			}
+			ext := refsExtent{vcn: binary.LittleEndian.Uint64(k), count: u64(v, 0), lcn: u64(v, 8)}
+			lcn, err := r.physical(ext.lcn, ext.count)
+			if err != nil {
+				return fmt.Errorf("ReFS: %q: %w", e.name, err)
+			}
+			ext.lcn = lcn
+			exts = append(exts, ext)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(exts, func(i, j int) bool { return exts[i].vcn < exts[j].vcn })
+	return exts, nil
+}
+
+// physical translates a v3 virtual cluster run to its physical start cluster.
+// A run may not cross a container boundary.
+func (r *ReFS) physical(lcn, count uint64) (uint64, error) {
+	if r.containers == nil {
+		return lcn, nil
+	}
+	per := r.containerSize / uint64(r.clusterSize)
+	if per == 0 {
+		return 0, fmt.Errorf("container size %d smaller than a cluster", r.containerSize)
+	}
+	c, off := lcn/per, lcn%per
+	if off+count > per {
+		return 0, fmt.Errorf("extent at virtual cluster %d crosses container %d", lcn, c)
+	}
+	start, ok := r.containers[c]
+	if !ok {
+		return 0, fmt.Errorf("virtual cluster %d is in unmapped container %d", lcn, c)
+	}
+	return start + off, nil
+}
+
+// splitPath splits a path into its non-empty components.
+func splitPath(path string) []string {
+	var out []string
+	for _, c := range strings.Split(path, "/") {
+		if c != "" && c != "." {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// resolve walks path from the root directory. Names compare
+// case-insensitively, as on Windows. The root resolves to a directory entry
+// with object id 0x600. parent is the object id of the containing directory.
+func (r *ReFS) resolve(path string) (e refsEntry, parent uint64, err error) {
+	cur := refsEntry{isDir: true, objectID: refsRootDirectory}
+	parent = refsRootDirectory
+	for _, comp := range splitPath(path) {
+		if !cur.isDir {
+			return refsEntry{}, 0, fmt.Errorf("ReFS: %q is not a directory: %w", cur.name, filesystem.ErrNotDirectory)
+		}
+		entries, err := r.readDirectory(cur.objectID)
+		if err != nil {
+			return refsEntry{}, 0, err
+		}
+		found := false
+		for _, c := range entries {
+			if strings.EqualFold(c.name, comp) {
+				parent, cur, found = cur.objectID, c, true
+				break
+			}
+		}
+		if !found {
+			return refsEntry{}, 0, fmt.Errorf("ReFS: %q: %w", comp, filesystem.ErrNotFound)
+		}
+	}
+	return cur, parent, nil
+}
+
+// inodeOf packs the handle OpenInode takes: the containing directory's
+// object id in the high 32 bits and the file id in the low 32 bits. 0 when
+// either does not fit.
+func inodeOf(parent uint64, e refsEntry) uint64 {
+	id := e.fileID
+	if e.isDir {
+		id = e.objectID
+	}
+	if parent > 0xFFFFFFFF || id > 0xFFFFFFFF {
+		return 0
+	}
+	return parent<<32 | id
+}
+
+// ListDirectory lists a directory path. "" and "/" both denote the root.
+// DirectoryEntry.Inode is the OpenInode handle (see inodeOf).
+func (r *ReFS) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
+	if r.readFunc == nil || r.objects == nil {
+		return nil, fmt.Errorf("ReFS: directory parsing requires a reader")
+	}
+	dir, _, err := r.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.isDir {
+		return nil, fmt.Errorf("ReFS: %q is not a directory: %w", path, filesystem.ErrNotDirectory)
+	}
+	entries, err := r.readDirectory(dir.objectID)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := ""
+	if path != "" && path != "/" {
+		dirPath = "/" + strings.Trim(path, "/")
+	}
+	out := make([]filesystem.DirectoryEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, filesystem.DirectoryEntry{
+			Name:       e.name,
+			Path:       filesystem.JoinPath(dirPath, e.name),
+			Size:       e.size,
+			IsDir:      e.isDir,
+			ModTime:    e.modified,
+			AccessTime: e.accessed,
+			CreateTime: e.created,
+			Inode:      inodeOf(dir.objectID, e),
+		})
+	}
+	return out, nil
+}
+
+// GetFile reads a file's contents by path.
+func (r *ReFS) GetFile(path string) ([]byte, error) {
+	if r.readFunc == nil || r.objects == nil {
+		return nil, fmt.Errorf("ReFS: file reading requires a reader")
+	}
+	e, _, err := r.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir {
+		return nil, fmt.Errorf("ReFS: %q: %w", path, filesystem.ErrIsDirectory)
+	}
+	f, err := r.openEntry(&e)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, f.size)
+	if _, err := f.ReadAt(buf, 0); err != nil && f.size > 0 {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// GetFileByPath returns metadata for a path.
+func (r *ReFS) GetFileByPath(path string) (*filesystem.FileInfo, error) {
+	if r.readFunc == nil || r.objects == nil {
+		return nil, fmt.Errorf("ReFS: file lookup requires a reader")
+	}
+	e, _, err := r.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(e, "/"+strings.Trim(path, "/")), nil
+}
+
+func infoOf(e refsEntry, path string) *filesystem.FileInfo {
+	mode := filesystem.ModeRegular
+	if e.isDir {
+		mode = filesystem.ModeDir
+	}
+	return &filesystem.FileInfo{
+		Name:       path[strings.LastIndex(path, "/")+1:],
+		Path:       path,
+		Size:       e.size,
+		Mode:       mode,
+		IsDir:      e.isDir,
+		ModTime:    e.modified,
+		AccessTime: e.accessed,
+		CreateTime: e.created,
+		IsHidden:   e.attrs&refsAttrHidden != 0,
+		IsSystem:   e.attrs&refsAttrSystem != 0,
+		IsReadOnly: e.attrs&refsAttrReadOnly != 0,
+	}
+}
+
+// SearchFiles walks the directory tree under rootPath and returns every
+// FileInfo for which predicate returns true.
+func (r *ReFS) SearchFiles(rootPath string, predicate func(filesystem.FileInfo) bool) ([]filesystem.FileInfo, error) {
+	if r.readFunc == nil || r.objects == nil {
+		return nil, fmt.Errorf("ReFS: search requires a reader")
+	}
+	start, _, err := r.resolve(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !start.isDir {
+		return nil, fmt.Errorf("ReFS: %q is not a directory: %w", rootPath, filesystem.ErrNotDirectory)
+	}
+	base := ""
+	if rootPath != "" && rootPath != "/" {
+		base = "/" + strings.Trim(rootPath, "/")
+	}
+	results := make([]filesystem.FileInfo, 0)
+	visited := make(map[uint64]bool)
+	var walk func(oid uint64, dirPath string, depth int) error
+	walk = func(oid uint64, dirPath string, depth int) error {
+		if depth > refsMaxSearchDepth || visited[oid] {
+			return nil
+		}
+		visited[oid] = true
+		entries, err := r.readDirectory(oid)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if len(results) >= refsMaxSearchCount {
+				return fmt.Errorf("ReFS: search exceeded %d results", refsMaxSearchCount)
+			}
+			fi := infoOf(e, filesystem.JoinPath(dirPath, e.name))
+			if predicate(*fi) {
+				results = append(results, *fi)
+			}
+			if e.isDir {
+				if err := walk(e.objectID, fi.Path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(start.objectID, base, 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,337 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/refs"
+)
+
+// memReFSReader is a fake Reader over an in-memory ReFS volume.
+type memReFSReader struct {
+	data []byte
+}
+
+func (r *memReFSReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start := lba * 512
+	end := start + count*512
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("refs: read past end of image")
+	}
+	return r.data[start:end], nil
+}
+
+// fakeReFS lays out a synthetic ReFS volume following the layout documented
+// in internal/filesystem/refs: v1 (16 KiB units, 64 KiB clusters) or v3
+// (4 KiB clusters, 16 KiB metadata blocks of four clusters, containers).
+type fakeReFS struct {
+	v3      bool
+	img     []byte
+	unit    int // bytes per metadata block-number unit
+	cluster int
+	next    uint64 // next free metadata block number
+}
+
+const fakeReFSBlock = 16 * 1024
+
+func newFakeReFS(v3 bool, size int) *fakeReFS {
+	f := &fakeReFS{v3: v3, img: make([]byte, size), unit: fakeReFSBlock, cluster: 64 * 1024, next: 34}
+	if v3 {
+		f.unit, f.cluster = 4096, 4096
+	}
+	vbr := f.img[:512]
+	copy(vbr[3:], "ReFS\x00\x00\x00\x00")
+	copy(vbr[16:], "FSRS")
+	binary.LittleEndian.PutUint64(vbr[24:], uint64(size/512))
+	binary.LittleEndian.PutUint32(vbr[32:], 512)
+	binary.LittleEndian.PutUint32(vbr[36:], uint32(f.cluster/512))
+	if v3 {
+		vbr[40], vbr[41] = 3, 4
+		binary.LittleEndian.PutUint64(vbr[64:], 16*4096) // 16-cluster containers
+	} else {
+		vbr[40], vbr[41] = 1, 2
+	}
+	binary.LittleEndian.PutUint64(vbr[56:], 0x1122334455667788)
+	return f
+}
+
+func (f *fakeReFS) headerSize() int {
+	if f.v3 {
+		return 0x50
+	}
+	return 0x30
+}
+
+func (f *fakeReFS) alloc() uint64 {
+	n := f.next
+	f.next += uint64(fakeReFSBlock / f.unit)
+	return n
+}
+
+// block writes a metadata block (header + body) at block number n and
+// returns a reference to it carrying a CRC64 checksum.
+func (f *fakeReFS) block(n uint64, sig string, seq uint64, body []byte) []byte {
+	b := f.img[int(n)*f.unit : int(n)*f.unit+fakeReFSBlock]
+	if f.v3 {
+		copy(b[0:], sig)
+		binary.LittleEndian.PutUint64(b[16:], seq)
+		for i := 0; i < 4; i++ {
+			binary.LittleEndian.PutUint64(b[32+8*i:], n+uint64(i))
+		}
+	} else {
+		binary.LittleEndian.PutUint64(b[0:], n)
+		binary.LittleEndian.PutUint64(b[8:], seq)
+	}
+	copy(b[f.headerSize():], body)
+	return f.ref(n, crc64.Checksum(b, crc64.MakeTable(crc64.ECMA)))
+}
+
+// ref encodes a block reference with a CRC64 checksum.
+func (f *fakeReFS) ref(n uint64, sum uint64) []byte {
+	if !f.v3 {
+		r := make([]byte, 24)
+		binary.LittleEndian.PutUint64(r[0:], n)
+		r[10], r[11] = 2, 16
+		binary.LittleEndian.PutUint16(r[12:], 8)
+		binary.LittleEndian.PutUint64(r[16:], sum)
+		return r
+	}
+	r := make([]byte, 48)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(r[8*i:], n+uint64(i))
+	}
+	r[34], r[35] = 2, 40
+	binary.LittleEndian.PutUint16(r[36:], 8)
+	binary.LittleEndian.PutUint64(r[40:], sum)
+	return r
+}
+
+// refsNode encodes a Minstore node: a prefix, the node header, the records
+// and the record-offset array.
+func refsNode(branch bool, recs [][2][]byte) []byte {
+	const prefix = 8
+	hdr := make([]byte, 32)
+	if branch {
+		hdr[13] = 0x01
+	}
+	var data []byte
+	var offs []uint32
+	for _, kv := range recs {
+		r := make([]byte, 14)
+		binary.LittleEndian.PutUint32(r[0:], uint32(14+len(kv[0])+len(kv[1])))
+		binary.LittleEndian.PutUint16(r[4:], 14)
+		binary.LittleEndian.PutUint16(r[6:], uint16(len(kv[0])))
+		binary.LittleEndian.PutUint16(r[10:], uint16(14+len(kv[0])))
+		binary.LittleEndian.PutUint16(r[12:], uint16(len(kv[1])))
+		offs = append(offs, uint32(32+len(data)))
+		data = append(data, append(append(r, kv[0]...), kv[1]...)...)
+	}
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(32+len(data)))
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(len(offs)))
+	out := make([]byte, prefix)
+	binary.LittleEndian.PutUint32(out, prefix)
+	out = append(append(out, hdr...), data...)
+	for _, o := range offs {
+		out = binary.LittleEndian.AppendUint32(out, o)
+	}
+	return out
+}
+
+func refsNameKey(kind uint16, name string) []byte {
+	k := []byte{0x30, 0, byte(kind), 0}
+	for _, u := range utf16.Encode([]rune(name)) {
+		k = binary.LittleEndian.AppendUint16(k, u)
+	}
+	return k
+}
+
+func refsU64(v ...uint64) []byte {
+	var b []byte
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint64(b, x)
+	}
+	return b
+}
+
+// refsFile encodes a file's embedded table: its metadata record and a data
+// stream of extents {vcn, count, lcn}.
+func refsFile(id, size uint64, exts ...[3]uint64) []byte {
+	info := make([]byte, 0x48)
+	binary.LittleEndian.PutUint64(info[8:], (11644473600+7200)*10_000_000) // modified
+	binary.LittleEndian.PutUint64(info[0x28:], id)
+	binary.LittleEndian.PutUint64(info[0x40:], size)
+	var runs [][2][]byte
+	for _, e := range exts {
+		runs = append(runs, [2][]byte{refsU64(e[0]), refsU64(e[1], e[2])})
+	}
+	return refsNode(false, [][2][]byte{
+		{refsU64(0x10)[:4], info},
+		{refsU64(0x80)[:4], refsNode(false, runs)},
+	})
+}
+
+func refsDir(oid uint64) []byte {
+	v := make([]byte, 52)
+	binary.LittleEndian.PutUint64(v[8:], oid)
+	binary.LittleEndian.PutUint32(v[48:], 0x10)
+	return v
+}
+
+// buildFakeReFS builds a volume with /hello.txt, a sparse two-extent
+// /sparse.bin and /Docs/note.txt. Two checkpoints exist; the stale one points
+// at an object table that would not validate. The object table is a branch
+// node over two leaves. On v3, file clusters are virtual: container 5 maps to
+// physical cluster 96.
+func buildFakeReFS(v3 bool) (*memReFSReader, []byte) {
+	size := 2 << 20
+	f := newFakeReFS(v3, size)
+	cl := func(n uint64) []byte { return f.img[int(n)*f.cluster : int(n+1)*f.cluster] }
+
+	// Data clusters: virtual (v3) or physical (v1) cluster numbers.
+	hello, sparseA, sparseB, note := uint64(12), uint64(13), uint64(14), uint64(16)
+	phys := func(v uint64) uint64 { return v }
+	if v3 {
+		hello, sparseA, sparseB, note = 82, 80, 83, 86
+		phys = func(v uint64) uint64 { return v - 80 + 96 }
+	}
+	copy(cl(phys(hello)), "hello, refs\n")
+	sparse := make([]byte, 3*f.cluster+100)
+	for i := range sparse {
+		sparse[i] = byte(i%251 + 1)
+	}
+	clear(sparse[f.cluster : 2*f.cluster]) // cluster 1 is a hole
+	copy(cl(phys(sparseA)), sparse[:f.cluster])
+	copy(f.img[int(phys(sparseB))*f.cluster:], sparse[2*f.cluster:])
+	copy(cl(phys(note)), "note\n")
+
+	docs := f.block(f.alloc(), "MSB+", 1, refsNode(false, [][2][]byte{
+		{refsNameKey(1, "note.txt"), refsFile(3, 5, [3]uint64{0, 1, note})},
+	}))
+	root := f.block(f.alloc(), "MSB+", 1, refsNode(false, [][2][]byte{
+		{refsNameKey(2, "Docs"), refsDir(0x701)},
+		{refsNameKey(1, "hello.txt"), refsFile(1, 12, [3]uint64{0, 1, hello})},
+		{refsNameKey(1, "sparse.bin"), refsFile(2, uint64(len(sparse)),
+			[3]uint64{0, 1, sparseA}, [3]uint64{2, 2, sparseB})},
+		{[]byte{0x10, 0, 0, 0}, []byte("directory metadata")},
+	}))
+	leafA := f.block(f.alloc(), "MSB+", 1, refsNode(false, [][2][]byte{{refsU64(0, 0x600), root}}))
+	leafB := f.block(f.alloc(), "MSB+", 1, refsNode(false, [][2][]byte{{refsU64(0, 0x701), docs}}))
+	objects := f.block(f.alloc(), "MSB+", 1, refsNode(true, [][2][]byte{
+		{refsU64(0, 0x600), leafA},
+		{refsU64(0, 0x701), leafB},
+	}))
+	containers := f.block(f.alloc(), "MSB+", 1, refsNode(false, [][2][]byte{{refsU64(5), refsU64(96)}}))
+
+	checkpoint := func(n uint64, seq uint64, objRef []byte) {
+		body := make([]byte, 0x400)
+		at := 0x18
+		if v3 {
+			at = 0x40
+		}
+		refsList := [][]byte{objRef, nil, nil, nil, nil, nil, nil, containers}
+		binary.LittleEndian.PutUint32(body[at:], uint32(len(refsList)))
+		pos := 0x200
+		for i, r := range refsList {
+			binary.LittleEndian.PutUint32(body[at+4+4*i:], uint32(f.headerSize()+pos))
+			if r == nil {
+				r = f.ref(0, 0) // unused table
+			}
+			copy(body[pos:], r)
+			pos += len(r)
+		}
+		f.block(n, "CHKP", seq, body)
+	}
+	cpOld, cpNew := f.alloc(), f.alloc()
+	bogus := f.ref(binary.LittleEndian.Uint64(objects), 0xDEAD)
+	checkpoint(cpOld, 5, bogus)
+	checkpoint(cpNew, 9, objects)
+
+	sb := make([]byte, 0x100)
+	binary.LittleEndian.PutUint32(sb[32:], uint32(f.headerSize()+0x80))
+	binary.LittleEndian.PutUint32(sb[36:], 2)
+	copy(sb[0x80:], refsU64(cpOld, cpNew))
+	f.block(30, "SUPB", 1, sb)
+	return &memReFSReader{data: f.img}, sparse
+}
+
+func TestReFSReader(t *testing.T) {
+	for _, v3 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("v3=%v", v3), func(t *testing.T) {
+			r, sparse := buildFakeReFS(v3)
+			fs, err := filesystem.NewHandler(filesystem.FS_REFS, r, 0, uint64(len(r.data)))
+			if err != nil {
+				t.Fatalf("NewHandler(ReFS): %v", err)
+			}
+			h := fs.(*refs.ReFS)
+
+			entries, err := h.ListDirectory("/")
+			if err != nil {
+				t.Fatalf("ListDirectory(/): %v", err)
+			}
+			if len(entries) != 3 || entries[0].Name != "Docs" || !entries[0].IsDir ||
+				entries[1].Name != "hello.txt" || entries[1].Size != 12 || entries[1].ModTime != 7200 ||
+				entries[2].Name != "sparse.bin" {
+				t.Fatalf("root listing = %+v", entries)
+			}
+			for path, want := range map[string][]byte{
+				"/hello.txt":     []byte("hello, refs\n"),
+				"/HELLO.TXT":     []byte("hello, refs\n"),
+				"/docs/note.txt": []byte("note\n"),
+				"/sparse.bin":    sparse,
+			} {
+				if got, err := h.GetFile(path); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("GetFile(%q) = %d bytes, %v; want %d bytes", path, len(got), err, len(want))
+				}
+			}
+
+			f, err := h.OpenInode(entries[2].Inode, 0)
+			if err != nil {
+				t.Fatalf("OpenInode(sparse.bin): %v", err)
+			}
+			buf := make([]byte, 2*len(sparse))
+			n, err := f.(io.ReaderAt).ReadAt(buf, 10)
+			if n != len(sparse)-10 || err != io.EOF || !bytes.Equal(buf[:n], sparse[10:]) {
+				t.Errorf("ReadAt(10) = %d, %v; want %d bytes and io.EOF", n, err, len(sparse)-10)
+			}
+
+			if _, err := h.OpenFile("/Docs"); !errors.Is(err, filesystem.ErrIsDirectory) {
+				t.Errorf("OpenFile(/Docs) = %v, want ErrIsDirectory", err)
+			}
+			if _, err := h.GetFile("/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+				t.Errorf("GetFile(/missing) = %v, want ErrNotFound", err)
+			}
+			found, err := h.SearchFiles("/", func(fi filesystem.FileInfo) bool { return !fi.IsDir })
+			if err != nil || len(found) != 3 {
+				t.Errorf("SearchFiles(files) = %+v, %v; want 3 files", found, err)
+			}
+		})
+	}
+}
+
+// TestReFSChecksumMismatch pins that a metadata block whose checksum does not
+// match its reference fails to mount instead of being parsed.
+func TestReFSChecksumMismatch(t *testing.T) {
+	r, _ := buildFakeReFS(true)
+	// Corrupt a byte inside the object table branch node.
+	corrupted := 0
+	for i := 34; i < 80; i += 4 {
+		b := r.data[i*4096:]
+		if string(b[:4]) == "MSB+" && b[0x50+8+13] == 0x01 {
+			b[0x200] ^= 0xFF
+			corrupted++
+		}
+	}
+	if corrupted != 1 {
+		t.Fatalf("found %d object table blocks, want 1", corrupted)
+	}
+	if _, err := filesystem.NewHandler(filesystem.FS_REFS, r, 0, uint64(len(r.data))); err == nil {
+		t.Fatal("NewHandler succeeded on a corrupted object table")
+	}
+}
@@ -15,9 +15,14 @@ import (
 	_ "github.com/laenix/ewfgo/internal/filesystem/detect"
 	_ "github.com/laenix/ewfgo/internal/filesystem/exfat"
 	_ "github.com/laenix/ewfgo/internal/filesystem/ext4"
+	_ "github.com/laenix/ewfgo/internal/filesystem/f2fs"
 	_ "github.com/laenix/ewfgo/internal/filesystem/fat"
+	_ "github.com/laenix/ewfgo/internal/filesystem/hfsplus"
 	_ "github.com/laenix/ewfgo/internal/filesystem/ntfs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/refs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/squashfs"
 	_ "github.com/laenix/ewfgo/internal/filesystem/xfs"
+	_ "github.com/laenix/ewfgo/internal/filesystem/zfs"
 )
 
 // --- 解析红线 sweep (no fabricated data) ---
@@ -107,11 +112,13 @@ func TestDetectBtrfsRejectsLegacyOffsets(t *testing.T) {
 
 // TestDetectExt4LittleEndianMagic pins the consolidated path still detects
 // ext4 via the little-endian s_magic (0xEF53) at offset 0x38 within the
-// superblock (byte 1024 for 1K-block filesystems).
+// superblock (byte 1024 for 1K-block filesystems); INCOMPAT_EXTENTS makes it
+// ext4 rather than ext2.
 func TestDetectExt4LittleEndianMagic(t *testing.T) {
 	buf := make([]byte, 2048)
 	buf[1024+0x38] = 0x53
 	buf[1024+0x39] = 0xEF
+	buf[1024+0x60] = 0x40
 	if got := filesystem.DetectFileSystem(buf); got != filesystem.FS_EXT4 {
 		t.Fatalf("DetectFileSystem ext4 = %s, want %s", got, filesystem.FS_EXT4)
 	}
@@ -154,6 +161,7 @@ func TestExt4ReaderlessHonestError(t *testing.T) {
 func TestDetectAndOpen_Ext4Magic(t *testing.T) {
 	sb := make([]byte, 2048) // cover superblock at byte 1024
 	binary.LittleEndian.PutUint16(sb[1024+0x38:], 0xEF53) // ext4 s_magic
+	binary.LittleEndian.PutUint32(sb[1024+0x60:], 0x40)   // INCOMPAT_EXTENTS
 
 	fs, err := filesystem.DetectAndOpen(sb)
 	if err != nil {
@@ -0,0 +1,362 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// dentry is one directory entry: a name and the inode it references.
+type dentry struct {
+	name   string
+	ref    uint64
+	number uint32
+	typ    uint16 // basic inode type
+}
+
+// readDir parses a directory's listing from the directory table. The
+// listing's size counts three bytes for the "." and ".." entries that are
+// not stored.
+func (s *SquashFS) readDir(dir *inode) ([]dentry, error) {
+	if !dir.isDir() {
+		return nil, fmt.Errorf("SquashFS: inode %d is not a directory: %w", dir.number, filesystem.ErrNotDirectory)
+	}
+	if dir.dirSize <= 3 {
+		return nil, nil
+	}
+	c := &metaCursor{s: s, pos: s.dirTable + uint64(dir.dirBlock), off: int(dir.dirOffset)}
+	if c.pos >= s.bytesUsed {
+		return nil, fmt.Errorf("SquashFS: directory inode %d listing outside the image", dir.number)
+	}
+	remaining := int(dir.dirSize) - 3
+	le := binary.LittleEndian
+	var out []dentry
+	for remaining > 0 {
+		if remaining < 12 {
+			return nil, fmt.Errorf("SquashFS: directory inode %d listing ends inside a header", dir.number)
+		}
+		h, err := c.read(12)
+		if err != nil {
+			return nil, fmt.Errorf("SquashFS: directory inode %d: %w", dir.number, err)
+		}
+		remaining -= 12
+		count := le.Uint32(h) + 1
+		block := le.Uint32(h[4:])
+		base := le.Uint32(h[8:])
+		if count > sqMaxDirEntries {
+			return nil, fmt.Errorf("SquashFS: directory inode %d header claims %d entries", dir.number, count)
+		}
+		for i := uint32(0); i < count; i++ {
+			if remaining < 8 {
+				return nil, fmt.Errorf("SquashFS: directory inode %d listing ends inside an entry", dir.number)
+			}
+			e, err := c.read(8)
+			if err != nil {
+				return nil, fmt.Errorf("SquashFS: directory inode %d: %w", dir.number, err)
+			}
+			nameLen := int(le.Uint16(e[6:])) + 1
+			remaining -= 8 + nameLen
+			if remaining < 0 {
+				return nil, fmt.Errorf("SquashFS: directory inode %d entry name overruns the listing", dir.number)
+			}
+			name, err := c.read(nameLen)
+			if err != nil {
+				return nil, fmt.Errorf("SquashFS: directory inode %d: %w", dir.number, err)
+			}
+			out = append(out, dentry{
+				name:   string(name),
+				ref:    uint64(block)<<16 | uint64(le.Uint16(e)),
+				number: uint32(int64(base) + int64(int16(le.Uint16(e[2:])))),
+				typ:    le.Uint16(e[4:]),
+			})
+		}
+	}
+	return out, nil
+}
+
+// lookup finds name in dir. Listings are sorted, but a linear scan also
+// copes with images whose order is damaged.
+func (s *SquashFS) lookup(dir *inode, name string) (dentry, error) {
+	entries, err := s.readDir(dir)
+	if err != nil {
+		return dentry{}, err
+	}
+	for _, d := range entries {
+		if d.name == name {
+			return d, nil
+		}
+	}
+	return dentry{}, fmt.Errorf("SquashFS: %q: %w", name, filesystem.ErrNotFound)
+}
+
+// resolve walks path from the root directory. Names compare exactly.
+func (s *SquashFS) resolve(path string) (*inode, error) {
+	cur, err := s.readInode(s.rootRef)
+	if err != nil {
+		return nil, err
+	}
+	for _, comp := range strings.Split(path, "/") {
+		if comp == "" || comp == "." {
+			continue
+		}
+		d, err := s.lookup(cur, comp)
+		if err != nil {
+			return nil, err
+		}
+		if cur, err = s.readInode(d.ref); err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+func cleanPath(path string) string {
+	if path == "" || path == "/" {
+		return ""
+	}
+	return "/" + strings.Trim(path, "/")
+}
+
+// ListDirectory lists a directory path. "" and "/" both denote the root.
+// DirectoryEntry.Inode is the entry's inode number.
+func (s *SquashFS) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: directory parsing requires a reader")
+	}
+	dir, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := cleanPath(path)
+	out := make([]filesystem.DirectoryEntry, 0, len(entries))
+	for _, d := range entries {
+		e := filesystem.DirectoryEntry{
+			Name:  d.name,
+			Path:  filesystem.JoinPath(dirPath, d.name),
+			IsDir: d.typ == typeDir,
+			Inode: uint64(d.number),
+		}
+		// The entry carries no size or time; read them from the inode.
+		if in, err := s.readInode(d.ref); err == nil {
+			e.Size, e.IsDir, e.ModTime = in.size, in.isDir(), in.mtime
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// GetFile reads a file's contents by path. A symlink's contents are its
+// target.
+func (s *SquashFS) GetFile(path string) ([]byte, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: file reading requires a reader")
+	}
+	in, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	switch in.baseType() {
+	case typeDir:
+		return nil, fmt.Errorf("SquashFS: %q: %w", path, filesystem.ErrIsDirectory)
+	case typeSymlink:
+		return []byte(in.target), nil
+	case typeFile:
+	default:
+		return nil, fmt.Errorf("SquashFS: %q is a special file: %w", path, filesystem.ErrUnsupported)
+	}
+	r, err := s.openData(in)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, r.size)
+	if _, err := r.ReadAt(buf, 0); err != nil && r.size > 0 {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// GetFileByPath returns metadata for a path.
+func (s *SquashFS) GetFileByPath(path string) (*filesystem.FileInfo, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: file lookup requires a reader")
+	}
+	in, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(in, cleanPath(path)), nil
+}
+
+func infoOf(in *inode, path string) *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:       path[strings.LastIndex(path, "/")+1:],
+		Path:       path,
+		Size:       in.size,
+		Mode:       in.fileMode(),
+		IsDir:      in.isDir(),
+		ModTime:    in.mtime,
+		IsReadOnly: true,
+	}
+}
+
+// SearchFiles walks the directory tree under rootPath and returns every
+// FileInfo for which predicate returns true. Depth and result count are
+// bounded.
+func (s *SquashFS) SearchFiles(rootPath string, predicate func(filesystem.FileInfo) bool) ([]filesystem.FileInfo, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: search requires a reader")
+	}
+	start, err := s.resolve(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !start.isDir() {
+		return nil, fmt.Errorf("SquashFS: %q is not a directory: %w", rootPath, filesystem.ErrNotDirectory)
+	}
+	results := make([]filesystem.FileInfo, 0)
+	visited := make(map[uint64]bool)
+	var walk func(dir *inode, dirPath string, depth int) error
+	walk = func(dir *inode, dirPath string, depth int) error {
+		if depth > sqMaxSearchDepth || visited[dir.ref] {
+			return nil
+		}
+		visited[dir.ref] = true
+		entries, err := s.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, d := range entries {
+			if len(results) >= sqMaxSearchCount {
+				return fmt.Errorf("SquashFS: search exceeded %d results", sqMaxSearchCount)
+			}
+			in, err := s.readInode(d.ref)
+			if err != nil {
+				return err
+			}
+			fi := infoOf(in, filesystem.JoinPath(dirPath, d.name))
+			if predicate(*fi) {
+				results = append(results, *fi)
+			}
+			if in.isDir() {
+				if err := walk(in, fi.Path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(start, cleanPath(rootPath), 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Owner returns the numeric uid and gid of path, resolved through the ID
+// table.
+func (s *SquashFS) Owner(path string) (uid, gid uint32, err error) {
+	if s.readFunc == nil || s.meta == nil {
+		return 0, 0, fmt.Errorf("SquashFS: owner lookup requires a reader")
+	}
+	in, err := s.resolve(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if uid, err = s.id(in.uid); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = s.id(in.gid); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// xattrPrefixes are the namespace prefixes of the xattr type field.
+var xattrPrefixes = [...]string{"user.", "trusted.", "security."}
+
+const xattrValueOOL = 0x100 // the value is a reference to a shared value
+
+// Xattrs returns the extended attributes of path keyed by full name
+// ("user.foo", "security.selinux"). A path without attributes yields an
+// empty map.
+func (s *SquashFS) Xattrs(path string) (map[string][]byte, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: xattr lookup requires a reader")
+	}
+	in, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	if in.xattr == sqInvalidXattr {
+		return out, nil
+	}
+	if s.xattrTable == sqInvalidTable {
+		return nil, fmt.Errorf("SquashFS: inode %d has xattr index %d but the image has no xattr table", in.number, in.xattr)
+	}
+	le := binary.LittleEndian
+	hdr, err := s.readBytes(s.xattrTable, 16)
+	if err != nil {
+		return nil, err
+	}
+	kvStart, ids := le.Uint64(hdr), le.Uint32(hdr[8:])
+	if in.xattr >= ids {
+		return nil, fmt.Errorf("SquashFS: xattr index %d beyond the %d-entry xattr ID table", in.xattr, ids)
+	}
+	id, err := s.tableEntry(s.xattrTable+16, uint64(in.xattr), 16)
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: xattr ID table: %w", err)
+	}
+	ref, count := le.Uint64(id), le.Uint32(id[8:])
+	c := &metaCursor{s: s, pos: kvStart + ref>>16, off: int(ref & 0xFFFF)}
+	value := func(c *metaCursor) ([]byte, error) {
+		b, err := c.read(4)
+		if err != nil {
+			return nil, err
+		}
+		n := le.Uint32(b)
+		if n > 1<<16 {
+			return nil, fmt.Errorf("SquashFS: xattr value of %d bytes", n)
+		}
+		v, err := c.read(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), v...), nil
+	}
+	for i := uint32(0); i < count; i++ {
+		b, err := c.read(4)
+		if err != nil {
+			return nil, fmt.Errorf("SquashFS: xattrs of inode %d: %w", in.number, err)
+		}
+		typ, nameLen := le.Uint16(b), int(le.Uint16(b[2:]))
+		if int(typ&0xFF) >= len(xattrPrefixes) {
+			return nil, fmt.Errorf("SquashFS: inode %d has xattr of unknown namespace %d", in.number, typ&0xFF)
+		}
+		name, err := c.read(nameLen)
+		if err != nil {
+			return nil, fmt.Errorf("SquashFS: xattrs of inode %d: %w", in.number, err)
+		}
+		key := xattrPrefixes[typ&0xFF] + string(name)
+		v, err := value(c)
+		if err != nil {
+			return nil, fmt.Errorf("SquashFS: xattr %q: %w", key, err)
+		}
+		if typ&xattrValueOOL != 0 {
+			if len(v) != 8 {
+				return nil, fmt.Errorf("SquashFS: xattr %q has a %d-byte value reference", key, len(v))
+			}
+			vr := le.Uint64(v)
+			if v, err = value(&metaCursor{s: s, pos: kvStart + vr>>16, off: int(vr & 0xFFFF)}); err != nil {
+				return nil, fmt.Errorf("SquashFS: xattr %q: %w", key, err)
+			}
+		}
+		out[key] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,224 @@
+package squashfs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// OpenFile opens the regular file at path for streaming reads. It returns a
+// lazy, seekable io.ReadSeekCloser whose reads decompress only the data
+// blocks intersecting the accessed byte range, plus the fragment block for a
+// file's tail. Holes read as zeros.
+//
+// A directory resolves to ErrIsDirectory, a missing path to ErrNotFound, and
+// a symlink or special file to ErrUnsupported.
+//
+// Concurrency: ReadAt is safe for concurrent use; the last block read is
+// cached under a mutex. Read/Seek share a cursor and are not.
+func (s *SquashFS) OpenFile(path string) (io.ReadSeekCloser, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: handler has no reader (construct with NewSquashFSHandler)")
+	}
+	in, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.openRegular(in)
+}
+
+// OpenInode opens a regular file by inode number (DirectoryEntry.Inode),
+// skipping the path walk. The size param is ignored.
+func (s *SquashFS) OpenInode(ino uint64, _ int64) (io.ReadSeekCloser, error) {
+	if s.readFunc == nil || s.meta == nil {
+		return nil, fmt.Errorf("SquashFS: handler has no reader (construct with NewSquashFSHandler)")
+	}
+	if ino == 0 || ino > 0xFFFFFFFF {
+		return nil, fmt.Errorf("SquashFS: inode number %d out of range", ino)
+	}
+	ref, err := s.inodeRef(uint32(ino))
+	if err != nil {
+		return nil, err
+	}
+	in, err := s.readInode(ref)
+	if err != nil {
+		return nil, err
+	}
+	if in.number != uint32(ino) {
+		return nil, fmt.Errorf("SquashFS: inode reference for %d leads to inode %d", ino, in.number)
+	}
+	return s.openRegular(in)
+}
+
+func (s *SquashFS) openRegular(in *inode) (io.ReadSeekCloser, error) {
+	switch in.baseType() {
+	case typeDir:
+		return nil, fmt.Errorf("SquashFS: inode %d is a directory: %w", in.number, filesystem.ErrIsDirectory)
+	case typeFile:
+	default:
+		return nil, fmt.Errorf("SquashFS: inode %d is not a regular file (type %d): %w",
+			in.number, in.typ, filesystem.ErrUnsupported)
+	}
+	return s.openData(in)
+}
+
+// openData opens a regular file inode. The block offsets are the running
+// sum of the stored block sizes from blocksStart.
+func (s *SquashFS) openData(in *inode) (*squashFileReader, error) {
+	if in.size >= uint64(1)<<63 {
+		return nil, fmt.Errorf("SquashFS: inode %d size %d overflows int64", in.number, in.size)
+	}
+	r := &squashFileReader{s: s, in: in, size: int64(in.size), offsets: make([]uint64, len(in.blocks)), cached: -1}
+	off := in.blocksStart
+	for i, b := range in.blocks {
+		r.offsets[i] = off
+		off += uint64(b & sqDataSizeMask)
+	}
+	if off > s.bytesUsed {
+		return nil, fmt.Errorf("SquashFS: inode %d data runs past the end of the image", in.number)
+	}
+	return r, nil
+}
+
+// squashFileReader is a lazy, seekable reader over a regular file.
+type squashFileReader struct {
+	s       *SquashFS
+	in      *inode
+	size    int64
+	offsets []uint64 // image offset of each data block
+	pos     int64
+
+	mu     sync.Mutex
+	cached int64 // index of the block held in data, -1 for none
+	data   []byte
+}
+
+// block returns the uncompressed contents of file block idx; the block past
+// the last full one is the tail held in a fragment.
+func (r *squashFileReader) block(idx int64) ([]byte, error) {
+	r.mu.Lock()
+	if r.cached == idx {
+		b := r.data
+		r.mu.Unlock()
+		return b, nil
+	}
+	r.mu.Unlock()
+	bs := int64(r.s.blockSize)
+	want := min(bs, r.size-idx*bs)
+	var b []byte
+	if idx < int64(len(r.in.blocks)) {
+		size := r.in.blocks[idx]
+		if size&sqDataSizeMask == 0 {
+			b = make([]byte, want) // hole
+		} else {
+			var err error
+			if b, err = r.s.readDataBlock(r.offsets[idx], size); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if r.in.fragment == sqInvalidFrag {
+			return nil, fmt.Errorf("SquashFS: inode %d has no block %d", r.in.number, idx)
+		}
+		frag, err := r.s.fragment(r.in.fragment)
+		if err != nil {
+			return nil, err
+		}
+		if int64(r.in.fragOffset)+want > int64(len(frag)) {
+			return nil, fmt.Errorf("SquashFS: inode %d tail [%d,+%d) beyond the %d-byte fragment %d",
+				r.in.number, r.in.fragOffset, want, len(frag), r.in.fragment)
+		}
+		b = frag[r.in.fragOffset : int64(r.in.fragOffset)+want]
+	}
+	if int64(len(b)) != want {
+		return nil, fmt.Errorf("SquashFS: inode %d block %d holds %d bytes, want %d", r.in.number, idx, len(b), want)
+	}
+	r.mu.Lock()
+	r.cached, r.data = idx, b
+	r.mu.Unlock()
+	return b, nil
+}
+
+// readAt copies into p the file bytes starting at off, returning io.EOF for a
+// read at or past the end and n < len(p) with io.EOF for one that crosses it.
+func (r *squashFileReader) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("SquashFS: negative read offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	atEOF := false
+	if want > r.size-off {
+		want = r.size - off
+		atEOF = true
+	}
+	bs := int64(r.s.blockSize)
+	n := 0
+	for int64(n) < want {
+		o := off + int64(n)
+		b, err := r.block(o / bs)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:want], b[o%bs:])
+	}
+	if atEOF {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *squashFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.readAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt; it is safe for concurrent use.
+func (r *squashFileReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.readAt(p, off)
+}
+
+// Seek implements io.Seeker. It shares the cursor with Read.
+func (r *squashFileReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative seek position %d", abs)
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+// Close releases the cached block.
+func (r *squashFileReader) Close() error {
+	r.mu.Lock()
+	r.cached, r.data = -1, nil
+	r.mu.Unlock()
+	return nil
+}
+
+var _ io.ReadSeekCloser = (*squashFileReader)(nil)
+var _ io.ReaderAt = (*squashFileReader)(nil)
+var _ filesystem.FileOpener = (*SquashFS)(nil)
+var _ filesystem.InodeOpener = (*SquashFS)(nil)
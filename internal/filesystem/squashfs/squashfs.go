@@ -0,0 +1,698 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/compress"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+func init() {
+	filesystem.RegisterFileSystem(filesystem.FS_SQUASHFS, func() filesystem.FileSystem { return &SquashFS{} })
+	filesystem.RegisterHandler(filesystem.FS_SQUASHFS, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+		return NewSquashFSHandler(r, startLBA)
+	})
+}
+
+// SquashFS 4.0 implementation.
+// Reference: squashfs-tools squashfs_fs.h and the kernel's fs/squashfs.
+//
+// A SquashFS image is a read-only, byte-addressed archive: a 96-byte
+// superblock at offset 0, the data and fragment blocks, then a series of
+// tables, each a run of metadata blocks (a 16-bit header — bit 15 set when
+// stored uncompressed, the low 15 bits the stored size — followed by up to
+// 8 KiB of data).
+//
+//   - Inodes live in the inode table and are addressed by a reference:
+//     (metadata block offset within the table << 16) | offset within the
+//     block's uncompressed data. Directory entries carry references, so the
+//     tree is walked without the inode-number lookup table, which only
+//     exportable images have.
+//   - A directory listing in the directory table is a sequence of headers
+//     {count-1, inode block, base inode number}, each followed by up to 256
+//     entries {offset, inode number delta, type, name size-1, name}.
+//   - A file is a run of data blocks and an optional tail packed into a
+//     fragment block, which the fragment table locates. A data block size
+//     with bit 24 set is stored uncompressed; size 0 is a hole.
+//   - uid/gid are indexes into the ID table; extended inodes carry an index
+//     into the extended attribute ID table.
+//
+// Blocks are compressed with the compressor named in the superblock. gzip
+// (zlib), lzma, lzo, xz, lz4 and zstd are decoded; any other compressor is
+// rejected at open time with ErrUnsupported.
+
+const (
+	sqMagic         = 0x73717368 // "hsqs"
+	sqSuperSize     = 96
+	sqMetaSize      = 8192
+	sqInvalidFrag   = 0xFFFFFFFF
+	sqInvalidXattr  = 0xFFFFFFFF
+	sqInvalidTable  = 0xFFFFFFFFFFFFFFFF
+	sqMetaStored    = 0x8000
+	sqDataStored    = 1 << 24
+	sqDataSizeMask  = sqDataStored - 1
+	sqMaxDirEntries = 256
+
+	// Superblock flags.
+	sqFlagCompressorOptions = 0x0400
+
+	// Inode types.
+	typeDir      = 1
+	typeFile     = 2
+	typeSymlink  = 3
+	typeBlkDev   = 4
+	typeChrDev   = 5
+	typeFIFO     = 6
+	typeSocket   = 7
+	typeExtDir   = 8
+	typeExtFile  = 9
+	typeExtLink  = 10
+	typeExtBlk   = 11
+	typeExtChr   = 12
+	typeExtFIFO  = 13
+	typeExtSock  = 14
+	typeExtDelta = typeExtDir - typeDir
+
+	// Compressor IDs.
+	compGzip = 1
+	compLZMA = 2
+	compLZO  = 3
+	compXZ   = 4
+	compLZ4  = 5
+	compZstd = 6
+
+	sqMaxSearchDepth = 64
+	sqMaxSearchCount = 100000
+)
+
+var compressorNames = map[uint16]string{
+	compGzip: "gzip", compLZMA: "lzma", compLZO: "lzo", compXZ: "xz", compLZ4: "lz4", compZstd: "zstd",
+}
+
+// zlibBlock decodes a gzip-compressor block, which is a zlib stream.
+func zlibBlock(src []byte, limit int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("zlib: %w", compress.ErrOutputLimit)
+	}
+	return out, nil
+}
+
+var decoders = map[uint16]func([]byte, int) ([]byte, error){
+	compGzip: zlibBlock,
+	compLZMA: compress.LZMA,
+	compLZO:  compress.LZO1X,
+	compXZ:   compress.XZ,
+	compLZ4:  compress.LZ4Block,
+	compZstd: compress.Zstd,
+}
+
+// inode is a parsed SquashFS inode of any type.
+type inode struct {
+	ref    uint64
+	typ    uint16
+	mode   uint16 // permission bits only; the type comes from typ
+	uid    uint16 // ID table index
+	gid    uint16 // ID table index
+	mtime  int64
+	number uint32
+	nlink  uint32
+	xattr  uint32
+
+	// Directories.
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+	parent    uint32
+
+	// Regular files.
+	blocksStart uint64
+	fragment    uint32
+	fragOffset  uint32
+	blocks      []uint32
+
+	size   uint64
+	target string // symlinks
+	rdev   uint32 // devices
+}
+
+func (in *inode) isDir() bool { return in.typ == typeDir || in.typ == typeExtDir }
+
+// baseType folds an extended inode type onto its basic counterpart.
+func (in *inode) baseType() uint16 {
+	if in.typ >= typeExtDir {
+		return in.typ - typeExtDelta
+	}
+	return in.typ
+}
+
+func (in *inode) fileMode() filesystem.FileMode {
+	switch in.baseType() {
+	case typeDir:
+		return filesystem.ModeDir
+	case typeFile:
+		return filesystem.ModeRegular
+	case typeSymlink:
+		return filesystem.ModeSymlink
+	case typeBlkDev:
+		return filesystem.ModeBlock
+	case typeChrDev:
+		return filesystem.ModeCharacter
+	case typeFIFO:
+		return filesystem.ModeFIFO
+	default:
+		return filesystem.ModeSocket
+	}
+}
+
+// SquashFS implements filesystem.FileSystem over a SquashFS 4.0 image.
+type SquashFS struct {
+	startLBA uint64
+	readFunc func(startLBA uint64, count uint64) ([]byte, error)
+
+	// Superblock.
+	inodeCount  uint32
+	mkfsTime    int64
+	blockSize   uint32
+	fragments   uint32
+	compression uint16
+	flags       uint16
+	idCount     uint16
+	major       uint16
+	minor       uint16
+	rootRef     uint64
+	bytesUsed   uint64
+	idTable     uint64
+	xattrTable  uint64
+	inodeTable  uint64
+	dirTable    uint64
+	fragTable   uint64
+	lookupTable uint64
+
+	decompress func([]byte, int) ([]byte, error)
+	ids        []uint32
+
+	mu        sync.Mutex
+	meta      map[uint64]metaBlock // decompressed metadata blocks by offset
+	fragIdx   int64                // fragment block held in fragData, -1 for none
+	fragData  []byte
+	inodeRefs map[uint32]uint64 // inode number -> reference, built by a tree walk
+}
+
+type metaBlock struct {
+	data []byte
+	next uint64
+}
+
+// NewSquashFSHandler opens the SquashFS image at startLBA: it validates the
+// superblock, checks that its compressor is one this package decodes, and
+// loads the ID table.
+func NewSquashFSHandler(reader filesystem.Reader, startLBA uint64) (*SquashFS, error) {
+	s := &SquashFS{startLBA: startLBA, readFunc: reader.ReadSectors}
+	head, err := reader.ReadSectors(startLBA, 1)
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: failed to read superblock: %w", err)
+	}
+	if err := s.Open(head); err != nil {
+		return nil, err
+	}
+	dec, ok := decoders[s.compression]
+	if !ok {
+		return nil, fmt.Errorf("SquashFS: unknown compressor %d: %w", s.compression, filesystem.ErrUnsupported)
+	}
+	s.decompress = dec
+	s.meta = make(map[uint64]metaBlock)
+	s.fragIdx = -1
+	if s.flags&sqFlagCompressorOptions != 0 {
+		opts, _, err := s.readMeta(sqSuperSize)
+		if err != nil {
+			return nil, fmt.Errorf("SquashFS: compressor options: %w", err)
+		}
+		// LZ4 images record the block format version; only the legacy
+		// format (1) exists.
+		if s.compression == compLZ4 && (len(opts) < 4 || binary.LittleEndian.Uint32(opts) != 1) {
+			return nil, fmt.Errorf("SquashFS: unknown lz4 block format: %w", filesystem.ErrUnsupported)
+		}
+	}
+	if err := s.loadIDs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SquashFS) Type() filesystem.FileSystemType { return filesystem.FS_SQUASHFS }
+
+// Open validates the superblock at the start of sectorData and caches the
+// table locations.
+func (s *SquashFS) Open(sectorData []byte) error {
+	if len(sectorData) < sqSuperSize {
+		return fmt.Errorf("SquashFS: superblock data too small (%d bytes)", len(sectorData))
+	}
+	sb := sectorData[:sqSuperSize]
+	le := binary.LittleEndian
+	if le.Uint32(sb) != sqMagic {
+		return fmt.Errorf("SquashFS: invalid superblock magic")
+	}
+	s.major, s.minor = le.Uint16(sb[28:]), le.Uint16(sb[30:])
+	if s.major != 4 || s.minor != 0 {
+		return fmt.Errorf("SquashFS: version %d.%d: %w", s.major, s.minor, filesystem.ErrUnsupported)
+	}
+	s.inodeCount = le.Uint32(sb[4:])
+	s.mkfsTime = int64(le.Uint32(sb[8:]))
+	s.blockSize = le.Uint32(sb[12:])
+	s.fragments = le.Uint32(sb[16:])
+	s.compression = le.Uint16(sb[20:])
+	blockLog := le.Uint16(sb[22:])
+	s.flags = le.Uint16(sb[24:])
+	s.idCount = le.Uint16(sb[26:])
+	s.rootRef = le.Uint64(sb[32:])
+	s.bytesUsed = le.Uint64(sb[40:])
+	s.idTable = le.Uint64(sb[48:])
+	s.xattrTable = le.Uint64(sb[56:])
+	s.inodeTable = le.Uint64(sb[64:])
+	s.dirTable = le.Uint64(sb[72:])
+	s.fragTable = le.Uint64(sb[80:])
+	s.lookupTable = le.Uint64(sb[88:])
+	if blockLog < 12 || blockLog > 20 || s.blockSize != 1<<blockLog {
+		return fmt.Errorf("SquashFS: invalid block size %d (log %d)", s.blockSize, blockLog)
+	}
+	if !(s.inodeTable < s.dirTable && s.dirTable < s.bytesUsed && s.idTable < s.bytesUsed) ||
+		s.inodeTable < sqSuperSize || s.rootRef>>16 >= s.dirTable-s.inodeTable {
+		return fmt.Errorf("SquashFS: superblock table offsets out of order")
+	}
+	return nil
+}
+
+func (s *SquashFS) Close() error { return nil }
+
+// GetVolumeLabel returns "": SquashFS has no volume label.
+func (s *SquashFS) GetVolumeLabel() string { return "" }
+
+// GetVersion returns the on-disk format version as "major.minor".
+func (s *SquashFS) GetVersion() string { return fmt.Sprintf("%d.%d", s.major, s.minor) }
+
+// Compression returns the name of the image's block compressor.
+func (s *SquashFS) Compression() string {
+	if name, ok := compressorNames[s.compression]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", s.compression)
+}
+
+// GetBlockSize returns the data block size.
+func (s *SquashFS) GetBlockSize() uint32 { return s.blockSize }
+
+// CreationTime returns the image creation time in Unix seconds.
+func (s *SquashFS) CreationTime() int64 { return s.mkfsTime }
+
+// readBytes reads n bytes at byte offset off of the image.
+func (s *SquashFS) readBytes(off uint64, n int) ([]byte, error) {
+	if s.readFunc == nil {
+		return nil, fmt.Errorf("SquashFS: handler has no reader")
+	}
+	if off > s.bytesUsed || uint64(n) > s.bytesUsed-off {
+		return nil, fmt.Errorf("SquashFS: read of %d bytes at %d beyond the %d-byte image", n, off, s.bytesUsed)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	first := off / 512
+	last := (off + uint64(n) + 511) / 512
+	b, err := s.readFunc(s.startLBA+first, last-first)
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: read at %d: %w", off, err)
+	}
+	skip := int(off % 512)
+	if len(b) < skip+n {
+		return nil, fmt.Errorf("SquashFS: short read at %d", off)
+	}
+	return b[skip : skip+n], nil
+}
+
+// readMeta reads and decompresses the metadata block at byte offset off,
+// returning its data and the offset of the block that follows it.
+func (s *SquashFS) readMeta(off uint64) ([]byte, uint64, error) {
+	s.mu.Lock()
+	if mb, ok := s.meta[off]; ok {
+		s.mu.Unlock()
+		return mb.data, mb.next, nil
+	}
+	s.mu.Unlock()
+	h, err := s.readBytes(off, 2)
+	if err != nil {
+		return nil, 0, err
+	}
+	hdr := binary.LittleEndian.Uint16(h)
+	size := int(hdr &^ sqMetaStored)
+	if size == 0 || size > sqMetaSize {
+		return nil, 0, fmt.Errorf("SquashFS: metadata block at %d has invalid size %d", off, size)
+	}
+	raw, err := s.readBytes(off+2, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	data := raw
+	if hdr&sqMetaStored == 0 {
+		if data, err = s.decompress(raw, sqMetaSize); err != nil {
+			return nil, 0, fmt.Errorf("SquashFS: metadata block at %d: %w", off, err)
+		}
+	} else {
+		data = append([]byte(nil), raw...)
+	}
+	next := off + 2 + uint64(size)
+	s.mu.Lock()
+	if len(s.meta) >= 4096 {
+		clear(s.meta)
+	}
+	s.meta[off] = metaBlock{data, next}
+	s.mu.Unlock()
+	return data, next, nil
+}
+
+// metaCursor reads a byte stream that spans consecutive metadata blocks.
+type metaCursor struct {
+	s   *SquashFS
+	pos uint64 // offset of the current metadata block
+	off int    // offset within its uncompressed data
+}
+
+func (c *metaCursor) read(n int) ([]byte, error) {
+	var out []byte
+	for n > 0 {
+		data, next, err := c.s.readMeta(c.pos)
+		if err != nil {
+			return nil, err
+		}
+		if c.off >= len(data) {
+			c.off -= len(data)
+			c.pos = next
+			continue
+		}
+		k := min(n, len(data)-c.off)
+		if out == nil && k == n {
+			out = data[c.off : c.off+k]
+		} else {
+			out = append(out, data[c.off:c.off+k]...)
+		}
+		c.off += k
+		n -= k
+	}
+	return out, nil
+}
+
+// tableEntry reads entry idx of size bytes from a table whose metadata
+// blocks are located by an array of 64-bit pointers at ptrs.
+func (s *SquashFS) tableEntry(ptrs uint64, idx uint64, size int) ([]byte, error) {
+	perBlock := uint64(sqMetaSize / size)
+	p, err := s.readBytes(ptrs+8*(idx/perBlock), 8)
+	if err != nil {
+		return nil, err
+	}
+	c := &metaCursor{s: s, pos: binary.LittleEndian.Uint64(p), off: int(idx%perBlock) * size}
+	return c.read(size)
+}
+
+// loadIDs reads the ID table, which maps the uid/gid indexes in inodes to
+// numeric ids.
+func (s *SquashFS) loadIDs() error {
+	s.ids = make([]uint32, s.idCount)
+	for i := range s.ids {
+		b, err := s.tableEntry(s.idTable, uint64(i), 4)
+		if err != nil {
+			return fmt.Errorf("SquashFS: ID table: %w", err)
+		}
+		s.ids[i] = binary.LittleEndian.Uint32(b)
+	}
+	return nil
+}
+
+func (s *SquashFS) id(idx uint16) (uint32, error) {
+	if int(idx) >= len(s.ids) {
+		return 0, fmt.Errorf("SquashFS: ID index %d beyond the %d-entry ID table", idx, len(s.ids))
+	}
+	return s.ids[idx], nil
+}
+
+// readInode parses the inode at reference ref.
+func (s *SquashFS) readInode(ref uint64) (*inode, error) {
+	c := &metaCursor{s: s, pos: s.inodeTable + ref>>16, off: int(ref & 0xFFFF)}
+	if ref>>16 >= s.dirTable-s.inodeTable || ref&0xFFFF >= sqMetaSize {
+		return nil, fmt.Errorf("SquashFS: inode reference 0x%X outside the inode table", ref)
+	}
+	h, err := c.read(16)
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: inode 0x%X: %w", ref, err)
+	}
+	le := binary.LittleEndian
+	in := &inode{
+		ref:    ref,
+		typ:    le.Uint16(h),
+		mode:   le.Uint16(h[2:]) & 0o7777,
+		uid:    le.Uint16(h[4:]),
+		gid:    le.Uint16(h[6:]),
+		mtime:  int64(le.Uint32(h[8:])),
+		number: le.Uint32(h[12:]),
+		xattr:  sqInvalidXattr,
+	}
+	fail := func(err error) (*inode, error) {
+		return nil, fmt.Errorf("SquashFS: inode 0x%X: %w", ref, err)
+	}
+	switch in.typ {
+	case typeDir:
+		b, err := c.read(16)
+		if err != nil {
+			return fail(err)
+		}
+		in.dirBlock = le.Uint32(b)
+		in.nlink = le.Uint32(b[4:])
+		in.dirSize = uint32(le.Uint16(b[8:]))
+		in.dirOffset = le.Uint16(b[10:])
+		in.parent = le.Uint32(b[12:])
+	case typeExtDir:
+		b, err := c.read(24)
+		if err != nil {
+			return fail(err)
+		}
+		in.nlink = le.Uint32(b)
+		in.dirSize = le.Uint32(b[4:])
+		in.dirBlock = le.Uint32(b[8:])
+		in.parent = le.Uint32(b[12:])
+		in.dirOffset = le.Uint16(b[18:])
+		in.xattr = le.Uint32(b[20:])
+	case typeFile, typeExtFile:
+		if in.typ == typeFile {
+			b, err := c.read(16)
+			if err != nil {
+				return fail(err)
+			}
+			in.blocksStart = uint64(le.Uint32(b))
+			in.fragment = le.Uint32(b[4:])
+			in.fragOffset = le.Uint32(b[8:])
+			in.size = uint64(le.Uint32(b[12:]))
+			in.nlink = 1
+		} else {
+			b, err := c.read(40)
+			if err != nil {
+				return fail(err)
+			}
+			in.blocksStart = le.Uint64(b)
+			in.size = le.Uint64(b[8:])
+			in.nlink = le.Uint32(b[24:])
+			in.fragment = le.Uint32(b[28:])
+			in.fragOffset = le.Uint32(b[32:])
+			in.xattr = le.Uint32(b[36:])
+		}
+		n := in.size / uint64(s.blockSize)
+		if in.fragment == sqInvalidFrag && in.size%uint64(s.blockSize) != 0 {
+			n++
+		}
+		if n > s.bytesUsed/4 {
+			return fail(fmt.Errorf("file size %d implies %d blocks, more than the image holds", in.size, n))
+		}
+		b, err := c.read(int(n) * 4)
+		if err != nil {
+			return fail(err)
+		}
+		in.blocks = make([]uint32, n)
+		for i := range in.blocks {
+			in.blocks[i] = le.Uint32(b[4*i:])
+		}
+	case typeSymlink, typeExtLink:
+		b, err := c.read(8)
+		if err != nil {
+			return fail(err)
+		}
+		in.nlink = le.Uint32(b)
+		n := le.Uint32(b[4:])
+		if n > 65535 {
+			return fail(fmt.Errorf("symlink target length %d", n))
+		}
+		t, err := c.read(int(n))
+		if err != nil {
+			return fail(err)
+		}
+		in.target = string(t)
+		in.size = uint64(n)
+		if in.typ == typeExtLink {
+			x, err := c.read(4)
+			if err != nil {
+				return fail(err)
+			}
+			in.xattr = le.Uint32(x)
+		}
+	case typeBlkDev, typeChrDev, typeExtBlk, typeExtChr:
+		n := 8
+		if in.typ >= typeExtDir {
+			n = 12
+		}
+		b, err := c.read(n)
+		if err != nil {
+			return fail(err)
+		}
+		in.nlink, in.rdev = le.Uint32(b), le.Uint32(b[4:])
+		if n == 12 {
+			in.xattr = le.Uint32(b[8:])
+		}
+	case typeFIFO, typeSocket, typeExtFIFO, typeExtSock:
+		n := 4
+		if in.typ >= typeExtDir {
+			n = 8
+		}
+		b, err := c.read(n)
+		if err != nil {
+			return fail(err)
+		}
+		in.nlink = le.Uint32(b)
+		if n == 8 {
+			in.xattr = le.Uint32(b[4:])
+		}
+	default:
+		return fail(fmt.Errorf("unknown inode type %d", in.typ))
+	}
+	if in.isDir() {
+		in.size = uint64(in.dirSize)
+	}
+	return in, nil
+}
+
+// fragment returns the decompressed fragment block idx.
+func (s *SquashFS) fragment(idx uint32) ([]byte, error) {
+	s.mu.Lock()
+	if s.fragIdx == int64(idx) {
+		data := s.fragData
+		s.mu.Unlock()
+		return data, nil
+	}
+	s.mu.Unlock()
+	if idx >= s.fragments || s.fragTable == sqInvalidTable {
+		return nil, fmt.Errorf("SquashFS: fragment %d beyond the %d-entry fragment table", idx, s.fragments)
+	}
+	e, err := s.tableEntry(s.fragTable, uint64(idx), 16)
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: fragment table: %w", err)
+	}
+	data, err := s.readDataBlock(binary.LittleEndian.Uint64(e), binary.LittleEndian.Uint32(e[8:]))
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: fragment %d: %w", idx, err)
+	}
+	s.mu.Lock()
+	s.fragIdx, s.fragData = int64(idx), data
+	s.mu.Unlock()
+	return data, nil
+}
+
+// readDataBlock reads the data or fragment block at off whose on-disk size
+// word is size, decompressing it unless bit 24 marks it stored.
+func (s *SquashFS) readDataBlock(off uint64, size uint32) ([]byte, error) {
+	n := size & sqDataSizeMask
+	if n > s.blockSize {
+		return nil, fmt.Errorf("SquashFS: block at %d has stored size %d, more than the block size", off, n)
+	}
+	raw, err := s.readBytes(off, int(n))
+	if err != nil {
+		return nil, err
+	}
+	if size&sqDataStored != 0 {
+		return raw, nil
+	}
+	data, err := s.decompress(raw, int(s.blockSize))
+	if err != nil {
+		return nil, fmt.Errorf("SquashFS: block at %d: %w", off, err)
+	}
+	return data, nil
+}
+
+// inodeRef maps an inode number to its reference through the lookup table
+// when the image is exportable, or through a one-time walk of the tree.
+func (s *SquashFS) inodeRef(number uint32) (uint64, error) {
+	if number == 0 || number > s.inodeCount {
+		return 0, fmt.Errorf("SquashFS: inode number %d out of range", number)
+	}
+	if s.lookupTable != sqInvalidTable {
+		b, err := s.tableEntry(s.lookupTable, uint64(number-1), 8)
+		if err != nil {
+			return 0, fmt.Errorf("SquashFS: lookup table: %w", err)
+		}
+		return binary.LittleEndian.Uint64(b), nil
+	}
+	s.mu.Lock()
+	refs := s.inodeRefs
+	s.mu.Unlock()
+	if refs == nil {
+		root, err := s.readInode(s.rootRef)
+		if err != nil {
+			return 0, err
+		}
+		refs = map[uint32]uint64{root.number: s.rootRef}
+		var walk func(dir *inode, depth int) error
+		walk = func(dir *inode, depth int) error {
+			if depth > sqMaxSearchDepth {
+				return nil
+			}
+			entries, err := s.readDir(dir)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if _, seen := refs[e.number]; seen {
+					continue
+				}
+				refs[e.number] = e.ref
+				if e.typ == typeDir {
+					sub, err := s.readInode(e.ref)
+					if err != nil {
+						return err
+					}
+					if err := walk(sub, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		if err := walk(root, 0); err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+		s.inodeRefs = refs
+		s.mu.Unlock()
+	}
+	ref, ok := refs[number]
+	if !ok {
+		return 0, fmt.Errorf("SquashFS: inode %d is not linked into the tree: %w", number, filesystem.ErrNotFound)
+	}
+	return ref, nil
+}
@@ -0,0 +1,256 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/squashfs"
+)
+
+// memSquashReader is a fake Reader over an in-memory SquashFS image.
+type memSquashReader struct {
+	data []byte
+}
+
+func (r *memSquashReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start := lba * 512
+	end := start + count*512
+	if end > uint64(len(r.data)) {
+		return nil, fmt.Errorf("squashfs: read past end of image")
+	}
+	return r.data[start:end], nil
+}
+
+func squashZlib(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// squashNoise is incompressible filler, so the builder stores it.
+func squashNoise(n int, seed uint32) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		seed = seed*1103515245 + 12345
+		out[i] = byte(seed >> 16)
+	}
+	return out
+}
+
+// buildSquashTree returns a tree covering fragments, holes, stored and
+// compressed blocks, every inode type, extended inodes with xattrs and a
+// directory large enough to span several listing headers.
+func buildSquashTree() (*ewffixture.SquashFSNode, map[string][]byte) {
+	big := append(bytes.Repeat([]byte("compressible block\n"), 4096/19+1)[:4096], make([]byte, 4096)...)
+	big = append(big, squashNoise(4096+100, 7)...)
+	files := map[string][]byte{
+		"/hello.txt":      []byte("hello, squashfs\n"),
+		"/big.bin":        big,
+		"/exact.bin":      squashNoise(2*4096-100, 9),
+		"/dir/nested.txt": []byte("nested file in a shared fragment\n"),
+	}
+	many := &ewffixture.SquashFSNode{Name: "many", Type: ewffixture.SquashDir, Mode: 0o755}
+	for i := 0; i < 300; i++ {
+		many.Children = append(many.Children, &ewffixture.SquashFSNode{
+			Name: fmt.Sprintf("f%03d", i), Type: ewffixture.SquashFile, Mode: 0o644,
+		})
+	}
+	root := &ewffixture.SquashFSNode{Type: ewffixture.SquashDir, Mode: 0o755, Children: []*ewffixture.SquashFSNode{
+		{Name: "hello.txt", Type: ewffixture.SquashFile, Mode: 0o644, UID: 1, Data: files["/hello.txt"]},
+		{Name: "big.bin", Type: ewffixture.SquashFile, Mode: 0o600, Data: big, Holes: []int{1},
+			Xattrs: map[string][]byte{
+				"user.comment":      []byte("x"),
+				"security.selinux":  []byte("system_u:object_r:bin_t:s0"),
+				"trusted.overlay.o": []byte("y"),
+			},
+			SharedXattrs: []string{"security.selinux"}},
+		{Name: "exact.bin", Type: ewffixture.SquashFile, Mode: 0o644, Data: files["/exact.bin"], NoFragment: true},
+		{Name: "link", Type: ewffixture.SquashSymlink, Mode: 0o777, Target: "hello.txt"},
+		{Name: "fifo", Type: ewffixture.SquashFIFO, Mode: 0o644},
+		{Name: "tty", Type: ewffixture.SquashChrDev, Mode: 0o620, Rdev: 0x0401, Extended: true},
+		{Name: "dir", Type: ewffixture.SquashDir, Mode: 0o755, Extended: true, Children: []*ewffixture.SquashFSNode{
+			{Name: "nested.txt", Type: ewffixture.SquashFile, Mode: 0o644, Data: files["/dir/nested.txt"]},
+		}},
+		many,
+	}}
+	return root, files
+}
+
+func openSquash(t *testing.T, img []byte) filesystem.FileSystem {
+	t.Helper()
+	fs, err := filesystem.NewHandler(filesystem.FS_SQUASHFS, &memSquashReader{data: img}, 0, uint64(len(img)))
+	if err != nil {
+		t.Fatalf("NewHandler(SquashFS): %v", err)
+	}
+	return fs
+}
+
+func TestSquashFSReader(t *testing.T) {
+	for _, exportable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("exportable=%v", exportable), func(t *testing.T) {
+			root, files := buildSquashTree()
+			img := (&ewffixture.SquashFSImage{Compress: squashZlib, IDs: []uint32{0, 1000}, Exportable: exportable, ModTime: 1700000000}).Build(root)
+			if got := filesystem.DetectFileSystem(img[:512]); got != filesystem.FS_SQUASHFS {
+				t.Fatalf("DetectFileSystem = %s, want SquashFS", got)
+			}
+			fs := openSquash(t, img)
+			sq := fs.(*squashfs.SquashFS)
+			if sq.GetVersion() != "4.0" || sq.Compression() != "gzip" {
+				t.Errorf("version %q, compression %q", sq.GetVersion(), sq.Compression())
+			}
+
+			entries, err := fs.ListDirectory("/")
+			if err != nil {
+				t.Fatalf("ListDirectory(/): %v", err)
+			}
+			names := map[string]filesystem.DirectoryEntry{}
+			for _, e := range entries {
+				names[e.Name] = e
+			}
+			if len(entries) != 8 || !names["dir"].IsDir || names["big.bin"].Size != uint64(len(files["/big.bin"])) ||
+				names["hello.txt"].ModTime != 1700000000 || names["link"].Size != 9 {
+				t.Fatalf("root listing = %+v", entries)
+			}
+			if many, err := fs.ListDirectory("/many"); err != nil || len(many) != 300 || many[299].Name != "f299" {
+				t.Errorf("ListDirectory(/many) = %d entries, %v", len(many), err)
+			}
+
+			for path, want := range files {
+				if got, err := fs.GetFile(path); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("GetFile(%q) = %d bytes, %v; want %d bytes", path, len(got), err, len(want))
+				}
+			}
+			if got, err := fs.GetFile("/link"); err != nil || string(got) != "hello.txt" {
+				t.Errorf("GetFile(/link) = %q, %v", got, err)
+			}
+			if got, err := fs.GetFile("/many/f123"); err != nil || len(got) != 0 {
+				t.Errorf("GetFile(/many/f123) = %q, %v", got, err)
+			}
+
+			opener := fs.(filesystem.FileOpener)
+			f, err := opener.OpenFile("/big.bin")
+			if err != nil {
+				t.Fatalf("OpenFile(/big.bin): %v", err)
+			}
+			big := files["/big.bin"]
+			buf := make([]byte, 300)
+			for _, off := range []int64{0, 4000, 4096, 8100, int64(len(big)) - 300} {
+				if _, err := f.(io.ReaderAt).ReadAt(buf, off); err != nil || !bytes.Equal(buf, big[off:off+300]) {
+					t.Errorf("big.bin ReadAt(%d): err %v, data mismatch %v", off, err, !bytes.Equal(buf, big[off:off+300]))
+				}
+			}
+			if n, err := f.(io.ReaderAt).ReadAt(buf, int64(len(big))-100); n != 100 || err != io.EOF {
+				t.Errorf("ReadAt across EOF = %d, %v", n, err)
+			}
+			f.Close()
+
+			in, err := fs.(filesystem.InodeOpener).OpenInode(names["hello.txt"].Inode, 0)
+			if err != nil {
+				t.Fatalf("OpenInode(hello.txt): %v", err)
+			}
+			if got, _ := io.ReadAll(in); string(got) != "hello, squashfs\n" {
+				t.Errorf("OpenInode(hello.txt) read %q", got)
+			}
+			for path, want := range map[string]error{
+				"/dir":     filesystem.ErrIsDirectory,
+				"/link":    filesystem.ErrUnsupported,
+				"/fifo":    filesystem.ErrUnsupported,
+				"/missing": filesystem.ErrNotFound,
+			} {
+				if _, err := opener.OpenFile(path); !errors.Is(err, want) {
+					t.Errorf("OpenFile(%q) = %v, want %v", path, err, want)
+				}
+			}
+			if _, err := fs.GetFile("/tty"); !errors.Is(err, filesystem.ErrUnsupported) {
+				t.Errorf("GetFile(/tty) = %v, want ErrUnsupported", err)
+			}
+			if _, err := fs.ListDirectory("/hello.txt"); !errors.Is(err, filesystem.ErrNotDirectory) {
+				t.Errorf("ListDirectory(/hello.txt) = %v, want ErrNotDirectory", err)
+			}
+
+			if uid, gid, err := sq.Owner("/hello.txt"); err != nil || uid != 1000 || gid != 0 {
+				t.Errorf("Owner(/hello.txt) = %d, %d, %v", uid, gid, err)
+			}
+			x, err := sq.Xattrs("/big.bin")
+			if err != nil || len(x) != 3 || string(x["security.selinux"]) != "system_u:object_r:bin_t:s0" ||
+				string(x["user.comment"]) != "x" || string(x["trusted.overlay.o"]) != "y" {
+				t.Errorf("Xattrs(/big.bin) = %q, %v", x, err)
+			}
+			if x, err := sq.Xattrs("/hello.txt"); err != nil || len(x) != 0 {
+				t.Errorf("Xattrs(/hello.txt) = %q, %v", x, err)
+			}
+
+			found, err := fs.SearchFiles("/", func(fi filesystem.FileInfo) bool { return strings.HasSuffix(fi.Name, ".txt") })
+			if err != nil || len(found) != 2 {
+				t.Errorf("SearchFiles(*.txt) = %+v, %v", found, err)
+			}
+		})
+	}
+}
+
+// squashCompressorVectors hold squashText compressed by the reference
+// tools (xz 5.6, zstd 1.5, lz4 1.9; the lzo stream is hand-assembled: a
+// 25-byte literal run and one 975-byte match).
+var squashText = []byte(strings.Repeat("squashfs compressor test\n", 40))
+
+var squashCompressorVectors = map[uint16]string{
+	2: "5d00008000ffffffffffffffff00399c4b0208682d1c39224aa8883c93d49fe95f71204c46d48fae4d77ba73a04dd193b5e7ffffedcd8000",
+	3: "2a" + hex.EncodeToString(squashText[:25]) + "20000000b16000110000",
+	4: "fd377a585a0000016922de3604c02de80721011c000000000000000070cb4ea4e003e700255d00399c4b0208682d1c39224aa8883c93d49fe95f71204c46d48fae4d77ba73a04dd16ce53800000000000116ffdf000145e807000000a5fbb3193e300d8b020000000001595a",
+	5: "ff0a737175617368667320636f6d70726573736f7220746573740a1900ffffffba50746573740a",
+	6: "28b52ffd00680d0100c8737175617368667320636f6d70726573736f7220746573740a010031e76a8e01",
+}
+
+func TestSquashFSCompressors(t *testing.T) {
+	for id, vec := range squashCompressorVectors {
+		block, _ := hex.DecodeString(vec)
+		build := func(opts []byte, damage bool) []byte {
+			b := append([]byte(nil), block...)
+			if damage {
+				b[len(b)/2] ^= 0x55
+			}
+			img := &ewffixture.SquashFSImage{Compressor: id, CompressorOptions: opts, Compress: func(data []byte) []byte {
+				if bytes.Equal(data, squashText) {
+					return b
+				}
+				return nil
+			}}
+			return img.Build(&ewffixture.SquashFSNode{Type: ewffixture.SquashDir, Children: []*ewffixture.SquashFSNode{
+				{Name: "c.txt", Type: ewffixture.SquashFile, Data: squashText, NoFragment: true},
+			}})
+		}
+		var opts []byte
+		if id == 5 {
+			opts = []byte{1, 0, 0, 0, 0, 0, 0, 0}
+		}
+		fs := openSquash(t, build(opts, false))
+		if got, err := fs.GetFile("/c.txt"); err != nil || !bytes.Equal(got, squashText) {
+			t.Errorf("compressor %d: GetFile = %d bytes, %v", id, len(got), err)
+		}
+		fs = openSquash(t, build(opts, true))
+		if got, err := fs.GetFile("/c.txt"); err == nil && bytes.Equal(got, squashText) {
+			t.Errorf("compressor %d: damaged block decoded cleanly", id)
+		}
+	}
+
+	root := &ewffixture.SquashFSNode{Type: ewffixture.SquashDir}
+	for name, img := range map[string]*ewffixture.SquashFSImage{
+		"unknown compressor": {Compressor: 7},
+		"lz4 block format 2": {Compressor: 5, CompressorOptions: []byte{2, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		data := img.Build(root)
+		if _, err := filesystem.NewHandler(filesystem.FS_SQUASHFS, &memSquashReader{data: data}, 0, uint64(len(data))); !errors.Is(err, filesystem.ErrUnsupported) {
+			t.Errorf("%s: NewHandler = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
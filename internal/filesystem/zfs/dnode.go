@@ -0,0 +1,199 @@
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+const (
+	dnodeSize      = 512
+	dnodeCoreSize  = 64
+	dnodeFlagSpill = 4
+
+	objsetTypeMeta = 1
+	objsetTypeZFS  = 2
+	objsetTypeZvol = 3
+)
+
+// dnode is a decoded dnode_phys_t.
+type dnode struct {
+	obj         uint64
+	typ         uint8
+	indblkshift uint8
+	nlevels     uint8
+	bonustype   uint8
+	datablksz   int
+	maxblkid    uint64
+	bps         []blkptr
+	bonus       []byte
+	spill       *blkptr
+}
+
+// objset is an opened object set: its meta dnode holds the dnode array.
+type objset struct {
+	p    *pool
+	meta *dnode
+	typ  uint64
+}
+
+func parseDnode(b []byte, obj uint64) (*dnode, error) {
+	dn := &dnode{
+		obj:         obj,
+		typ:         b[0],
+		indblkshift: b[1],
+		nlevels:     b[2],
+		bonustype:   b[4],
+		datablksz:   int(binary.LittleEndian.Uint16(b[8:])) << 9,
+		maxblkid:    binary.LittleEndian.Uint64(b[16:]),
+	}
+	nblkptr := int(b[3])
+	flags := b[7]
+	bonuslen := int(binary.LittleEndian.Uint16(b[10:]))
+	size := dnodeSize * (1 + int(b[12]))
+	if size > len(b) {
+		return nil, fmt.Errorf("ZFS: dnode %d spans %d bytes past its block", obj, size)
+	}
+	if dn.typ == 0 {
+		return dn, nil
+	}
+	room := size - dnodeCoreSize
+	if flags&dnodeFlagSpill != 0 {
+		room -= bpSize
+	}
+	if nblkptr < 1 || nblkptr*bpSize+bonuslen > room || dn.nlevels < 1 || dn.nlevels > 10 ||
+		(dn.nlevels > 1 && (dn.indblkshift < 7 || dn.indblkshift > 17)) {
+		return nil, fmt.Errorf("ZFS: dnode %d is malformed (%d block pointers, bonus %d, %d levels)", obj, nblkptr, bonuslen, dn.nlevels)
+	}
+	for i := 0; i < nblkptr; i++ {
+		dn.bps = append(dn.bps, parseBlkptr(b[dnodeCoreSize+i*bpSize:]))
+	}
+	bonus := dnodeCoreSize + nblkptr*bpSize
+	dn.bonus = b[bonus : bonus+bonuslen]
+	if flags&dnodeFlagSpill != 0 {
+		bp := parseBlkptr(b[size-bpSize:])
+		dn.spill = &bp
+	}
+	return dn, nil
+}
+
+// openObjset reads the object set bp points to.
+func (p *pool) openObjset(bp *blkptr) (*objset, error) {
+	if bp.typ != dmuObjset {
+		return nil, fmt.Errorf("ZFS: block pointer of type %d where an object set was expected", bp.typ)
+	}
+	b, err := p.readMeta(bp)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 1024 {
+		return nil, fmt.Errorf("ZFS: object set of %d bytes", len(b))
+	}
+	meta, err := parseDnode(b[:dnodeSize], 0)
+	if err != nil {
+		return nil, err
+	}
+	if meta.typ == 0 || meta.datablksz == 0 {
+		return nil, fmt.Errorf("ZFS: object set has no meta dnode")
+	}
+	return &objset{p: p, meta: meta, typ: binary.LittleEndian.Uint64(b[704:])}, nil
+}
+
+// readMeta reads a metadata block through the pool's cache.
+func (p *pool) readMeta(bp *blkptr) ([]byte, error) {
+	if bp.embedded || bp.hole() {
+		return p.readBlock(bp)
+	}
+	key := cacheKey{bp.dva[0].vdev, bp.dva[0].offset}
+	p.mu.Lock()
+	if b, ok := p.cache[key]; ok {
+		p.mu.Unlock()
+		return b, nil
+	}
+	p.mu.Unlock()
+	b, err := p.readBlock(bp)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if p.cacheBytes+len(b) > maxCacheBytes {
+		clear(p.cache)
+		p.cacheBytes = 0
+	}
+	p.cache[key] = b
+	p.cacheBytes += len(b)
+	p.mu.Unlock()
+	return b, nil
+}
+
+// dnode returns object obj of the object set.
+func (os *objset) dnode(obj uint64) (*dnode, error) {
+	perBlock := uint64(os.meta.datablksz / dnodeSize)
+	blk, err := os.p.dataBlock(os.meta, obj/perBlock, true)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: dnode %d: %w", obj, err)
+	}
+	off := int(obj%perBlock) * dnodeSize
+	dn, err := parseDnode(blk[off:], obj)
+	if err != nil {
+		return nil, err
+	}
+	if dn.typ == 0 {
+		return nil, fmt.Errorf("ZFS: object %d is free: %w", obj, filesystem.ErrNotFound)
+	}
+	return dn, nil
+}
+
+// dataBlock returns level-0 block blkid of dn, walking its indirect
+// blocks. Blocks past maxblkid and holes at any level read as zeros. meta
+// routes the level-0 read through the metadata cache.
+func (p *pool) dataBlock(dn *dnode, blkid uint64, meta bool) ([]byte, error) {
+	if blkid > dn.maxblkid {
+		return make([]byte, dn.datablksz), nil
+	}
+	epbs := uint(dn.indblkshift) - 7
+	levels := uint(dn.nlevels)
+	top := blkid
+	if levels > 1 {
+		if epbs*(levels-1) >= 64 {
+			return nil, fmt.Errorf("ZFS: object %d has %d levels of %d-pointer indirect blocks", dn.obj, levels, 1<<epbs)
+		}
+		top = blkid >> (epbs * (levels - 1))
+	}
+	if top >= uint64(len(dn.bps)) {
+		return nil, fmt.Errorf("ZFS: object %d block %d beyond its block pointers", dn.obj, blkid)
+	}
+	bp := dn.bps[top]
+	for level := levels - 1; level > 0; level-- {
+		if bp.hole() {
+			return make([]byte, dn.datablksz), nil
+		}
+		ind, err := p.readMeta(&bp)
+		if err != nil {
+			return nil, err
+		}
+		idx := int(blkid>>(epbs*(level-1))) & (1<<epbs - 1)
+		if (idx+1)*bpSize > len(ind) {
+			return nil, fmt.Errorf("ZFS: object %d indirect block of %d bytes", dn.obj, len(ind))
+		}
+		bp = parseBlkptr(ind[idx*bpSize:])
+	}
+	if bp.hole() {
+		return make([]byte, dn.datablksz), nil
+	}
+	var b []byte
+	var err error
+	if meta {
+		b, err = p.readMeta(&bp)
+	} else {
+		b, err = p.readBlock(&bp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != dn.datablksz && !(dn.maxblkid == 0 && len(b) < dn.datablksz) {
+		return nil, fmt.Errorf("ZFS: object %d block %d is %d bytes, want %d", dn.obj, blkid, len(b), dn.datablksz)
+	}
+	return b, nil
+}
@@ -0,0 +1,185 @@
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+const (
+	mosObjectDirectory = 1
+
+	// dsl_dir_phys_t and dsl_dataset_phys_t field offsets.
+	ddHeadDataset = 8
+	ddChildDirZAP = 32
+	dsSnapnames   = 32
+	dsCreation    = 48
+	dsReferenced  = 72
+	dsGUID        = 112
+	dsBP          = 128
+	dsPhysSize    = dsBP + bpSize
+
+	maxDatasetDepth = 64
+	maxDatasets     = 1 << 16
+)
+
+// dsEntry is one dataset or snapshot found in the MOS.
+type dsEntry struct {
+	name string
+	obj  uint64
+	snap bool
+}
+
+// datasets walks the DSL directory tree from the root dataset's directory,
+// listing each head dataset followed by its snapshots. Directories named
+// with a leading '$' ($MOS, $FREE, $ORIGIN) are internal and skipped.
+func (p *pool) datasets() ([]dsEntry, error) {
+	root, err := p.mos.zapLookup(mosObjectDirectory, "root_dataset")
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: MOS object directory: %w", err)
+	}
+	var out []dsEntry
+	var walk func(dirObj uint64, name string, depth int) error
+	walk = func(dirObj uint64, name string, depth int) error {
+		if depth > maxDatasetDepth || len(out) > maxDatasets {
+			return fmt.Errorf("ZFS: dataset tree deeper than %d or larger than %d", maxDatasetDepth, maxDatasets)
+		}
+		dir, err := p.mos.dnode(dirObj)
+		if err != nil {
+			return err
+		}
+		if len(dir.bonus) < ddChildDirZAP+8 {
+			return fmt.Errorf("ZFS: DSL directory %d has a %d-byte bonus", dirObj, len(dir.bonus))
+		}
+		le := binary.LittleEndian
+		if head := le.Uint64(dir.bonus[ddHeadDataset:]); head != 0 {
+			out = append(out, dsEntry{name: name, obj: head})
+			ds, err := p.datasetPhys(head)
+			if err != nil {
+				return err
+			}
+			if snaps := le.Uint64(ds[dsSnapnames:]); snaps != 0 {
+				dn, err := p.mos.dnode(snaps)
+				if err != nil {
+					return err
+				}
+				entries, err := p.mos.zapList(dn)
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					out = append(out, dsEntry{name: name + "@" + e.name, obj: e.value(), snap: true})
+				}
+			}
+		}
+		children := le.Uint64(dir.bonus[ddChildDirZAP:])
+		if children == 0 {
+			return nil
+		}
+		dn, err := p.mos.dnode(children)
+		if err != nil {
+			return err
+		}
+		entries, err := p.mos.zapList(dn)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.name, "$") {
+				continue
+			}
+			if err := walk(e.value(), name+"/"+e.name, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root.value(), p.name, 0); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// datasetPhys returns the dsl_dataset_phys_t bonus of dataset object obj.
+func (p *pool) datasetPhys(obj uint64) ([]byte, error) {
+	dn, err := p.mos.dnode(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(dn.bonus) < dsPhysSize {
+		return nil, fmt.Errorf("ZFS: dataset %d has a %d-byte bonus", obj, len(dn.bonus))
+	}
+	return dn.bonus, nil
+}
+
+// openDataset opens the named dataset or snapshot as a filesystem.
+func (p *pool) openDataset(name string) (*ZFS, error) {
+	all, err := p.datasets()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range all {
+		if d.name != name {
+			continue
+		}
+		ds, err := p.datasetPhys(d.obj)
+		if err != nil {
+			return nil, err
+		}
+		bp := parseBlkptr(ds[dsBP:])
+		if bp.hole() {
+			return nil, fmt.Errorf("ZFS: dataset %q has no object set", name)
+		}
+		os, err := p.openObjset(&bp)
+		if err != nil {
+			return nil, fmt.Errorf("ZFS: dataset %q: %w", name, err)
+		}
+		switch os.typ {
+		case objsetTypeZFS:
+		case objsetTypeZvol:
+			return nil, fmt.Errorf("ZFS: dataset %q is a volume (zvol), not a filesystem: %w", name, filesystem.ErrUnsupported)
+		default:
+			return nil, fmt.Errorf("ZFS: dataset %q has object set type %d", name, os.typ)
+		}
+		return newZPL(p, name, os)
+	}
+	return nil, fmt.Errorf("ZFS: dataset %q: %w", name, filesystem.ErrNotFound)
+}
+
+// Datasets lists the pool's filesystems, volumes and snapshots.
+func (z *ZFS) Datasets() ([]filesystem.Dataset, error) {
+	if z.p == nil || z.p.mos == nil {
+		return nil, fmt.Errorf("ZFS: dataset listing requires a reader")
+	}
+	all, err := z.p.datasets()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]filesystem.Dataset, 0, len(all))
+	le := binary.LittleEndian
+	for _, d := range all {
+		ds, err := z.p.datasetPhys(d.obj)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, filesystem.Dataset{
+			Name:       d.name,
+			Snapshot:   d.snap,
+			GUID:       le.Uint64(ds[dsGUID:]),
+			Created:    int64(le.Uint64(ds[dsCreation:])),
+			Referenced: le.Uint64(ds[dsReferenced:]),
+		})
+	}
+	return out, nil
+}
+
+// OpenDataset opens a dataset ("pool/child") or snapshot
+// ("pool/child@snap") of the same pool.
+func (z *ZFS) OpenDataset(name string) (filesystem.FileSystem, error) {
+	if z.p == nil || z.p.mos == nil {
+		return nil, fmt.Errorf("ZFS: dataset access requires a reader")
+	}
+	return z.p.openDataset(name)
+}
@@ -0,0 +1,213 @@
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// nvlist is a decoded name/value list. Values are uint64 (every integer
+// type), string, bool, []uint64, nvlist or []nvlist; pairs of other types
+// are skipped.
+type nvlist map[string]any
+
+// nvpair data types (sys/nvpair.h).
+const (
+	nvBoolean      = 1
+	nvByte         = 2
+	nvInt16        = 3
+	nvUint16       = 4
+	nvInt32        = 5
+	nvUint32       = 6
+	nvInt64        = 7
+	nvUint64       = 8
+	nvString       = 9
+	nvUint64Array  = 16
+	nvNvlist       = 19
+	nvNvlistArray  = 20
+	nvBooleanValue = 21
+	nvInt8         = 22
+	nvUint8        = 23
+
+	nvMaxDepth = 16
+)
+
+func (l nvlist) uint64(name string) (uint64, bool) {
+	v, ok := l[name].(uint64)
+	return v, ok
+}
+
+func (l nvlist) string(name string) string {
+	v, _ := l[name].(string)
+	return v
+}
+
+func (l nvlist) list(name string) nvlist {
+	v, _ := l[name].(nvlist)
+	return v
+}
+
+// unpackNVList decodes a packed nvlist: a 4-byte header (encoding, byte
+// order, two reserved bytes) followed by the list. Only the XDR encoding,
+// which every on-disk nvlist uses, is accepted.
+func unpackNVList(b []byte) (nvlist, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("nvlist: %d bytes is too short for a header", len(b))
+	}
+	if b[0] != 1 {
+		return nil, fmt.Errorf("nvlist: encoding %d is not XDR", b[0])
+	}
+	d := &xdrDecoder{b: b, pos: 4}
+	return d.list(0)
+}
+
+// xdrDecoder reads XDR: big-endian, every item padded to 4 bytes.
+type xdrDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *xdrDecoder) need(n int) error {
+	if n < 0 || d.pos+n > len(d.b) {
+		return fmt.Errorf("nvlist: truncated at offset %d", d.pos)
+	}
+	return nil
+}
+
+func (d *xdrDecoder) u32() (uint32, error) {
+	if err := d.need(4); err != nil {
+		return 0, err
+	}
+	v := binary.BigEndian.Uint32(d.b[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *xdrDecoder) u64() (uint64, error) {
+	if err := d.need(8); err != nil {
+		return 0, err
+	}
+	v := binary.BigEndian.Uint64(d.b[d.pos:])
+	d.pos += 8
+	return v, nil
+}
+
+func (d *xdrDecoder) str() (string, error) {
+	n, err := d.u32()
+	if err != nil {
+		return "", err
+	}
+	if err := d.need(int((n + 3) &^ 3)); err != nil {
+		return "", err
+	}
+	s := string(d.b[d.pos : d.pos+int(n)])
+	d.pos += int((n + 3) &^ 3)
+	return s, nil
+}
+
+// list decodes {version, flags, pairs..., 8 zero bytes}.
+func (d *xdrDecoder) list(depth int) (nvlist, error) {
+	if depth > nvMaxDepth {
+		return nil, fmt.Errorf("nvlist: nested deeper than %d", nvMaxDepth)
+	}
+	if _, err := d.u32(); err != nil { // version
+		return nil, err
+	}
+	if _, err := d.u32(); err != nil { // flags
+		return nil, err
+	}
+	l := nvlist{}
+	for {
+		start := d.pos
+		encSize, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		decSize, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		if encSize == 0 && decSize == 0 {
+			return l, nil
+		}
+		if encSize < 8 || d.need(int(encSize)-8) != nil {
+			return nil, fmt.Errorf("nvlist: pair at offset %d has encoded size %d", start, encSize)
+		}
+		end := start + int(encSize)
+		name, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		typ, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		nelem, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case nvBoolean:
+			l[name] = true
+		case nvBooleanValue:
+			v, err := d.u32()
+			if err != nil {
+				return nil, err
+			}
+			l[name] = v != 0
+		case nvByte, nvInt8, nvUint8, nvInt16, nvUint16, nvInt32, nvUint32:
+			v, err := d.u32()
+			if err != nil {
+				return nil, err
+			}
+			l[name] = uint64(v)
+		case nvInt64, nvUint64:
+			v, err := d.u64()
+			if err != nil {
+				return nil, err
+			}
+			l[name] = v
+		case nvString:
+			s, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			l[name] = s
+		case nvUint64Array:
+			n, err := d.u32()
+			if err != nil {
+				return nil, err
+			}
+			if n != nelem || d.need(8*int(n)) != nil {
+				return nil, fmt.Errorf("nvlist: %q: bad array of %d elements", name, n)
+			}
+			a := make([]uint64, n)
+			for i := range a {
+				a[i], _ = d.u64()
+			}
+			l[name] = a
+		case nvNvlist:
+			sub, err := d.list(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			l[name] = sub
+		case nvNvlistArray:
+			if d.need(int(nelem)*16) != nil {
+				return nil, fmt.Errorf("nvlist: %q: bad array of %d lists", name, nelem)
+			}
+			a := make([]nvlist, nelem)
+			for i := range a {
+				if a[i], err = d.list(depth + 1); err != nil {
+					return nil, err
+				}
+			}
+			l[name] = a
+		}
+		// Embedded lists were decoded in place; for every other type the
+		// encoded size is authoritative, which also steps over the types
+		// this decoder does not keep.
+		if typ != nvNvlist && typ != nvNvlistArray {
+			d.pos = end
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+const (
+	zbtLeaf   = 1<<63 + 0
+	zbtHeader = 1<<63 + 1
+	zbtMicro  = 1<<63 + 3
+
+	zapMagic     = 0x2F52AB2AB
+	zapLeafMagic = 0x2AB1EAF
+
+	mzapEntSize   = 64
+	mzapNameLen   = 50
+	zapChunkSize  = 24
+	zapArrayBytes = 21
+	zapChunkEntry = 252
+	zapChunkArray = 251
+	zapChainEnd   = 0xFFFF
+
+	zapFlagUint64Key = 2
+	maxZAPEntries    = 1 << 22
+)
+
+// zapEntry is one ZAP attribute: a name and an array of integers.
+type zapEntry struct {
+	name   string
+	intlen int
+	values []uint64
+}
+
+func (e zapEntry) value() uint64 {
+	if len(e.values) == 0 {
+		return 0
+	}
+	return e.values[0]
+}
+
+// zapList returns every entry of the ZAP object dn, micro or fat.
+func (os *objset) zapList(dn *dnode) ([]zapEntry, error) {
+	b0, err := os.p.dataBlock(dn, 0, true)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: ZAP object %d: %w", dn.obj, err)
+	}
+	if len(b0) < 128 {
+		return nil, fmt.Errorf("ZFS: ZAP object %d has a %d-byte block", dn.obj, len(b0))
+	}
+	le := binary.LittleEndian
+	switch le.Uint64(b0) {
+	case zbtMicro:
+		var out []zapEntry
+		for off := mzapEntSize; off+mzapEntSize <= len(b0); off += mzapEntSize {
+			e := b0[off : off+mzapEntSize]
+			name := e[14 : 14+mzapNameLen]
+			n := 0
+			for n < len(name) && name[n] != 0 {
+				n++
+			}
+			if n == 0 {
+				continue
+			}
+			out = append(out, zapEntry{name: string(name[:n]), intlen: 8, values: []uint64{le.Uint64(e)}})
+		}
+		return out, nil
+	case zbtHeader:
+		return os.fatZAPList(dn, b0)
+	}
+	return nil, fmt.Errorf("ZFS: object %d is not a ZAP (block type 0x%X)", dn.obj, le.Uint64(b0))
+}
+
+// fatZAPList walks the leaf blocks named by a fat ZAP's pointer table.
+func (os *objset) fatZAPList(dn *dnode, hdr []byte) ([]zapEntry, error) {
+	le := binary.LittleEndian
+	if le.Uint64(hdr[8:]) != zapMagic {
+		return nil, fmt.Errorf("ZFS: fat ZAP object %d has a bad magic", dn.obj)
+	}
+	if le.Uint64(hdr[96:])&zapFlagUint64Key != 0 {
+		return nil, fmt.Errorf("ZFS: fat ZAP object %d has binary keys: %w", dn.obj, filesystem.ErrUnsupported)
+	}
+	blkShift := bits.Len(uint(len(hdr))) - 1
+	if len(hdr) != 1<<blkShift {
+		return nil, fmt.Errorf("ZFS: fat ZAP object %d block size %d is not a power of two", dn.obj, len(hdr))
+	}
+	tblBlk, tblBlocks := le.Uint64(hdr[16:]), le.Uint64(hdr[24:])
+
+	// Collect the distinct leaf block ids, in table order.
+	var leaves []uint64
+	seen := map[uint64]bool{}
+	addPtrs := func(b []byte) {
+		for i := 0; i+8 <= len(b); i += 8 {
+			if blk := le.Uint64(b[i:]); blk != 0 && !seen[blk] {
+				seen[blk] = true
+				leaves = append(leaves, blk)
+			}
+		}
+	}
+	if tblBlocks == 0 {
+		addPtrs(hdr[len(hdr)/2:])
+	} else {
+		if tblBlocks > dn.maxblkid+1 {
+			return nil, fmt.Errorf("ZFS: fat ZAP object %d pointer table of %d blocks", dn.obj, tblBlocks)
+		}
+		for i := uint64(0); i < tblBlocks; i++ {
+			b, err := os.p.dataBlock(dn, tblBlk+i, true)
+			if err != nil {
+				return nil, fmt.Errorf("ZFS: fat ZAP object %d: %w", dn.obj, err)
+			}
+			addPtrs(b)
+		}
+	}
+
+	var out []zapEntry
+	for _, blk := range leaves {
+		if blk > dn.maxblkid {
+			return nil, fmt.Errorf("ZFS: fat ZAP object %d names leaf %d past its last block", dn.obj, blk)
+		}
+		b, err := os.p.dataBlock(dn, blk, true)
+		if err != nil {
+			return nil, fmt.Errorf("ZFS: fat ZAP object %d: %w", dn.obj, err)
+		}
+		entries, err := zapLeafEntries(b, blkShift)
+		if err != nil {
+			return nil, fmt.Errorf("ZFS: fat ZAP object %d leaf %d: %w", dn.obj, blk, err)
+		}
+		out = append(out, entries...)
+		if len(out) > maxZAPEntries {
+			return nil, fmt.Errorf("ZFS: fat ZAP object %d has more than %d entries", dn.obj, maxZAPEntries)
+		}
+	}
+	return out, nil
+}
+
+// zapLeafEntries decodes the entry chunks of one leaf block. Chunks follow
+// a 48-byte header and a hash table of 2-byte slots; names and values are
+// chains of array chunks, with integers stored big-endian.
+func zapLeafEntries(b []byte, blkShift int) ([]zapEntry, error) {
+	le := binary.LittleEndian
+	if le.Uint64(b) != zbtLeaf || le.Uint32(b[24:]) != zapLeafMagic {
+		return nil, fmt.Errorf("bad leaf header")
+	}
+	hashEntries := 1 << (blkShift - 5)
+	base := 48 + 2*hashEntries
+	nchunks := (len(b)-2*hashEntries)/zapChunkSize - 2
+	chunk := func(i int) []byte {
+		if i < 0 || i >= nchunks {
+			return nil
+		}
+		return b[base+i*zapChunkSize : base+(i+1)*zapChunkSize]
+	}
+	array := func(first, n int) ([]byte, error) {
+		out := make([]byte, 0, n)
+		for c := first; len(out) < n; {
+			ch := chunk(c)
+			if ch == nil || ch[0] != zapChunkArray {
+				return nil, fmt.Errorf("broken array chain at chunk %d", c)
+			}
+			out = append(out, ch[1:1+min(zapArrayBytes, n-len(out))]...)
+			c = int(le.Uint16(ch[22:]))
+		}
+		return out, nil
+	}
+	var out []zapEntry
+	for i := 0; i < nchunks; i++ {
+		ch := chunk(i)
+		if ch[0] != zapChunkEntry {
+			continue
+		}
+		intlen := int(ch[1])
+		nameChunk, nameLen := int(le.Uint16(ch[4:])), int(le.Uint16(ch[6:]))
+		valChunk, valNum := int(le.Uint16(ch[8:])), int(le.Uint16(ch[10:]))
+		if intlen != 1 && intlen != 2 && intlen != 4 && intlen != 8 {
+			return nil, fmt.Errorf("entry chunk %d has integer length %d", i, intlen)
+		}
+		name, err := array(nameChunk, nameLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		raw, err := array(valChunk, valNum*intlen)
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]uint64, valNum)
+		for k := range vals {
+			for _, c := range raw[k*intlen : (k+1)*intlen] {
+				vals[k] = vals[k]<<8 | uint64(c)
+			}
+		}
+		out = append(out, zapEntry{name: string(name), intlen: intlen, values: vals})
+	}
+	return out, nil
+}
+
+// zapLookup finds name in the ZAP object obj.
+func (os *objset) zapLookup(obj uint64, name string) (zapEntry, error) {
+	dn, err := os.dnode(obj)
+	if err != nil {
+		return zapEntry{}, err
+	}
+	entries, err := os.zapList(dn)
+	if err != nil {
+		return zapEntry{}, err
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return e, nil
+		}
+	}
+	return zapEntry{}, fmt.Errorf("ZFS: %q not in ZAP object %d: %w", name, obj, filesystem.ErrNotFound)
+}
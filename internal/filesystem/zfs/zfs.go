@@ -0,0 +1,329 @@
+package zfs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+func init() {
+	filesystem.RegisterFileSystem(filesystem.FS_ZFS, func() filesystem.FileSystem { return &ZFS{} })
+	filesystem.RegisterHandler(filesystem.FS_ZFS, func(r filesystem.Reader, startLBA, partitionSize uint64) (filesystem.FileSystem, error) {
+		return NewZFSHandler(r, startLBA, partitionSize)
+	})
+}
+
+// ZFS implementation (read-only).
+// Reference: the ZFS On-Disk Specification and OpenZFS (module/zfs,
+// include/sys).
+//
+// A pool device (vdev) carries four 256 KiB labels, two at the start and two
+// at the end. Each holds an XDR nvlist describing the pool and the device's
+// place in its vdev tree, and a ring of uberblocks; the uberblock with the
+// highest txg roots the pool.
+//
+//   - Everything else is reached through 128-byte block pointers: up to three
+//     copies (DVAs: top-level vdev + offset past the 4 MiB label area), the
+//     logical and physical sizes, compression and checksum. Small blocks are
+//     embedded in the pointer itself.
+//   - An object set is an array of 512-byte dnodes, itself stored as the
+//     data of its meta dnode. A dnode's data blocks hang off a tree of
+//     indirect blocks nlevels deep.
+//   - The uberblock points at the MOS (meta object set). Its object
+//     directory names the root DSL directory; DSL directories chain child
+//     directories through ZAPs, and each names its head dataset, whose
+//     snapshot-name ZAP lists its snapshots. A dataset points at its own
+//     object set.
+//   - In a filesystem (ZPL) object set, the master node names the root
+//     directory; directories are ZAPs mapping names to object numbers, and
+//     file attributes live in the dnode bonus buffer as system attributes
+//     (SA) or, on old pools, a fixed znode_phys_t.
+//
+// Single-device and mirror pools are read from one device image: every DVA
+// on this device's top-level vdev is readable. RAID-Z and dRAID vdevs are
+// rejected at open time with ErrUnsupported, as are big-endian pools; gang
+// blocks and encrypted datasets fail with ErrUnsupported when reached.
+
+const (
+	labelSize      = 256 << 10
+	labelNVOffset  = 16 << 10
+	labelNVSize    = 112 << 10
+	labelUBOffset  = 128 << 10
+	labelUBSize    = 128 << 10
+	uberblockMagic = 0x00bab10c
+	eckMagic       = 0x0210da7ab10c7a11
+	eckSize        = 40
+
+	dmuObjset = 11 // block pointer type of an object set
+
+	maxSearchDepth = 64
+	maxSearchCount = 100000
+)
+
+type uberblock struct {
+	txg       uint64
+	timestamp uint64
+	version   uint64
+	rootbp    blkptr
+}
+
+// pool is the state shared by every dataset opened from one device.
+type pool struct {
+	startLBA uint64
+	size     uint64
+	readFunc func(startLBA uint64, count uint64) ([]byte, error)
+
+	name     string
+	guid     uint64
+	version  uint64
+	hostname string
+	topID    uint32
+	vdevType string
+	config   nvlist
+	ub       uberblock
+	mos      *objset
+
+	mu         sync.Mutex
+	cache      map[cacheKey][]byte // metadata blocks by location
+	cacheBytes int
+}
+
+type cacheKey struct {
+	vdev   uint32
+	offset uint64
+}
+
+const maxCacheBytes = 32 << 20
+
+// ZFS implements filesystem.FileSystem over one dataset of a pool. The
+// handler NewZFSHandler returns reads the pool's root dataset; Datasets
+// lists the others and OpenDataset opens them.
+type ZFS struct {
+	p       *pool
+	dataset string
+	os      *objset
+	root    uint64
+	sa      *saTables
+
+	mu      sync.Mutex
+	dirs    map[uint64][]zapEntry // parsed directory ZAPs
+	dirSize int
+}
+
+// NewZFSHandler opens the pool on the device at startLBA: it reads the
+// labels, selects the newest uberblock whose MOS reads, and opens the
+// pool's root dataset.
+func NewZFSHandler(reader filesystem.Reader, startLBA, partitionSize uint64) (*ZFS, error) {
+	if partitionSize < 2*labelSize+vdevLabelStart {
+		return nil, fmt.Errorf("ZFS: device of %d bytes is too small for a pool", partitionSize)
+	}
+	p := &pool{
+		startLBA: startLBA,
+		size:     partitionSize,
+		readFunc: reader.ReadSectors,
+		cache:    make(map[cacheKey][]byte),
+	}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return p.openDataset(p.name)
+}
+
+func labelOffsets(size uint64) []uint64 {
+	end := size &^ (labelSize - 1)
+	return []uint64{0, labelSize, end - 2*labelSize, end - labelSize}
+}
+
+// open reads the labels and selects the uberblock.
+func (p *pool) open() error {
+	var labelErr error
+	var ubs []uberblock
+	for _, off := range labelOffsets(p.size) {
+		raw, err := p.readBytes(off, labelSize)
+		if err != nil {
+			labelErr = err
+			continue
+		}
+		if p.config == nil {
+			cfg, err := parseLabelConfig(raw[labelNVOffset:labelNVOffset+labelNVSize], off+labelNVOffset)
+			if err != nil {
+				labelErr = err
+				continue
+			}
+			if err := p.setConfig(cfg); err != nil {
+				return err
+			}
+		}
+		ubs = append(ubs, p.uberblocks(raw[labelUBOffset:], off+labelUBOffset)...)
+	}
+	if p.config == nil {
+		return fmt.Errorf("ZFS: no valid vdev label: %v", labelErr)
+	}
+	if len(ubs) == 0 {
+		return fmt.Errorf("ZFS: pool %q has no valid uberblock", p.name)
+	}
+	sort.SliceStable(ubs, func(i, j int) bool {
+		if ubs[i].txg != ubs[j].txg {
+			return ubs[i].txg > ubs[j].txg
+		}
+		return ubs[i].timestamp > ubs[j].timestamp
+	})
+	var mosErr error
+	for i := range ubs {
+		mos, err := p.openObjset(&ubs[i].rootbp)
+		if err != nil {
+			if mosErr == nil {
+				mosErr = err
+			}
+			continue
+		}
+		p.ub, p.mos = ubs[i], mos
+		return nil
+	}
+	return fmt.Errorf("ZFS: pool %q: no uberblock leads to a readable MOS: %w", p.name, mosErr)
+}
+
+// parseLabelConfig verifies and decodes a label's nvlist region.
+func parseLabelConfig(b []byte, offset uint64) (nvlist, error) {
+	if err := verifyLabelChecksum(b, offset); err != nil {
+		return nil, fmt.Errorf("ZFS: label config at %d: %w", offset, err)
+	}
+	cfg, err := unpackNVList(b[:len(b)-eckSize])
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: label config at %d: %w", offset, err)
+	}
+	return cfg, nil
+}
+
+// verifyLabelChecksum checks a label block's embedded SHA-256 checksum,
+// which is computed with the checksum field holding the block's device
+// offset.
+func verifyLabelChecksum(b []byte, offset uint64) error {
+	le := binary.LittleEndian
+	tail := b[len(b)-eckSize:]
+	switch le.Uint64(tail) {
+	case eckMagic:
+	case 0x117a0cb17ada1002:
+		return fmt.Errorf("big-endian label: %w", filesystem.ErrUnsupported)
+	default:
+		return fmt.Errorf("no embedded checksum")
+	}
+	var want [4]uint64
+	for i := range want {
+		want[i] = le.Uint64(tail[8+8*i:])
+	}
+	buf := append([]byte(nil), b...)
+	vt := buf[len(buf)-eckSize+8:]
+	clear(vt)
+	le.PutUint64(vt, offset)
+	if shaWords(sha256.Sum256(buf)) != want {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// setConfig records the pool identity and this device's place in the vdev
+// tree from a label's config.
+func (p *pool) setConfig(cfg nvlist) error {
+	name := cfg.string("name")
+	guid, ok := cfg.uint64("pool_guid")
+	if name == "" || !ok {
+		return fmt.Errorf("ZFS: label belongs to no pool (spare or cache device)")
+	}
+	tree := cfg.list("vdev_tree")
+	if tree == nil {
+		return fmt.Errorf("ZFS: pool %q label has no vdev tree", name)
+	}
+	typ := tree.string("type")
+	switch typ {
+	case "disk", "file", "mirror", "replacing", "spare":
+	case "raidz", "draid":
+		return fmt.Errorf("ZFS: pool %q: %s vdev: %w", name, typ, filesystem.ErrUnsupported)
+	default:
+		return fmt.Errorf("ZFS: pool %q: unknown vdev type %q: %w", name, typ, filesystem.ErrUnsupported)
+	}
+	id, _ := tree.uint64("id")
+	p.name, p.guid, p.vdevType, p.topID, p.config = name, guid, typ, uint32(id), cfg
+	p.version, _ = cfg.uint64("version")
+	p.hostname = cfg.string("hostname")
+	return nil
+}
+
+// uberblocks returns the valid uberblocks of one label's ring.
+func (p *pool) uberblocks(ring []byte, offset uint64) []uberblock {
+	tree := p.config.list("vdev_tree")
+	ashift, _ := tree.uint64("ashift")
+	shift := min(max(ashift, 10), 13)
+	slot := 1 << shift
+	le := binary.LittleEndian
+	var out []uberblock
+	for i := 0; i+slot <= labelUBSize && i+slot <= len(ring); i += slot {
+		b := ring[i : i+slot]
+		if le.Uint64(b) != uberblockMagic {
+			continue
+		}
+		if verifyLabelChecksum(b, offset+uint64(i)) != nil {
+			continue
+		}
+		ub := uberblock{
+			version:   le.Uint64(b[8:]),
+			txg:       le.Uint64(b[16:]),
+			timestamp: le.Uint64(b[32:]),
+			rootbp:    parseBlkptr(append([]byte(nil), b[40:40+bpSize]...)),
+		}
+		out = append(out, ub)
+	}
+	return out
+}
+
+func (z *ZFS) Type() filesystem.FileSystemType { return filesystem.FS_ZFS }
+
+// Open checks for a ZFS label config at offset 16 KiB of sectorData, the
+// region DetectFileSystem probes.
+func (z *ZFS) Open(sectorData []byte) error {
+	if !filesystem.IsZFSLabel(sectorData) {
+		return fmt.Errorf("ZFS: no vdev label config")
+	}
+	return nil
+}
+
+func (z *ZFS) Close() error { return nil }
+
+// GetVolumeLabel returns the dataset name ("pool" or "pool/child@snap").
+func (z *ZFS) GetVolumeLabel() string { return z.dataset }
+
+// PoolName returns the pool's name.
+func (z *ZFS) PoolName() string {
+	if z.p == nil {
+		return ""
+	}
+	return z.p.name
+}
+
+// PoolGUID returns the pool's GUID.
+func (z *ZFS) PoolGUID() uint64 {
+	if z.p == nil {
+		return 0
+	}
+	return z.p.guid
+}
+
+// TXG returns the transaction group of the selected uberblock.
+func (z *ZFS) TXG() uint64 {
+	if z.p == nil {
+		return 0
+	}
+	return z.p.ub.txg
+}
+
+// Hostname returns the host that last imported the pool, if recorded.
+func (z *ZFS) Hostname() string {
+	if z.p == nil {
+		return ""
+	}
+	return z.p.hostname
+}
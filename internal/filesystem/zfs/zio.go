@@ -0,0 +1,340 @@
+package zfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/laenix/ewfgo/internal/compress"
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// Checksum algorithms (zio_checksum enum).
+const (
+	ckFletcher2 = 6
+	ckFletcher4 = 7
+	ckSHA256    = 8
+	ckSHA512    = 11
+)
+
+// Compression algorithms (zio_compress enum).
+const (
+	compOff   = 2
+	compLZJB  = 3
+	compEmpty = 4
+	compGzip1 = 5
+	compGzip9 = 13
+	compZLE   = 14
+	compLZ4   = 15
+	compZstd  = 16
+)
+
+func compName(comp uint8) string {
+	switch {
+	case comp == compLZJB:
+		return "lzjb"
+	case comp >= compGzip1 && comp <= compGzip9:
+		return fmt.Sprintf("gzip-%d", comp-compGzip1+1)
+	case comp == compZLE:
+		return "zle"
+	case comp == compLZ4:
+		return "lz4"
+	case comp == compZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression %d", comp)
+}
+
+const (
+	bpSize          = 128
+	maxBlockSize    = 16 << 20
+	vdevLabelStart  = 4 << 20 // two labels and the boot block precede allocatable space
+	embeddedPayload = 112
+)
+
+// blkptr is a decoded block pointer.
+type blkptr struct {
+	dva      [3]dva
+	lsize    int
+	psize    int
+	comp     uint8
+	checksum uint8
+	typ      uint8
+	level    uint8
+	embedded bool
+	encrypt  bool
+	little   bool
+	birth    uint64
+	fill     uint64
+	cksum    [4]uint64
+	raw      []byte // the 128 on-disk bytes, for embedded payloads
+}
+
+type dva struct {
+	vdev   uint32
+	asize  uint64
+	offset uint64 // bytes from the start of allocatable space
+	gang   bool
+}
+
+func (d dva) empty() bool { return d.vdev == 0 && d.asize == 0 && d.offset == 0 && !d.gang }
+
+func parseBlkptr(b []byte) blkptr {
+	le := binary.LittleEndian
+	var bp blkptr
+	bp.raw = b[:bpSize]
+	for i := range bp.dva {
+		w0, w1 := le.Uint64(b[16*i:]), le.Uint64(b[16*i+8:])
+		bp.dva[i] = dva{
+			vdev:   uint32(w0 >> 32),
+			asize:  (w0 & 0xFFFFFF) << 9,
+			offset: (w1 &^ (1 << 63)) << 9,
+			gang:   w1>>63 != 0,
+		}
+	}
+	prop := le.Uint64(b[48:])
+	bp.embedded = prop>>39&1 != 0
+	bp.comp = uint8(prop >> 32 & 0x7F)
+	bp.typ = uint8(prop >> 48)
+	bp.level = uint8(prop >> 56 & 0x1F)
+	bp.encrypt = prop>>61&1 != 0
+	bp.little = prop>>63 != 0
+	if bp.embedded {
+		bp.lsize = int(prop&0x1FFFFFF) + 1
+		bp.psize = int(prop>>25&0x7F) + 1
+		bp.checksum = uint8(prop >> 40) // the embedded payload type
+	} else {
+		bp.lsize = int(prop&0xFFFF+1) << 9
+		bp.psize = int(prop>>16&0xFFFF+1) << 9
+		bp.checksum = uint8(prop >> 40)
+	}
+	bp.birth = le.Uint64(b[80:])
+	bp.fill = le.Uint64(b[88:])
+	for i := range bp.cksum {
+		bp.cksum[i] = le.Uint64(b[96+8*i:])
+	}
+	return bp
+}
+
+// hole reports a block pointer that references no data: it reads as zeros.
+func (bp *blkptr) hole() bool { return !bp.embedded && bp.dva[0].empty() }
+
+// readBytes reads n bytes at byte offset off of this vdev.
+func (p *pool) readBytes(off uint64, n int) ([]byte, error) {
+	if p.readFunc == nil {
+		return nil, fmt.Errorf("ZFS: handler has no reader")
+	}
+	if off > p.size || uint64(n) > p.size-off {
+		return nil, fmt.Errorf("ZFS: read of %d bytes at %d beyond the %d-byte vdev", n, off, p.size)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	first := off / 512
+	last := (off + uint64(n) + 511) / 512
+	b, err := p.readFunc(p.startLBA+first, last-first)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: read at %d: %w", off, err)
+	}
+	skip := int(off % 512)
+	if len(b) < skip+n {
+		return nil, fmt.Errorf("ZFS: short read at %d", off)
+	}
+	return b[skip : skip+n], nil
+}
+
+// readBlock returns the logical contents of the block bp points to:
+// embedded payloads are unpacked, a hole reads as zeros, and a stored block
+// is read from the first copy on this vdev whose checksum verifies, then
+// decompressed.
+func (p *pool) readBlock(bp *blkptr) ([]byte, error) {
+	if bp.embedded {
+		return p.readEmbedded(bp)
+	}
+	if bp.hole() {
+		return make([]byte, bp.lsize), nil
+	}
+	if bp.encrypt {
+		return nil, fmt.Errorf("ZFS: block is encrypted: %w", filesystem.ErrUnsupported)
+	}
+	if !bp.little {
+		return nil, fmt.Errorf("ZFS: big-endian block: %w", filesystem.ErrUnsupported)
+	}
+	if bp.lsize > maxBlockSize || bp.psize > bp.lsize {
+		return nil, fmt.Errorf("ZFS: block sizes %d/%d out of range", bp.psize, bp.lsize)
+	}
+	var firstErr error
+	for _, d := range bp.dva {
+		if d.empty() {
+			continue
+		}
+		data, err := p.readDVA(bp, d)
+		if err == nil {
+			return data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("ZFS: block pointer has no copies")
+	}
+	return nil, firstErr
+}
+
+func (p *pool) readDVA(bp *blkptr, d dva) ([]byte, error) {
+	if d.gang {
+		return nil, fmt.Errorf("ZFS: gang block at vdev %d offset %d: %w", d.vdev, d.offset, filesystem.ErrUnsupported)
+	}
+	if d.vdev != p.topID {
+		return nil, fmt.Errorf("ZFS: block on top-level vdev %d, which is not this device (vdev %d)", d.vdev, p.topID)
+	}
+	raw, err := p.readBytes(vdevLabelStart+d.offset, bp.psize)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChecksum(bp.checksum, raw, bp.cksum); err != nil {
+		return nil, fmt.Errorf("ZFS: block at vdev %d offset %d: %w", d.vdev, d.offset, err)
+	}
+	data, err := decompressBlock(bp.comp, raw, bp.lsize)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: block at vdev %d offset %d: %w", d.vdev, d.offset, err)
+	}
+	return data, nil
+}
+
+// readEmbedded unpacks data stored in the block pointer itself: every word
+// but the properties (6) and the birth txg (10).
+func (p *pool) readEmbedded(bp *blkptr) ([]byte, error) {
+	if bp.checksum != 0 { // BP_EMBEDDED_TYPE_DATA
+		return nil, fmt.Errorf("ZFS: embedded block pointer of type %d: %w", bp.checksum, filesystem.ErrUnsupported)
+	}
+	if bp.psize > embeddedPayload {
+		return nil, fmt.Errorf("ZFS: embedded payload of %d bytes", bp.psize)
+	}
+	payload := make([]byte, 0, embeddedPayload)
+	for w := 0; w < 16; w++ {
+		if w != 6 && w != 10 {
+			payload = append(payload, bp.raw[8*w:8*w+8]...)
+		}
+	}
+	return decompressBlock(bp.comp, payload[:bp.psize], bp.lsize)
+}
+
+func decompressBlock(comp uint8, src []byte, lsize int) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch {
+	case comp == compOff:
+		if len(src) < lsize {
+			return nil, fmt.Errorf("uncompressed block of %d bytes, want %d", len(src), lsize)
+		}
+		return append([]byte(nil), src[:lsize]...), nil
+	case comp == compLZJB:
+		out, err = compress.LZJB(src, lsize)
+	case comp == compEmpty:
+		return make([]byte, lsize), nil
+	case comp >= compGzip1 && comp <= compGzip9:
+		out, err = zlibBlock(src, lsize)
+	case comp == compZLE:
+		out, err = compress.ZLE(src, lsize)
+	case comp == compLZ4:
+		// A 4-byte big-endian length precedes the raw LZ4 block.
+		if len(src) < 4 || int(binary.BigEndian.Uint32(src)) > len(src)-4 {
+			return nil, fmt.Errorf("lz4 block header: %w", compress.ErrCorrupt)
+		}
+		out, err = compress.LZ4Block(src[4:4+binary.BigEndian.Uint32(src)], lsize)
+	case comp == compZstd:
+		// An 8-byte header (big-endian length, version and level)
+		// precedes a zstd frame written without its magic number.
+		if len(src) < 8 || int(binary.BigEndian.Uint32(src)) > len(src)-8 {
+			return nil, fmt.Errorf("zstd block header: %w", compress.ErrCorrupt)
+		}
+		frame := append([]byte{0x28, 0xB5, 0x2F, 0xFD}, src[8:8+binary.BigEndian.Uint32(src)]...)
+		out, err = compress.Zstd(frame, lsize)
+	default:
+		return nil, fmt.Errorf("compression %d: %w", comp, filesystem.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != lsize {
+		return nil, fmt.Errorf("%s block decoded to %d bytes, want %d: %w", compName(comp), len(out), lsize, compress.ErrCorrupt)
+	}
+	return out, nil
+}
+
+func zlibBlock(src []byte, limit int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("gzip: %w", compress.ErrOutputLimit)
+	}
+	return out, nil
+}
+
+// verifyChecksum checks data against the block pointer's checksum. The
+// fletcher and SHA-2 families are verified; skein, edonr and blake3 are not
+// implemented and read unverified.
+func verifyChecksum(alg uint8, data []byte, want [4]uint64) error {
+	var got [4]uint64
+	switch alg {
+	case ckFletcher2:
+		got = fletcher2(data)
+	case ckFletcher4:
+		got = fletcher4(data)
+	case ckSHA256:
+		got = shaWords(sha256.Sum256(data))
+	case ckSHA512:
+		got = shaWords(sha512.Sum512_256(data))
+	default:
+		return nil
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch (algorithm %d)", alg)
+	}
+	return nil
+}
+
+func shaWords(sum [32]byte) [4]uint64 {
+	var w [4]uint64
+	for i := range w {
+		w[i] = binary.BigEndian.Uint64(sum[8*i:])
+	}
+	return w
+}
+
+func fletcher2(b []byte) [4]uint64 {
+	var a0, a1, b0, b1 uint64
+	for i := 0; i+16 <= len(b); i += 16 {
+		a0 += binary.LittleEndian.Uint64(b[i:])
+		a1 += binary.LittleEndian.Uint64(b[i+8:])
+		b0 += a0
+		b1 += a1
+	}
+	return [4]uint64{a0, a1, b0, b1}
+}
+
+func fletcher4(b []byte) [4]uint64 {
+	var a, bb, c, d uint64
+	for i := 0; i+4 <= len(b); i += 4 {
+		a += uint64(binary.LittleEndian.Uint32(b[i:]))
+		bb += a
+		c += bb
+		d += c
+	}
+	return [4]uint64{a, bb, c, d}
+}
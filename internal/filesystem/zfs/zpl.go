@@ -0,0 +1,477 @@
+package zfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+const (
+	zplMasterNode = 1
+
+	bonusZnode = 17 // znode_phys_t
+	bonusSA    = 44 // system attributes
+
+	saMagic         = 0x2F505A
+	znodePhysSize   = 264
+	dirEntryObjMask = 1<<48 - 1
+	maxDirCache     = 4 << 20 // cached directory entries, counted by name bytes
+)
+
+// saTables holds a ZPL object set's system-attribute registry and layouts.
+type saTables struct {
+	attrs   map[uint16]saAttr
+	layouts map[uint64][]uint16
+}
+
+type saAttr struct {
+	name   string
+	length int // 0 for variable-length attributes
+}
+
+// znode is a file's attributes, from system attributes or a znode_phys_t.
+type znode struct {
+	obj     uint64
+	dn      *dnode
+	mode    uint64
+	size    uint64
+	uid     uint64
+	gid     uint64
+	links   uint64
+	parent  uint64
+	rdev    uint64
+	atime   int64
+	mtime   int64
+	ctime   int64
+	crtime  int64
+	symlink []byte
+	hasLink bool // symlink set from the attributes
+}
+
+func (zn *znode) isDir() bool { return zn.mode&0o170000 == 0o040000 }
+
+func (zn *znode) fileMode() filesystem.FileMode {
+	return filesystem.FileMode(zn.mode & 0o170000)
+}
+
+// newZPL opens the filesystem in object set os: the master node names the
+// root directory and, on SA-based datasets, the attribute tables.
+func newZPL(p *pool, name string, os *objset) (*ZFS, error) {
+	z := &ZFS{p: p, dataset: name, os: os, dirs: make(map[uint64][]zapEntry)}
+	master, err := os.dnode(zplMasterNode)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: dataset %q master node: %w", name, err)
+	}
+	entries, err := os.zapList(master)
+	if err != nil {
+		return nil, fmt.Errorf("ZFS: dataset %q master node: %w", name, err)
+	}
+	var saObj uint64
+	for _, e := range entries {
+		switch e.name {
+		case "ROOT":
+			z.root = e.value()
+		case "SA_ATTRS":
+			saObj = e.value()
+		}
+	}
+	if z.root == 0 {
+		return nil, fmt.Errorf("ZFS: dataset %q master node names no root directory", name)
+	}
+	if saObj != 0 {
+		if z.sa, err = os.loadSA(saObj); err != nil {
+			return nil, fmt.Errorf("ZFS: dataset %q: %w", name, err)
+		}
+	}
+	return z, nil
+}
+
+// loadSA reads the SA master node's REGISTRY (attribute name -> number,
+// length) and LAYOUTS (layout number -> attribute order) ZAPs.
+func (os *objset) loadSA(obj uint64) (*saTables, error) {
+	t := &saTables{attrs: map[uint16]saAttr{}, layouts: map[uint64][]uint16{}}
+	reg, err := os.zapLookup(obj, "REGISTRY")
+	if err != nil {
+		return nil, fmt.Errorf("SA registry: %w", err)
+	}
+	dn, err := os.dnode(reg.value())
+	if err != nil {
+		return nil, fmt.Errorf("SA registry: %w", err)
+	}
+	entries, err := os.zapList(dn)
+	if err != nil {
+		return nil, fmt.Errorf("SA registry: %w", err)
+	}
+	for _, e := range entries {
+		v := e.value()
+		t.attrs[uint16(v)] = saAttr{name: e.name, length: int(v >> 24 & 0xFFFF)}
+	}
+	lay, err := os.zapLookup(obj, "LAYOUTS")
+	if err != nil {
+		// A dataset with only the default layouts has no LAYOUTS object.
+		return t, nil
+	}
+	if dn, err = os.dnode(lay.value()); err != nil {
+		return nil, fmt.Errorf("SA layouts: %w", err)
+	}
+	if entries, err = os.zapList(dn); err != nil {
+		return nil, fmt.Errorf("SA layouts: %w", err)
+	}
+	for _, e := range entries {
+		n, err := strconv.ParseUint(e.name, 10, 64)
+		if err != nil || e.intlen != 2 {
+			return nil, fmt.Errorf("SA layouts: bad layout %q", e.name)
+		}
+		order := make([]uint16, len(e.values))
+		for i, v := range e.values {
+			order[i] = uint16(v)
+		}
+		t.layouts[n] = order
+	}
+	return t, nil
+}
+
+// saDecode splits an SA buffer (header, variable lengths, attributes in
+// layout order, each padded to 8 bytes) into attributes keyed by name.
+func (t *saTables) decode(b []byte, out map[string][]byte) error {
+	le := binary.LittleEndian
+	if len(b) < 8 || le.Uint32(b) != saMagic {
+		return fmt.Errorf("no system-attribute header")
+	}
+	info := le.Uint16(b[4:])
+	layout := uint64(info & 0x3FF)
+	hdrSize := int(info>>10&0x3F) * 8
+	order, ok := t.layouts[layout]
+	if !ok {
+		return fmt.Errorf("unknown SA layout %d", layout)
+	}
+	if hdrSize < 8 || hdrSize > len(b) {
+		return fmt.Errorf("SA header of %d bytes", hdrSize)
+	}
+	off, varIdx := hdrSize, 0
+	for _, num := range order {
+		a, ok := t.attrs[num]
+		if !ok {
+			return fmt.Errorf("SA layout %d names unregistered attribute %d", layout, num)
+		}
+		n := a.length
+		if n == 0 {
+			if 6+2*varIdx+2 > hdrSize {
+				return fmt.Errorf("SA layout %d: variable-length attribute %q has no length", layout, a.name)
+			}
+			n = int(le.Uint16(b[6+2*varIdx:]))
+			varIdx++
+		}
+		if off+n > len(b) {
+			return fmt.Errorf("SA attribute %q overruns its buffer", a.name)
+		}
+		out[a.name] = b[off : off+n]
+		off = (off + n + 7) &^ 7
+	}
+	return nil
+}
+
+// znode reads object obj's attributes.
+func (z *ZFS) znode(obj uint64) (*znode, error) {
+	dn, err := z.os.dnode(obj)
+	if err != nil {
+		return nil, err
+	}
+	zn := &znode{obj: obj, dn: dn}
+	le := binary.LittleEndian
+	switch dn.bonustype {
+	case bonusSA:
+		if z.sa == nil {
+			return nil, fmt.Errorf("ZFS: object %d has system attributes but the dataset has no SA tables", obj)
+		}
+		attrs := map[string][]byte{}
+		if err := z.sa.decode(dn.bonus, attrs); err != nil {
+			return nil, fmt.Errorf("ZFS: object %d: %w", obj, err)
+		}
+		if dn.spill != nil {
+			b, err := z.p.readBlock(dn.spill)
+			if err != nil {
+				return nil, fmt.Errorf("ZFS: object %d spill block: %w", obj, err)
+			}
+			if err := z.sa.decode(b, attrs); err != nil {
+				return nil, fmt.Errorf("ZFS: object %d spill block: %w", obj, err)
+			}
+		}
+		u64 := func(name string) uint64 {
+			if v := attrs[name]; len(v) >= 8 {
+				return le.Uint64(v)
+			}
+			return 0
+		}
+		if len(attrs["ZPL_MODE"]) < 8 || len(attrs["ZPL_SIZE"]) < 8 {
+			return nil, fmt.Errorf("ZFS: object %d lacks mode or size attributes", obj)
+		}
+		zn.mode, zn.size = u64("ZPL_MODE"), u64("ZPL_SIZE")
+		zn.uid, zn.gid, zn.links = u64("ZPL_UID"), u64("ZPL_GID"), u64("ZPL_LINKS")
+		zn.parent, zn.rdev = u64("ZPL_PARENT"), u64("ZPL_RDEV")
+		zn.atime, zn.mtime = int64(u64("ZPL_ATIME")), int64(u64("ZPL_MTIME"))
+		zn.ctime, zn.crtime = int64(u64("ZPL_CTIME")), int64(u64("ZPL_CRTIME"))
+		if v, ok := attrs["ZPL_SYMLINK"]; ok {
+			zn.symlink, zn.hasLink = v, true
+		}
+	case bonusZnode:
+		b := dn.bonus
+		if len(b) < znodePhysSize {
+			return nil, fmt.Errorf("ZFS: object %d has a %d-byte znode", obj, len(b))
+		}
+		zn.atime, zn.mtime = int64(le.Uint64(b[0:])), int64(le.Uint64(b[16:]))
+		zn.ctime, zn.crtime = int64(le.Uint64(b[32:])), int64(le.Uint64(b[48:]))
+		zn.mode, zn.size = le.Uint64(b[72:]), le.Uint64(b[80:])
+		zn.parent, zn.links = le.Uint64(b[88:]), le.Uint64(b[96:])
+		zn.rdev, zn.uid, zn.gid = le.Uint64(b[112:]), le.Uint64(b[128:]), le.Uint64(b[136:])
+		// Short symlink targets follow the znode in the bonus buffer.
+		if zn.mode&0o170000 == 0o120000 && zn.size <= uint64(len(b)-znodePhysSize) {
+			zn.symlink, zn.hasLink = b[znodePhysSize:znodePhysSize+int(zn.size)], true
+		}
+	default:
+		return nil, fmt.Errorf("ZFS: object %d has bonus type %d, not a file", obj, dn.bonustype)
+	}
+	return zn, nil
+}
+
+// target returns a symlink's target.
+func (z *ZFS) target(zn *znode) ([]byte, error) {
+	if zn.hasLink {
+		return append([]byte(nil), zn.symlink...), nil
+	}
+	if zn.size > uint64(zn.dn.datablksz) {
+		return nil, fmt.Errorf("ZFS: symlink object %d target of %d bytes", zn.obj, zn.size)
+	}
+	b, err := z.p.dataBlock(zn.dn, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b[:zn.size]...), nil
+}
+
+// readDir returns a directory's entries. Values carry the object number in
+// their low 48 bits and the entry type in the top four.
+func (z *ZFS) readDir(zn *znode) ([]zapEntry, error) {
+	if !zn.isDir() {
+		return nil, fmt.Errorf("ZFS: object %d is not a directory: %w", zn.obj, filesystem.ErrNotDirectory)
+	}
+	z.mu.Lock()
+	entries, ok := z.dirs[zn.obj]
+	z.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+	entries, err := z.os.zapList(zn.dn)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, e := range entries {
+		n += len(e.name) + 32
+	}
+	z.mu.Lock()
+	if z.dirSize+n > maxDirCache {
+		clear(z.dirs)
+		z.dirSize = 0
+	}
+	z.dirs[zn.obj], z.dirSize = entries, z.dirSize+n
+	z.mu.Unlock()
+	return entries, nil
+}
+
+// resolve walks path from the root directory. Names compare exactly.
+func (z *ZFS) resolve(path string) (*znode, error) {
+	cur, err := z.znode(z.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, comp := range strings.Split(path, "/") {
+		if comp == "" || comp == "." {
+			continue
+		}
+		entries, err := z.readDir(cur)
+		if err != nil {
+			return nil, err
+		}
+		var obj uint64
+		for _, e := range entries {
+			if e.name == comp {
+				obj = e.value() & dirEntryObjMask
+				break
+			}
+		}
+		if obj == 0 {
+			return nil, fmt.Errorf("ZFS: %q: %w", comp, filesystem.ErrNotFound)
+		}
+		if cur, err = z.znode(obj); err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+func cleanPath(path string) string {
+	if path == "" || path == "/" {
+		return ""
+	}
+	return "/" + strings.Trim(path, "/")
+}
+
+func (z *ZFS) ready() bool { return z.p != nil && z.os != nil }
+
+// ListDirectory lists a directory path. "" and "/" both denote the root.
+// DirectoryEntry.Inode is the entry's object number.
+func (z *ZFS) ListDirectory(path string) ([]filesystem.DirectoryEntry, error) {
+	if !z.ready() {
+		return nil, fmt.Errorf("ZFS: directory parsing requires a reader")
+	}
+	dir, err := z.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := z.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := cleanPath(path)
+	out := make([]filesystem.DirectoryEntry, 0, len(entries))
+	for _, e := range entries {
+		obj := e.value() & dirEntryObjMask
+		d := filesystem.DirectoryEntry{
+			Name:  e.name,
+			Path:  filesystem.JoinPath(dirPath, e.name),
+			IsDir: e.value()>>60 == 4, // DT_DIR
+			Inode: obj,
+		}
+		if zn, err := z.znode(obj); err == nil {
+			d.Size, d.IsDir = zn.size, zn.isDir()
+			d.ModTime, d.AccessTime, d.CreateTime = zn.mtime, zn.atime, zn.crtime
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// GetFile reads a file's contents by path. A symlink's contents are its
+// target.
+func (z *ZFS) GetFile(path string) ([]byte, error) {
+	if !z.ready() {
+		return nil, fmt.Errorf("ZFS: file reading requires a reader")
+	}
+	zn, err := z.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	switch zn.fileMode() {
+	case filesystem.ModeDir:
+		return nil, fmt.Errorf("ZFS: %q: %w", path, filesystem.ErrIsDirectory)
+	case filesystem.ModeSymlink:
+		return z.target(zn)
+	case filesystem.ModeRegular:
+	default:
+		return nil, fmt.Errorf("ZFS: %q is a special file: %w", path, filesystem.ErrUnsupported)
+	}
+	r, err := z.openData(zn)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, r.size)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// GetFileByPath returns metadata for a path.
+func (z *ZFS) GetFileByPath(path string) (*filesystem.FileInfo, error) {
+	if !z.ready() {
+		return nil, fmt.Errorf("ZFS: file lookup requires a reader")
+	}
+	zn, err := z.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return infoOf(zn, cleanPath(path)), nil
+}
+
+func infoOf(zn *znode, path string) *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:       path[strings.LastIndex(path, "/")+1:],
+		Path:       path,
+		Size:       zn.size,
+		Mode:       zn.fileMode(),
+		IsDir:      zn.isDir(),
+		ModTime:    zn.mtime,
+		AccessTime: zn.atime,
+		CreateTime: zn.crtime,
+		IsReadOnly: zn.mode&0o222 == 0,
+	}
+}
+
+// SearchFiles walks the directory tree under rootPath and returns every
+// FileInfo for which predicate returns true. Depth and result count are
+// bounded.
+func (z *ZFS) SearchFiles(rootPath string, predicate func(filesystem.FileInfo) bool) ([]filesystem.FileInfo, error) {
+	if !z.ready() {
+		return nil, fmt.Errorf("ZFS: search requires a reader")
+	}
+	start, err := z.resolve(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if !start.isDir() {
+		return nil, fmt.Errorf("ZFS: %q is not a directory: %w", rootPath, filesystem.ErrNotDirectory)
+	}
+	results := make([]filesystem.FileInfo, 0)
+	visited := make(map[uint64]bool)
+	var walk func(dir *znode, dirPath string, depth int) error
+	walk = func(dir *znode, dirPath string, depth int) error {
+		if depth > maxSearchDepth || visited[dir.obj] {
+			return nil
+		}
+		visited[dir.obj] = true
+		entries, err := z.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if len(results) >= maxSearchCount {
+				return fmt.Errorf("ZFS: search exceeded %d results", maxSearchCount)
+			}
+			zn, err := z.znode(e.value() & dirEntryObjMask)
+			if err != nil {
+				return err
+			}
+			fi := infoOf(zn, filesystem.JoinPath(dirPath, e.name))
+			if predicate(*fi) {
+				results = append(results, *fi)
+			}
+			if zn.isDir() {
+				if err := walk(zn, fi.Path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(start, cleanPath(rootPath), 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Owner returns the numeric uid and gid of path.
+func (z *ZFS) Owner(path string) (uid, gid uint64, err error) {
+	if !z.ready() {
+		return 0, 0, fmt.Errorf("ZFS: owner lookup requires a reader")
+	}
+	zn, err := z.resolve(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return zn.uid, zn.gid, nil
+}
@@ -0,0 +1,424 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/zfs"
+)
+
+// The encoders below produce valid streams that only exploit byte runs,
+// which is all a zero-padded fixture block needs to shrink.
+
+// zfsLZ4 emits a ZFS lz4 block: a 4-byte big-endian length, then LZ4
+// sequences whose matches are offset-1 runs.
+func zfsLZ4(src []byte) []byte {
+	var out []byte
+	length := func(n int) {
+		for ; n >= 255; n -= 255 {
+			out = append(out, 255)
+		}
+		out = append(out, byte(n))
+	}
+	seq := func(lits []byte, mlen int) {
+		tok := byte(min(len(lits), 15)) << 4
+		if mlen > 0 {
+			tok |= byte(min(mlen-4, 15))
+		}
+		out = append(out, tok)
+		if len(lits) >= 15 {
+			length(len(lits) - 15)
+		}
+		out = append(out, lits...)
+		if mlen > 0 {
+			out = append(out, 1, 0)
+			if mlen-4 >= 15 {
+				length(mlen - 4 - 15)
+			}
+		}
+	}
+	n, lit := len(src), 0
+	for i := 1; i < n; {
+		run := 0
+		for i+run < n-5 && src[i+run] == src[i-1] {
+			run++
+		}
+		if run >= 4 && i <= n-12 {
+			seq(src[lit:i], run)
+			i += run
+			lit = i
+			continue
+		}
+		i++
+	}
+	seq(src[lit:], 0)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(out))), out...)
+}
+
+// zfsLZJB emits LZJB items, each run after its first byte as distance-1
+// matches of up to 66 bytes.
+func zfsLZJB(src []byte) []byte {
+	var out []byte
+	mapPos, bit := 0, 8
+	for i := 0; i < len(src); {
+		if bit == 8 {
+			mapPos, bit = len(out), 0
+			out = append(out, 0)
+		}
+		run := 0
+		if i > 0 {
+			for i+run < len(src) && run < 66 && src[i+run] == src[i-1] {
+				run++
+			}
+		}
+		if run >= 3 {
+			out[mapPos] |= 1 << bit
+			out = append(out, byte((run-3)<<2), 1)
+			i += run
+		} else {
+			out = append(out, src[i])
+			i++
+		}
+		bit++
+	}
+	return out
+}
+
+// zfsZLE emits zero runs of up to 192 bytes and literal runs of up to 64.
+func zfsZLE(src []byte) []byte {
+	var out []byte
+	for i := 0; i < len(src); {
+		n := 0
+		if src[i] == 0 {
+			for i+n < len(src) && n < 192 && src[i+n] == 0 {
+				n++
+			}
+			out = append(out, byte(63+n))
+		} else {
+			for i+n < len(src) && n < 64 && src[i+n] != 0 {
+				n++
+			}
+			out = append(out, byte(n-1))
+			out = append(out, src[i:i+n]...)
+		}
+		i += n
+	}
+	return out
+}
+
+// zfsZstd emits a ZFS zstd block: an 8-byte header (big-endian frame
+// length, version and level) and a frame without its magic number, made of
+// raw and RLE blocks.
+func zfsZstd(src []byte) []byte {
+	frame := []byte{0xA0} // single segment, 4-byte content size
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(src)))
+	block := func(typ, size int, body []byte, last bool) {
+		h := typ<<1 | size<<3
+		if last {
+			h |= 1
+		}
+		frame = append(frame, byte(h), byte(h>>8), byte(h>>16))
+		frame = append(frame, body...)
+	}
+	lit := 0
+	flush := func(end int, last bool) {
+		for lit < end {
+			n := min(end-lit, 128<<10)
+			block(0, n, src[lit:lit+n], last && lit+n == end)
+			lit += n
+		}
+	}
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && run < 128<<10 && src[i+run] == src[i] {
+			run++
+		}
+		if run >= 16 {
+			flush(i, false)
+			block(1, run, src[i:i+1], i+run == len(src))
+			i += run
+			lit = i
+			continue
+		}
+		i += run
+	}
+	flush(len(src), true)
+	hdr := binary.BigEndian.AppendUint32(nil, uint32(len(frame)))
+	return append(binary.BigEndian.AppendUint32(hdr, 10505<<8|3), frame...)
+}
+
+type memZFSReader struct{ data []byte }
+
+func (r *memZFSReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	start, end := lba*512, (lba+count)*512
+	if end > uint64(len(r.data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return r.data[start:end], nil
+}
+
+// buildZFSTree returns a pool covering multi-level indirect files with
+// holes, micro and fat directories, symlinks, an SA root dataset with a
+// snapshot, a znode_phys_t child dataset and a volume.
+func buildZFSTree() (*ewffixture.ZFSDataset, map[string][]byte) {
+	big := squashNoise(40*4096, 11)
+	clear(big[5*4096 : 10*4096]) // a hole
+	copy(big[20*4096:], bytes.Repeat([]byte("compressible "), 4096/13))
+	files := map[string][]byte{
+		"/hello.txt":        []byte("hello, zfs\n"),
+		"/big.bin":          big,
+		"/small.txt":        bytes.Repeat([]byte("ab"), 50),
+		"/empty":            {},
+		"/dir/sub/deep.txt": []byte("deep inside\n"),
+	}
+	file := func(name string, data []byte) *ewffixture.ZFSNode {
+		return &ewffixture.ZFSNode{Name: name, Mode: 0o100644, UID: 1000, GID: 100, MTime: 1700000100, Data: data}
+	}
+	dir := func(name string, children ...*ewffixture.ZFSNode) *ewffixture.ZFSNode {
+		return &ewffixture.ZFSNode{Name: name, Mode: 0o040755, MTime: 1700000200, Children: children}
+	}
+	link := func(name, target string) *ewffixture.ZFSNode {
+		return &ewffixture.ZFSNode{Name: name, Mode: 0o120777, Target: target}
+	}
+	many := dir("dir", dir("sub", file("deep.txt", files["/dir/sub/deep.txt"])))
+	for i := 0; i < 120; i++ {
+		name := "entry-" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+		many.Children = append(many.Children, file(name, []byte(name)))
+	}
+	root := &ewffixture.ZFSDataset{
+		Name:    "tank",
+		Created: 1700000000,
+		Root: dir("", file("hello.txt", files["/hello.txt"]), file("big.bin", big),
+			file("small.txt", files["/small.txt"]), file("empty", nil), link("link", "hello.txt"), many),
+		Snapshots: []*ewffixture.ZFSDataset{
+			{Name: "snap1", Root: dir("", file("hello.txt", []byte("before\n")))},
+		},
+		Children: []*ewffixture.ZFSDataset{
+			{Name: "legacy", Legacy: true, Root: dir("",
+				file("old.txt", []byte("znode_phys_t file\n")),
+				link("ln", "old.txt"),
+				link("longln", string(bytes.Repeat([]byte("long/"), 20))+"target"))},
+			{Name: "vol", Volume: true},
+		},
+	}
+	return root, files
+}
+
+func openZFS(t *testing.T, img []byte) *zfs.ZFS {
+	t.Helper()
+	fs, err := filesystem.NewHandler(filesystem.FS_ZFS, &memZFSReader{data: img}, 0, uint64(len(img)))
+	if err != nil {
+		t.Fatalf("NewHandler(ZFS): %v", err)
+	}
+	return fs.(*zfs.ZFS)
+}
+
+func TestZFSReader(t *testing.T) {
+	variants := []struct {
+		name string
+		pool ewffixture.ZFSPool
+	}{
+		{"uncompressed", ewffixture.ZFSPool{}},
+		{"lz4", ewffixture.ZFSPool{Compression: 15, Compress: zfsLZ4}},
+		{"lz4-embedded-sha256", ewffixture.ZFSPool{Compression: 15, Compress: zfsLZ4, Embed: true, Checksum: 8}},
+		{"lzjb", ewffixture.ZFSPool{Compression: 3, Compress: zfsLZJB}},
+		{"gzip-6", ewffixture.ZFSPool{Compression: 10, Compress: squashZlib}},
+		{"zle", ewffixture.ZFSPool{Compression: 14, Compress: zfsZLE}},
+		{"zstd", ewffixture.ZFSPool{Compression: 16, Compress: zfsZstd}},
+		{"mirror-ditto", ewffixture.ZFSPool{Vdev: "mirror", Copies: 2, VdevID: 3}},
+	}
+	for _, v := range variants {
+		t.Run(v.name, func(t *testing.T) {
+			tree, files := buildZFSTree()
+			pool := v.pool
+			pool.GUID, pool.Hostname = 0xC0FFEE, "truenas"
+			pool.BlockSize, pool.IndirectShift, pool.MicroZAPMax = 4096, 12, 50
+			fs := openZFS(t, pool.Build(tree))
+			if fs.GetVolumeLabel() != "tank" || fs.PoolName() != "tank" || fs.PoolGUID() != 0xC0FFEE ||
+				fs.Hostname() != "truenas" || fs.TXG() != 10 {
+				t.Errorf("pool = %q %q %#x %q txg %d", fs.GetVolumeLabel(), fs.PoolName(), fs.PoolGUID(), fs.Hostname(), fs.TXG())
+			}
+
+			for path, want := range files {
+				got, err := fs.GetFile(path)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("GetFile(%s) = %d bytes, %v; want %d bytes", path, len(got), err, len(want))
+				}
+			}
+			if got, err := fs.GetFile("/link"); err != nil || string(got) != "hello.txt" {
+				t.Errorf("GetFile(/link) = %q, %v", got, err)
+			}
+
+			entries, err := fs.ListDirectory("/")
+			if err != nil {
+				t.Fatalf("ListDirectory(/): %v", err)
+			}
+			var names []string
+			byName := map[string]filesystem.DirectoryEntry{}
+			for _, e := range entries {
+				names = append(names, e.Name)
+				byName[e.Name] = e
+			}
+			sort.Strings(names)
+			if want := []string{"big.bin", "dir", "empty", "hello.txt", "link", "small.txt"}; !equalStrings(names, want) {
+				t.Errorf("ListDirectory(/) = %v, want %v", names, want)
+			}
+			if e := byName["dir"]; !e.IsDir || e.Path != "/dir" || e.ModTime != 1700000200 {
+				t.Errorf("dir entry = %+v", e)
+			}
+			if e := byName["big.bin"]; e.IsDir || e.Size != uint64(len(files["/big.bin"])) || e.Inode == 0 {
+				t.Errorf("big.bin entry = %+v", e)
+			}
+			if sub, err := fs.ListDirectory("/dir"); err != nil || len(sub) != 121 {
+				t.Errorf("ListDirectory(/dir) = %d entries, %v; want 121", len(sub), err)
+			}
+
+			r, err := fs.OpenInode(byName["big.bin"].Inode, 0)
+			if err != nil {
+				t.Fatalf("OpenInode(big.bin): %v", err)
+			}
+			big := files["/big.bin"]
+			buf := make([]byte, 3*4096)
+			if n, err := r.(io.ReaderAt).ReadAt(buf, 4*4096+100); err != nil || !bytes.Equal(buf[:n], big[4*4096+100:7*4096+100]) {
+				t.Errorf("ReadAt across the hole = %d, %v", n, err)
+			}
+			if n, err := r.(io.ReaderAt).ReadAt(buf, int64(len(big))-50); n != 50 || err != io.EOF ||
+				!bytes.Equal(buf[:50], big[len(big)-50:]) {
+				t.Errorf("ReadAt at the tail = %d, %v", n, err)
+			}
+			r.Close()
+
+			fi, err := fs.GetFileByPath("/dir/sub")
+			if err != nil || !fi.IsDir || fi.Mode != filesystem.ModeDir || fi.Path != "/dir/sub" {
+				t.Errorf("GetFileByPath(/dir/sub) = %+v, %v", fi, err)
+			}
+			if fi, err := fs.GetFileByPath("/link"); err != nil || fi.Mode != filesystem.ModeSymlink {
+				t.Errorf("GetFileByPath(/link) = %+v, %v", fi, err)
+			}
+			if uid, gid, err := fs.Owner("/hello.txt"); err != nil || uid != 1000 || gid != 100 {
+				t.Errorf("Owner(/hello.txt) = %d, %d, %v", uid, gid, err)
+			}
+			found, err := fs.SearchFiles("/", func(fi filesystem.FileInfo) bool { return fi.Name == "deep.txt" })
+			if err != nil || len(found) != 1 || found[0].Path != "/dir/sub/deep.txt" {
+				t.Errorf("SearchFiles(deep.txt) = %+v, %v", found, err)
+			}
+
+			if _, err := fs.GetFile("/dir"); !errors.Is(err, filesystem.ErrIsDirectory) {
+				t.Errorf("GetFile(/dir) = %v, want ErrIsDirectory", err)
+			}
+			if _, err := fs.GetFile("/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+				t.Errorf("GetFile(/missing) = %v, want ErrNotFound", err)
+			}
+			if _, err := fs.ListDirectory("/hello.txt"); !errors.Is(err, filesystem.ErrNotDirectory) {
+				t.Errorf("ListDirectory(/hello.txt) = %v, want ErrNotDirectory", err)
+			}
+			if _, err := fs.OpenFile("/link"); !errors.Is(err, filesystem.ErrUnsupported) {
+				t.Errorf("OpenFile(/link) = %v, want ErrUnsupported", err)
+			}
+
+			datasets, err := fs.Datasets()
+			if err != nil {
+				t.Fatalf("Datasets: %v", err)
+			}
+			var dsNames []string
+			for _, d := range datasets {
+				dsNames = append(dsNames, d.Name)
+				if d.Name == "tank" && (d.Created != 1700000000 || d.Snapshot || d.GUID == 0) {
+					t.Errorf("tank dataset = %+v", d)
+				}
+				if d.Name == "tank@snap1" && !d.Snapshot {
+					t.Errorf("tank@snap1 dataset = %+v", d)
+				}
+			}
+			if want := []string{"tank", "tank@snap1", "tank/legacy", "tank/vol"}; !equalStrings(dsNames, want) {
+				t.Errorf("Datasets = %v, want %v", dsNames, want)
+			}
+			snap, err := fs.OpenDataset("tank@snap1")
+			if err != nil {
+				t.Fatalf("OpenDataset(tank@snap1): %v", err)
+			}
+			if got, err := snap.GetFile("/hello.txt"); err != nil || string(got) != "before\n" {
+				t.Errorf("snapshot GetFile = %q, %v", got, err)
+			}
+			legacy, err := fs.OpenDataset("tank/legacy")
+			if err != nil {
+				t.Fatalf("OpenDataset(tank/legacy): %v", err)
+			}
+			for path, want := range map[string]string{
+				"/old.txt": "znode_phys_t file\n",
+				"/ln":      "old.txt",
+				"/longln":  string(bytes.Repeat([]byte("long/"), 20)) + "target",
+			} {
+				if got, err := legacy.GetFile(path); err != nil || string(got) != want {
+					t.Errorf("legacy GetFile(%s) = %q, %v", path, got, err)
+				}
+			}
+			if legacy.GetVolumeLabel() != "tank/legacy" {
+				t.Errorf("legacy label = %q", legacy.GetVolumeLabel())
+			}
+			if _, err := fs.OpenDataset("tank/vol"); !errors.Is(err, filesystem.ErrUnsupported) {
+				t.Errorf("OpenDataset(tank/vol) = %v, want ErrUnsupported", err)
+			}
+			if _, err := fs.OpenDataset("tank/none"); !errors.Is(err, filesystem.ErrNotFound) {
+				t.Errorf("OpenDataset(tank/none) = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestZFSPoolDamage covers uberblock fallback, ditto-block recovery from a
+// damaged copy, a damaged single copy and rejected vdev layouts.
+func TestZFSPoolDamage(t *testing.T) {
+	tree, files := buildZFSTree()
+	img := (&ewffixture.ZFSPool{BrokenNewerUberblock: true, BlockSize: 4096}).Build(tree)
+	if fs := openZFS(t, img); fs.TXG() != 10 {
+		t.Errorf("TXG = %d, want the intact uberblock's 10", fs.TXG())
+	}
+
+	damage := func(img []byte) {
+		i := bytes.Index(img, files["/big.bin"][:4096])
+		if i < 0 {
+			t.Fatal("big.bin block not found in the image")
+		}
+		img[i+100] ^= 0xFF
+	}
+	tree, files = buildZFSTree()
+	img = (&ewffixture.ZFSPool{Copies: 2, BlockSize: 4096}).Build(tree)
+	damage(img)
+	if got, err := openZFS(t, img).GetFile("/big.bin"); err != nil || !bytes.Equal(got, files["/big.bin"]) {
+		t.Errorf("GetFile with one damaged copy = %d bytes, %v", len(got), err)
+	}
+	tree, _ = buildZFSTree()
+	img = (&ewffixture.ZFSPool{BlockSize: 4096}).Build(tree)
+	damage(img)
+	if _, err := openZFS(t, img).GetFile("/big.bin"); err == nil {
+		t.Error("GetFile of a damaged block succeeded, want a checksum error")
+	}
+
+	tree, _ = buildZFSTree()
+	img = (&ewffixture.ZFSPool{Vdev: "raidz"}).Build(tree)
+	if _, err := filesystem.NewHandler(filesystem.FS_ZFS, &memZFSReader{data: img}, 0, uint64(len(img))); !errors.Is(err, filesystem.ErrUnsupported) {
+		t.Errorf("raidz NewHandler = %v, want ErrUnsupported", err)
+	}
+	if got := filesystem.DetectFileSystem(img[:0x10048]); got != filesystem.FS_ZFS {
+		t.Errorf("DetectFileSystem = %q, want ZFS", got)
+	}
+}
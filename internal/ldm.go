@@ -0,0 +1,544 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Windows Logical Disk Manager (dynamic disk) database.
+//
+// A dynamic disk keeps a 1 MiB database (2048 sectors) at the end of the disk,
+// behind an MBR type 0x42 partition or a GPT "LDM metadata" partition. Every
+// structure in it is big-endian. The layout follows the Linux kernel's
+// block/partitions/ldm.c reader:
+//
+//	PRIVHEAD  disk id, data-area geometry and the database location
+//	TOCBLOCK  config+1 sector; locates the "config" area (the VMDB)
+//	VMDB      VBLK slot size and count
+//	VBLK      one object per slot: disks, components, partitions, volumes
+//
+// Objects reference each other by a variable-length object id. A volume owns
+// components (one per plex; two plexes is a mirror), a component owns
+// partitions, and a partition names the disk and the sector range it occupies.
+
+// LDM on-disk constants.
+const (
+	// LDMPrivHeadLBA is the sector of the primary PRIVHEAD on an MBR dynamic disk.
+	LDMPrivHeadLBA = 6
+	// LDMDatabaseSectors is the size of the LDM database in sectors (1 MiB).
+	LDMDatabaseSectors = 2048
+	// ldmMaxVBLKs bounds the number of VBLK slots walked (hostile-input guard).
+	ldmMaxVBLKs = 1 << 16
+)
+
+// VBLK object types (the full byte at VBLK offset 0x13).
+const (
+	ldmVBLKComponent = 0x32
+	ldmVBLKPartition = 0x33
+	ldmVBLKDisk3     = 0x34
+	ldmVBLKGroup3    = 0x35
+	ldmVBLKDisk4     = 0x44
+	ldmVBLKGroup4    = 0x45
+	ldmVBLKVolume    = 0x51
+)
+
+// VBLK flag bits (VBLK offset 0x12) that add optional fields.
+const (
+	ldmFlagPartIndex   = 0x08
+	ldmFlagCompStripe  = 0x10
+	ldmFlagVolumeID1   = 0x08
+	ldmFlagVolumeID2   = 0x20
+	ldmFlagVolumeSize  = 0x80
+	ldmFlagVolumeDrive = 0x02
+)
+
+// LDM component layouts (LDMComponent.Layout).
+const (
+	LDMLayoutStriped      = 1 // RAID-0 across the component's partitions
+	LDMLayoutConcatenated = 2 // partitions concatenated by volume offset
+	LDMLayoutRAID5        = 3 // RAID-5 with parity
+)
+
+// LDMPrivHead is the per-disk PRIVHEAD record.
+type LDMPrivHead struct {
+	VersionMajor     uint16
+	VersionMinor     uint16
+	DiskID           string // lower-case GUID string
+	DiskGroupID      string
+	DiskGroupName    string
+	LogicalDiskStart uint64 // first sector of the data area; partition starts are relative to it
+	LogicalDiskSize  uint64
+	ConfigStart      uint64 // first sector of the database
+	ConfigSize       uint64
+}
+
+// LDMTOC is the TOCBLOCK: the config (VMDB) and log areas, relative to
+// LDMPrivHead.ConfigStart.
+type LDMTOC struct {
+	ConfigStart uint64
+	ConfigSize  uint64
+	LogStart    uint64
+	LogSize     uint64
+}
+
+// LDMVMDB is the VMDB header that sizes the VBLK slots following it.
+type LDMVMDB struct {
+	LastSeq      uint32
+	VBLKSize     uint32
+	VBLKOffset   uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	DiskGroup    string
+}
+
+// LDMDisk is a disk object (DSK3/DSK4).
+type LDMDisk struct {
+	ObjectID uint64
+	Name     string
+	DiskID   string // lower-case GUID string, matches LDMPrivHead.DiskID
+}
+
+// LDMComponent is a component object (CMP3): one plex of a volume.
+type LDMComponent struct {
+	ObjectID      uint64
+	Name          string
+	Layout        byte
+	Children      uint64
+	ParentID      uint64 // owning volume
+	StripeSectors uint64 // stripe size, striped layout only
+	Columns       uint64
+}
+
+// LDMPartition is a partition object (PRT3): a sector range of one disk.
+type LDMPartition struct {
+	ObjectID     uint64
+	Name         string
+	Start        uint64 // relative to the disk's LogicalDiskStart
+	VolumeOffset uint64 // offset of this range within its component (or column)
+	Size         uint64
+	ParentID     uint64 // owning component
+	DiskID       uint64 // owning disk object
+	Index        uint64 // column index (striped components)
+	HasIndex     bool
+}
+
+// LDMVolume is a volume object (VOL5).
+type LDMVolume struct {
+	ObjectID      uint64
+	Name          string
+	Type          string // "gen" or "raid5"
+	Children      uint64
+	Size          uint64
+	PartitionType byte
+	DriveHint     string
+}
+
+// LDMDatabase is the parsed object set of an LDM database.
+type LDMDatabase struct {
+	PrivHead   LDMPrivHead
+	Disks      []LDMDisk
+	Components []LDMComponent
+	Partitions []LDMPartition
+	Volumes    []LDMVolume
+}
+
+// ParseLDMPrivHead parses a PRIVHEAD sector.
+func ParseLDMPrivHead(data []byte) (*LDMPrivHead, error) {
+	if len(data) < 512 {
+		return nil, fmt.Errorf("data too small for LDM PRIVHEAD")
+	}
+	if string(data[0:8]) != "PRIVHEAD" {
+		return nil, fmt.Errorf("invalid LDM PRIVHEAD magic %q", string(data[0:8]))
+	}
+	ph := &LDMPrivHead{
+		VersionMajor:     binary.BigEndian.Uint16(data[0x0C:0x0E]),
+		VersionMinor:     binary.BigEndian.Uint16(data[0x0E:0x10]),
+		DiskID:           strings.ToLower(cString(data[0x30:0x70])),
+		DiskGroupID:      strings.ToLower(cString(data[0xB0:0xF0])),
+		DiskGroupName:    cString(data[0xF0:0x110]),
+		LogicalDiskStart: binary.BigEndian.Uint64(data[0x11B:0x123]),
+		LogicalDiskSize:  binary.BigEndian.Uint64(data[0x123:0x12B]),
+		ConfigStart:      binary.BigEndian.Uint64(data[0x12B:0x133]),
+		ConfigSize:       binary.BigEndian.Uint64(data[0x133:0x13B]),
+	}
+	if ph.VersionMajor != 2 {
+		return nil, fmt.Errorf("unsupported LDM PRIVHEAD version %d.%d", ph.VersionMajor, ph.VersionMinor)
+	}
+	if ph.LogicalDiskStart == 0 || ph.LogicalDiskStart+ph.LogicalDiskSize < ph.LogicalDiskStart {
+		return nil, fmt.Errorf("invalid LDM data area %d+%d", ph.LogicalDiskStart, ph.LogicalDiskSize)
+	}
+	if ph.ConfigSize == 0 || ph.ConfigSize > 1<<20 {
+		return nil, fmt.Errorf("invalid LDM database size %d", ph.ConfigSize)
+	}
+	if ph.DiskID == "" {
+		return nil, fmt.Errorf("LDM PRIVHEAD has no disk id")
+	}
+	return ph, nil
+}
+
+// ParseLDMTOC parses a TOCBLOCK sector.
+func ParseLDMTOC(data []byte) (*LDMTOC, error) {
+	if len(data) < 0x60 {
+		return nil, fmt.Errorf("data too small for LDM TOCBLOCK")
+	}
+	if string(data[0:8]) != "TOCBLOCK" {
+		return nil, fmt.Errorf("invalid LDM TOCBLOCK magic %q", string(data[0:8]))
+	}
+	if cString(data[0x24:0x2E]) != "config" {
+		return nil, fmt.Errorf("LDM TOCBLOCK has no config area")
+	}
+	toc := &LDMTOC{
+		ConfigStart: binary.BigEndian.Uint64(data[0x2E:0x36]),
+		ConfigSize:  binary.BigEndian.Uint64(data[0x36:0x3E]),
+	}
+	if cString(data[0x46:0x50]) == "log" {
+		toc.LogStart = binary.BigEndian.Uint64(data[0x50:0x58])
+		toc.LogSize = binary.BigEndian.Uint64(data[0x58:0x60])
+	}
+	return toc, nil
+}
+
+// ParseLDMVMDB parses the VMDB header sector.
+func ParseLDMVMDB(data []byte) (*LDMVMDB, error) {
+	if len(data) < 0x3D {
+		return nil, fmt.Errorf("data too small for LDM VMDB")
+	}
+	if string(data[0:4]) != "VMDB" {
+		return nil, fmt.Errorf("invalid LDM VMDB magic %q", string(data[0:4]))
+	}
+	vm := &LDMVMDB{
+		LastSeq:      binary.BigEndian.Uint32(data[0x04:0x08]),
+		VBLKSize:     binary.BigEndian.Uint32(data[0x08:0x0C]),
+		VBLKOffset:   binary.BigEndian.Uint32(data[0x0C:0x10]),
+		VersionMajor: binary.BigEndian.Uint16(data[0x12:0x14]),
+		VersionMinor: binary.BigEndian.Uint16(data[0x14:0x16]),
+		DiskGroup:    cString(data[0x16:0x35]),
+	}
+	if vm.VersionMajor != 4 || vm.VersionMinor != 10 {
+		return nil, fmt.Errorf("unsupported LDM VMDB version %d.%d", vm.VersionMajor, vm.VersionMinor)
+	}
+	if vm.VBLKSize < 0x20 || vm.VBLKSize > 512 || 512%vm.VBLKSize != 0 {
+		return nil, fmt.Errorf("invalid LDM VBLK size %d", vm.VBLKSize)
+	}
+	if vm.LastSeq > ldmMaxVBLKs {
+		return nil, fmt.Errorf("LDM VBLK count %d exceeds limit %d", vm.LastSeq, ldmMaxVBLKs)
+	}
+	return vm, nil
+}
+
+// ParseLDMVBLKs parses the VBLK slots of the config area. area starts at the
+// VMDB sector and must cover vm.LastSeq slots. Fragmented objects (records
+// spread over several slots) are reassembled before decoding; slots without a
+// VBLK magic or without records are skipped. Object types other than disks,
+// components, partitions and volumes (disk groups) are ignored.
+func ParseLDMVBLKs(area []byte, vm *LDMVMDB) (*LDMDatabase, error) {
+	size := int(vm.VBLKSize)
+	end := int(vm.LastSeq) * size
+	if end > len(area) {
+		return nil, fmt.Errorf("LDM config area truncated: need %d bytes, have %d", end, len(area))
+	}
+
+	type fragSet struct {
+		count uint16
+		parts map[uint16][]byte
+	}
+	var whole [][]byte
+	frags := make(map[uint32]*fragSet)
+	var fragOrder []uint32
+	for off := int(vm.VBLKOffset); off+size <= end; off += size {
+		slot := area[off : off+size]
+		if string(slot[0:4]) != "VBLK" {
+			continue
+		}
+		group := binary.BigEndian.Uint32(slot[0x08:0x0C])
+		rec := binary.BigEndian.Uint16(slot[0x0C:0x0E])
+		recs := binary.BigEndian.Uint16(slot[0x0E:0x10])
+		switch {
+		case recs == 0:
+			continue
+		case recs == 1:
+			whole = append(whole, slot)
+		default:
+			if rec >= recs {
+				return nil, fmt.Errorf("LDM VBLK fragment %d of %d out of range", rec, recs)
+			}
+			fs, ok := frags[group]
+			if !ok {
+				fs = &fragSet{count: recs, parts: make(map[uint16][]byte)}
+				frags[group] = fs
+				fragOrder = append(fragOrder, group)
+			}
+			if fs.count != recs {
+				return nil, fmt.Errorf("LDM VBLK group %d has inconsistent fragment counts", group)
+			}
+			fs.parts[rec] = slot
+		}
+	}
+	for _, g := range fragOrder {
+		fs := frags[g]
+		if len(fs.parts) != int(fs.count) {
+			return nil, fmt.Errorf("LDM VBLK group %d is missing fragments (%d of %d)", g, len(fs.parts), fs.count)
+		}
+		buf := append([]byte(nil), fs.parts[0][:0x10]...)
+		for r := uint16(0); r < fs.count; r++ {
+			buf = append(buf, fs.parts[r][0x10:]...)
+		}
+		whole = append(whole, buf)
+	}
+
+	db := &LDMDatabase{}
+	for _, blk := range whole {
+		if err := db.addVBLK(blk); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// addVBLK decodes one (reassembled) VBLK object into the database.
+func (db *LDMDatabase) addVBLK(b []byte) error {
+	if len(b) < 0x19 {
+		return fmt.Errorf("LDM VBLK too short (%d bytes)", len(b))
+	}
+	flags := b[0x12]
+	typ := b[0x13]
+	v := &vblkCursor{b: b}
+	rObjID := v.rel(0x18, 0)
+	rName := v.rel(0x18, rObjID)
+	objID := v.num(0x18)
+	name := v.str(0x18 + rObjID)
+
+	switch typ {
+	case ldmVBLKComponent:
+		rState := v.rel(0x18, rName)
+		rChild := v.rel(0x1D, rState)
+		rParent := v.rel(0x2D, rChild)
+		c := LDMComponent{
+			ObjectID: objID,
+			Name:     name,
+			Layout:   v.byteAt(0x18 + rState),
+			Children: v.num(0x1D + rState),
+			ParentID: v.num(0x2D + rChild),
+		}
+		if flags&ldmFlagCompStripe != 0 {
+			rStripe := v.rel(0x2E, rParent)
+			c.StripeSectors = v.num(0x2E + rParent)
+			c.Columns = v.num(0x2E + rStripe)
+		}
+		if v.err != nil {
+			return fmt.Errorf("LDM component %q: %w", name, v.err)
+		}
+		db.Components = append(db.Components, c)
+	case ldmVBLKPartition:
+		rSize := v.rel(0x34, rName)
+		rParent := v.rel(0x34, rSize)
+		rDisk := v.rel(0x34, rParent)
+		p := LDMPartition{
+			ObjectID:     objID,
+			Name:         name,
+			Start:        v.u64(0x24 + rName),
+			VolumeOffset: v.u64(0x2C + rName),
+			Size:         v.num(0x34 + rName),
+			ParentID:     v.num(0x34 + rSize),
+			DiskID:       v.num(0x34 + rParent),
+		}
+		if flags&ldmFlagPartIndex != 0 {
+			p.Index = uint64(v.byteAt(0x35 + rDisk))
+			p.HasIndex = true
+		}
+		if v.err != nil {
+			return fmt.Errorf("LDM partition %q: %w", name, v.err)
+		}
+		db.Partitions = append(db.Partitions, p)
+	case ldmVBLKDisk3:
+		d := LDMDisk{ObjectID: objID, Name: name, DiskID: strings.ToLower(v.str(0x18 + rName))}
+		if v.err != nil {
+			return fmt.Errorf("LDM disk %q: %w", name, v.err)
+		}
+		db.Disks = append(db.Disks, d)
+	case ldmVBLKDisk4:
+		off := 0x18 + rName
+		if v.err == nil && off+16 > len(b) {
+			v.err = fmt.Errorf("disk GUID overruns VBLK")
+		}
+		if v.err != nil {
+			return fmt.Errorf("LDM disk %q: %w", name, v.err)
+		}
+		db.Disks = append(db.Disks, LDMDisk{ObjectID: objID, Name: name, DiskID: FormatGUID(b[off : off+16])})
+	case ldmVBLKVolume:
+		rType := v.rel(0x18, rName)
+		rDisable := v.rel(0x18, rType)
+		rChild := v.rel(0x2D, rDisable)
+		rSize := v.rel(0x3D, rChild)
+		vol := LDMVolume{
+			ObjectID:      objID,
+			Name:          name,
+			Type:          v.str(0x18 + rName),
+			Children:      v.num(0x2D + rDisable),
+			Size:          v.num(0x3D + rChild),
+			PartitionType: v.byteAt(0x41 + rSize),
+		}
+		next := rSize
+		if flags&ldmFlagVolumeID1 != 0 {
+			next = v.rel(0x52, next)
+		}
+		if flags&ldmFlagVolumeID2 != 0 {
+			next = v.rel(0x52, next)
+		}
+		if flags&ldmFlagVolumeSize != 0 {
+			next = v.rel(0x52, next)
+		}
+		if flags&ldmFlagVolumeDrive != 0 {
+			vol.DriveHint = v.str(0x52 + next)
+		}
+		if v.err != nil {
+			return fmt.Errorf("LDM volume %q: %w", name, v.err)
+		}
+		db.Volumes = append(db.Volumes, vol)
+	case ldmVBLKGroup3, ldmVBLKGroup4:
+		// Disk group objects carry nothing the volume layout needs.
+	}
+	return nil
+}
+
+// ComponentsOf returns the components (plexes) of a volume, in object-id order.
+func (db *LDMDatabase) ComponentsOf(volumeID uint64) []LDMComponent {
+	var out []LDMComponent
+	for _, c := range db.Components {
+		if c.ParentID == volumeID {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ObjectID < out[j].ObjectID })
+	return out
+}
+
+// PartitionsOf returns the partitions of a component, ordered by column index
+// and then volume offset.
+func (db *LDMDatabase) PartitionsOf(componentID uint64) []LDMPartition {
+	var out []LDMPartition
+	for _, p := range db.Partitions {
+		if p.ParentID == componentID {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Index != out[j].Index {
+			return out[i].Index < out[j].Index
+		}
+		return out[i].VolumeOffset < out[j].VolumeOffset
+	})
+	return out
+}
+
+// Disk returns the disk object with the given object id.
+func (db *LDMDatabase) Disk(objectID uint64) (LDMDisk, bool) {
+	for _, d := range db.Disks {
+		if d.ObjectID == objectID {
+			return d, true
+		}
+	}
+	return LDMDisk{}, false
+}
+
+// vblkCursor decodes the variable-length fields of one VBLK. The first bounds
+// violation is latched in err and every later accessor returns zero, so a
+// decoder reads its fields straight through and checks err once.
+type vblkCursor struct {
+	b   []byte
+	err error
+}
+
+// rel returns the offset just past the variable-length field at base+offset,
+// relative to base (the kernel's ldm_relative).
+func (v *vblkCursor) rel(base, offset int) int {
+	if v.err != nil {
+		return 0
+	}
+	p := base + offset
+	if offset < 0 || p >= len(v.b) || p+int(v.b[p]) >= len(v.b) {
+		v.err = fmt.Errorf("variable-length field at %d overruns VBLK", p)
+		return 0
+	}
+	return int(v.b[p]) + offset + 1
+}
+
+// num decodes a length-prefixed big-endian number.
+func (v *vblkCursor) num(off int) uint64 {
+	if v.err != nil {
+		return 0
+	}
+	if off >= len(v.b) {
+		v.err = fmt.Errorf("number at %d overruns VBLK", off)
+		return 0
+	}
+	n := int(v.b[off])
+	if n > 8 || off+1+n > len(v.b) {
+		v.err = fmt.Errorf("number at %d has invalid length %d", off, n)
+		return 0
+	}
+	var x uint64
+	for _, c := range v.b[off+1 : off+1+n] {
+		x = x<<8 | uint64(c)
+	}
+	return x
+}
+
+// str decodes a length-prefixed string.
+func (v *vblkCursor) str(off int) string {
+	if v.err != nil {
+		return ""
+	}
+	if off >= len(v.b) || off+1+int(v.b[off]) > len(v.b) {
+		v.err = fmt.Errorf("string at %d overruns VBLK", off)
+		return ""
+	}
+	return string(v.b[off+1 : off+1+int(v.b[off])])
+}
+
+func (v *vblkCursor) byteAt(off int) byte {
+	if v.err != nil {
+		return 0
+	}
+	if off >= len(v.b) {
+		v.err = fmt.Errorf("byte at %d overruns VBLK", off)
+		return 0
+	}
+	return v.b[off]
+}
+
+func (v *vblkCursor) u64(off int) uint64 {
+	if v.err != nil {
+		return 0
+	}
+	if off+8 > len(v.b) {
+		v.err = fmt.Errorf("field at %d overruns VBLK", off)
+		return 0
+	}
+	return binary.BigEndian.Uint64(v.b[off : off+8])
+}
+
+// cString returns b up to its first NUL byte.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// FormatGUID renders a 16-byte on-disk (mixed-endian) GUID in its canonical
+// lower-case string form.
+func FormatGUID(g []byte) string {
+	if len(g) < 16 {
+		return ""
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
@@ -0,0 +1,435 @@
+// Package luks reads LUKS (Linux Unified Key Setup) volumes: it parses the
+// LUKS1 header or the LUKS2 binary header and JSON metadata, recovers the
+// volume (master) key from a key slot with the examiner's passphrase, and
+// presents the decrypted payload as a volume.Volume the filesystem handlers
+// open unchanged.
+//
+// The layout follows the LUKS1 On-Disk Format Specification 1.2.3 and the
+// LUKS2 On-Disk Format Specification 1.1.
+//
+// LUKS1 header (592 bytes at offset 0, big-endian):
+//
+//	0    magic "LUKS\xba\xbe", version 1
+//	8    cipher name (32), cipher mode (32), hash spec (32)
+//	104  payload offset (512-byte sectors), key bytes
+//	112  master key digest (20), digest salt (32), digest iterations
+//	168  UUID (40)
+//	208  eight key slots of 48 bytes: state (0x00AC71F3 active), PBKDF2
+//	     iterations, salt (32), key material offset (sectors), stripes
+//
+// LUKS2 binary header (4096 bytes at offset 0, a copy at the header size):
+//
+//	0    magic "LUKS\xba\xbe", version 2, header size (binary + JSON)
+//	24   label (48), checksum algorithm (32), salt (64), UUID (40)
+//	448  checksum (64) of the header with this field zeroed and the JSON area
+//
+// The JSON area follows at 4096 and describes key slots (key size, AF
+// stripes and hash, key material area and cipher, KDF: pbkdf2, argon2i or
+// argon2id), data segments (offset, size, IV tweak, cipher, sector size) and
+// digests (PBKDF2 of the volume key, per key slot and segment).
+//
+// A key slot stores the volume key split into stripes by the anti-forensic
+// (AF) splitter and encrypted with a key derived from the passphrase.
+package luks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Magic is the signature of a LUKS header.
+const Magic = "LUKS\xba\xbe"
+
+// secondaryMagic is the signature of the LUKS2 secondary header.
+const secondaryMagic = "SKUL\xba\xbe"
+
+const (
+	luks1KeySlots    = 8
+	luks1SlotActive  = 0x00AC71F3
+	luks1DigestSize  = 20
+	luks2BinarySize  = 4096
+	maxLUKS2JSONSize = 4 << 20 // the largest metadata area cryptsetup writes
+	maxStripes       = 1 << 16 // hostile-input guard; cryptsetup writes 4000
+	maxKeyBytes      = 128
+)
+
+// luks2SecondaryOffsets are the possible offsets of the LUKS2 secondary
+// header, one per valid metadata size.
+var luks2SecondaryOffsets = []uint64{0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000}
+
+// KDF is a key derivation function with its parameters.
+type KDF struct {
+	Type       string // "pbkdf2", "argon2i" or "argon2id"
+	Hash       string // PBKDF2 hash
+	Iterations uint32 // PBKDF2 iterations or Argon2 passes
+	Memory     uint32 // Argon2 memory cost in KiB
+	Threads    uint32 // Argon2 lanes
+	Salt       []byte
+}
+
+// Keyslot is one active key slot.
+type Keyslot struct {
+	Index    int
+	KeyBytes int    // size of the key it stores
+	Cipher   string // cipher of the key material, e.g. "aes-xts-plain64"
+	AreaKey  int    // key size of the key material cipher
+	Stripes  uint32
+	AFHash   string
+	Offset   uint64 // key material offset in bytes from the header
+	Size     uint64 // key material area size in bytes
+	KDF      KDF
+}
+
+// digest checks a candidate volume key.
+type digest struct {
+	keyslots   []int
+	hash       string
+	iterations uint32
+	salt       []byte
+	value      []byte
+}
+
+// Header is the parsed LUKS header of a volume.
+type Header struct {
+	Version    int
+	UUID       string
+	Label      string // LUKS2 only
+	Cipher     string // data cipher, e.g. "aes-xts-plain64"
+	Hash       string // LUKS1 hash spec
+	KeyBytes   int    // volume key size
+	SectorSize uint32 // encryption sector size (512 for LUKS1)
+	Offset     uint64 // payload offset in bytes
+	Size       uint64 // payload size in bytes; 0 = to the end of the device
+	IVTweak    uint64 // added to the 512-byte sector number of the IV
+	Keyslots   []Keyslot
+	digests    []digest
+
+	src      filesystem.Reader // holds the key material
+	startLBA uint64
+}
+
+// IsHeader reports whether b starts with a LUKS header.
+func IsHeader(b []byte) bool {
+	return len(b) >= 8 && string(b[:6]) == Magic
+}
+
+// Parse reads the LUKS header of the volume at startLBA of src. A LUKS2
+// header whose checksum fails is replaced by a valid secondary header.
+func Parse(src filesystem.Reader, startLBA uint64) (*Header, error) {
+	b, err := volume.ReadBytes(src, startLBA, 0, luks2BinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("luks: header: %w", err)
+	}
+	if !IsHeader(b) {
+		return nil, fmt.Errorf("luks: no LUKS signature")
+	}
+	var h *Header
+	switch v := binary.BigEndian.Uint16(b[6:8]); v {
+	case 1:
+		h, err = parseLUKS1(b)
+	case 2:
+		h, err = parseLUKS2(src, startLBA, 0)
+		for _, off := range luks2SecondaryOffsets {
+			if err == nil {
+				break
+			}
+			if h2, err2 := parseLUKS2(src, startLBA, off); err2 == nil {
+				h, err = h2, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("luks: version %d: %w", v, filesystem.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.src, h.startLBA = src, startLBA
+	return h, nil
+}
+
+func parseLUKS1(b []byte) (*Header, error) {
+	be := binary.BigEndian
+	cipherName := cString(b[8:40])
+	mode := cString(b[40:72])
+	h := &Header{
+		Version:    1,
+		Cipher:     cipherName + "-" + mode,
+		Hash:       cString(b[72:104]),
+		Offset:     uint64(be.Uint32(b[104:108])) * 512,
+		KeyBytes:   int(be.Uint32(b[108:112])),
+		UUID:       cString(b[168:208]),
+		SectorSize: 512,
+	}
+	if h.KeyBytes == 0 || h.KeyBytes > maxKeyBytes {
+		return nil, fmt.Errorf("luks: invalid key size %d", h.KeyBytes)
+	}
+	h.digests = []digest{{
+		hash:       h.Hash,
+		iterations: be.Uint32(b[164:168]),
+		salt:       append([]byte(nil), b[132:164]...),
+		value:      append([]byte(nil), b[112:112+luks1DigestSize]...),
+	}}
+	for i := 0; i < luks1KeySlots; i++ {
+		s := b[208+48*i : 208+48*(i+1)]
+		if be.Uint32(s[0:4]) != luks1SlotActive {
+			continue
+		}
+		ks := Keyslot{
+			Index:    i,
+			KeyBytes: h.KeyBytes,
+			Cipher:   h.Cipher,
+			AreaKey:  h.KeyBytes,
+			Stripes:  be.Uint32(s[44:48]),
+			AFHash:   h.Hash,
+			Offset:   uint64(be.Uint32(s[40:44])) * 512,
+			KDF: KDF{
+				Type:       "pbkdf2",
+				Hash:       h.Hash,
+				Iterations: be.Uint32(s[4:8]),
+				Salt:       append([]byte(nil), s[8:40]...),
+			},
+		}
+		ks.Size = uint64(ks.KeyBytes) * uint64(ks.Stripes)
+		if ks.Stripes == 0 || ks.Stripes > maxStripes {
+			return nil, fmt.Errorf("luks: key slot %d: invalid stripe count %d", i, ks.Stripes)
+		}
+		h.Keyslots = append(h.Keyslots, ks)
+		h.digests[0].keyslots = append(h.digests[0].keyslots, i)
+	}
+	return h, nil
+}
+
+// LUKS2 JSON metadata; sizes and offsets are decimal strings.
+type jsonMetadata struct {
+	Keyslots map[string]struct {
+		Type    string `json:"type"`
+		KeySize int    `json:"key_size"`
+		AF      struct {
+			Type    string `json:"type"`
+			Stripes uint32 `json:"stripes"`
+			Hash    string `json:"hash"`
+		} `json:"af"`
+		Area struct {
+			Type       string `json:"type"`
+			Offset     string `json:"offset"`
+			Size       string `json:"size"`
+			Encryption string `json:"encryption"`
+			KeySize    int    `json:"key_size"`
+		} `json:"area"`
+		KDF struct {
+			Type       string `json:"type"`
+			Hash       string `json:"hash"`
+			Iterations uint32 `json:"iterations"`
+			Time       uint32 `json:"time"`
+			Memory     uint32 `json:"memory"`
+			CPUs       uint32 `json:"cpus"`
+			Salt       string `json:"salt"`
+		} `json:"kdf"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Offset     string `json:"offset"`
+		Size       string `json:"size"`
+		IVTweak    string `json:"iv_tweak"`
+		Encryption string `json:"encryption"`
+		SectorSize uint32 `json:"sector_size"`
+	} `json:"segments"`
+	Digests map[string]struct {
+		Type       string   `json:"type"`
+		Keyslots   []string `json:"keyslots"`
+		Segments   []string `json:"segments"`
+		Hash       string   `json:"hash"`
+		Iterations uint32   `json:"iterations"`
+		Salt       string   `json:"salt"`
+		Digest     string   `json:"digest"`
+	} `json:"digests"`
+}
+
+// parseLUKS2 reads the LUKS2 header at byte offset off.
+func parseLUKS2(src filesystem.Reader, startLBA, off uint64) (*Header, error) {
+	b, err := volume.ReadBytes(src, startLBA, off, luks2BinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("luks2: header at %d: %w", off, err)
+	}
+	magic := Magic
+	if off != 0 {
+		magic = secondaryMagic
+	}
+	if string(b[:6]) != magic || binary.BigEndian.Uint16(b[6:8]) != 2 {
+		return nil, fmt.Errorf("luks2: no header at offset %d", off)
+	}
+	hdrSize := binary.BigEndian.Uint64(b[8:16])
+	if hdrSize <= luks2BinarySize || hdrSize-luks2BinarySize > maxLUKS2JSONSize || hdrSize%4096 != 0 {
+		return nil, fmt.Errorf("luks2: invalid header size %d", hdrSize)
+	}
+	area, err := volume.ReadBytes(src, startLBA, off+luks2BinarySize, hdrSize-luks2BinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("luks2: JSON area: %w", err)
+	}
+	if alg := cString(b[72:104]); alg != "sha256" {
+		return nil, fmt.Errorf("luks2: header checksum %q: %w", alg, filesystem.ErrUnsupported)
+	}
+	sum := sha256.New()
+	hdr := append([]byte(nil), b...)
+	clear(hdr[448:512])
+	sum.Write(hdr)
+	sum.Write(area)
+	if !bytes.Equal(sum.Sum(nil), b[448:448+sha256.Size]) {
+		return nil, fmt.Errorf("luks2: header checksum mismatch at offset %d", off)
+	}
+
+	if i := bytes.IndexByte(area, 0); i >= 0 {
+		area = area[:i]
+	}
+	var md jsonMetadata
+	if err := json.Unmarshal(area, &md); err != nil {
+		return nil, fmt.Errorf("luks2: JSON metadata: %w", err)
+	}
+	h := &Header{
+		Version: 2,
+		Label:   cString(b[24:72]),
+		UUID:    cString(b[168:208]),
+	}
+
+	seg, ok := md.Segments["0"]
+	if !ok || seg.Type != "crypt" {
+		return nil, fmt.Errorf("luks2: no crypt segment 0")
+	}
+	h.Cipher = seg.Encryption
+	h.SectorSize = seg.SectorSize
+	if h.SectorSize < 512 || h.SectorSize > 4096 || h.SectorSize&(h.SectorSize-1) != 0 {
+		return nil, fmt.Errorf("luks2: invalid sector size %d", h.SectorSize)
+	}
+	if h.Offset, err = jsonUint(seg.Offset); err != nil {
+		return nil, fmt.Errorf("luks2: segment offset: %w", err)
+	}
+	if seg.Size != "dynamic" {
+		if h.Size, err = jsonUint(seg.Size); err != nil {
+			return nil, fmt.Errorf("luks2: segment size: %w", err)
+		}
+	}
+	if seg.IVTweak != "" {
+		if h.IVTweak, err = jsonUint(seg.IVTweak); err != nil {
+			return nil, fmt.Errorf("luks2: segment IV tweak: %w", err)
+		}
+	}
+
+	for id, d := range md.Digests {
+		if d.Type != "pbkdf2" {
+			return nil, fmt.Errorf("luks2: digest %s type %q: %w", id, d.Type, filesystem.ErrUnsupported)
+		}
+		if !contains(d.Segments, "0") {
+			continue
+		}
+		dg := digest{hash: d.Hash, iterations: d.Iterations}
+		if dg.salt, err = base64.StdEncoding.DecodeString(d.Salt); err != nil {
+			return nil, fmt.Errorf("luks2: digest %s salt: %w", id, err)
+		}
+		if dg.value, err = base64.StdEncoding.DecodeString(d.Digest); err != nil {
+			return nil, fmt.Errorf("luks2: digest %s: %w", id, err)
+		}
+		for _, k := range d.Keyslots {
+			n, err := strconv.Atoi(k)
+			if err != nil {
+				return nil, fmt.Errorf("luks2: digest %s key slot %q", id, k)
+			}
+			dg.keyslots = append(dg.keyslots, n)
+		}
+		h.digests = append(h.digests, dg)
+	}
+	if len(h.digests) == 0 {
+		return nil, fmt.Errorf("luks2: no digest for segment 0")
+	}
+
+	for id, k := range md.Keyslots {
+		idx, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("luks2: key slot %q", id)
+		}
+		if k.Type != "luks2" || k.AF.Type != "luks1" || k.Area.Type != "raw" {
+			continue // reencryption or unknown key slot types hold no volume key
+		}
+		if !h.forSegment(idx) {
+			continue
+		}
+		ks := Keyslot{
+			Index:    idx,
+			KeyBytes: k.KeySize,
+			Cipher:   k.Area.Encryption,
+			AreaKey:  k.Area.KeySize,
+			Stripes:  k.AF.Stripes,
+			AFHash:   k.AF.Hash,
+			KDF: KDF{
+				Type:       k.KDF.Type,
+				Hash:       k.KDF.Hash,
+				Iterations: k.KDF.Iterations,
+				Memory:     k.KDF.Memory,
+				Threads:    k.KDF.CPUs,
+			},
+		}
+		if ks.KDF.Type != "pbkdf2" {
+			ks.KDF.Iterations = k.KDF.Time
+		}
+		if ks.KDF.Salt, err = base64.StdEncoding.DecodeString(k.KDF.Salt); err != nil {
+			return nil, fmt.Errorf("luks2: key slot %d salt: %w", idx, err)
+		}
+		if ks.Offset, err = jsonUint(k.Area.Offset); err != nil {
+			return nil, fmt.Errorf("luks2: key slot %d area offset: %w", idx, err)
+		}
+		if ks.Size, err = jsonUint(k.Area.Size); err != nil {
+			return nil, fmt.Errorf("luks2: key slot %d area size: %w", idx, err)
+		}
+		if ks.KeyBytes <= 0 || ks.KeyBytes > maxKeyBytes || ks.Stripes == 0 || ks.Stripes > maxStripes ||
+			uint64(ks.KeyBytes)*uint64(ks.Stripes) > ks.Size {
+			return nil, fmt.Errorf("luks2: key slot %d: invalid key size %d or stripe count %d", idx, ks.KeyBytes, ks.Stripes)
+		}
+		if h.KeyBytes == 0 {
+			h.KeyBytes = ks.KeyBytes
+		}
+		h.Keyslots = append(h.Keyslots, ks)
+	}
+	sort.Slice(h.Keyslots, func(i, j int) bool { return h.Keyslots[i].Index < h.Keyslots[j].Index })
+	return h, nil
+}
+
+// forSegment reports whether key slot idx has a digest of segment 0.
+func (h *Header) forSegment(idx int) bool {
+	for _, d := range h.digests {
+		for _, k := range d.keyslots {
+			if k == idx {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonUint(s string) (uint64, error) {
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
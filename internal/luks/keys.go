@@ -0,0 +1,235 @@
+package luks
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Key is the examiner's key material. A master key, when set, is used
+// directly; otherwise the passphrase is tried against every key slot.
+type Key struct {
+	Passphrase string
+	// MasterKey is the volume key itself, e.g. recovered from memory or from
+	// a dm-crypt table.
+	MasterKey []byte
+}
+
+// hashFunc returns the hash of a LUKS hash spec.
+func hashFunc(name string) (func() hash.Hash, error) {
+	switch name {
+	case "sha1":
+		return sha1.New, nil
+	case "sha224":
+		return sha256.New224, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha384":
+		return sha512.New384, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("luks: hash %q: %w", name, filesystem.ErrUnsupported)
+}
+
+// Unlock recovers the volume key from key. A passphrase that opens no key
+// slot, or a master key that does not match the volume key digest, fails
+// with filesystem.ErrWrongKey.
+func (h *Header) Unlock(key Key) ([]byte, error) {
+	if key.MasterKey != nil {
+		if len(key.MasterKey) != h.KeyBytes {
+			return nil, fmt.Errorf("luks: %w: master key is %d bytes, the volume key %d", filesystem.ErrWrongKey, len(key.MasterKey), h.KeyBytes)
+		}
+		for _, d := range h.digests {
+			ok, err := d.check(key.MasterKey)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return key.MasterKey, nil
+			}
+		}
+		return nil, fmt.Errorf("luks: %w: master key does not match the volume key digest", filesystem.ErrWrongKey)
+	}
+	if key.Passphrase == "" {
+		return nil, fmt.Errorf("luks: no passphrase or master key given")
+	}
+	if len(h.Keyslots) == 0 {
+		return nil, fmt.Errorf("luks: no active key slot")
+	}
+
+	var errs []error
+	for _, ks := range h.Keyslots {
+		mk, err := h.openKeyslot(ks, key.Passphrase)
+		if err == nil {
+			return mk, nil
+		}
+		if !errors.Is(err, filesystem.ErrWrongKey) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fmt.Errorf("luks: %w: passphrase opens no key slot", filesystem.ErrWrongKey)
+}
+
+// openKeyslot derives the key of ks from passphrase, decrypts and merges its
+// key material and checks the result against the digest.
+func (h *Header) openKeyslot(ks Keyslot, passphrase string) ([]byte, error) {
+	k, err := DeriveKey(ks.KDF, passphrase, ks.AreaKey)
+	if err != nil {
+		return nil, fmt.Errorf("luks: key slot %d: %w", ks.Index, err)
+	}
+	c, err := NewCipher(ks.Cipher, k)
+	if err != nil {
+		return nil, fmt.Errorf("luks: key slot %d: %w", ks.Index, err)
+	}
+	n := uint64(ks.KeyBytes) * uint64(ks.Stripes)
+	material, err := volume.ReadBytes(h.src, h.startLBA, ks.Offset, (n+511)/512*512)
+	if err != nil {
+		return nil, fmt.Errorf("luks: key slot %d material: %w", ks.Index, err)
+	}
+	for s := uint64(0); s < uint64(len(material)); s += 512 {
+		c.Decrypt(material[s:s+512], material[s:s+512], s/512)
+	}
+	mk, err := AFMerge(material[:n], ks.KeyBytes, int(ks.Stripes), ks.AFHash)
+	if err != nil {
+		return nil, fmt.Errorf("luks: key slot %d: %w", ks.Index, err)
+	}
+	for _, d := range h.digests {
+		if !d.hasKeyslot(ks.Index) {
+			continue
+		}
+		ok, err := d.check(mk)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return mk, nil
+		}
+	}
+	return nil, fmt.Errorf("luks: key slot %d: %w", ks.Index, filesystem.ErrWrongKey)
+}
+
+// DeriveKey derives a keyLen-byte key slot key from passphrase.
+func DeriveKey(kdf KDF, passphrase string, keyLen int) ([]byte, error) {
+	switch kdf.Type {
+	case "pbkdf2":
+		hf, err := hashFunc(kdf.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if kdf.Iterations == 0 {
+			return nil, fmt.Errorf("PBKDF2 iteration count is zero")
+		}
+		return pbkdf2.Key(hf, passphrase, kdf.Salt, int(kdf.Iterations), keyLen)
+	case "argon2i":
+		return crypt.Argon2Key(crypt.Argon2i, []byte(passphrase), kdf.Salt, kdf.Iterations, kdf.Memory, kdf.Threads, keyLen)
+	case "argon2id":
+		return crypt.Argon2Key(crypt.Argon2id, []byte(passphrase), kdf.Salt, kdf.Iterations, kdf.Memory, kdf.Threads, keyLen)
+	}
+	return nil, fmt.Errorf("KDF %q: %w", kdf.Type, filesystem.ErrUnsupported)
+}
+
+func (d digest) hasKeyslot(idx int) bool {
+	for _, k := range d.keyslots {
+		if k == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports whether mk matches the digest.
+func (d digest) check(mk []byte) (bool, error) {
+	hf, err := hashFunc(d.hash)
+	if err != nil {
+		return false, err
+	}
+	if d.iterations == 0 || len(d.value) == 0 {
+		return false, fmt.Errorf("luks: invalid volume key digest")
+	}
+	got, err := pbkdf2.Key(hf, string(mk), d.salt, int(d.iterations), len(d.value))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(got, d.value), nil
+}
+
+// AFMerge recovers a keyBytes-byte key from its stripes of AF-split
+// material: d = diffuse(d ^ s_i) over all stripes but the last, key =
+// d ^ s_last.
+func AFMerge(material []byte, keyBytes, stripes int, hashName string) ([]byte, error) {
+	hf, err := hashFunc(hashName)
+	if err != nil {
+		return nil, err
+	}
+	if len(material) != keyBytes*stripes {
+		return nil, fmt.Errorf("AF material is %d bytes, want %d", len(material), keyBytes*stripes)
+	}
+	d := make([]byte, keyBytes)
+	for i := 0; i < stripes-1; i++ {
+		xorBytes(d, material[i*keyBytes:(i+1)*keyBytes])
+		d = diffuse(hf, d)
+	}
+	xorBytes(d, material[(stripes-1)*keyBytes:])
+	return d, nil
+}
+
+// AFSplit splits key into stripes using random for all stripes but the
+// last; it is the inverse of AFMerge.
+func AFSplit(key, random []byte, stripes int, hashName string) ([]byte, error) {
+	hf, err := hashFunc(hashName)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key)
+	if len(random) != n*(stripes-1) {
+		return nil, fmt.Errorf("AF random data is %d bytes, want %d", len(random), n*(stripes-1))
+	}
+	out := append(append([]byte(nil), random...), make([]byte, n)...)
+	d := make([]byte, n)
+	for i := 0; i < stripes-1; i++ {
+		xorBytes(d, random[i*n:(i+1)*n])
+		d = diffuse(hf, d)
+	}
+	last := out[(stripes-1)*n:]
+	copy(last, key)
+	xorBytes(last, d)
+	return out, nil
+}
+
+// diffuse hashes d block by block: block j is H(be32(j) || block j),
+// truncated to the block length.
+func diffuse(hf func() hash.Hash, d []byte) []byte {
+	h := hf()
+	size := h.Size()
+	out := make([]byte, 0, len(d))
+	var iv [4]byte
+	for j := 0; j*size < len(d); j++ {
+		end := min((j+1)*size, len(d))
+		h.Reset()
+		binary.BigEndian.PutUint32(iv[:], uint32(j))
+		h.Write(iv[:])
+		h.Write(d[j*size : end])
+		out = append(out, h.Sum(nil)[:end-j*size]...)
+	}
+	return out
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,184 @@
+package luks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Cipher encrypts and decrypts dm-crypt sectors of a cipher specification
+// "cipher-chainmode-ivmode[:ivopts]": aes-xts-plain64, aes-xts-plain,
+// aes-cbc-essiv:<hash>, aes-cbc-plain64 and aes-cbc-plain.
+type Cipher struct {
+	xts   *crypt.XTS
+	cbc   cipher.Block
+	essiv cipher.Block // ESSIV salt cipher, keyed with the hash of the key
+	iv64  bool         // plain64 rather than the 32-bit plain
+}
+
+// NewCipher builds the sector cipher of spec keyed with key.
+func NewCipher(spec string, key []byte) (*Cipher, error) {
+	parts := strings.SplitN(spec, "-", 3)
+	if len(parts) != 3 || parts[0] != "aes" {
+		return nil, fmt.Errorf("cipher %q: %w", spec, filesystem.ErrUnsupported)
+	}
+	mode, ivMode := parts[1], parts[2]
+	c := &Cipher{}
+	var err error
+	switch {
+	case mode == "xts" && (ivMode == "plain64" || ivMode == "plain"):
+		c.iv64 = ivMode == "plain64"
+		c.xts, err = crypt.NewXTS(key)
+	case mode == "cbc" && (ivMode == "plain64" || ivMode == "plain"):
+		c.iv64 = ivMode == "plain64"
+		c.cbc, err = aes.NewCipher(key)
+	case mode == "cbc" && strings.HasPrefix(ivMode, "essiv:"):
+		var hf func() hash.Hash
+		if hf, err = hashFunc(strings.TrimPrefix(ivMode, "essiv:")); err != nil {
+			return nil, err
+		}
+		h := hf()
+		h.Write(key)
+		if c.essiv, err = aes.NewCipher(h.Sum(nil)); err != nil {
+			return nil, fmt.Errorf("cipher %q: ESSIV hash does not form an AES key", spec)
+		}
+		c.iv64 = true
+		c.cbc, err = aes.NewCipher(key)
+	default:
+		return nil, fmt.Errorf("cipher %q: %w", spec, filesystem.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cipher %q key: %w", spec, err)
+	}
+	return c, nil
+}
+
+// Decrypt decrypts the sector with IV sector number iv from src into dst
+// (which may alias src).
+func (c *Cipher) Decrypt(dst, src []byte, iv uint64) {
+	if !c.iv64 {
+		iv = uint64(uint32(iv))
+	}
+	if c.xts != nil {
+		c.xts.Decrypt(dst, src, iv)
+		return
+	}
+	cipher.NewCBCDecrypter(c.cbc, c.iv(iv)).CryptBlocks(dst, src)
+}
+
+// Encrypt encrypts the sector with IV sector number iv; it is the inverse
+// of Decrypt.
+func (c *Cipher) Encrypt(dst, src []byte, iv uint64) {
+	if !c.iv64 {
+		iv = uint64(uint32(iv))
+	}
+	if c.xts != nil {
+		c.xts.Encrypt(dst, src, iv)
+		return
+	}
+	cipher.NewCBCEncrypter(c.cbc, c.iv(iv)).CryptBlocks(dst, src)
+}
+
+// iv is the CBC IV of sector n: n little-endian, encrypted under the ESSIV
+// salt for essiv.
+func (c *Cipher) iv(n uint64) []byte {
+	iv := make([]byte, 16)
+	binary.LittleEndian.PutUint64(iv, n)
+	if c.essiv != nil {
+		c.essiv.Encrypt(iv, iv)
+	}
+	return iv
+}
+
+// Volume is the decrypted payload of a LUKS volume: a volume.Volume whose
+// LBA 0 is the first sector of the mapped (plaintext) device.
+//
+// Each encryption sector of SectorSize bytes is decrypted with the IV of
+// its 512-byte sector number plus the segment's IV tweak; for larger
+// sectors the number counts SectorSize units (dm-crypt iv_large_sectors,
+// which cryptsetup always sets for LUKS2).
+type Volume struct {
+	src      filesystem.Reader
+	startLBA uint64 // first sector of the payload in src
+	sectors  uint64
+	h        *Header
+	c        *Cipher
+}
+
+// NewVolume builds the decrypted view of the payload of the LUKS volume of
+// sectors sectors at startLBA of src. A trailing partial encryption sector
+// is not part of the view.
+func NewVolume(src filesystem.Reader, startLBA, sectors uint64, h *Header, key []byte) (*Volume, error) {
+	c, err := NewCipher(h.Cipher, key)
+	if err != nil {
+		return nil, fmt.Errorf("luks: %w", err)
+	}
+	if h.Offset%512 != 0 || h.Offset/512 >= sectors {
+		return nil, fmt.Errorf("luks: payload offset %d is outside the %d-sector volume", h.Offset, sectors)
+	}
+	n := sectors - h.Offset/512
+	if h.Size != 0 {
+		if h.Size/512 > n {
+			return nil, fmt.Errorf("luks: segment of %d bytes exceeds the volume", h.Size)
+		}
+		n = h.Size / 512
+	}
+	per := uint64(h.SectorSize) / 512
+	n -= n % per
+	if n == 0 {
+		return nil, fmt.Errorf("luks: payload is smaller than one sector")
+	}
+	return &Volume{src: src, startLBA: startLBA + h.Offset/512, sectors: n, h: h, c: c}, nil
+}
+
+// Open parses the LUKS volume at startLBA of src, recovers its volume key
+// with key and returns the decrypted payload.
+func Open(src filesystem.Reader, startLBA, sectors uint64, key Key) (*Volume, error) {
+	h, err := Parse(src, startLBA)
+	if err != nil {
+		return nil, err
+	}
+	mk, err := h.Unlock(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewVolume(src, startLBA, sectors, h, mk)
+}
+
+// Header returns the LUKS header of the volume.
+func (v *Volume) Header() *Header { return v.h }
+
+// Sectors returns the payload size in 512-byte sectors.
+func (v *Volume) Sectors() uint64 { return v.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (v *Volume) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := volume.CheckRange(lba, count, v.sectors); err != nil {
+		return nil, err
+	}
+
+	ss := uint64(v.h.SectorSize)
+	per := ss / 512
+	first := lba / per * per
+	last := (lba + count + per - 1) / per * per
+	data, err := v.src.ReadSectors(v.startLBA+first, last-first)
+	if err != nil {
+		return nil, fmt.Errorf("luks sector %d: %w", lba, err)
+	}
+	if uint64(len(data)) != (last-first)*512 {
+		return nil, fmt.Errorf("luks sector %d: short read of %d bytes", lba, len(data))
+	}
+	for i := uint64(0); i < uint64(len(data)); i += ss {
+		iv := (first + i/512 + v.h.IVTweak) / per
+		v.c.Decrypt(data[i:i+ss], data[i:i+ss], iv)
+	}
+	skip := (lba - first) * 512
+	return data[skip : skip+count*512], nil
+}
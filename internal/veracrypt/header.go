@@ -0,0 +1,431 @@
+// Package veracrypt reads VeraCrypt and TrueCrypt volumes — encrypted
+// partitions and file containers: it derives the header key from the
+// examiner's password (with PIM and keyfiles), finds the PRF and
+// encryption algorithm by trial as VeraCrypt does, decrypts the volume
+// header and presents the decrypted data area as a volume.Volume the
+// filesystem handlers open unchanged.
+//
+// The layout follows the VeraCrypt Volume Format Specification. A volume
+// starts with a 64 KiB normal header area and a 64 KiB hidden volume
+// header area; the data area follows at 128 KiB, and backup copies of both
+// headers fill the last 128 KiB. Each header is 512 bytes:
+//
+//	0    salt (64), in the clear
+//	64   magic "VERA" ("TRUE" for TrueCrypt), header version (2),
+//	     minimum program version (2)
+//	72   CRC-32 of the master key area (256..511)
+//	76   volume and header creation times (8 each)
+//	92   hidden volume size (8)
+//	100  volume size (8)
+//	108  encrypted area start and size (8 each), in bytes
+//	124  flags (4), sector size (4)
+//	252  CRC-32 of bytes 64..251
+//	256  master keys (256)
+//
+// Bytes 64..511 are encrypted as data unit 0 with the header key, which is
+// PBKDF2 of the password and salt under one of the PRFs; nothing in the
+// header names the PRF or the algorithm, so every combination is tried and
+// the magic and CRCs decide. All integers are big-endian.
+//
+// Data is encrypted in XTS mode over 512-byte data units numbered from the
+// start of the volume (so the first unit of a normal volume's data area is
+// 256). A cascade applies whole XTS passes of each cipher in turn. A
+// hidden volume lives inside the outer volume's free space; its header
+// gives its own encrypted area.
+//
+// System encryption (a boot drive, with the header in the first track) and
+// the Kuznyechik and Streebog algorithms are not supported.
+package veracrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/laenix/ewfgo/internal/crypt"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+const (
+	// HeaderSize is the size of one volume header.
+	HeaderSize = 512
+	// HeaderAreaSize is the size of each header area: the normal header,
+	// the hidden volume header and their backups.
+	HeaderAreaSize = 64 << 10
+	// DataAreaOffset is the start of a normal volume's data area.
+	DataAreaOffset = 2 * HeaderAreaSize
+
+	saltSize      = 64
+	masterKeyOff  = 256
+	maxKeyBytes   = 3 * 32 // the longest cascade, three 256-bit keys
+	maxPassword   = 128
+	legacyPool    = 64 // keyfile pool for passwords of up to 64 bytes
+	keyfileMaxLen = 1 << 20
+	// FlagSystem marks a system encryption header.
+	FlagSystem = 0x1
+)
+
+// Key is the examiner's key material.
+type Key struct {
+	Password []byte
+	// PIM is the Personal Iterations Multiplier; 0 selects the default
+	// iteration counts.
+	PIM int
+	// Keyfiles are the contents of the keyfiles, in any order VeraCrypt
+	// would apply them (their contributions are summed).
+	Keyfiles [][]byte
+	// PRF limits the trial to one PRF ("sha512", "sha256", "whirlpool",
+	// "blake2s" or "ripemd160"); empty tries them all.
+	PRF string
+	// TrueCrypt opens a TrueCrypt volume, with TrueCrypt's magic and
+	// iteration counts; PIM must be 0.
+	TrueCrypt bool
+	// BackupHeader also tries the backup headers at the end of the volume,
+	// after the primary headers fail.
+	BackupHeader bool
+}
+
+// PRF is a header key derivation function: HMAC of Hash in PBKDF2.
+type PRF struct {
+	Name string
+	Hash func() hash.Hash
+	// Iterations is VeraCrypt's default count; TrueCryptIterations is
+	// TrueCrypt's, 0 when TrueCrypt did not offer the PRF.
+	Iterations          int
+	TrueCryptIterations int
+}
+
+// PRFs are the supported PRFs in the order VeraCrypt tries them.
+var PRFs = []PRF{
+	{"sha512", sha512.New, 500000, 1000},
+	{"sha256", sha256.New, 500000, 0},
+	{"blake2s", crypt.NewBLAKE2s256, 500000, 0},
+	{"whirlpool", crypt.NewWhirlpool, 500000, 1000},
+	{"ripemd160", crypt.NewRIPEMD160, 655331, 2000},
+}
+
+// IterationCount is the PBKDF2 iteration count of p for key.
+func (p PRF) IterationCount(key Key) int {
+	switch {
+	case key.TrueCrypt:
+		return p.TrueCryptIterations
+	case key.PIM > 0:
+		return 15000 + key.PIM*1000
+	}
+	return p.Iterations
+}
+
+// Algorithm is an encryption algorithm: one cipher or a cascade.
+type Algorithm struct {
+	// Name is VeraCrypt's name, outermost cipher first ("AES-Twofish").
+	Name string
+	// Ciphers construct the ciphers in the order they encrypt, which is the
+	// reverse of the name; each takes a 256-bit key.
+	Ciphers []func(key []byte) (cipher.Block, error)
+}
+
+// KeyBytes is the size of one of the algorithm's two XTS keys.
+func (a Algorithm) KeyBytes() int { return 32 * len(a.Ciphers) }
+
+var (
+	aesCipher = aes.NewCipher
+	serpent   = crypt.NewSerpent
+	twofish   = crypt.NewTwofish
+	camellia  = crypt.NewCamellia
+)
+
+// Algorithms are the supported algorithms in the order VeraCrypt tries
+// them.
+var Algorithms = []Algorithm{
+	{"AES", []func([]byte) (cipher.Block, error){aesCipher}},
+	{"Serpent", []func([]byte) (cipher.Block, error){serpent}},
+	{"Twofish", []func([]byte) (cipher.Block, error){twofish}},
+	{"Camellia", []func([]byte) (cipher.Block, error){camellia}},
+	{"AES-Twofish", []func([]byte) (cipher.Block, error){twofish, aesCipher}},
+	{"AES-Twofish-Serpent", []func([]byte) (cipher.Block, error){serpent, twofish, aesCipher}},
+	{"Serpent-AES", []func([]byte) (cipher.Block, error){aesCipher, serpent}},
+	{"Serpent-Twofish-AES", []func([]byte) (cipher.Block, error){aesCipher, twofish, serpent}},
+	{"Twofish-Serpent", []func([]byte) (cipher.Block, error){serpent, twofish}},
+	{"Camellia-Serpent", []func([]byte) (cipher.Block, error){serpent, camellia}},
+}
+
+// Header is a decrypted volume header.
+type Header struct {
+	TrueCrypt          bool
+	Version            uint16
+	MinProgramVersion  uint16
+	HiddenVolumeSize   uint64 // in the outer volume's header, when it has one
+	VolumeSize         uint64
+	EncryptedAreaStart uint64
+	EncryptedAreaSize  uint64
+	Flags              uint32
+	SectorSize         uint32
+	// Offset is where the header was found in the volume; Hidden is set
+	// for a hidden volume header, Backup for a backup copy.
+	Offset    uint64
+	Hidden    bool
+	Backup    bool
+	PRF       string
+	Algorithm string
+	masterKey []byte
+	alg       Algorithm
+}
+
+// ApplyKeyfiles mixes the keyfiles into password as VeraCrypt does: each
+// keyfile's running CRC-32 is added into a pool byte by byte, and the pool
+// is added into the password, which it extends to the pool size.
+func ApplyKeyfiles(password []byte, keyfiles [][]byte) ([]byte, error) {
+	if len(keyfiles) == 0 {
+		return password, nil
+	}
+	size := legacyPool
+	if len(password) > legacyPool {
+		size = maxPassword
+	}
+	pool := make([]byte, size)
+	for i, kf := range keyfiles {
+		if len(kf) == 0 {
+			return nil, fmt.Errorf("veracrypt: keyfile %d is empty", i+1)
+		}
+		if len(kf) > keyfileMaxLen {
+			kf = kf[:keyfileMaxLen]
+		}
+		crc := ^uint32(0)
+		pos := 0
+		for _, b := range kf {
+			crc = crc32.IEEETable[byte(crc)^b] ^ crc>>8
+			pool[pos] += byte(crc >> 24)
+			pool[pos+1] += byte(crc >> 16)
+			pool[pos+2] += byte(crc >> 8)
+			pool[pos+3] += byte(crc)
+			if pos += 4; pos >= size {
+				pos = 0
+			}
+		}
+	}
+	out := make([]byte, max(len(password), size))
+	copy(out, password)
+	for i, b := range pool {
+		out[i] += b
+	}
+	return out, nil
+}
+
+// DeriveKey derives the header key of an algorithm with keyBytes-byte XTS
+// keys: the primary key followed by the secondary (tweak) key.
+func DeriveKey(p PRF, password, salt []byte, iterations, keyBytes int) ([]byte, error) {
+	return pbkdf2.Key(p.Hash, string(password), salt, iterations, 2*keyBytes)
+}
+
+// NewCipher builds the XTS passes of alg from a primary and secondary key,
+// each alg.KeyBytes() long: cipher i takes bytes 32i..32i+31 of both.
+func NewCipher(alg Algorithm, key []byte) (*Cipher, error) {
+	n := alg.KeyBytes()
+	if len(key) != 2*n {
+		return nil, fmt.Errorf("veracrypt: %s key is %d bytes, want %d", alg.Name, len(key), 2*n)
+	}
+	c := &Cipher{}
+	for i, newBlock := range alg.Ciphers {
+		k1, err := newBlock(key[32*i : 32*i+32])
+		if err != nil {
+			return nil, err
+		}
+		k2, err := newBlock(key[n+32*i : n+32*i+32])
+		if err != nil {
+			return nil, err
+		}
+		c.passes = append(c.passes, crypt.NewXTSCipher(k1, k2))
+	}
+	return c, nil
+}
+
+// Cipher encrypts and decrypts data units with an algorithm's XTS passes.
+type Cipher struct {
+	passes []*crypt.XTS
+}
+
+// Decrypt decrypts data unit unit from src into dst (which may alias src).
+func (c *Cipher) Decrypt(dst, src []byte, unit uint64) {
+	for i := len(c.passes) - 1; i >= 0; i-- {
+		c.passes[i].Decrypt(dst, src, unit)
+		src = dst
+	}
+}
+
+// Encrypt encrypts data unit unit; it is the inverse of Decrypt.
+func (c *Cipher) Encrypt(dst, src []byte, unit uint64) {
+	for _, p := range c.passes {
+		p.Encrypt(dst, src, unit)
+		src = dst
+	}
+}
+
+// prfs returns the PRFs key asks to try.
+func prfs(key Key) ([]PRF, error) {
+	var out []PRF
+	for _, p := range PRFs {
+		if (key.PRF == "" || key.PRF == p.Name) && p.IterationCount(key) > 0 {
+			out = append(out, p)
+		}
+	}
+	if len(out) == 0 {
+		if key.TrueCrypt && key.PRF != "" {
+			return nil, fmt.Errorf("veracrypt: PRF %q: not a TrueCrypt PRF: %w", key.PRF, filesystem.ErrUnsupported)
+		}
+		return nil, fmt.Errorf("veracrypt: PRF %q: %w", key.PRF, filesystem.ErrUnsupported)
+	}
+	return out, nil
+}
+
+// headerLocation is a place a header may be.
+type headerLocation struct {
+	off            uint64
+	hidden, backup bool
+}
+
+// Unlock finds the header key and returns the first header of the volume
+// of size bytes at startLBA of src that it decrypts: the normal header,
+// then the hidden volume header, then (with key.BackupHeader) their
+// backups. A password that decrypts none fails with
+// filesystem.ErrWrongKey.
+func Unlock(src filesystem.Reader, startLBA, size uint64, key Key) (*Header, error) {
+	if key.TrueCrypt && key.PIM != 0 {
+		return nil, fmt.Errorf("veracrypt: TrueCrypt volumes have no PIM")
+	}
+	if key.PIM < 0 {
+		return nil, fmt.Errorf("veracrypt: negative PIM %d", key.PIM)
+	}
+	if len(key.Password) > maxPassword {
+		return nil, fmt.Errorf("veracrypt: password is %d bytes, the limit is %d", len(key.Password), maxPassword)
+	}
+	password, err := ApplyKeyfiles(key.Password, key.Keyfiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("veracrypt: no password or keyfile given")
+	}
+	prfList, err := prfs(key)
+	if err != nil {
+		return nil, err
+	}
+	if size < 2*DataAreaOffset {
+		return nil, fmt.Errorf("veracrypt: volume of %d bytes is too small", size)
+	}
+
+	locs := []headerLocation{{0, false, false}, {HeaderAreaSize, true, false}}
+	if key.BackupHeader {
+		locs = append(locs, headerLocation{size - DataAreaOffset, false, true}, headerLocation{size - HeaderAreaSize, true, true})
+	}
+	var errs []error
+	for _, loc := range locs {
+		raw, err := volume.ReadBytes(src, startLBA, loc.off, HeaderSize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("veracrypt: header at %d: %w", loc.off, err))
+			continue
+		}
+		h, err := tryHeader(raw, password, prfList, key)
+		if err != nil {
+			if !errors.Is(err, filesystem.ErrWrongKey) {
+				errs = append(errs, fmt.Errorf("veracrypt: header at %d: %w", loc.off, err))
+			}
+			continue
+		}
+		h.Offset, h.Hidden, h.Backup = loc.off, loc.hidden, loc.backup
+		if err := h.check(size); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return h, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fmt.Errorf("veracrypt: %w: password, PIM or keyfiles decrypt no volume header", filesystem.ErrWrongKey)
+}
+
+// tryHeader derives the header key under each PRF and tries every algorithm
+// on the encrypted header raw.
+func tryHeader(raw, password []byte, prfList []PRF, key Key) (*Header, error) {
+	salt := raw[:saltSize]
+	for _, p := range prfList {
+		// The longest key serves every algorithm, which takes its primary
+		// and secondary keys from the front.
+		dk, err := DeriveKey(p, password, salt, p.IterationCount(key), maxKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, alg := range Algorithms {
+			h, err := decryptHeader(raw, alg, dk[:2*alg.KeyBytes()], key.TrueCrypt)
+			if err != nil {
+				return nil, err
+			}
+			if h != nil {
+				h.PRF = p.Name
+				return h, nil
+			}
+		}
+	}
+	return nil, filesystem.ErrWrongKey
+}
+
+// decryptHeader decrypts raw with alg and key and parses it; a nil header
+// means the key is wrong.
+func decryptHeader(raw []byte, alg Algorithm, key []byte, trueCrypt bool) (*Header, error) {
+	c, err := NewCipher(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	d := make([]byte, HeaderSize)
+	copy(d, raw)
+	c.Decrypt(d[saltSize:], d[saltSize:], 0)
+	magic := "VERA"
+	if trueCrypt {
+		magic = "TRUE"
+	}
+	if string(d[64:68]) != magic ||
+		binary.BigEndian.Uint32(d[72:]) != crc32.ChecksumIEEE(d[masterKeyOff:]) ||
+		binary.BigEndian.Uint32(d[252:]) != crc32.ChecksumIEEE(d[64:252]) {
+		return nil, nil
+	}
+	h := &Header{
+		TrueCrypt:          trueCrypt,
+		Version:            binary.BigEndian.Uint16(d[68:]),
+		MinProgramVersion:  binary.BigEndian.Uint16(d[70:]),
+		HiddenVolumeSize:   binary.BigEndian.Uint64(d[92:]),
+		VolumeSize:         binary.BigEndian.Uint64(d[100:]),
+		EncryptedAreaStart: binary.BigEndian.Uint64(d[108:]),
+		EncryptedAreaSize:  binary.BigEndian.Uint64(d[116:]),
+		Flags:              binary.BigEndian.Uint32(d[124:]),
+		SectorSize:         binary.BigEndian.Uint32(d[128:]),
+		Algorithm:          alg.Name,
+		masterKey:          bytes.Clone(d[masterKeyOff : masterKeyOff+2*alg.KeyBytes()]),
+		alg:                alg,
+	}
+	if h.SectorSize == 0 { // headers before version 5
+		h.SectorSize = 512
+	}
+	return h, nil
+}
+
+// check validates the decrypted header against a volume of size bytes.
+func (h *Header) check(size uint64) error {
+	if h.Flags&FlagSystem != 0 {
+		return fmt.Errorf("veracrypt: header at %d is a system encryption header: %w", h.Offset, filesystem.ErrUnsupported)
+	}
+	if h.EncryptedAreaStart%512 != 0 || h.EncryptedAreaSize%512 != 0 || h.EncryptedAreaSize == 0 ||
+		h.EncryptedAreaStart > size || h.EncryptedAreaSize > size-h.EncryptedAreaStart {
+		return fmt.Errorf("veracrypt: header at %d: encrypted area of %d bytes at %d is outside the %d-byte volume",
+			h.Offset, h.EncryptedAreaSize, h.EncryptedAreaStart, size)
+	}
+	return nil
+}
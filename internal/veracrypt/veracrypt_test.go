@@ -0,0 +1,117 @@
+package veracrypt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// testdata/aes-sha512-keyfile.vc.gz was built by an independent reference,
+// not by this package: the header laid out after the VeraCrypt Volume Format
+// Specification, the keyfile pool mixed after VeraCrypt's keyfile
+// documentation with zlib's CRC-32, PBKDF2-HMAC-SHA-512 from Python's
+// hashlib and AES-XTS from OpenSSL.
+//
+// It is a 288 KiB normal volume, AES with SHA-512, opened by katPassword,
+// PIM 3 and katKeyfile. The 64-sector data area at 128 KiB holds byte
+// (s*7)^i at byte i of sector s; katPlainSHA256 is its digest.
+
+const (
+	katPassword    = "KAT password"
+	katPIM         = 3
+	katMixed       = "087c97d89491421134ece2a25e799e8dc38b3d826d1eeaa54542b40cfc55f38ca1c159a63d7683755207844e9bc2950aea764fc64144e78eafaa4e6f64c5ed02"
+	katPlainSHA256 = "ce7c154b1e7b9dcff9938b91d683e0e76ebed7a36ddff1301f1ae63ea4c91904"
+)
+
+// katKeyfile is the contents of the keyfile.
+func katKeyfile() []byte {
+	kf := make([]byte, 1000)
+	for i := range kf {
+		kf[i] = byte(i*31 + 7)
+	}
+	return kf
+}
+
+// memReader serves an in-memory volume as 512-byte sectors.
+type memReader []byte
+
+func (m memReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if lba*512+count*512 > uint64(len(m)) {
+		return nil, fmt.Errorf("read past the end of the volume")
+	}
+	return bytes.Clone(m[lba*512 : (lba+count)*512]), nil
+}
+
+func readVolume(t *testing.T, name string) memReader {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memReader(b)
+}
+
+func TestApplyKeyfilesKnownAnswer(t *testing.T) {
+	got, err := ApplyKeyfiles([]byte(katPassword), [][]byte{katKeyfile()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(got) != katMixed {
+		t.Fatalf("mixed password = %x", got)
+	}
+}
+
+func TestKnownAnswerVolume(t *testing.T) {
+	vol := readVolume(t, "aes-sha512-keyfile.vc.gz")
+	sectors := uint64(len(vol)) / 512
+
+	if _, err := Open(vol, 0, sectors, Key{Password: []byte(katPassword), PIM: katPIM, PRF: "sha512"}); !errors.Is(err, filesystem.ErrWrongKey) {
+		t.Fatalf("without the keyfile: %v", err)
+	}
+
+	v, err := Open(vol, 0, sectors, Key{Password: []byte(katPassword), PIM: katPIM, Keyfiles: [][]byte{katKeyfile()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := v.Header()
+	if h.TrueCrypt || h.Hidden || h.Backup || h.Version != 5 || h.MinProgramVersion != 0x010b ||
+		h.PRF != "sha512" || h.Algorithm != "AES" || h.SectorSize != 512 || h.VolumeSize != 32768 ||
+		h.EncryptedAreaStart != DataAreaOffset || h.EncryptedAreaSize != 32768 {
+		t.Fatalf("header = %+v", h)
+	}
+	if v.Sectors() != 64 {
+		t.Fatalf("data area is %d sectors", v.Sectors())
+	}
+	data, err := v.ReadSectors(0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != katPlainSHA256 {
+		t.Fatalf("data area SHA-256 = %x", sum)
+	}
+	one, err := v.ReadSectors(37, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(one, data[37*512:38*512]) {
+		t.Fatalf("sector 37 read alone differs")
+	}
+}
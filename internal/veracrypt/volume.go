@@ -0,0 +1,68 @@
+package veracrypt
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Volume is the decrypted data area of a VeraCrypt or TrueCrypt volume: a
+// volume.Volume whose LBA 0 is the first sector of the mounted (plaintext)
+// device.
+type Volume struct {
+	src      filesystem.Reader
+	startLBA uint64 // first sector of the container in src
+	first    uint64 // first data unit of the encrypted area
+	sectors  uint64
+	h        *Header
+	c        *Cipher
+}
+
+// Open unlocks the volume of sectors sectors at startLBA of src with key and
+// returns its decrypted data area: the hidden volume when key opens the
+// hidden volume header, the normal (outer) volume otherwise.
+func Open(src filesystem.Reader, startLBA, sectors uint64, key Key) (*Volume, error) {
+	h, err := Unlock(src, startLBA, sectors*512, key)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCipher(h.alg, h.masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("veracrypt: %w", err)
+	}
+	return &Volume{
+		src:      src,
+		startLBA: startLBA,
+		first:    h.EncryptedAreaStart / 512,
+		sectors:  h.EncryptedAreaSize / 512,
+		h:        h,
+		c:        c,
+	}, nil
+}
+
+// Header returns the decrypted header the volume was opened with.
+func (v *Volume) Header() *Header { return v.h }
+
+// Sectors returns the data area size in 512-byte sectors.
+func (v *Volume) Sectors() uint64 { return v.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (v *Volume) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := volume.CheckRange(lba, count, v.sectors); err != nil {
+		return nil, err
+	}
+	unit := v.first + lba
+	data, err := v.src.ReadSectors(v.startLBA+unit, count)
+	if err != nil {
+		return nil, fmt.Errorf("veracrypt sector %d: %w", lba, err)
+	}
+	if uint64(len(data)) != count*512 {
+		return nil, fmt.Errorf("veracrypt sector %d: short read of %d bytes", lba, len(data))
+	}
+	for i := uint64(0); i < count; i++ {
+		s := data[i*512 : i*512+512]
+		v.c.Decrypt(s, s, unit+i)
+	}
+	return data, nil
+}
@@ -0,0 +1,358 @@
+// Package volume assembles virtual block devices out of sector ranges of one
+// or more underlying filesystem.Reader sources. Software volume managers
+// (Windows dynamic disks) and decryption layers all present a logical volume
+// whose sectors live somewhere else; each is expressed here as a
+// filesystem.Reader whose LBA 0 is the first sector of the logical volume, so
+// the existing filesystem handlers open it unchanged (startLBA 0).
+//
+// Correctness contract (forensics): every read either returns the exact bytes
+// of the mapped source sectors or an explicit error. A sector that maps to a
+// member that is not present, or to no member at all, is an error — it is
+// never zero-filled.
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// ErrMissingMember is returned (wrapped) when a read touches sectors that live
+// on a member disk that was not supplied.
+var ErrMissingMember = errors.New("volume member not present")
+
+// MaxReadSectors bounds a single virtual read (64 MiB at 512-byte sectors), the
+// same order as the EWF read path's own request cap.
+const MaxReadSectors = 1 << 17
+
+// Volume is a virtual block device: a filesystem.Reader whose LBA 0 is the
+// first sector of the volume, plus its size in sectors.
+type Volume interface {
+	filesystem.Reader
+	Sectors() uint64
+}
+
+// Extent maps Sectors consecutive sectors of a linear volume, starting at
+// volume sector Start, onto Source starting at SourceLBA. A nil Source marks
+// a member that is not present; Missing names it in the read error.
+type Extent struct {
+	Start     uint64
+	Sectors   uint64
+	Source    filesystem.Reader
+	SourceLBA uint64
+	Missing   string
+}
+
+// Linear concatenates extents into one volume (a simple or spanned volume, or
+// one column of a striped set).
+type Linear struct {
+	extents []Extent
+	sectors uint64
+}
+
+// NewLinear builds a linear volume of the given size from extents. Extents are
+// sorted by Start; they must not overlap and must lie within the volume. A gap
+// between extents is allowed but reads of it fail explicitly.
+func NewLinear(extents []Extent, sectors uint64) (*Linear, error) {
+	ext := append([]Extent(nil), extents...)
+	sort.Slice(ext, func(i, j int) bool { return ext[i].Start < ext[j].Start })
+	var next uint64
+	for i, e := range ext {
+		if e.Sectors == 0 {
+			return nil, fmt.Errorf("extent %d is empty", i)
+		}
+		if e.Start < next {
+			return nil, fmt.Errorf("extent %d at sector %d overlaps the previous extent", i, e.Start)
+		}
+		if e.Start+e.Sectors < e.Start || e.Start+e.Sectors > sectors {
+			return nil, fmt.Errorf("extent %d (%d+%d) exceeds the volume size %d", i, e.Start, e.Sectors, sectors)
+		}
+		next = e.Start + e.Sectors
+	}
+	return &Linear{extents: ext, sectors: sectors}, nil
+}
+
+// Sectors returns the volume size in sectors.
+func (l *Linear) Sectors() uint64 { return l.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (l *Linear) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := CheckRange(lba, count, l.sectors); err != nil {
+		return nil, err
+	}
+	var out []byte
+	for cur, end := lba, lba+count; cur < end; {
+		i := sort.Search(len(l.extents), func(i int) bool {
+			return l.extents[i].Start+l.extents[i].Sectors > cur
+		})
+		if i == len(l.extents) || l.extents[i].Start > cur {
+			return nil, fmt.Errorf("volume sector %d is not mapped by any extent", cur)
+		}
+		e := l.extents[i]
+		take := e.Start + e.Sectors - cur
+		if take > end-cur {
+			take = end - cur
+		}
+		if e.Source == nil {
+			return nil, fmt.Errorf("volume sector %d lives on %s: %w", cur, e.Missing, ErrMissingMember)
+		}
+		data, err := readExact(e.Source, e.SourceLBA+(cur-e.Start), take)
+		if err != nil {
+			return nil, fmt.Errorf("volume sector %d: %w", cur, err)
+		}
+		out = append(out, data...)
+		cur += take
+	}
+	return out, nil
+}
+
+// Striped interleaves columns in stripes of stripeSectors sectors (RAID-0):
+// volume stripe n lives on column n % len(columns) at column stripe
+// n / len(columns).
+type Striped struct {
+	columns       []filesystem.Reader
+	stripeSectors uint64
+	sectors       uint64
+}
+
+// NewStriped builds a striped volume of the given size.
+func NewStriped(columns []filesystem.Reader, stripeSectors, sectors uint64) (*Striped, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("striped volume has no columns")
+	}
+	if stripeSectors == 0 {
+		return nil, fmt.Errorf("striped volume has a zero stripe size")
+	}
+	return &Striped{columns: columns, stripeSectors: stripeSectors, sectors: sectors}, nil
+}
+
+// Sectors returns the volume size in sectors.
+func (s *Striped) Sectors() uint64 { return s.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (s *Striped) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := CheckRange(lba, count, s.sectors); err != nil {
+		return nil, err
+	}
+	ncol := uint64(len(s.columns))
+	var out []byte
+	for cur, end := lba, lba+count; cur < end; {
+		stripe := cur / s.stripeSectors
+		within := cur % s.stripeSectors
+		take := s.stripeSectors - within
+		if take > end-cur {
+			take = end - cur
+		}
+		col := s.columns[stripe%ncol]
+		colLBA := (stripe/ncol)*s.stripeSectors + within
+		data, err := readExact(col, colLBA, take)
+		if err != nil {
+			return nil, fmt.Errorf("volume sector %d (column %d): %w", cur, stripe%ncol, err)
+		}
+		out = append(out, data...)
+		cur += take
+	}
+	return out, nil
+}
+
+// Mirror serves reads from the first plex that can satisfy them (RAID-1).
+// Plexes are tried in order, so a plex on a missing member disk is skipped in
+// favour of a present one; the read fails only when every plex fails.
+type Mirror struct {
+	plexes  []filesystem.Reader
+	sectors uint64
+}
+
+// NewMirror builds a mirrored volume of the given size.
+func NewMirror(plexes []filesystem.Reader, sectors uint64) (*Mirror, error) {
+	if len(plexes) == 0 {
+		return nil, fmt.Errorf("mirrored volume has no plexes")
+	}
+	return &Mirror{plexes: plexes, sectors: sectors}, nil
+}
+
+// Sectors returns the volume size in sectors.
+func (m *Mirror) Sectors() uint64 { return m.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (m *Mirror) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := CheckRange(lba, count, m.sectors); err != nil {
+		return nil, err
+	}
+	var errs []error
+	for i, p := range m.plexes {
+		data, err := readExact(p, lba, count)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, fmt.Errorf("plex %d: %w", i, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Window is a volume that starts at an arbitrary byte offset of a source: its
+// sector n is the 512 bytes at offset+n*512, whether or not offset is
+// sector-aligned. It lets a handler open a filesystem that was carved or
+// nested at an unaligned position.
+type Window struct {
+	src     filesystem.Reader
+	offset  uint64
+	sectors uint64
+}
+
+// NewWindow builds a window of the given size in sectors over src, starting
+// at byte offset. The caller bounds the window to the source's size.
+func NewWindow(src filesystem.Reader, offset, sectors uint64) (*Window, error) {
+	if sectors == 0 {
+		return nil, fmt.Errorf("empty window")
+	}
+	return &Window{src: src, offset: offset, sectors: sectors}, nil
+}
+
+// Sectors returns the window size in sectors.
+func (w *Window) Sectors() uint64 { return w.sectors }
+
+// ReadSectors implements filesystem.Reader. An unaligned window reads one
+// extra source sector and returns the requested bytes from inside it.
+func (w *Window) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := CheckRange(lba, count, w.sectors); err != nil {
+		return nil, err
+	}
+	start := w.offset + lba*512
+	first, skip := start/512, start%512
+	n := count
+	if skip != 0 {
+		n++
+	}
+	data, err := readExact(w.src, first, n)
+	if err != nil {
+		return nil, fmt.Errorf("window sector %d: %w", lba, err)
+	}
+	return data[skip : skip+count*512], nil
+}
+
+// File is a volume backed by a file inside another filesystem — a disk or
+// filesystem image stored as a regular file. Sector n is the 512 bytes at
+// offset n*512 of the file. A file whose size is not a multiple of 512 has a
+// final partial sector, whose bytes past the end of the file read as zeros;
+// those bytes only complete the sector and are never file content.
+type File struct {
+	mu   sync.Mutex
+	r    io.ReaderAt
+	rs   io.ReadSeeker // used when the file is not an io.ReaderAt
+	size int64
+}
+
+// NewFile builds a volume over a file of size bytes. r is used through
+// io.ReaderAt when it implements it, and otherwise through Seek and Read
+// under a mutex.
+func NewFile(r io.ReadSeeker, size int64) (*File, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("empty file volume")
+	}
+	f := &File{size: size}
+	if ra, ok := r.(io.ReaderAt); ok {
+		f.r = ra
+	} else {
+		f.rs = r
+	}
+	return f, nil
+}
+
+// Sectors returns the volume size in sectors, counting a final partial one.
+func (f *File) Sectors() uint64 { return uint64((f.size + 511) / 512) }
+
+// Size returns the file size in bytes.
+func (f *File) Size() int64 { return f.size }
+
+// ReadSectors implements filesystem.Reader.
+func (f *File) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := CheckRange(lba, count, f.Sectors()); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, count*512)
+	off := int64(lba) * 512
+	want := min(int64(len(buf)), f.size-off)
+	var n int
+	var err error
+	if f.r != nil {
+		n, err = f.r.ReadAt(buf[:want], off)
+	} else {
+		f.mu.Lock()
+		if _, err = f.rs.Seek(off, io.SeekStart); err == nil {
+			n, err = io.ReadFull(f.rs, buf[:want])
+		}
+		f.mu.Unlock()
+	}
+	if int64(n) == want && (err == nil || err == io.EOF) {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("got %d of %d bytes", n, want)
+	}
+	return nil, fmt.Errorf("file sector %d: %w", lba, err)
+}
+
+// unavailable is a volume that cannot be assembled: every read returns err.
+type unavailable struct {
+	err     error
+	sectors uint64
+}
+
+// Unavailable returns a volume of the given size whose every read fails with
+// err. It lets a caller list a volume it found but cannot reconstruct (an
+// unsupported layout, a broken mapping) without ever serving bytes for it.
+func Unavailable(err error, sectors uint64) Volume {
+	return &unavailable{err: err, sectors: sectors}
+}
+
+func (u *unavailable) Sectors() uint64 { return u.sectors }
+
+func (u *unavailable) ReadSectors(uint64, uint64) ([]byte, error) { return nil, u.err }
+
+// CheckRange rejects empty reads, reads over MaxReadSectors and reads that
+// start or end outside a volume of size sectors.
+func CheckRange(lba, count, sectors uint64) error {
+	if count == 0 {
+		return fmt.Errorf("zero-sector read")
+	}
+	if count > MaxReadSectors {
+		return fmt.Errorf("read of %d sectors exceeds the %d-sector limit", count, MaxReadSectors)
+	}
+	if lba >= sectors || count > sectors-lba {
+		return fmt.Errorf("read of %d sectors at %d exceeds the volume size %d", count, lba, sectors)
+	}
+	return nil
+}
+
+// readExact reads count sectors from r and rejects any result other than
+// count*512 bytes, so a truncated source read can never shift the bytes of
+// later extents.
+func readExact(r filesystem.Reader, lba, count uint64) ([]byte, error) {
+	data, err := r.ReadSectors(lba, count)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != count*512 {
+		return nil, fmt.Errorf("short read at LBA %d: got %d bytes for %d sectors", lba, len(data), count)
+	}
+	return data, nil
+}
+
+// ReadBytes reads n bytes at byte offset off of the volume at startLBA of r.
+func ReadBytes(r filesystem.Reader, startLBA, off, n uint64) ([]byte, error) {
+	first, skip := off/512, off%512
+	count := (skip + n + 511) / 512
+	data, err := r.ReadSectors(startLBA+first, count)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < skip+n {
+		return nil, fmt.Errorf("short read of %d bytes at offset %d", len(data), off)
+	}
+	return data[skip : skip+n], nil
+}
@@ -0,0 +1,315 @@
+// Package vss reads Volume Shadow Copy Service snapshots of an NTFS volume:
+// it parses the VSS volume header, the catalog and the store headers, and
+// presents each shadow copy as a volume.Volume that the NTFS handler opens
+// unchanged, reading the volume as it was when the snapshot was taken.
+//
+// Microsoft publishes no specification; the layout follows the public
+// reverse-engineering of the format (libvshadow). All integers are
+// little-endian, GUIDs are in the usual mixed-endian order, times are
+// FILETIMEs and every offset is a byte offset from the start of the volume.
+//
+// Every VSS structure but the volume header fills a 16 KiB block that
+// starts with a 128-byte header:
+//
+//	header          identifier GUID 3808876b-c176-4e48-b7ae-04046e6cc752,
+//	                version at 16, record type at 20, offset relative to
+//	                the first block of its list at 24, own offset at 32,
+//	                next block of the list at 40 (0 for the last), and for a
+//	                store header the size of the store information at 48
+//
+// The volume header is the same header in the 512 bytes at 0x1E00, with
+// the catalog offset at 48, the maximum store size at 56 and the volume
+// GUID at 64. The catalog is a list of blocks of 128-byte entries; a
+// snapshot is described by a pair of entries:
+//
+//	type 2          volume size at 8, store GUID at 16, creation time at 48
+//	type 3          store block list at 8, store GUID at 16, store header
+//	                at 32, block range list at 40, current bitmap at 48,
+//	                previous bitmap at 72
+//
+// The store header block holds the store information after its header:
+// shadow copy GUID at 16, shadow copy set GUID at 32, snapshot context at
+// 48, attributes at 56, then the originating and service machine names
+// as sized UTF-16 strings. The store block list is a list of blocks of
+// 32-byte entries, one per 16 KiB block of the volume that was copied
+// before being overwritten:
+//
+//	entry           original offset at 0, relative offset at 8, store
+//	                offset at 16, flags at 24, sector bitmap at 28
+//
+// A store holds what changed between its snapshot and the next one, so a
+// block of a snapshot is read from the first of its own and the newer
+// stores that copied it, and from the live volume when none did.
+package vss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf16"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// HeaderOffset is the byte offset of the VSS volume header.
+const HeaderOffset = 0x1E00
+
+// BlockSize is the size of a VSS block, both of its own structures and of
+// the units of the volume it copies.
+const BlockSize = 0x4000
+
+// Identifier is the on-disk form of the VSS identifier GUID
+// 3808876b-c176-4e48-b7ae-04046e6cc752.
+var Identifier = []byte{0x6B, 0x87, 0x08, 0x38, 0x76, 0xC1, 0x48, 0x4E, 0xB7, 0xAE, 0x04, 0x04, 0x6E, 0x6C, 0xC7, 0x52}
+
+// Record types of the block header.
+const (
+	RecordVolumeHeader = 1
+	RecordCatalog      = 2
+	RecordBlockList    = 3
+	RecordStoreHeader  = 4
+	RecordBlockRange   = 5
+	RecordBitmap       = 6
+)
+
+// Catalog entry types.
+const (
+	catalogStoreInfo  = 2
+	catalogStoreLists = 3
+)
+
+const (
+	headerSize       = 0x80
+	catalogEntrySize = 0x80
+	// maxListBlocks bounds a catalog or block list chain, guarding against
+	// loops: a block list of this many blocks maps 64 TiB of changes.
+	maxListBlocks = 1 << 20
+)
+
+// Snapshot describes one shadow copy.
+type Snapshot struct {
+	ID             string // shadow copy GUID
+	SetID          string // shadow copy set GUID
+	StoreID        string // store GUID in the catalog
+	Created        time.Time
+	VolumeSize     uint64 // bytes, at the time of the snapshot
+	Context        uint32 // VSS_CTX_* snapshot context
+	Attributes     uint32 // VSS_VOLSNAP_ATTR_* flags
+	Machine        string // originating machine
+	ServiceMachine string
+
+	// BlockListOffset, HeaderOffset and BitmapOffset locate the store's
+	// block list, header and current bitmap.
+	BlockListOffset uint64
+	HeaderOffset    uint64
+	BitmapOffset    uint64
+}
+
+// Catalog is the parsed VSS catalog of a volume.
+type Catalog struct {
+	VolumeID  string
+	MaxSize   uint64 // maximum size of the stores, 0 for no limit
+	Snapshots []Snapshot
+}
+
+// IsVolumeHeader reports whether b, the 512 bytes at HeaderOffset, is a
+// VSS volume header.
+func IsVolumeHeader(b []byte) bool {
+	return len(b) >= headerSize && bytes.Equal(b[:16], Identifier) &&
+		binary.LittleEndian.Uint32(b[20:]) == RecordVolumeHeader
+}
+
+// Parse reads the VSS volume header, catalog and store headers of the
+// sectors-sector volume at startLBA of r (512-byte sectors). A volume
+// without a VSS volume header, or whose header names no catalog, has no
+// snapshots: an empty catalog. Snapshots are sorted oldest first.
+func Parse(r filesystem.Reader, startLBA, sectors uint64) (*Catalog, error) {
+	size := sectors * 512
+	if size < HeaderOffset+512 {
+		return &Catalog{}, nil
+	}
+	hdr, err := volume.ReadBytes(r, startLBA, HeaderOffset, 512)
+	if err != nil {
+		return nil, fmt.Errorf("vss: read volume header: %w", err)
+	}
+	if !IsVolumeHeader(hdr) {
+		return &Catalog{}, nil
+	}
+	if v := binary.LittleEndian.Uint32(hdr[16:]); v != 1 && v != 2 {
+		return nil, fmt.Errorf("vss: volume header version %d: %w", v, filesystem.ErrUnsupported)
+	}
+	c := &Catalog{
+		VolumeID: formatGUID(hdr[64:80]),
+		MaxSize:  binary.LittleEndian.Uint64(hdr[56:]),
+	}
+	catalog := binary.LittleEndian.Uint64(hdr[48:])
+	if catalog == 0 {
+		return c, nil
+	}
+
+	type store struct {
+		info, lists []byte
+	}
+	stores := map[string]*store{}
+	var order []string
+	err = walkList(r, startLBA, size, catalog, RecordCatalog, func(b []byte) error {
+		for off := headerSize; off+catalogEntrySize <= len(b); off += catalogEntrySize {
+			e := b[off : off+catalogEntrySize]
+			typ := binary.LittleEndian.Uint64(e)
+			if typ != catalogStoreInfo && typ != catalogStoreLists {
+				continue
+			}
+			id := formatGUID(e[16:32])
+			s := stores[id]
+			if s == nil {
+				s = &store{}
+				stores[id] = s
+				order = append(order, id)
+			}
+			if typ == catalogStoreInfo {
+				s.info = e
+			} else {
+				s.lists = e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vss: catalog: %w", err)
+	}
+
+	for _, id := range order {
+		s := stores[id]
+		if s.info == nil || s.lists == nil {
+			return nil, fmt.Errorf("vss: catalog describes store %s with only one of its two entries", id)
+		}
+		sn := Snapshot{
+			StoreID:         id,
+			Created:         filetime(binary.LittleEndian.Uint64(s.info[48:])),
+			VolumeSize:      binary.LittleEndian.Uint64(s.info[8:]),
+			BlockListOffset: binary.LittleEndian.Uint64(s.lists[8:]),
+			HeaderOffset:    binary.LittleEndian.Uint64(s.lists[32:]),
+			BitmapOffset:    binary.LittleEndian.Uint64(s.lists[48:]),
+		}
+		if sn.VolumeSize < BlockSize || sn.VolumeSize%512 != 0 {
+			return nil, fmt.Errorf("vss: store %s: volume size %d", id, sn.VolumeSize)
+		}
+		if err := sn.parseStoreHeader(r, startLBA, size); err != nil {
+			return nil, fmt.Errorf("vss: store %s: %w", id, err)
+		}
+		c.Snapshots = append(c.Snapshots, sn)
+	}
+	sort.SliceStable(c.Snapshots, func(i, j int) bool { return c.Snapshots[i].Created.Before(c.Snapshots[j].Created) })
+	return c, nil
+}
+
+// parseStoreHeader reads the store information of the snapshot's store
+// header block.
+func (sn *Snapshot) parseStoreHeader(r filesystem.Reader, startLBA, size uint64) error {
+	b, err := readBlock(r, startLBA, size, sn.HeaderOffset, RecordStoreHeader)
+	if err != nil {
+		return fmt.Errorf("store header: %w", err)
+	}
+	n := binary.LittleEndian.Uint64(b[48:])
+	if n < 0x44 || n > BlockSize-headerSize {
+		return fmt.Errorf("store information of %d bytes", n)
+	}
+	info := b[headerSize : headerSize+n]
+	sn.ID = formatGUID(info[16:32])
+	sn.SetID = formatGUID(info[32:48])
+	sn.Context = binary.LittleEndian.Uint32(info[48:])
+	sn.Attributes = binary.LittleEndian.Uint32(info[56:])
+	rest := info[64:]
+	for _, s := range []*string{&sn.Machine, &sn.ServiceMachine} {
+		if len(rest) < 2 {
+			return fmt.Errorf("store information truncated")
+		}
+		l := int(binary.LittleEndian.Uint16(rest))
+		if l%2 != 0 || 2+l > len(rest) {
+			return fmt.Errorf("machine name of %d bytes overruns the store information", l)
+		}
+		*s = utf16String(rest[2 : 2+l])
+		rest = rest[2+l:]
+	}
+	return nil
+}
+
+// walkList calls fn with each block of the list of record type typ that
+// starts at off.
+func walkList(r filesystem.Reader, startLBA, size, off uint64, typ uint32, fn func([]byte) error) error {
+	seen := map[uint64]bool{}
+	for off != 0 {
+		if seen[off] {
+			return fmt.Errorf("block list loops back to offset %d", off)
+		}
+		if len(seen) >= maxListBlocks {
+			return fmt.Errorf("block list longer than %d blocks", maxListBlocks)
+		}
+		seen[off] = true
+		b, err := readBlock(r, startLBA, size, off, typ)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		off = binary.LittleEndian.Uint64(b[40:])
+	}
+	return nil
+}
+
+// readBlock reads the VSS block of record type typ at byte offset off and
+// checks its header.
+func readBlock(r filesystem.Reader, startLBA, size, off uint64, typ uint32) ([]byte, error) {
+	if off%512 != 0 || off > size || size-off < BlockSize {
+		return nil, fmt.Errorf("block offset %d outside the %d-byte volume", off, size)
+	}
+	b, err := volume.ReadBytes(r, startLBA, off, BlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("block at offset %d: %w", off, err)
+	}
+	if !bytes.Equal(b[:16], Identifier) {
+		return nil, fmt.Errorf("block at offset %d has no VSS identifier", off)
+	}
+	if t := binary.LittleEndian.Uint32(b[20:]); t != typ {
+		return nil, fmt.Errorf("block at offset %d has record type %d, want %d", off, t, typ)
+	}
+	if cur := binary.LittleEndian.Uint64(b[32:]); cur != off {
+		return nil, fmt.Errorf("block at offset %d records its offset as %d", off, cur)
+	}
+	return b, nil
+}
+
+// formatGUID renders an on-disk (mixed-endian) GUID in its canonical
+// lower-case form.
+func formatGUID(g []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+// filetime converts a FILETIME; zero stays the zero time.
+func filetime(ft uint64) time.Time {
+	if ft == 0 || ft > 1<<62 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ft/10_000_000)-11644473600, int64(ft%10_000_000)*100).UTC()
+}
+
+// utf16String decodes a little-endian UTF-16 string, up to a NUL.
+func utf16String(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,216 @@
+package vss
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// Block list entry flags.
+const (
+	// blockForwarder: the block's content is that of the block at the
+	// relative offset, looked up in the newer stores.
+	blockForwarder = 0x1
+	// blockOverlay: the sectors set in the entry's bitmap are stored at its
+	// store offset, on top of the block found in the newer stores.
+	blockOverlay = 0x2
+	// blockNotUsed: the entry is void.
+	blockNotUsed = 0x4
+
+	blockEntrySize = 32
+)
+
+// blockEntry is a store's copy of one volume block.
+type blockEntry struct {
+	store    uint64 // offset of the copy, for a block stored whole
+	relative uint64 // volume block a forwarder takes its content from
+	flags    uint32
+}
+
+// overlayEntry is a store's copy of some sectors of one volume block.
+type overlayEntry struct {
+	store  uint64
+	bitmap uint32 // bit n set: sector n of the block is stored
+}
+
+// storeMap is the block list of one store, read on first use.
+type storeMap struct {
+	once     sync.Once
+	err      error
+	blocks   map[uint64]blockEntry
+	overlays map[uint64][]overlayEntry
+}
+
+// Set is the shadow copies of one volume. Its volumes share the block
+// lists of the stores, each read once on first use.
+type Set struct {
+	src      filesystem.Reader
+	startLBA uint64
+	sectors  uint64
+	catalog  *Catalog
+	stores   []*storeMap
+}
+
+// Open parses the VSS catalog of the sectors-sector volume at startLBA of
+// src. Its block lists are read when a snapshot is first read.
+func Open(src filesystem.Reader, startLBA, sectors uint64) (*Set, error) {
+	c, err := Parse(src, startLBA, sectors)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{src: src, startLBA: startLBA, sectors: sectors, catalog: c}
+	for range c.Snapshots {
+		s.stores = append(s.stores, &storeMap{})
+	}
+	return s, nil
+}
+
+// Catalog returns the parsed catalog; its Snapshots are in the order of
+// Volume's index.
+func (s *Set) Catalog() *Catalog { return s.catalog }
+
+// Volume returns the view of the volume as of snapshot i of the catalog.
+func (s *Set) Volume(i int) *Volume {
+	return &Volume{set: s, i: i, sectors: s.catalog.Snapshots[i].VolumeSize / 512}
+}
+
+// storeMap returns the block list of store i.
+func (s *Set) storeMap(i int) (*storeMap, error) {
+	m := s.stores[i]
+	m.once.Do(func() {
+		sn := &s.catalog.Snapshots[i]
+		m.blocks = map[uint64]blockEntry{}
+		m.overlays = map[uint64][]overlayEntry{}
+		m.err = walkList(s.src, s.startLBA, s.sectors*512, sn.BlockListOffset, RecordBlockList, func(b []byte) error {
+			for off := headerSize; off+blockEntrySize <= len(b); off += blockEntrySize {
+				e := b[off : off+blockEntrySize]
+				orig := binary.LittleEndian.Uint64(e)
+				be := blockEntry{
+					relative: binary.LittleEndian.Uint64(e[8:]),
+					store:    binary.LittleEndian.Uint64(e[16:]),
+					flags:    binary.LittleEndian.Uint32(e[24:]),
+				}
+				if orig == 0 && be.relative == 0 && be.store == 0 && be.flags == 0 || be.flags&blockNotUsed != 0 {
+					continue
+				}
+				if orig%BlockSize != 0 {
+					return fmt.Errorf("block list entry for unaligned offset %d", orig)
+				}
+				switch {
+				case be.flags&blockOverlay != 0:
+					m.overlays[orig] = append(m.overlays[orig], overlayEntry{store: be.store, bitmap: binary.LittleEndian.Uint32(e[28:])})
+				case be.flags&blockForwarder != 0 && be.relative%BlockSize != 0:
+					return fmt.Errorf("block %d forwarded to unaligned offset %d", orig, be.relative)
+				default:
+					// The first copy holds the content as of the snapshot.
+					if _, ok := m.blocks[orig]; !ok {
+						m.blocks[orig] = be
+					}
+				}
+			}
+			return nil
+		})
+		if m.err != nil {
+			m.err = fmt.Errorf("vss: block list of store %s: %w", sn.StoreID, m.err)
+		}
+	})
+	return m, m.err
+}
+
+// block returns the n bytes of the volume block at off as of snapshot i:
+// the copy in store i, or else the block as of snapshot i+1, which for the
+// newest snapshot is the live volume.
+func (s *Set) block(i int, off, n uint64) ([]byte, error) {
+	if i == len(s.stores) {
+		return s.read(off, n)
+	}
+	m, err := s.storeMap(i)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := m.blocks[off]; ok {
+		if e.flags&blockForwarder != 0 {
+			return s.block(i+1, e.relative, n)
+		}
+		return s.read(e.store, n)
+	}
+	b, err := s.block(i+1, off, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range m.overlays[off] {
+		data, err := s.read(o.store, n)
+		if err != nil {
+			return nil, err
+		}
+		for sec := uint64(0); sec < 32 && sec*512 < n; sec++ {
+			if o.bitmap&(1<<sec) != 0 {
+				copy(b[sec*512:(sec+1)*512], data[sec*512:])
+			}
+		}
+	}
+	return b, nil
+}
+
+// read reads n bytes at byte offset off of the live volume.
+func (s *Set) read(off, n uint64) ([]byte, error) {
+	if off > s.sectors*512 || s.sectors*512-off < n {
+		return nil, fmt.Errorf("vss: %d bytes at offset %d exceed the %d-byte volume", n, off, s.sectors*512)
+	}
+	b, err := volume.ReadBytes(s.src, s.startLBA, off, n)
+	if err != nil {
+		return nil, fmt.Errorf("vss: offset %d: %w", off, err)
+	}
+	// Callers overlay sectors onto the block: keep the source's buffer
+	// untouched.
+	return append([]byte(nil), b...), nil
+}
+
+// Volume is the read-only view of a volume as of one shadow copy: a
+// volume.Volume whose LBA 0 is the first sector of the volume.
+//
+// A block VSS copied before overwriting it is read from the snapshot's
+// store or a newer one; every other block is read from the live volume.
+// VSS does not copy blocks that were free when the snapshot was taken, so
+// unallocated space of the view shows their current content.
+type Volume struct {
+	set     *Set
+	i       int
+	sectors uint64
+}
+
+// Snapshot returns the description of the view's shadow copy.
+func (v *Volume) Snapshot() *Snapshot { return &v.set.catalog.Snapshots[v.i] }
+
+// Sectors returns the volume size as of the snapshot in 512-byte sectors.
+func (v *Volume) Sectors() uint64 { return v.sectors }
+
+// ReadSectors implements filesystem.Reader.
+func (v *Volume) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if err := volume.CheckRange(lba, count, v.sectors); err != nil {
+		return nil, err
+	}
+	size := v.sectors * 512
+	out := make([]byte, 0, count*512)
+	for off, end := lba*512, (lba+count)*512; off < end; {
+		base := off &^ (BlockSize - 1)
+		n := uint64(BlockSize)
+		if size-base < n {
+			n = size - base
+		}
+		b, err := v.set.block(v.i, base, n)
+		if err != nil {
+			return nil, fmt.Errorf("shadow copy sector %d: %w", off/512, err)
+		}
+		stop := base + n
+		if stop > end {
+			stop = end
+		}
+		out = append(out, b[off-base:stop-base]...)
+		off = stop
+	}
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package vss
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testdata/two-stores.img.gz was laid out by an independent reference from
+// the offsets of libvshadow's VSS format documentation, not by this package
+// or the test fixtures. It is a 256 KiB volume of 16 blocks, each filled
+// with "live block NN ", holding a volume header, a one-block catalog and two
+// stores:
+//
+//	block 1         catalog
+//	blocks 2, 3     older store: header and block list, which copies
+//	                block 10 ("snap0 block 10 ", at block 8) and has a
+//	                not-used entry for block 12 (at block 9)
+//	blocks 4, 5     newer store: header and block list, which copies
+//	                blocks 10 and 11 ("snap1 block NN ", at blocks 6 and 7)
+
+// memReader serves an in-memory volume as 512-byte sectors.
+type memReader []byte
+
+func (m memReader) ReadSectors(lba uint64, count uint64) ([]byte, error) {
+	if lba*512+count*512 > uint64(len(m)) {
+		return nil, fmt.Errorf("read past the end of the volume")
+	}
+	return bytes.Clone(m[lba*512 : (lba+count)*512]), nil
+}
+
+func readVolume(t *testing.T, name string) memReader {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memReader(b)
+}
+
+// katBlock is a block of the pattern the reference filled it with.
+func katBlock(tag string, n int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%s block %02d ", tag, n)), BlockSize)[:BlockSize]
+}
+
+func TestKnownAnswerCatalog(t *testing.T) {
+	vol := readVolume(t, "two-stores.img.gz")
+	c, err := Parse(vol, 0, uint64(len(vol))/512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.VolumeID != "9f1c2d3e-4a5b-4c6d-8e7f-0a1b2c3d4e5f" || c.MaxSize != 0x10000000 || len(c.Snapshots) != 2 {
+		t.Fatalf("catalog = %+v", c)
+	}
+	want := []Snapshot{{
+		ID:              "aaaaaaaa-bbbb-4ccc-8ddd-eeeeeeeeeee0",
+		SetID:           "0f0e0d0c-0b0a-4908-8706-050403020100",
+		StoreID:         "11111111-2222-4333-8444-555555555555",
+		Created:         time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		VolumeSize:      16 * BlockSize,
+		Context:         0x1D,
+		Attributes:      0x0042000D,
+		Machine:         "KAT-PC.example.org",
+		ServiceMachine:  "KAT-PC.example.org",
+		BlockListOffset: 3 * BlockSize,
+		HeaderOffset:    2 * BlockSize,
+		BitmapOffset:    14 * BlockSize,
+	}, {
+		ID:              "aaaaaaaa-bbbb-4ccc-8ddd-eeeeeeeeeee1",
+		SetID:           "1f1e1d1c-1b1a-4918-9716-151413121110",
+		StoreID:         "66666666-7777-4888-9999-aaaaaaaaaaaa",
+		Created:         time.Date(2024, 3, 2, 8, 30, 0, 0, time.UTC),
+		VolumeSize:      16 * BlockSize,
+		Context:         0x1D,
+		Attributes:      0x0042000D,
+		Machine:         "KAT-PC.example.org",
+		ServiceMachine:  "KAT-PC.example.org",
+		BlockListOffset: 5 * BlockSize,
+		HeaderOffset:    4 * BlockSize,
+		BitmapOffset:    15 * BlockSize,
+	}}
+	for i, sn := range c.Snapshots {
+		if sn != want[i] {
+			t.Errorf("snapshot %d = %+v, want %+v", i, sn, want[i])
+		}
+	}
+}
+
+func TestKnownAnswerStores(t *testing.T) {
+	vol := readVolume(t, "two-stores.img.gz")
+	s, err := Open(vol, 0, uint64(len(vol))/512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, copied := range []map[int][]byte{
+		{10: katBlock("snap0", 10), 11: katBlock("snap1", 11)},
+		{10: katBlock("snap1", 10), 11: katBlock("snap1", 11)},
+	} {
+		v := s.Volume(i)
+		if v.Sectors() != 16*BlockSize/512 {
+			t.Fatalf("snapshot %d: %d sectors", i, v.Sectors())
+		}
+		got, err := v.ReadSectors(0, v.Sectors())
+		if err != nil {
+			t.Fatalf("snapshot %d: %v", i, err)
+		}
+		for n := 0; n < 16; n++ {
+			want := []byte(vol[n*BlockSize : (n+1)*BlockSize])
+			if b, ok := copied[n]; ok {
+				want = b
+			}
+			if !bytes.Equal(got[n*BlockSize:(n+1)*BlockSize], want) {
+				t.Errorf("snapshot %d block %d = %.16q", i, n, got[n*BlockSize:])
+			}
+		}
+		// A read straddling the copied and live blocks.
+		part, err := v.ReadSectors(11*BlockSize/512+31, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part, got[(11*BlockSize/512+31)*512:(11*BlockSize/512+33)*512]) {
+			t.Errorf("snapshot %d: straddling read differs", i)
+		}
+	}
+}
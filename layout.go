@@ -0,0 +1,385 @@
+package ewf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/laenix/ewfgo/internal"
+)
+
+// Unallocated region kinds (UnallocatedRegion.Kind).
+const (
+	RegionPostMBRGap  = "post-MBR gap"         // partition-table metadata to the first partition
+	RegionGap         = "inter-partition gap"  // between two partitions
+	RegionAfterLast   = "after last partition" // last partition to the end of the table's disk
+	RegionHiddenTail  = "HPA-like tail"        // past the disk the table describes, up to TotalSectors
+	RegionUnpartition = "unpartitioned disk"   // no partition table at all
+)
+
+// Layout anomaly kinds (LayoutAnomaly.Kind).
+const (
+	AnomalyOverlap        = "overlap"          // two partitions share sectors
+	AnomalyBeyondMedia    = "beyond media"     // a partition ends past TotalSectors
+	AnomalyInTableArea    = "in table area"    // a partition starts inside the partition-table metadata
+	AnomalyHybridMismatch = "hybrid mismatch"  // a hybrid-MBR entry disagrees with the GPT
+	AnomalyGPTGeometry    = "GPT geometry"     // the GPT header's disk geometry disagrees with the media
+	AnomalyEBRChain       = "EBR chain"        // an extended partition's EBR chain breaks off or loops
+	AnomalyOutsideExt     = "outside extended" // a logical partition reaches past its extended partition
+)
+
+// DeclaredPartition is one partition-table entry as the table declares it.
+//
+// An MBR extended partition is listed with Extended set; it is a container,
+// not allocated space. Its logical partitions follow with Table "EBR", Slot
+// numbering them along the chain, and EBR the sector of the boot record
+// declaring each.
+type DeclaredPartition struct {
+	Table       string // "MBR", "EBR" or "GPT"
+	Slot        int    // entry number within the table, from 0
+	StartSector uint64
+	SizeSectors uint64
+	Type        string // MBR type byte ("0x07") or GPT type GUID (lower case)
+	Extended    bool   // an MBR extended partition
+	EBR         uint64 // the declaring EBR's sector, for Table "EBR"
+}
+
+// EndSector returns the first sector after the partition.
+func (p DeclaredPartition) EndSector() uint64 { return p.StartSector + p.SizeSectors }
+
+// UnallocatedRegion is a run of sectors that no declared partition covers. It
+// is an io.ReaderAt over its own bytes (offset 0 is StartSector); reads past
+// its end return the readable prefix and io.EOF.
+type UnallocatedRegion struct {
+	Kind        string
+	StartSector uint64
+	SizeSectors uint64
+	SizeBytes   uint64
+
+	img *EWFImage
+}
+
+// LayoutAnomaly is one inconsistency in the partition layout. Partitions lists
+// the DeclaredPartition indices (into DiskLayout.Partitions) involved, if any.
+type LayoutAnomaly struct {
+	Kind        string
+	Partitions  []int
+	Description string
+}
+
+// DiskLayout is the partition map of the image: the declared partitions, every
+// unallocated extent between and around them, and the anomalies found while
+// reconciling the table with the media.
+type DiskLayout struct {
+	Scheme       string // "GPT", "MBR" or "none"
+	TotalSectors uint64
+	Partitions   []DeclaredPartition
+	Unallocated  []UnallocatedRegion
+	Anomalies    []LayoutAnomaly
+}
+
+// DiskLayout maps the image's sectors against its partition table. It builds
+// on the same MBR and GPT parsing as ScanFileSystems (a GPT is used when the
+// MBR carries a protective 0xEE entry, and the EBR chain of an MBR extended
+// partition is followed to its logical partitions) and reports:
+//
+//   - every unallocated extent: the post-MBR gap before the first partition
+//     (after the GPT entry array on a GPT disk), gaps between partitions, the
+//     space after the last partition, and an HPA-like tail when the media is
+//     larger than the disk the GPT describes;
+//   - anomalies: overlapping partitions, partitions that end past
+//     TotalSectors or start inside the table metadata, hybrid-MBR entries that
+//     disagree with the GPT, a GPT whose backup-header location or last
+//     usable sector disagrees with the media size, logical partitions outside
+//     their extended partition and an EBR chain that breaks off or loops.
+//
+// Sectors of the partition-table metadata itself (the MBR, the EBRs, the GPT
+// header and entry arrays) are never reported as unallocated; the free space
+// inside an extended partition is.
+func (e *EWFImage) DiskLayout() (*DiskLayout, error) {
+	total := e.TotalSectors()
+	if total == 0 {
+		return nil, fmt.Errorf("image has no sectors")
+	}
+	mbr, err := e.MBR()
+	if err != nil {
+		return nil, err
+	}
+	l := &DiskLayout{Scheme: "none", TotalSectors: total}
+	firstUsable, usableEnd, tableEnd := uint64(1), total, total
+
+	protective := false
+	var mbrParts []DeclaredPartition
+	if mbr.BootSignature == 0xAA55 {
+		for i, p := range mbr.PartitionTable {
+			if p.PartitionType == 0 || p.PartitionSize == 0 {
+				continue
+			}
+			if p.PartitionType == 0xEE {
+				protective = true
+				continue
+			}
+			mbrParts = append(mbrParts, DeclaredPartition{
+				Table: "MBR", Slot: i,
+				StartSector: uint64(p.StartLBA), SizeSectors: uint64(p.PartitionSize),
+				Type:     fmt.Sprintf("0x%02X", p.PartitionType),
+				Extended: isExtendedType(p.PartitionType),
+			})
+		}
+	}
+
+	if protective {
+		gpt, gerr := e.GPT()
+		if gerr == nil && string(gpt.GPTHeader.Signature[:]) == "EFI PART" {
+			l.Scheme = "GPT"
+			for i, p := range gpt.GPTPartitionTable {
+				if p.StartLBA == 0 {
+					continue
+				}
+				size := uint64(0)
+				if p.EndLBA >= p.StartLBA {
+					size = p.EndLBA - p.StartLBA + 1
+				}
+				l.Partitions = append(l.Partitions, DeclaredPartition{
+					Table: "GPT", Slot: i, StartSector: p.StartLBA, SizeSectors: size,
+					Type: internal.FormatGUID(p.PartitionTypeGUID[:]),
+				})
+			}
+			firstUsable, usableEnd, tableEnd = l.reconcileGPT(e, gpt.GPTHeader.FirstLBA, gpt.GPTHeader.LastLBA)
+			l.checkHybrid(mbrParts)
+		}
+	}
+	if l.Scheme == "none" && len(mbrParts) > 0 {
+		l.Scheme = "MBR"
+		l.Partitions = mbrParts
+		for _, p := range mbrParts {
+			if p.Extended {
+				l.addLogical(e, p)
+			}
+		}
+	}
+
+	for i, p := range l.Partitions {
+		if p.EndSector() > total {
+			l.anomaly(AnomalyBeyondMedia, []int{i}, "%s partition %d (sectors %d-%d) ends past the %d-sector media",
+				p.Table, p.Slot, p.StartSector, p.EndSector()-1, total)
+		}
+		if p.StartSector < firstUsable {
+			l.anomaly(AnomalyInTableArea, []int{i}, "%s partition %d starts at sector %d, inside the partition-table area (first usable sector %d)",
+				p.Table, p.Slot, p.StartSector, firstUsable)
+		}
+	}
+	order := make([]int, len(l.Partitions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return l.Partitions[order[a]].StartSector < l.Partitions[order[b]].StartSector
+	})
+	for a := 0; a < len(order); a++ {
+		pa := l.Partitions[order[a]]
+		for b := a + 1; b < len(order); b++ {
+			pb := l.Partitions[order[b]]
+			if pb.StartSector >= pa.EndSector() {
+				break
+			}
+			if (pa.Extended && pb.Table == "EBR") || (pb.Extended && pa.Table == "EBR") {
+				continue // a logical partition inside its container
+			}
+			l.anomaly(AnomalyOverlap, []int{order[a], order[b]}, "%s partitions %d and %d overlap at sectors %d-%d",
+				pa.Table, pa.Slot, pb.Slot, pb.StartSector, minU64(pa.EndSector(), pb.EndSector())-1)
+		}
+	}
+
+	l.findUnallocated(e, firstUsable, usableEnd, tableEnd)
+	return l, nil
+}
+
+// addLogical appends the logical partitions of the extended partition ext,
+// flagging those that reach past it and a chain that breaks off.
+func (l *DiskLayout) addLogical(e *EWFImage, ext DeclaredPartition) {
+	entry := internal.PartitionEntry{StartLBA: uint32(ext.StartSector), PartitionSize: uint32(ext.SizeSectors)}
+	logical, err := e.logicalPartitions(entry)
+	for n, lp := range logical {
+		l.Partitions = append(l.Partitions, DeclaredPartition{
+			Table: "EBR", Slot: n, StartSector: lp.Start, SizeSectors: lp.Size,
+			Type: fmt.Sprintf("0x%02X", lp.Type), EBR: lp.EBR,
+		})
+		if lp.Start <= lp.EBR || lp.Start+lp.Size > ext.EndSector() {
+			l.anomaly(AnomalyOutsideExt, []int{len(l.Partitions) - 1}, "EBR partition %d (sectors %d-%d) is not inside its extended partition after its EBR at sector %d (sectors %d-%d)",
+				n, lp.Start, lp.Start+lp.Size-1, lp.EBR, ext.StartSector, ext.EndSector()-1)
+		}
+	}
+	if err != nil {
+		l.anomaly(AnomalyEBRChain, nil, "extended partition %d: %v", ext.Slot, err)
+	}
+}
+
+// reconcileGPT checks the GPT header's geometry against the media and returns
+// the usable sector range [first, end) and the end of the disk the table
+// describes (the sector after the backup header, or TotalSectors when it
+// cannot tell). The sectors between the two ends hold the backup entry array
+// and header.
+func (l *DiskLayout) reconcileGPT(e *EWFImage, firstLBA, lastLBA uint64) (uint64, uint64, uint64) {
+	total := l.TotalSectors
+	firstUsable, tableEnd := uint64(34), total
+	if firstLBA > 0 {
+		firstUsable = firstLBA
+	}
+	// ParseGPTHeader leaves BackupLBA zero; read it from the header sector.
+	var backup uint64
+	if hdr, err := e.ReadSectors(1, 1); err == nil && len(hdr) >= 40 {
+		backup = binary.LittleEndian.Uint64(hdr[32:40])
+	}
+	switch {
+	case backup == 0:
+	case backup >= total:
+		l.anomaly(AnomalyGPTGeometry, nil, "GPT backup header at sector %d is past the %d-sector media (image truncated or disk shrunk)", backup, total)
+	case backup < total-1:
+		l.anomaly(AnomalyGPTGeometry, nil, "GPT backup header at sector %d, but the media ends at sector %d: %d sectors follow the described disk",
+			backup, total-1, total-1-backup)
+		tableEnd = backup + 1
+	}
+	if lastLBA >= tableEnd {
+		l.anomaly(AnomalyGPTGeometry, nil, "GPT last usable sector %d is past the end of the disk (%d sectors)", lastLBA, tableEnd)
+	}
+	usableEnd := lastLBA + 1
+	if lastLBA == 0 || lastLBA >= tableEnd || usableEnd < firstUsable {
+		usableEnd = tableEnd - minU64(tableEnd, 33) // backup entry array + header
+	}
+	return firstUsable, usableEnd, tableEnd
+}
+
+// checkHybrid compares the non-protective entries of a hybrid MBR with the GPT
+// partitions: each must match a GPT partition's start and size exactly.
+func (l *DiskLayout) checkHybrid(mbrParts []DeclaredPartition) {
+	for _, m := range mbrParts {
+		match := -1
+		for i, g := range l.Partitions {
+			if g.StartSector == m.StartSector {
+				match = i
+				break
+			}
+		}
+		switch {
+		case match < 0:
+			l.anomaly(AnomalyHybridMismatch, nil, "hybrid MBR entry %d (type %s, sectors %d-%d) matches no GPT partition",
+				m.Slot, m.Type, m.StartSector, m.EndSector()-1)
+		case l.Partitions[match].SizeSectors != m.SizeSectors:
+			g := l.Partitions[match]
+			l.anomaly(AnomalyHybridMismatch, []int{match}, "hybrid MBR entry %d declares %d sectors at %d, GPT partition %d declares %d",
+				m.Slot, m.SizeSectors, m.StartSector, g.Slot, g.SizeSectors)
+		}
+	}
+}
+
+// findUnallocated lists the sectors in [firstUsable, usableEnd) that no
+// declared partition covers. Sectors at or after tableEnd (past the disk the
+// table describes) form an HPA-like tail; on a GPT disk the backup entry array
+// and header in [usableEnd, tableEnd) are table metadata, not free space.
+func (l *DiskLayout) findUnallocated(e *EWFImage, firstUsable, usableEnd, tableEnd uint64) {
+	total := l.TotalSectors
+	add := func(kind string, start, end uint64) {
+		if end > start {
+			l.Unallocated = append(l.Unallocated, UnallocatedRegion{
+				Kind: kind, StartSector: start, SizeSectors: end - start, SizeBytes: (end - start) * 512, img: e,
+			})
+		}
+	}
+	if l.Scheme == "none" {
+		add(RegionUnpartition, 0, total)
+		return
+	}
+	var covered [][2]uint64
+	for _, p := range l.Partitions {
+		switch {
+		case p.Extended:
+			// A container: the space between its logical partitions is free.
+			covered = append(covered, [2]uint64{p.StartSector, p.StartSector + 1})
+		case p.Table == "EBR":
+			covered = append(covered, [2]uint64{p.EBR, p.EBR + 1}, [2]uint64{p.StartSector, p.EndSector()})
+		default:
+			covered = append(covered, [2]uint64{p.StartSector, p.EndSector()})
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i][0] < covered[j][0] })
+	for _, g := range gaps(covered, firstUsable, usableEnd) {
+		switch {
+		case len(covered) == 0 || g[1] <= covered[0][0]:
+			add(RegionPostMBRGap, g[0], g[1])
+		case g[1] == usableEnd && !coveredFrom(covered, g[1]):
+			add(RegionAfterLast, g[0], g[1])
+		default:
+			add(RegionGap, g[0], g[1])
+		}
+	}
+	if tableEnd < total {
+		start := tableEnd
+		for _, c := range covered {
+			if c[1] > start {
+				start = c[1]
+			}
+		}
+		add(RegionHiddenTail, minU64(start, total), total)
+	}
+}
+
+// coveredFrom reports whether any partition ends after sector s.
+func coveredFrom(covered [][2]uint64, s uint64) bool {
+	for _, c := range covered {
+		if c[1] > s {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *DiskLayout) anomaly(kind string, parts []int, format string, args ...any) {
+	l.Anomalies = append(l.Anomalies, LayoutAnomaly{Kind: kind, Partitions: parts, Description: fmt.Sprintf(format, args...)})
+}
+
+// ReadAt implements io.ReaderAt over the region's bytes. Reads past the end of
+// the region return the readable prefix and io.EOF; nothing is fabricated.
+func (r UnallocatedRegion) ReadAt(p []byte, off int64) (int, error) {
+	if r.img == nil {
+		return 0, fmt.Errorf("region not bound to an image")
+	}
+	if off < 0 {
+		return 0, errors.New("negative read offset")
+	}
+	size := int64(r.SizeBytes)
+	if off >= size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	want := int64(len(p))
+	if want > size-off {
+		want = size - off
+	}
+	first := off / 512
+	intra := off % 512
+	count := (intra + want + 511) / 512
+	raw, err := r.img.ReadSectors(r.StartSector+uint64(first), uint64(count))
+	if err != nil {
+		return 0, fmt.Errorf("%s at sector %d: %w", r.Kind, r.StartSector, err)
+	}
+	if int64(len(raw)) < intra+want {
+		return 0, fmt.Errorf("%s at sector %d: short read: got %d bytes, need %d", r.Kind, r.StartSector, len(raw), intra+want)
+	}
+	n := copy(p, raw[intra:intra+want])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func minU64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
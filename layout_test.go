@@ -0,0 +1,221 @@
+package ewf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// setMBREntry writes MBR partition entry slot of disk.
+func setMBREntry(disk []byte, slot int, typ byte, start, size uint32) {
+	e := disk[446+16*slot:]
+	e[4] = typ
+	binary.LittleEndian.PutUint32(e[8:], start)
+	binary.LittleEndian.PutUint32(e[12:], size)
+	disk[510], disk[511] = 0x55, 0xAA
+}
+
+// TestDiskLayoutMBR maps an MBR disk with a post-MBR gap, an inter-partition
+// gap, two overlapping partitions and one that runs off the media, and reads a
+// gap back through its io.ReaderAt.
+func TestDiskLayoutMBR(t *testing.T) {
+	disk := ewffixture.DiskPattern(1000)
+	for i := 446; i < 512; i++ {
+		disk[i] = 0
+	}
+	setMBREntry(disk, 0, 0x07, 100, 100) // 100-199
+	setMBREntry(disk, 1, 0x83, 300, 200) // 300-499
+	setMBREntry(disk, 2, 0x83, 450, 100) // 450-549: overlaps entry 1
+	setMBREntry(disk, 3, 0x0C, 900, 200) // 900-1099: past the media
+	img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	l, err := img.DiskLayout()
+	if err != nil {
+		t.Fatalf("DiskLayout: %v", err)
+	}
+	if l.Scheme != "MBR" || len(l.Partitions) != 4 {
+		t.Fatalf("scheme %q with %d partitions, want MBR with 4", l.Scheme, len(l.Partitions))
+	}
+	want := []struct {
+		kind        string
+		start, size uint64
+	}{
+		{RegionPostMBRGap, 1, 99},
+		{RegionGap, 200, 100},
+		{RegionGap, 550, 350},
+	}
+	if len(l.Unallocated) != len(want) {
+		t.Fatalf("unallocated = %+v, want %d regions", l.Unallocated, len(want))
+	}
+	for i, w := range want {
+		r := l.Unallocated[i]
+		if r.Kind != w.kind || r.StartSector != w.start || r.SizeSectors != w.size || r.SizeBytes != w.size*512 {
+			t.Errorf("region %d = {%q %d %d}, want {%q %d %d}", i, r.Kind, r.StartSector, r.SizeSectors, w.kind, w.start, w.size)
+		}
+	}
+
+	kinds := map[string][]int{}
+	for _, a := range l.Anomalies {
+		kinds[a.Kind] = a.Partitions
+	}
+	if p := kinds[AnomalyOverlap]; len(p) != 2 || p[0] != 1 || p[1] != 2 {
+		t.Errorf("overlap anomaly partitions = %v, want [1 2] (anomalies %+v)", p, l.Anomalies)
+	}
+	if p := kinds[AnomalyBeyondMedia]; len(p) != 1 || p[0] != 3 {
+		t.Errorf("beyond-media anomaly partitions = %v, want [3] (anomalies %+v)", p, l.Anomalies)
+	}
+	if len(l.Anomalies) != 2 {
+		t.Errorf("got %d anomalies, want 2: %+v", len(l.Anomalies), l.Anomalies)
+	}
+
+	// The inter-partition gap reads back exactly, with io.EOF past its end.
+	gap := l.Unallocated[1]
+	var r io.ReaderAt = gap
+	buf := make([]byte, 1000)
+	n, err := r.ReadAt(buf, 100*512-600)
+	if n != 600 || !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadAt across the region end = %d, %v; want 600, io.EOF", n, err)
+	}
+	if !bytes.Equal(buf[:n], disk[300*512-600:300*512]) {
+		t.Errorf("ReadAt returned bytes that differ from the gap")
+	}
+	if n, err := r.ReadAt(buf, int64(gap.SizeBytes)); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v; want 0, io.EOF", n, err)
+	}
+}
+
+// TestDiskLayoutExtended follows the EBR chain of an extended partition to
+// its logical partitions, reports the free space between them and the loop
+// the chain ends in, and lists the logical partitions in ScanFileSystems.
+func TestDiskLayoutExtended(t *testing.T) {
+	disk := ewffixture.DiskPattern(1000)
+	for _, s := range []int{0, 300, 500} {
+		for i := s*512 + 446; i < s*512+512; i++ {
+			disk[i] = 0
+		}
+	}
+	setMBREntry(disk, 0, 0x07, 100, 100) // 100-199
+	setMBREntry(disk, 1, 0x0F, 300, 600) // extended, 300-899
+	ebr := func(s int) []byte { return disk[s*512:] }
+	setMBREntry(ebr(300), 0, 0x83, 2, 98)   // logical 302-399
+	setMBREntry(ebr(300), 1, 0x05, 200, 50) // next EBR at 300+200
+	setMBREntry(ebr(500), 0, 0x0B, 10, 100) // logical 510-609
+	setMBREntry(ebr(500), 1, 0x05, 200, 50) // back to itself
+	img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	l, err := img.DiskLayout()
+	if err != nil {
+		t.Fatalf("DiskLayout: %v", err)
+	}
+	if len(l.Partitions) != 4 || !l.Partitions[1].Extended {
+		t.Fatalf("partitions = %+v, want a primary, the extended one and two logical", l.Partitions)
+	}
+	for i, w := range []DeclaredPartition{
+		{Table: "EBR", Slot: 0, StartSector: 302, SizeSectors: 98, Type: "0x83", EBR: 300},
+		{Table: "EBR", Slot: 1, StartSector: 510, SizeSectors: 100, Type: "0x0B", EBR: 500},
+	} {
+		if got := l.Partitions[2+i]; got != w {
+			t.Errorf("logical partition %d = %+v, want %+v", i, got, w)
+		}
+	}
+	want := []struct {
+		kind        string
+		start, size uint64
+	}{
+		{RegionPostMBRGap, 1, 99},
+		{RegionGap, 200, 100},
+		{RegionGap, 301, 1},
+		{RegionGap, 400, 100},
+		{RegionGap, 501, 9},
+		{RegionAfterLast, 610, 390},
+	}
+	if len(l.Unallocated) != len(want) {
+		t.Fatalf("unallocated = %+v, want %d regions", l.Unallocated, len(want))
+	}
+	for i, w := range want {
+		r := l.Unallocated[i]
+		if r.Kind != w.kind || r.StartSector != w.start || r.SizeSectors != w.size {
+			t.Errorf("region %d = {%q %d %d}, want {%q %d %d}", i, r.Kind, r.StartSector, r.SizeSectors, w.kind, w.start, w.size)
+		}
+	}
+	if len(l.Anomalies) != 1 || l.Anomalies[0].Kind != AnomalyEBRChain {
+		t.Errorf("anomalies = %+v, want one EBR chain loop", l.Anomalies)
+	}
+
+	parts, err := img.ScanFileSystems()
+	if err != nil {
+		t.Fatalf("ScanFileSystems: %v", err)
+	}
+	if len(parts) != 4 || parts[2].StartSector != 302 || parts[3].StartSector != 510 || parts[3].Index != 3 {
+		t.Errorf("ScanFileSystems = %+v, want the logical partitions after the primary entries", parts)
+	}
+}
+
+// TestDiskLayoutGPT maps a GPT disk imaged with more sectors than its table
+// describes (the backup header sits before the last media sector) and whose
+// hybrid MBR disagrees with the GPT.
+func TestDiskLayoutGPT(t *testing.T) {
+	const total, described = 4096, 3072
+	disk := make([]byte, total*512)
+	setMBREntry(disk, 0, 0xEE, 1, described-1)
+	setMBREntry(disk, 1, 0x07, 2048, 300) // GPT partition 0 is 256 sectors
+
+	hdr := disk[512:1024]
+	copy(hdr, "EFI PART")
+	binary.LittleEndian.PutUint64(hdr[24:], 1)
+	binary.LittleEndian.PutUint64(hdr[32:], described-1)
+	binary.LittleEndian.PutUint64(hdr[40:], 34)
+	binary.LittleEndian.PutUint64(hdr[48:], described-34)
+	binary.LittleEndian.PutUint64(hdr[72:], 2)
+	binary.LittleEndian.PutUint32(hdr[80:], 128)
+	binary.LittleEndian.PutUint32(hdr[84:], 128)
+	for i, r := range [][2]uint64{{2048, 2303}, {2560, 2999}} {
+		ent := disk[1024+128*i:]
+		ent[0] = 0xA2 // any non-zero type GUID
+		binary.LittleEndian.PutUint64(ent[32:], r[0])
+		binary.LittleEndian.PutUint64(ent[40:], r[1])
+	}
+	img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	l, err := img.DiskLayout()
+	if err != nil {
+		t.Fatalf("DiskLayout: %v", err)
+	}
+	if l.Scheme != "GPT" || len(l.Partitions) != 2 {
+		t.Fatalf("scheme %q with %d partitions, want GPT with 2", l.Scheme, len(l.Partitions))
+	}
+	want := []struct {
+		kind        string
+		start, size uint64
+	}{
+		{RegionPostMBRGap, 34, 2048 - 34},
+		{RegionGap, 2304, 256},
+		{RegionAfterLast, 3000, described - 33 - 3000},
+		{RegionHiddenTail, described, total - described},
+	}
+	if len(l.Unallocated) != len(want) {
+		t.Fatalf("unallocated = %+v, want %d regions", l.Unallocated, len(want))
+	}
+	for i, w := range want {
+		r := l.Unallocated[i]
+		if r.Kind != w.kind || r.StartSector != w.start || r.SizeSectors != w.size {
+			t.Errorf("region %d = {%q %d %d}, want {%q %d %d}", i, r.Kind, r.StartSector, r.SizeSectors, w.kind, w.start, w.size)
+		}
+	}
+	var sawGeometry, sawHybrid bool
+	for _, a := range l.Anomalies {
+		switch a.Kind {
+		case AnomalyGPTGeometry:
+			sawGeometry = true
+		case AnomalyHybridMismatch:
+			sawHybrid = len(a.Partitions) == 1 && a.Partitions[0] == 0
+		}
+	}
+	if !sawGeometry || !sawHybrid {
+		t.Errorf("anomalies %+v: want a GPT geometry and a hybrid mismatch on partition 0", l.Anomalies)
+	}
+}
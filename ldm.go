@@ -0,0 +1,316 @@
+package ewf
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal"
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// GPT partition type GUIDs of a dynamic disk, in on-disk (mixed-endian) byte
+// order: the LDM metadata partition holds the database, the LDM data partition
+// holds the volumes.
+var (
+	ldmMetadataGUID = [16]byte{0xAA, 0xC8, 0x08, 0x58, 0x8F, 0x7E, 0xE0, 0x42, 0x85, 0xD2, 0xE1, 0xE9, 0x04, 0x34, 0xCF, 0xB3}
+	ldmDataGUID     = [16]byte{0xA0, 0x60, 0x9B, 0xAF, 0x31, 0x14, 0x62, 0x4F, 0xBC, 0x68, 0x33, 0x11, 0x71, 0x4A, 0x69, 0xAD}
+)
+
+// ldmPartitionType is the MBR partition type of a dynamic disk.
+const ldmPartitionType = 0x42
+
+// ldmMember is one dynamic disk of a disk group: its PRIVHEAD and a reader
+// over its sectors.
+type ldmMember struct {
+	img *EWFImage
+	ph  *internal.LDMPrivHead
+}
+
+// LDMPrivHead returns the Logical Disk Manager PRIVHEAD of a dynamic disk. It
+// is read from sector 6 on an MBR disk, from the last sector of the LDM
+// metadata partition on a GPT disk, and from the last sector of the disk as a
+// fallback (the backup copy that closes the database).
+func (e *EWFImage) LDMPrivHead() (*internal.LDMPrivHead, error) {
+	var candidates []uint64
+	if gpt, err := e.GPT(); err == nil && string(gpt.GPTHeader.Signature[:]) == "EFI PART" {
+		for _, p := range gpt.GPTPartitionTable {
+			if p.StartLBA > 0 && p.PartitionTypeGUID == ldmMetadataGUID {
+				candidates = append(candidates, p.EndLBA)
+			}
+		}
+	}
+	candidates = append(candidates, internal.LDMPrivHeadLBA)
+	if total := e.TotalSectors(); total > 0 {
+		candidates = append(candidates, total-1)
+	}
+
+	var firstErr error
+	for _, lba := range candidates {
+		data, err := e.ReadSectors(lba, 1)
+		if err == nil {
+			var ph *internal.LDMPrivHead
+			if ph, err = internal.ParseLDMPrivHead(data); err == nil {
+				return ph, nil
+			}
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("sector %d: %w", lba, err)
+		}
+	}
+	return nil, fmt.Errorf("no LDM PRIVHEAD found: %w", firstErr)
+}
+
+// LDMDatabase reads and parses the dynamic-disk database (TOCBLOCK, VMDB and
+// every VBLK object) located by the disk's PRIVHEAD.
+func (e *EWFImage) LDMDatabase() (*internal.LDMDatabase, error) {
+	ph, err := e.LDMPrivHead()
+	if err != nil {
+		return nil, err
+	}
+	area, err := e.ReadSectors(ph.ConfigStart, ph.ConfigSize)
+	if err != nil {
+		return nil, fmt.Errorf("read LDM database at sector %d: %w", ph.ConfigStart, err)
+	}
+
+	// The TOCBLOCK sits in the second database sector; its "config" entry
+	// locates the VMDB (17 sectors in on every known writer).
+	vmdbSector := uint64(17)
+	if len(area) >= 2*512 {
+		if toc, err := internal.ParseLDMTOC(area[512:1024]); err == nil {
+			vmdbSector = toc.ConfigStart
+		}
+	}
+	if vmdbSector*512+512 > uint64(len(area)) {
+		return nil, fmt.Errorf("LDM VMDB sector %d outside the database", vmdbSector)
+	}
+	vmArea := area[vmdbSector*512:]
+	vm, err := internal.ParseLDMVMDB(vmArea[:512])
+	if err != nil {
+		return nil, err
+	}
+	db, err := internal.ParseLDMVBLKs(vmArea, vm)
+	if err != nil {
+		return nil, err
+	}
+	db.PrivHead = *ph
+	return db, nil
+}
+
+// DynamicVolumes assembles the Windows dynamic (LDM) volumes of this disk's
+// disk group and returns each as a virtual partition that OpenPartition opens
+// with the existing handlers. Simple, spanned, striped and mirrored volumes are
+// assembled; RAID-5 volumes are listed but every read of them fails with
+// ErrUnsupported.
+//
+// A volume may span several physical disks. members supplies the other disks
+// of the group (matched by the disk id in their PRIVHEAD); a volume whose
+// sectors live on a disk that was not supplied is still listed, and reads of
+// those sectors fail with an explicit missing-member error. A mirror is
+// readable as long as one complete plex is present.
+//
+// The returned partitions are numbered from 0; ScanFileSystems appends the
+// single-disk assembly after the declared partitions.
+func (e *EWFImage) DynamicVolumes(members ...*EWFImage) ([]PartitionInfo, error) {
+	db, err := e.LDMDatabase()
+	if err != nil {
+		return nil, err
+	}
+	disks := map[string]ldmMember{db.PrivHead.DiskID: {img: e, ph: &db.PrivHead}}
+	for _, m := range members {
+		if m == nil || m == e {
+			continue
+		}
+		ph, err := m.LDMPrivHead()
+		if err != nil {
+			return nil, fmt.Errorf("member disk: %w", err)
+		}
+		if ph.DiskGroupID != db.PrivHead.DiskGroupID {
+			return nil, fmt.Errorf("member disk %s belongs to disk group %s, not %s", ph.DiskID, ph.DiskGroupID, db.PrivHead.DiskGroupID)
+		}
+		disks[ph.DiskID] = ldmMember{img: m, ph: ph}
+	}
+
+	var parts []PartitionInfo
+	for _, vol := range db.Volumes {
+		v, kind, err := assembleLDMVolume(db, vol, disks)
+		if err != nil {
+			v = volume.Unavailable(fmt.Errorf("dynamic volume %q: %w", vol.Name, err), vol.Size)
+		}
+		pi := PartitionInfo{
+			Index:       len(parts),
+			StartSector: ldmFirstSector(db, vol, e, disks),
+			SizeSectors: vol.Size,
+			SizeBytes:   vol.Size * 512,
+			Type:        "LDM",
+			TypeCode:    ldmPartitionType,
+			TypeName:    "Dynamic " + kind,
+			FileSystem:  detectVolumeFileSystem(v),
+			Virtual:     true,
+			volume:      v,
+		}
+		if pi.FileSystem == "Unknown" {
+			pi.FileSystem = GuessFileSystemFromPartitionType(vol.PartitionType)
+		}
+		parts = append(parts, pi)
+	}
+	return parts, nil
+}
+
+// assembleLDMVolume builds the virtual block device of one dynamic volume and
+// names its layout. Each component is one plex; more than one plex is a mirror.
+func assembleLDMVolume(db *internal.LDMDatabase, vol internal.LDMVolume, disks map[string]ldmMember) (volume.Volume, string, error) {
+	comps := db.ComponentsOf(vol.ObjectID)
+	if len(comps) == 0 {
+		return nil, "Volume", fmt.Errorf("volume has no components")
+	}
+	plexes := make([]filesystem.Reader, 0, len(comps))
+	var first volume.Volume
+	kind := ""
+	for _, c := range comps {
+		plex, k, err := assembleLDMComponent(db, c, vol.Size, disks)
+		if err != nil {
+			return nil, k, fmt.Errorf("component %q: %w", c.Name, err)
+		}
+		if first == nil {
+			first = plex
+		}
+		plexes = append(plexes, plex)
+		kind = k
+	}
+	if len(plexes) == 1 {
+		return first, kind, nil
+	}
+	m, err := volume.NewMirror(plexes, vol.Size)
+	return m, "Mirrored", err
+}
+
+// assembleLDMComponent builds one plex: a linear (simple/spanned) or striped
+// mapping of the component's partitions onto their disks.
+func assembleLDMComponent(db *internal.LDMDatabase, c internal.LDMComponent, size uint64, disks map[string]ldmMember) (volume.Volume, string, error) {
+	parts := db.PartitionsOf(c.ObjectID)
+	if len(parts) == 0 {
+		return nil, "Volume", fmt.Errorf("component has no partitions")
+	}
+	switch c.Layout {
+	case internal.LDMLayoutConcatenated:
+		kind := "Simple"
+		if len(parts) > 1 {
+			kind = "Spanned"
+		}
+		extents := make([]volume.Extent, 0, len(parts))
+		for _, p := range parts {
+			extents = append(extents, ldmExtent(db, p, disks))
+		}
+		l, err := volume.NewLinear(extents, size)
+		return l, kind, err
+	case internal.LDMLayoutStriped:
+		columns := make(map[uint64][]volume.Extent)
+		var order []uint64
+		for i, p := range parts {
+			col := uint64(i)
+			if p.HasIndex {
+				col = p.Index
+			}
+			if _, ok := columns[col]; !ok {
+				order = append(order, col)
+			}
+			columns[col] = append(columns[col], ldmExtent(db, p, disks))
+		}
+		if c.Columns != 0 && uint64(len(order)) != c.Columns {
+			return nil, "Striped", fmt.Errorf("striped component declares %d columns, found %d", c.Columns, len(order))
+		}
+		readers := make([]filesystem.Reader, 0, len(order))
+		for _, col := range order {
+			var colSize uint64
+			for _, x := range columns[col] {
+				if end := x.Start + x.Sectors; end > colSize {
+					colSize = end
+				}
+			}
+			l, err := volume.NewLinear(columns[col], colSize)
+			if err != nil {
+				return nil, "Striped", fmt.Errorf("column %d: %w", col, err)
+			}
+			readers = append(readers, l)
+		}
+		s, err := volume.NewStriped(readers, c.StripeSectors, size)
+		return s, "Striped", err
+	case internal.LDMLayoutRAID5:
+		return nil, "RAID-5", fmt.Errorf("RAID-5 dynamic volumes: %w", filesystem.ErrUnsupported)
+	default:
+		return nil, "Volume", fmt.Errorf("unknown component layout %d: %w", c.Layout, filesystem.ErrUnsupported)
+	}
+}
+
+// ldmExtent maps one LDM partition onto the disk that holds it. A partition on
+// a disk that was not supplied maps to a nil source, so reads of it fail with
+// volume.ErrMissingMember.
+func ldmExtent(db *internal.LDMDatabase, p internal.LDMPartition, disks map[string]ldmMember) volume.Extent {
+	x := volume.Extent{Start: p.VolumeOffset, Sectors: p.Size}
+	d, ok := db.Disk(p.DiskID)
+	if !ok {
+		x.Missing = fmt.Sprintf("undeclared disk object %d", p.DiskID)
+		return x
+	}
+	m, ok := disks[d.DiskID]
+	if !ok {
+		x.Missing = fmt.Sprintf("dynamic disk %s (%s)", d.Name, d.DiskID)
+		return x
+	}
+	x.Source = readerAdapter{img: m.img.ewf}
+	x.SourceLBA = m.ph.LogicalDiskStart + p.Start
+	return x
+}
+
+// ldmFirstSector reports where the volume's first sector lives on img, or 0
+// when it lives on another member disk.
+func ldmFirstSector(db *internal.LDMDatabase, vol internal.LDMVolume, img *EWFImage, disks map[string]ldmMember) uint64 {
+	for _, c := range db.ComponentsOf(vol.ObjectID) {
+		for _, p := range db.PartitionsOf(c.ObjectID) {
+			if p.VolumeOffset != 0 || (p.HasIndex && p.Index != 0) {
+				continue
+			}
+			x := ldmExtent(db, p, disks)
+			if src, ok := x.Source.(readerAdapter); ok && src.img == img.ewf {
+				return x.SourceLBA
+			}
+		}
+	}
+	return 0
+}
+
+// hasLDMPartition reports whether the declared partition table points at a
+// dynamic disk: an MBR type 0x42 entry or a GPT LDM metadata/data partition.
+func hasLDMPartition(mbr internal.MBR, gpt *internal.GPT) bool {
+	for _, p := range mbr.PartitionTable {
+		if p.PartitionType == ldmPartitionType && p.PartitionSize > 0 {
+			return true
+		}
+	}
+	if gpt != nil {
+		for _, p := range gpt.GPTPartitionTable {
+			if p.StartLBA > 0 && (p.PartitionTypeGUID == ldmMetadataGUID || p.PartitionTypeGUID == ldmDataGUID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectVolumeFileSystem runs filesystem detection over the first sectors of a
+// virtual volume, with the same 129-sector window ScanFileSystems uses for
+// declared partitions. An unreadable volume is "Unknown".
+func detectVolumeFileSystem(v filesystem.Reader) string {
+	n := uint64(129)
+	if vv, ok := v.(volume.Volume); ok && vv.Sectors() < n {
+		n = vv.Sectors()
+	}
+	if n == 0 {
+		return "Unknown"
+	}
+	data, err := v.ReadSectors(0, n)
+	if err != nil {
+		return "Unknown"
+	}
+	return DetectFileSystem(data)
+}
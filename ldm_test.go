@@ -0,0 +1,336 @@
+package ewf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+	"github.com/laenix/ewfgo/internal/volume"
+)
+
+// --- Minimal LDM database builder ---
+
+const ldmTestVBLKSize = 128
+
+// ldmObj builds the body of one VBLK object. Fields are placed the way the
+// kernel's ldm.c addresses them: each variable-length field sits at
+// base+r, where r is the offset just past the previous field relative to the
+// previous base.
+type ldmObj struct {
+	b []byte
+}
+
+func newLDMObj(flags, typ byte, id uint64, name string) *ldmObj {
+	o := &ldmObj{b: make([]byte, 0x18)}
+	o.b[0x12], o.b[0x13] = flags, typ
+	o.num(id)
+	o.str(name)
+	return o
+}
+
+func (o *ldmObj) padTo(pos int) {
+	for len(o.b) < pos {
+		o.b = append(o.b, 0)
+	}
+}
+
+func (o *ldmObj) num(v uint64) {
+	var be [8]byte
+	binary.BigEndian.PutUint64(be[:], v)
+	i := 0
+	for i < 7 && be[i] == 0 {
+		i++
+	}
+	o.b = append(o.b, byte(8-i))
+	o.b = append(o.b, be[i:]...)
+}
+
+func (o *ldmObj) str(s string) {
+	o.b = append(o.b, byte(len(s)))
+	o.b = append(o.b, s...)
+}
+
+// rel returns the current end of the object relative to base.
+func (o *ldmObj) rel(base int) int { return len(o.b) - base }
+
+func ldmVolumeObj(id uint64, name string, children, size uint64, ptype byte) []byte {
+	o := newLDMObj(0, 0x51, id, name)
+	o.str("gen")
+	o.str("") // disable-drive-letter field
+	rDisable := o.rel(0x18)
+	o.padTo(0x18 + rDisable)
+	o.b = append(o.b, []byte("ACTIVE")...)
+	o.padTo(0x2D + rDisable)
+	o.num(children)
+	rChild := o.rel(0x2D)
+	o.padTo(0x3D + rChild)
+	o.num(size)
+	rSize := o.rel(0x3D)
+	o.padTo(0x41 + rSize)
+	o.b = append(o.b, ptype)
+	o.padTo(0x52 + rSize)
+	return o.b
+}
+
+func ldmComponentObj(id uint64, name string, layout byte, children, parent, stripe, columns uint64) []byte {
+	var flags byte
+	if layout == 1 {
+		flags = 0x10
+	}
+	o := newLDMObj(flags, 0x32, id, name)
+	o.str("ACTIVE")
+	rState := o.rel(0x18)
+	o.b = append(o.b, layout)
+	o.padTo(0x1D + rState)
+	o.num(children)
+	rChild := o.rel(0x1D)
+	o.padTo(0x2D + rChild)
+	o.num(parent)
+	if layout == 1 {
+		rParent := o.rel(0x2D)
+		o.padTo(0x2E + rParent)
+		o.num(stripe)
+		o.num(columns)
+	}
+	return o.b
+}
+
+func ldmPartitionObj(id uint64, name string, start, volOff, size, parent, disk uint64, index int) []byte {
+	var flags byte
+	if index >= 0 {
+		flags = 0x08
+	}
+	o := newLDMObj(flags, 0x33, id, name)
+	rName := o.rel(0x18)
+	o.padTo(0x24 + rName)
+	o.b = binary.BigEndian.AppendUint64(o.b, start)
+	o.b = binary.BigEndian.AppendUint64(o.b, volOff)
+	o.num(size)
+	o.num(parent)
+	o.num(disk)
+	if index >= 0 {
+		o.num(uint64(index))
+	}
+	return o.b
+}
+
+func ldmDiskObj(id uint64, name, guid string) []byte {
+	o := newLDMObj(0, 0x34, id, name)
+	o.str(guid)
+	o.str("") // alternate name
+	return o.b
+}
+
+// ldmSlots lays objects into VBLK slots after the VMDB header, splitting any
+// object longer than one slot into numbered fragments.
+func ldmSlots(objs [][]byte) ([]byte, uint32) {
+	var area []byte
+	group := uint32(1)
+	for _, obj := range objs {
+		payload := obj[0x10:]
+		per := ldmTestVBLKSize - 0x10
+		recs := (len(payload) + per - 1) / per
+		for r := 0; r < recs; r++ {
+			slot := make([]byte, ldmTestVBLKSize)
+			copy(slot, obj[:0x10])
+			copy(slot[0:4], "VBLK")
+			binary.BigEndian.PutUint32(slot[0x08:], group)
+			binary.BigEndian.PutUint16(slot[0x0C:], uint16(r))
+			binary.BigEndian.PutUint16(slot[0x0E:], uint16(recs))
+			end := (r + 1) * per
+			if end > len(payload) {
+				end = len(payload)
+			}
+			copy(slot[0x10:], payload[r*per:end])
+			area = append(area, slot...)
+		}
+		group++
+	}
+	return area, uint32(len(area) / ldmTestVBLKSize)
+}
+
+// buildLDMDisk writes an MBR dynamic disk: a 0x42 container, the PRIVHEAD at
+// sector 6, the data area at sector 63 and the 2048-sector database after it.
+func buildLDMDisk(dataSectors uint64, diskID, groupID string, objs [][]byte, data map[uint64][]byte) []byte {
+	const ss = 512
+	const dataStart = 63
+	configStart := dataStart + dataSectors
+	total := configStart + 2048
+	disk := make([]byte, total*ss)
+
+	binary.LittleEndian.PutUint32(disk[446+8:], 1)
+	binary.LittleEndian.PutUint32(disk[446+12:], uint32(total-1))
+	disk[446+4] = 0x42
+	disk[510], disk[511] = 0x55, 0xAA
+
+	ph := disk[6*ss : 7*ss]
+	copy(ph, "PRIVHEAD")
+	binary.BigEndian.PutUint16(ph[0x0C:], 2)
+	binary.BigEndian.PutUint16(ph[0x0E:], 12)
+	copy(ph[0x30:], diskID)
+	copy(ph[0xB0:], groupID)
+	copy(ph[0xF0:], "TestDg0")
+	binary.BigEndian.PutUint64(ph[0x11B:], dataStart)
+	binary.BigEndian.PutUint64(ph[0x123:], dataSectors)
+	binary.BigEndian.PutUint64(ph[0x12B:], configStart)
+	binary.BigEndian.PutUint64(ph[0x133:], 2048)
+
+	db := disk[configStart*ss:]
+	toc := db[1*ss : 2*ss]
+	copy(toc, "TOCBLOCK")
+	copy(toc[0x24:], "config")
+	binary.BigEndian.PutUint64(toc[0x2E:], 17)
+	binary.BigEndian.PutUint64(toc[0x36:], 1000)
+	copy(toc[0x46:], "log")
+	binary.BigEndian.PutUint64(toc[0x50:], 1017)
+	binary.BigEndian.PutUint64(toc[0x58:], 200)
+
+	slots, n := ldmSlots(objs)
+	vmdb := db[17*ss:]
+	copy(vmdb, "VMDB")
+	headerSlots := uint32(ss / ldmTestVBLKSize)
+	binary.BigEndian.PutUint32(vmdb[0x04:], headerSlots+n)
+	binary.BigEndian.PutUint32(vmdb[0x08:], ldmTestVBLKSize)
+	binary.BigEndian.PutUint32(vmdb[0x0C:], ss)
+	binary.BigEndian.PutUint16(vmdb[0x12:], 4)
+	binary.BigEndian.PutUint16(vmdb[0x14:], 10)
+	copy(vmdb[ss:], slots)
+
+	for rel, b := range data {
+		copy(disk[(dataStart+rel)*ss:], b)
+	}
+	return disk
+}
+
+// TestDynamicVolumes assembles spanned, striped and mirrored dynamic volumes
+// from one disk's LDM database and verifies each reads the exact bytes it
+// maps. The spanned volume holds the committed FAT16 fixture filesystem with
+// its two halves stored in reverse order, so OpenFileSystem only finds the
+// fixture file when the span is reassembled by volume offset.
+func TestDynamicVolumes(t *testing.T) {
+	src, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open fixture: %v", err)
+	}
+	fat, err := src.ReadSectors(2048, 32768)
+	src.Close()
+	if err != nil {
+		t.Fatalf("read fixture partition: %v", err)
+	}
+
+	const (
+		diskID  = "5f1e7c3a-0d2b-4c55-9a61-2b3c4d5e6f70"
+		otherID = "6a2f8d4b-1e3c-4d66-8b72-3c4d5e6f7081"
+		groupID = "a1b2c3d4-e5f6-4711-8899-aabbccddeeff"
+	)
+	half := uint64(16384)
+	stripe := ewffixture.DiskPattern(512)
+	mirror := ewffixture.DiskPattern(300)[:256*512]
+
+	// Column c of the striped volume holds stripes c, c+2, ... of 64 sectors.
+	cols := [2][]byte{}
+	for s := 0; s < 512/64; s++ {
+		cols[s%2] = append(cols[s%2], stripe[s*64*512:(s+1)*64*512]...)
+	}
+
+	objs := [][]byte{
+		ldmDiskObj(1, "Disk1", diskID),
+		ldmDiskObj(2, "Disk2-with-a-long-name-that-forces-the-object-into-two-fragments-"+string(bytes.Repeat([]byte("x"), 64)), otherID),
+		// Spanned FAT16: the second half is stored first on disk.
+		ldmVolumeObj(10, "Volume1", 1, 2*half, 0x06),
+		ldmComponentObj(11, "Volume1-01", 2, 2, 10, 0, 0),
+		ldmPartitionObj(12, "Disk1-01", 0, half, half, 11, 1, -1),
+		ldmPartitionObj(13, "Disk1-02", half+8, 0, half, 11, 1, -1),
+		// Striped: two columns, 64-sector stripes.
+		ldmVolumeObj(20, "Volume2", 1, 512, 0x07),
+		ldmComponentObj(21, "Volume2-01", 1, 2, 20, 64, 2),
+		ldmPartitionObj(22, "Disk1-03", 2*half+8, 0, 256, 21, 1, 1),
+		ldmPartitionObj(23, "Disk1-04", 2*half+8+256, 0, 256, 21, 1, 0),
+		// Mirrored: plex 1 lives on the absent Disk2, plex 2 here.
+		ldmVolumeObj(30, "Volume3", 2, 256, 0x07),
+		ldmComponentObj(31, "Volume3-01", 2, 1, 30, 0, 0),
+		ldmPartitionObj(32, "Disk2-01", 0, 0, 256, 31, 2, -1),
+		ldmComponentObj(33, "Volume3-02", 2, 1, 30, 0, 0),
+		ldmPartitionObj(34, "Disk1-05", 2*half+8+512, 0, 256, 33, 1, -1),
+		// Simple volume wholly on the absent Disk2.
+		ldmVolumeObj(40, "Volume4", 1, 128, 0x07),
+		ldmComponentObj(41, "Volume4-01", 2, 1, 40, 0, 0),
+		ldmPartitionObj(42, "Disk2-02", 256, 0, 128, 41, 2, -1),
+	}
+	data := map[uint64][]byte{
+		0:                fat[half*512:],
+		half + 8:         fat[:half*512],
+		2*half + 8:       cols[1],
+		2*half + 8 + 256: cols[0],
+		2*half + 8 + 512: mirror,
+	}
+	disk := buildLDMDisk(2*half+8+768, diskID, groupID, objs, data)
+	img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	parts, err := img.ScanFileSystems()
+	if err != nil {
+		t.Fatalf("ScanFileSystems: %v", err)
+	}
+	if len(parts) != 5 {
+		t.Fatalf("got %d partitions, want the 0x42 container + 4 dynamic volumes: %+v", len(parts), parts)
+	}
+	if parts[0].TypeCode != 0x42 || parts[0].Virtual {
+		t.Errorf("partition 0 = %+v, want the declared 0x42 container", parts[0])
+	}
+	wantKinds := []string{"Dynamic Spanned", "Dynamic Striped", "Dynamic Mirrored", "Dynamic Simple"}
+	for i, want := range wantKinds {
+		p := parts[i+1]
+		if !p.Virtual || p.TypeName != want || p.Index != i+1 {
+			t.Errorf("partition %d = {Index:%d TypeName:%q Virtual:%v}, want {%d %q true}", i+1, p.Index, p.TypeName, p.Virtual, i+1, want)
+		}
+	}
+	if parts[1].FileSystem != "FAT16" {
+		t.Errorf("spanned volume filesystem = %q, want FAT16", parts[1].FileSystem)
+	}
+	if parts[1].StartSector != 63+half+8 {
+		t.Errorf("spanned volume StartSector = %d, want %d", parts[1].StartSector, 63+half+8)
+	}
+
+	fs, err := img.OpenFileSystem(1)
+	if err != nil {
+		t.Fatalf("OpenFileSystem(spanned): %v", err)
+	}
+	got, err := fs.ReadFile("/FIXTURE.TXT")
+	fs.Close()
+	if err != nil || string(got) != "fixture\n" {
+		t.Fatalf("spanned ReadFile = %q, %v; want %q", got, err, "fixture\n")
+	}
+
+	readAll := func(p PartitionInfo) ([]byte, error) {
+		return p.volume.ReadSectors(0, p.SizeSectors)
+	}
+	if got, err := readAll(parts[2]); err != nil || !bytes.Equal(got, stripe) {
+		t.Errorf("striped volume read mismatch (err %v)", err)
+	}
+	if got, err := readAll(parts[3]); err != nil || !bytes.Equal(got, mirror) {
+		t.Errorf("mirrored volume read mismatch (err %v)", err)
+	}
+	if _, err := readAll(parts[4]); !errors.Is(err, volume.ErrMissingMember) {
+		t.Errorf("volume on an absent disk: err = %v, want volume.ErrMissingMember", err)
+	}
+}
+
+// shortReader returns a single sector whatever the read size.
+type shortReader struct{}
+
+func (shortReader) ReadSectors(uint64, uint64) ([]byte, error) { return make([]byte, 512), nil }
+
+// TestVolumeShortRead: a member read that returns fewer bytes than the
+// sectors asked for is an error, not data that shifts the later extents.
+func TestVolumeShortRead(t *testing.T) {
+	l, err := volume.NewLinear([]volume.Extent{{Start: 0, Sectors: 4, Source: shortReader{}}}, 4)
+	if err != nil {
+		t.Fatalf("NewLinear: %v", err)
+	}
+	if got, err := l.ReadSectors(0, 2); err == nil {
+		t.Errorf("ReadSectors(0, 2) over a short member = %d bytes, want an error", len(got))
+	}
+}
@@ -0,0 +1,180 @@
+package ewf
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// LogFileRestart describes the NTFS $LogFile restart area a log was parsed
+// from, the newer of its two copies.
+type LogFileRestart struct {
+	MajorVersion int
+	MinorVersion int
+	CurrentLSN   uint64
+	Clean        bool // the volume was cleanly dismounted
+	PageSize     int
+	FileSize     int64
+}
+
+// LogFileEvent is one NTFS $LogFile client record: a redo/undo operation
+// pair. FileRef, ParentRef and Name are decoded from the operation where it
+// describes a file (an MFT record image, a $FILE_NAME attribute or an index
+// entry); otherwise FileRef is the MFT record a record-level operation
+// targets, with sequence number 0, as the log does not carry it.
+type LogFileEvent struct {
+	LSN           uint64
+	PreviousLSN   uint64 // previous record of the same transaction
+	UndoNextLSN   uint64
+	Offset        int64 // byte offset of the record in $LogFile
+	TransactionID uint32
+	RedoOp        uint16 // named by RedoName
+	UndoOp        uint16 // named by UndoName
+	TargetVCN     uint64
+	// ClusterBlockOffset is the 512-byte block within the target cluster.
+	ClusterBlockOffset uint16
+	RecordOffset       uint16 // offset of the change in the MFT record or index buffer
+	AttributeOffset    uint16 // offset of the change in the attribute
+	RedoData           []byte
+	UndoData           []byte
+	FileRef            uint64
+	ParentRef          uint64
+	Name               string
+	// Path is Name under its parent directory's current path, or the target
+	// record's own path, as the MFT stands in the image; it is empty when
+	// the record was since reused.
+	Path string
+}
+
+// logFileOps names the NTFS log operation codes.
+var logFileOps = []string{
+	"Noop",
+	"CompensationLogRecord",
+	"InitializeFileRecordSegment",
+	"DeallocateFileRecordSegment",
+	"WriteEndOfFileRecordSegment",
+	"CreateAttribute",
+	"DeleteAttribute",
+	"UpdateResidentValue",
+	"UpdateNonresidentValue",
+	"UpdateMappingPairs",
+	"DeleteDirtyClusters",
+	"SetNewAttributeSizes",
+	"AddIndexEntryRoot",
+	"DeleteIndexEntryRoot",
+	"AddIndexEntryAllocation",
+	"DeleteIndexEntryAllocation",
+	"WriteEndOfIndexBuffer",
+	"SetIndexEntryVcnRoot",
+	"SetIndexEntryVcnAllocation",
+	"UpdateFileNameRoot",
+	"UpdateFileNameAllocation",
+	"SetBitsInNonresidentBitMap",
+	"ClearBitsInNonresidentBitMap",
+	"HotFix",
+	"EndTopLevelAction",
+	"PrepareTransaction",
+	"CommitTransaction",
+	"ForgetTransaction",
+	"OpenNonresidentAttribute",
+	"OpenAttributeTableDump",
+	"AttributeNamesDump",
+	"DirtyPageTableDump",
+	"TransactionTableDump",
+	"UpdateRecordDataRoot",
+	"UpdateRecordDataAllocation",
+}
+
+// logFileOpName names op, or formats it in hex when it has no name.
+func logFileOpName(op uint16) string {
+	if int(op) < len(logFileOps) {
+		return logFileOps[op]
+	}
+	return fmt.Sprintf("%#x", op)
+}
+
+// RedoName names the event's redo operation, "InitializeFileRecordSegment".
+func (e LogFileEvent) RedoName() string {
+	return logFileOpName(e.RedoOp)
+}
+
+// UndoName names the event's undo operation.
+func (e LogFileEvent) UndoName() string {
+	return logFileOpName(e.UndoOp)
+}
+
+// LogFile streams the events of an NTFS transaction log (see
+// ImageFS.OpenLogFile).
+type LogFile struct {
+	l filesystem.LogFile
+}
+
+// Restart returns the restart area the log was parsed from.
+func (l *LogFile) Restart() LogFileRestart {
+	r := l.l.Restart()
+	return LogFileRestart{
+		MajorVersion: r.MajorVersion,
+		MinorVersion: r.MinorVersion,
+		CurrentLSN:   r.CurrentLSN,
+		Clean:        r.Clean,
+		PageSize:     r.PageSize,
+		FileSize:     r.FileSize,
+	}
+}
+
+// Next returns the next event in LSN order, and io.EOF after the last. A
+// record whose operation data cannot be decoded is returned as an error
+// naming its LSN; calling Next again carries on past it.
+func (l *LogFile) Next() (LogFileEvent, error) {
+	e, err := l.l.Next()
+	if err != nil {
+		return LogFileEvent{}, err
+	}
+	return LogFileEvent{
+		LSN:                e.LSN,
+		PreviousLSN:        e.PreviousLSN,
+		UndoNextLSN:        e.UndoNextLSN,
+		Offset:             e.Offset,
+		TransactionID:      e.TransactionID,
+		RedoOp:             e.RedoOp,
+		UndoOp:             e.UndoOp,
+		TargetVCN:          e.TargetVCN,
+		ClusterBlockOffset: e.ClusterBlockOffset,
+		RecordOffset:       e.RecordOffset,
+		AttributeOffset:    e.AttributeOffset,
+		RedoData:           e.RedoData,
+		UndoData:           e.UndoData,
+		FileRef:            e.FileRef,
+		ParentRef:          e.ParentRef,
+		Name:               e.Name,
+		Path:               e.Path,
+	}, nil
+}
+
+// Close releases the log.
+func (l *LogFile) Close() error {
+	return l.l.Close()
+}
+
+// OpenLogFile parses the NTFS transaction log, $LogFile: its restart area
+// and every record page that passes its update-sequence fixups, including
+// those left over from the log's previous pass, ordered by LSN. Records
+// that span pages are reassembled. Filesystems other than NTFS return
+// ErrUnsupported.
+func (fs *ImageFS) OpenLogFile() (*LogFile, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	opener, ok := fs.fs.(filesystem.LogFileOpener)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no transaction log: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	l, err := opener.OpenLogFile()
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: open $LogFile: %w", fs.part.Index, err)
+	}
+	return &LogFile{l: l}, nil
+}
@@ -0,0 +1,48 @@
+package ewf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestImageFSOpenLogFile: mkntfs leaves the fixture volume's $LogFile
+// unwritten, with no restart area to parse; FAT has no log at all, which is
+// ErrUnsupported.
+func TestImageFSOpenLogFile(t *testing.T) {
+	for _, tc := range []struct {
+		fixture     string
+		unsupported bool
+	}{
+		{"ntfs-encase6-zlib.E01", false},
+		{"fat16-encase6-zlib.E01", true},
+	} {
+		img, err := Open(filepath.Join("testdata", "e01", tc.fixture))
+		if err != nil {
+			t.Fatalf("Open(%s): %v", tc.fixture, err)
+		}
+		fs, err := img.OpenFileSystem(0)
+		if err != nil {
+			t.Fatalf("OpenFileSystem(%s): %v", tc.fixture, err)
+		}
+		_, err = fs.OpenLogFile()
+		if err == nil || errors.Is(err, ErrUnsupported) != tc.unsupported {
+			t.Errorf("%s: OpenLogFile = %v, want unsupported %v", tc.fixture, err, tc.unsupported)
+		}
+		fs.Close()
+		img.Close()
+	}
+}
+
+func TestLogFileEventOpNames(t *testing.T) {
+	e := LogFileEvent{RedoOp: 0x02, UndoOp: 0x03}
+	if got := e.RedoName(); got != "InitializeFileRecordSegment" {
+		t.Errorf("RedoName = %q", got)
+	}
+	if got := e.UndoName(); got != "DeallocateFileRecordSegment" {
+		t.Errorf("UndoName = %q", got)
+	}
+	if got := (LogFileEvent{RedoOp: 0x40}).RedoName(); got != "0x40" {
+		t.Errorf("RedoName(0x40) = %q", got)
+	}
+}
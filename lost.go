@@ -0,0 +1,290 @@
+package ewf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// LostPartitionOptions configures ScanLostPartitions.
+type LostPartitionOptions struct {
+	// WholeDisk scans every sector of the image instead of only the sectors
+	// no declared partition covers. Volumes that start where a declared
+	// partition starts are still not reported, nor are copies of a declared
+	// partition's own boot sector or superblock inside it (a candidate whose
+	// size is the partition's, or that of the volume at its start).
+	WholeDisk bool
+	// Step is the candidate alignment in sectors (0 means 1: every sector).
+	// Partitioning tools align to 2048 (1 MiB) or, on older disks, 63; a
+	// coarser step makes a whole-disk scan cheaper but misses volumes that
+	// start off the grid.
+	Step uint64
+}
+
+// lostScanChunk is the number of sectors read per scan request.
+const lostScanChunk = 2048
+
+// lostProbeSectors is the look-ahead past a candidate sector the quick probe
+// needs: the ext superblock lives at byte 1024 (sectors 2-3).
+const lostProbeSectors = 4
+
+// ScanLostPartitions searches for filesystems that no partition table entry
+// declares — volumes left behind by a repartition or a reformat. It probes
+// candidate sectors for the boot-sector and superblock signatures
+// DetectFileSystem recognises (NTFS, FAT12/16/32, exFAT, ext2/3/4, XFS, APFS),
+// runs DetectFileSystem over the same 129-sector window ScanFileSystems uses,
+// and keeps a candidate only when its own geometry (total sectors, block size
+// and count) is self-consistent and the volume fits on the disk.
+//
+// By default only the gaps between declared partitions (and the space after
+// the last one) are scanned; opts.WholeDisk scans every sector. Once a volume
+// is accepted, candidates inside it (backup boot sectors, backup superblocks,
+// checkpoint copies) are skipped; inside a declared partition, candidates
+// with the partition's own geometry are copies of its boot sector or
+// superblock and are not reported either.
+//
+// The scan is opt-in: it reads every scanned sector. Recovered volumes are
+// numbered from 0, have Type "Recovered" and open with OpenPartition like any
+// declared partition.
+func (e *EWFImage) ScanLostPartitions(opts LostPartitionOptions) ([]PartitionInfo, error) {
+	total := e.TotalSectors()
+	if total == 0 {
+		return nil, fmt.Errorf("image has no sectors")
+	}
+	step := opts.Step
+	if step == 0 {
+		step = 1
+	}
+
+	declared, err := e.ScanFileSystems()
+	if err != nil {
+		return nil, err
+	}
+	var covered [][2]uint64 // [start, end) of declared partitions
+	var sizes [][]uint64    // sizes a copy of covered[i]'s own volume declares
+	declaredStart := make(map[uint64]bool)
+	for _, p := range declared {
+		if p.Virtual {
+			continue
+		}
+		declaredStart[p.StartSector] = true
+		if p.SizeSectors > 0 {
+			covered = append(covered, [2]uint64{p.StartSector, p.StartSector + p.SizeSectors})
+			own := []uint64{p.SizeSectors}
+			if v, ok := e.validateLost(p.StartSector, total); ok {
+				own = append(own, v.SizeSectors)
+			}
+			sizes = append(sizes, own)
+		}
+	}
+	// ownCopy reports whether a candidate inside a declared partition has the
+	// geometry of that partition: a backup boot sector or superblock.
+	ownCopy := func(p PartitionInfo) bool {
+		for i, c := range covered {
+			if p.StartSector < c[0] || p.StartSector >= c[1] {
+				continue
+			}
+			for _, n := range sizes[i] {
+				if p.SizeSectors == n {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var ranges [][2]uint64
+	if opts.WholeDisk {
+		ranges = [][2]uint64{{0, total}}
+	} else {
+		ranges = gaps(covered, 1, total)
+	}
+
+	var found []PartitionInfo
+	for _, r := range ranges {
+		for lba := alignUp(r[0], step); lba < r[1]; {
+			n := uint64(lostScanChunk)
+			if step >= n {
+				n = 1 // one candidate per read on a coarse grid
+			}
+			if lba+n > r[1] {
+				n = r[1] - lba
+			}
+			readN := n + lostProbeSectors
+			if lba+readN > total {
+				readN = total - lba
+			}
+			buf, err := e.ReadSectors(lba, readN)
+			if err != nil {
+				return found, fmt.Errorf("scan sector %d: %w", lba, err)
+			}
+			for off := uint64(0); off < n; off += step {
+				cand := lba + off
+				if declaredStart[cand] || inRanges(found, cand) {
+					continue
+				}
+				if !lostProbe(buf[off*512:]) {
+					continue
+				}
+				if p, ok := e.validateLost(cand, total); ok && !ownCopy(p) {
+					p.Index = len(found)
+					found = append(found, p)
+				}
+			}
+			lba += alignUp(n, step)
+		}
+	}
+	return found, nil
+}
+
+// validateLost confirms a candidate at lba: DetectFileSystem names it and the
+// filesystem's own geometry gives a size that fits on the disk.
+func (e *EWFImage) validateLost(lba, total uint64) (PartitionInfo, bool) {
+	n := uint64(129)
+	if lba+n > total {
+		n = total - lba
+	}
+	window, err := e.ReadSectors(lba, n)
+	if err != nil {
+		return PartitionInfo{}, false
+	}
+	fsType := filesystem.DetectFileSystem(window)
+	size, ok := lostVolumeSectors(fsType, window)
+	if !ok || size == 0 || size > total-lba {
+		return PartitionInfo{}, false
+	}
+	return PartitionInfo{
+		StartSector: lba,
+		SizeSectors: size,
+		SizeBytes:   size * 512,
+		Type:        "Recovered",
+		TypeName:    "Recovered " + string(fsType),
+		FileSystem:  string(fsType),
+	}, true
+}
+
+// lostProbe is the cheap per-sector filter: a boot-sector signature at the
+// candidate sector or a superblock magic at its fixed offset. b starts at the
+// candidate and may be shorter than lostProbeSectors at the end of the disk.
+func lostProbe(b []byte) bool {
+	if len(b) < 512 {
+		return false
+	}
+	if b[510] == 0x55 && b[511] == 0xAA {
+		if string(b[3:7]) == "NTFS" || string(b[3:11]) == "EXFAT   " ||
+			string(b[0x36:0x3A]) == "FAT1" || string(b[0x52:0x57]) == "FAT32" {
+			return true
+		}
+	}
+	if string(b[0:4]) == "XFSB" || string(b[0x20:0x24]) == "NXSB" {
+		return true
+	}
+	return len(b) >= 1024+0x3A && binary.LittleEndian.Uint16(b[1024+0x38:]) == 0xEF53
+}
+
+// lostVolumeSectors returns the size in 512-byte sectors a recovered volume
+// declares for itself, or false when its geometry is not self-consistent.
+func lostVolumeSectors(fsType filesystem.FileSystemType, b []byte) (uint64, bool) {
+	switch fsType {
+	case filesystem.FS_NTFS:
+		bps := uint64(binary.LittleEndian.Uint16(b[0x0B:]))
+		if !validSectorSize(bps) || !powerOfTwo(uint64(b[0x0D])) {
+			return 0, false
+		}
+		// The backup boot sector follows the declared total.
+		return (binary.LittleEndian.Uint64(b[0x28:]) + 1) * bps / 512, true
+	case filesystem.FS_FAT12, filesystem.FS_FAT16, filesystem.FS_FAT32:
+		bps := uint64(binary.LittleEndian.Uint16(b[0x0B:]))
+		reserved := binary.LittleEndian.Uint16(b[0x0E:])
+		if !validSectorSize(bps) || !powerOfTwo(uint64(b[0x0D])) || reserved == 0 || b[0x10] == 0 || b[0x10] > 2 {
+			return 0, false
+		}
+		n := uint64(binary.LittleEndian.Uint16(b[0x13:]))
+		if n == 0 {
+			n = uint64(binary.LittleEndian.Uint32(b[0x20:]))
+		}
+		return n * bps / 512, n > uint64(reserved)
+	case filesystem.FS_EXFAT:
+		shift, spc := b[0x6C], b[0x6D]
+		if shift < 9 || shift > 12 || spc > 25-shift {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint64(b[0x48:]) << (shift - 9), true
+	case filesystem.FS_EXT2, filesystem.FS_EXT3, filesystem.FS_EXT4:
+		if len(b) < 1024+0x160 {
+			return 0, false
+		}
+		sb := b[1024:]
+		logBlock := binary.LittleEndian.Uint32(sb[0x18:])
+		if logBlock > 6 || binary.LittleEndian.Uint16(sb[0x5A:]) != 0 {
+			// Oversized block, or a backup superblock (block group != 0).
+			return 0, false
+		}
+		blockSize := uint64(1024) << logBlock
+		if first := binary.LittleEndian.Uint32(sb[0x14:]); (blockSize == 1024) != (first == 1) {
+			return 0, false
+		}
+		blocks := uint64(binary.LittleEndian.Uint32(sb[0x04:]))
+		if binary.LittleEndian.Uint32(sb[0x60:])&0x80 != 0 { // INCOMPAT_64BIT
+			blocks |= uint64(binary.LittleEndian.Uint32(sb[0x150:])) << 32
+		}
+		return blocks * blockSize / 512, true
+	case filesystem.FS_XFS:
+		blockSize := uint64(binary.BigEndian.Uint32(b[4:]))
+		if blockSize < 512 || blockSize > 65536 || !powerOfTwo(blockSize) {
+			return 0, false
+		}
+		return binary.BigEndian.Uint64(b[8:]) * blockSize / 512, true
+	case filesystem.FS_APFS:
+		blockSize := uint64(binary.LittleEndian.Uint32(b[0x24:]))
+		if blockSize < 4096 || blockSize > 65536 || !powerOfTwo(blockSize) {
+			return 0, false
+		}
+		return binary.LittleEndian.Uint64(b[0x28:]) * blockSize / 512, true
+	}
+	return 0, false
+}
+
+// gaps returns the sub-ranges of [from, to) that no range in covered touches.
+func gaps(covered [][2]uint64, from, to uint64) [][2]uint64 {
+	c := append([][2]uint64(nil), covered...)
+	sort.Slice(c, func(i, j int) bool { return c[i][0] < c[j][0] })
+	var out [][2]uint64
+	cur := from
+	for _, r := range c {
+		if r[0] > cur {
+			end := r[0]
+			if end > to {
+				end = to
+			}
+			if end > cur {
+				out = append(out, [2]uint64{cur, end})
+			}
+		}
+		if r[1] > cur {
+			cur = r[1]
+		}
+	}
+	if cur < to {
+		out = append(out, [2]uint64{cur, to})
+	}
+	return out
+}
+
+// inRanges reports whether lba lies inside an already recovered volume.
+func inRanges(parts []PartitionInfo, lba uint64) bool {
+	for _, p := range parts {
+		if lba >= p.StartSector && lba < p.StartSector+p.SizeSectors {
+			return true
+		}
+	}
+	return false
+}
+
+func alignUp(v, step uint64) uint64 { return (v + step - 1) / step * step }
+
+func validSectorSize(n uint64) bool { return n >= 512 && n <= 4096 && powerOfTwo(n) }
+
+func powerOfTwo(n uint64) bool { return n != 0 && n&(n-1) == 0 }
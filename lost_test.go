@@ -0,0 +1,96 @@
+package ewf
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// TestScanLostPartitions places a second copy of the committed FAT16 fixture
+// filesystem in unpartitioned space after the declared partition, next to a
+// decoy ext backup superblock, and checks that the scanner recovers exactly
+// the FAT16 volume with its own geometry and that it opens like a declared
+// partition.
+func TestScanLostPartitions(t *testing.T) {
+	src, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open fixture: %v", err)
+	}
+	fat, err := src.ReadSectors(2048, 32768)
+	src.Close()
+	if err != nil {
+		t.Fatalf("read fixture partition: %v", err)
+	}
+
+	const lostLBA = 2048 + 32768 + 2048
+	disk := ewffixture.WrapMBRDisk(fat, 0x06, 2048)
+	disk = append(disk, make([]byte, (lostLBA+32768+64)*512-len(disk))...)
+	copy(disk[lostLBA*512:], fat)
+	// Decoy: an ext superblock copy from block group 1 (a backup, not a volume).
+	decoy := disk[(2048+32768+16)*512+1024:]
+	binary.LittleEndian.PutUint16(decoy[0x38:], 0xEF53)
+	binary.LittleEndian.PutUint32(decoy[0x04:], 1024)
+	binary.LittleEndian.PutUint32(decoy[0x14:], 1)
+	binary.LittleEndian.PutUint16(decoy[0x5A:], 1)
+	img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{}))
+
+	for _, opts := range []LostPartitionOptions{{}, {WholeDisk: true}, {Step: 2048}} {
+		lost, err := img.ScanLostPartitions(opts)
+		if err != nil {
+			t.Fatalf("ScanLostPartitions(%+v): %v", opts, err)
+		}
+		if len(lost) != 1 {
+			t.Fatalf("ScanLostPartitions(%+v) = %+v, want one recovered volume", opts, lost)
+		}
+		p := lost[0]
+		if p.StartSector != lostLBA || p.SizeSectors != 32768 || p.FileSystem != "FAT16" || p.Type != "Recovered" {
+			t.Errorf("ScanLostPartitions(%+v) = {Start:%d Size:%d FS:%q Type:%q}, want {%d 32768 FAT16 Recovered}",
+				opts, p.StartSector, p.SizeSectors, p.FileSystem, p.Type, lostLBA)
+		}
+	}
+
+	lost, _ := img.ScanLostPartitions(LostPartitionOptions{})
+	fs, err := img.OpenPartition(lost[0])
+	if err != nil {
+		t.Fatalf("OpenPartition(recovered): %v", err)
+	}
+	got, err := fs.ReadFile("/FIXTURE.TXT")
+	fs.Close()
+	if err != nil || string(got) != "fixture\n" {
+		t.Fatalf("recovered ReadFile = %q, %v; want %q", got, err, "fixture\n")
+	}
+}
+
+// TestScanLostPartitionsWholeDiskBackups: a whole-disk scan does not report
+// a declared partition's own backup boot sector (FAT32 at sector 6, NTFS in
+// the last sector) as a recovered volume, even when the disk leaves room
+// after the partition for the volume such a copy declares.
+func TestScanLostPartitionsWholeDiskBackups(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		sectors uint64
+		typ     byte
+	}{
+		{"fat32-encase6-zlib.E01", 65536, 0x0C},
+		{"ntfs-encase6-zlib.E01", 131072, 0x07},
+	} {
+		src, err := Open(filepath.Join("testdata", "e01", tc.fixture))
+		if err != nil {
+			t.Fatalf("Open fixture: %v", err)
+		}
+		vol, err := src.ReadSectors(2048, tc.sectors)
+		src.Close()
+		if err != nil {
+			t.Fatalf("%s: read fixture partition: %v", tc.fixture, err)
+		}
+		disk := ewffixture.WrapMBRDisk(vol, tc.typ, 2048)
+		disk = append(disk, make([]byte, tc.sectors*512)...)
+		img := openE01(t, ewffixture.WrapDisk(disk, ewffixture.Options{Compress: ewffixture.CompressNone, ShortFinalChunk: true}))
+		lost, err := img.ScanLostPartitions(LostPartitionOptions{WholeDisk: true})
+		if err != nil || len(lost) != 0 {
+			t.Errorf("%s: ScanLostPartitions(WholeDisk) = %+v, %v; want none", tc.fixture, lost, err)
+		}
+	}
+}
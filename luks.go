@@ -0,0 +1,102 @@
+package ewf
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/luks"
+)
+
+// LUKSKey is the key material for UnlockLUKS.
+type LUKSKey struct {
+	// Passphrase is tried against every active key slot.
+	Passphrase string
+	// MasterKey is the volume key itself, e.g. recovered from memory or
+	// from a dm-crypt table; it is checked against the header's digest.
+	MasterKey []byte
+}
+
+// LUKSKeyslot is one active key slot of a LUKS volume.
+type LUKSKeyslot struct {
+	Index  int
+	KDF    string // "pbkdf2", "argon2i" or "argon2id"
+	Cipher string // cipher of the key material
+}
+
+// LUKSInfo describes a LUKS volume from its header, which is stored in the
+// clear.
+type LUKSInfo struct {
+	Version       int // 1 or 2
+	UUID          string
+	Label         string // LUKS2 only
+	Cipher        string // e.g. "aes-xts-plain64"
+	KeyBits       int
+	SectorSize    uint32 // encryption sector size
+	PayloadOffset uint64 // bytes from the start of the partition
+	Keyslots      []LUKSKeyslot
+}
+
+// LUKS reads the header of a LUKS partition: its cipher and key slots. No
+// key is needed.
+func (e *EWFImage) LUKS(part PartitionInfo) (*LUKSInfo, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return nil, err
+	}
+	h, err := luks.Parse(src, start)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	info := &LUKSInfo{
+		Version:       h.Version,
+		UUID:          h.UUID,
+		Label:         h.Label,
+		Cipher:        h.Cipher,
+		KeyBits:       h.KeyBytes * 8,
+		SectorSize:    h.SectorSize,
+		PayloadOffset: h.Offset,
+	}
+	for _, ks := range h.Keyslots {
+		info.Keyslots = append(info.Keyslots, LUKSKeyslot{Index: ks.Index, KDF: ks.KDF.Type, Cipher: ks.Cipher})
+	}
+	return info, nil
+}
+
+// UnlockLUKS recovers the volume key of a LUKS1 or LUKS2 partition with key
+// and returns the decrypted payload (the dm-crypt mapped device) as a
+// virtual partition that OpenPartition opens with the ext4, XFS, Btrfs or
+// any other handler. A decrypted LVM physical volume is reported with
+// FileSystem "LVM2".
+//
+// A passphrase that opens no key slot, or a master key that does not match
+// the volume key digest, fails with ErrWrongKey. Every read of the returned
+// partition decrypts on the fly.
+func (e *EWFImage) UnlockLUKS(part PartitionInfo, key LUKSKey) (PartitionInfo, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return PartitionInfo{}, err
+	}
+	v, err := luks.Open(src, start, part.SizeSectors, luks.Key{Passphrase: key.Passphrase, MasterKey: key.MasterKey})
+	if err != nil {
+		return PartitionInfo{}, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	fs := detectVolumeFileSystem(v)
+	if fs == "Unknown" && v.Sectors() > 1 {
+		if label, err := v.ReadSectors(1, 1); err == nil && string(label[:8]) == "LABELONE" && string(label[24:32]) == "LVM2 001" {
+			fs = "LVM2"
+		}
+	}
+	h := v.Header()
+	pi := PartitionInfo{
+		Index:       part.Index,
+		StartSector: part.StartSector + h.Offset/512,
+		SizeSectors: v.Sectors(),
+		SizeBytes:   v.Sectors() * 512,
+		Type:        "LUKS",
+		TypeCode:    part.TypeCode,
+		TypeName:    fmt.Sprintf("LUKS%d %s", h.Version, h.Cipher),
+		FileSystem:  fs,
+		Virtual:     true,
+		volume:      v,
+	}
+	return pi, nil
+}
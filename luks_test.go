@@ -0,0 +1,139 @@
+package ewf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// openLUKS wraps a LUKS volume into an MBR disk image and returns the image
+// and its LUKS partition.
+func openLUKS(t *testing.T, vol []byte) (*EWFImage, PartitionInfo) {
+	t.Helper()
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(vol, 0x83, 2048), ewffixture.Options{Compress: ewffixture.CompressNone}))
+	parts, err := img.ScanFileSystems()
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("ScanFileSystems: %v (%d partitions)", err, len(parts))
+	}
+	if parts[0].FileSystem != "LUKS" {
+		t.Fatalf("encrypted partition detected as %q, want LUKS", parts[0].FileSystem)
+	}
+	return img, parts[0]
+}
+
+func TestUnlockLUKS1(t *testing.T) {
+	plain := fixturePartition(t, "ext4-encase6-zlib.E01")
+	vol := ewffixture.LUKS{Version: 1, Passphrases: []string{"first secret", "second secret"}}.Build(plain)
+	img, part := openLUKS(t, vol)
+
+	info, err := img.LUKS(part)
+	if err != nil {
+		t.Fatalf("LUKS: %v", err)
+	}
+	if info.Version != 1 || info.Cipher != "aes-xts-plain64" || info.KeyBits != 512 || info.PayloadOffset != 2<<20 ||
+		len(info.Keyslots) != 2 || info.Keyslots[1].Index != 1 || info.Keyslots[1].KDF != "pbkdf2" {
+		t.Errorf("LUKS info = %+v", info)
+	}
+
+	for name, key := range map[string]LUKSKey{
+		"slot 0":     {Passphrase: "first secret"},
+		"slot 1":     {Passphrase: "second secret"},
+		"master key": {MasterKey: ewffixture.LUKSMasterKey(64)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			u, err := img.UnlockLUKS(part, key)
+			if err != nil {
+				t.Fatalf("UnlockLUKS: %v", err)
+			}
+			if !u.Virtual || u.FileSystem != "ext4" || u.SizeSectors != uint64(len(plain)/512) {
+				t.Errorf("unlocked partition = %+v", u)
+			}
+			got, err := u.volume.ReadSectors(0, u.SizeSectors)
+			if err != nil {
+				t.Fatalf("read decrypted volume: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("decrypted volume differs from the plaintext")
+			}
+			if data := readUnlocked(t, img, u, "/fixture.txt"); string(data) != "fixture\n" {
+				t.Errorf("fixture.txt = %q", data)
+			}
+		})
+	}
+
+	for name, key := range map[string]LUKSKey{
+		"passphrase":        {Passphrase: "First secret"},
+		"master key":        {MasterKey: bytes.Repeat([]byte{1}, 64)},
+		"master key length": {MasterKey: ewffixture.LUKSMasterKey(32)},
+	} {
+		if _, err := img.UnlockLUKS(part, key); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("%s: UnlockLUKS error = %v, want ErrWrongKey", name, err)
+		}
+	}
+	if _, err := img.UnlockLUKS(part, LUKSKey{}); err == nil || errors.Is(err, ErrWrongKey) {
+		t.Errorf("empty key: error = %v", err)
+	}
+	if _, err := img.OpenPartition(part); err == nil {
+		t.Error("OpenPartition opened the encrypted partition")
+	}
+}
+
+func TestUnlockLUKSVariants(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the key slot KDF of every variant")
+	}
+	ext4 := fixturePartition(t, "ext4-encase6-zlib.E01")
+	btrfs := fixturePartition(t, "btrfs-encase6-zlib.E01")
+	for _, tc := range []struct {
+		name  string
+		plain []byte
+		l     ewffixture.LUKS
+		fs    string
+	}{
+		{"luks1 cbc-essiv sha1", ext4, ewffixture.LUKS{Version: 1, Cipher: "aes-cbc-essiv:sha256", KeyBytes: 16, Hash: "sha1"}, "ext4"},
+		{"luks2 pbkdf2 xts", ext4, ewffixture.LUKS{}, "ext4"},
+		{"luks2 argon2id 4k sectors", btrfs, ewffixture.LUKS{KDF: "argon2id", SectorSize: 4096, Label: "evidence"}, "Btrfs"},
+		{"luks2 argon2i cbc-essiv", btrfs, ewffixture.LUKS{KDF: "argon2i", Cipher: "aes-cbc-essiv:sha256"}, "Btrfs"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.l.Passphrases = []string{"secret"}
+			img, part := openLUKS(t, tc.l.Build(tc.plain))
+			u, err := img.UnlockLUKS(part, LUKSKey{Passphrase: "secret"})
+			if err != nil {
+				t.Fatalf("UnlockLUKS: %v", err)
+			}
+			if u.FileSystem != tc.fs {
+				t.Errorf("unlocked filesystem = %q, want %q", u.FileSystem, tc.fs)
+			}
+			if data := readUnlocked(t, img, u, "/fixture.txt"); string(data) != "fixture\n" {
+				t.Errorf("fixture.txt = %q", data)
+			}
+			if _, err := img.UnlockLUKS(part, LUKSKey{Passphrase: "Secret"}); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("wrong passphrase: error = %v, want ErrWrongKey", err)
+			}
+			if tc.l.Label != "" {
+				if info, err := img.LUKS(part); err != nil || info.Label != tc.l.Label || info.SectorSize != tc.l.SectorSize {
+					t.Errorf("LUKS info = %+v, %v", info, err)
+				}
+			}
+		})
+	}
+}
+
+// A LUKS2 volume whose primary header is damaged opens from the secondary
+// header.
+func TestUnlockLUKS2SecondaryHeader(t *testing.T) {
+	plain := fixturePartition(t, "ext4-encase6-zlib.E01")
+	vol := ewffixture.LUKS{Passphrases: []string{"secret"}}.Build(plain)
+	vol[4096+10] ^= 0xFF // JSON area of the primary header
+	img, part := openLUKS(t, vol)
+	u, err := img.UnlockLUKS(part, LUKSKey{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("UnlockLUKS: %v", err)
+	}
+	if data := readUnlocked(t, img, u, "/fixture.txt"); string(data) != "fixture\n" {
+		t.Errorf("fixture.txt = %q", data)
+	}
+}
@@ -127,6 +127,12 @@ func (e *EWFImage) DetectPartitionType() string {
 }
 
 // PartitionInfo contains information about a detected partition.
+//
+// A virtual partition (Virtual true) is a logical volume assembled from other
+// sectors — a Windows dynamic-disk volume, for example. Its bytes are read
+// through the volume mapping rather than from StartSector onwards; StartSector
+// reports where its first sector lives on this image (0 when it lives on
+// another member disk). OpenPartition opens either kind.
 type PartitionInfo struct {
 	Index          int
 	StartSector    uint64
@@ -137,11 +143,34 @@ type PartitionInfo struct {
 	TypeName       string
 	FileSystem     string
 	FilesystemType filesystem.FileSystemType
+	Virtual        bool
+
+	// volume is the sector source of a virtual partition (LBA 0 = its first
+	// sector); nil for a partition read straight from the image.
+	volume filesystem.Reader
 }
 
 // ScanFileSystems scans the image for partitions and detects filesystems.
 // This is a simplified version that reads the MBR/GPT and detects filesystem types.
+//
+// The logical partitions of an MBR extended partition (type 0x05, 0x0F or
+// 0x85), found by following its EBR chain, follow the primary entries.
+//
+// On a Windows dynamic disk (an MBR type 0x42 entry or a GPT LDM partition)
+// the dynamic volumes assembled from this disk alone follow the declared
+// partitions as virtual partitions (see DynamicVolumes). The Volume Shadow
+// Copies of every NTFS volume come last (see ShadowCopies).
 func (e *EWFImage) ScanFileSystems() ([]PartitionInfo, error) {
+	partitions, err := e.scanPartitions()
+	if err != nil {
+		return nil, err
+	}
+	return e.appendShadowCopies(partitions), nil
+}
+
+// scanPartitions lists the declared partitions and the volumes assembled
+// from them.
+func (e *EWFImage) scanPartitions() ([]PartitionInfo, error) {
 	var partitions []PartitionInfo
 
 	// Try GPT first (check if GPT protective MBR exists)
@@ -216,54 +245,148 @@ func (e *EWFImage) ScanFileSystems() ([]PartitionInfo, error) {
 
 				// If we got GPT partitions, return them
 				if len(partitions) > 0 {
+					if hasLDMPartition(mbr, &gpt) {
+						partitions = appendVirtualPartitions(partitions, e.DynamicVolumes)
+					}
 					return partitions, nil
 				}
 			}
 		}
 
 		// Fall back to MBR parsing
-		for _, p := range mbr.PartitionTable {
-			if p.PartitionSize > 0 && p.PartitionType != 0x00 {
-				pi := PartitionInfo{
-					Index:       len(partitions),
-					StartSector: uint64(p.StartLBA),
-					SizeSectors: uint64(p.PartitionSize),
-					SizeBytes:   uint64(p.PartitionSize) * 512,
-					Type:        fmt.Sprintf("0x%02X", p.PartitionType),
-					TypeCode:    p.PartitionType,
-					TypeName:    getPartitionTypeName(p.PartitionType),
-					FileSystem:  "Unknown",
-				}
+		add := func(start, size uint64, typ uint8) {
+			pi := PartitionInfo{
+				Index:       len(partitions),
+				StartSector: start,
+				SizeSectors: size,
+				SizeBytes:   size * 512,
+				Type:        fmt.Sprintf("0x%02X", typ),
+				TypeCode:    typ,
+				TypeName:    getPartitionTypeName(typ),
+				FileSystem:  "Unknown",
+			}
 
-				// Try to detect filesystem in this partition
-				if p.PartitionSize > 10 {
-					// Read a window large enough for every signature we check,
-					// including the btrfs superblock magic at 0x10040 (64 KiB + 0x40).
-					// Clamp to the partition size so tiny partitions don't read past
-					// the end.
-					readSectors := uint64(129) // 66048 bytes
-					if readSectors > uint64(p.PartitionSize) {
-						readSectors = uint64(p.PartitionSize)
-					}
-					partSector, err := e.ReadSectors(uint64(p.StartLBA), readSectors)
-					if err == nil {
-						pi.FileSystem = DetectFileSystem(partSector)
-					}
+			// Try to detect filesystem in this partition
+			if size > 10 {
+				// Read a window large enough for every signature we check,
+				// including the btrfs superblock magic at 0x10040 (64 KiB + 0x40).
+				// Clamp to the partition size so tiny partitions don't read past
+				// the end.
+				readSectors := uint64(129) // 66048 bytes
+				if readSectors > size {
+					readSectors = size
 				}
-
-				// If still unknown, guess from partition type code
-				if pi.FileSystem == "Unknown" {
-					pi.FileSystem = GuessFileSystemFromPartitionType(p.PartitionType)
+				partSector, err := e.ReadSectors(start, readSectors)
+				if err == nil {
+					pi.FileSystem = DetectFileSystem(partSector)
 				}
+			}
+
+			// If still unknown, guess from partition type code
+			if pi.FileSystem == "Unknown" {
+				pi.FileSystem = GuessFileSystemFromPartitionType(typ)
+			}
 
-				partitions = append(partitions, pi)
+			partitions = append(partitions, pi)
+		}
+		var logical []logicalPartition
+		for _, p := range mbr.PartitionTable {
+			if p.PartitionSize > 0 && p.PartitionType != 0x00 {
+				add(uint64(p.StartLBA), uint64(p.PartitionSize), p.PartitionType)
+				if isExtendedType(p.PartitionType) {
+					// A broken chain still lists the logical partitions
+					// before the break; DiskLayout reports it.
+					found, _ := e.logicalPartitions(p)
+					logical = append(logical, found...)
+				}
 			}
 		}
+		for _, l := range logical {
+			add(l.Start, l.Size, l.Type)
+		}
+		if hasLDMPartition(mbr, nil) {
+			partitions = appendVirtualPartitions(partitions, e.DynamicVolumes)
+		}
 	}
 
 	return partitions, nil
 }
 
+// maxLogicalPartitions bounds the EBR chain of an extended partition.
+const maxLogicalPartitions = 128
+
+// isExtendedType reports whether an MBR partition type is an extended
+// partition: a container whose logical partitions are chained through
+// extended boot records (EBRs).
+func isExtendedType(t uint8) bool {
+	return t == 0x05 || t == 0x0F || t == 0x85
+}
+
+// logicalPartition is a logical partition declared in an EBR, with absolute
+// sectors.
+type logicalPartition struct {
+	EBR   uint64 // sector of the EBR declaring it
+	Start uint64
+	Size  uint64
+	Type  uint8
+}
+
+// logicalPartitions follows the EBR chain of the extended partition ext. An
+// EBR's first entry is a logical partition relative to the EBR, its second
+// the next EBR relative to the start of ext. The chain ends at an EBR
+// without a second entry; an unreadable EBR, a link outside ext or back to
+// an EBR already read, or more than maxLogicalPartitions EBRs end it with an
+// error, returned with the logical partitions found before it.
+func (e *EWFImage) logicalPartitions(ext internal.PartitionEntry) ([]logicalPartition, error) {
+	base, end := uint64(ext.StartLBA), uint64(ext.StartLBA)+uint64(ext.PartitionSize)
+	var out []logicalPartition
+	seen := make(map[uint64]bool)
+	for ebr := base; ; {
+		if seen[ebr] {
+			return out, fmt.Errorf("EBR chain loops back to sector %d", ebr)
+		}
+		if len(seen) == maxLogicalPartitions {
+			return out, fmt.Errorf("EBR chain longer than %d links", maxLogicalPartitions)
+		}
+		seen[ebr] = true
+		data, err := e.ReadSectors(ebr, 1)
+		if err != nil {
+			return out, fmt.Errorf("EBR at sector %d: %w", ebr, err)
+		}
+		var rec internal.MBR
+		if len(data) < 512 || binary.Read(bytes.NewReader(data[:512]), binary.LittleEndian, &rec) != nil || rec.BootSignature != 0xAA55 {
+			return out, fmt.Errorf("no EBR signature at sector %d", ebr)
+		}
+		if p := rec.PartitionTable[0]; p.PartitionType != 0 && p.PartitionSize > 0 {
+			out = append(out, logicalPartition{EBR: ebr, Start: ebr + uint64(p.StartLBA), Size: uint64(p.PartitionSize), Type: p.PartitionType})
+		}
+		next := rec.PartitionTable[1]
+		if next.PartitionType == 0 || next.StartLBA == 0 {
+			return out, nil
+		}
+		if ebr = base + uint64(next.StartLBA); ebr >= end {
+			return out, fmt.Errorf("EBR link to sector %d is outside the extended partition (sectors %d-%d)", ebr, base, end-1)
+		}
+	}
+}
+
+// appendVirtualPartitions appends the volumes a volume manager assembles from
+// this disk alone (dynamic volumes) to the declared partitions, continuing
+// their numbering. A database that cannot be read
+// leaves the declared partitions as they are: the container partition stays
+// listed, so the disk is never reported as empty.
+func appendVirtualPartitions(partitions []PartitionInfo, assemble func(...*EWFImage) ([]PartitionInfo, error)) []PartitionInfo {
+	vols, err := assemble()
+	if err != nil {
+		return partitions
+	}
+	for _, v := range vols {
+		v.Index = len(partitions)
+		partitions = append(partitions, v)
+	}
+	return partitions
+}
+
 // DetectFileSystem attempts to detect the filesystem type from boot sector data.
 // It delegates to the single source of truth in internal/filesystem so the two
 // copies can never drift apart (in particular, the btrfs probe at 0x10040).
@@ -291,6 +414,7 @@ func getPartitionTypeName(t byte) string {
 		0x1C: "Hidden FAT32",
 		0x1E: "Hidden FAT16 LBA",
 		0x27: "Windows RE",
+		0x42: "Windows LDM",
 		0x82: "Linux Swap",
 		0x83: "Linux",
 		0x8E: "Linux LVM",
@@ -318,6 +442,8 @@ func GuessFileSystemFromPartitionType(t byte) string {
 		return "NTFS"
 	case 0x83:
 		return "Unknown" // Could be ext2/3/4, XFS, btrfs, etc.
+	case 0x42:
+		return "LDM"
 	case 0x8E:
 		return "LVM"
 	case 0x82:
@@ -0,0 +1,100 @@
+package ewf
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+	"github.com/laenix/ewfgo/internal/filesystem/ntfs"
+)
+
+// ReparsePoint is a decoded NTFS reparse point. SubstituteName and
+// PrintName are a link's stored names in Windows form, "\??\C:\Users" and
+// "C:\Users". Target is its destination as a path: for an absolute link on
+// the volume of drive letter Drive, "/Users" on "C", for a relative one on
+// this volume, and "" when it names a volume by GUID, a network share or a
+// Linux path.
+type ReparsePoint struct {
+	Tag            uint32
+	GUID           string // owner of a third-party tag
+	SubstituteName string
+	PrintName      string
+	Relative       bool // the link is relative to its directory
+	Drive          string
+	Target         string
+	Data           []byte // the tag-specific data as stored
+}
+
+// TagName names the reparse point's tag, IO_REPARSE_TAG_SYMLINK as
+// "SYMLINK"; an unknown tag is given in hex.
+func (r *ReparsePoint) TagName() string {
+	return ntfs.TagName(r.Tag)
+}
+
+// Reparse decodes the reparse point of the file or directory at path: a
+// symbolic link or junction with its names and target, or the tag and data
+// of any other kind. Reading a link returns its PrintName; reading a
+// cloud-files, deduplication or HSM placeholder whose content is not on the
+// volume fails with ErrPlaceholder. A file without a reparse point is
+// ErrNotFound; filesystems without reparse points return ErrUnsupported.
+func (fs *ImageFS) Reparse(filePath string) (*ReparsePoint, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	reader, ok := fs.fs.(filesystem.ReparseReader)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no reparse points: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	rp, err := reader.Reparse(normalizeInternalPath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: reparse point of %q: %w", fs.part.Index, filePath, err)
+	}
+	return &ReparsePoint{
+		Tag:            rp.Tag,
+		GUID:           rp.GUID,
+		SubstituteName: rp.SubstituteName,
+		PrintName:      rp.PrintName,
+		Relative:       rp.Relative,
+		Drive:          rp.Drive,
+		Target:         rp.Target,
+		Data:           rp.Data,
+	}, nil
+}
+
+// FollowLinks returns a view of this filesystem whose paths resolve
+// through NTFS symbolic links, junctions and WSL symlinks to their targets
+// on the volume. Links are followed at every path component; ListDir
+// follows one at the final component too, while ReadFile of a link still
+// returns the link itself. drive is the letter the volume was mounted as,
+// "C" or "C:"; an absolute link to another drive letter, or to any when
+// drive is "", is off the volume. A lookup fails after 40 links or on a
+// loop, and through a link whose target is off the volume with
+// ErrNotFound. Closing the view leaves this ImageFS open. Filesystems that
+// follow their links already, or have none, return ErrUnsupported.
+func (fs *ImageFS) FollowLinks(drive string) (*ImageFS, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	follower, ok := fs.fs.(filesystem.LinkFollower)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: link following not supported for %s: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	h, err := follower.FollowLinks(drive)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: link-following view: %w", fs.part.Index, err)
+	}
+	return &ImageFS{
+		img:        fs.img,
+		part:       fs.part,
+		fs:         h,
+		sectorSize: fs.sectorSize,
+		fsType:     fs.fsType,
+		src:        fs.src,
+		base:       fs.base,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package ewf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestImageFSReparse: mkntfs writes no reparse points, so fixture.txt has
+// none and a FollowLinks view reads like the volume itself; FAT has no
+// reparse points at all.
+func TestImageFSReparse(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "ntfs-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer fs.Close()
+
+	if _, err := fs.Reparse("/fixture.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Reparse(fixture.txt) = %v, want ErrNotFound", err)
+	}
+	view, err := fs.FollowLinks("C")
+	if err != nil {
+		t.Fatalf("FollowLinks: %v", err)
+	}
+	defer view.Close()
+	want, err := fs.ReadFile("/fixture.txt")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if got, err := view.ReadFile("/fixture.txt"); err != nil || string(got) != string(want) {
+		t.Errorf("FollowLinks ReadFile(fixture.txt) = %q, %v, want %q", got, err, want)
+	}
+
+	fat, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fat.Close()
+	ffs, err := fat.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer ffs.Close()
+	if _, err := ffs.Reparse("/"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT Reparse = %v, want ErrUnsupported", err)
+	}
+	if _, err := ffs.FollowLinks(""); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT FollowLinks = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestReparsePointTagName(t *testing.T) {
+	for tag, want := range map[uint32]string{
+		0xA000000C: "SYMLINK",
+		0xA0000003: "MOUNT_POINT",
+		0x9000601A: "CLOUD_6",
+		0x80000013: "DEDUP",
+		0x0000BEEF: "0x0000BEEF",
+	} {
+		if got := (&ReparsePoint{Tag: tag}).TagName(); got != want {
+			t.Errorf("TagName(%#x) = %q, want %q", tag, got, want)
+		}
+	}
+}
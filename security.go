@@ -0,0 +1,286 @@
+package ewf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// SID is a Windows security identifier in string form, "S-1-5-32-544".
+type SID string
+
+// wellKnownSIDs names the fixed SIDs Windows volumes commonly carry.
+var wellKnownSIDs = map[SID]string{
+	"S-1-0-0":      "Nobody",
+	"S-1-1-0":      "Everyone",
+	"S-1-2-0":      "Local",
+	"S-1-3-0":      "Creator Owner",
+	"S-1-3-1":      "Creator Group",
+	"S-1-3-4":      "Owner Rights",
+	"S-1-5-1":      "Dialup",
+	"S-1-5-2":      "Network",
+	"S-1-5-3":      "Batch",
+	"S-1-5-4":      "Interactive",
+	"S-1-5-6":      "Service",
+	"S-1-5-7":      "Anonymous Logon",
+	"S-1-5-9":      "Enterprise Domain Controllers",
+	"S-1-5-10":     "Principal Self",
+	"S-1-5-11":     "Authenticated Users",
+	"S-1-5-12":     "Restricted Code",
+	"S-1-5-13":     "Terminal Server Users",
+	"S-1-5-18":     "Local System",
+	"S-1-5-19":     "Local Service",
+	"S-1-5-20":     "Network Service",
+	"S-1-5-32-544": "Administrators",
+	"S-1-5-32-545": "Users",
+	"S-1-5-32-546": "Guests",
+	"S-1-5-32-547": "Power Users",
+	"S-1-5-32-548": "Account Operators",
+	"S-1-5-32-549": "Server Operators",
+	"S-1-5-32-550": "Print Operators",
+	"S-1-5-32-551": "Backup Operators",
+	"S-1-5-32-552": "Replicator",
+	"S-1-5-32-555": "Remote Desktop Users",
+	"S-1-5-32-568": "IIS_IUSRS",
+	"S-1-5-80-0":   "All Services",
+	"S-1-15-2-1":   "All Application Packages",
+	"S-1-15-2-2":   "All Restricted Application Packages",
+	"S-1-16-4096":  "Low Mandatory Level",
+	"S-1-16-8192":  "Medium Mandatory Level",
+	"S-1-16-8448":  "Medium Plus Mandatory Level",
+	"S-1-16-12288": "High Mandatory Level",
+	"S-1-16-16384": "System Mandatory Level",
+	"S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464": "TrustedInstaller",
+}
+
+// domainRIDs names the well-known relative ids of domain and machine
+// accounts, S-1-5-21-<domain>-<rid>.
+var domainRIDs = map[string]string{
+	"500": "Administrator",
+	"501": "Guest",
+	"503": "DefaultAccount",
+	"504": "WDAGUtilityAccount",
+	"512": "Domain Admins",
+	"513": "Domain Users",
+	"514": "Domain Guests",
+	"515": "Domain Computers",
+	"516": "Domain Controllers",
+	"519": "Enterprise Admins",
+}
+
+// Name names a well-known SID, "Administrators", or the well-known account
+// of a domain or machine SID, "Administrator"; it is "" for other SIDs,
+// whose names live in the SAM or a directory rather than on the volume.
+func (s SID) Name() string {
+	if n, ok := wellKnownSIDs[s]; ok {
+		return n
+	}
+	if rest, ok := strings.CutPrefix(string(s), "S-1-5-21-"); ok {
+		if parts := strings.Split(rest, "-"); len(parts) == 4 {
+			return domainRIDs[parts[3]]
+		}
+	}
+	return ""
+}
+
+// SecurityDescriptor is the Windows security descriptor of a file: its
+// owner and group, the DACL saying who may access it and the SACL saying
+// what is audited. DACL is nil when the descriptor has none; with
+// SE_DACL_PRESENT (0x0004) set in Control that is a NULL DACL, granting
+// everyone full access.
+type SecurityDescriptor struct {
+	// SecurityID is the descriptor's id in the volume's $Secure store; 0
+	// when the file carries its own $SECURITY_DESCRIPTOR.
+	SecurityID uint32
+	Control    uint16 // SE_* control flags
+	Owner      SID    // "" when absent
+	Group      SID    // "" when absent
+	DACL       *ACL
+	SACL       *ACL
+	Raw        []byte // the self-relative descriptor as stored
+}
+
+// ACL is an access control list, its entries in stored (evaluation) order.
+type ACL struct {
+	Revision int
+	ACEs     []ACE
+}
+
+// ACE is one access control entry. Object ACEs also carry ObjectFlags and
+// the object-type GUIDs those flags announce. SID is "" for the rare ACE
+// types whose layout is not decoded.
+type ACE struct {
+	Type                uint8 // named by TypeName
+	Flags               uint8 // named by FlagNames
+	Mask                uint32
+	SID                 SID
+	ObjectFlags         uint32
+	ObjectType          string
+	InheritedObjectType string
+}
+
+// aceTypes names the ACE types by value.
+var aceTypes = []string{
+	"ACCESS_ALLOWED",
+	"ACCESS_DENIED",
+	"SYSTEM_AUDIT",
+	"SYSTEM_ALARM",
+	"ACCESS_ALLOWED_COMPOUND",
+	"ACCESS_ALLOWED_OBJECT",
+	"ACCESS_DENIED_OBJECT",
+	"SYSTEM_AUDIT_OBJECT",
+	"SYSTEM_ALARM_OBJECT",
+	"ACCESS_ALLOWED_CALLBACK",
+	"ACCESS_DENIED_CALLBACK",
+	"ACCESS_ALLOWED_CALLBACK_OBJECT",
+	"ACCESS_DENIED_CALLBACK_OBJECT",
+	"SYSTEM_AUDIT_CALLBACK",
+	"SYSTEM_ALARM_CALLBACK",
+	"SYSTEM_AUDIT_CALLBACK_OBJECT",
+	"SYSTEM_ALARM_CALLBACK_OBJECT",
+	"SYSTEM_MANDATORY_LABEL",
+	"SYSTEM_RESOURCE_ATTRIBUTE",
+	"SYSTEM_SCOPED_POLICY_ID",
+}
+
+// TypeName names the ACE type, "ACCESS_ALLOWED".
+func (a ACE) TypeName() string {
+	if int(a.Type) < len(aceTypes) {
+		return aceTypes[a.Type]
+	}
+	return fmt.Sprintf("%#x", a.Type)
+}
+
+// aceFlags names the ACE header flags in bit order.
+var aceFlags = []bitName{
+	{0x01, "OBJECT_INHERIT"},
+	{0x02, "CONTAINER_INHERIT"},
+	{0x04, "NO_PROPAGATE_INHERIT"},
+	{0x08, "INHERIT_ONLY"},
+	{0x10, "INHERITED"},
+	{0x40, "SUCCESSFUL_ACCESS"},
+	{0x80, "FAILED_ACCESS"},
+}
+
+// FlagNames names the ACE's inheritance and audit flags,
+// "OBJECT_INHERIT|CONTAINER_INHERIT".
+func (a ACE) FlagNames() string {
+	return bitNames(uint32(a.Flags), aceFlags)
+}
+
+// fileRightSets names the composite file access masks Windows shows in its
+// security dialog.
+var fileRightSets = map[uint32]string{
+	0x001F01FF: "FullControl",
+	0x001301BF: "Modify",
+	0x001200A9: "ReadAndExecute",
+	0x00120089: "Read",
+	0x00100116: "Write",
+}
+
+// fileRights names the file and directory access mask bits in bit order.
+var fileRights = []bitName{
+	{0x00000001, "READ_DATA"},
+	{0x00000002, "WRITE_DATA"},
+	{0x00000004, "APPEND_DATA"},
+	{0x00000008, "READ_EA"},
+	{0x00000010, "WRITE_EA"},
+	{0x00000020, "EXECUTE"},
+	{0x00000040, "DELETE_CHILD"},
+	{0x00000080, "READ_ATTRIBUTES"},
+	{0x00000100, "WRITE_ATTRIBUTES"},
+	{0x00010000, "DELETE"},
+	{0x00020000, "READ_CONTROL"},
+	{0x00040000, "WRITE_DAC"},
+	{0x00080000, "WRITE_OWNER"},
+	{0x00100000, "SYNCHRONIZE"},
+	{0x01000000, "ACCESS_SYSTEM_SECURITY"},
+	{0x10000000, "GENERIC_ALL"},
+	{0x20000000, "GENERIC_EXECUTE"},
+	{0x40000000, "GENERIC_WRITE"},
+	{0x80000000, "GENERIC_READ"},
+}
+
+// Rights names the ACE's access mask: a composite such as "FullControl" or
+// "ReadAndExecute" when it is exactly one, else its bits,
+// "READ_DATA|SYNCHRONIZE". Bits without a name are kept as a hex remainder.
+func (a ACE) Rights() string {
+	if n, ok := fileRightSets[a.Mask]; ok {
+		return n
+	}
+	return bitNames(a.Mask, fileRights)
+}
+
+// bitName names one flag bit.
+type bitName struct {
+	bit  uint32
+	name string
+}
+
+// bitNames joins the names of the bits set in v with "|", appending any
+// unnamed remainder in hex.
+func bitNames(v uint32, names []bitName) string {
+	var out []string
+	rest := v
+	for _, f := range names {
+		if v&f.bit != 0 {
+			out = append(out, f.name)
+			rest &^= f.bit
+		}
+	}
+	if rest != 0 {
+		out = append(out, fmt.Sprintf("%#x", rest))
+	}
+	return strings.Join(out, "|")
+}
+
+// convertACL copies an internal ACL; nil stays nil.
+func convertACL(acl *filesystem.ACL) *ACL {
+	if acl == nil {
+		return nil
+	}
+	out := &ACL{Revision: int(acl.Revision), ACEs: make([]ACE, len(acl.ACEs))}
+	for i, a := range acl.ACEs {
+		out.ACEs[i] = ACE{
+			Type:                a.Type,
+			Flags:               a.Flags,
+			Mask:                a.Mask,
+			SID:                 SID(a.SID),
+			ObjectFlags:         a.ObjectFlags,
+			ObjectType:          a.ObjectType,
+			InheritedObjectType: a.InheritedObjectType,
+		}
+	}
+	return out
+}
+
+// Security returns the security descriptor of the file or directory at
+// path, from the volume's shared $Secure store or the file's own legacy
+// $SECURITY_DESCRIPTOR. A file without one returns ErrNotFound;
+// filesystems other than NTFS ErrUnsupported.
+func (fs *ImageFS) Security(filePath string) (*SecurityDescriptor, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	reader, ok := fs.fs.(filesystem.SecurityReader)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no security descriptors: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	sd, err := reader.Security(normalizeInternalPath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: security of %q: %w", fs.part.Index, filePath, err)
+	}
+	return &SecurityDescriptor{
+		SecurityID: sd.SecurityID,
+		Control:    sd.Control,
+		Owner:      SID(sd.Owner),
+		Group:      SID(sd.Group),
+		DACL:       convertACL(sd.DACL),
+		SACL:       convertACL(sd.SACL),
+		Raw:        sd.Raw,
+	}, nil
+}
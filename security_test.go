@@ -0,0 +1,85 @@
+package ewf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestImageFSSecurity: mkntfs gives the root and fixture.txt their own
+// $SECURITY_DESCRIPTOR and $Secure a descriptor from its own $SDS; FAT
+// has none.
+func TestImageFSSecurity(t *testing.T) {
+	img, err := Open(filepath.Join("testdata", "e01", "ntfs-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer img.Close()
+	fs, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer fs.Close()
+
+	root, err := fs.Security("/")
+	if err != nil {
+		t.Fatalf("Security(/): %v", err)
+	}
+	if root.SecurityID != 0 || root.Owner.Name() != "Local System" || root.DACL == nil || len(root.DACL.ACEs) != 8 {
+		t.Errorf("root = id %d owner %s %+v", root.SecurityID, root.Owner, root.DACL)
+	} else if a := root.DACL.ACEs[0]; a.SID.Name() != "Administrators" || a.TypeName() != "ACCESS_ALLOWED" || a.Rights() != "FullControl" {
+		t.Errorf("root ACE 0 = %s %s %s", a.SID, a.TypeName(), a.Rights())
+	}
+	f, err := fs.Security("/fixture.txt")
+	if err != nil {
+		t.Fatalf("Security(fixture.txt): %v", err)
+	}
+	if f.Owner != "S-1-5-32-544" || len(f.DACL.ACEs) != 1 || f.DACL.ACEs[0].SID.Name() != "Everyone" {
+		t.Errorf("fixture.txt = owner %s %+v", f.Owner, f.DACL)
+	}
+	if s, err := fs.Security("/$Secure"); err != nil || s.SecurityID != 0x101 {
+		t.Errorf("Security($Secure) = %+v, %v", s, err)
+	}
+	if _, err := fs.Security("/$MFT"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Security($MFT) = %v, want ErrNotFound", err)
+	}
+
+	fat, err := Open(filepath.Join("testdata", "e01", "fat16-encase6-zlib.E01"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fat.Close()
+	ffs, err := fat.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem: %v", err)
+	}
+	defer ffs.Close()
+	if _, err := ffs.Security("/"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FAT Security = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestSecurityNames(t *testing.T) {
+	for sid, want := range map[SID]string{
+		"S-1-5-32-544":         "Administrators",
+		"S-1-5-21-1-2-3-500":   "Administrator",
+		"S-1-5-21-1-2-3-1001":  "",
+		"S-1-5-21-1-2-500":     "",
+		"S-1-5-80-0":           "All Services",
+		"S-1-0x123456789ABC-1": "",
+	} {
+		if got := sid.Name(); got != want {
+			t.Errorf("%s.Name() = %q, want %q", sid, got, want)
+		}
+	}
+	a := ACE{Type: 0x11, Flags: 0x13, Mask: 0x00120089}
+	if a.TypeName() != "SYSTEM_MANDATORY_LABEL" || a.FlagNames() != "OBJECT_INHERIT|CONTAINER_INHERIT|INHERITED" || a.Rights() != "Read" {
+		t.Errorf("ACE names = %s %s %s", a.TypeName(), a.FlagNames(), a.Rights())
+	}
+	if got := (ACE{Type: 0x30, Mask: 0x00100021 | 0x200}).Rights(); got != "READ_DATA|EXECUTE|SYNCHRONIZE|0x200" {
+		t.Errorf("Rights = %q", got)
+	}
+	if got := (ACE{Type: 0x30}).TypeName(); got != "0x30" {
+		t.Errorf("TypeName = %q", got)
+	}
+}
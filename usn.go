@@ -0,0 +1,135 @@
+package ewf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/laenix/ewfgo/internal/filesystem"
+)
+
+// USNRecord is one entry of the NTFS update sequence number change journal
+// (USN_RECORD_V2, V3 or V4). FileRef and ParentRef are MFT references: the
+// record number in the low 48 bits, its sequence number in the high 16.
+type USNRecord struct {
+	Offset         int64 // byte offset in $UsnJrnl:$J
+	MajorVersion   int
+	MinorVersion   int
+	USN            int64
+	FileRef        uint64
+	ParentRef      uint64
+	Time           time.Time // UTC; zero for V4 records, which carry none
+	Reason         uint32    // USN_REASON_* flags, named by Reasons
+	SourceInfo     uint32
+	SecurityID     uint32
+	FileAttributes uint32
+	Name           string // file name; empty for V4 records
+	// Path is the full path through the MFT as it stands in the image: Name
+	// under its parent directory's current path, or for a V4 record the
+	// file's own path. It is empty when the MFT record was since reused.
+	Path    string
+	Extents []USNExtent // changed ranges of a V4 range-tracking record
+}
+
+// USNExtent is one changed byte range of a V4 record.
+type USNExtent struct {
+	Offset int64
+	Length int64
+}
+
+// usnReasons names the USN_REASON_* flags in bit order.
+var usnReasons = []bitName{
+	{0x00000001, "DATA_OVERWRITE"},
+	{0x00000002, "DATA_EXTEND"},
+	{0x00000004, "DATA_TRUNCATION"},
+	{0x00000010, "NAMED_DATA_OVERWRITE"},
+	{0x00000020, "NAMED_DATA_EXTEND"},
+	{0x00000040, "NAMED_DATA_TRUNCATION"},
+	{0x00000100, "FILE_CREATE"},
+	{0x00000200, "FILE_DELETE"},
+	{0x00000400, "EA_CHANGE"},
+	{0x00000800, "SECURITY_CHANGE"},
+	{0x00001000, "RENAME_OLD_NAME"},
+	{0x00002000, "RENAME_NEW_NAME"},
+	{0x00004000, "INDEXABLE_CHANGE"},
+	{0x00008000, "BASIC_INFO_CHANGE"},
+	{0x00010000, "HARD_LINK_CHANGE"},
+	{0x00020000, "COMPRESSION_CHANGE"},
+	{0x00040000, "ENCRYPTION_CHANGE"},
+	{0x00080000, "OBJECT_ID_CHANGE"},
+	{0x00100000, "REPARSE_POINT_CHANGE"},
+	{0x00200000, "STREAM_CHANGE"},
+	{0x00400000, "TRANSACTED_CHANGE"},
+	{0x00800000, "INTEGRITY_CHANGE"},
+	{0x01000000, "DESIRED_STORAGE_CLASS_CHANGE"},
+	{0x80000000, "CLOSE"},
+}
+
+// Reasons names the record's reason flags, "FILE_CREATE|CLOSE"; bits
+// without a name are kept as a hex remainder.
+func (r USNRecord) Reasons() string {
+	return bitNames(r.Reason, usnReasons)
+}
+
+// USNJournal streams the records of a change journal (see
+// ImageFS.OpenUSNJournal).
+type USNJournal struct {
+	j filesystem.USNJournal
+}
+
+// Next returns the next record in journal order, and io.EOF after the last.
+// A record that cannot be read or parsed is returned as an error naming its
+// offset; calling Next again carries on past it, so a scan can report the
+// damage and keep going.
+func (j *USNJournal) Next() (USNRecord, error) {
+	r, err := j.j.Next()
+	if err != nil {
+		return USNRecord{}, err
+	}
+	out := USNRecord{
+		Offset:         r.Offset,
+		MajorVersion:   int(r.MajorVersion),
+		MinorVersion:   int(r.MinorVersion),
+		USN:            r.USN,
+		FileRef:        r.FileRef,
+		ParentRef:      r.ParentRef,
+		Reason:         r.Reason,
+		SourceInfo:     r.SourceInfo,
+		SecurityID:     r.SecurityID,
+		FileAttributes: r.FileAttributes,
+		Name:           r.Name,
+		Path:           r.Path,
+		Time:           filetime(r.Timestamp),
+	}
+	for _, e := range r.Extents {
+		out.Extents = append(out.Extents, USNExtent{Offset: e.Offset, Length: e.Length})
+	}
+	return out, nil
+}
+
+// Close releases the journal.
+func (j *USNJournal) Close() error {
+	return j.j.Close()
+}
+
+// OpenUSNJournal opens the NTFS change journal, $Extend\$UsnJrnl:$J, for a
+// forward scan. The journal's deallocated front is skipped without reading
+// it, so the scan costs only the live records. Like OpenFile's readers, the
+// journal reads independently of this ImageFS's mutex. A volume without a
+// journal returns ErrNotFound; filesystems other than NTFS ErrUnsupported.
+func (fs *ImageFS) OpenUSNJournal() (*USNJournal, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.img == nil {
+		return nil, fmt.Errorf("filesystem closed")
+	}
+	opener, ok := fs.fs.(filesystem.USNJournalOpener)
+	if !ok {
+		return nil, fmt.Errorf("partition %d: %s has no USN journal: %w", fs.part.Index, fs.fsType, filesystem.ErrUnsupported)
+	}
+	j, err := opener.OpenUSNJournal()
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: open USN journal: %w", fs.part.Index, err)
+	}
+	return &USNJournal{j: j}, nil
+}
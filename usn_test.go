@@ -0,0 +1,46 @@
+package ewf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestImageFSOpenUSNJournal: the mkntfs fixture volume has no change journal,
+// which is ErrNotFound; FAT has none to have, which is ErrUnsupported.
+func TestImageFSOpenUSNJournal(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		want    error
+	}{
+		{"ntfs-encase6-zlib.E01", ErrNotFound},
+		{"fat16-encase6-zlib.E01", ErrUnsupported},
+	} {
+		img, err := Open(filepath.Join("testdata", "e01", tc.fixture))
+		if err != nil {
+			t.Fatalf("Open(%s): %v", tc.fixture, err)
+		}
+		fs, err := img.OpenFileSystem(0)
+		if err != nil {
+			t.Fatalf("OpenFileSystem(%s): %v", tc.fixture, err)
+		}
+		if _, err := fs.OpenUSNJournal(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: OpenUSNJournal = %v, want %v", tc.fixture, err, tc.want)
+		}
+		fs.Close()
+		img.Close()
+	}
+}
+
+func TestUSNRecordReasons(t *testing.T) {
+	for reason, want := range map[uint32]string{
+		0:          "",
+		0x80000100: "FILE_CREATE|CLOSE",
+		0x00003002: "DATA_EXTEND|RENAME_OLD_NAME|RENAME_NEW_NAME",
+		0x40000001: "DATA_OVERWRITE|0x40000000",
+	} {
+		if got := (USNRecord{Reason: reason}).Reasons(); got != want {
+			t.Errorf("Reasons(%#x) = %q, want %q", reason, got, want)
+		}
+	}
+}
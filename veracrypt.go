@@ -0,0 +1,122 @@
+package ewf
+
+import (
+	"fmt"
+
+	"github.com/laenix/ewfgo/internal/veracrypt"
+)
+
+// VeraCryptKey is the key material for UnlockVeraCrypt and
+// UnlockVeraCryptFile.
+type VeraCryptKey struct {
+	Password string
+	// PIM is the Personal Iterations Multiplier the volume was created
+	// with; 0 means none (the default iteration counts).
+	PIM int
+	// Keyfiles are the contents of the volume's keyfiles, if any.
+	Keyfiles [][]byte
+	// PRF limits the trial to one header key derivation PRF: "sha512",
+	// "sha256", "blake2s", "whirlpool" or "ripemd160". Each PRF costs
+	// hundreds of thousands of iterations, so naming the right one makes a
+	// wrong password fail much sooner. Empty tries them all.
+	PRF string
+	// TrueCrypt opens a TrueCrypt volume (VeraCrypt's TrueCrypt mode).
+	TrueCrypt bool
+	// BackupHeader also tries the backup headers at the end of the volume,
+	// for a volume whose primary headers are damaged.
+	BackupHeader bool
+}
+
+func (k VeraCryptKey) internal() veracrypt.Key {
+	return veracrypt.Key{
+		Password:     []byte(k.Password),
+		PIM:          k.PIM,
+		Keyfiles:     k.Keyfiles,
+		PRF:          k.PRF,
+		TrueCrypt:    k.TrueCrypt,
+		BackupHeader: k.BackupHeader,
+	}
+}
+
+// UnlockVeraCrypt decrypts the header of a VeraCrypt or TrueCrypt partition
+// with key, trying every PRF and encryption algorithm (AES, Serpent,
+// Twofish, Camellia and their cascades) as VeraCrypt does, and returns the
+// decrypted volume as a virtual partition that OpenPartition opens with the
+// matching handler. An encrypted partition carries no signature, so it is
+// whatever ScanFileSystems reported, typically "Unknown".
+//
+// The password selects the volume: the outer volume's password opens the
+// outer volume, a hidden volume's password the hidden volume inside it.
+// TypeName records which, with the algorithm and PRF. Key material that
+// decrypts no header fails with ErrWrongKey. Every read of the returned
+// partition decrypts on the fly.
+func (e *EWFImage) UnlockVeraCrypt(part PartitionInfo, key VeraCryptKey) (PartitionInfo, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return PartitionInfo{}, err
+	}
+	v, err := veracrypt.Open(src, start, part.SizeSectors, key.internal())
+	if err != nil {
+		return PartitionInfo{}, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	pi := veraCryptPartition(v)
+	pi.Index = part.Index
+	pi.StartSector = part.StartSector + v.Header().EncryptedAreaStart/512
+	pi.TypeCode = part.TypeCode
+	return pi, nil
+}
+
+// UnlockVeraCryptFile unlocks a VeraCrypt or TrueCrypt file container stored
+// in this filesystem, as UnlockVeraCrypt does for a partition, and opens
+// the filesystem inside it.
+//
+// The container is read through this filesystem's streaming OpenFile
+// reader, so this ImageFS must stay open while the returned one is in use.
+// The decrypted volume is a virtual partition (Index -1) whose TypeName
+// names the container file.
+func (fs *ImageFS) UnlockVeraCryptFile(filePath string, key VeraCryptKey) (*ImageFS, error) {
+	img, rc, vol, err := fs.openFileVolume(filePath)
+	if err != nil {
+		return nil, err
+	}
+	v, err := veracrypt.Open(vol, 0, uint64(vol.Size())/512, key.internal())
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("file %q: %w", filePath, err)
+	}
+	part := veraCryptPartition(v)
+	part.Index = -1
+	part.TypeName += " in " + filePath
+	if part.FileSystem == "Unknown" {
+		rc.Close()
+		return nil, fmt.Errorf("no filesystem detected in %s", part.TypeName)
+	}
+	nested, err := img.openPartition(part, fmt.Sprintf("file %q", filePath))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	nested.file = rc
+	return nested, nil
+}
+
+// veraCryptPartition describes the decrypted volume v.
+func veraCryptPartition(v *veracrypt.Volume) PartitionInfo {
+	h := v.Header()
+	kind, name := "VeraCrypt", "volume"
+	if h.TrueCrypt {
+		kind = "TrueCrypt"
+	}
+	if h.Hidden {
+		name = "hidden volume"
+	}
+	return PartitionInfo{
+		SizeSectors: v.Sectors(),
+		SizeBytes:   v.Sectors() * 512,
+		Type:        kind,
+		TypeName:    fmt.Sprintf("%s %s %s %s", kind, name, h.Algorithm, h.PRF),
+		FileSystem:  detectVolumeFileSystem(v),
+		Virtual:     true,
+		volume:      v,
+	}
+}
@@ -0,0 +1,198 @@
+package ewf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// openVeraCrypt wraps a VeraCrypt volume into an MBR disk image and returns
+// the image and its partition, which no detector recognises.
+func openVeraCrypt(t *testing.T, vol []byte) (*EWFImage, PartitionInfo) {
+	t.Helper()
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(vol, 0x83, 2048), ewffixture.Options{Compress: ewffixture.CompressNone, ShortFinalChunk: true}))
+	parts, err := img.ScanFileSystems()
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("ScanFileSystems: %v (%d partitions)", err, len(parts))
+	}
+	if parts[0].FileSystem != "Unknown" {
+		t.Fatalf("encrypted partition detected as %q", parts[0].FileSystem)
+	}
+	return img, parts[0]
+}
+
+func TestUnlockVeraCrypt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("derives header keys under every PRF")
+	}
+	outer := fixturePartition(t, "fat16-encase6-zlib.E01")
+	hidden := fixturePartition(t, "exfat-encase6-zlib.E01")
+	vol := ewffixture.VeraCrypt{
+		Password: "outer secret",
+		PIM:      1,
+		Hidden:   &ewffixture.VeraCrypt{Password: "hidden secret", PIM: 2, Algorithm: "Twofish-Serpent", PRF: "whirlpool"},
+	}.Build(outer, hidden)
+	img, part := openVeraCrypt(t, vol)
+
+	for _, tc := range []struct {
+		name, password, fs, typeName, path string
+		pim                                int
+		plain                              []byte
+	}{
+		{"outer", "outer secret", "FAT16", "VeraCrypt volume AES sha512", "/FIXTURE.TXT", 1, outer},
+		{"hidden", "hidden secret", "exFAT", "VeraCrypt hidden volume Twofish-Serpent whirlpool", "/fixture.txt", 2, hidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := img.UnlockVeraCrypt(part, VeraCryptKey{Password: tc.password, PIM: tc.pim})
+			if err != nil {
+				t.Fatalf("UnlockVeraCrypt: %v", err)
+			}
+			if !u.Virtual || u.FileSystem != tc.fs || u.TypeName != tc.typeName {
+				t.Errorf("unlocked partition = %+v", u)
+			}
+			got, err := u.volume.ReadSectors(0, 2048)
+			if err != nil {
+				t.Fatalf("read decrypted volume: %v", err)
+			}
+			if !bytes.Equal(got, tc.plain[:len(got)]) {
+				t.Fatalf("decrypted volume differs from the plaintext")
+			}
+			if data := readUnlocked(t, img, u, tc.path); string(data) != "fixture\n" {
+				t.Errorf("%s = %q", tc.path, data)
+			}
+		})
+	}
+
+	for name, key := range map[string]VeraCryptKey{
+		"password": {Password: "Outer secret", PIM: 1, PRF: "sha512"},
+		"PIM":      {Password: "outer secret", PIM: 3, PRF: "sha512"},
+		"PRF":      {Password: "outer secret", PIM: 1, PRF: "sha256"},
+	} {
+		if _, err := img.UnlockVeraCrypt(part, key); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("wrong %s: UnlockVeraCrypt error = %v, want ErrWrongKey", name, err)
+		}
+	}
+	if _, err := img.UnlockVeraCrypt(part, VeraCryptKey{}); err == nil || errors.Is(err, ErrWrongKey) {
+		t.Errorf("empty key: error = %v", err)
+	}
+	if _, err := img.UnlockVeraCrypt(part, VeraCryptKey{Password: "outer secret", PRF: "streebog"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Streebog: error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestUnlockVeraCryptVariants(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and unlocks a volume per algorithm and PRF")
+	}
+	plain := fixturePartition(t, "fat16-encase6-zlib.E01")
+	keyfiles := [][]byte{[]byte("first keyfile"), bytes.Repeat([]byte{0xA5}, 3000)}
+	for _, tc := range []struct {
+		name string
+		v    ewffixture.VeraCrypt
+		key  VeraCryptKey
+		want string
+	}{
+		{"serpent blake2s", ewffixture.VeraCrypt{Algorithm: "Serpent", PRF: "blake2s"}, VeraCryptKey{}, "VeraCrypt volume Serpent blake2s"},
+		{"camellia-serpent ripemd160", ewffixture.VeraCrypt{Algorithm: "Camellia-Serpent", PRF: "ripemd160"}, VeraCryptKey{PRF: "ripemd160"}, "VeraCrypt volume Camellia-Serpent ripemd160"},
+		{"aes-twofish-serpent sha256", ewffixture.VeraCrypt{Algorithm: "AES-Twofish-Serpent", PRF: "sha256"}, VeraCryptKey{}, "VeraCrypt volume AES-Twofish-Serpent sha256"},
+		{"serpent-twofish-aes", ewffixture.VeraCrypt{Algorithm: "Serpent-Twofish-AES"}, VeraCryptKey{}, "VeraCrypt volume Serpent-Twofish-AES sha512"},
+		{"keyfiles", ewffixture.VeraCrypt{Keyfiles: keyfiles, Algorithm: "AES-Twofish"}, VeraCryptKey{Keyfiles: [][]byte{keyfiles[1], keyfiles[0]}}, "VeraCrypt volume AES-Twofish sha512"},
+		{"truecrypt", ewffixture.VeraCrypt{TrueCrypt: true, Algorithm: "Serpent-AES", PRF: "ripemd160"}, VeraCryptKey{TrueCrypt: true}, "TrueCrypt volume Serpent-AES ripemd160"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.v.Password = "secret"
+			tc.key.Password = "secret"
+			if !tc.v.TrueCrypt {
+				tc.v.PIM, tc.key.PIM = 1, 1
+			}
+			img, part := openVeraCrypt(t, tc.v.Build(plain, nil))
+			u, err := img.UnlockVeraCrypt(part, tc.key)
+			if err != nil {
+				t.Fatalf("UnlockVeraCrypt: %v", err)
+			}
+			if u.TypeName != tc.want || u.FileSystem != "FAT16" {
+				t.Errorf("unlocked partition = %q %q, want %q FAT16", u.TypeName, u.FileSystem, tc.want)
+			}
+			if data := readUnlocked(t, img, u, "/FIXTURE.TXT"); string(data) != "fixture\n" {
+				t.Errorf("FIXTURE.TXT = %q", data)
+			}
+			if tc.key.Keyfiles != nil {
+				tc.key.Keyfiles = tc.key.Keyfiles[:1]
+				if _, err := img.UnlockVeraCrypt(part, tc.key); !errors.Is(err, ErrWrongKey) {
+					t.Errorf("missing keyfile: error = %v, want ErrWrongKey", err)
+				}
+			}
+		})
+	}
+}
+
+// A volume whose primary header is damaged opens from the backup header when
+// asked to.
+func TestUnlockVeraCryptBackupHeader(t *testing.T) {
+	plain := fixturePartition(t, "fat16-encase6-zlib.E01")
+	vol := ewffixture.VeraCrypt{Password: "secret", PIM: 1}.Build(plain, nil)
+	vol[100] ^= 0xFF
+	img, part := openVeraCrypt(t, vol)
+	key := VeraCryptKey{Password: "secret", PIM: 1, PRF: "sha512"}
+	if _, err := img.UnlockVeraCrypt(part, key); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("damaged header: error = %v, want ErrWrongKey", err)
+	}
+	key.BackupHeader = true
+	u, err := img.UnlockVeraCrypt(part, key)
+	if err != nil {
+		t.Fatalf("UnlockVeraCrypt with the backup header: %v", err)
+	}
+	if data := readUnlocked(t, img, u, "/FIXTURE.TXT"); string(data) != "fixture\n" {
+		t.Errorf("FIXTURE.TXT = %q", data)
+	}
+}
+
+// TestUnlockVeraCryptFile opens a VeraCrypt file container stored in a
+// SquashFS volume, outer and hidden.
+func TestUnlockVeraCryptFile(t *testing.T) {
+	outer := fixturePartition(t, "fat16-encase6-zlib.E01")
+	hidden := fixturePartition(t, "ext4-encase6-zlib.E01")
+	container := ewffixture.VeraCrypt{
+		Password: "outer",
+		PIM:      1,
+		Hidden:   &ewffixture.VeraCrypt{Password: "hidden", PIM: 1, Algorithm: "Camellia"},
+	}.Build(outer, hidden)
+	root := &ewffixture.SquashFSNode{Type: ewffixture.SquashDir, Mode: 0o755, Children: []*ewffixture.SquashFSNode{
+		{Name: "container.hc", Type: ewffixture.SquashFile, Mode: 0o644, Data: container},
+	}}
+	sq := (&ewffixture.SquashFSImage{}).Build(root)
+	img := openE01(t, ewffixture.WrapDisk(append(sq, make([]byte, 4096)...), ewffixture.Options{Compress: ewffixture.CompressNone, ShortFinalChunk: true}))
+	fs, err := img.OpenFileSystemAt(0, int64(len(sq)), "")
+	if err != nil {
+		t.Fatalf("OpenFileSystemAt: %v", err)
+	}
+	defer fs.Close()
+
+	for _, tc := range []struct{ password, fsType, typeName, path string }{
+		{"outer", "FAT16", "VeraCrypt volume AES sha512 in /container.hc", "/FIXTURE.TXT"},
+		{"hidden", "ext4", "VeraCrypt hidden volume Camellia sha512 in /container.hc", "/fixture.txt"},
+	} {
+		nested, err := fs.UnlockVeraCryptFile("/container.hc", VeraCryptKey{Password: tc.password, PIM: 1, PRF: "sha512"})
+		if err != nil {
+			t.Fatalf("UnlockVeraCryptFile(%s): %v", tc.password, err)
+		}
+		if string(nested.FSType()) != tc.fsType || nested.part.TypeName != tc.typeName || nested.part.Index != -1 {
+			t.Errorf("%s: FSType %q, partition %+v", tc.password, nested.FSType(), nested.part)
+		}
+		if got, err := nested.ReadFile(tc.path); err != nil || string(got) != "fixture\n" {
+			t.Errorf("%s: %s = %q, %v", tc.password, tc.path, got, err)
+		}
+		nested.Close()
+	}
+	if _, err := fs.UnlockVeraCryptFile("/container.hc", VeraCryptKey{Password: "Outer", PIM: 1, PRF: "sha512"}); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong password: error = %v, want ErrWrongKey", err)
+	} else if !strings.Contains(err.Error(), "container.hc") {
+		t.Errorf("wrong password: error %q does not name the file", err)
+	}
+	if _, err := fs.UnlockVeraCryptFile("/missing.hc", VeraCryptKey{Password: "outer"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file: error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,92 @@
+package ewf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/laenix/ewfgo/internal/vss"
+)
+
+// ShadowCopy is one Volume Shadow Copy of an NTFS partition: the volume as
+// it was when the snapshot was taken.
+type ShadowCopy struct {
+	ID             string // shadow copy GUID
+	SetID          string // GUID of the set of snapshots taken together
+	Created        time.Time
+	Context        uint32 // VSS_CTX_* snapshot context
+	Attributes     uint32 // VSS_VOLSNAP_ATTR_* flags
+	Machine        string // originating machine
+	ServiceMachine string
+	// Partition is the snapshot as a read-only virtual partition that
+	// OpenPartition opens with the NTFS handler.
+	Partition PartitionInfo
+}
+
+// ShadowCopies reads the Volume Shadow Copy catalog of an NTFS partition
+// through the VSS volume header at 0x1E00 and returns its snapshots,
+// oldest first. A partition without a VSS volume header, or whose catalog
+// is empty, has none.
+//
+// Each snapshot's Partition reads a 16 KiB block from the first store,
+// its own or a newer snapshot's, that copied the block before it was
+// overwritten, and from the live volume otherwise; a store's block list is
+// read on first use. VSS does not preserve blocks that were free when the
+// snapshot was taken, so the unallocated space of a snapshot shows the
+// live volume's. The partitions are numbered from 0; ScanFileSystems
+// appends every NTFS partition's snapshots after the other partitions.
+func (e *EWFImage) ShadowCopies(part PartitionInfo) ([]ShadowCopy, error) {
+	src, start, err := e.partitionSource(part)
+	if err != nil {
+		return nil, err
+	}
+	set, err := vss.Open(src, start, part.SizeSectors)
+	if err != nil {
+		return nil, fmt.Errorf("partition %d: %w", part.Index, err)
+	}
+	var out []ShadowCopy
+	for i, sn := range set.Catalog().Snapshots {
+		v := set.Volume(i)
+		out = append(out, ShadowCopy{
+			ID:             sn.ID,
+			SetID:          sn.SetID,
+			Created:        sn.Created,
+			Context:        sn.Context,
+			Attributes:     sn.Attributes,
+			Machine:        sn.Machine,
+			ServiceMachine: sn.ServiceMachine,
+			Partition: PartitionInfo{
+				Index:       i,
+				StartSector: part.StartSector,
+				SizeSectors: v.Sectors(),
+				SizeBytes:   v.Sectors() * 512,
+				Type:        "VSS",
+				TypeCode:    part.TypeCode,
+				TypeName:    fmt.Sprintf("Shadow copy %d of partition %d, %s", i+1, part.Index, sn.Created.Format(time.RFC3339)),
+				FileSystem:  detectVolumeFileSystem(v),
+				Virtual:     true,
+				volume:      v,
+			},
+		})
+	}
+	return out, nil
+}
+
+// appendShadowCopies appends the snapshots of every NTFS partition to
+// partitions, continuing their numbering. A catalog that cannot be read
+// adds nothing: the live partition stays listed as it is.
+func (e *EWFImage) appendShadowCopies(partitions []PartitionInfo) []PartitionInfo {
+	for _, p := range partitions {
+		if p.FileSystem != "NTFS" {
+			continue
+		}
+		snaps, err := e.ShadowCopies(p)
+		if err != nil {
+			continue
+		}
+		for _, s := range snaps {
+			s.Partition.Index = len(partitions)
+			partitions = append(partitions, s.Partition)
+		}
+	}
+	return partitions
+}
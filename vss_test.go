@@ -0,0 +1,132 @@
+package ewf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/laenix/ewfgo/internal/ewffixture"
+)
+
+// buildShadowCopyDisk wraps the NTFS fixture into a disk whose volume has
+// two shadow copies: the first with fixture.txt as mkntfs wrote it, the
+// second after it was rewritten as "changed\n". Since then fixture.txt was
+// deleted and a byte of free space at 1 MiB was written, so only the
+// second store copied that block. It returns the snapshot images and the
+// disk's volume with the VSS structures, and the volume before them.
+func buildShadowCopyDisk(t *testing.T) (snaps [][]byte, vol, current []byte) {
+	t.Helper()
+	plain := fixturePartition(t, "ntfs-encase6-zlib.E01")
+	at := bytes.Index(plain, []byte("fixture\n"))
+	if at < 0 {
+		t.Fatal("fixture.txt content not found in the volume")
+	}
+	changed := append([]byte(nil), plain...)
+	copy(changed[at:], "changed\n")
+	current = append([]byte(nil), changed...)
+	rec := at &^ 1023 // MFT record of fixture.txt
+	current[rec+0x16] &^= 0x01
+	current[0x100000] = 0xAA
+
+	created := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	snaps = [][]byte{plain, changed}
+	vol = ewffixture.VSS{Snapshots: []ewffixture.VSSSnapshot{
+		{Image: plain, Created: created, Machine: "WS01.corp.example"},
+		{Image: changed, Created: created.Add(24 * time.Hour), Machine: "WS01.corp.example"},
+	}}.Build(current)
+	return snaps, vol, current
+}
+
+func TestShadowCopies(t *testing.T) {
+	snaps, vol, current := buildShadowCopyDisk(t)
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(vol, 0x07, 2048), ewffixture.Options{Compress: ewffixture.CompressNone}))
+
+	parts, err := img.ScanFileSystems()
+	if err != nil {
+		t.Fatalf("ScanFileSystems: %v", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("ScanFileSystems = %d partitions, want the volume and 2 shadow copies", len(parts))
+	}
+	for i, p := range parts[1:] {
+		if p.Index != i+1 || p.Type != "VSS" || !p.Virtual || p.FileSystem != "NTFS" || p.SizeBytes != uint64(len(vol)) {
+			t.Errorf("shadow copy partition %d = %+v", i, p)
+		}
+	}
+
+	copies, err := img.ShadowCopies(parts[0])
+	if err != nil {
+		t.Fatalf("ShadowCopies: %v", err)
+	}
+	if len(copies) != 2 {
+		t.Fatalf("ShadowCopies = %d snapshots, want 2", len(copies))
+	}
+	for i, c := range copies {
+		want := time.Date(2026, 10, 1+i, 9, 30, 0, 0, time.UTC)
+		if !c.Created.Equal(want) || c.Machine != "WS01.corp.example" || c.ID == "" || c.SetID == "" || c.Partition.Index != i {
+			t.Errorf("snapshot %d = %+v, want created %v", i, c, want)
+		}
+		// Outside the sectors VSS itself wrote, the snapshot reads exactly
+		// as the volume was.
+		got, err := c.Partition.volume.ReadSectors(0, uint64(len(vol)/512))
+		if err != nil {
+			t.Fatalf("snapshot %d: ReadSectors: %v", i, err)
+		}
+		for off := 0; off < len(vol); off += 512 {
+			if bytes.Equal(vol[off:off+512], current[off:off+512]) && !bytes.Equal(got[off:off+512], snaps[i][off:off+512]) {
+				t.Fatalf("snapshot %d differs from its image at offset %#x", i, off)
+			}
+		}
+	}
+
+	for index, want := range map[int]string{1: "fixture\n", 2: "changed\n"} {
+		fs, err := img.OpenFileSystem(index)
+		if err != nil {
+			t.Fatalf("OpenFileSystem(%d): %v", index, err)
+		}
+		got, err := fs.ReadFile("/fixture.txt")
+		fs.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("shadow copy %d: fixture.txt = %q, %v, want %q", index, got, err, want)
+		}
+	}
+	live, err := img.OpenFileSystem(0)
+	if err != nil {
+		t.Fatalf("OpenFileSystem(0): %v", err)
+	}
+	defer live.Close()
+	if _, err := live.ReadFile("/fixture.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("live fixture.txt = %v, want ErrNotFound", err)
+	}
+}
+
+// TestShadowCopiesAbsentOrDamaged: a volume without VSS has no shadow
+// copies, and a damaged catalog is an error for ShadowCopies but leaves
+// ScanFileSystems listing the volume.
+func TestShadowCopiesAbsentOrDamaged(t *testing.T) {
+	src, err := Open("testdata/e01/ntfs-encase6-zlib.E01")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	parts, err := src.ScanFileSystems()
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("ScanFileSystems = %d partitions, %v", len(parts), err)
+	}
+	if copies, err := src.ShadowCopies(parts[0]); err != nil || len(copies) != 0 {
+		t.Errorf("ShadowCopies without VSS = %+v, %v", copies, err)
+	}
+
+	_, vol, _ := buildShadowCopyDisk(t)
+	catalog := len(vol)&^0x3FFF - 2*0x4000
+	vol[catalog] ^= 0xFF
+	img := openE01(t, ewffixture.WrapDisk(ewffixture.WrapMBRDisk(vol, 0x07, 2048), ewffixture.Options{Compress: ewffixture.CompressNone}))
+	parts, err = img.ScanFileSystems()
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("ScanFileSystems with a damaged catalog = %d partitions, %v", len(parts), err)
+	}
+	if _, err := img.ShadowCopies(parts[0]); err == nil {
+		t.Error("ShadowCopies with a damaged catalog succeeded")
+	}
+}